@@ -0,0 +1,154 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package audit writes a structured log
+// of queries and administrative actions
+// as JSON lines.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionQuery         = "query"
+	ActionCancelQuery   = "cancel-query"
+	ActionListDatabases = "list-databases"
+	ActionListTables    = "list-tables"
+	ActionCreateTable   = "create-table"
+	ActionCreateView    = "create-view"
+	ActionDropView      = "drop-view"
+	ActionSync          = "sync"
+)
+
+// Event is one entry of the audit log.
+// Fields that are not relevant to
+// the action are omitted.
+type Event struct {
+	// Time is the time at which the action
+	// completed. Log sets Time to the
+	// current time if it is zero.
+	Time time.Time `json:"time"`
+	// Action is the action performed
+	// (one of the Action constants).
+	Action string `json:"action"`
+	// Tenant is the ID of the tenant
+	// on behalf of which the action
+	// was performed.
+	Tenant  string `json:"tenant,omitempty"`
+	QueryID string `json:"query_id,omitempty"`
+	// Query is the redacted text of
+	// the query (see expr.Query.Redacted).
+	Query    string `json:"query,omitempty"`
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table,omitempty"`
+	// Tables are the tables and views
+	// referenced by the query as "db.table".
+	Tables       []string `json:"tables,omitempty"`
+	BytesScanned int64    `json:"bytes_scanned,omitempty"`
+	// Duration is the duration
+	// of the action in seconds.
+	Duration float64 `json:"duration,omitempty"`
+	// Status is the HTTP status
+	// of the response to the request.
+	Status int `json:"status,omitempty"`
+	// Error is the error that caused
+	// the action to fail, if known.
+	Error string `json:"error,omitempty"`
+	// Client is the address of the client
+	// that made the request.
+	Client string `json:"client,omitempty"`
+}
+
+// Logger writes events to an io.Writer
+// as JSON lines. Logger is safe to use
+// from multiple goroutines. A nil *Logger
+// discards every event.
+type Logger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// New returns a Logger that writes to w.
+// Each event is written with exactly one
+// call to w.Write.
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Open opens an audit log destination:
+//
+//   - "-" is the standard output,
+//   - a path ending in "/" or the path of
+//     an existing directory is a Dir with the
+//     default rotation settings, and
+//   - any other path is a file that events
+//     are appended to.
+func Open(dest string) (*Logger, error) {
+	if dest == "-" {
+		return New(os.Stdout), nil
+	}
+	if strings.HasSuffix(dest, "/") {
+		return New(&Dir{Path: dest}), nil
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		return New(&Dir{Path: dest}), nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return New(f), nil
+}
+
+// Log writes an event to the log.
+func (l *Logger) Log(e *Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.w.Write(buf)
+	return err
+}
+
+// Close closes the underlying io.Writer
+// if it is an io.Closer other than the
+// standard output.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if c, ok := l.w.(io.Closer); ok && l.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, buf []byte) []Event {
+	t.Helper()
+	var out []Event
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		var e Event
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %s", s.Text(), err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+	when := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	want := []Event{{
+		Time:         when,
+		Action:       ActionQuery,
+		Tenant:       "tenant0",
+		QueryID:      "0ba6c0b4-3b7c-4b5e-8b0c-9b0f0a5d1e7c",
+		Query:        "SELECT * FROM db.x WHERE y = 'REDACTED'",
+		Tables:       []string{"db.x"},
+		BytesScanned: 1024,
+		Duration:     0.5,
+		Status:       200,
+		Client:       "127.0.0.1:1234",
+	}, {
+		Time:     when,
+		Action:   ActionSync,
+		Tenant:   "tenant0",
+		Database: "db",
+		Table:    "x",
+		Error:    "failed",
+	}}
+	for i := range want {
+		e := want[i]
+		if err := l.Log(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readEvents(t, buf.Bytes())
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if strings.Contains(buf.String(), "bytes_scanned\":0") {
+		t.Error("zero fields not omitted")
+	}
+
+	// a zero time is filled in
+	buf.Reset()
+	before := time.Now()
+	if err := l.Log(&Event{Action: ActionListDatabases}); err != nil {
+		t.Fatal(err)
+	}
+	got = readEvents(t, buf.Bytes())
+	if len(got) != 1 || got[0].Time.Before(before.Truncate(time.Second)) {
+		t.Fatalf("unexpected events %+v", got)
+	}
+
+	// a nil logger discards events
+	var nl *Logger
+	if err := nl.Log(&Event{Action: ActionQuery}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lineWriter fails if any write
+// is not exactly one line
+type lineWriter struct {
+	t     *testing.T
+	lock  sync.Mutex
+	lines int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, '\n') != len(p)-1 {
+		w.t.Errorf("write %q is not one line", p)
+	}
+	w.lock.Lock()
+	w.lines++
+	w.lock.Unlock()
+	return len(p), nil
+}
+
+func TestLoggerConcurrent(t *testing.T) {
+	w := &lineWriter{t: t}
+	l := New(w)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Log(&Event{Action: ActionQuery, Tenant: "t"})
+			}
+		}()
+	}
+	wg.Wait()
+	if w.lines != 800 {
+		t.Fatalf("%d lines written", w.lines)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		l, err := Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(&Event{Action: ActionCreateTable, Table: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second Open appends
+	if got := readEvents(t, buf); len(got) != 2 {
+		t.Fatalf("got %d events, want 2", len(got))
+	}
+	l, err := Open(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.w.(*Dir); !ok {
+		t.Fatalf("Open(directory) writes to %T", l.w)
+	}
+}
+
+func TestDir(t *testing.T) {
+	clock := time.Date(2023, 6, 1, 23, 59, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	d := &Dir{
+		Path:     filepath.Join(t.TempDir(), "audit"),
+		MaxSize:  100,
+		MaxFiles: 3,
+	}
+	l := New(d)
+	defer l.Close()
+	log := func() {
+		t.Helper()
+		err := l.Log(&Event{Action: ActionQuery, Query: strings.Repeat("x", 20)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(time.Millisecond)
+	}
+	files := func() []string {
+		t.Helper()
+		lst, err := d.Files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lst
+	}
+	// each event is about 80 bytes, so
+	// no two events fit in one file
+	log()
+	if n := len(files()); n != 1 {
+		t.Fatalf("%d files after one event", n)
+	}
+	log()
+	if n := len(files()); n != 2 {
+		t.Fatalf("%d files after two events", n)
+	}
+	log()
+	lst := files()
+	if len(lst) != 3 {
+		t.Fatalf("%d files after three events", len(lst))
+	}
+	// raising MaxSize keeps appending
+	// to the current file
+	d.MaxSize = 1000
+	log()
+	lst = files()
+	if len(lst) != 3 {
+		t.Fatalf("%d files after four events", len(lst))
+	}
+	buf, err := os.ReadFile(lst[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(readEvents(t, buf)); n != 2 {
+		t.Fatalf("%d events in %s", n, lst[2])
+	}
+	// a new day starts a new file
+	// and the oldest file is removed
+	clock = clock.Add(time.Minute)
+	log()
+	after := files()
+	if len(after) != 3 {
+		t.Fatalf("%d files after rotation", len(after))
+	}
+	if after[0] != lst[1] || after[1] != lst[2] {
+		t.Fatalf("unexpected files %v (before: %v)", after, lst)
+	}
+	if !strings.HasSuffix(after[2], "audit-20230602T000000.004000000Z.jsonl") {
+		t.Fatalf("unexpected file name %s", after[2])
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DefaultMaxSize is the default
+// maximum size of the files of a Dir.
+const DefaultMaxSize = 64 * 1024 * 1024
+
+// now is the clock used by Dir
+var now = time.Now
+
+// Dir is an io.WriteCloser that writes to
+// files in a local directory, starting a new
+// file every day (UTC) and whenever the current
+// file would grow beyond MaxSize bytes.
+// The files are named audit-{time}.jsonl, where
+// {time} is the time at which the file was created,
+// so they sort in chronological order.
+//
+// Dir is not safe to use from multiple
+// goroutines; Logger serializes writes.
+type Dir struct {
+	// Path is the path of the directory,
+	// which is created if it doesn't exist.
+	Path string
+	// MaxSize is the maximum size of a file.
+	// If MaxSize is zero, DefaultMaxSize is used.
+	MaxSize int64
+	// MaxFiles, if positive, is the maximum
+	// number of files kept in the directory;
+	// the oldest files are removed when a new
+	// file is created.
+	MaxFiles int
+
+	f    *os.File
+	size int64
+	day  time.Time
+}
+
+const (
+	dirPrefix  = "audit-"
+	dirSuffix  = ".jsonl"
+	timeLayout = "20060102T150405.000000000Z"
+)
+
+func (d *Dir) maxSize() int64 {
+	if d.MaxSize > 0 {
+		return d.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+// Write implements io.Writer.Write.
+// The data is never split across files.
+func (d *Dir) Write(p []byte) (int, error) {
+	t := now().UTC()
+	day := t.Truncate(24 * time.Hour)
+	if d.f == nil || !day.Equal(d.day) ||
+		(d.size > 0 && d.size+int64(len(p)) > d.maxSize()) {
+		if err := d.rotate(t); err != nil {
+			return 0, err
+		}
+		d.day = day
+	}
+	n, err := d.f.Write(p)
+	d.size += int64(n)
+	return n, err
+}
+
+// rotate closes the current file (if any)
+// and creates a new file at time t
+func (d *Dir) rotate(t time.Time) error {
+	if d.f != nil {
+		err := d.f.Close()
+		d.f = nil
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(d.Path, 0750); err != nil {
+		return err
+	}
+	name := filepath.Join(d.Path, dirPrefix+t.Format(timeLayout)+dirSuffix)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	d.f = f
+	d.size = 0
+	return d.prune()
+}
+
+// prune removes the oldest files
+// in excess of d.MaxFiles
+func (d *Dir) prune() error {
+	if d.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := d.Files()
+	if err != nil {
+		return err
+	}
+	for len(files) > d.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// Files returns the paths of the
+// files in the directory, oldest first.
+func (d *Dir) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(d.Path, dirPrefix+"*"+dirSuffix))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// Close implements io.Closer.Close.
+func (d *Dir) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f = nil
+	return err
+}
@@ -43,7 +43,9 @@ type Provider interface {
 // given specification.
 //
 // It uses an authorization endpoint when a
-// http(s):// prefix is detected and otherwise
+// http(s):// prefix is detected, verifies JWTs
+// as configured by the JWTConfig file given after
+// a jwt:// prefix (see FromJWTConfig) and otherwise
 // the specification is interpreted as a file name.
 func Parse(spec string) (Provider, error) {
 	if spec == "" {
@@ -56,6 +58,9 @@ func Parse(spec string) (Provider, error) {
 	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
 		return FromEndPoint(spec)
 	}
+	if strings.HasPrefix(spec, "jwt://") {
+		return FromJWTConfig(spec)
+	}
 	return FromFile(spec)
 }
 
@@ -0,0 +1,709 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SnellerInc/sneller/aws"
+	"github.com/SnellerInc/sneller/db"
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+)
+
+var _ Provider = &JWTBearer{}
+
+// JWTConfig is the configuration of a JWTBearer.
+//
+// The Tenant and Root fields are templates
+// in which each "{claim}" is replaced with the
+// value of the claim with the given name, so,
+// for example, a Root of "s3://bucket/{org}"
+// roots the storage of each tenant in a prefix
+// named after the "org" claim of its tokens.
+// The values of the claims used in templates
+// must be non-empty strings without slashes.
+type JWTConfig struct {
+	// Issuer is the required "iss" claim.
+	Issuer string `json:"issuer"`
+	// Audience is the value that must
+	// be present in the "aud" claim.
+	Audience string `json:"audience"`
+	// JWKS is the path or the http(s) URL
+	// of the JSON Web Key Set used to verify
+	// the signatures of tokens.
+	JWKS string `json:"jwks"`
+	// Tenant is the template of the tenant ID.
+	Tenant string `json:"tenant"`
+	// Root is the template of the URL
+	// of the root of the tenant storage
+	// (see S3BearerIdentity.Bucket).
+	Root string `json:"root"`
+	// IndexKey is the key used to
+	// sign the indexes of tenants.
+	IndexKey []byte `json:"index_key"`
+	// Region is the region of the bucket.
+	// If Region is empty, the region is
+	// determined from the environment.
+	Region string `json:"region,omitempty"`
+	// Credentials are the credentials used
+	// to access the tenant storage. If Credentials
+	// is nil, then the credentials are determined
+	// from the environment (see aws.AmbientCreds).
+	Credentials *S3BearerCredentials `json:"credentials,omitempty"`
+	// Limits maps claims to the
+	// limits of each tenant.
+	Limits JWTLimits `json:"limits"`
+}
+
+// JWTLimits maps claims to the fields
+// of db.TenantConfig.
+type JWTLimits struct {
+	MaxScanBytes         JWTLimit `json:"max_scan_bytes"`
+	MaxConcurrentQueries JWTLimit `json:"max_concurrent_queries"`
+	MaxBytesInFlight     JWTLimit `json:"max_bytes_in_flight"`
+	Weight               JWTLimit `json:"weight"`
+}
+
+// JWTLimit maps a numeric claim
+// to a limit of a tenant.
+type JWTLimit struct {
+	// Claim is the name of the claim
+	// that holds the limit.
+	Claim string `json:"claim,omitempty"`
+	// Default is the limit used when Claim is
+	// empty or the token doesn't have the claim.
+	Default uint64 `json:"default,omitempty"`
+}
+
+func (l *JWTLimit) get(claims map[string]any) (uint64, error) {
+	if l.Claim == "" {
+		return l.Default, nil
+	}
+	v, ok := claims[l.Claim]
+	if !ok {
+		return l.Default, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("claim %q is not a number", l.Claim)
+	}
+	u, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("claim %q: %w", l.Claim, err)
+	}
+	return u, nil
+}
+
+const (
+	// jwksRefresh is the interval at
+	// which the JWKS is fetched again
+	jwksRefresh = 15 * time.Minute
+	// jwksTimeout is the timeout of the
+	// initial fetch of the JWKS
+	jwksTimeout = 30 * time.Second
+	// maxJWKSSize is the maximum size
+	// of a JWKS fetched over HTTP
+	maxJWKSSize = 1024 * 1024
+	// clockSkew is the allowed difference
+	// between the clocks of the issuer and
+	// the clock of the local machine
+	clockSkew = time.Minute
+)
+
+// jwksMinRefresh is the minimum interval
+// between the fetches of the JWKS caused by
+// tokens signed with unknown keys
+var jwksMinRefresh = 30 * time.Second
+
+// JWTBearer is a Provider that authorizes
+// JSON Web Tokens signed with RS256 or ES256
+// by the keys in a JWKS (see JWTConfig.JWKS).
+//
+// The JWKS is cached and fetched again periodically
+// and when a token is signed by a key that isn't in
+// the cached JWKS, so the signing keys can be rotated.
+type JWTBearer struct {
+	conf  JWTConfig
+	creds S3BearerCredentials
+
+	// Client is the client used to fetch the
+	// JWKS over HTTP. If Client is nil, then
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	lock    sync.Mutex
+	keys    []jwk
+	fetched time.Time
+	// pending is the fetch of the JWKS
+	// in progress, if any; the lock is
+	// not held while fetching the JWKS
+	pending *jwksFetch
+}
+
+// jwksFetch is a fetch of the JWKS
+// shared by the concurrent lookups
+// that need the JWKS to be fetched
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// FromJWTConfig creates a JWTBearer from the
+// JWTConfig in the JSON file with the given name.
+func FromJWTConfig(fileName string) (Provider, error) {
+	fileName = strings.TrimPrefix(fileName, "jwt://")
+	buf, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf := new(JWTConfig)
+	err = json.Unmarshal(buf, conf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return NewJWTBearer(conf)
+}
+
+// NewJWTBearer checks the configuration
+// and creates a JWTBearer that uses it.
+// The JWKS is fetched before NewJWTBearer
+// returns, so an invalid JWKS is reported
+// immediately.
+func NewJWTBearer(conf *JWTConfig) (*JWTBearer, error) {
+	switch {
+	case conf.Issuer == "":
+		return nil, errors.New("JWT config: missing issuer")
+	case conf.Audience == "":
+		return nil, errors.New("JWT config: missing audience")
+	case conf.JWKS == "":
+		return nil, errors.New("JWT config: missing jwks")
+	case conf.Tenant == "":
+		return nil, errors.New("JWT config: missing tenant")
+	case conf.Root == "":
+		return nil, errors.New("JWT config: missing root")
+	case len(conf.IndexKey) != blockfmt.KeyLength:
+		return nil, fmt.Errorf("JWT config: index_key should be %d bytes", blockfmt.KeyLength)
+	}
+	for _, tmpl := range []string{conf.Tenant, conf.Root} {
+		if _, err := expand(tmpl, nil); err != nil {
+			return nil, fmt.Errorf("JWT config: %w", err)
+		}
+	}
+	j := &JWTBearer{conf: *conf}
+	if conf.Credentials != nil {
+		j.creds = *conf.Credentials
+	} else {
+		id, secret, region, token, err := aws.AmbientCreds()
+		if err != nil {
+			return nil, err
+		}
+		if j.conf.Region == "" {
+			j.conf.Region = region
+		}
+		j.creds = S3BearerCredentials{
+			BaseURI:         aws.S3EndPoint(j.conf.Region),
+			AccessKeyID:     id,
+			SecretAccessKey: secret,
+			SessionToken:    token,
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+	err := j.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *JWTBearer) client() *http.Client {
+	if j.Client == nil {
+		return http.DefaultClient
+	}
+	return j.Client
+}
+
+// Authorize implements Provider.Authorize
+//
+// The token must be a JWT signed by one of the keys
+// in the JWKS with the configured issuer and audience
+// that hasn't expired. The tenant is determined by
+// the claims of the token (see JWTConfig).
+func (j *JWTBearer) Authorize(ctx context.Context, token string) (db.Tenant, error) {
+	claims, err := j.verify(ctx, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := j.Identity(claims)
+	if err != nil {
+		return nil, err
+	}
+	return id.Tenant(ctx)
+}
+
+// Identity maps the claims of a verified
+// token to the identity of a tenant.
+func (j *JWTBearer) Identity(claims map[string]any) (*S3BearerIdentity, error) {
+	id, err := expand(j.conf.Tenant, claims)
+	if err != nil {
+		return nil, fmt.Errorf("tenant: %w", err)
+	}
+	root, err := expand(j.conf.Root, claims)
+	if err != nil {
+		return nil, fmt.Errorf("root: %w", err)
+	}
+	ident := &S3BearerIdentity{
+		ID:          id,
+		Region:      j.conf.Region,
+		IndexKey:    j.conf.IndexKey,
+		Bucket:      root,
+		Credentials: j.creds,
+	}
+	lim := &j.conf.Limits
+	ident.MaxScanBytes, err = lim.MaxScanBytes.get(claims)
+	if err != nil {
+		return nil, err
+	}
+	ident.MaxBytesInFlight, err = lim.MaxBytesInFlight.get(claims)
+	if err != nil {
+		return nil, err
+	}
+	n, err := lim.MaxConcurrentQueries.get(claims)
+	if err != nil {
+		return nil, err
+	}
+	ident.MaxConcurrentQueries = int(n)
+	n, err = lim.Weight.get(claims)
+	if err != nil {
+		return nil, err
+	}
+	ident.Weight = int(n)
+	return ident, nil
+}
+
+// expand replaces each {claim} in tmpl
+// with the value of the claim in claims;
+// if claims is nil, expand only checks
+// the syntax of tmpl
+func expand(tmpl string, claims map[string]any) (string, error) {
+	var out strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			if strings.IndexByte(tmpl, '}') >= 0 {
+				return "", fmt.Errorf("unbalanced '}' in template")
+			}
+			out.WriteString(tmpl)
+			return out.String(), nil
+		}
+		out.WriteString(tmpl[:i])
+		tmpl = tmpl[i+1:]
+		j := strings.IndexByte(tmpl, '}')
+		if j <= 0 || strings.IndexByte(tmpl[:j], '{') >= 0 {
+			return "", fmt.Errorf("unbalanced '{' or empty claim in template")
+		}
+		name := tmpl[:j]
+		tmpl = tmpl[j+1:]
+		if claims == nil {
+			continue
+		}
+		v, ok := claims[name]
+		if !ok {
+			return "", fmt.Errorf("missing claim %q", name)
+		}
+		var str string
+		switch v := v.(type) {
+		case string:
+			str = v
+		case json.Number:
+			str = v.String()
+		default:
+			return "", fmt.Errorf("claim %q is not a string", name)
+		}
+		if str == "" || str == "." || str == ".." || strings.ContainsAny(str, "/{}") {
+			return "", fmt.Errorf("claim %q has an invalid value %q", name, str)
+		}
+		out.WriteString(str)
+	}
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+func decodeSegment(seg string, v any) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// verify verifies the signature and
+// the claims of token and returns the claims
+func (j *JWTBearer) verify(ctx context.Context, token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWT: malformed token")
+	}
+	var hdr jwtHeader
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("JWT: bad header: %w", err)
+	}
+	if len(hdr.Crit) > 0 {
+		return nil, fmt.Errorf("JWT: unsupported critical headers %q", hdr.Crit)
+	}
+	if hdr.Alg != "RS256" && hdr.Alg != "ES256" {
+		return nil, fmt.Errorf("JWT: unsupported algorithm %q", hdr.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("JWT: bad signature: %w", err)
+	}
+	keys, err := j.lookup(ctx, hdr.Kid, hdr.Alg)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	ok := false
+	for i := range keys {
+		if keys[i].verify(hash[:], sig) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, errors.New("JWT: invalid signature")
+	}
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("JWT: bad claims: %w", err)
+	}
+	if err := j.check(claims, now); err != nil {
+		return nil, fmt.Errorf("JWT: %w", err)
+	}
+	return claims, nil
+}
+
+func timeClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %q is not a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %q: %w", name, err)
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// check checks the registered claims
+func (j *JWTBearer) check(claims map[string]any, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != j.conf.Issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == j.conf.Audience
+	case []any:
+		for i := range aud {
+			if s, _ := aud[i].(string); s == j.conf.Audience {
+				audOK = true
+				break
+			}
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("audience %q not allowed", claims["aud"])
+	}
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("missing exp claim")
+	}
+	if now.After(exp.Add(clockSkew)) {
+		return fmt.Errorf("token expired at %s", exp)
+	}
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Before(nbf.Add(-clockSkew)) {
+		return fmt.Errorf("token not valid before %s", nbf)
+	}
+	return nil
+}
+
+// jwk is a public key from a JWKS
+type jwk struct {
+	kid string
+	alg string // "RS256" or "ES256"
+	key crypto.PublicKey
+}
+
+func (k *jwk) verify(hash, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash, sig) == nil
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, hash, r, s)
+	}
+	return false
+}
+
+// lookup returns the keys that may have
+// signed a token with the given kid and alg,
+// fetching the JWKS again if necessary
+func (j *JWTBearer) lookup(ctx context.Context, kid, alg string) ([]jwk, error) {
+	j.lock.Lock()
+	stale := time.Since(j.fetched) >= jwksRefresh
+	j.lock.Unlock()
+	if stale {
+		// keep using the cached keys on error
+		j.fetch(ctx)
+	}
+	keys, fetched := j.match(kid, alg)
+	if len(keys) == 0 && time.Since(fetched) >= jwksMinRefresh {
+		// the keys may have been rotated
+		if err := j.fetch(ctx); err != nil {
+			return nil, err
+		}
+		keys, _ = j.match(kid, alg)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWT: no key for kid %q and alg %s", kid, alg)
+	}
+	return keys, nil
+}
+
+// match returns the cached keys matching
+// kid and alg and the time the keys were fetched
+func (j *JWTBearer) match(kid, alg string) ([]jwk, time.Time) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var out []jwk
+	for i := range j.keys {
+		if j.keys[i].alg == alg && (kid == "" || j.keys[i].kid == kid) {
+			out = append(out, j.keys[i])
+		}
+	}
+	return out, j.fetched
+}
+
+// fetch fetches the JWKS and replaces the
+// cached keys; if the JWKS is already being
+// fetched, fetch waits for that fetch instead
+func (j *JWTBearer) fetch(ctx context.Context) error {
+	j.lock.Lock()
+	if p := j.pending; p != nil {
+		j.lock.Unlock()
+		select {
+		case <-p.done:
+			return p.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p := &jwksFetch{done: make(chan struct{})}
+	j.pending = p
+	j.lock.Unlock()
+
+	keys, err := j.load(ctx)
+	j.lock.Lock()
+	if err == nil {
+		j.keys = keys
+		j.fetched = time.Now()
+	}
+	j.pending = nil
+	j.lock.Unlock()
+	p.err = err
+	close(p.done)
+	return err
+}
+
+func (j *JWTBearer) load(ctx context.Context) ([]jwk, error) {
+	buf, err := j.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	keys, err := parseJWKS(buf)
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
+	return keys, nil
+}
+
+func (j *JWTBearer) read(ctx context.Context) ([]byte, error) {
+	uri := j.conf.JWKS
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return os.ReadFile(strings.TrimPrefix(uri, "file://"))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := j.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", uri, res.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxJWKSSize {
+		return nil, fmt.Errorf("%s: JWKS larger than %d bytes", uri, maxJWKSSize)
+	}
+	return buf, nil
+}
+
+// jwkJSON is the JSON representation of a JWK
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the RS256 and ES256
+// signing keys in a JWKS; other keys are ignored
+func parseJWKS(buf []byte) ([]jwk, error) {
+	var set struct {
+		Keys []jwkJSON `json:"keys"`
+	}
+	err := json.Unmarshal(buf, &set)
+	if err != nil {
+		return nil, err
+	}
+	var keys []jwk
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			if k.Alg != "" && k.Alg != "RS256" {
+				continue
+			}
+			pub, err := rsaKey(k)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			keys = append(keys, jwk{kid: k.Kid, alg: "RS256", key: pub})
+		case "EC":
+			if (k.Alg != "" && k.Alg != "ES256") || k.Crv != "P-256" {
+				continue
+			}
+			pub, err := ecKey(k)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			keys = append(keys, jwk{kid: k.Kid, alg: "ES256", key: pub})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RS256 or ES256 keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(k *jwkJSON) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if pub.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key size %d is too small", pub.N.BitLen())
+	}
+	return pub, nil
+}
+
+func ecKey(k *jwkJSON) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if len(x) != 32 || len(y) != 32 || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("invalid P-256 point")
+	}
+	return pub, nil
+}
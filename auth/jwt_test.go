@@ -0,0 +1,425 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/db"
+)
+
+type testKey struct {
+	kid string
+	key crypto.Signer
+}
+
+func rsaTestKey(t *testing.T, kid string) *testKey {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, key: k}
+}
+
+func ecTestKey(t *testing.T, kid string) *testKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, key: k}
+}
+
+func b64(buf []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (k *testKey) alg() string {
+	if _, ok := k.key.(*rsa.PrivateKey); ok {
+		return "RS256"
+	}
+	return "ES256"
+}
+
+func (k *testKey) jwk() map[string]string {
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": k.kid,
+			"use": "sig",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		return map[string]string{
+			"kty": "EC",
+			"kid": k.kid,
+			"crv": "P-256",
+			"x":   b64(key.X.FillBytes(make([]byte, 32))),
+			"y":   b64(key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	panic("unexpected key type")
+}
+
+func (k *testKey) sign(t *testing.T, alg string, claims map[string]any) string {
+	hdr, err := json.Marshal(map[string]string{"alg": alg, "kid": k.kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := b64(hdr) + "." + b64(body)
+	hash := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func jwks(t *testing.T, keys ...*testKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	buf, err := json.Marshal(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func testJWTConfig(jwks string) *JWTConfig {
+	return &JWTConfig{
+		Issuer:   "https://idp.example.com/",
+		Audience: "sneller",
+		JWKS:     jwks,
+		Tenant:   "{org}",
+		Root:     "s3://sneller-data/tenants/{org}",
+		IndexKey: make([]byte, 32),
+		Region:   "us-east-1",
+		Credentials: &S3BearerCredentials{
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+		},
+		Limits: JWTLimits{
+			MaxScanBytes:         JWTLimit{Claim: "max_scan", Default: 1000},
+			MaxConcurrentQueries: JWTLimit{Default: 4},
+			Weight:               JWTLimit{Claim: "weight"},
+		},
+	}
+}
+
+func claims(mod func(m map[string]any)) map[string]any {
+	m := map[string]any{
+		"iss": "https://idp.example.com/",
+		"aud": "sneller",
+		"sub": "user@example.com",
+		"org": "acme",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if mod != nil {
+		mod(m)
+	}
+	return m
+}
+
+func TestJWTAuthorize(t *testing.T) {
+	rs, es := rsaTestKey(t, "rs"), ecTestKey(t, "es")
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "jwks.json")
+	err := os.WriteFile(keyfile, jwks(t, rs, es), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJWTBearer(testJWTConfig(keyfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, k := range []*testKey{rs, es} {
+		tok := k.sign(t, k.alg(), claims(func(m map[string]any) {
+			m["max_scan"] = 5000
+			m["weight"] = 3
+		}))
+		tn, err := j.Authorize(ctx, tok)
+		if err != nil {
+			t.Fatalf("%s: %s", k.alg(), err)
+		}
+		if tn.ID() != "acme" {
+			t.Errorf("tenant ID %q", tn.ID())
+		}
+		root, err := tn.Root()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s3fs, ok := root.(*db.S3FS)
+		if !ok {
+			t.Fatalf("root is %T", root)
+		}
+		if s3fs.Bucket != "sneller-data" || s3fs.Root != "tenants/acme" {
+			t.Errorf("bucket %q root %q", s3fs.Bucket, s3fs.Root)
+		}
+		cfg := tn.(db.TenantConfigurable).Config()
+		want := db.TenantConfig{
+			MaxScanBytes:         5000,
+			MaxConcurrentQueries: 4,
+			Weight:               3,
+		}
+		if *cfg != want {
+			t.Errorf("got config %+v, want %+v", *cfg, want)
+		}
+	}
+
+	// the default limits are used
+	// when the claims are missing
+	tn, err := j.Authorize(ctx, rs.sign(t, "RS256", claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := tn.(db.TenantConfigurable).Config(); cfg.MaxScanBytes != 1000 || cfg.Weight != 0 {
+		t.Errorf("got config %+v", *cfg)
+	}
+	// "aud" may be a list
+	_, err = j.Authorize(ctx, es.sign(t, "ES256", claims(func(m map[string]any) {
+		m["aud"] = []string{"other", "sneller"}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := rsaTestKey(t, "rs")
+	valid := rs.sign(t, "RS256", claims(nil))
+	parts := strings.Split(valid, ".")
+	tampered, _ := json.Marshal(claims(func(m map[string]any) { m["org"] = "evil" }))
+	unsigned, _ := json.Marshal(map[string]string{"alg": "none"})
+	for _, tc := range []struct {
+		name, token, err string
+	}{
+		{"issuer", rs.sign(t, "RS256", claims(func(m map[string]any) { m["iss"] = "https://evil.example.com/" })), "issuer"},
+		{"audience", rs.sign(t, "RS256", claims(func(m map[string]any) { m["aud"] = []string{"other"} })), "audience"},
+		{"expired", rs.sign(t, "RS256", claims(func(m map[string]any) { m["exp"] = time.Now().Add(-time.Hour).Unix() })), "expired"},
+		{"no expiry", rs.sign(t, "RS256", claims(func(m map[string]any) { delete(m, "exp") })), "exp"},
+		{"not before", rs.sign(t, "RS256", claims(func(m map[string]any) { m["nbf"] = time.Now().Add(time.Hour).Unix() })), "not valid before"},
+		{"wrong key", other.sign(t, "RS256", claims(nil)), "invalid signature"},
+		{"wrong alg", rs.sign(t, "ES256", claims(nil)), "no key"},
+		{"HS256", rs.sign(t, "HS256", claims(nil)), "unsupported algorithm"},
+		{"none", b64(unsigned) + "." + parts[1] + ".", "unsupported algorithm"},
+		{"tampered", parts[0] + "." + b64(tampered) + "." + parts[2], "invalid signature"},
+		{"malformed", "not-a-token", "malformed"},
+		{"bad claim", rs.sign(t, "RS256", claims(func(m map[string]any) { m["org"] = "../other" })), "invalid value"},
+		{"missing claim", rs.sign(t, "RS256", claims(func(m map[string]any) { delete(m, "org") })), "missing claim"},
+		{"bad limit", rs.sign(t, "RS256", claims(func(m map[string]any) { m["weight"] = "heavy" })), "not a number"},
+	} {
+		_, err := j.Authorize(ctx, tc.token)
+		if err == nil {
+			t.Errorf("%s: no error", tc.name)
+		} else if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %q doesn't contain %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestJWTRotation(t *testing.T) {
+	old, next := ecTestKey(t, "old"), rsaTestKey(t, "next")
+	var body atomic.Value
+	body.Store(jwks(t, old))
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(body.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	j, err := NewJWTBearer(testJWTConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := j.Authorize(ctx, old.sign(t, "ES256", claims(nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches with a cached JWKS", n)
+	}
+	// tokens signed with unknown keys don't cause
+	// the JWKS to be fetched more than once within
+	// jwksMinRefresh
+	body.Store(jwks(t, next))
+	_, err = j.Authorize(ctx, next.sign(t, "RS256", claims(nil)))
+	if err == nil {
+		t.Fatal("token signed with an unknown key accepted")
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches within jwksMinRefresh", n)
+	}
+
+	save := jwksMinRefresh
+	jwksMinRefresh = 0
+	defer func() { jwksMinRefresh = save }()
+	_, err = j.Authorize(ctx, next.sign(t, "RS256", claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches after rotation", n)
+	}
+	// the old key is no longer valid
+	_, err = j.Authorize(ctx, old.sign(t, "ES256", claims(nil)))
+	if err == nil {
+		t.Fatal("token signed with a rotated key accepted")
+	}
+}
+
+func TestJWTFetchUnlocked(t *testing.T) {
+	old, next := ecTestKey(t, "old"), rsaTestKey(t, "next")
+	var body atomic.Value
+	body.Store(jwks(t, old))
+	var block atomic.Bool
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if block.Load() {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		w.Write(body.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	j, err := NewJWTBearer(testJWTConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := jwksMinRefresh
+	jwksMinRefresh = 0
+	defer func() { jwksMinRefresh = save }()
+
+	body.Store(jwks(t, old, next))
+	block.Store(true)
+	ctx := context.Background()
+	oldtok := old.sign(t, "ES256", claims(nil))
+	nexttok := next.sign(t, "RS256", claims(nil))
+	const waiters = 8
+	errs := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			_, err := j.Authorize(ctx, nexttok)
+			errs <- err
+		}()
+	}
+	<-started
+	// tokens signed with cached keys are
+	// verified while the JWKS is being fetched
+	done := make(chan error, 1)
+	go func() {
+		_, err := j.Authorize(ctx, oldtok)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("lookup blocked by the fetch of the JWKS")
+	}
+	block.Store(false)
+	close(release)
+	for i := 0; i < waiters; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJWTConfig(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "jwks.json")
+	err := os.WriteFile(keyfile, jwks(t, ecTestKey(t, "es")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conffile := filepath.Join(dir, "jwt.json")
+	buf, err := json.Marshal(testJWTConfig(keyfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(conffile, buf, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Parse("jwt://" + conffile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*JWTBearer); !ok {
+		t.Fatalf("Parse returned %T", p)
+	}
+
+	for _, mod := range []func(c *JWTConfig){
+		func(c *JWTConfig) { c.Issuer = "" },
+		func(c *JWTConfig) { c.Audience = "" },
+		func(c *JWTConfig) { c.IndexKey = nil },
+		func(c *JWTConfig) { c.Tenant = "{org" },
+		func(c *JWTConfig) { c.Root = "s3://bucket/{}" },
+		func(c *JWTConfig) { c.JWKS = filepath.Join(dir, "missing.json") },
+	} {
+		c := testJWTConfig(keyfile)
+		mod(c)
+		if _, err := NewJWTBearer(c); err == nil {
+			t.Errorf("config %+v accepted", c)
+		}
+	}
+}
@@ -20,9 +20,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/SnellerInc/sneller/aws"
@@ -51,13 +53,23 @@ type S3BearerIdentity struct {
 	ID       string `json:"TenantID"`
 	Region   string `json:"Region"`
 	IndexKey []byte `json:"IndexKey,omitempty"`
-	Bucket   string `json:"SnellerBucket"`
+	// Bucket is the URL of the root of
+	// the tenant storage, either the root of
+	// a bucket ("s3://bucket") or a prefix
+	// within a bucket ("s3://bucket/prefix").
+	Bucket string `json:"SnellerBucket"`
 	// Credentials is a JSON-compatible
 	// representation of the AWS SDK "Credentials" structure
 	Credentials S3BearerCredentials `json:"Credentials"`
 	// MaxScanBytes is the maximum number of bytes
 	// allowed to be scanned on any query.
 	MaxScanBytes uint64 `json:"MaxScanBytes"`
+	// MaxConcurrentQueries, MaxBytesInFlight and Weight
+	// are the admission limits of the tenant
+	// (see db.TenantConfig).
+	MaxConcurrentQueries int    `json:"MaxConcurrentQueries,omitempty"`
+	MaxBytesInFlight     uint64 `json:"MaxBytesInFlight,omitempty"`
+	Weight               int    `json:"Weight,omitempty"`
 }
 
 type S3BearerCredentials struct {
@@ -91,6 +103,10 @@ func (s *S3BearerIdentity) Tenant(ctx context.Context) (db.Tenant, error) {
 	if !s3.ValidBucket(u.Host) {
 		return nil, fmt.Errorf("bucket %q is invalid", s.Bucket)
 	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" && (!fs.ValidPath(prefix) || prefix == ".") {
+		return nil, fmt.Errorf("bucket prefix %q is invalid", prefix)
+	}
 	k := new(blockfmt.Key)
 	if copy(k[:], s.IndexKey) != len(k[:]) {
 		return nil, fmt.Errorf("invalid len(IndexKey)=%d", len(s.IndexKey))
@@ -106,10 +122,14 @@ func (s *S3BearerIdentity) Tenant(ctx context.Context) (db.Tenant, error) {
 	root.Ctx = ctx
 	root.Client = &s3.DefaultClient
 	root.Bucket = u.Host
+	root.Root = prefix
 	root.Key = aws.DeriveKey(c.BaseURI, c.AccessKeyID, c.SecretAccessKey, s.Region, "s3")
 	root.Key.Token = c.SessionToken
 	cfg := &db.TenantConfig{
-		MaxScanBytes: s.MaxScanBytes,
+		MaxScanBytes:         s.MaxScanBytes,
+		MaxConcurrentQueries: s.MaxConcurrentQueries,
+		MaxBytesInFlight:     s.MaxBytesInFlight,
+		Weight:               s.Weight,
 	}
 	return S3Tenant(ctx, s.ID, root, k, cfg), nil
 }
@@ -41,6 +41,14 @@ type BucketFS struct {
 	Client *http.Client
 	Ctx    context.Context
 
+	// Root, if non-empty, is the prefix
+	// (without a trailing forward slash)
+	// of the keys of all of the objects in
+	// the file system, so the file system
+	// is rooted in a "directory" of the bucket
+	// rather than at the root of the bucket.
+	Root string
+
 	// DelayGet, if true, causes the
 	// Open call to use a HEAD operation
 	// rather than a GET operation.
@@ -49,6 +57,19 @@ type BucketFS struct {
 	DelayGet bool
 }
 
+// ObjectKey returns the key of the
+// object with the given (valid) path
+// relative to the root of the file system.
+func (b *BucketFS) ObjectKey(name string) string {
+	if b.Root == "" {
+		return name
+	}
+	if name == "." {
+		return b.Root + "/"
+	}
+	return b.Root + "/" + name
+}
+
 func (b *BucketFS) sub(name string) *Prefix {
 	return b.subctx(b.Ctx, name)
 }
@@ -58,7 +79,7 @@ func (b *BucketFS) subctx(ctx context.Context, name string) *Prefix {
 		Key:    b.Key,
 		Client: b.Client,
 		Bucket: b.Bucket,
-		Path:   name,
+		Path:   b.ObjectKey(name),
 		Ctx:    ctx,
 	}
 }
@@ -93,7 +114,7 @@ func (b *BucketFS) Put(where string, contents []byte) (string, error) {
 }
 
 func (b *BucketFS) put(where string, contents []byte) (string, error) {
-	req, err := http.NewRequestWithContext(b.Ctx, http.MethodPut, uri(b.Key, b.Bucket, where), nil)
+	req, err := http.NewRequestWithContext(b.Ctx, http.MethodPut, uri(b.Key, b.Bucket, b.ObjectKey(where)), nil)
 	if err != nil {
 		return "", err
 	}
@@ -140,7 +161,7 @@ func (b *BucketFS) OpenRange(name, etag string, start, width int64) (io.ReadClos
 		Client: b.Client,
 		Key:    b.Key,
 		Bucket: b.Bucket,
-		Path:   name,
+		Path:   b.ObjectKey(name),
 		ETag:   etag,
 	}
 	return r.RangeReader(start, width)
@@ -170,7 +191,7 @@ func (b *BucketFS) Open(name string) (fs.File, error) {
 		// try a HEAD or GET operation; these
 		// are cheaper and faster than
 		// full listing operations
-		f, err := Open(b.Key, b.Bucket, name, !b.DelayGet)
+		f, err := Open(b.Key, b.Bucket, b.ObjectKey(name), !b.DelayGet)
 		if err == nil || !errors.Is(err, fs.ErrNotExist) {
 			return f, err
 		}
@@ -52,3 +52,61 @@ func TestCgroup(t *testing.T) {
 		t.Fatal("removing sub:", err)
 	}
 }
+
+func TestMemoryLimit(t *testing.T) {
+	tmp := t.TempDir()
+	root := Dir(tmp)
+	leaf := root.Sub("a/b")
+	if err := os.MkdirAll(string(leaf), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(d Dir, text string) {
+		if err := os.WriteFile(d.join("memory.max"), []byte(text+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// no limits anywhere
+	write(leaf, "max")
+	_, ok, err := leaf.MemoryLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected no limit")
+	}
+	// the smallest limit wins
+	write(leaf, "4194304")
+	write(root.Sub("a"), "max")
+	write(root, "1048576")
+	limit, ok, err := leaf.MemoryLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || limit != 1048576 {
+		t.Fatalf("got limit %d, %v", limit, ok)
+	}
+	// only the parent has a limit (the memory
+	// controller isn't enabled for the leaf and
+	// the root never has a limit)
+	for _, d := range []Dir{leaf, root} {
+		if err := os.Remove(d.join("memory.max")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(root.Sub("a"), "2097152")
+	limit, ok, err = leaf.Sub("c").MemoryLimit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || limit != 2097152 {
+		t.Fatalf("got limit %d, %v", limit, ok)
+	}
+	write(leaf, "4194304")
+	n, err := leaf.ReadInt("memory.max")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4194304 {
+		t.Fatalf("ReadInt returned %d", n)
+	}
+}
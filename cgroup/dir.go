@@ -100,6 +100,49 @@ func (d Dir) WriteInt(name string, val int) error {
 
 func (d Dir) join(name string) string { return filepath.Join(string(d), name) }
 
+// ReadInt reads an integer value from
+// the file with the given name within d.
+func (d Dir) ReadInt(name string) (int64, error) {
+	buf, err := os.ReadFile(d.join(name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(bytes.TrimSpace(buf)), 10, 64)
+}
+
+// MemoryLimit returns the effective memory limit
+// of d, which is the smallest memory.max value of
+// d and each of its parents. If none of them
+// has a limit, MemoryLimit returns (0, false, nil).
+func (d Dir) MemoryLimit() (int64, bool, error) {
+	limit, ok := int64(0), false
+	for dir := d; ; {
+		buf, err := os.ReadFile(dir.join("memory.max"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, false, err
+		}
+		// the root cgroup has no memory.max, and
+		// neither do cgroups that don't have the
+		// memory controller enabled, so keep going
+		text := string(bytes.TrimSpace(buf))
+		if err == nil && text != "max" {
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return 0, false, fmt.Errorf("%s: %w", dir.join("memory.max"), err)
+			}
+			if !ok || n < limit {
+				limit, ok = n, true
+			}
+		}
+		parent := Dir(filepath.Dir(string(dir)))
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return limit, ok, nil
+}
+
 // WriteLine writes the provided bytes plus
 // a newline character to the file with the
 // given name within d.
@@ -61,6 +61,20 @@ func (d Dir) Create(sub string, kill bool) (Dir, error) {
 	panic("unimplemented")
 }
 
+// ReadInt reads an integer value from
+// the file with the given name within d.
+func (d Dir) ReadInt(name string) (int64, error) {
+	panic("unimplemented")
+}
+
+// MemoryLimit returns the effective memory limit
+// of d, which is the smallest memory.max value of
+// d and each of its parents. If none of them
+// has a limit, MemoryLimit returns (0, false, nil).
+func (d Dir) MemoryLimit() (int64, bool, error) {
+	panic("unimplemented")
+}
+
 // Sub returns a new Dir that represents a
 // sub-directory of d.
 func (d Dir) Sub(dir string) Dir {
@@ -17,6 +17,7 @@ package main
 import (
 	"os"
 
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/db"
 )
 
@@ -38,6 +39,12 @@ func create(creds db.Tenant, dbname, tblname, defpath string) {
 	ofs := outfs(creds)
 	s := load(defpath)
 	err := db.WriteDefinition(ofs, dbname, tblname, s)
+	record(&audit.Event{
+		Action:   audit.ActionCreateTable,
+		Tenant:   creds.ID(),
+		Database: dbname,
+		Table:    tblname,
+	}, err)
 	if err != nil {
 		exitf("writing new definition: %s", err)
 	}
@@ -17,6 +17,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"time"
 
@@ -106,10 +107,46 @@ func descriptors(ofs db.InputFS, files []string) []blockfmt.Descriptor {
 	return out
 }
 
+func describeShape(s *blockfmt.Shape) {
+	if s.Empty() && !s.Incomplete {
+		return
+	}
+	note := ""
+	if s.Approximate {
+		note = " (approximate)"
+	}
+	fmt.Printf("rows:               %d%s\n", s.Rows, note)
+	fmt.Printf("fields:\n")
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		var types []string
+		for k, v := range f.Types {
+			types = append(types, fmt.Sprintf("%s:%d", k, v))
+		}
+		slices.Sort(types)
+		fmt.Printf("\t%s %s", f.Name(), strings.Join(types, " "))
+		if d := f.Distinct(); d > 0 {
+			fmt.Printf(" ~%d distinct", d)
+		}
+		fmt.Printf("\n")
+	}
+	if s.Truncated {
+		fmt.Printf("\t(too many fields; list truncated)\n")
+	}
+	if s.Incomplete {
+		fmt.Printf("\t(some objects have no recorded fields)\n")
+	}
+}
+
 func describeFiles(creds db.Tenant, files []string) {
 	ofs := root(creds)
 	descs := descriptors(ofs, files)
 	describeDescs(ofs, descs, 0)
+	var shape blockfmt.Shape
+	for i := range descs {
+		shape.Merge(&descs[i].Trailer.Shape)
+	}
+	describeShape(&shape)
 }
 
 func describe(creds db.Tenant, dbname, table string) {
@@ -125,6 +162,8 @@ func describe(creds db.Tenant, dbname, table string) {
 	nindirect := len(descs)
 	descs = append(descs, idx.Inline...)
 	describeDescs(ofs, descs, nindirect)
+	shape := idx.Shape()
+	describeShape(&shape)
 }
 
 func describeDescs(src blockfmt.InputFS, descs []blockfmt.Descriptor, indirect int) {
@@ -155,7 +194,9 @@ The command
   $ sdb describe <db> <table>
 will output a textual description
 of the index file associated with
-the given database+table.
+the given database+table, including
+the field paths and types recorded
+in the index.
 `,
 		run: func(args []string) bool {
 			if len(args) != 3 {
@@ -23,6 +23,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/auth"
 	"github.com/SnellerInc/sneller/db"
 
@@ -30,9 +31,14 @@ import (
 )
 
 var (
-	dashv    bool
-	dashh    bool
-	rootpath string
+	dashv     bool
+	dashh     bool
+	rootpath  string
+	auditdest string
+
+	// auditlog is the audit log
+	// opened from auditdest (if any)
+	auditlog *audit.Logger
 )
 
 const (
@@ -56,6 +62,7 @@ func init() {
 	flag.BoolVar(&dashv, "v", false, "verbose")
 	flag.BoolVar(&dashh, "h", false, "show usage help")
 	flag.StringVar(&rootpath, "root", defaultRoot(), "file system root (either directory path or s3 bucket)")
+	flag.StringVar(&auditdest, "audit", "", "audit log destination (file path, directory path ending in / for rotating files, or - for stdout)")
 }
 
 func exitf(f string, args ...interface{}) {
@@ -90,6 +97,18 @@ func logf(f string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, f, args...)
 }
 
+// record records an action in the audit log
+// (if there is one) along with the error
+// that caused it to fail, if any
+func record(e *audit.Event, err error) {
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := auditlog.Log(e); err != nil {
+		exitf("audit log: %s", err)
+	}
+}
+
 func creds() db.Tenant {
 	if rootpath == "" {
 		exitf("-root not specified")
@@ -216,6 +235,15 @@ func main() {
 		return
 	}
 
+	if auditdest != "" {
+		var err error
+		auditlog, err = audit.Open(auditdest)
+		if err != nil {
+			exitf("audit log: %s", err)
+		}
+		defer auditlog.Close()
+	}
+
 	validArgs := app.run(args)
 	if !validArgs {
 		exitf("usage: %s %s", cmd, app.help)
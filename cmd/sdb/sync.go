@@ -45,6 +45,7 @@ func sync(args []string) {
 			Force:         force,
 			MaxScanBytes:  dashm,
 			GCMinimumAge:  5 * time.Minute,
+			Audit:         auditlog,
 		}
 		if dashv {
 			c.Logf = logf
@@ -0,0 +1,100 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/audit"
+	"github.com/SnellerInc/sneller/db"
+)
+
+// entry point for 'sdb create-view ...'
+func createView(creds db.Tenant, dbname, view, query string) {
+	_, err := sneller.ParseView(dbname, query)
+	if err != nil {
+		exitf("invalid view: %s", err)
+	}
+	err = db.WriteView(outfs(creds), dbname, view, &db.View{Query: query})
+	record(&audit.Event{
+		Action:   audit.ActionCreateView,
+		Tenant:   creds.ID(),
+		Database: dbname,
+		Table:    view,
+	}, err)
+	if err != nil {
+		exitf("writing view: %s", err)
+	}
+}
+
+// entry point for 'sdb drop-view ...'
+func dropView(creds db.Tenant, dbname, view string) {
+	rfs, ok := root(creds).(db.RemoveFS)
+	if !ok {
+		exitf("root does not support removing files")
+	}
+	err := db.DeleteView(rfs, dbname, view)
+	record(&audit.Event{
+		Action:   audit.ActionDropView,
+		Tenant:   creds.ID(),
+		Database: dbname,
+		Table:    view,
+	}, err)
+	if err != nil {
+		exitf("removing view: %s", err)
+	}
+}
+
+func init() {
+	addApplet(applet{
+		name: "create-view",
+		help: "<db> <view> <query>",
+		desc: `create a view from a query
+The command
+  $ sdb create-view <db> <view> 'SELECT ...'
+stores the query as a view at
+  /db/<db>/<view>/view.json
+in the tenant root file system.
+
+A view can be queried like a table; the query
+of the view is inlined into the queries that
+reference it. Tables referenced by the view
+without a database name are resolved in <db>.
+Running create-view again replaces the view.
+`,
+		run: func(args []string) bool {
+			if len(args) != 4 {
+				return false
+			}
+			createView(creds(), args[1], args[2], args[3])
+			return true
+		},
+	})
+	addApplet(applet{
+		name: "drop-view",
+		help: "<db> <view>",
+		desc: `remove a view
+The command
+  $ sdb drop-view <db> <view>
+removes a view created with create-view.
+`,
+		run: func(args []string) bool {
+			if len(args) != 3 {
+				return false
+			}
+			dropView(creds(), args[1], args[2])
+			return true
+		},
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/tenant"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+func TestQueueFull(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:     testlogger(t),
+		cachedir:   t.TempDir(),
+		tenantcmd:  []string{"./snellerd-test-binary", "worker"},
+		peers:      noPeers{},
+		auth:       testAuth{tt},
+		maxRunning: 1,
+		maxQueued:  1,
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	// occupy the only slot and
+	// the only place in the queue
+	ctx := context.Background()
+	other := tnproto.ID{1}
+	release, err := s.manager.Admit(ctx, other, &tenant.Limits{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := make(chan func())
+	go func() {
+		r, err := s.manager.Admit(ctx, other, &tenant.Limits{}, 0)
+		if err != nil {
+			panic(err)
+		}
+		queued <- r
+	}()
+	queuedQueries := func() float64 {
+		for _, f := range s.manager.Metrics() {
+			if f.Name == "sneller_queries_queued" {
+				return f.Samples[0].Value
+			}
+		}
+		return 0
+	}
+	for i := 0; queuedQueries() != 1; i++ {
+		if i == 1000 {
+			t.Fatal("query not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	query := rq.getQuery("default", "SELECT COUNT(*) FROM parking")
+	res, err := http.DefaultClient.Do(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got status %s", res.Status)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+
+	release()
+	(<-queued)()
+	res, err = http.DefaultClient.Do(rq.getQuery("default", "SELECT COUNT(*) FROM parking"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %s", res.Status)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/SnellerInc/sneller/audit"
+)
+
+// record records an event in the audit log
+// (if there is one) on behalf of the client
+// that sent r; the status of the event is
+// the status of the response written to w
+func (s *server) record(w *queryWriter, r *http.Request, e *audit.Event) {
+	if s.audit == nil {
+		return
+	}
+	e.Status = w.status
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	if e.Error == "" && w.err != nil {
+		e.Error = w.err.Error()
+	}
+	e.Client = r.RemoteAddr
+	if err := s.audit.Log(e); err != nil {
+		s.logger.Printf("audit log: %s", err)
+	}
+}
+
+// recordQuery records a query
+// handled by the query handler
+func (s *server) recordQuery(w *queryWriter, r *http.Request, elapsed time.Duration) {
+	e := &audit.Event{
+		Action:   audit.ActionQuery,
+		Tenant:   w.tenant,
+		QueryID:  w.id,
+		Query:    w.query,
+		Database: r.URL.Query().Get("database"),
+		Tables:   w.tables,
+		Duration: elapsed.Seconds(),
+	}
+	if w.stats != nil {
+		e.BytesScanned = w.stats.BytesScanned
+	}
+	s.record(w, r, e)
+}
+
+// recordAsync records a finished
+// asynchronous query in the audit log
+func (s *server) recordAsync(q *asyncQuery) {
+	if s.audit == nil {
+		return
+	}
+	st := &q.status
+	e := &audit.Event{
+		Time:         *st.End,
+		Action:       audit.ActionQuery,
+		Tenant:       q.tenant,
+		QueryID:      st.ID,
+		Query:        st.Query,
+		Tables:       q.tables,
+		BytesScanned: st.BytesScanned,
+		Duration:     st.End.Sub(st.Start).Seconds(),
+		Error:        st.Error,
+		Client:       q.client,
+	}
+	if st.State == asyncCanceled {
+		e.Error = "canceled"
+	}
+	if err := s.audit.Log(e); err != nil {
+		s.logger.Printf("audit log: %s", err)
+	}
+}
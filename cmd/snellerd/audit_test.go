@@ -0,0 +1,156 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/audit"
+)
+
+// eventWriter sends the events
+// written to the audit log to a channel
+type eventWriter chan audit.Event
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	var e audit.Event
+	if err := json.Unmarshal(p, &e); err != nil {
+		return 0, err
+	}
+	w <- e
+	return len(p), nil
+}
+
+func TestAudit(t *testing.T) {
+	tt := testdirEnviron(t)
+	events := make(eventWriter, 16)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+		audit:     audit.New(events),
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(req *http.Request) *http.Response {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer snellerd-test")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return res
+	}
+	// the event is recorded after the response
+	// is sent, so wait for it to show up
+	next := func() audit.Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Tenant != tt.ID() || e.Client == "" || e.Time.IsZero() {
+				t.Errorf("unexpected event %+v", e)
+			}
+			return e
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for audit event")
+		}
+		return audit.Event{}
+	}
+
+	req := rq.get("/view?database=default&view=expensive")
+	req.Method = http.MethodPost
+	req.Body = io.NopCloser(strings.NewReader("SELECT Make, Color, Fine FROM parking WHERE Fine > 50"))
+	res := do(req)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("creating view: %s", res.Status)
+	}
+	e := next()
+	if e.Action != audit.ActionCreateView || e.Database != "default" || e.Table != "expensive" || e.Status != http.StatusOK {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	res = do(rq.getQuery("default", "SELECT COUNT(*) FROM expensive WHERE Color = 'SECRET'"))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("query: %s", res.Status)
+	}
+	e = next()
+	if e.Action != audit.ActionQuery || e.Status != http.StatusOK || e.Database != "default" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e.QueryID != res.Header.Get("X-Sneller-Query-ID") {
+		t.Errorf("query ID %q, want %q", e.QueryID, res.Header.Get("X-Sneller-Query-ID"))
+	}
+	// the query text is redacted
+	if e.Query == "" || strings.Contains(e.Query, "SECRET") {
+		t.Errorf("unexpected query text %q", e.Query)
+	}
+	if want := []string{"default.expensive", "default.parking"}; !slices.Equal(e.Tables, want) {
+		t.Errorf("tables %v, want %v", e.Tables, want)
+	}
+	if e.BytesScanned == 0 || e.Duration == 0 {
+		t.Errorf("no statistics in %+v", e)
+	}
+
+	res = do(rq.getQuery("default", "SELECT COUNT(*) FROM missing"))
+	if res.StatusCode == http.StatusOK {
+		t.Fatal("query of missing table succeeded")
+	}
+	e = next()
+	if e.Action != audit.ActionQuery || e.Status != res.StatusCode || e.BytesScanned != 0 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	do(rq.getDBs())
+	e = next()
+	if e.Action != audit.ActionListDatabases || e.Status != http.StatusOK {
+		t.Errorf("unexpected event %+v", e)
+	}
+	do(rq.getTables("default"))
+	e = next()
+	if e.Action != audit.ActionListTables || e.Database != "default" || e.Status != http.StatusOK {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// reading a view isn't audited
+	do(rq.get("/view?database=default&view=expensive"))
+	req = rq.get("/view?database=default&view=expensive")
+	req.Method = http.MethodDelete
+	do(req)
+	e = next()
+	if e.Action != audit.ActionDropView || e.Table != "expensive" || e.Status != http.StatusNoContent {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
@@ -115,6 +115,12 @@ func (r *requester) getInputs(db, table string) *http.Request {
 	return req
 }
 
+func (r *requester) getSchema(db, table string) *http.Request {
+	req := r.get(fmt.Sprintf("/schema?database=%s&table=%s", url.QueryEscape(db), url.QueryEscape(table)))
+	req.Header.Set("Authorization", "Bearer snellerd-test")
+	return req
+}
+
 type testAuth struct {
 	self db.Tenant
 }
@@ -419,6 +419,48 @@ func TestSimpleFS(t *testing.T) {
 		}
 	}
 
+	{
+		// test that the schema is available
+		// without running a query
+		req := rq.getSchema("default", "parking2")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("get schema: %s", res.Status)
+		}
+		var ret struct {
+			Total      int64                       `json:"total"`
+			Incomplete bool                        `json:"incomplete"`
+			Fields     map[string]map[string]int64 `json:"fields"`
+			Distinct   map[string]int64            `json:"distinct"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&ret)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.Incomplete {
+			t.Error("schema is incomplete")
+		}
+		if ret.Total < 1023 {
+			t.Errorf("schema total: %d", ret.Total)
+		}
+		if n := ret.Fields["Make"]["string"]; n == 0 || n > ret.Total {
+			t.Errorf("Make: %d strings", n)
+		}
+		if n := ret.Fields["Issue.Time"]["int"]; n == 0 || n > ret.Total {
+			t.Errorf("Issue.Time: %d ints", n)
+		}
+		if _, ok := ret.Fields["Make"]["distinct"]; ok {
+			t.Error("Make: distinct count among the types")
+		}
+		if n := ret.Distinct["Make"]; n == 0 {
+			t.Error("Make: no distinct count")
+		}
+	}
+
 	checkTiming := func(t *testing.T, res *http.Response) {
 		t.Helper()
 		timings := res.Trailer.Get("Server-Timing")
@@ -0,0 +1,542 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/db"
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tenant"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+	"github.com/SnellerInc/sneller/usock"
+
+	"github.com/google/uuid"
+)
+
+// DefaultAsyncTTL is the default amount of time
+// for which the status and the results of
+// asynchronous queries are kept.
+const DefaultAsyncTTL = 24 * time.Hour
+
+// asyncPrefix is the directory of the tenant
+// storage in which asynchronous queries store
+// their status and results
+const asyncPrefix = "async"
+
+// asyncSweepInterval is the minimum interval
+// between two sweeps of the expired asynchronous
+// queries of the same tenant
+const asyncSweepInterval = 10 * time.Minute
+
+// states of asynchronous queries
+const (
+	asyncRunning  = "running"
+	asyncDone     = "done"
+	asyncFailed   = "failed"
+	asyncCanceled = "canceled"
+)
+
+// asyncStatus is the status of an asynchronous
+// query; it is stored next to the query results
+// so that any node can serve it
+type asyncStatus struct {
+	ID           string     `json:"id"`
+	State        string     `json:"state"`
+	Query        string     `json:"query"`
+	Start        time.Time  `json:"start"`
+	End          *time.Time `json:"end,omitempty"`
+	Expires      time.Time  `json:"expires"`
+	MaxScanned   int64      `json:"max_scanned"`
+	BytesScanned int64      `json:"bytes_scanned"`
+	Error        string     `json:"error,omitempty"`
+	// Result is the path of the packfile
+	// holding the results of the query
+	Result string `json:"result,omitempty"`
+}
+
+func asyncStatusPath(id string) string {
+	return path.Join(asyncPrefix, id, "status.json")
+}
+
+func writeAsyncStatus(dst db.OutputFS, st *asyncStatus) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = dst.WriteFile(asyncStatusPath(st.ID), buf)
+	return err
+}
+
+func readAsyncStatus(src fs.FS, id string) (*asyncStatus, error) {
+	buf, err := fs.ReadFile(src, asyncStatusPath(id))
+	if err != nil {
+		return nil, err
+	}
+	st := new(asyncStatus)
+	if err := json.Unmarshal(buf, st); err != nil {
+		return nil, fmt.Errorf("decoding status of query %s: %w", id, err)
+	}
+	return st, nil
+}
+
+// asyncQuery is an asynchronous query
+// that is running in the background
+type asyncQuery struct {
+	status  asyncStatus
+	tenant  string
+	id      tnproto.ID
+	key     tnproto.Key
+	limits  tenant.Limits
+	tree    *plan.Tree
+	store   db.OutputFS
+	running *runningQuery
+	// client and tables are recorded
+	// in the audit log (see runAsync)
+	client string
+	tables []string
+}
+
+// example invocation:
+// curl -v -X POST -H 'Authorization: sneller' --data-raw 'SELECT * FROM nation' 'http://localhost:8080/query/async?database=sf1-new'
+func (s *server) asyncQueryHandler(w http.ResponseWriter, r *http.Request) {
+	creds, err := s.getTenant(r.Context(), w, r)
+	if err != nil {
+		return
+	}
+	tenantID := creds.ID()
+	root, err := creds.Root()
+	if err != nil {
+		s.logger.Printf("tenant %s: async query: %s", tenantID, err)
+		http.Error(w, "cannot access tenant storage", http.StatusInternalServerError)
+		return
+	}
+	store, ok := root.(db.OutputFS)
+	if !ok {
+		http.Error(w, "asynchronous queries require writable storage", http.StatusNotImplemented)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, 128*1024*1024)
+	query, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, "cannot read query", http.StatusBadRequest)
+		return
+	}
+	parsedQuery, err := parseQuery(r.Header.Get("Content-Type"), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := parsedQuery.Check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if parsedQuery.Into != nil {
+		http.Error(w, "INTO is not supported by asynchronous queries", http.StatusBadRequest)
+		return
+	}
+
+	id, key := tenantProc(creds)
+	maxScan, limits := tenantLimits(creds)
+	planEnv, err := sneller.Environ(creds, r.URL.Query().Get("database"))
+	if err != nil {
+		http.Error(w, "tenant ID disallowed", http.StatusForbidden)
+		s.logger.Printf("refusing query: %s", err)
+		return
+	}
+	endPoints := s.peers.Get()
+
+	queryID := uuid.New().String()
+	w.Header().Add("X-Sneller-Query-ID", queryID)
+	tree, err := s.plan(parsedQuery, planEnv, id, key, endPoints)
+	tables := planEnv.Tables()
+	if err != nil {
+		s.logger.Printf("tenant %s query ID %s planning failed: %s", tenantID, queryID, err)
+		planError(w, err)
+		return
+	}
+	tree.ID = queryID
+	willScan := uint64(tree.MaxScanned())
+	w.Header().Set("X-Sneller-Max-Scanned-Bytes", utoa(willScan))
+	if maxScan > 0 && willScan > maxScan {
+		planError(w, &errPlanLimit{scan: willScan, max: maxScan})
+		return
+	}
+	// upload the query results into
+	// the tenant storage (like SELECT INTO)
+	tree.Root.Op = &plan.OutputPart{
+		Nonterminal: plan.Nonterminal{From: tree.Root.Op},
+		Basename:    path.Join(asyncPrefix, queryID),
+	}
+
+	start := time.Now()
+	q := &asyncQuery{
+		status: asyncStatus{
+			ID:         queryID,
+			State:      asyncRunning,
+			Query:      parsedQuery.Redacted(),
+			Start:      start.UTC(),
+			Expires:    start.Add(s.asyncTTL()).UTC(),
+			MaxScanned: int64(willScan),
+		},
+		tenant: tenantID,
+		id:     id,
+		key:    key,
+		limits: limits,
+		tree:   tree,
+		store:  store,
+		client: r.RemoteAddr,
+		tables: tables,
+		running: &runningQuery{
+			ID:     queryID,
+			Tenant: tenantID,
+			Query:  parsedQuery.Redacted(),
+			Start:  start,
+			id:     id,
+			key:    key,
+		},
+	}
+	for i := range endPoints {
+		q.running.Peers = append(q.running.Peers, endPoints[i].String())
+	}
+	if err := writeAsyncStatus(store, &q.status); err != nil {
+		s.logger.Printf("tenant %s query ID %s writing status: %s", tenantID, queryID, err)
+		http.Error(w, "cannot write query status", http.StatusInternalServerError)
+		return
+	}
+	status := q.status
+	ctx, cancel := context.WithCancel(context.Background())
+	q.running.abort = cancel
+	s.queries.add(q.running)
+	go func() {
+		defer cancel()
+		s.runAsync(ctx, q)
+	}()
+	s.sweepAsync(tenantID, store)
+
+	s.logger.Printf("tenant %s query ID %s started asynchronously", tenantID, queryID)
+	w.Header().Set("Location", "/query/async/"+queryID)
+	writeResultResponse(w, http.StatusAccepted, &status)
+}
+
+func (s *server) asyncTTL() time.Duration {
+	if s.asyncttl > 0 {
+		return s.asyncttl
+	}
+	return DefaultAsyncTTL
+}
+
+// runAsync runs an asynchronous query
+// and stores its final status
+func (s *server) runAsync(ctx context.Context, q *asyncQuery) {
+	defer s.queries.remove(q.running)
+	var stats plan.ExecStats
+	result, err := s.execAsync(ctx, q, &stats)
+	end := time.Now().UTC()
+	st := &q.status
+	st.End = &end
+	st.BytesScanned = stats.BytesScanned
+	switch {
+	case q.running.isCanceled():
+		st.State = asyncCanceled
+		s.logger.Printf("tenant %s query ID %s canceled after %s", q.tenant, st.ID, end.Sub(st.Start))
+	case err != nil:
+		st.State = asyncFailed
+		st.Error = err.Error()
+		s.logger.Printf("tenant %s query ID %s %q execution failed (async): %v", q.tenant, st.ID, st.Query, err)
+	default:
+		st.State = asyncDone
+		st.Result = result
+		s.logger.Printf("tenant %s query ID %s duration %s bytes %d hits %d misses %d",
+			q.tenant, st.ID, end.Sub(st.Start), stats.BytesScanned, stats.CacheHits, stats.CacheMisses)
+	}
+	if err := writeAsyncStatus(q.store, st); err != nil {
+		s.logger.Printf("tenant %s query ID %s writing status: %s", q.tenant, st.ID, err)
+	}
+	s.recordAsync(q)
+}
+
+// execAsync executes an asynchronous query
+// and returns the path of the uploaded results
+func (s *server) execAsync(ctx context.Context, q *asyncQuery, stats *plan.ExecStats) (string, error) {
+	release, err := s.manager.Admit(ctx, q.id, &q.limits, uint64(q.status.MaxScanned))
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	here, there, err := usock.SocketPair()
+	if err != nil {
+		return "", err
+	}
+	defer here.Close()
+	rc, err := s.manager.Do(q.id, q.key, q.tree, tnproto.OutputRaw, there)
+	// the tenant has its own copy of the socket
+	there.Close()
+	if err != nil {
+		return "", err
+	}
+	q.running.attach(rc)
+	type output struct {
+		buf []byte
+		err error
+	}
+	outc := make(chan output, 1)
+	go func() {
+		buf, err := io.ReadAll(here)
+		outc <- output{buf, err}
+	}()
+	setDeadline(rc, queryKillTimeout)
+	err = tenant.Check(rc, stats)
+	if err != nil {
+		if isTimeout(err) {
+			s.logger.Printf("tenant %s query ID %s killing tenant worker %s due to timeout", q.tenant, q.status.ID, q.id)
+			s.manager.Quit(q.id, q.key)
+		}
+		return "", err
+	}
+	out := <-outc
+	if out.err != nil {
+		return "", out.err
+	}
+	return readResultPath(out.buf)
+}
+
+// readResultPath reads the path of the packfile
+// from the descriptor written by plan.OutputPart
+func readResultPath(buf []byte) (string, error) {
+	var st ion.Symtab
+	for len(buf) > 0 {
+		var d ion.Datum
+		var err error
+		d, buf, err = ion.ReadDatum(&st, buf)
+		if err != nil {
+			return "", err
+		}
+		if d.IsEmpty() || d.IsNull() {
+			continue // nop pad
+		}
+		desc, err := blockfmt.ReadDescriptor(d)
+		if err != nil {
+			return "", err
+		}
+		return desc.Path, nil
+	}
+	return "", fmt.Errorf("no result descriptor in query output")
+}
+
+// example invocations:
+// curl -v -H 'Authorization: sneller' 'http://localhost:8080/query/async/<query-id>'
+// curl -v -H 'Authorization: sneller' -H 'Accept: application/x-ndjson' 'http://localhost:8080/query/async/<query-id>/result'
+func (s *server) asyncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	creds, err := s.getTenant(r.Context(), w, r)
+	if err != nil {
+		return
+	}
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/query/async/"), "/")
+	if _, err := uuid.Parse(id); err != nil || (rest != "" && rest != "result") {
+		http.Error(w, "no such query", http.StatusNotFound)
+		return
+	}
+	root, err := creds.Root()
+	if err != nil {
+		s.logger.Printf("tenant %s: async query: %s", creds.ID(), err)
+		http.Error(w, "cannot access tenant storage", http.StatusInternalServerError)
+		return
+	}
+	st, err := readAsyncStatus(root, id)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && time.Now().After(st.Expires)) {
+		http.Error(w, "no such query", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("tenant %s query ID %s reading status: %s", creds.ID(), id, err)
+		http.Error(w, "cannot read query status", http.StatusInternalServerError)
+		return
+	}
+	if rest == "result" {
+		s.asyncResult(w, r, root, st)
+		return
+	}
+
+	// report the progress of queries
+	// that are running on this node
+	if q := s.queries.get(id); st.State == asyncRunning && q != nil && q.Tenant == creds.ID() {
+		lst, err := s.manager.Queries(q.id, q.key)
+		if err != nil {
+			s.logger.Printf("tenant %s: reading query progress: %s", q.Tenant, err)
+		}
+		for i := range lst {
+			if lst[i].ID == id {
+				st.BytesScanned = lst[i].BytesScanned
+			}
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	writeResultResponse(w, http.StatusOK, st)
+}
+
+// asyncResult streams the results
+// of a completed asynchronous query
+func (s *server) asyncResult(w http.ResponseWriter, r *http.Request, root fs.FS, st *asyncStatus) {
+	if st.State != asyncDone {
+		http.Error(w, fmt.Sprintf("query is %s", st.State), http.StatusConflict)
+		return
+	}
+	contentType := "application/ion"
+	switch accept := r.Header.Get("Accept"); accept {
+	case "application/x-ndjson", "application/x-jsonlines":
+		contentType = accept
+	case "application/ion", "", "*/*":
+		if r.URL.Query().Has("json") {
+			contentType = "application/x-ndjson"
+		}
+	default:
+		http.Error(w, "invalid 'Accept' header", http.StatusBadRequest)
+		return
+	}
+	f, err := root.Open(st.Result)
+	if err != nil {
+		s.logger.Printf("query ID %s opening result: %s", st.ID, err)
+		http.Error(w, "cannot read query result", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	src, ok := f.(io.ReaderAt)
+	info, err := f.Stat()
+	if !ok || err != nil {
+		http.Error(w, "cannot read query result", http.StatusInternalServerError)
+		return
+	}
+	trailer, err := blockfmt.ReadTrailer(src, info.Size())
+	if err != nil {
+		s.logger.Printf("query ID %s reading result trailer: %s", st.ID, err)
+		http.Error(w, "cannot read query result", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if r.Method == http.MethodHead {
+		return
+	}
+	var dst io.Writer = w
+	if contentType != "application/ion" {
+		jw := ion.NewJSONWriter(w, '\n')
+		defer jw.Close()
+		dst = jw
+	}
+	var d blockfmt.Decoder
+	d.Set(trailer)
+	if _, err := d.Copy(dst, io.NewSectionReader(src, 0, trailer.Offset)); err != nil {
+		s.logger.Printf("query ID %s streaming result: %s", st.ID, err)
+	}
+}
+
+// asyncSweeper tracks when the expired
+// asynchronous queries of each tenant
+// were last removed
+type asyncSweeper struct {
+	lock sync.Mutex
+	last map[string]time.Time
+}
+
+// sweepAsync removes the expired asynchronous
+// queries of the tenant in the background,
+// unless they have been removed recently
+func (s *server) sweepAsync(tenantID string, store db.OutputFS) {
+	rfs, ok := store.(db.RemoveFS)
+	if !ok {
+		return
+	}
+	sw := &s.asyncSweeper
+	sw.lock.Lock()
+	now := time.Now()
+	if now.Sub(sw.last[tenantID]) < asyncSweepInterval {
+		sw.lock.Unlock()
+		return
+	}
+	if sw.last == nil {
+		sw.last = make(map[string]time.Time)
+	}
+	sw.last[tenantID] = now
+	sw.lock.Unlock()
+	go func() {
+		n, err := removeExpired(rfs, now)
+		if err != nil {
+			s.logger.Printf("tenant %s: removing expired async queries: %s", tenantID, err)
+		}
+		if n > 0 {
+			s.logger.Printf("tenant %s: removed %d expired async queries", tenantID, n)
+		}
+	}()
+}
+
+// removeExpired removes the status and results
+// of the asynchronous queries that expired
+// before now and returns how many were removed
+func removeExpired(rfs db.RemoveFS, now time.Time) (int, error) {
+	dirs, err := fs.ReadDir(rfs, asyncPrefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range dirs {
+		id := dirs[i].Name()
+		st, err := readAsyncStatus(rfs, id)
+		if err != nil || now.Before(st.Expires) {
+			continue
+		}
+		dir := path.Join(asyncPrefix, id)
+		files, err := fs.ReadDir(rfs, dir)
+		if err != nil {
+			return n, err
+		}
+		// remove the status last so that
+		// we retry if anything fails
+		for j := range files {
+			if name := path.Join(dir, files[j].Name()); name != asyncStatusPath(id) {
+				if err := rfs.Remove(name); err != nil {
+					return n, err
+				}
+			}
+		}
+		if err := rfs.Remove(asyncStatusPath(id)); err != nil {
+			return n, err
+		}
+		rfs.Remove(dir) // only needed for directories on local file systems
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/db"
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+	"github.com/SnellerInc/sneller/tenant"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+func TestAsyncQuery(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+		// allows queueing queries
+		maxRunning: 1,
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(req *http.Request) (*http.Response, string) {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer snellerd-test")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+	submit := func(query string) *asyncStatus {
+		t.Helper()
+		req := rq.get("/query/async?database=default")
+		req.Method = http.MethodPost
+		req.Body = io.NopCloser(strings.NewReader(query))
+		res, body := do(req)
+		if res.StatusCode != http.StatusAccepted {
+			t.Fatalf("submitting %q: %s %s", query, res.Status, body)
+		}
+		st := new(asyncStatus)
+		if err := json.Unmarshal([]byte(body), st); err != nil {
+			t.Fatal(err)
+		}
+		if st.State != asyncRunning || res.Header.Get("Location") != "/query/async/"+st.ID {
+			t.Fatalf("unexpected response %s (location %s)", body, res.Header.Get("Location"))
+		}
+		return st
+	}
+	wait := func(id string) *asyncStatus {
+		t.Helper()
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			res, body := do(rq.get("/query/async/" + id))
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("get status: %s %s", res.Status, body)
+			}
+			st := new(asyncStatus)
+			if err := json.Unmarshal([]byte(body), st); err != nil {
+				t.Fatal(err)
+			}
+			if st.State != asyncRunning {
+				return st
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("query %s still running", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT COUNT(*) AS n FROM parking",
+		"SELECT Ticket, Make FROM parking ORDER BY Ticket DESC LIMIT 20",
+	} {
+		id := submit(query).ID
+		// the result isn't available
+		// until the query is done
+		res, _ := do(rq.get("/query/async/" + id + "/result"))
+		if res.StatusCode != http.StatusConflict && res.StatusCode != http.StatusOK {
+			t.Errorf("get result of running query: %s", res.Status)
+		}
+		st := wait(id)
+		if st.State != asyncDone || st.End == nil || st.BytesScanned == 0 {
+			t.Fatalf("unexpected status %+v", st)
+		}
+
+		req := rq.getQuery("default", query)
+		req.Header.Set("Accept", "application/x-ndjson")
+		_, want := do(req)
+		req = rq.get("/query/async/" + id + "/result")
+		req.Header.Set("Accept", "application/x-ndjson")
+		res, got := do(req)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("get result: %s %s", res.Status, got)
+		}
+		if res.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected content type %s", res.Header.Get("Content-Type"))
+		}
+		if got != want {
+			t.Errorf("%s: got result\n%s\nwant\n%s", query, got, want)
+		}
+	}
+
+	// a sorted result that spans several
+	// chunks is read back in the same order
+	query := "SELECT tpep_pickup_datetime AS t, tpep_dropoff_datetime, VendorID, passenger_count, trip_distance, " +
+		"pickup_longitude, pickup_latitude, RatecodeID, store_and_fwd_flag, dropoff_longitude, dropoff_latitude, " +
+		"payment_type, fare_amount, surcharge, mta_tax, tip_amount, tolls_amount, total_amount, " +
+		"tpep_dropoff_datetime AS d0, tpep_dropoff_datetime AS d1, tpep_dropoff_datetime AS d2, tpep_dropoff_datetime AS d3, " +
+		"total_amount AS a0, total_amount AS a1, total_amount AS a2, total_amount AS a3 " +
+		"FROM taxi ORDER BY t DESC LIMIT 10000"
+	st := wait(submit(query).ID)
+	if st.State != asyncDone {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	root, err := tt.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := root.Open(st.Result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer, err := blockfmt.ReadTrailer(f.(io.ReaderAt), info.Size())
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := trailer.Decompressed() >> trailer.BlockShift; n < 2 {
+		t.Fatalf("result has %d chunks; expected more than one", n)
+	}
+	times := func(ndjson string) []string {
+		t.Helper()
+		var out []string
+		for _, line := range strings.Split(strings.TrimSpace(ndjson), "\n") {
+			var row struct {
+				T string `json:"t"`
+			}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("%s: %s", line, err)
+			}
+			out = append(out, row.T)
+		}
+		return out
+	}
+	req := rq.getQuery("default", query)
+	req.Header.Set("Accept", "application/x-ndjson")
+	_, body := do(req)
+	want := times(body)
+	req = rq.get("/query/async/" + st.ID + "/result")
+	req.Header.Set("Accept", "application/x-ndjson")
+	_, body = do(req)
+	got := times(body)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %d rows, want %d rows in the same order", len(got), len(want))
+	}
+	if !slices.IsSortedFunc(got, func(a, b string) int { return strings.Compare(b, a) }) {
+		t.Error("result isn't sorted")
+	}
+
+	for _, uri := range []string{
+		"/query/async/00000000-0000-0000-0000-000000000000",
+		"/query/async/not-an-id",
+		"/query/async/not-an-id/result",
+	} {
+		if res, _ := do(rq.get(uri)); res.StatusCode != http.StatusNotFound {
+			t.Errorf("get %s: %s", uri, res.Status)
+		}
+	}
+
+	// queued queries can be canceled
+	release, err := s.manager.Admit(context.Background(), tnproto.ID{1}, &tenant.Limits{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := submit("SELECT COUNT(*) FROM parking").ID
+	req = rq.get("/queries/" + id)
+	req.Method = http.MethodDelete
+	if res, _ := do(req); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("cancel: %s", res.Status)
+	}
+	st = wait(id)
+	release()
+	if st.State != asyncCanceled || st.Result != "" {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	// expired queries are removed
+	n, err := removeExpired(root.(db.RemoveFS), time.Now())
+	if err != nil || n != 0 {
+		t.Fatalf("removed %d queries: %v", n, err)
+	}
+	n, err = removeExpired(root.(db.RemoveFS), time.Now().Add(DefaultAsyncTTL+time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("removed %d queries; expected 4", n)
+	}
+	if res, _ := do(rq.get("/query/async/" + st.ID)); res.StatusCode != http.StatusNotFound {
+		t.Errorf("get expired query: %s", res.Status)
+	}
+}
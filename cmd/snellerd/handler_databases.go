@@ -18,6 +18,7 @@ import (
 	"net/http"
 
 	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/db"
 )
 
@@ -28,17 +29,25 @@ func (s *server) databasesHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	qw := &queryWriter{ResponseWriter: w}
+	w = qw
+	defer s.record(qw, r, &audit.Event{
+		Action: audit.ActionListDatabases,
+		Tenant: tenant.ID(),
+	})
 
 	pattern := r.URL.Query().Get("pattern")
 
 	e, err := sneller.Environ(tenant, "")
 	if err != nil {
+		qw.err = err
 		s.logger.Printf("unable to load databases for tenant '%v' %s\n", tenant, err)
 		writeInternalServerResponse(w, err)
 		return
 	}
 	res, err := db.List(e.Root)
 	if err != nil {
+		qw.err = err
 		s.logger.Printf("unable to load databases for tenant '%v' %s\n", tenant, err)
 		writeInternalServerResponse(w, err)
 		return
@@ -0,0 +1,123 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SnellerInc/sneller/metrics"
+	"github.com/SnellerInc/sneller/plan"
+)
+
+type serverMetrics struct {
+	registry metrics.Registry
+
+	queries       *metrics.CounterVec
+	queryErrors   *metrics.CounterVec
+	queryDuration *metrics.Histogram
+	bytesScanned  *metrics.Counter
+	cacheHits     *metrics.Counter
+	cacheMisses   *metrics.Counter
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{}
+	r := &m.registry
+	m.queries = r.CounterVec("sneller_queries", "Number of queries by HTTP status.", "status")
+	m.queryErrors = r.CounterVec("sneller_query_errors", "Number of failed queries by HTTP status.", "status")
+	m.queryDuration = r.Histogram("sneller_query_duration_seconds", "Query latency in seconds.", nil)
+	m.bytesScanned = r.Counter("sneller_query_scanned_bytes", "Number of bytes scanned by queries.")
+	m.cacheHits = r.Counter("sneller_query_cache_hits", "Number of cache hits reported by queries.")
+	m.cacheMisses = r.Counter("sneller_query_cache_misses", "Number of cache misses reported by queries.")
+	return m
+}
+
+// queryWriter is the http.ResponseWriter
+// passed to the query handler (and to the
+// other handlers of audited requests);
+// it records the outcome of the request
+type queryWriter struct {
+	http.ResponseWriter
+	status int
+	// failed is set when the query failed
+	// after the response status was sent
+	failed bool
+	// err is the error that caused
+	// the request to fail, if known
+	err error
+	// stats is set when the query succeeded
+	stats *plan.ExecStats
+
+	// tenant, id, query and tables
+	// describe the query for the audit log
+	tenant, id, query string
+	tables            []string
+}
+
+func (q *queryWriter) WriteHeader(code int) {
+	if q.status == 0 {
+		q.status = code
+	}
+	q.ResponseWriter.WriteHeader(code)
+}
+
+func (q *queryWriter) Write(p []byte) (int, error) {
+	if q.status == 0 {
+		q.status = http.StatusOK
+	}
+	return q.ResponseWriter.Write(p)
+}
+
+func (q *queryWriter) Flush() {
+	flush(q.ResponseWriter)
+}
+
+func (q *queryWriter) Unwrap() http.ResponseWriter {
+	return q.ResponseWriter
+}
+
+func (m *serverMetrics) observe(q *queryWriter, elapsed time.Duration) {
+	status := q.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	label := strconv.Itoa(status)
+	m.queries.With(label).Inc()
+	m.queryDuration.Observe(elapsed.Seconds())
+	if q.failed || status >= http.StatusBadRequest {
+		m.queryErrors.With(label).Inc()
+	}
+	if q.stats != nil {
+		m.bytesScanned.Add(float64(q.stats.BytesScanned))
+		m.cacheHits.Add(float64(q.stats.CacheHits))
+		m.cacheMisses.Add(float64(q.stats.CacheMisses))
+	}
+}
+
+// metricsHandler serves the metrics of the server
+// and the tenant manager (including the metrics
+// reported by the tenant processes)
+//
+// example invocation:
+// curl -v 'http://localhost:8080/metrics'
+func (s *server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	gather := []func() []metrics.Family{s.metrics.registry.Gather}
+	if m := s.manager; m != nil {
+		gather = append(gather, m.Metrics)
+	}
+	metrics.Handler(gather...).ServeHTTP(w, r)
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SnellerInc/sneller/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(req *http.Request) (*http.Response, string) {
+		t.Helper()
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+
+	if res, _ := do(rq.getQuery("default", "SELECT COUNT(*) FROM parking")); res.StatusCode != http.StatusOK {
+		t.Fatalf("query: %s", res.Status)
+	}
+	if res, _ := do(rq.getQuery("default", "SELECT 3||x FROM parking")); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad query: %s", res.Status)
+	}
+
+	res, body := do(rq.get("/metrics"))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("get /metrics: %s", res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("missing # EOF")
+	}
+	for _, want := range []string{
+		"\nsneller_queries_total{status=\"200\"} 1\n",
+		"\nsneller_queries_total{status=\"400\"} 1\n",
+		"\nsneller_query_errors_total{status=\"400\"} 1\n",
+		"\nsneller_query_duration_seconds_count 2\n",
+		"\nsneller_tenant_processes 1\n",
+		"\nsneller_tenant_launches_total 1\n",
+		"\nsneller_dcache_misses_total{tenant=",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+	if strings.Contains(body, "sneller_query_errors_total{status=\"200\"}") {
+		t.Error("the successful query should not be counted as an error")
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
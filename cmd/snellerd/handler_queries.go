@@ -0,0 +1,209 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SnellerInc/sneller/audit"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+// runningQuery is a query that
+// is currently being executed
+type runningQuery struct {
+	ID     string
+	Tenant string
+	Query  string // redacted query text
+	Start  time.Time
+	Peers  []string
+
+	id  tnproto.ID
+	key tnproto.Key
+
+	lock     sync.Mutex
+	rc       io.Closer // tenant error pipe
+	abort    func()    // if non-nil, cancels a query waiting to run
+	canceled bool
+}
+
+// attach sets the tenant error pipe of the query;
+// closing the pipe cancels the query execution
+func (q *runningQuery) attach(rc io.Closer) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.rc = rc
+	if q.canceled {
+		rc.Close()
+	}
+}
+
+// cancel cancels the query; the tenant process
+// propagates the cancellation to each of the
+// peers executing part of the query
+func (q *runningQuery) cancel() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.canceled = true
+	if q.abort != nil {
+		q.abort()
+	}
+	if q.rc != nil {
+		q.rc.Close()
+	}
+}
+
+func (q *runningQuery) isCanceled() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.canceled
+}
+
+// queryRegistry tracks running queries by ID
+type queryRegistry struct {
+	lock    sync.Mutex
+	running map[string]*runningQuery
+}
+
+func (r *queryRegistry) add(q *runningQuery) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.running == nil {
+		r.running = make(map[string]*runningQuery)
+	}
+	r.running[q.ID] = q
+}
+
+func (r *queryRegistry) remove(q *runningQuery) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.running, q.ID)
+}
+
+func (r *queryRegistry) get(id string) *runningQuery {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.running[id]
+}
+
+// list returns the queries of the
+// given tenant ordered by start time
+func (r *queryRegistry) list(tenant string) []*runningQuery {
+	r.lock.Lock()
+	var lst []*runningQuery
+	for _, q := range r.running {
+		if q.Tenant == tenant {
+			lst = append(lst, q)
+		}
+	}
+	r.lock.Unlock()
+	slices.SortFunc(lst, func(a, b *runningQuery) int {
+		return a.Start.Compare(b.Start)
+	})
+	return lst
+}
+
+type queryStatus struct {
+	ID           string    `json:"id"`
+	Tenant       string    `json:"tenant"`
+	Query        string    `json:"query"`
+	Start        time.Time `json:"start"`
+	BytesScanned int64     `json:"bytes_scanned"`
+	Peers        []string  `json:"peers,omitempty"`
+}
+
+// example invocation:
+// curl -v -H 'Authorization: sneller' 'http://localhost:8080/queries'
+func (s *server) queriesHandler(w http.ResponseWriter, r *http.Request) {
+	creds, err := s.getTenant(r.Context(), w, r)
+	if err != nil {
+		return
+	}
+	running := s.queries.list(creds.ID())
+
+	// ask the tenant process(es) for the
+	// number of bytes scanned so far
+	type procID struct {
+		id  tnproto.ID
+		key tnproto.Key
+	}
+	scanned := make(map[string]int64)
+	seen := make(map[procID]bool)
+	for _, q := range running {
+		pid := procID{q.id, q.key}
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		lst, err := s.manager.Queries(q.id, q.key)
+		if err != nil {
+			s.logger.Printf("tenant %s: reading query progress: %s", q.Tenant, err)
+			continue
+		}
+		for i := range lst {
+			scanned[lst[i].ID] = lst[i].BytesScanned
+		}
+	}
+
+	ret := make([]queryStatus, len(running))
+	for i, q := range running {
+		ret[i] = queryStatus{
+			ID:           q.ID,
+			Tenant:       q.Tenant,
+			Query:        q.Query,
+			Start:        q.Start.UTC(),
+			BytesScanned: scanned[q.ID],
+			Peers:        q.Peers,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(ret)
+}
+
+// example invocation:
+// curl -v -X DELETE -H 'Authorization: sneller' 'http://localhost:8080/queries/<query-id>'
+func (s *server) cancelQueryHandler(w http.ResponseWriter, r *http.Request) {
+	creds, err := s.getTenant(r.Context(), w, r)
+	if err != nil {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/queries/")
+	qw := &queryWriter{ResponseWriter: w}
+	w = qw
+	defer s.record(qw, r, &audit.Event{
+		Action:  audit.ActionCancelQuery,
+		Tenant:  creds.ID(),
+		QueryID: id,
+	})
+	q := s.queries.get(id)
+	// don't disclose the queries of other tenants
+	if id == "" || q == nil || q.Tenant != creds.ID() {
+		http.Error(w, "no such query", http.StatusNotFound)
+		return
+	}
+	q.cancel()
+	s.logger.Printf("tenant %s query ID %s canceled by request", q.Tenant, q.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
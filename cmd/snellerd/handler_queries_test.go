@@ -0,0 +1,107 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueries(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	// register a query that is "running"
+	// until its error pipe is closed
+	here, there := net.Pipe()
+	defer there.Close()
+	q := &runningQuery{
+		ID:     "query-1",
+		Tenant: tt.ID(),
+		Query:  `SELECT * FROM "default".parking`,
+		Start:  time.Now(),
+		Peers:  []string{"127.0.0.1:9000"},
+	}
+	q.attach(here)
+	s.queries.add(q)
+	s.queries.add(&runningQuery{ID: "query-2", Tenant: "other-tenant", Start: time.Now()})
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(method, uri string) *http.Response {
+		t.Helper()
+		req := rq.get(uri)
+		req.Method = method
+		req.Header.Set("Authorization", "Bearer snellerd-test")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := do(http.MethodGet, "/queries")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("get /queries: %s", res.Status)
+	}
+	var lst []queryStatus
+	if err := json.NewDecoder(res.Body).Decode(&lst); err != nil {
+		t.Fatal(err)
+	}
+	if len(lst) != 1 {
+		t.Fatalf("expected only the query of the tenant; got %+v", lst)
+	}
+	if lst[0].ID != q.ID || lst[0].Query != q.Query || len(lst[0].Peers) != 1 || lst[0].Peers[0] != q.Peers[0] {
+		t.Errorf("unexpected query %+v", lst[0])
+	}
+
+	for _, id := range []string{"query-2", "no-such-query", ""} {
+		if res := do(http.MethodDelete, "/queries/"+id); res.StatusCode != http.StatusNotFound {
+			t.Errorf("delete %q: got status %s", id, res.Status)
+		}
+	}
+	if res := do(http.MethodDelete, "/queries/query-1"); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: got status %s", res.Status)
+	}
+	if !q.isCanceled() {
+		t.Error("query not canceled")
+	}
+	// the error pipe should be closed
+	there.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := there.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF reading the error pipe, got %v", err)
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -22,6 +23,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"mime"
 	"net"
 	"net/http"
 	"strconv"
@@ -46,6 +48,11 @@ import (
 // without doing any scanning.
 const DefaultMaxScan = 0
 
+// retryAfter is the Retry-After header (in seconds)
+// sent with 429 responses when a query is rejected
+// because the tenant or the node is overloaded.
+const retryAfter = "1"
+
 type errPlanLimit struct {
 	scan, max uint64
 }
@@ -82,6 +89,149 @@ func setTiming(w http.ResponseWriter, elapsed time.Duration, stats *plan.ExecSta
 		float64(elapsed)/float64(time.Millisecond), stats.CacheMisses, stats.CacheHits, stats.BytesScanned))
 }
 
+// tenantProc returns the ID and the key
+// of the tenant process of the tenant
+func tenantProc(creds db.Tenant) (tnproto.ID, tnproto.Key) {
+	var id tnproto.ID
+	var key tnproto.Key
+	tenantID := creds.ID()
+	hash := sha256.Sum256([]byte(tenantID))
+	copy(id[:], hash[:])
+	hash = sha256.Sum256([]byte(tenantID + string(creds.Key()[:])))
+	copy(key[:], hash[:])
+	return id, key
+}
+
+// tenantLimits returns the scan limit
+// and the admission limits of the tenant
+func tenantLimits(creds db.Tenant) (uint64, tenant.Limits) {
+	maxScan := uint64(DefaultMaxScan)
+	var limits tenant.Limits
+	if ct, ok := creds.(db.TenantConfigurable); ok {
+		if cfg := ct.Config(); cfg != nil {
+			if cfg.MaxScanBytes > 0 {
+				maxScan = cfg.MaxScanBytes
+			}
+			limits.MaxQueries = cfg.MaxConcurrentQueries
+			limits.MaxBytes = cfg.MaxBytesInFlight
+			limits.Weight = cfg.Weight
+		}
+	}
+	return maxScan, limits
+}
+
+// parseQuery parses the query in the body of a request.
+// A body of type application/json or application/ion is a
+// document with the query text in the "query" field and the
+// values of the query parameters in the "params" field,
+// which is either a list (for positional parameters)
+// or a struct (for named parameters); the parameters
+// are bound while the query is parsed.
+// Any other body is just the query text.
+func parseQuery(contentType string, body []byte) (*expr.Query, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	var st ion.Symtab
+	var doc ion.Datum
+	var err error
+	switch mt {
+	case "application/json":
+		doc, err = ion.FromJSON(&st, json.NewDecoder(bytes.NewReader(body)))
+	case "application/ion":
+		doc, _, err = ion.ReadDatum(&st, body)
+	default:
+		return partiql.Parse(body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode query document: %w", err)
+	}
+	s, err := doc.Struct()
+	if err != nil {
+		return nil, fmt.Errorf("query document: %w", err)
+	}
+	f, ok := s.FieldByName("query")
+	if !ok {
+		return nil, errors.New("query document: missing \"query\" field")
+	}
+	text, err := f.String()
+	if err != nil {
+		return nil, fmt.Errorf("query document: \"query\": %w", err)
+	}
+	params := &expr.Params{}
+	if f, ok := s.FieldByName("params"); ok {
+		err = decodeParams(f.Datum, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return partiql.ParseParams([]byte(text), params)
+}
+
+func decodeParams(d ion.Datum, params *expr.Params) error {
+	constant := func(d ion.Datum) (expr.Constant, error) {
+		c, ok := expr.AsConstant(d)
+		if !ok {
+			return nil, fmt.Errorf("cannot use %s value as a query parameter", d.Type())
+		}
+		return c, nil
+	}
+	switch d.Type() {
+	case ion.ListType:
+		l, _ := d.List()
+		return l.Each(func(d ion.Datum) error {
+			c, err := constant(d)
+			if err != nil {
+				return err
+			}
+			params.Positional = append(params.Positional, c)
+			return nil
+		})
+	case ion.StructType:
+		s, _ := d.Struct()
+		params.Named = make(map[string]expr.Constant)
+		return s.Each(func(f ion.Field) error {
+			c, err := constant(f.Datum)
+			if err != nil {
+				return fmt.Errorf("parameter %q: %w", f.Label, err)
+			}
+			params.Named[f.Label] = c
+			return nil
+		})
+	default:
+		return fmt.Errorf("query parameters must be a list or a struct, not %s", d.Type())
+	}
+}
+
+// plan plans the query, splitting it
+// across endPoints if there are any
+func (s *server) plan(q *expr.Query, env *sneller.FSEnv, id tnproto.ID, key tnproto.Key, endPoints []*net.TCPAddr) (*plan.Tree, error) {
+	var tree *plan.Tree
+	var err error
+	if len(endPoints) == 0 {
+		tree, err = plan.New(q, env)
+	} else {
+		splitter := s.newSplitter(id, key, endPoints)
+		tree, err = plan.NewSplit(q, struct {
+			*sneller.FSEnv
+			*sneller.Splitter
+		}{env, splitter})
+	}
+	if err != nil {
+		return nil, err
+	}
+	// TODO: clean this up
+	if enc, ok := env.Root.(interface {
+		Encode(*ion.Buffer, *ion.Symtab) error
+	}); ok {
+		var buf ion.Buffer
+		var st ion.Symtab
+		if err := enc.Encode(&buf, &st); err != nil {
+			return nil, fmt.Errorf("encoding file system: %w", err)
+		}
+		tree.Data, _, _ = ion.ReadDatum(&st, buf.Bytes())
+	}
+	return tree, nil
+}
+
 // after 15 minutes, stop waiting for a result
 // and SIGQUIT the child process
 const queryKillTimeout = 15 * time.Minute
@@ -90,6 +240,15 @@ const queryKillTimeout = 15 * time.Minute
 // curl -v -H 'Authorization: sneller' -H 'Accept: application/ion' 'http://localhost:8080/query?database=sf1-new&query=SELECT%20%2A%20FROM%20nation%20LIMIT%2010'
 // curl -v -X POST -H 'Authorization: sneller' -H 'Accept: application/ion' --data-raw 'SELECT * FROM nation LIMIT 10' 'http://localhost:8080/query?database=sf1-new'
 func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
+	qw := &queryWriter{ResponseWriter: w}
+	start := time.Now()
+	s.query(qw, r)
+	elapsed := time.Since(start)
+	s.metrics.observe(qw, elapsed)
+	s.recordQuery(qw, r, elapsed)
+}
+
+func (s *server) query(w *queryWriter, r *http.Request) {
 	ctx := r.Context()
 	start := time.Now()
 	creds, err := s.getTenant(ctx, w, r)
@@ -98,6 +257,7 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	authElapsed := time.Since(start)
 	tenantID := creds.ID()
+	w.tenant = tenantID
 
 	isHeadRequest := r.Method == http.MethodHead
 
@@ -157,7 +317,7 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	defaultDatabase := r.URL.Query().Get("database")
-	parsedQuery, err := partiql.Parse(query)
+	parsedQuery, err := parseQuery(r.Header.Get("Content-Type"), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -169,23 +329,11 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	normalized := parsedQuery.Text()
-	redacted := parsedQuery.Text()
+	redacted := parsedQuery.Redacted()
+	w.query = redacted
 
-	var id tnproto.ID
-	var key tnproto.Key
-	hash := sha256.Sum256([]byte(tenantID))
-	copy(id[:], hash[:])
-	hash = sha256.Sum256([]byte(tenantID + string(creds.Key()[:])))
-	copy(key[:], hash[:])
-
-	// determine scan limit
-	maxScan := uint64(DefaultMaxScan)
-	if ct, ok := creds.(db.TenantConfigurable); ok {
-		cfg := ct.Config()
-		if cfg != nil && cfg.MaxScanBytes > 0 {
-			maxScan = cfg.MaxScanBytes
-		}
-	}
+	id, key := tenantProc(creds)
+	maxScan, limits := tenantLimits(creds)
 
 	planEnv, err := sneller.Environ(creds, defaultDatabase)
 	if err != nil {
@@ -193,45 +341,34 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 		s.logger.Printf("refusing query: %s", err)
 		return
 	}
+	if snapshot := r.URL.Query().Get("snapshot"); snapshot != "" {
+		if !db.ValidSnapshotID(snapshot) {
+			http.Error(w, "invalid snapshot id", http.StatusBadRequest)
+			return
+		}
+		planEnv.Snapshot = snapshot
+		planEnv.Pin = r.URL.Query().Has("pin")
+	}
 	endPoints := s.peers.Get()
 
 	queryID := uuid.New().String()
 	w.Header().Add("X-Sneller-Query-ID", queryID)
+	w.id = queryID
 
-	var tree *plan.Tree
 	start = time.Now()
-	if len(endPoints) == 0 {
-		tree, err = plan.New(parsedQuery, planEnv)
-	} else {
-		splitter := s.newSplitter(id, key, endPoints)
-		tree, err = plan.NewSplit(parsedQuery, struct {
-			*sneller.FSEnv
-			*sneller.Splitter
-		}{planEnv, splitter})
-	}
+	tree, err := s.plan(parsedQuery, planEnv, id, key, endPoints)
+	w.tables = planEnv.Tables()
 	if err != nil {
 		s.logger.Printf("tenant %s query ID %s planning failed: %s", tenantID, queryID, err)
 		planError(w, err)
 		return
 	}
 	tree.ID = queryID
-	// TODO: clean this up
-	if enc, ok := planEnv.Root.(interface {
-		Encode(*ion.Buffer, *ion.Symtab) error
-	}); ok {
-		var buf ion.Buffer
-		var st ion.Symtab
-		if err := enc.Encode(&buf, &st); err != nil {
-			s.logger.Printf("tenant %s query ID %s encoding file system: %s", tenantID, queryID, err)
-			planError(w, err)
-			return
-		}
-		tree.Data, _, _ = ion.ReadDatum(&st, buf.Bytes())
-	}
 	willScan := uint64(tree.MaxScanned())
 	w.Header().Set("X-Sneller-Max-Scanned-Bytes", utoa(willScan))
 	if maxScan > 0 && willScan > maxScan {
-		planError(w, &errPlanLimit{scan: willScan, max: maxScan})
+		w.err = &errPlanLimit{scan: willScan, max: maxScan}
+		planError(w, w.err)
 		return
 	}
 	s.logger.Printf("tenant %s query ID %s auth %s planning %s", tenantID, queryID, authElapsed, time.Since(start))
@@ -291,20 +428,57 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Trailer", "Server-Timing")
 	}
 
+	// wait for the tenant and the node
+	// to have capacity for the query
+	startwait := time.Now()
+	release, err := s.manager.Admit(r.Context(), id, &limits, willScan)
+	if err != nil {
+		w.err = err
+		if errors.Is(err, tenant.ErrQueueFull) {
+			w.Header().Del("Trailer")
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, "too many queries", http.StatusTooManyRequests)
+			s.logger.Printf("tenant %s query ID %s rejected: %s", tenantID, queryID, err)
+		} else {
+			s.logger.Printf("tenant %s query ID %s canceled while queued after %s", tenantID, queryID, time.Since(startwait))
+		}
+		return
+	}
+	defer release()
+	if waited := time.Since(startwait); waited >= time.Millisecond {
+		s.logger.Printf("tenant %s query ID %s queued for %s", tenantID, queryID, waited)
+	}
+
 	conn := &delayedHijack{
 		laddr: s.bound,
 		req:   r,
 		res:   w,
 	}
 	startrun := time.Now()
+	running := &runningQuery{
+		ID:     queryID,
+		Tenant: tenantID,
+		Query:  parsedQuery.Redacted(),
+		Start:  startrun,
+		id:     id,
+		key:    key,
+	}
+	for i := range endPoints {
+		running.Peers = append(running.Peers, endPoints[i].String())
+	}
+	s.queries.add(running)
+	defer s.queries.remove(running)
+	defer conn.abort()
 	rc, err := s.manager.Do(id, key, tree, encodingFormat, conn)
 	if err != nil {
+		conn.abort()
 		if !conn.hijacked {
 			// didn't call w.WriteHeader() yet;
 			// we can write a plaintext error
 			w.Header().Del("Trailer")
 			w.Header().Set("Content-Type", "text/plain")
 			if errors.Is(err, tenant.ErrOverloaded) {
+				w.Header().Set("Retry-After", retryAfter)
 				w.WriteHeader(http.StatusTooManyRequests)
 			} else {
 				w.WriteHeader(http.StatusInternalServerError)
@@ -317,9 +491,12 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 				writeError(w, "error dispatching query")
 			}
 		}
+		w.failed = true
+		w.err = err
 		s.logger.Printf("tenant %s query ID %s %q execution failed (do): %v", tenantID, queryID, redacted, err)
 		return
 	}
+	running.attach(rc)
 	go func() {
 		<-r.Context().Done()
 		rc.Close()
@@ -329,19 +506,25 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	deadlined := setDeadline(rc, queryKillTimeout)
 	err = tenant.Check(rc, &stats)
 	if err != nil {
+		conn.abort()
 		canceled := false
 		if ctxerr := r.Context().Err(); ctxerr != nil {
 			// see if we got an error due to cancellation
 			err = ctxerr
 			canceled = true
+		} else if running.isCanceled() {
+			// canceled with DELETE /queries/{id}
+			canceled = true
 		}
 		if sendTrailer {
 			setError(w)
 		}
+		w.err = err
 		if canceled {
 			s.logger.Printf("tenant %s query ID %s canceled after %s", tenantID, queryID, time.Since(startrun))
 			return
 		}
+		w.failed = true
 		s.logger.Printf("tenant %s query ID %s %q execution failed (check): %v", tenantID, queryID, redacted, err)
 		if deadlined && isTimeout(err) {
 			s.logger.Printf("tenant %s query ID %s killing tenant worker %s due to timeout", tenantID, queryID, id)
@@ -349,6 +532,8 @@ func (s *server) queryHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	conn.wait()
+	w.stats = &stats
 	elapsed := time.Since(startrun)
 	if sendTrailer {
 		setTiming(w, elapsed, &stats)
@@ -425,9 +610,15 @@ func isBadQuery(err error, w http.ResponseWriter) bool {
 //
 // type and syntax errors are returned as 400,
 // fs.ErrNotExist errors are returned as 404,
+// db.ErrNoSnapshot errors are returned as 410,
 // and others are returned as 500
 func planError(w http.ResponseWriter, err error) {
 	w.Header().Set("Content-Type", "text/plain")
+	if errors.Is(err, db.ErrNoSnapshot) {
+		w.WriteHeader(http.StatusGone)
+		io.WriteString(w, "snapshot does not exist or has expired\n")
+		return
+	}
 	if errors.Is(err, fs.ErrNotExist) {
 		w.WriteHeader(http.StatusNotFound)
 		io.WriteString(w, "table does not exist\n")
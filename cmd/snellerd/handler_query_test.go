@@ -0,0 +1,119 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/SnellerInc/sneller/db"
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+)
+
+func TestQuerySnapshot(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	const text = "SELECT COUNT(*) AS n FROM parking2"
+	query := func(method, params string) (int, string) {
+		t.Helper()
+		req := rq.getQuery("default", text)
+		req.Method = method
+		req.URL.RawQuery += params
+		req.Header.Set("Accept", "application/x-ndjson")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	status, body := query(http.MethodHead, "&snapshot=s1&pin")
+	if status != http.StatusOK {
+		t.Fatalf("pinning snapshot: %d %s", status, body)
+	}
+	status, before := query(http.MethodGet, "")
+	if status != http.StatusOK {
+		t.Fatalf("%d %s", status, before)
+	}
+	status, body = query(http.MethodGet, "&snapshot=s2")
+	if status != http.StatusGone {
+		t.Errorf("querying missing snapshot: %d %s", status, body)
+	}
+	status, body = query(http.MethodGet, "&snapshot=../../index")
+	if status != http.StatusBadRequest {
+		t.Errorf("querying invalid snapshot: %d %s", status, body)
+	}
+
+	// add more rows to the table
+	root, err := tt.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.(db.OutputFS).WriteFile("a-prefix/parking4.json", []byte(`{"Ticket": 1, "Make": "NEW"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := db.Config{
+		Align:         testBlocksize,
+		RangeMultiple: 10,
+		Fallback: func(_ string) blockfmt.RowFormat {
+			return blockfmt.UnsafeION()
+		},
+	}
+	err = c.Sync(tt, "default", "parking2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, after := query(http.MethodGet, "")
+	if status != http.StatusOK {
+		t.Fatalf("%d %s", status, after)
+	}
+	if after == before {
+		t.Fatalf("count didn't change after ingest: %s", after)
+	}
+	status, body = query(http.MethodGet, "&snapshot=s1")
+	if status != http.StatusOK {
+		t.Fatalf("querying snapshot: %d %s", status, body)
+	}
+	if body != before {
+		t.Errorf("snapshot: got %s, want %s", body, before)
+	}
+}
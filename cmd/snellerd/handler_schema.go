@@ -0,0 +1,245 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/db"
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tenant"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+	"github.com/SnellerInc/sneller/usock"
+)
+
+// schemaSampleRows is the number of rows scanned
+// to determine the schema of a table when some of
+// its objects were written without a stored shape
+const schemaSampleRows = 100_000
+
+// schemaResponse is the response to /schema;
+// the per-field type counts use the same names
+// as the output of SNELLER_DATASHAPE(*)
+//
+// When some of the objects in the table have no
+// stored shape, the fields are determined by scanning
+// a sample of the table and Incomplete is set.
+type schemaResponse struct {
+	Database    string                      `json:"database"`
+	Table       string                      `json:"table"`
+	Total       int64                       `json:"total"`
+	Approximate bool                        `json:"approximate,omitempty"`
+	Truncated   bool                        `json:"truncated,omitempty"`
+	Incomplete  bool                        `json:"incomplete,omitempty"`
+	Fields      map[string]map[string]int64 `json:"fields"`
+	Distinct    map[string]int64            `json:"distinct,omitempty"`
+}
+
+func (s *server) schemaHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	tenant, err := s.getTenant(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	databaseName := r.URL.Query().Get("database")
+	if databaseName == "" {
+		http.Error(w, "no database", http.StatusBadRequest)
+		return
+	}
+	tableName := r.URL.Query().Get("table")
+	if tableName == "" {
+		http.Error(w, "no table", http.StatusBadRequest)
+		return
+	}
+
+	root, err := tenant.Root()
+	if err != nil {
+		http.Error(w, "couldn't open db+table", http.StatusInternalServerError)
+		return
+	}
+	idx, err := db.OpenIndex(root, databaseName, tableName, tenant.Key())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "no such table", http.StatusNotFound)
+			return
+		}
+		s.logger.Printf("handling /schema: OpenIndex: %s", err)
+		http.Error(w, "couldn't open index file", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	shape := idx.Shape()
+	out := schemaResponse{
+		Database:    databaseName,
+		Table:       tableName,
+		Total:       shape.Rows,
+		Approximate: shape.Approximate,
+		Truncated:   shape.Truncated,
+		Incomplete:  shape.Incomplete,
+	}
+	if shape.Incomplete {
+		err = s.sampleSchema(ctx, tenant, &out)
+		if err != nil {
+			s.logger.Printf("handling /schema: sampling %s.%s: %s", databaseName, tableName, err)
+			http.Error(w, "couldn't determine schema", http.StatusInternalServerError)
+			return
+		}
+		writeResultResponse(w, http.StatusOK, &out)
+		return
+	}
+	out.Fields = make(map[string]map[string]int64, len(shape.Fields))
+	for i := range shape.Fields {
+		f := &shape.Fields[i]
+		m := make(map[string]int64, len(f.Types))
+		for k, v := range f.Types {
+			m[k] = v
+		}
+		out.Fields[f.Name()] = m
+		if d := f.Distinct(); d > 0 {
+			if out.Distinct == nil {
+				out.Distinct = make(map[string]int64)
+			}
+			out.Distinct[f.Name()] = d
+		}
+	}
+	writeResultResponse(w, http.StatusOK, &out)
+}
+
+// sampleSchema fills in the fields of out by running
+// SNELLER_DATASHAPE(*) over the first schemaSampleRows
+// rows of the table
+func (s *server) sampleSchema(ctx context.Context, creds db.Tenant, out *schemaResponse) error {
+	text := fmt.Sprintf("WITH subset AS (SELECT * FROM %s.%s LIMIT %d) SELECT SNELLER_DATASHAPE(*) FROM subset",
+		expr.QuoteID(out.Database), expr.QuoteID(out.Table), schemaSampleRows)
+	q, err := partiql.Parse([]byte(text))
+	if err != nil {
+		return err
+	}
+	id, key := tenantProc(creds)
+	maxScan, limits := tenantLimits(creds)
+	env, err := sneller.Environ(creds, out.Database)
+	if err != nil {
+		return err
+	}
+	tree, err := s.plan(q, env, id, key, nil)
+	if err != nil {
+		return err
+	}
+	willScan := uint64(tree.MaxScanned())
+	if maxScan > 0 && willScan > maxScan {
+		return &errPlanLimit{scan: willScan, max: maxScan}
+	}
+	release, err := s.manager.Admit(ctx, id, &limits, willScan)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	here, there, err := usock.SocketPair()
+	if err != nil {
+		return err
+	}
+	defer here.Close()
+	rc, err := s.manager.Do(id, key, tree, tnproto.OutputRaw, there)
+	there.Close()
+	if err != nil {
+		return err
+	}
+	type output struct {
+		buf []byte
+		err error
+	}
+	outc := make(chan output, 1)
+	go func() {
+		buf, err := io.ReadAll(here)
+		outc <- output{buf, err}
+	}()
+	var stats plan.ExecStats
+	setDeadline(rc, queryKillTimeout)
+	err = tenant.Check(rc, &stats)
+	if err != nil {
+		if isTimeout(err) {
+			s.manager.Quit(id, key)
+		}
+		return err
+	}
+	res := <-outc
+	if res.err != nil {
+		return res.err
+	}
+	return readDatashape(res.buf, out)
+}
+
+// readDatashape reads the result of SNELLER_DATASHAPE(*)
+// into the total and fields of out
+func readDatashape(buf []byte, out *schemaResponse) error {
+	var st ion.Symtab
+	for len(buf) > 0 {
+		var d ion.Datum
+		var err error
+		d, buf, err = ion.ReadDatum(&st, buf)
+		if err != nil {
+			return err
+		}
+		if d.IsEmpty() || d.IsNull() {
+			continue // nop pad
+		}
+		if msg, err := d.Field("error").String(); err == nil {
+			return errors.New(msg)
+		}
+		out.Total, _ = d.Field("total").Int()
+		out.Approximate = true
+		out.Fields = make(map[string]map[string]int64)
+		fields, err := d.Field("fields").Struct()
+		if err != nil {
+			return err
+		}
+		return fields.Each(func(f ion.Field) error {
+			types, err := f.Struct()
+			if err != nil {
+				return err
+			}
+			m := make(map[string]int64)
+			types.Each(func(t ion.Field) error {
+				// the min/max statistics may be
+				// floats; keep only the integers
+				if n, err := t.Int(); err == nil {
+					m[t.Label] = n
+				}
+				return nil
+			})
+			out.Fields[f.Label] = m
+			return nil
+		})
+	}
+	return fmt.Errorf("no result in SNELLER_DATASHAPE output")
+}
@@ -19,11 +19,19 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/db"
 )
 
+type tableEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "table" or "view"
+}
+
 func (s *server) tablesHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -33,6 +41,13 @@ func (s *server) tablesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	databaseName := r.URL.Query().Get("database")
+	qw := &queryWriter{ResponseWriter: w}
+	w = qw
+	defer s.record(qw, r, &audit.Event{
+		Action:   audit.ActionListTables,
+		Tenant:   tenant.ID(),
+		Database: databaseName,
+	})
 	if databaseName == "" {
 		http.Error(w, "no database", http.StatusBadRequest)
 		return
@@ -46,7 +61,12 @@ func (s *server) tablesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	tables, err := db.Tables(e.Root, databaseName)
+	var views []string
+	if err == nil {
+		views, err = db.Views(e.Root, databaseName)
+	}
 	if err != nil {
+		qw.err = err
 		if errors.Is(err, fs.ErrNotExist) {
 			http.Error(w, "no such database", http.StatusNotFound)
 			return
@@ -60,11 +80,28 @@ func (s *server) tablesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	out := make([]string, 0)
+	entries := make([]tableEntry, 0, len(tables)+len(views))
 	for i := range tables {
-		if pattern == "" || matchPattern(tables[i], pattern) {
-			out = append(out, tables[i])
-		}
+		entries = append(entries, tableEntry{Name: tables[i], Type: "table"})
+	}
+	for i := range views {
+		entries = append(entries, tableEntry{Name: views[i], Type: "view"})
+	}
+	slices.SortFunc(entries, func(a, b tableEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	entries = slices.DeleteFunc(entries, func(t tableEntry) bool {
+		return pattern != "" && !matchPattern(t.Name, pattern)
+	})
+	// with ?detailed, each entry
+	// includes the type of the table
+	if r.URL.Query().Has("detailed") {
+		writeResultResponse(w, http.StatusOK, entries)
+		return
+	}
+	out := make([]string, 0, len(entries))
+	for i := range entries {
+		out = append(out, entries[i].Name)
 	}
 	writeResultResponse(w, http.StatusOK, out)
 }
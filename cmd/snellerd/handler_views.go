@@ -0,0 +1,155 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/audit"
+	"github.com/SnellerInc/sneller/db"
+)
+
+type viewDefinition struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Query    string `json:"query"`
+}
+
+// viewHandler gets (GET), creates or replaces (POST)
+// and deletes (DELETE) the view given by the
+// database and view query parameters;
+// the body of a POST request is the query text
+func (s *server) viewHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenant, err := s.getTenant(ctx, w, r)
+	if err != nil {
+		return
+	}
+	dbname := r.URL.Query().Get("database")
+	if dbname == "" {
+		http.Error(w, "no database", http.StatusBadRequest)
+		return
+	}
+	view := r.URL.Query().Get("view")
+	qw := &queryWriter{ResponseWriter: w}
+	w = qw
+	// changes to views are audited
+	action := ""
+	switch r.Method {
+	case http.MethodPost:
+		action = audit.ActionCreateView
+	case http.MethodDelete:
+		action = audit.ActionDropView
+	}
+	if action != "" {
+		defer s.record(qw, r, &audit.Event{
+			Action:   action,
+			Tenant:   tenant.ID(),
+			Database: dbname,
+			Table:    view,
+		})
+	}
+	if view == "" {
+		http.Error(w, "no view", http.StatusBadRequest)
+		return
+	}
+	if !fs.ValidPath(view) || path.Base(view) != view {
+		http.Error(w, "invalid view name", http.StatusBadRequest)
+		return
+	}
+	root, err := tenant.Root()
+	if err != nil {
+		s.logger.Printf("tenant %s: view: %s", tenant.ID(), err)
+		http.Error(w, "cannot access tenant storage", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		v, err := db.OpenView(root, dbname, view)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				http.Error(w, "no such view", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "cannot read view", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeResultResponse(w, http.StatusOK, &viewDefinition{
+			Database: dbname,
+			Name:     view,
+			Query:    v.Query,
+		})
+	case http.MethodPost:
+		ofs, ok := root.(db.OutputFS)
+		if !ok {
+			http.Error(w, "views require writable storage", http.StatusNotImplemented)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, 1024*1024)
+		query, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, "cannot read query", http.StatusBadRequest)
+			return
+		}
+		_, err = sneller.ParseView(dbname, string(query))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = db.WriteView(ofs, dbname, view, &db.View{Query: string(query)})
+		if err != nil {
+			qw.err = err
+			if errors.Is(err, db.ErrTableExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			s.logger.Printf("tenant %s: writing view %s.%s: %s", tenant.ID(), dbname, view, err)
+			http.Error(w, "cannot write view", http.StatusInternalServerError)
+			return
+		}
+		writeResultResponse(w, http.StatusOK, &viewDefinition{
+			Database: dbname,
+			Name:     view,
+			Query:    string(query),
+		})
+	case http.MethodDelete:
+		rfs, ok := root.(db.RemoveFS)
+		if !ok {
+			http.Error(w, "views require writable storage", http.StatusNotImplemented)
+			return
+		}
+		err := db.DeleteView(rfs, dbname, view)
+		if err != nil {
+			qw.err = err
+			if errors.Is(err, fs.ErrNotExist) {
+				http.Error(w, "no such view", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "cannot delete view", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
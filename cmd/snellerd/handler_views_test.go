@@ -0,0 +1,143 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestViews(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(req *http.Request) (*http.Response, string) {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer snellerd-test")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+	view := func(method, name, query string) (*http.Response, string) {
+		t.Helper()
+		req := rq.get("/view?database=default&view=" + name)
+		req.Method = method
+		req.Body = io.NopCloser(strings.NewReader(query))
+		return do(req)
+	}
+	query := func(text string) string {
+		t.Helper()
+		req := rq.getQuery("", text)
+		req.Header.Set("Accept", "application/x-ndjson")
+		res, body := do(req)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s %s", text, res.Status, body)
+		}
+		return body
+	}
+
+	const def = "SELECT Make, Color, Fine FROM parking WHERE Fine > 50"
+	res, body := view(http.MethodPost, "expensive", def)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("creating view: %s %s", res.Status, body)
+	}
+	res, body = view(http.MethodGet, "expensive", "")
+	var got viewDefinition
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &got) != nil || got.Query != def {
+		t.Fatalf("get view: %s %s", res.Status, body)
+	}
+	// views can reference other views
+	res, body = view(http.MethodPost, "red", "SELECT Make, Fine FROM expensive WHERE Color = 'RE'")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("creating view: %s %s", res.Status, body)
+	}
+
+	// the view resolves parking in its own database
+	// regardless of the default database of the query
+	got0 := query("SELECT Make, SUM(Fine) AS total FROM default.red GROUP BY Make ORDER BY Make")
+	want := query("SELECT Make, SUM(Fine) AS total FROM default.parking WHERE Fine > 50 AND Color = 'RE' GROUP BY Make ORDER BY Make")
+	if got0 != want {
+		t.Errorf("got %s, want %s", got0, want)
+	}
+
+	res, body = do(rq.get("/tables?database=default&detailed"))
+	var entries []tableEntry
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &entries) != nil {
+		t.Fatalf("list tables: %s %s", res.Status, body)
+	}
+	types := make(map[string]string)
+	for i := range entries {
+		types[entries[i].Name] = entries[i].Type
+	}
+	if types["parking"] != "table" || types["expensive"] != "view" || types["red"] != "view" {
+		t.Errorf("unexpected tables %s", body)
+	}
+
+	for _, tc := range []struct {
+		name, query string
+		status      int
+	}{
+		{"parking", "SELECT * FROM taxi", http.StatusConflict},
+		{"bad", "SELECT * FROM", http.StatusBadRequest},
+		{"bad", "SELECT * INTO default.x FROM parking", http.StatusBadRequest},
+		{"a/b", "SELECT * FROM parking", http.StatusBadRequest},
+	} {
+		res, body := view(http.MethodPost, tc.name, tc.query)
+		if res.StatusCode != tc.status {
+			t.Errorf("creating view %s: %s %s", tc.name, res.Status, body)
+		}
+	}
+
+	res, body = view(http.MethodDelete, "red", "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete view: %s %s", res.Status, body)
+	}
+	res, _ = view(http.MethodGet, "red", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("get deleted view: %s", res.Status)
+	}
+	res, _ = view(http.MethodDelete, "red", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("delete deleted view: %s", res.Status)
+	}
+}
@@ -39,9 +39,10 @@ func (s *server) handle(handler func(http.ResponseWriter, *http.Request), method
 			forwarded = true
 		}
 		// unforwarded requests to "/"
-		// are just ELB heartbeats;
+		// are just ELB heartbeats and requests
+		// to "/metrics" are periodic scrapes;
 		// don't log these, as they spam the logs
-		if r.URL.Path != "/" || forwarded {
+		if (r.URL.Path != "/" && r.URL.Path != "/metrics") || forwarded {
 			s.logger.Printf("Request %s %s from %s", r.Method, r.URL.Path, remoteAddress)
 		}
 		if version != "" {
@@ -50,7 +51,7 @@ func (s *server) handle(handler func(http.ResponseWriter, *http.Request), method
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
 		w.Header().Set("Access-Control-Expose-Headers", "Etag, X-Sneller-Max-Scanned-Bytes, X-Sneller-Query-ID, X-Sneller-Total-Table-Bytes, X-Sneller-Version")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
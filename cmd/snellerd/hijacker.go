@@ -15,12 +15,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"strconv"
 	"syscall"
 	"time"
+
+	"github.com/SnellerInc/sneller/usock"
 )
 
 type delayedHijack struct {
@@ -28,6 +33,13 @@ type delayedHijack struct {
 	req      *http.Request
 	res      http.ResponseWriter
 	hijacked bool
+
+	// here and there are the ends of the
+	// socket pair through which the output of
+	// the tenant is relayed when the connection
+	// can't be passed to the tenant (see relay)
+	here, there *net.UnixConn
+	relayed     chan struct{}
 }
 
 type sysconn interface {
@@ -43,6 +55,9 @@ func (d *delayedHijack) SyscallConn() (syscall.RawConn, error) {
 	if !ok {
 		return nil, fmt.Errorf("no rawConn value?")
 	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return d.relay()
+	}
 	sc, ok := conn.(sysconn)
 	if !ok {
 		return nil, fmt.Errorf("can't use %T as sysconn", conn)
@@ -50,6 +65,64 @@ func (d *delayedHijack) SyscallConn() (syscall.RawConn, error) {
 	return sc.SyscallConn()
 }
 
+// relay returns one end of a socket pair
+// in place of the connection and copies the
+// output of the tenant from the other end to
+// the response, since the tenant can't perform
+// the encryption of a TLS connection itself
+func (d *delayedHijack) relay() (syscall.RawConn, error) {
+	here, there, err := usock.SocketPair()
+	if err != nil {
+		return nil, err
+	}
+	d.here, d.there = here, there
+	d.relayed = make(chan struct{})
+	go func() {
+		defer close(d.relayed)
+		// the tenant writes chunks, but the
+		// final chunk is written by the server
+		// (along with the trailers), so the chunked
+		// reader returns io.ErrUnexpectedEOF at the end
+		io.Copy(flushWriter{d.res}, httputil.NewChunkedReader(here))
+	}()
+	return there.SyscallConn()
+}
+
+// wait waits for the relayed output (if any)
+// to be written to the response; it should be
+// called once the tenant is done writing
+func (d *delayedHijack) wait() {
+	if d.relayed == nil {
+		return
+	}
+	d.there.Close()
+	<-d.relayed
+	d.here.Close()
+	d.relayed = nil
+}
+
+// abort is like wait, but it discards
+// the output that hasn't been relayed yet
+func (d *delayedHijack) abort() {
+	if d.relayed == nil {
+		return
+	}
+	d.here.Close()
+	d.there.Close()
+	<-d.relayed
+	d.relayed = nil
+}
+
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	flush(f.w)
+	return n, err
+}
+
 func (d *delayedHijack) Write(p []byte) (int, error) {
 	panic("not expecting Write to delayedHijack")
 }
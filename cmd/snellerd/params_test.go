@@ -0,0 +1,184 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SnellerInc/sneller/ion"
+)
+
+func TestQueryParams(t *testing.T) {
+	tt := testdirEnviron(t)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     noPeers{},
+		auth:      testAuth{tt},
+	}
+	httpsock := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	s.aboutToServe = wg.Done
+	go s.Serve(httpsock, nil)
+	wg.Wait()
+	defer s.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "http://" + httpsock.Addr().String(),
+	}
+	do := func(req *http.Request) (*http.Response, string) {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer snellerd-test")
+		req.Header.Set("Accept", "application/x-ndjson")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+	post := func(contentType string, body []byte) (*http.Response, string) {
+		t.Helper()
+		req := rq.get("/query?database=default")
+		req.Method = http.MethodPost
+		req.Header.Set("Content-Type", contentType)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return do(req)
+	}
+
+	const literal = "SELECT Make, COUNT(*) AS n FROM parking WHERE Make IN ('HOND', 'TOYT') AND Fine > 50 GROUP BY Make ORDER BY Make"
+	wantres, want := do(rq.getQuery("default", literal))
+	if wantres.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", wantres.Status, want)
+	}
+
+	var st ion.Symtab
+	var ibuf ion.Buffer
+	ibuf.BeginStruct(-1)
+	ibuf.BeginField(st.Intern("query"))
+	ibuf.WriteString("SELECT Make, COUNT(*) AS n FROM parking WHERE Make IN (:a, :b) AND Fine > :fine GROUP BY Make ORDER BY Make")
+	ibuf.BeginField(st.Intern("params"))
+	ibuf.BeginStruct(-1)
+	ibuf.BeginField(st.Intern("a"))
+	ibuf.WriteString("HOND")
+	ibuf.BeginField(st.Intern("b"))
+	ibuf.WriteString("TOYT")
+	ibuf.BeginField(st.Intern("fine"))
+	ibuf.WriteInt(50)
+	ibuf.EndStruct()
+	ibuf.EndStruct()
+	var doc ion.Buffer
+	st.Marshal(&doc, true)
+	doc.UnsafeAppend(ibuf.Bytes())
+
+	for _, tc := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/json", []byte(`{"query": "SELECT Make, COUNT(*) AS n FROM parking WHERE Make IN (?, ?) AND Fine > ? GROUP BY Make ORDER BY Make", "params": ["HOND", "TOYT", 50]}`)},
+		{"application/json; charset=utf-8", []byte(`{"query": "SELECT Make, COUNT(*) AS n FROM parking WHERE Make IN ($2, $1) AND Fine > $3 GROUP BY Make ORDER BY Make", "params": ["TOYT", "HOND", 50]}`)},
+		{"application/ion", doc.Bytes()},
+	} {
+		res, got := post(tc.contentType, tc.body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s %s", tc.body, res.Status, got)
+		}
+		if got != want {
+			t.Errorf("%s: got %s, want %s", tc.body, got, want)
+		}
+		// the bound values are visible to the planner
+		scanned, wantScanned := res.Header.Get("X-Sneller-Max-Scanned-Bytes"), wantres.Header.Get("X-Sneller-Max-Scanned-Bytes")
+		if scanned != wantScanned {
+			t.Errorf("%s: scanning %s bytes, want %s", tc.body, scanned, wantScanned)
+		}
+	}
+
+	// parameters can be used where
+	// the grammar requires a literal
+	for _, tc := range []struct {
+		literal, query, params string
+	}{
+		{
+			literal: "SELECT COUNT(*) AS n FROM parking WHERE Make LIKE 'HO%'",
+			query:   "SELECT COUNT(*) AS n FROM parking WHERE Make LIKE ?",
+			params:  `["HO%"]`,
+		},
+		{
+			literal: "SELECT COUNT(*) AS n FROM parking WHERE Make NOT ILIKE 'toy%' ESCAPE '!'",
+			query:   "SELECT COUNT(*) AS n FROM parking WHERE Make NOT ILIKE :pattern ESCAPE :escape",
+			params:  `{"pattern": "toy%", "escape": "!"}`,
+		},
+		{
+			literal: "SELECT COUNT(*) AS n FROM parking WHERE Make IN ('HOND')",
+			query:   "SELECT COUNT(*) AS n FROM parking WHERE Make IN (?)",
+			params:  `["HOND"]`,
+		},
+		{
+			literal: "SELECT [Make, Color][1] AS c, COUNT(*) AS n FROM parking GROUP BY [Make, Color][1] ORDER BY c",
+			query:   "SELECT [Make, Color][$1] AS c, COUNT(*) AS n FROM parking GROUP BY [Make, Color][$1] ORDER BY c",
+			params:  `[1]`,
+		},
+	} {
+		res, want := do(rq.getQuery("default", tc.literal))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s %s", tc.literal, res.Status, want)
+		}
+		body, _ := json.Marshal(map[string]any{"query": tc.query, "params": json.RawMessage(tc.params)})
+		res, got := post("application/json", body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s %s", body, res.Status, got)
+		}
+		if got != want {
+			t.Errorf("%s: got %s, want %s", body, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		body, match string
+	}{
+		{`{"query": "SELECT * FROM parking WHERE Make = ?", "params": []}`, "no value for parameter $1"},
+		{`{"query": "SELECT * FROM parking WHERE Make = :make", "params": {"model": "x"}}`, "no value for parameter :make"},
+		{`{"query": "SELECT * FROM parking WHERE Make = ?", "params": "HOND"}`, "must be a list or a struct"},
+		{`{"params": []}`, "missing \"query\" field"},
+		{`{"query": "SELECT * FROM parking`, "cannot decode query document"},
+	} {
+		res, got := post("application/json", []byte(tc.body))
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %s %s", tc.body, res.Status, got)
+			continue
+		}
+		if !strings.Contains(got, tc.match) {
+			t.Errorf("%s: error %q doesn't contain %q", tc.body, got, tc.match)
+		}
+	}
+	// a query with unbound parameters is rejected
+	res, got := do(rq.getQuery("default", "SELECT * FROM parking WHERE Make = ?"))
+	if res.StatusCode != http.StatusBadRequest || !strings.Contains(got, "parameter $1 is not bound") {
+		t.Errorf("unbound parameter: %s %s", res.Status, got)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/SnellerInc/sneller/tenant/tnproto"
+	"github.com/SnellerInc/sneller/tlsconf"
 )
 
 const cmdTimeout = 30 * time.Second
@@ -49,6 +50,9 @@ type peerCmd struct {
 	ticker *time.Ticker
 	logf   func(f string, args ...interface{})
 	stop   chan struct{}
+	// tls, if non-nil, is the TLS configuration
+	// used to connect to (and ping) peers
+	tls *tlsconf.Options
 }
 
 type peerDesc struct {
@@ -123,9 +127,6 @@ func (p *peerCmd) run() error {
 		return err
 	}
 	lst := make([]*net.TCPAddr, 0, len(ret.Peers))
-	dl := net.Dialer{
-		Timeout: time.Second,
-	}
 	for i := range ret.Peers {
 		addr := ret.Peers[i].Addr
 		host, port, err := net.SplitHostPort(addr)
@@ -141,7 +142,13 @@ func (p *peerCmd) run() error {
 			return fmt.Errorf("couldn't parse peer %d IP: %w", i, err)
 		}
 		tcpaddr := &net.TCPAddr{IP: ip, Port: portnum}
-		conn, err := dl.Dial("tcp", tcpaddr.String())
+		remote := &tnproto.Remote{
+			Net:     "tcp",
+			Addr:    tcpaddr.String(),
+			Timeout: time.Second,
+			TLS:     p.tls,
+		}
+		conn, err := remote.Dial(ctx)
 		if err != nil {
 			p.logf("discarding peer %s: %s", addr, err)
 			continue
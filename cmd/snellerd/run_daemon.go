@@ -16,6 +16,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"log"
 	"net"
@@ -25,9 +26,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/auth"
 	"github.com/SnellerInc/sneller/debug"
 	"github.com/SnellerInc/sneller/tenant"
+	"github.com/SnellerInc/sneller/tlsconf"
 )
 
 func runDaemon(args []string) {
@@ -35,12 +38,24 @@ func runDaemon(args []string) {
 	log.Default().SetOutput(os.Stdout)
 
 	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
-	authEndpoint := daemonCmd.String("a", "", "authorization specification (file://, http://, https://, empty uses environment)")
+	authEndpoint := daemonCmd.String("a", "", "authorization specification (file://, http://, https://, jwt://, empty uses environment)")
 	daemonEndpoint := daemonCmd.String("e", "127.0.0.1:8000", "endpoint to listen on (REST API)")
 	remoteEndpoint := daemonCmd.String("r", "127.0.0.1:9000", "endpoint to listen on for remote requests (inter-node)")
 	cgroupRoot := daemonCmd.String("cgroot", "", "delegated cgroup root for tenant processes")
 	peerExec := daemonCmd.String("x", "", "command to exec for fetching peers")
 	debugSock := daemonCmd.Int("debug", -1, "file descriptor to listen on for pprof debug activity")
+	maxRunning := daemonCmd.Int("max-queries", 0, "maximum number of queries running concurrently (0 means no limit)")
+	maxQueued := daemonCmd.Int("max-queued", tenant.DefaultMaxQueued, "maximum number of queries waiting to run")
+	asyncTTL := daemonCmd.Duration("async-ttl", DefaultAsyncTTL, "amount of time for which asynchronous query results are kept")
+	auditDest := daemonCmd.String("audit", "", "audit log destination (file path, directory path ending in / for rotating files, or - for stdout)")
+	var publicTLS, peerTLS tlsconf.Options
+	daemonCmd.StringVar(&publicTLS.Cert, "tls-cert", "", "TLS certificate file for the REST API endpoint")
+	daemonCmd.StringVar(&publicTLS.Key, "tls-key", "", "TLS key file for the REST API endpoint")
+	daemonCmd.StringVar(&publicTLS.CA, "tls-ca", "", "CA file for verifying client certificates on the REST API endpoint (enables mutual TLS)")
+	daemonCmd.StringVar(&peerTLS.Cert, "peer-tls-cert", "", "TLS certificate file for the inter-node endpoint and peer connections")
+	daemonCmd.StringVar(&peerTLS.Key, "peer-tls-key", "", "TLS key file for the inter-node endpoint and peer connections")
+	daemonCmd.StringVar(&peerTLS.CA, "peer-tls-ca", "", "CA file for verifying peer certificates (enables mutual TLS)")
+	daemonCmd.StringVar(&peerTLS.ServerName, "peer-tls-server-name", "", "server name expected in peer certificates (defaults to the peer host)")
 
 	if daemonCmd.Parse(args) != nil {
 		os.Exit(1)
@@ -63,11 +78,22 @@ func runDaemon(args []string) {
 		sandbox:   tenant.CanSandbox(),
 		tenantcmd: []string{exe, "worker"},
 		peers:     noPeers{},
+
+		maxRunning: *maxRunning,
+		maxQueued:  *maxQueued,
+		asyncttl:   *asyncTTL,
 	}
 	httpl, err := net.Listen("tcp", *daemonEndpoint)
 	if err != nil {
 		server.logger.Fatal(err)
 	}
+	if publicTLS != (tlsconf.Options{}) {
+		conf, err := tlsconf.New(&publicTLS)
+		if err != nil {
+			server.logger.Fatalf("public endpoint TLS: %s", err)
+		}
+		httpl = tls.NewListener(httpl, conf.Server())
+	}
 	var tenantl net.Listener
 	if *remoteEndpoint != "" {
 		tenantl, err = net.Listen("tcp", *remoteEndpoint)
@@ -75,6 +101,23 @@ func runDaemon(args []string) {
 			server.logger.Fatal(err)
 		}
 	}
+	if peerTLS != (tlsconf.Options{}) {
+		conf, err := tlsconf.Cached(&peerTLS)
+		if err != nil {
+			server.logger.Fatalf("peer TLS: %s", err)
+		}
+		if tenantl != nil {
+			tenantl = tls.NewListener(tenantl, conf.Server())
+		}
+		server.peerTLS = &peerTLS
+	}
+	if *auditDest != "" {
+		server.audit, err = audit.Open(*auditDest)
+		if err != nil {
+			server.logger.Fatalf("audit log: %s", err)
+		}
+		defer server.audit.Close()
+	}
 	provider, err := auth.Parse(*authEndpoint)
 	if err != nil {
 		if len(*authEndpoint) == 0 {
@@ -98,6 +141,7 @@ func runDaemon(args []string) {
 	if *peerExec != "" {
 		server.peers = &peerCmd{
 			cmd: strings.Fields(*peerExec),
+			tls: server.peerTLS,
 		}
 	}
 	go func() {
@@ -30,10 +30,13 @@ import (
 	"time"
 
 	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/cgroup"
 	"github.com/SnellerInc/sneller/db"
 	"github.com/SnellerInc/sneller/debug"
 	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tenant"
 	"github.com/SnellerInc/sneller/tenant/dcache"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
 	"github.com/SnellerInc/sneller/vm"
@@ -44,6 +47,33 @@ func nfds() int {
 	return len(d) - 1
 }
 
+// configureSpill lets queries write the state of
+// ORDER BY, DISTINCT and GROUP BY to a directory
+// under cachedir once that state takes up more than
+// half of the memory available to our cgroup
+func configureSpill(cachedir string, logger *log.Logger) {
+	self, err := cgroup.Self()
+	if err != nil {
+		return
+	}
+	limit, ok, err := self.MemoryLimit()
+	if err != nil {
+		logger.Printf("reading cgroup memory limit: %s", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	dir := filepath.Join(cachedir, tenant.SpillDir)
+	// remove anything left behind by a previous worker
+	os.RemoveAll(dir)
+	if err := os.Mkdir(dir, 0750); err != nil {
+		logger.Printf("creating spill directory: %s", err)
+		return
+	}
+	vm.SetSpill(dir, limit/2)
+}
+
 func runWorker(args []string) {
 	log.Default().SetOutput(os.Stdout)
 	sneller.CanVMOpen = true
@@ -104,12 +134,14 @@ func runWorker(args []string) {
 		} else {
 			run.Cache = dcache.New(cachedir, run.Post)
 			run.Cache.Logger = logger
+			run.Cache.Register(metrics.Default)
 
 			// for now, only allow root to debug us
 			ok := func(ucred *syscall.Ucred) bool {
 				return ucred.Uid == 0
 			}
 			debug.Path(filepath.Join(cachedir, "debug.sock"), ok, logger)
+			configureSpill(cachedir, logger)
 		}
 	}
 
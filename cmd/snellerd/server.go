@@ -22,10 +22,12 @@ import (
 	"time"
 
 	"github.com/SnellerInc/sneller"
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/auth"
 	"github.com/SnellerInc/sneller/cgroup"
 	"github.com/SnellerInc/sneller/tenant"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
+	"github.com/SnellerInc/sneller/tlsconf"
 )
 
 type contextKey struct {
@@ -43,8 +45,28 @@ type server struct {
 	cgroot    string
 	tenantcmd []string
 
+	// maxRunning and maxQueued are the
+	// node-wide admission limits
+	// (see tenant.WithAdmission)
+	maxRunning, maxQueued int
+
+	// asyncttl is the amount of time for which
+	// asynchronous query results are kept
+	// (DefaultAsyncTTL if zero)
+	asyncttl     time.Duration
+	asyncSweeper asyncSweeper
+
 	peers peerlist
 	auth  auth.Provider
+	// peerTLS, if non-nil, is the TLS
+	// configuration used to connect to peers
+	peerTLS *tlsconf.Options
+	// audit, if non-nil, is the audit log
+	// of queries and administrative actions
+	audit *audit.Logger
+
+	metrics *serverMetrics
+	queries queryRegistry
 
 	// when we encounter an error
 	// listing peers, we fall back to
@@ -80,9 +102,16 @@ func (s *server) handler() *http.ServeMux {
 	r.HandleFunc("/", s.handle(s.versionHandler, http.MethodHead, http.MethodGet))
 	r.HandleFunc("/ping", s.handle(s.pingHandler, http.MethodHead, http.MethodGet))
 	r.HandleFunc("/query", s.handle(s.queryHandler, http.MethodHead, http.MethodGet, http.MethodPost))
+	r.HandleFunc("/query/async", s.handle(s.asyncQueryHandler, http.MethodPost))
+	r.HandleFunc("/query/async/", s.handle(s.asyncStatusHandler, http.MethodHead, http.MethodGet))
 	r.HandleFunc("/databases", s.handle(s.databasesHandler, http.MethodHead, http.MethodGet))
 	r.HandleFunc("/tables", s.handle(s.tablesHandler, http.MethodHead, http.MethodGet))
 	r.HandleFunc("/inputs", s.handle(s.inputsHandler, http.MethodHead, http.MethodGet))
+	r.HandleFunc("/schema", s.handle(s.schemaHandler, http.MethodHead, http.MethodGet))
+	r.HandleFunc("/view", s.handle(s.viewHandler, http.MethodHead, http.MethodGet, http.MethodPost, http.MethodDelete))
+	r.HandleFunc("/queries", s.handle(s.queriesHandler, http.MethodHead, http.MethodGet))
+	r.HandleFunc("/queries/", s.handle(s.cancelQueryHandler, http.MethodDelete))
+	r.HandleFunc("/metrics", s.handle(s.metricsHandler, http.MethodHead, http.MethodGet))
 	// deprecated endpoints
 	r.HandleFunc("/executeQuery", s.handle(s.queryHandler, http.MethodHead, http.MethodGet, http.MethodPost))
 	return r
@@ -92,6 +121,7 @@ func (s *server) Serve(httpsock, tenantsock net.Listener) error {
 	opts := []tenant.Option{
 		tenant.WithLogger(s.logger),
 		tenant.WithRemote(tenantsock),
+		tenant.WithAdmission(s.maxRunning, s.maxQueued),
 	}
 	if s.cgroot != "" {
 		opts = append(opts, tenant.WithCgroup(func(id tnproto.ID) cgroup.Dir {
@@ -121,6 +151,7 @@ func (s *server) Serve(httpsock, tenantsock net.Listener) error {
 	if err != nil {
 		s.logger.Fatal(err)
 	}
+	s.metrics = newServerMetrics()
 	s.srv.Handler = s.handler()
 	if s.aboutToServe != nil {
 		s.aboutToServe()
@@ -133,6 +164,7 @@ func (s *server) newSplitter(id tnproto.ID, key tnproto.Key, peers []*net.TCPAdd
 		WorkerID:  id,
 		WorkerKey: key,
 		Peers:     peers,
+		TLS:       s.peerTLS,
 	}
 	if s.remote != nil {
 		split.SelfAddr = s.remote.String()
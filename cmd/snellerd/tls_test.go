@@ -0,0 +1,173 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SnellerInc/sneller/tlsconf"
+	"github.com/SnellerInc/sneller/tlsconf/tlstest"
+)
+
+func newCA(t *testing.T) *tlstest.CA {
+	ca, err := tlstest.NewCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func issue(t *testing.T, ca *tlstest.CA, name string, hosts ...string) *tlsconf.Options {
+	opts, err := ca.Issue(name, hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func tlsListen(t *testing.T, opts *tlsconf.Options) net.Listener {
+	conf, err := tlsconf.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.NewListener(listen(t), conf.Server())
+}
+
+func TestTLS(t *testing.T) {
+	tt := testdirEnviron(t)
+	ca := newCA(t)
+	peerTLS := issue(t, ca, "peer", "127.0.0.1", "localhost")
+	publicTLS := issue(t, ca, "public", "127.0.0.1", "localhost")
+	clientTLS := issue(t, ca, "client")
+
+	peersock0, peersock1 := tlsListen(t, peerTLS), tlsListen(t, peerTLS)
+	peers := func() *testPeers {
+		p := makePeers(t, peersock0.Addr().(*net.TCPAddr), peersock1.Addr().(*net.TCPAddr))
+		p.tls = peerTLS
+		return p
+	}
+	// the certificates live in a temporary
+	// directory, which is not visible to
+	// sandboxed tenants (see tenant.Manager.Sandbox)
+	s := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: []string{"./snellerd-test-binary", "worker"},
+		peers:     peers(),
+		peerTLS:   peerTLS,
+		auth:      testAuth{tt},
+	}
+	httpsock := tlsListen(t, publicTLS)
+	peer := server{
+		logger:    testlogger(t),
+		cachedir:  t.TempDir(),
+		tenantcmd: s.tenantcmd,
+		peers:     peers(),
+		peerTLS:   peerTLS,
+	}
+	httpsock2 := listen(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	s.aboutToServe = (&wg).Done
+	peer.aboutToServe = (&wg).Done
+	go s.Serve(httpsock, peersock0)
+	go peer.Serve(httpsock2, peersock1)
+	wg.Wait()
+
+	defer s.Close()
+	defer peer.Close()
+
+	rq := &requester{
+		t:    t,
+		host: "https://" + httpsock.Addr().String(),
+	}
+	conf, err := tlsconf.New(clientTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: conf.Client(httpsock.Addr().String()),
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// the public endpoint requires a client certificate
+	anon, err := tlsconf.New(&tlsconf.Options{CA: ca.Path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: anon.Client(httpsock.Addr().String()),
+		},
+	}
+	defer anonClient.CloseIdleConnections()
+	res, err := anonClient.Do(rq.getDBs())
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("request without a client certificate succeeded")
+	}
+
+	// EXPLAIN ANALYZE reports the statistics
+	// of each peer, so the query must have been
+	// executed by the second peer over TLS
+	const query = "EXPLAIN ANALYZE SELECT COUNT(*) FROM default.taxi ++ default.parking2 ++ default.parking"
+	res, err = client.Do(rq.getQueryJSON("", query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", res.Status, body)
+	}
+	if res.Trailer.Get("Server-Timing") == "" {
+		t.Error("no server timings")
+	}
+	if strings.Contains(res.Trailer.Get("Server-Timing"), "error") {
+		t.Errorf("query error: %s", body)
+	}
+	if !strings.Contains(string(body), "peer 1:") {
+		t.Errorf("query was not executed by both peers:\n%s", body)
+	}
+
+	// plain queries are relayed to the client
+	res, err = client.Do(rq.getQueryJSON("", "SELECT COUNT(*) AS n FROM default.taxi ++ default.parking2 ++ default.parking"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s: %s", res.Status, body)
+	}
+	if !strings.HasPrefix(string(body), `[{"n": `) {
+		t.Errorf("unexpected result %s", body)
+	}
+}
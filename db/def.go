@@ -17,6 +17,7 @@ package db
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -186,7 +187,15 @@ func OpenDefinition(s fs.FS, db, table string) (*Definition, error) {
 }
 
 // WriteDefinition writes a definition to the given database.
+// WriteDefinition fails if a view with the same name already exists.
 func WriteDefinition(dst OutputFS, db, table string, s *Definition) error {
+	_, err := fs.Stat(dst, ViewPath(db, table))
+	if err == nil {
+		return fmt.Errorf("cannot create table %s.%s: %w", db, table, ErrViewExists)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	buf, err := json.MarshalIndent(s, "", "\t")
 	if err != nil {
 		return err
@@ -158,7 +158,64 @@ func (c *GCConfig) runInputs(rfs RemoveFS, dir string, idx *blockfmt.Index, star
 	return fsutil.VisitDir(rfs, dir, "", pattern, visit)
 }
 
-func (c *GCConfig) runPacked(rfs RemoveFS, dir string, idx *blockfmt.Index, start time.Time, min time.Duration) error {
+// pinnedObjects returns the paths of the objects
+// referenced by the snapshots of a table that haven't
+// expired yet and removes the snapshots that have.
+func (c *GCConfig) pinnedObjects(rfs RemoveFS, dbname, table string) (map[string]struct{}, error) {
+	ifs, ok := rfs.(blockfmt.InputFS)
+	if !ok {
+		return nil, fmt.Errorf("cannot scan snapshots using %T", rfs)
+	}
+	now := time.Now()
+	dir := path.Dir(SnapshotPath(dbname, table, "x"))
+	pinned := make(map[string]struct{})
+	visit := func(d fsutil.DirEntry) error {
+		if d.IsDir() {
+			return nil
+		}
+		p := path.Join(dir, d.Name())
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			c.logf("%s: %v", p, err)
+			return err
+		}
+		if now.Sub(info.ModTime()) >= SnapshotMaxAge {
+			c.remove(rfs, p)
+			return nil
+		}
+		// the snapshot was copied from a signed
+		// index, so we don't need to verify it again
+		idx, _, err := openIndex(rfs, p, nil, blockfmt.FlagSkipInputs)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		for i := range idx.Inline {
+			pinned[idx.Inline[i].Path] = struct{}{}
+		}
+		for i := range idx.Indirect.Refs {
+			pinned[idx.Indirect.Refs[i].Path] = struct{}{}
+		}
+		descs, err := idx.Indirect.Search(ifs, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		for i := range descs {
+			pinned[descs[i].Path] = struct{}{}
+		}
+		return nil
+	}
+	err := fsutil.VisitDir(rfs, dir, "", "*", visit)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return pinned, err
+}
+
+func (c *GCConfig) runPacked(rfs RemoveFS, dir string, idx *blockfmt.Index, pinned map[string]struct{}, start time.Time, min time.Duration) error {
 	ifs, ok := rfs.(blockfmt.InputFS)
 	if !ok {
 		return fmt.Errorf("cannot scan indirect inputs using %T", rfs)
@@ -210,6 +267,9 @@ func (c *GCConfig) runPacked(rfs RemoveFS, dir string, idx *blockfmt.Index, star
 			if _, ok := used[name]; ok {
 				return nil
 			}
+			if _, ok := pinned[path.Join(sub, name)]; ok {
+				return nil
+			}
 			info, err := d.Info()
 			if errors.Is(err, fs.ErrNotExist) {
 				return nil
@@ -264,10 +324,10 @@ func setPackedCursor(dst *blockfmt.Index, cursor string) {
 // within the provided database name and table
 // that a) has a filename pattern that indicates
 // it was packed by Sync, at b) is not pointed to
-// by idx.
+// by idx or by any unexpired snapshot of the table.
 func (c *GCConfig) Run(rfs RemoveFS, dbname string, idx *blockfmt.Index) error {
 	if c.Precise {
-		c.preciseGC(rfs, idx)
+		c.preciseGC(rfs, dbname, idx)
 	}
 	start := time.Now()
 	dir := path.Join("db", dbname, idx.Name)
@@ -279,7 +339,11 @@ func (c *GCConfig) Run(rfs RemoveFS, dbname string, idx *blockfmt.Index) error {
 	if inputmin <= 0 {
 		inputmin = DefaultInputMinimumAge
 	}
-	err := c.runPacked(rfs, dir, idx, start, packedmin)
+	pinned, err := c.pinnedObjects(rfs, dbname, idx.Name)
+	if err != nil {
+		return fmt.Errorf("scanning snapshots: %w", err)
+	}
+	err = c.runPacked(rfs, dir, idx, pinned, start, packedmin)
 	if err != nil {
 		return fmt.Errorf("scanning packfiles: %w", err)
 	}
@@ -291,8 +355,9 @@ func (c *GCConfig) Run(rfs RemoveFS, dbname string, idx *blockfmt.Index) error {
 }
 
 // preciseGC removes expired elements from idx.ToDelete
-// and returns true if any items were removed, or otherwise false
-func (c *GCConfig) preciseGC(rfs RemoveFS, idx *blockfmt.Index) bool {
+// and returns true if any items were removed, or otherwise false;
+// elements that are still referenced by a snapshot are kept
+func (c *GCConfig) preciseGC(rfs RemoveFS, dbname string, idx *blockfmt.Index) bool {
 	if len(idx.ToDelete) == 0 {
 		return false
 	}
@@ -300,11 +365,28 @@ func (c *GCConfig) preciseGC(rfs RemoveFS, idx *blockfmt.Index) bool {
 	now := date.Now()
 	var failed chan blockfmt.Quarantined
 	var wg sync.WaitGroup
+	var pinned map[string]struct{}
+	scanned := false
 	for i := range idx.ToDelete {
 		if idx.ToDelete[i].Expiry.After(now) {
 			saved = append(saved, idx.ToDelete[i])
 			continue
 		}
+		if !scanned {
+			var err error
+			scanned = true
+			pinned, err = c.pinnedObjects(rfs, dbname, idx.Name)
+			if err != nil {
+				// we can't tell what is safe
+				// to delete, so try again later
+				c.logf("scanning snapshots: %s", err)
+				return false
+			}
+		}
+		if _, ok := pinned[idx.ToDelete[i].Path]; ok {
+			saved = append(saved, idx.ToDelete[i])
+			continue
+		}
 		x := idx.ToDelete[i]
 		if failed == nil {
 			failed = make(chan blockfmt.Quarantined, 1)
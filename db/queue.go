@@ -30,6 +30,7 @@ import (
 	"github.com/SnellerInc/sneller/aws/s3"
 	"github.com/SnellerInc/sneller/fsutil"
 	"github.com/SnellerInc/sneller/ion/blockfmt"
+	"github.com/SnellerInc/sneller/metrics"
 
 	"slices"
 
@@ -60,6 +61,32 @@ const (
 	StatusWriteError
 )
 
+func (s QueueStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusTryAgain:
+		return "try_again"
+	case StatusWriteError:
+		return "write_error"
+	default:
+		return fmt.Sprintf("QueueStatus(%d)", int32(s))
+	}
+}
+
+// metrics for QueueRunner.Run; these are
+// registered with metrics.Default
+var (
+	ingestBatches = metrics.Default.CounterVec("sneller_ingest_batches",
+		"Number of batches inserted into tables by status.", "status")
+	ingestObjects = metrics.Default.Counter("sneller_ingest_objects",
+		"Number of objects inserted into tables.")
+	ingestBytes = metrics.Default.Counter("sneller_ingest_bytes",
+		"Number of source bytes inserted into tables.")
+	ingestLag = metrics.Default.GaugeVec("sneller_ingest_lag_seconds",
+		"Time between the arrival of the oldest object in the most recent batch and its insertion.", "db", "table")
+)
+
 // QueueItem represents an item
 // in a notification queue.
 type QueueItem interface {
@@ -227,7 +254,7 @@ func open(infs InputFS, name, etag string, size int64) (fs.File, error) {
 	// I/O if we have enough information to produce
 	// an s3.File handle already
 	if b, ok := infs.(*S3FS); ok {
-		f := s3.NewFile(b.Key, b.Bucket, name, etag, size)
+		f := s3.NewFile(b.Key, b.Bucket, b.ObjectKey(name), etag, size)
 		f.Client = b.Client
 		return f, nil
 	}
@@ -380,6 +407,11 @@ func (q *QueueRunner) runTable(ctx context.Context, src *queueBatch, ti *tableIn
 			if err == nil {
 				q.logf("table %s/%s inserted %d objects %d source bytes mindelay %s maxdelay %s wallclock %s",
 					ti.state.db, ti.state.table, total, size, time.Since(dst.latest), time.Since(dst.earliest), time.Since(batchstart))
+				ingestObjects.Add(float64(total))
+				ingestBytes.Add(float64(size))
+				if !dst.earliest.IsZero() {
+					ingestLag.With(ti.state.db, ti.state.table).Set(time.Since(dst.earliest).Seconds())
+				}
 			}
 		}
 	}
@@ -397,6 +429,9 @@ func (q *QueueRunner) runTable(ctx context.Context, src *queueBatch, ti *tableIn
 
 	// atomically merge status codes back into parent
 	status := errResult(err)
+	if err != nil || len(dst.indirect) > 0 {
+		ingestBatches.With(status.String()).Inc()
+	}
 	for _, j := range dst.indirect {
 		src.status[j].atomicMerge(status)
 	}
@@ -515,6 +550,9 @@ func stopScans(defs map[dbtable]*tableInfo) {
 
 // Run processes entries from in until ReadInputs returns io.EOF,
 // at which point it will call in.Close.
+//
+// The outcome of each batch and the ingest lag
+// of each table are recorded in metrics.Default.
 func (q *QueueRunner) Run(in Queue) error {
 	var lastRefresh time.Time
 	var ts tableStates
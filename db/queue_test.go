@@ -299,6 +299,8 @@ func testQueue(t *testing.T, batchsize int64, scan bool) {
 		Logf:         t.Logf,
 	}
 
+	batches := ingestBatches.With("ok").Value()
+	objects := ingestObjects.Value()
 	runQueue(t, r, q)
 
 	create("aabb/file0.json", `{"name": "aabb/file0.json", "value": 0}`)
@@ -344,4 +346,10 @@ func testQueue(t *testing.T, batchsize int64, scan bool) {
 		"file://aacc/file0.json":          true,
 		"file://aabb/does-not-exist.json": false,
 	})
+	if ingestBatches.With("ok").Value() <= batches {
+		t.Error("no successful batches were counted")
+	}
+	if ingestObjects.Value() <= objects {
+		t.Error("no inserted objects were counted")
+	}
 }
@@ -34,7 +34,7 @@ type S3FS struct {
 
 // URL implements db.URL
 func (s *S3FS) URL(name, etag string) (string, error) {
-	return s3.URL(s.Key, s.Bucket, name)
+	return s3.URL(s.Key, s.Bucket, s.ObjectKey(name))
 }
 
 // Encode implements plan.UploadFS
@@ -44,6 +44,10 @@ func (s *S3FS) Encode(dst *ion.Buffer, st *ion.Symtab) error {
 	s.Key.Encode(st, dst)
 	dst.BeginField(st.Intern("bucket"))
 	dst.WriteString(s.Bucket)
+	if s.Root != "" {
+		dst.BeginField(st.Intern("root"))
+		dst.WriteString(s.Root)
+	}
 	dst.EndStruct()
 	return nil
 }
@@ -58,6 +62,8 @@ func DecodeS3FS(d ion.Datum) (*S3FS, error) {
 			s.Key, err = aws.DecodeKey(f.Datum)
 		case "bucket":
 			s.Bucket, err = f.String()
+		case "root":
+			s.Root, err = f.String()
 		}
 		return err
 	})
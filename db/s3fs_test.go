@@ -15,9 +15,20 @@
 package db
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/SnellerInc/sneller/aws"
+	"github.com/SnellerInc/sneller/ion"
 )
 
 func TestSplit(t *testing.T) {
@@ -35,3 +46,177 @@ func TestSplit(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestS3FSRoot(t *testing.T) {
+	s := &S3FS{}
+	s.Key = aws.DeriveKey("", "AKID", "secret", "us-east-1", "s3")
+	s.Bucket = "bucket.name"
+	s.Root = "tenants/foo"
+	if p := s.Prefix(); p != "s3://bucket.name/tenants/foo/" {
+		t.Errorf("prefix %q", p)
+	}
+	u, err := s.URL("db/default/table/index", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(u, "/tenants/foo/db/default/table/index") {
+		t.Errorf("URL %q doesn't include the root", u)
+	}
+
+	var st ion.Symtab
+	var buf ion.Buffer
+	err = s.Encode(&buf, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, err := ion.ReadDatum(&st, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeS3FS(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bucket != s.Bucket || out.Root != s.Root {
+		t.Errorf("decoded bucket %q root %q", out.Bucket, out.Root)
+	}
+}
+
+// fakeBucket serves the objects in a bucket
+// named "bucket" over a minimal S3 API
+type fakeBucket struct {
+	t       *testing.T
+	objects map[string]string
+}
+
+type fakeObject struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	Size         int       `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type fakePrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeListResult struct {
+	XMLName        xml.Name     `xml:"ListBucketResult"`
+	Contents       []fakeObject `xml:"Contents"`
+	CommonPrefixes []fakePrefix `xml:"CommonPrefixes"`
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if key == "" {
+		b.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	body, ok := b.objects[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodGet {
+		w.Write([]byte(body))
+	}
+}
+
+func (b *fakeBucket) list(w http.ResponseWriter, prefix string) {
+	var res fakeListResult
+	var keys []string
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			dir := prefix + rest[:i+1]
+			n := len(res.CommonPrefixes)
+			if n == 0 || res.CommonPrefixes[n-1].Prefix != dir {
+				res.CommonPrefixes = append(res.CommonPrefixes, fakePrefix{Prefix: dir})
+			}
+			continue
+		}
+		res.Contents = append(res.Contents, fakeObject{
+			Key:          key,
+			ETag:         `"` + key + `"`,
+			Size:         len(b.objects[key]),
+			LastModified: time.Now().UTC(),
+		})
+	}
+	buf, err := xml.Marshal(&res)
+	if err != nil {
+		b.t.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(buf)
+}
+
+func TestS3FSRootObjects(t *testing.T) {
+	b := &fakeBucket{
+		t: t,
+		objects: map[string]string{
+			"tenants/foo/db/default/t0/index": "t0",
+			"tenants/foo/db/default/t1/index": "t1",
+			"tenants/foo/db/other/t2/index":   "t2",
+			"tenants/bar/db/default/t3/index": "t3",
+			"db/default/t4/index":             "t4",
+		},
+	}
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	s := &S3FS{}
+	s.Key = aws.DeriveKey(srv.URL, "AKID", "secret", "us-east-1", "s3")
+	s.Bucket = "bucket"
+	s.Root = "tenants/foo"
+	s.Ctx = context.Background()
+
+	dbs, err := fs.ReadDir(s, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := range dbs {
+		names = append(names, dbs[i].Name())
+	}
+	if !slices.Equal(names, []string{"default", "other"}) {
+		t.Errorf("databases %q", names)
+	}
+	tables, err := Tables(s, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tables, []string{"t0", "t1"}) {
+		t.Errorf("tables %q", tables)
+	}
+	buf, err := fs.ReadFile(s, "db/default/t1/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "t1" {
+		t.Errorf("read %q", buf)
+	}
+	for _, name := range []string{
+		"db/default/t3/index",
+		"db/default/t4/index",
+	} {
+		_, err = fs.Stat(s, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got error %v outside of the root", name, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+)
+
+// SnapshotMaxAge is the maximum age of a snapshot.
+// Older snapshots can't be opened anymore and are
+// removed (along with the objects that only they
+// reference) by garbage collection.
+const SnapshotMaxAge = 24 * time.Hour
+
+// ErrNoSnapshot is returned by OpenSnapshot when
+// the snapshot doesn't exist or has expired.
+var ErrNoSnapshot = errors.New("snapshot does not exist or has expired")
+
+// SnapshotPath returns the path
+// at which the snapshot with the given id
+// of the index of the given db and table
+// would live relative to the root of the FS.
+func SnapshotPath(db, table, id string) string {
+	return path.Join("db", db, table, "snapshots", id)
+}
+
+// ValidSnapshotID returns whether id can be
+// used as the id of a snapshot. Snapshot ids
+// consist of 1 to 64 letters, digits, '-' or '_'.
+func ValidSnapshotID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WriteSnapshot saves the current index of
+// the given db and table as the snapshot with
+// the given id. The objects referenced by the
+// snapshot are not garbage-collected until the
+// snapshot expires (see SnapshotMaxAge), so
+// queries can keep reading the snapshot after
+// the table has been updated.
+//
+// WriteSnapshot does nothing if the snapshot
+// already exists.
+func WriteSnapshot(dst OutputFS, db, table, id string) error {
+	if !ValidSnapshotID(id) {
+		return fmt.Errorf("db.WriteSnapshot: invalid snapshot id %q", id)
+	}
+	spath := SnapshotPath(db, table, id)
+	_, err := fs.Stat(dst, spath)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ipath := IndexPath(db, table)
+	info, err := fs.Stat(dst, ipath)
+	if err != nil {
+		return err
+	}
+	if info.Size() >= MaxIndexSize {
+		return fmt.Errorf("index %q is %d bytes; too big", ipath, info.Size())
+	}
+	// the index is copied verbatim, so the
+	// signature of the snapshot is still valid
+	buf, err := fs.ReadFile(dst, ipath)
+	if err != nil {
+		return err
+	}
+	_, err = dst.WriteFile(spath, buf)
+	return err
+}
+
+// OpenSnapshot opens the snapshot with the given id
+// of the index of the given db and table. Like
+// OpenPartialIndex, it skips decoding Index.Inputs.
+// OpenSnapshot returns ErrNoSnapshot if the snapshot
+// doesn't exist or has expired.
+func OpenSnapshot(s fs.FS, db, table, id string, key *blockfmt.Key) (*blockfmt.Index, error) {
+	if !ValidSnapshotID(id) {
+		return nil, fmt.Errorf("db.OpenSnapshot: invalid snapshot id %q", id)
+	}
+	i, info, err := openIndex(s, SnapshotPath(db, table, id), key, blockfmt.FlagSkipInputs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s.%s: %w", db, table, ErrNoSnapshot)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) >= SnapshotMaxAge {
+		return nil, fmt.Errorf("%s.%s: %w", db, table, ErrNoSnapshot)
+	}
+	return i, nil
+}
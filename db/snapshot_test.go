@@ -0,0 +1,182 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/date"
+	"github.com/SnellerInc/sneller/ion/blockfmt"
+)
+
+func TestSnapshot(t *testing.T) {
+	checkFiles(t)
+	tmpdir := t.TempDir()
+	dfs := newDirFS(t, tmpdir)
+	err := os.MkdirAll(filepath.Join(tmpdir, "a-prefix"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldname, err := filepath.Abs("../testdata/parking.10n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(oldname, filepath.Join(tmpdir, "a-prefix/parking.10n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteDefinition(dfs, "default", "parking", &Definition{
+		Inputs: []Input{{Pattern: "file://a-prefix/*.10n"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := newTenant(dfs)
+	c := Config{
+		Align: 1024,
+		Fallback: func(_ string) blockfmt.RowFormat {
+			return blockfmt.UnsafeION()
+		},
+	}
+	err = c.Sync(owner, "default", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenSnapshot(dfs, "default", "parking", "s1", owner.Key())
+	if !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("opening missing snapshot: got %v", err)
+	}
+	for _, id := range []string{"s1", "expired"} {
+		err = WriteSnapshot(dfs, "default", "parking", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = WriteSnapshot(dfs, "default", "parking", "../index")
+	if err == nil {
+		t.Fatal("expected an error for an invalid snapshot id")
+	}
+	old := time.Now().Add(-SnapshotMaxAge - time.Minute)
+	err = os.Chtimes(filepath.Join(tmpdir, SnapshotPath("default", "parking", "expired")), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenSnapshot(dfs, "default", "parking", "expired", owner.Key())
+	if !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("opening expired snapshot: got %v", err)
+	}
+	snap, err := OpenSnapshot(dfs, "default", "parking", "s1", owner.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Inline) == 0 {
+		t.Fatal("no objects in snapshot")
+	}
+
+	// pretend that the table has been rewritten,
+	// so that the index doesn't reference the
+	// objects of the snapshot anymore
+	idx, err := OpenIndex(dfs, "default", "parking", owner.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pinned []string
+	for i := range idx.Inline {
+		p := idx.Inline[i].Path
+		buf, err := fs.ReadFile(dfs, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		np := path.Join(path.Dir(p), "packed-rewritten"+path.Base(p)[len("packed-"):])
+		_, err = dfs.WriteFile(np, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinned = append(pinned, p)
+		idx.Inline[i].Path = np
+		idx.ToDelete = append(idx.ToDelete, blockfmt.Quarantined{
+			Path:   p,
+			Expiry: date.Now().Add(-time.Minute),
+		})
+	}
+	exists := func(p string) bool {
+		_, err := fs.Stat(dfs, p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	conf := GCConfig{
+		Logf:            t.Logf,
+		MinimumAge:      1,
+		InputMinimumAge: 1,
+		Precise:         true,
+	}
+	err = conf.Run(dfs, "default", idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pinned {
+		if !exists(p) {
+			t.Errorf("%s was removed while a snapshot references it", p)
+		}
+	}
+	if len(idx.ToDelete) != len(pinned) {
+		t.Errorf("%d items left in ToDelete; expected %d", len(idx.ToDelete), len(pinned))
+	}
+	if exists(SnapshotPath("default", "parking", "expired")) {
+		t.Error("expired snapshot was not removed")
+	}
+	snap, err = OpenSnapshot(dfs, "default", "parking", "s1", owner.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range snap.Inline {
+		if !exists(snap.Inline[i].Path) {
+			t.Errorf("%s: missing", snap.Inline[i].Path)
+		}
+	}
+
+	// once the snapshot expires, the
+	// objects can be collected
+	err = os.Chtimes(filepath.Join(tmpdir, SnapshotPath("default", "parking", "s1")), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conf.Run(dfs, "default", idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pinned {
+		if exists(p) {
+			t.Errorf("%s still exists after the snapshot expired", p)
+		}
+	}
+	if len(idx.ToDelete) != 0 {
+		t.Errorf("%d items left in ToDelete", len(idx.ToDelete))
+	}
+	for i := range idx.Inline {
+		if !exists(idx.Inline[i].Path) {
+			t.Errorf("%s: missing", idx.Inline[i].Path)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/date"
 	"github.com/SnellerInc/sneller/expr"
 	"github.com/SnellerInc/sneller/ion"
@@ -170,6 +171,11 @@ type Config struct {
 	Logf func(f string, args ...interface{})
 
 	Verbose bool
+
+	// Audit, if non-nil, is the audit log
+	// in which Sync records the outcome
+	// of the synchronization of each table.
+	Audit *audit.Logger
 }
 
 func (c *Config) minMergeSize() int64 {
@@ -583,13 +589,38 @@ func (c *Config) Sync(who Tenant, db, tblpat string) error {
 		tab := tables[i]
 		go func(i int) {
 			defer wg.Done()
+			start := time.Now()
 			errlist[i] = syncTable(tab)
+			c.audit(who, db, tab, time.Since(start), errlist[i])
 		}(i)
 	}
 	wg.Wait()
 	return combine(errlist)
 }
 
+// audit records the synchronization
+// of a table in c.Audit
+func (c *Config) audit(who Tenant, db, table string, elapsed time.Duration, err error) {
+	if c.Audit == nil {
+		return
+	}
+	e := &audit.Event{
+		Action:   audit.ActionSync,
+		Tenant:   who.ID(),
+		Database: db,
+		Table:    table,
+		Duration: elapsed.Seconds(),
+	}
+	// ErrBuildAgain means the sync
+	// made progress but isn't done yet
+	if err != nil && !errors.Is(err, ErrBuildAgain) {
+		e.Error = err.Error()
+	}
+	if err := c.Audit.Log(e); err != nil && c.Logf != nil {
+		c.Logf("audit log: %s", err)
+	}
+}
+
 func combine(lst []error) error {
 	var nonnull []error
 	for i := range lst {
@@ -797,7 +828,7 @@ func (st *tableState) preciseGC(idx *blockfmt.Index) bool {
 	gc := false
 	if rmfs, ok := st.ofs.(RemoveFS); ok {
 		gcconf := GCConfig{Precise: true, Logf: st.logf}
-		gc = gcconf.preciseGC(rmfs, idx)
+		gc = gcconf.preciseGC(rmfs, st.db, idx)
 	}
 	return purged || gc
 }
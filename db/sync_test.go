@@ -17,6 +17,7 @@ package db
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SnellerInc/sneller/audit"
 	"github.com/SnellerInc/sneller/compr"
 	"github.com/SnellerInc/sneller/date"
 	"github.com/SnellerInc/sneller/ion"
@@ -427,6 +429,59 @@ func TestSync(t *testing.T) {
 	}
 }
 
+func TestSyncAudit(t *testing.T) {
+	dfs := newDirFS(t, t.TempDir())
+	err := WriteDefinition(dfs, "default", "x", &Definition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteDefinition(dfs, "default", "y", &Definition{
+		Inputs: []Input{{Pattern: "bogus://a-prefix/*.json"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	owner := newTenant(dfs)
+	c := Config{
+		Logf:  t.Logf,
+		Audit: audit.New(&buf),
+	}
+	// y can't be synchronized
+	if err := c.Sync(owner, "default", "*"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := c.Sync(owner, "default", "x"); err != nil {
+		t.Fatal(err)
+	}
+	var events []audit.Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e audit.Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	slices.SortFunc(events[:2], func(a, b audit.Event) int {
+		return strings.Compare(a.Table, b.Table)
+	})
+	for i, e := range events {
+		if e.Action != audit.ActionSync || e.Tenant != owner.ID() || e.Database != "default" {
+			t.Errorf("event %d: unexpected %+v", i, e)
+		}
+		if (e.Error != "") != (e.Table == "y") {
+			t.Errorf("event %d: unexpected error %q", i, e.Error)
+		}
+	}
+	if events[0].Table != "x" || events[1].Table != "y" || events[2].Table != "x" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
 func TestMaxBytesSync(t *testing.T) {
 	checkFiles(t)
 	tmpdir := t.TempDir()
@@ -16,6 +16,7 @@ package db
 
 import (
 	"crypto/rand"
+	"errors"
 	"os"
 	"path"
 	"path/filepath"
@@ -227,3 +228,24 @@ func TestBuildBlobs(t *testing.T) {
 		t.Fatalf("got %d blobs back?", len(lst))
 	}
 }
+
+func TestTableViewConflict(t *testing.T) {
+	dfs := NewDirFS(t.TempDir())
+	defer dfs.Close()
+	err := WriteView(dfs, "db0", "v", &View{Query: "SELECT * FROM t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteDefinition(dfs, "db0", "v", &Definition{})
+	if !errors.Is(err, ErrViewExists) {
+		t.Fatalf("WriteDefinition over a view: got error %v", err)
+	}
+	err = WriteDefinition(dfs, "db0", "t", &Definition{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteView(dfs, "db0", "t", &View{Query: "SELECT * FROM v"})
+	if !errors.Is(err, ErrTableExists) {
+		t.Fatalf("WriteView over a table: got error %v", err)
+	}
+}
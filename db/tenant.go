@@ -49,6 +49,23 @@ type TenantConfig struct {
 	// allowed to be scanned for each query. If
 	// this is 0, there is no limit.
 	MaxScanBytes uint64
+	// MaxConcurrentQueries is the maximum number
+	// of queries of the tenant that may run
+	// concurrently on each node; additional
+	// queries are queued. If this is 0,
+	// there is no limit.
+	MaxConcurrentQueries int
+	// MaxBytesInFlight is the maximum number of
+	// bytes that the queries of the tenant running
+	// concurrently on each node may scan in total;
+	// additional queries are queued. If this is 0,
+	// there is no limit.
+	MaxBytesInFlight uint64
+	// Weight is the share of the query capacity
+	// of each node given to the tenant relative to
+	// other tenants when queries are queued.
+	// If this is 0, the weight is 1.
+	Weight int
 }
 
 // TenantConfigurable is a tenant that may provide
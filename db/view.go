@@ -0,0 +1,97 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// ErrTableExists is returned by WriteView
+// when a table with the name of the view exists.
+var ErrTableExists = errors.New("table already exists")
+
+// ErrViewExists is returned by WriteDefinition
+// when a view with the name of the table exists.
+var ErrViewExists = errors.New("view already exists")
+
+// View is a stored query that
+// can be referenced like a table.
+type View struct {
+	// Query is the text of the query
+	// that produces the rows of the view.
+	Query string `json:"query"`
+}
+
+// ViewPath returns the path
+// at which the definition of the given
+// view would live relative to the
+// root of the FS.
+func ViewPath(db, view string) string {
+	return path.Join("db", db, view, "view.json")
+}
+
+// Views lists the names of all
+// the views in the given database.
+func Views(s fs.FS, db string) ([]string, error) {
+	return ListComponent(s, ViewPath(db, "*"), 2)
+}
+
+// OpenView opens the definition of
+// the given view in the given database.
+func OpenView(s fs.FS, db, view string) (*View, error) {
+	f, err := s.Open(ViewPath(db, view))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := checkDef(f); err != nil {
+		return nil, err
+	}
+	v := new(View)
+	err = json.NewDecoder(f).Decode(v)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WriteView writes the definition of a view
+// to the given database. WriteView fails if
+// a table with the same name already exists.
+func WriteView(dst OutputFS, db, view string, v *View) error {
+	_, err := fs.Stat(dst, DefinitionPath(db, view))
+	if err == nil {
+		return fmt.Errorf("cannot create view %s.%s: %w", db, view, ErrTableExists)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	buf, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = dst.WriteFile(ViewPath(db, view), buf)
+	return err
+}
+
+// DeleteView removes the definition
+// of a view from the given database.
+func DeleteView(dst RemoveFS, db, view string) error {
+	return dst.Remove(ViewPath(db, view))
+}
@@ -153,6 +153,17 @@ func main() {
 	r.HandleFunc("/{index}/_count", withConfig(proxy_http.CountProxy)).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/{index}/_search", withConfig(proxy_http.SearchProxy)).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/{index}/_async_search", withConfig(proxy_http.AsyncSearchProxy)).Methods(http.MethodPost)
+	r.HandleFunc("/_msearch", withConfig(proxy_http.MultiSearchProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/{index}/_msearch", withConfig(proxy_http.MultiSearchProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/{index}/_field_caps", withConfig(proxy_http.FieldCapsProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/{index}/_pit", withConfig(proxy_http.OpenPointInTimeProxy)).Methods(http.MethodPost)
+	r.HandleFunc("/_pit", withConfig(proxy_http.ClosePointInTimeProxy)).Methods(http.MethodDelete)
+	r.HandleFunc("/_search", withConfig(proxy_http.PointInTimeSearchProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/_search/scroll", withConfig(proxy_http.ScrollProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/_search/scroll/{scroll_id}", withConfig(proxy_http.ScrollProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/_search/scroll", withConfig(proxy_http.ClearScrollProxy)).Methods(http.MethodDelete)
+	r.HandleFunc("/_sql", withConfig(proxy_http.SQLProxy)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/_sql/close", withConfig(proxy_http.SQLCloseProxy)).Methods(http.MethodPost)
 	r.HandleFunc("/_bulk", withConfig(proxy_http.BulkProxy)).Methods(http.MethodPost)
 	r.HandleFunc("/{target}/_bulk", withConfig(proxy_http.BulkProxy)).Methods(http.MethodPost)
 	r.HandleFunc("/{index}/_mapping", withConfig(func(c *proxy_http.HandlerContext) bool {
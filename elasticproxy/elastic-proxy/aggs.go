@@ -34,14 +34,18 @@ var aggregationTypeMapping map[string]reflect.Type
 func init() {
 	aggregationTypeMapping = map[string]reflect.Type{
 		// Metric aggregations
-		"min":          reflect.TypeOf(&aggsMin{}),
-		"avg":          reflect.TypeOf(&aggsAvg{}),
-		"max":          reflect.TypeOf(&aggsMax{}),
-		"sum":          reflect.TypeOf(&aggsSum{}),
-		"cardinality":  reflect.TypeOf(&aggsCardinality{}),
-		"value_count":  reflect.TypeOf(&aggsValueCount{}),
-		"geo_centroid": reflect.TypeOf(&aggsGeoCentroid{}),
-		"top_hits":     reflect.TypeOf(&aggsTopHits{}), // implemented as a bucket aggregation
+		"min":              reflect.TypeOf(&aggsMin{}),
+		"avg":              reflect.TypeOf(&aggsAvg{}),
+		"max":              reflect.TypeOf(&aggsMax{}),
+		"sum":              reflect.TypeOf(&aggsSum{}),
+		"cardinality":      reflect.TypeOf(&aggsCardinality{}),
+		"value_count":      reflect.TypeOf(&aggsValueCount{}),
+		"geo_centroid":     reflect.TypeOf(&aggsGeoCentroid{}),
+		"top_hits":         reflect.TypeOf(&aggsTopHits{}), // implemented as a bucket aggregation
+		"percentiles":      reflect.TypeOf(&aggsPercentiles{}),
+		"percentile_ranks": reflect.TypeOf(&aggsPercentileRanks{}),
+		"stats":            reflect.TypeOf(&aggsStats{}),
+		"extended_stats":   reflect.TypeOf(&aggsExtendedStats{}),
 
 		// Bucket aggregations
 		"date_histogram": reflect.TypeOf(&aggsDateHistogram{}),
@@ -51,6 +55,11 @@ func init() {
 		"terms":          reflect.TypeOf(&aggsTerms{}),
 		"multi_terms":    reflect.TypeOf(&aggsMultiTerms{}),
 		"geotile_grid":   reflect.TypeOf(&aggsGeotileGrid{}),
+		"range":          reflect.TypeOf(&aggsRange{}),
+		"date_range":     reflect.TypeOf(&aggsDateRange{}),
+		"composite":      reflect.TypeOf(&aggsComposite{}),
+		"nested":         reflect.TypeOf(&aggsNested{}),
+		"reverse_nested": reflect.TypeOf(&aggsReverseNested{}),
 
 		// Pipeline aggregations
 		"bucket_script": reflect.TypeOf(&aggsBucketScript{}),
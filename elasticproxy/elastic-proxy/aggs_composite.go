@@ -0,0 +1,486 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-aggregations-bucket-composite-aggregation.html
+type aggsComposite struct {
+	Size    *int              `json:"size"`
+	Sources []compositeSource `json:"sources"`
+	After   map[string]any    `json:"after"`
+}
+
+// compositeSource is a single value source
+// of a composite aggregation. Only one of
+// Terms, Histogram or DateHistogram is set.
+type compositeSource struct {
+	Name          string
+	Terms         *compositeTerms
+	Histogram     *aggsHistogram
+	DateHistogram *aggsDateHistogram
+
+	Field         string
+	Order         Ordering
+	MissingBucket bool
+
+	// timestamp is set when the field of a
+	// terms source is mapped to a timestamp
+	// (the key is returned in milliseconds)
+	timestamp bool
+}
+
+type compositeTerms struct {
+	Field string `json:"field"`
+}
+
+func (s *compositeSource) UnmarshalJSON(data []byte) error {
+	var source map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &source); err != nil {
+		return err
+	}
+	if len(source) != 1 {
+		return errors.New("composite source should contain a single value source")
+	}
+	for name, valueSource := range source {
+		if len(valueSource) != 1 {
+			return fmt.Errorf("composite source %q should contain a single value source", name)
+		}
+		s.Name = name
+		for kind, data := range valueSource {
+			var target any
+			switch kind {
+			case "terms":
+				s.Terms = &compositeTerms{}
+				target = s.Terms
+			case "histogram":
+				s.Histogram = &aggsHistogram{}
+				target = s.Histogram
+			case "date_histogram":
+				s.DateHistogram = &aggsDateHistogram{}
+				target = s.DateHistogram
+			default:
+				return fmt.Errorf("unsupported value source %q in composite source %q", kind, name)
+			}
+			if err := json.Unmarshal(data, target); err != nil {
+				return err
+			}
+
+			var common struct {
+				Field         string   `json:"field"`
+				Order         Ordering `json:"order"`
+				MissingBucket bool     `json:"missing_bucket"`
+			}
+			if err := json.Unmarshal(data, &common); err != nil {
+				return err
+			}
+			s.Field = common.Field
+			s.Order = common.Order
+			if s.Order == "" {
+				s.Order = OrderAscending
+			}
+			s.MissingBucket = common.MissingBucket
+		}
+	}
+	return nil
+}
+
+// keyExpr returns the expression that determines
+// the value of the source for each record
+func (s *compositeSource) keyExpr(c *aggsGenerateContext) (expression, error) {
+	e, err := s.valueExpr(c)
+	if err != nil || !s.MissingBucket {
+		return e, err
+	}
+	// documents without a value end up
+	// in the bucket with a null key
+	return &exprCase{
+		Context: c.context,
+		Whens: []exprCaseWhen{{
+			When: &exprOperator1{
+				Context:  c.context,
+				Operator: "IS MISSING",
+				Expr1:    ParseExprFieldName(c.context, s.Field),
+			},
+			Then: &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: nil}},
+		}},
+		Else: e,
+	}, nil
+}
+
+func (s *compositeSource) valueExpr(c *aggsGenerateContext) (expression, error) {
+	switch {
+	case s.Histogram != nil:
+		h := s.Histogram
+		if h.Interval <= 0 {
+			return nil, errors.New("invalid interval")
+		}
+		var e expression = ParseExprFieldName(c.context, s.Field)
+		if h.Offset != 0 {
+			e = &exprOperator2{
+				Context:  c.context,
+				Operator: "-",
+				Expr1:    e,
+				Expr2:    &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: h.Offset}},
+			}
+		}
+		e = &exprOperator2{
+			Context:  c.context,
+			Operator: "*",
+			Expr1: &exprFunction{
+				Context: c.context,
+				Name:    "FLOOR",
+				Exprs: []expression{
+					&exprOperator2{
+						Context:  c.context,
+						Operator: "/",
+						Expr1:    e,
+						Expr2:    &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: h.Interval}},
+					},
+				},
+			},
+			Expr2: &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: h.Interval}},
+		}
+		if h.Offset != 0 {
+			e = &exprOperator2{
+				Context:  c.context,
+				Operator: "+",
+				Expr1:    e,
+				Expr2:    &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: h.Offset}},
+			}
+		}
+		return e, nil
+	case s.DateHistogram != nil:
+		return s.DateHistogram.keyExpr(c)
+	default:
+		return ParseExprFieldName(c.context, s.Field), nil
+	}
+}
+
+// afterValue converts the 'after' key of
+// the source to the value that is returned
+// by the key expression
+func (s *compositeSource) afterValue(v any) (JSONLiteral, error) {
+	if v == nil {
+		if !s.MissingBucket {
+			return JSONLiteral{}, fmt.Errorf("invalid 'after' key for composite source %q", s.Name)
+		}
+		return JSONLiteral{Value: nil}, nil
+	}
+	if s.timestamp {
+		switch v := v.(type) {
+		case float64:
+			return JSONLiteral{Value: time.UnixMilli(int64(v)).UTC()}, nil
+		case string:
+			t, err := parseDateTime(v)
+			if err != nil {
+				return JSONLiteral{}, fmt.Errorf("invalid 'after' key %q for composite source %q", v, s.Name)
+			}
+			return JSONLiteral{Value: t.UTC()}, nil
+		}
+		return JSONLiteral{}, fmt.Errorf("invalid 'after' key for composite source %q", s.Name)
+	}
+	if s.DateHistogram != nil {
+		var t time.Time
+		switch v := v.(type) {
+		case float64:
+			t = time.UnixMilli(int64(v)).UTC()
+		case string:
+			var err error
+			if t, err = parseDateTime(v); err != nil {
+				return JSONLiteral{}, fmt.Errorf("invalid 'after' key %q for composite source %q", v, s.Name)
+			}
+		default:
+			return JSONLiteral{}, fmt.Errorf("invalid 'after' key for composite source %q", s.Name)
+		}
+		if s.DateHistogram.FixedInterval != nil {
+			// TIME_BUCKET returns the number of seconds since epoch
+			return JSONLiteral{Value: t.Unix()}, nil
+		}
+		return JSONLiteral{Value: t.UTC()}, nil
+	}
+	return NewJSONLiteral(v)
+}
+
+// afterQuery returns the expression that only
+// selects the composite keys that follow the
+// 'after' key (taking the sort order into account)
+func (f *aggsComposite) afterQuery(c *aggsGenerateContext, keyExprs []expression) (expression, error) {
+	afterValues := make([]JSONLiteral, len(f.Sources))
+	for i := range f.Sources {
+		s := &f.Sources[i]
+		v, ok := f.After[s.Name]
+		if !ok {
+			return nil, fmt.Errorf("'after' key doesn't contain a value for composite source %q", s.Name)
+		}
+		after, err := s.afterValue(v)
+		if err != nil {
+			return nil, err
+		}
+		afterValues[i] = after
+	}
+
+	// (k0 > a0) OR (k0 = a0 AND k1 > a1) OR ...
+	var alternatives []expression
+	for i := range f.Sources {
+		follows := f.Sources[i].follows(c, keyExprs[i], afterValues[i])
+		if follows == nil {
+			continue
+		}
+		var exprs []expression
+		for j := 0; j < i; j++ {
+			exprs = append(exprs, equalsAfter(c, keyExprs[j], afterValues[j]))
+		}
+		exprs = append(exprs, follows)
+		alternatives = append(alternatives, andExpressions(exprs))
+	}
+	if len(alternatives) == 0 {
+		// the 'after' key is the last possible key
+		return &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: false}}, nil
+	}
+	return joinExpressions(alternatives, "OR"), nil
+}
+
+// follows returns the expression that selects the
+// keys of the source that follow the 'after' value
+// or nil if no key follows it. The missing bucket
+// (null key) comes first in ascending order and
+// last in descending order.
+func (s *compositeSource) follows(c *aggsGenerateContext, keyExpr expression, after JSONLiteral) expression {
+	if after.Value == nil {
+		if s.Order == OrderDescending {
+			return nil
+		}
+		return &exprOperator1{
+			Context:  c.context,
+			Operator: "IS NOT NULL",
+			Expr1:    keyExpr,
+		}
+	}
+	operator := ">"
+	if s.Order == OrderDescending {
+		operator = "<"
+	}
+	var e expression = &exprOperator2{
+		Context:  c.context,
+		Operator: operator,
+		Expr1:    keyExpr,
+		Expr2:    &exprJSONLiteral{Context: c.context, Value: after},
+	}
+	if s.MissingBucket && s.Order == OrderDescending {
+		e = &exprOperator2{
+			Context:  c.context,
+			Operator: "OR",
+			Expr1:    e,
+			Expr2: &exprOperator1{
+				Context:  c.context,
+				Operator: "IS NULL",
+				Expr1:    keyExpr,
+			},
+		}
+	}
+	return e
+}
+
+// equalsAfter returns the expression that
+// selects the key that equals the 'after' value
+func equalsAfter(c *aggsGenerateContext, keyExpr expression, after JSONLiteral) expression {
+	if after.Value == nil {
+		return &exprOperator1{
+			Context:  c.context,
+			Operator: "IS NULL",
+			Expr1:    keyExpr,
+		}
+	}
+	return &exprOperator2{
+		Context:  c.context,
+		Operator: "=",
+		Expr1:    keyExpr,
+		Expr2:    &exprJSONLiteral{Context: c.context, Value: after},
+	}
+}
+
+func (f *aggsComposite) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	if c.nestingLevel > 1 {
+		return nil, errors.New("composite aggregation cannot be used with a parent aggregation")
+	}
+	if len(f.Sources) == 0 {
+		return nil, errors.New("composite aggregation requires at least one source")
+	}
+
+	keyExprs := make([]expression, len(f.Sources))
+	for i := range f.Sources {
+		s := &f.Sources[i]
+		if s.Terms != nil {
+			tf, _ := format(s.Field, c.context.TypeMapping)
+			s.timestamp = isTimestampFormat(tf)
+		}
+		keyExpr, err := s.keyExpr(c)
+		if err != nil {
+			return nil, err
+		}
+		keyExprs[i] = keyExpr
+
+		if !s.MissingBucket {
+			// documents without a value are skipped
+			c.andQuery(&exprOperator1{
+				Context:  c.context,
+				Operator: "IS NOT MISSING",
+				Expr1:    ParseExprFieldName(c.context, s.Field),
+			})
+		}
+		c.addGroupExpr(keyExpr)
+		c.addOrdering(orderByExpr{
+			Context:    c.context,
+			expression: keyExpr,
+			Order:      s.Order,
+			NullsLast:  s.MissingBucket && s.Order == OrderDescending,
+		})
+	}
+
+	if f.After != nil {
+		afterExpr, err := f.afterQuery(c, keyExprs)
+		if err != nil {
+			return nil, err
+		}
+		c.andQuery(afterExpr)
+	}
+
+	c.addDocCount(false)
+	c.setSize(f.Size)
+
+	return c.transform()
+}
+
+func (f *aggsComposite) process(c *aggsProcessContext) (any, error) {
+	result := compositeResult{
+		Buckets: []compositeBucketResult{},
+	}
+
+	groups := c.groups()
+	if groups != nil {
+		size := effectiveSize(f.Size)
+		groupCount := len(groups.OrderedGroups)
+		if groupCount > size {
+			groupCount = size
+		}
+
+		result.Buckets = make([]compositeBucketResult, 0, groupCount)
+		for n := 0; n < groupCount; n++ {
+			group := groups.OrderedGroups[n]
+
+			if len(group.KeyValues) != len(f.Sources) {
+				return nil, fmt.Errorf("key-value count is %d, which is invalid for a composite aggregation with %d sources", len(group.KeyValues), len(f.Sources))
+			}
+
+			key := make(map[string]any, len(f.Sources))
+			for i := range f.Sources {
+				v, err := f.Sources[i].keyValue(group.KeyValues[i])
+				if err != nil {
+					return nil, err
+				}
+				key[f.Sources[i].Name] = v
+			}
+
+			docCount, err := group.docCount()
+			if err != nil {
+				return nil, err
+			}
+
+			c.docCount = docCount
+			bucketResult, err := c.subResult(group)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Buckets = append(result.Buckets, compositeBucketResult{
+				bucketSingleResult: bucketSingleResult{
+					SubAggregations: bucketResult,
+					DocCount:        docCount,
+				},
+				Key: key,
+			})
+		}
+	}
+
+	// the last key can be used to fetch the next page
+	if len(result.Buckets) > 0 {
+		result.AfterKey = result.Buckets[len(result.Buckets)-1].Key
+	}
+
+	return &result, nil
+}
+
+// keyValue converts the value of the key
+// expression to the value of the bucket key
+func (s *compositeSource) keyValue(v any) (any, error) {
+	switch {
+	case v == nil:
+		// the missing bucket
+		return nil, nil
+	case s.Histogram != nil:
+		f, err := NewElasticFloat(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram key for composite source %q", s.Name)
+		}
+		return &f, nil
+	case s.DateHistogram != nil:
+		ms, err := s.DateHistogram.keyMillis(v)
+		if err != nil {
+			return nil, err
+		}
+		if s.DateHistogram.Format != "" {
+			formatted, err := formatOutRaw(time.UnixMilli(ms).UTC(), s.DateHistogram.Format)
+			if err != nil {
+				return nil, err
+			}
+			return formatted, nil
+		}
+		return ms, nil
+	}
+	if t, ok := v.(time.Time); ok {
+		// timestamps are always emitted as numerical values
+		return t.UnixMilli(), nil
+	}
+	return v, nil
+}
+
+// compositeResult holds the result of a
+// composite aggregation. The buckets are
+// keyed by the values of all sources.
+type compositeResult struct {
+	AfterKey map[string]any          `json:"after_key,omitempty"`
+	Buckets  []compositeBucketResult `json:"buckets"`
+}
+
+type compositeBucketResult struct {
+	bucketSingleResult
+	Key map[string]any
+}
+
+func (r *compositeBucketResult) MarshalJSON() ([]byte, error) {
+	jsonMap := make(map[string]any, len(r.SubAggregations)+2)
+	for k, v := range r.SubAggregations {
+		jsonMap[k] = v
+	}
+	jsonMap["doc_count"] = r.DocCount
+	jsonMap["key"] = r.Key
+	return json.Marshal(jsonMap)
+}
@@ -53,23 +53,24 @@ func (f *aggsDateHistogram) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func (f *aggsDateHistogram) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
-	var e expression
-
+// keyExpr returns the expression that
+// determines the bucket of each record
+func (f *aggsDateHistogram) keyExpr(c *aggsGenerateContext) (expression, error) {
 	if f.FixedInterval != nil {
 		seconds, err := f.FixedInterval.Seconds()
 		if err != nil {
 			return nil, err
 		}
-		e = &exprFunction{
+		return &exprFunction{
 			Context: c.context,
 			Name:    "TIME_BUCKET",
 			Exprs: []expression{
 				ParseExprFieldName(c.context, f.Field),
 				&exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: seconds}},
 			},
-		}
-	} else if f.CalendarInterval != nil {
+		}, nil
+	}
+	if f.CalendarInterval != nil {
 		interval := string(*f.CalendarInterval)
 		var intervalArg string
 		switch interval {
@@ -96,16 +97,42 @@ func (f *aggsDateHistogram) transform(c *aggsGenerateContext) ([]projectAliasExp
 		default:
 			return nil, fmt.Errorf("unsupported interval %q", interval)
 		}
-		e = &exprFunction{
+		return &exprFunction{
 			Context: c.context,
 			Name:    "DATE_TRUNC",
 			Exprs: []expression{
 				&exprText{Context: c.context, Value: intervalArg},
 				ParseExprFieldName(c.context, f.Field),
 			},
+		}, nil
+	}
+	return nil, fmt.Errorf("required either calendar or fixed interval")
+}
+
+// keyMillis converts the bucket key (as
+// returned by the query) to milliseconds
+// since epoch
+func (f *aggsDateHistogram) keyMillis(key any) (int64, error) {
+	if f.FixedInterval != nil {
+		// TIME_BUCKET always returns in seconds since epoch
+		seconds, err := NewElasticFloat(key)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected return-type from TIME_BUCKET")
 		}
-	} else {
-		return nil, fmt.Errorf("required either calendar or fixed interval")
+		return int64(seconds) * 1000, nil
+	}
+	// DATE_TRUNC always return actual timestamp
+	t, ok := key.(time.Time)
+	if !ok {
+		return 0, fmt.Errorf("unexpected return-type from DATE_TRUNC")
+	}
+	return t.UnixMilli(), nil
+}
+
+func (f *aggsDateHistogram) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	e, err := f.keyExpr(c)
+	if err != nil {
+		return nil, err
 	}
 
 	if f.HardBounds != nil && f.HardBounds.Min != nil {
@@ -185,18 +212,9 @@ func (f *aggsDateHistogram) process(c *aggsProcessContext) (any, error) {
 	if groups != nil {
 		result.Buckets = make([]bucketSingleResultWithKey, 0, len(groups.OrderedGroups))
 		for _, group := range groups.OrderedGroups {
-			var msSinceEpoch int64
-			if f.FixedInterval != nil {
-				// TIME_BUCKET always returns in seconds since epoch
-				msSinceEpoch = int64(group.KeyValues[0].(int)) * 1000
-			} else {
-				// DATE_PART always return actual timestamp
-				var ok bool
-				t, ok := group.KeyValues[0].(time.Time)
-				if !ok {
-					return nil, fmt.Errorf("unexpected return-type from DATE_PART")
-				}
-				msSinceEpoch = t.UnixMilli()
+			msSinceEpoch, err := f.keyMillis(group.KeyValues[0])
+			if err != nil {
+				return nil, err
 			}
 
 			if f.HardBounds != nil {
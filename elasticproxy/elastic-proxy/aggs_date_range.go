@@ -0,0 +1,115 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-aggregations-bucket-daterange-aggregation.html
+type aggsDateRange struct {
+	Field  string `json:"field"`
+	Format string `json:"format"`
+	Keyed  bool   `json:"keyed"`
+	Ranges []struct {
+		Key  string     `json:"key"`
+		From *dateBound `json:"from"`
+		To   *dateBound `json:"to"`
+	} `json:"ranges"`
+	TimeZone     string  `json:"time_zone"` // TODO
+	MissingValue *string `json:"missing"`   // TODO
+}
+
+// dateBound is a date that is specified either
+// as milliseconds since epoch, as a timestamp
+// or using date-math (i.e. "now-10d/d")
+type dateBound struct {
+	time.Time
+}
+
+func (d *dateBound) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		d.Time = time.UnixMilli(int64(v)).UTC()
+	case string:
+		t, err := parseDateTime(v)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", v); err != nil {
+				return fmt.Errorf("invalid date %q", v)
+			}
+		}
+		d.Time = t.UTC()
+	default:
+		return fmt.Errorf("invalid date %s", string(data))
+	}
+	return nil
+}
+
+func (f *aggsDateRange) buckets() ([]rangeBucket, error) {
+	if len(f.Ranges) == 0 {
+		return nil, errors.New("no ranges specified")
+	}
+	buckets := make([]rangeBucket, len(f.Ranges))
+	for i, r := range f.Ranges {
+		b := &buckets[i]
+		b.key = r.Key
+		if r.From != nil {
+			ms := float64(r.From.UnixMilli())
+			b.from, b.fromValue = &ms, r.From.Time
+			b.fromAsString = f.formatDate(r.From.Time)
+		}
+		if r.To != nil {
+			ms := float64(r.To.UnixMilli())
+			b.to, b.toValue = &ms, r.To.Time
+			b.toAsString = f.formatDate(r.To.Time)
+		}
+	}
+	return buckets, nil
+}
+
+func (f *aggsDateRange) formatDate(t time.Time) string {
+	if f.Format != "" {
+		if v, err := formatOutRaw(t, f.Format); err == nil {
+			if text, ok := v.(string); ok {
+				return text
+			}
+		}
+	}
+	// Elastic's default 'strict_date_optional_time' format
+	return t.Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+func (f *aggsDateRange) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	buckets, err := f.buckets()
+	if err != nil {
+		return nil, err
+	}
+	return transformRanges(c, f.Field, buckets)
+}
+
+func (f *aggsDateRange) process(c *aggsProcessContext) (any, error) {
+	buckets, err := f.buckets()
+	if err != nil {
+		return nil, err
+	}
+	return processRanges(c, buckets, f.Keyed)
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Sneller, Inc.
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	  http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package elastic_proxy
+
+import "math"
+
+type aggsExtendedStats struct {
+	fieldMetricAgg
+	Sigma *float64 `json:"sigma"`
+}
+
+func (f *aggsExtendedStats) transform(subBucket string, c *aggsGenerateContext) error {
+	field := ParseExprFieldName(c.context, f.Field)
+	fields := append(statsFields(c, f.Field),
+		exprObjectField{Name: "sum_of_squares", Expr: &exprFunction{
+			Context: c.context,
+			Name:    "SUM",
+			Exprs: []expression{&exprOperator2{
+				Context:  c.context,
+				Operator: "*",
+				Expr1:    field,
+				Expr2:    field,
+			}},
+		}},
+		exprObjectField{Name: "variance", Expr: &exprFunction{
+			Context: c.context,
+			Name:    "VARIANCE_POP",
+			Exprs:   []expression{field},
+		}},
+		exprObjectField{Name: "std_deviation", Expr: &exprFunction{
+			Context: c.context,
+			Name:    "STDDEV_POP",
+			Exprs:   []expression{field},
+		}},
+	)
+	c.addProjection(subBucket, &exprObject{
+		Context: c.context,
+		Fields:  fields,
+	})
+	return nil
+}
+
+func (f *aggsExtendedStats) process(c *aggsProcessContext) (any, error) {
+	v, _ := c.result()
+	values, _ := v.(map[string]any)
+
+	sigma := 2.0
+	if f.Sigma != nil {
+		sigma = *f.Sigma
+	}
+
+	r := extendedStatsResult{
+		statsResult:  newStatsResult(values),
+		SumOfSquares: numericResult(values["sum_of_squares"]),
+	}
+	varPop := numericResult(values["variance"])
+	stdPop := numericResult(values["std_deviation"])
+	if varPop == nil || stdPop == nil || r.Avg == nil {
+		return &r, nil
+	}
+
+	// the engine only computes the population variance,
+	// so the sample variance is derived from it
+	var varSample, stdSample *elasticFloat
+	if r.Count > 1 {
+		vs := *varPop * elasticFloat(r.Count) / elasticFloat(r.Count-1)
+		ss := elasticFloat(math.Sqrt(float64(vs)))
+		varSample, stdSample = &vs, &ss
+	}
+
+	bound := func(std *elasticFloat, dir float64) *elasticFloat {
+		if std == nil {
+			return nil
+		}
+		b := *r.Avg + elasticFloat(dir*sigma)**std
+		return &b
+	}
+
+	r.Variance = varPop
+	r.VariancePopulation = varPop
+	r.VarianceSampling = varSample
+	r.StdDeviation = stdPop
+	r.StdDeviationPopulation = stdPop
+	r.StdDeviationSampling = stdSample
+	r.StdDeviationBounds = stdDeviationBounds{
+		Upper:           bound(stdPop, 1),
+		Lower:           bound(stdPop, -1),
+		UpperPopulation: bound(stdPop, 1),
+		LowerPopulation: bound(stdPop, -1),
+		UpperSampling:   bound(stdSample, 1),
+		LowerSampling:   bound(stdSample, -1),
+	}
+	return &r, nil
+}
+
+type extendedStatsResult struct {
+	statsResult
+	SumOfSquares           *elasticFloat      `json:"sum_of_squares"`
+	Variance               *elasticFloat      `json:"variance"`
+	VariancePopulation     *elasticFloat      `json:"variance_population"`
+	VarianceSampling       *elasticFloat      `json:"variance_sampling"`
+	StdDeviation           *elasticFloat      `json:"std_deviation"`
+	StdDeviationPopulation *elasticFloat      `json:"std_deviation_population"`
+	StdDeviationSampling   *elasticFloat      `json:"std_deviation_sampling"`
+	StdDeviationBounds     stdDeviationBounds `json:"std_deviation_bounds"`
+}
+
+type stdDeviationBounds struct {
+	Upper           *elasticFloat `json:"upper"`
+	Lower           *elasticFloat `json:"lower"`
+	UpperPopulation *elasticFloat `json:"upper_population"`
+	LowerPopulation *elasticFloat `json:"lower_population"`
+	UpperSampling   *elasticFloat `json:"upper_sampling"`
+	LowerSampling   *elasticFloat `json:"lower_sampling"`
+}
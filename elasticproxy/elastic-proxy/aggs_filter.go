@@ -33,6 +33,12 @@ func (f *aggsFilter) transform(c *aggsGenerateContext) ([]projectAliasExpr, erro
 }
 
 func (f *aggsFilter) process(c *aggsProcessContext) (any, error) {
+	return singleBucketResult(c)
+}
+
+// singleBucketResult returns the result of an
+// aggregation that produces a single bucket
+func singleBucketResult(c *aggsProcessContext) (any, error) {
 	group, _ := c.data.(*groupResults)
 
 	bucketResult, err := c.subResult(group)
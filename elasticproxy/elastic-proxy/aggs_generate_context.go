@@ -28,6 +28,7 @@ type aggsGenerateContext struct {
 	groupExprs          []projectAliasExpr
 	groupKeyIndex       int
 	projections         []projectAliasExpr
+	valueCounts         []projectAliasExpr
 	orderBy             []orderByExpr
 	nestingLevel        int
 }
@@ -92,6 +93,24 @@ func (c *aggsGenerateContext) setSize(size *int) *aggsGenerateContext {
 }
 
 func (c *aggsGenerateContext) makeCountStar() *exprFunction {
+	// count the (distinct) documents instead
+	// of the nested rows for reverse_nested
+	if c.context.docKey != nil {
+		return &exprFunction{
+			Context: c.context,
+			Name:    "COUNT",
+			Exprs:   []expression{&exprOperator1{Context: c.context, Operator: "DISTINCT", Expr1: c.context.docKey}},
+		}
+	}
+	// count the nested array elements (COUNT(*)
+	// would drop the unnesting if it's unused)
+	if n := len(c.context.nested); n > 0 {
+		return &exprFunction{
+			Context: c.context,
+			Name:    "COUNT",
+			Exprs:   []expression{ParseExprSourceName(c.context, c.context.nested[n-1].Alias)},
+		}
+	}
 	return &exprFunction{
 		Context: c.context,
 		Name:    "COUNT",
@@ -121,6 +140,19 @@ func (c *aggsGenerateContext) addProjection(alias string, e expression) *aggsGen
 	return c
 }
 
+// addValueCounts requests the number of occurrences of
+// each value of e in each group of the bucket; the counts
+// are stored as a []valueCount in the results of the
+// aggregation with the given name
+func (c *aggsGenerateContext) addValueCounts(name string, e expression) *aggsGenerateContext {
+	c.valueCounts = append(c.valueCounts, projectAliasExpr{
+		Context:    c.context,
+		Alias:      name,
+		expression: e,
+	})
+	return c
+}
+
 func (c *aggsGenerateContext) allGroupExprs() []projectAliasExpr {
 	if c.parent == nil {
 		return c.groupExprs
@@ -172,88 +204,24 @@ func (c *aggsGenerateContext) transform() ([]projectAliasExpr, error) {
 	}
 
 	where := c.query
-	if c.nestingLevel > 1 {
-		parentGroups := c.parent.allGroupExprs()
-
-		// generate SELECT of the parent nodes
-		const SelectionSource = "$selection"
-		parentBucket := fmt.Sprintf("%s:%s%%%d", BucketPrefix, c.parent.bucket, 0)
-		parentSelect := exprSelect{
-			Context: c.context,
-			From:    []expression{ParseExprSourceNameWithAlias(c.context, parentBucket, SelectionSource)},
-		}
-		for _, pg := range parentGroups {
-			parentSelect.Projection = append(parentSelect.Projection, projectAliasExpr{
-				Context: c.context,
-				expression: &exprFieldName{
-					Context: c.context,
-					Source:  SelectionSource,
-					Fields:  []string{pg.Alias},
-				},
-			})
-		}
-
-		var sourceExpr expression
-		if len(parentGroups) > 1 {
-			// Use the { '$key1': .., '$key2': .. } IN PARENT format
-			sourceFields := make([]exprObjectField, len(parentGroups))
-			selectFields := make([]exprObjectField, len(parentGroups))
-			for i, pg := range parentGroups {
-				sourceFields[i] = exprObjectField{
-					Name: pg.Alias,
-					Expr: pg.expression,
-				}
-				selectFields[i] = exprObjectField{
-					Name: pg.Alias,
-					Expr: &exprFieldName{
-						Context: c.context,
-						Source:  SelectionSource,
-						Fields:  []string{pg.Alias},
-					},
-				}
-			}
-			sourceExpr = &exprObject{
-				Context: c.context,
-				Fields:  sourceFields,
-			}
-			parentSelect.Projection = []projectAliasExpr{
-				{
-					Context: c.context,
-					expression: &exprObject{
-						Context: c.context,
-						Fields:  selectFields,
-					},
-				},
-			}
-		} else {
-			// Use the "$key1" IN PARENT format
-			parentSelect.Projection = []projectAliasExpr{
-				{
-					Context: c.context,
-					expression: &exprFieldName{
-						Context: c.context,
-						Source:  SelectionSource,
-						Fields:  []string{parentGroups[0].Alias},
-					},
-				},
-			}
-			sourceExpr = parentGroups[0].expression
-		}
-
-		inExpr := exprOperator2{
-			Context:  c.context,
-			Operator: "IN",
-			Expr1:    sourceExpr,
-			Expr2:    &parentSelect,
+	if c.nestingLevel > 1 && len(c.parent.allGroupExprs()) == 0 {
+		// single bucket parents (i.e. filter or nested) don't
+		// group the rows, so their queries are applied directly
+		exprs := []expression{where}
+		for p := c.parent; p != nil; p = p.parent {
+			exprs = append(exprs, p.query)
 		}
+		where = andExpressions(exprs)
+	} else if c.nestingLevel > 1 {
+		inExpr := c.inBucket(c.parent.bucket, c.parent.allGroupExprs())
 
 		if where == nil {
-			where = &inExpr
+			where = inExpr
 		} else {
 			where = &exprOperator2{
 				Context:  c.context,
 				Operator: "AND",
-				Expr1:    &inExpr,
+				Expr1:    inExpr,
 				Expr2:    where,
 			}
 		}
@@ -271,8 +239,17 @@ func (c *aggsGenerateContext) transform() ([]projectAliasExpr, error) {
 		}
 
 		if c.size > 0 {
-			if c.nestingLevel > 1 {
-				parentGroups := c.parent.allGroupExprs()
+			var parentGroups []projectAliasExpr
+			if c.parent != nil {
+				parentGroups = c.parent.allGroupExprs()
+			}
+			switch {
+			case len(parentGroups) == 0:
+				mainSelect.Limit = c.size
+			case c.context.docKey != nil:
+				// ROW_NUMBER() can't be combined with COUNT(DISTINCT ...),
+				// so the buckets are limited when processing the results
+			default:
 				partitionBy := make([]expression, len(parentGroups))
 				for i, pg := range parentGroups {
 					partitionBy[i] = pg.expression
@@ -293,9 +270,6 @@ func (c *aggsGenerateContext) transform() ([]projectAliasExpr, error) {
 						Value:   JSONLiteral{Value: c.size},
 					},
 				}
-
-			} else {
-				mainSelect.Limit = c.size
 			}
 		}
 
@@ -318,6 +292,7 @@ func (c *aggsGenerateContext) transform() ([]projectAliasExpr, error) {
 							Context:    c.context,
 							expression: ParseExprSourceName(c.context, proj.Alias),
 							Order:      orderBy.Order,
+							NullsLast:  orderBy.NullsLast,
 						}
 						break
 					}
@@ -335,9 +310,111 @@ func (c *aggsGenerateContext) transform() ([]projectAliasExpr, error) {
 		}
 	}
 
+	// the value counts are grouped by the value as well,
+	// so they are obtained using a separate query that
+	// only counts the values in the selected groups
+	for i, vc := range c.valueCounts {
+		valueWhere := where
+		if len(allGroupExprs) > 0 && len(c.projections) > 0 {
+			valueWhere = andExpressions([]expression{c.inBucket(c.bucket, allGroupExprs), where})
+		}
+		valueSelect := exprSelect{
+			Context: c.context,
+			Projection: append(append([]projectAliasExpr{}, allGroupExprs...),
+				projectAliasExpr{
+					Context:    c.context,
+					Alias:      fmt.Sprintf("%s:%s%%%d", ValuesPrefix, vc.Alias, 0),
+					expression: vc.expression,
+				},
+				projectAliasExpr{
+					Context: c.context,
+					Alias:   fmt.Sprintf("%s:%s%%%d", ValuesPrefix, vc.Alias, 1),
+					expression: &exprFunction{
+						Context: c.context,
+						Name:    "COUNT",
+						Exprs:   []expression{vc.expression},
+					},
+				}),
+			From:    c.context.Sources,
+			Where:   valueWhere,
+			GroupBy: append(append([]expression{}, groupByExpr...), vc.expression),
+		}
+		queries = append(queries, projectAliasExpr{
+			Context:    c.context,
+			Alias:      fmt.Sprintf("%s:%s%%%d", BucketPrefix, c.bucket, i+1),
+			expression: &valueSelect,
+		})
+	}
+
 	if len(subQueries) > 0 {
 		queries = append(queries, subQueries...)
 	}
 
 	return queries, nil
 }
+
+// inBucket returns the condition that selects the rows
+// that belong to one of the groups of the given bucket
+func (c *aggsGenerateContext) inBucket(bucket string, groups []projectAliasExpr) expression {
+	// generate SELECT of the bucket
+	const SelectionSource = "$selection"
+	bucketName := fmt.Sprintf("%s:%s%%%d", BucketPrefix, bucket, 0)
+	bucketSelect := exprSelect{
+		Context: c.context,
+		From:    []expression{ParseExprSourceNameWithAlias(c.context, bucketName, SelectionSource)},
+	}
+
+	var sourceExpr expression
+	if len(groups) > 1 {
+		// Use the { '$key1': .., '$key2': .. } IN PARENT format
+		sourceFields := make([]exprObjectField, len(groups))
+		selectFields := make([]exprObjectField, len(groups))
+		for i, g := range groups {
+			sourceFields[i] = exprObjectField{
+				Name: g.Alias,
+				Expr: g.expression,
+			}
+			selectFields[i] = exprObjectField{
+				Name: g.Alias,
+				Expr: &exprFieldName{
+					Context: c.context,
+					Source:  SelectionSource,
+					Fields:  []string{g.Alias},
+				},
+			}
+		}
+		sourceExpr = &exprObject{
+			Context: c.context,
+			Fields:  sourceFields,
+		}
+		bucketSelect.Projection = []projectAliasExpr{
+			{
+				Context: c.context,
+				expression: &exprObject{
+					Context: c.context,
+					Fields:  selectFields,
+				},
+			},
+		}
+	} else {
+		// Use the "$key1" IN PARENT format
+		bucketSelect.Projection = []projectAliasExpr{
+			{
+				Context: c.context,
+				expression: &exprFieldName{
+					Context: c.context,
+					Source:  SelectionSource,
+					Fields:  []string{groups[0].Alias},
+				},
+			},
+		}
+		sourceExpr = groups[0].expression
+	}
+
+	return &exprOperator2{
+		Context:  c.context,
+		Operator: "IN",
+		Expr1:    sourceExpr,
+		Expr2:    &bucketSelect,
+	}
+}
@@ -161,6 +161,8 @@ func NewElasticFloat(v any) (elasticFloat, error) {
 	switch v := v.(type) {
 	case int:
 		return elasticFloat(float64(v)), nil
+	case int64:
+		return elasticFloat(float64(v)), nil
 	case float64:
 		return elasticFloat(v), nil
 	}
@@ -0,0 +1,56 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import "fmt"
+
+// aggsNested aggregates the elements of a nested
+// array, so each array element is a (nested) document
+type aggsNested struct {
+	Path string `json:"path"`
+}
+
+func (n *aggsNested) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	if n.Path == "" {
+		return nil, fmt.Errorf("nested aggregation %q requires a 'path'", c.bucket)
+	}
+
+	c.context = c.context.withNested(n.Path)
+	return c.addDocCount(true).transform()
+}
+
+func (n *aggsNested) process(c *aggsProcessContext) (any, error) {
+	return singleBucketResult(c)
+}
+
+// aggsReverseNested aggregates the parent
+// documents of the nested array elements
+type aggsReverseNested struct {
+	Path string `json:"path"`
+}
+
+func (r *aggsReverseNested) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	qc, err := c.context.withReverseNested(r.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use reverse_nested aggregation %q: %w", c.bucket, err)
+	}
+
+	c.context = qc
+	return c.addDocCount(true).transform()
+}
+
+func (r *aggsReverseNested) process(c *aggsProcessContext) (any, error) {
+	return singleBucketResult(c)
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Sneller, Inc.
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	  http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package elastic_proxy
+
+import "fmt"
+
+type aggsPercentileRanks struct {
+	fieldMetricAgg
+	Values []float64 `json:"values"`
+	Keyed  *bool     `json:"keyed"`
+}
+
+func (f *aggsPercentileRanks) transform(subBucket string, c *aggsGenerateContext) error {
+	if len(f.Values) == 0 {
+		return fmt.Errorf("'percentile_ranks' aggregation %q requires values", subBucket)
+	}
+
+	// the ranks are interpolated between the
+	// values, so they are determined from the
+	// number of occurrences of each value
+	c.addValueCounts(subBucket, ParseExprFieldName(c.context, f.Field))
+	return nil
+}
+
+func (f *aggsPercentileRanks) process(c *aggsProcessContext) (any, error) {
+	v, _ := c.result()
+	counts, _ := v.([]valueCount)
+
+	r := percentilesResult{
+		Keys:   f.Values,
+		Values: make([]*elasticFloat, len(f.Values)),
+		Keyed:  f.Keyed == nil || *f.Keyed,
+	}
+	d := newDigest(counts)
+	for i, x := range r.Keys {
+		r.Values[i] = d.rank(x)
+	}
+	return &r, nil
+}
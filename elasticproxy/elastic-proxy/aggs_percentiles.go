@@ -0,0 +1,204 @@
+// Copyright 2023 Sneller, Inc.
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	  http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package elastic_proxy
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type aggsPercentiles struct {
+	fieldMetricAgg
+	Percents []float64 `json:"percents"`
+	Keyed    *bool     `json:"keyed"`
+}
+
+var defaultPercents = []float64{1, 5, 25, 50, 75, 95, 99}
+
+func (f *aggsPercentiles) percents() []float64 {
+	if f.Percents == nil {
+		return defaultPercents
+	}
+	return f.Percents
+}
+
+func (f *aggsPercentiles) transform(subBucket string, c *aggsGenerateContext) error {
+	for _, p := range f.percents() {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("invalid percent %v in 'percentiles' aggregation %q", p, subBucket)
+		}
+	}
+
+	// APPROX_PERCENTILE can't be used in a GROUP BY (yet),
+	// so the percentiles of each group are determined
+	// from the number of occurrences of each value
+	if len(c.allGroupExprs()) > 0 {
+		c.addValueCounts(subBucket, ParseExprFieldName(c.context, f.Field))
+		return nil
+	}
+
+	fields := make([]exprObjectField, len(f.percents()))
+	for i, p := range f.percents() {
+		// APPROX_PERCENTILE requires a floating-point literal
+		// and uses single-precision for the percentile
+		fraction := strconv.FormatFloat(p/100, 'f', -1, 32)
+		if !strings.Contains(fraction, ".") {
+			fraction += ".0"
+		}
+		fields[i] = exprObjectField{
+			Name: strconv.Itoa(i),
+			Expr: &exprFunction{
+				Context: c.context,
+				Name:    "APPROX_PERCENTILE",
+				Exprs: []expression{
+					ParseExprFieldName(c.context, f.Field),
+					&exprText{Context: c.context, Value: fraction},
+				},
+			},
+		}
+	}
+	c.addProjection(subBucket, &exprObject{
+		Context: c.context,
+		Fields:  fields,
+	})
+	return nil
+}
+
+func (f *aggsPercentiles) process(c *aggsProcessContext) (any, error) {
+	v, _ := c.result()
+
+	r := percentilesResult{
+		Keys:   f.percents(),
+		Values: make([]*elasticFloat, len(f.percents())),
+		Keyed:  f.Keyed == nil || *f.Keyed,
+	}
+	if counts, ok := v.([]valueCount); ok {
+		d := newDigest(counts)
+		for i, p := range r.Keys {
+			r.Values[i] = d.percentile(p)
+		}
+		return &r, nil
+	}
+	values, _ := v.(map[string]any)
+	for i := range r.Values {
+		r.Values[i] = numericResult(values[strconv.Itoa(i)])
+	}
+	return &r, nil
+}
+
+// digest holds the distinct values of a field
+// in ascending order and the number of values
+// up to and including the middle of each value
+// (like the centroids of a t-digest that each
+// hold a single distinct value)
+type digest struct {
+	values []float64
+	mids   []float64
+	total  int64
+}
+
+func newDigest(counts []valueCount) *digest {
+	slices.SortFunc(counts, func(a, b valueCount) int {
+		return cmp.Compare(a.value, b.value)
+	})
+	d := &digest{}
+	for _, vc := range counts {
+		if vc.count <= 0 {
+			continue
+		}
+		d.values = append(d.values, vc.value)
+		d.mids = append(d.mids, float64(d.total)+float64(vc.count)/2)
+		d.total += vc.count
+	}
+	return d
+}
+
+// percentile returns the value below which
+// p percent of the values fall; values between
+// the middles of two distinct values are
+// interpolated linearly
+func (d *digest) percentile(p float64) *elasticFloat {
+	if d.total == 0 {
+		return nil
+	}
+	target := p / 100 * float64(d.total)
+	i := sort.SearchFloat64s(d.mids, target)
+	var v float64
+	switch {
+	case i == 0:
+		v = d.values[0]
+	case i == len(d.mids):
+		v = d.values[i-1]
+	default:
+		frac := (target - d.mids[i-1]) / (d.mids[i] - d.mids[i-1])
+		v = d.values[i-1] + frac*(d.values[i]-d.values[i-1])
+	}
+	return numericResult(v)
+}
+
+// rank returns the percentage of the values
+// that are below x; this is the inverse of percentile
+func (d *digest) rank(x float64) *elasticFloat {
+	if d.total == 0 {
+		return nil
+	}
+	i := sort.SearchFloat64s(d.values, x)
+	var n float64
+	switch {
+	case i < len(d.values) && d.values[i] == x:
+		n = d.mids[i]
+	case i == 0:
+		n = 0
+	case i == len(d.values):
+		n = float64(d.total)
+	default:
+		frac := (x - d.values[i-1]) / (d.values[i] - d.values[i-1])
+		n = d.mids[i-1] + frac*(d.mids[i]-d.mids[i-1])
+	}
+	return numericResult(n * 100 / float64(d.total))
+}
+
+// percentilesResult holds the result of the
+// percentiles and percentile_ranks aggregations
+type percentilesResult struct {
+	Keys   []float64
+	Values []*elasticFloat
+	Keyed  bool
+}
+
+func (r *percentilesResult) MarshalJSON() ([]byte, error) {
+	if r.Keyed {
+		values := make(map[string]*elasticFloat, len(r.Keys))
+		for i, k := range r.Keys {
+			key := elasticFloat(k)
+			values[key.String()] = r.Values[i]
+		}
+		return json.Marshal(map[string]any{"values": values})
+	}
+
+	type keyValue struct {
+		Key   elasticFloat  `json:"key"`
+		Value *elasticFloat `json:"value"`
+	}
+	values := make([]*keyValue, len(r.Keys))
+	for i, k := range r.Keys {
+		values[i] = &keyValue{Key: elasticFloat(k), Value: r.Values[i]}
+	}
+	return json.Marshal(map[string]any{"values": values})
+}
@@ -0,0 +1,301 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-aggregations-bucket-range-aggregation.html
+type aggsRange struct {
+	Field  string `json:"field"`
+	Keyed  bool   `json:"keyed"`
+	Ranges []struct {
+		Key  string   `json:"key"`
+		From *float64 `json:"from"`
+		To   *float64 `json:"to"`
+	} `json:"ranges"`
+	MissingValue *float64 `json:"missing"` // TODO
+}
+
+func (f *aggsRange) buckets() ([]rangeBucket, error) {
+	if len(f.Ranges) == 0 {
+		return nil, errors.New("no ranges specified")
+	}
+	buckets := make([]rangeBucket, len(f.Ranges))
+	for i, r := range f.Ranges {
+		b := &buckets[i]
+		b.key = r.Key
+		if r.From != nil {
+			b.from, b.fromValue = r.From, *r.From
+		}
+		if r.To != nil {
+			b.to, b.toValue = r.To, *r.To
+		}
+	}
+	return buckets, nil
+}
+
+func (f *aggsRange) transform(c *aggsGenerateContext) ([]projectAliasExpr, error) {
+	buckets, err := f.buckets()
+	if err != nil {
+		return nil, err
+	}
+	return transformRanges(c, f.Field, buckets)
+}
+
+func (f *aggsRange) process(c *aggsProcessContext) (any, error) {
+	buckets, err := f.buckets()
+	if err != nil {
+		return nil, err
+	}
+	return processRanges(c, buckets, f.Keyed)
+}
+
+// rangeBucket is a single range of a range
+// or date_range aggregation. The range includes
+// the lower bound and excludes the upper bound,
+// where a nil bound means that it is unbounded.
+type rangeBucket struct {
+	key  string
+	from *float64
+	to   *float64
+
+	// SQL values of the bounds
+	fromValue any
+	toValue   any
+
+	// formatted bounds (optional)
+	fromAsString string
+	toAsString   string
+}
+
+// sortRanges orders the ranges the same way as
+// Elastic does and rejects overlapping ranges,
+// because each document is assigned to only a
+// single range-bucket.
+func sortRanges(buckets []rangeBucket) error {
+	sort.SliceStable(buckets, func(i, j int) bool {
+		a, b := &buckets[i], &buckets[j]
+		if a.from == nil || b.from == nil {
+			if (a.from == nil) != (b.from == nil) {
+				return a.from == nil
+			}
+		} else if *a.from != *b.from {
+			return *a.from < *b.from
+		}
+		if a.to == nil || b.to == nil {
+			return b.to == nil && a.to != nil
+		}
+		return *a.to < *b.to
+	})
+	for i := 1; i < len(buckets); i++ {
+		prev, cur := &buckets[i-1], &buckets[i]
+		if prev.to == nil || cur.from == nil || *prev.to > *cur.from {
+			return errors.New("overlapping ranges are not supported")
+		}
+	}
+	return nil
+}
+
+func transformRanges(c *aggsGenerateContext, field string, buckets []rangeBucket) ([]projectAliasExpr, error) {
+	if err := sortRanges(buckets); err != nil {
+		return nil, err
+	}
+
+	// the group-key is the index of the range
+	// that the value belongs to
+	caseExpr := &exprCase{Context: c.context}
+	var conditions []expression
+	for i := range buckets {
+		b := &buckets[i]
+		var bounds []expression
+		if b.from != nil {
+			bounds = append(bounds, &exprOperator2{
+				Context:  c.context,
+				Operator: ">=",
+				Expr1:    ParseExprFieldName(c.context, field),
+				Expr2:    &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: b.fromValue}},
+			})
+		}
+		if b.to != nil {
+			bounds = append(bounds, &exprOperator2{
+				Context:  c.context,
+				Operator: "<",
+				Expr1:    ParseExprFieldName(c.context, field),
+				Expr2:    &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: b.toValue}},
+			})
+		}
+		if len(bounds) == 0 {
+			bounds = append(bounds, &exprOperator1{
+				Context:  c.context,
+				Operator: "IS NOT MISSING",
+				Expr1:    ParseExprFieldName(c.context, field),
+			})
+		}
+		cond := andExpressions(bounds)
+		caseExpr.Whens = append(caseExpr.Whens, exprCaseWhen{
+			When: cond,
+			Then: &exprJSONLiteral{Context: c.context, Value: JSONLiteral{Value: i}},
+		})
+		conditions = append(conditions, cond)
+	}
+
+	// only scan values that are in one of the ranges
+	c.andQuery(joinExpressions(conditions, "OR"))
+
+	subContext := c.addGroupExpr(caseExpr).addOrdering(orderByExpr{
+		Context:    c.context,
+		expression: caseExpr,
+		Order:      "ASC",
+	}).addDocCount(false)
+
+	return subContext.transform()
+}
+
+func processRanges(c *aggsProcessContext, buckets []rangeBucket, keyed bool) (any, error) {
+	if err := sortRanges(buckets); err != nil {
+		return nil, err
+	}
+
+	groupsByIndex := make(map[int]*groupResults)
+	if groups := c.groups(); groups != nil {
+		for _, group := range groups.OrderedGroups {
+			index, err := NewElasticFloat(group.KeyValues[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range-index: %w", err)
+			}
+			groupsByIndex[int(index)] = group
+		}
+	}
+
+	// Elastic returns all ranges (even if they are empty)
+	result := rangeResult{
+		Buckets: make([]rangeBucketResult, 0, len(buckets)),
+		Keyed:   keyed,
+	}
+	for i := range buckets {
+		b := &buckets[i]
+
+		var docCount int64
+		group := groupsByIndex[i]
+		if group != nil {
+			var err error
+			docCount, err = group.docCount()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		c.docCount = docCount
+		bucketResult, err := c.subResult(group)
+		if err != nil {
+			return nil, err
+		}
+
+		br := rangeBucketResult{
+			bucketSingleResult: bucketSingleResult{
+				SubAggregations: bucketResult,
+				DocCount:        docCount,
+			},
+			Key:          b.key,
+			FromAsString: b.fromAsString,
+			ToAsString:   b.toAsString,
+		}
+		if b.from != nil {
+			from := elasticFloat(*b.from)
+			br.From = &from
+		}
+		if b.to != nil {
+			to := elasticFloat(*b.to)
+			br.To = &to
+		}
+		if br.Key == "" {
+			br.Key = rangeKey(br.From, b.fromAsString) + "-" + rangeKey(br.To, b.toAsString)
+		}
+		result.Buckets = append(result.Buckets, br)
+	}
+
+	return &result, nil
+}
+
+func rangeKey(v *elasticFloat, text string) string {
+	if text != "" {
+		return text
+	}
+	if v == nil {
+		return "*"
+	}
+	return v.String()
+}
+
+// rangeResult holds the result of a range
+// or date_range aggregation. The buckets are
+// returned as an array or as a hash-map (keyed).
+type rangeResult struct {
+	Buckets []rangeBucketResult
+	Keyed   bool
+}
+
+func (r *rangeResult) MarshalJSON() ([]byte, error) {
+	if !r.Keyed {
+		return json.Marshal(map[string]any{"buckets": r.Buckets})
+	}
+	buckets := make(map[string]any, len(r.Buckets))
+	for i := range r.Buckets {
+		buckets[r.Buckets[i].Key] = r.Buckets[i].jsonMap(false)
+	}
+	return json.Marshal(map[string]any{"buckets": buckets})
+}
+
+type rangeBucketResult struct {
+	bucketSingleResult
+	Key          string
+	From         *elasticFloat
+	To           *elasticFloat
+	FromAsString string
+	ToAsString   string
+}
+
+func (r *rangeBucketResult) jsonMap(withKey bool) map[string]any {
+	jsonMap := make(map[string]any, len(r.SubAggregations)+6)
+	for k, v := range r.SubAggregations {
+		jsonMap[k] = v
+	}
+	jsonMap["doc_count"] = r.DocCount
+	if withKey {
+		jsonMap["key"] = r.Key
+	}
+	if r.From != nil {
+		jsonMap["from"] = r.From
+		if r.FromAsString != "" {
+			jsonMap["from_as_string"] = r.FromAsString
+		}
+	}
+	if r.To != nil {
+		jsonMap["to"] = r.To
+		if r.ToAsString != "" {
+			jsonMap["to_as_string"] = r.ToAsString
+		}
+	}
+	return jsonMap
+}
+
+func (r *rangeBucketResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.jsonMap(true))
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Sneller, Inc.
+//
+//	Licensed under the Apache License, Version 2.0 (the "License");
+//	you may not use this file except in compliance with the License.
+//	You may obtain a copy of the License at
+//
+//	  http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+package elastic_proxy
+
+import "math"
+
+type aggsStats struct {
+	fieldMetricAgg
+}
+
+func (f *aggsStats) transform(subBucket string, c *aggsGenerateContext) error {
+	c.addProjection(subBucket, &exprObject{
+		Context: c.context,
+		Fields:  statsFields(c, f.Field),
+	})
+	return nil
+}
+
+func (f *aggsStats) process(c *aggsProcessContext) (any, error) {
+	v, _ := c.result()
+	values, _ := v.(map[string]any)
+	return newStatsResult(values), nil
+}
+
+// statsFields returns the fields that are
+// projected by the stats aggregation
+func statsFields(c *aggsGenerateContext, field string) []exprObjectField {
+	fn := func(name string) expression {
+		return &exprFunction{
+			Context: c.context,
+			Name:    name,
+			Exprs:   []expression{ParseExprFieldName(c.context, field)},
+		}
+	}
+	return []exprObjectField{
+		{Name: "count", Expr: fn("COUNT")},
+		{Name: "min", Expr: fn("MIN")},
+		{Name: "max", Expr: fn("MAX")},
+		{Name: "avg", Expr: fn("AVG")},
+		{Name: "sum", Expr: fn("SUM")},
+	}
+}
+
+type statsResult struct {
+	Count int64         `json:"count"`
+	Min   *elasticFloat `json:"min"`
+	Max   *elasticFloat `json:"max"`
+	Avg   *elasticFloat `json:"avg"`
+	Sum   *elasticFloat `json:"sum"`
+}
+
+func newStatsResult(values map[string]any) statsResult {
+	r := statsResult{
+		Min: numericResult(values["min"]),
+		Max: numericResult(values["max"]),
+		Avg: numericResult(values["avg"]),
+		Sum: numericResult(values["sum"]),
+	}
+	if count := numericResult(values["count"]); count != nil {
+		r.Count = int64(*count)
+	}
+	if r.Sum == nil {
+		// Elastic reports a sum of 0 for empty sets
+		zero := elasticFloat(0)
+		r.Sum = &zero
+	}
+	return r
+}
+
+// numericResult converts a numeric result to
+// an elasticFloat or returns nil if the value
+// is missing or not a finite number
+func numericResult(v any) *elasticFloat {
+	f, err := NewElasticFloat(v)
+	if err != nil || math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return nil
+	}
+	return &f
+}
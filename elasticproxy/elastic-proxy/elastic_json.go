@@ -32,11 +32,16 @@ import (
 const (
 	TotalCountBucket  = "$total_count"
 	HitsBucket        = "$hits"
+	ScoresBucket      = "$scores"
 	KeyPrefix         = "$key"
 	BucketPrefix      = "$bucket"
+	ValuesPrefix      = "$values"
 	DocCount          = "$doc_count"
 	DefaultSource     = "$source"
 	SourceAliasPrefix = "$source:"
+	StatsSource       = "$stats"
+	ScoreField        = "$score"
+	ScoreSortField    = "_score"
 )
 
 type ElasticJSON struct {
@@ -49,6 +54,13 @@ type ElasticJSON struct {
 	Source         *source                `json:"_source"` // indicates if source record should be included in the hit
 	Fields         []projectedField       `json:"fields"`
 	TrackTotalHits *TrackTotalHits        `json:"track_total_hits"`
+	SearchAfter    []JSONLiteral          `json:"search_after"`
+	PIT            *PointInTime           `json:"pit"`
+	Highlight      *Highlight             `json:"highlight"`
+
+	// ScrollPosition is set when fetching
+	// the next page of a scroll
+	ScrollPosition *ScrollPosition `json:"-"`
 }
 
 type source struct {
@@ -95,6 +107,18 @@ type sortFieldInner struct {
 }
 
 func (sf *SortField) UnmarshalJSON(data []byte) error {
+	// a field name sorts ascending, except for
+	// the score that sorts descending
+	var field string
+	if err := json.Unmarshal(data, &field); err == nil {
+		sf.Field = field
+		sf.Order = OrderAscending
+		if field == ScoreSortField {
+			sf.Order = OrderDescending
+		}
+		return nil
+	}
+
 	var vv map[string]sortFieldInner
 	if err := json.Unmarshal(data, &vv); err != nil {
 		return err
@@ -177,6 +201,8 @@ type ElasticResult struct {
 	Count        *int64              `json:"count,omitempty"` // only for Counting API
 	Took         int                 `json:"took"`
 	Aggregations *map[string]any     `json:"aggregations,omitempty"`
+	ScrollID     string              `json:"_scroll_id,omitempty"` // only for scrolling
+	PitID        string              `json:"pit_id,omitempty"`     // only for point-in-time searches
 }
 
 type elasticResultHits struct {
@@ -186,14 +212,17 @@ type elasticResultHits struct {
 }
 
 type elasticResultHitRecord struct {
-	Score   *float64         `json:"_score"`
-	Type    string           `json:"_type"`
-	Id      string           `json:"_id"`
-	Source  any              `json:"_source,omitempty"`
-	Fields  map[string][]any `json:"fields,omitempty"`
-	Version *int             `json:"_version,omitempty"`
-	Index   string           `json:"_index,omitempty"`
-	Sort    []any            `json:"sort,omitempty"`
+	Score     *float64            `json:"_score"`
+	Type      string              `json:"_type"`
+	Id        string              `json:"_id"`
+	Source    any                 `json:"_source,omitempty"`
+	Fields    map[string][]any    `json:"fields,omitempty"`
+	Version   *int                `json:"_version,omitempty"`
+	Index     string              `json:"_index,omitempty"`
+	Sort      []any               `json:"sort,omitempty"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+
+	rawSort []any // sort values (before conversion)
 }
 
 type elasticResultHitsTotal struct {
@@ -326,12 +355,20 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 	}
 
 	var qExpr expression
+	var nestedKeys []expression
+	var scoreTerms []scoreTerm
 	if ej.Query != nil {
 		var err error
+		qc.nestedKeys = &nestedKeys
+		qc.scoring, qc.scoreBoost = &scoreTerms, 1
 		qExpr, err = ej.Query.Expression(qc)
+		qc.nestedKeys, qc.scoring = nil, nil
 		if err != nil {
 			return nil, err
 		}
+		if ej.Highlight != nil {
+			qc.highlightTerms = highlightTerms(qExpr)
+		}
 	}
 
 	from := projectAliasExpr{
@@ -343,6 +380,13 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 		Alias: DefaultSource,
 	}
 
+	// the statistics for the relevance score are
+	// only needed when the hits are sorted by score
+	var stats *exprSelect
+	if effectiveSize > 0 && len(scoreTerms) > 0 && ej.sortedByScore() {
+		stats, qc.score = scoreExpressions(qc, scoreTerms, &from)
+	}
+
 	source := projectAliasExpr{
 		Context: qc,
 		Alias:   DefaultSource,
@@ -356,6 +400,9 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 			},
 			From:  append([]expression{&from}, qc.Sources...),
 			Where: qExpr,
+			// documents that match multiple elements of
+			// a nested query should only be returned once
+			DistinctOn: nestedKeys,
 		},
 	}
 
@@ -417,26 +464,46 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 		}
 
 		var orderBy []orderByExpr
-		if ej.Sort != nil {
-			for _, proj := range ej.Sort {
-				orderBy = append(orderBy, orderByExpr{
-					Context:    qc,
-					expression: ParseExprFieldName(qc, proj.Field),
-					Order:      proj.Order,
-				})
+		for _, proj := range ej.Sort {
+			if proj.Field == ScoreSortField && qc.score == nil {
+				continue // all hits have the same score
 			}
+			orderBy = append(orderBy, orderByExpr{
+				Context:    qc,
+				expression: qc.sortExpression(proj.Field),
+				Order:      proj.Order,
+			})
+		}
+		if len(ej.Sort) == 0 && qc.score != nil {
+			orderBy = []orderByExpr{{Context: qc, expression: qc.score, Order: OrderDescending}}
+		}
+		hits := &exprSelect{
+			Context:    qc,
+			Projection: []projectAliasExpr{{Context: qc, expression: &exprFieldName{Context: qc}}},
+			From:       fromSources,
+			Offset:     effectiveOffset,
+			Limit:      effectiveSize,
+			OrderBy:    orderBy,
+		}
+		if err := ej.paginate(qc, hits); err != nil {
+			return nil, err
 		}
 		projectExprs = append(projectExprs, projectAliasExpr{
-			Alias: HitsBucket,
-			expression: &exprSelect{
-				Context:    qc,
-				Projection: []projectAliasExpr{{Context: qc, expression: &exprFieldName{Context: qc}}},
-				From:       fromSources,
-				Offset:     effectiveOffset,
-				Limit:      effectiveSize,
-				OrderBy:    orderBy,
-			},
+			Alias:      HitsBucket,
+			expression: hits,
 		})
+
+		if qc.score != nil {
+			// the scores are returned separately (using
+			// the same order), because the hits can't
+			// be extended with additional columns
+			scores := *hits
+			scores.Projection = []projectAliasExpr{{Context: qc, Alias: ScoreField, expression: qc.score}}
+			projectExprs = append(projectExprs, projectAliasExpr{
+				Alias:      ScoresBucket,
+				expression: &scores,
+			})
+		}
 	}
 
 	qc.Sources = fromSources
@@ -451,7 +518,13 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 		return nil, err
 	}
 
-	withExprs := []projectAliasExpr{source}
+	var withExprs []projectAliasExpr
+	if stats != nil {
+		// the statistics need to be determined before the
+		// source, because it uses the same table alias
+		withExprs = append(withExprs, projectAliasExpr{Context: qc, Alias: StatsSource, expression: stats})
+	}
+	withExprs = append(withExprs, source)
 	for _, aggProjectExpr := range aggProjectExprs {
 		withExprs = append(withExprs, aggProjectExpr)
 
@@ -473,6 +546,46 @@ func (ej *ElasticJSON) SQL(qc *QueryContext) (*exprSelect, error) {
 	}, nil
 }
 
+// bucketRecords returns the records of a bucket
+// that either holds an array of records or a
+// single record (when limited to one record)
+func bucketRecords(bucket any) ([]any, bool) {
+	if singleRecord, ok := bucket.(map[string]any); ok {
+		if len(singleRecord) > 0 {
+			return []any{singleRecord}, true
+		}
+		return []any{}, true
+	}
+	records, ok := bucket.([]any)
+	return records, ok
+}
+
+// sortedByScore returns true when the hits are
+// sorted by their relevance score
+func (ej *ElasticJSON) sortedByScore() bool {
+	if len(ej.Sort) == 0 {
+		return true
+	}
+	for _, sf := range ej.Sort {
+		if sf.Field == ScoreSortField {
+			return true
+		}
+	}
+	return false
+}
+
+// sortExpression returns the expression that
+// is used to sort the hits by the field
+func (qc *QueryContext) sortExpression(field string) expression {
+	if field == ScoreSortField {
+		if qc.score != nil {
+			return qc.score
+		}
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{float64(1)}}
+	}
+	return ParseExprFieldName(qc, field)
+}
+
 func (ej *ElasticJSON) ConvertResult(qc *QueryContext, snellerResult map[string]any) (*ElasticResult, map[string]any, error) {
 	totalCountBucket := snellerResult[TotalCountBucket]
 	totalCount := int64(totalCountBucket.(int))
@@ -506,21 +619,22 @@ func (ej *ElasticJSON) ConvertResult(qc *QueryContext, snellerResult map[string]
 
 	// process normal hits
 	if hits, ok := snellerResult[HitsBucket]; ok {
-		var hitsRecords []any
-		if singleHitsRecord, ok := hits.(map[string]any); ok {
-			if len(singleHitsRecord) > 0 {
-				hits = []any{singleHitsRecord}
-			} else {
-				hits = []any{}
-			}
-		}
-		hitsRecords, ok := hits.([]any)
+		hitsRecords, ok := bucketRecords(hits)
 		if !ok {
 			return nil, nil, fmt.Errorf("%q should contain an array of records", HitsBucket)
 		}
 
+		// the scores are returned in the same order
+		// as the hits (only when sorted by score)
+		var scoreRecords []any
+		if scores, ok := snellerResult[ScoresBucket]; ok {
+			scoreRecords, ok = bucketRecords(scores)
+			if !ok || len(scoreRecords) != len(hitsRecords) {
+				return nil, nil, fmt.Errorf("%q should contain a score for each hit", ScoresBucket)
+			}
+		}
+
 		defaultScore := float64(1)
-		score := &defaultScore
 
 		var version *int
 		if ej.Version != nil && *ej.Version {
@@ -528,12 +642,26 @@ func (ej *ElasticJSON) ConvertResult(qc *QueryContext, snellerResult map[string]
 			version = &defaultVersion
 		}
 
-		for _, hitRecord := range hitsRecords {
+		for i, hitRecord := range hitsRecords {
 			hit, ok := hitRecord.(map[string]any)
 			if !ok {
 				return nil, nil, fmt.Errorf("%q should contain an array of records", HitsBucket)
 			}
 
+			score := &defaultScore
+			if scoreRecords != nil {
+				// a single score isn't returned as a record
+				scoreValue := scoreRecords[i]
+				if rec, ok := scoreValue.(map[string]any); ok {
+					scoreValue = rec[ScoreField]
+				}
+				value, err := NewElasticFloat(scoreValue)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid score %v in %q", scoreValue, ScoresBucket)
+				}
+				score = (*float64)(&value)
+			}
+
 			// Strip out excessive columns due to
 			// https://github.com/SnellerInc/sneller-core/issues/2358#issuecomment-1406379279
 			for k := range hit {
@@ -543,8 +671,13 @@ func (ej *ElasticJSON) ConvertResult(qc *QueryContext, snellerResult map[string]
 			}
 
 			sortValues := make([]any, 0, len(ej.Sort))
+			rawSort := make([]any, 0, len(ej.Sort))
 			for _, k := range ej.Sort {
 				value := hit[k.Field]
+				if k.Field == ScoreSortField {
+					value = *score
+				}
+				rawSort = append(rawSort, value)
 				// timestamp are written as unix-milli in sort orders
 				if t, ok := value.(time.Time); ok {
 					value = t.UnixMilli()
@@ -567,6 +700,10 @@ func (ej *ElasticJSON) ConvertResult(qc *QueryContext, snellerResult map[string]
 				Version: version,
 				Index:   qc.Index,
 				Sort:    sortValues,
+				rawSort: rawSort,
+			}
+			if ej.Highlight != nil {
+				rec.Highlight = ej.Highlight.highlight(hit, qc.highlightTerms)
 			}
 			if len(ej.Fields) > 0 {
 				rec.Fields = make(map[string][]any)
@@ -882,6 +1019,15 @@ func preProcess(snellerResult map[string]any) (map[string]any, error) {
 			if err != nil {
 				return nil, err
 			}
+			if len(keyGroups) == 0 {
+				// the value counts of an aggregation
+				// that isn't grouped (see addValueCounts)
+				results := singleResults(preProcessed, bucketNameParts)
+				for _, item := range rows {
+					mergeRow(results, item.(map[string]any))
+				}
+				continue
+			}
 
 			rootGrm, ok := preProcessed[rootBucketName].(*groupResultMap)
 			if !ok {
@@ -955,67 +1101,98 @@ func preProcess(snellerResult map[string]any) (map[string]any, error) {
 					if group.Results == nil {
 						group.Results = make(map[string]any, len(row))
 					}
-					for col, v := range row {
-						if col != DummyAlias {
-							_, index := splitWithPrefix(KeyPrefix, col)
-							if index < 0 {
-								group.Results[col] = v
-							}
-						}
-					}
+					mergeRow(group.Results, row)
 				}
 			}
 		case map[string]any:
 			// a single-object response is the result of an
 			// aggregation without group-by, so it will be
 			// only metric aggregation results
-			var results map[string]any
+			mergeRow(singleResults(preProcessed, bucketNameParts), b)
+		default:
+			return nil, fmt.Errorf("bucket %q has unsupported result data", combinedBucketName)
+		}
+	}
 
-			if len(bucketNameParts) > 1 {
-				grm, ok := preProcessed[rootBucketName].(*groupResultMap)
-				if !ok {
-					grm = &groupResultMap{
-						groups: make(map[string]*groupResults),
-					}
-					preProcessed[rootBucketName] = grm
-				}
-				group := &groupResults{
-					KeyValues: []any{bucketNameParts[1]},
-					Results:   make(map[string]any, 0),
-				}
-				grm.groups[bucketNameParts[1]] = group
-				grm.OrderedGroups = append(grm.OrderedGroups, group)
+	return preProcessed, nil
+}
 
-				results = group.Results
-			} else {
-				var group *groupResults
-				var ok bool
-				if rootBucketName != "" {
-					if group, ok = preProcessed[rootBucketName].(*groupResults); !ok {
-						group = &groupResults{
-							KeyValues: make([]any, 0),
-							Results:   make(map[string]any, 0),
-						}
-						preProcessed[rootBucketName] = group
-					}
-					results = group.Results
-				} else {
-					// top-level metric aggregations
-					results = preProcessed
-				}
+// singleResults returns the results of the
+// single-bucket aggregation with the given name
+func singleResults(preProcessed map[string]any, bucketNameParts []string) map[string]any {
+	rootBucketName := bucketNameParts[0]
+	if len(bucketNameParts) > 1 {
+		grm, ok := preProcessed[rootBucketName].(*groupResultMap)
+		if !ok {
+			grm = &groupResultMap{
+				groups: make(map[string]*groupResults),
 			}
-
-			for col, v := range b {
-				if col != DummyAlias {
-					results[col] = v
-				}
+			preProcessed[rootBucketName] = grm
+		}
+		group, ok := grm.groups[bucketNameParts[1]]
+		if !ok {
+			group = &groupResults{
+				KeyValues: []any{bucketNameParts[1]},
+				Results:   make(map[string]any, 0),
 			}
-		default:
-			return nil, fmt.Errorf("bucket %q has unsupported result data", combinedBucketName)
+			grm.groups[bucketNameParts[1]] = group
+			grm.OrderedGroups = append(grm.OrderedGroups, group)
+		}
+		return group.Results
+	}
+	if rootBucketName == "" {
+		// top-level metric aggregations
+		return preProcessed
+	}
+	group, ok := preProcessed[rootBucketName].(*groupResults)
+	if !ok {
+		group = &groupResults{
+			KeyValues: make([]any, 0),
+			Results:   make(map[string]any, 0),
 		}
+		preProcessed[rootBucketName] = group
 	}
+	return group.Results
+}
 
-	return preProcessed, nil
+// valueCount is the number of
+// occurrences of a value
+type valueCount struct {
+	value float64
+	count int64
+}
+
+// mergeRow copies the results in a row of a
+// bucket into results; the value counts of an
+// aggregation (see addValueCounts) are appended
+// to the []valueCount of the aggregation
+func mergeRow(results, row map[string]any) {
+	for col, v := range row {
+		if col == DummyAlias {
+			continue
+		}
+		if _, index := splitWithPrefix(KeyPrefix, col); index >= 0 {
+			continue
+		}
+		name, index := splitWithPrefix(ValuesPrefix, col)
+		if index < 0 {
+			results[col] = v
+			continue
+		}
+		if index != 0 {
+			continue // the count is read with the value
+		}
+		value, err := NewElasticFloat(v)
+		if err != nil {
+			continue // only numeric values are counted
+		}
+		count, err := NewElasticFloat(row[fmt.Sprintf("%s:%s%%%d", ValuesPrefix, name, 1)])
+		if err != nil {
+			continue
+		}
+		counts, _ := results[name].([]valueCount)
+		results[name] = append(counts, valueCount{value: float64(value), count: int64(count)})
+	}
 }
 
 func splitWithPrefix(prefix string, text string) (string, int) {
@@ -459,6 +459,322 @@ func TestSplitWithPrefix(t *testing.T) {
 	}
 }
 
+func TestMetricAggregationResults(t *testing.T) {
+	testData := []struct {
+		query    string
+		result   map[string]any
+		expected string
+	}{
+		{
+			query: `{"size": 0, "aggs": {"s": {"stats": {"field": "grade"}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 2,
+				"$bucket:%0": map[string]any{
+					"s":        map[string]any{"count": 2, "min": 50, "max": 100, "avg": 75.0, "sum": 150},
+					DummyAlias: false,
+				},
+			},
+			expected: `{"s": {"count": 2, "min": 50.0, "max": 100.0, "avg": 75.0, "sum": 150.0}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"s": {"stats": {"field": "grade"}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 0,
+				"$bucket:%0": map[string]any{
+					"s":        map[string]any{"count": 0, "min": nil, "max": nil, "avg": nil, "sum": nil},
+					DummyAlias: false,
+				},
+			},
+			expected: `{"s": {"count": 0, "min": null, "max": null, "avg": null, "sum": 0.0}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"terms": {"field": "class"}, "aggs": {"s": {"extended_stats": {"field": "grade", "sigma": 1}}}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 2,
+				"$bucket:c%0": []any{
+					map[string]any{
+						"$key:c%0": "a",
+						DocCount:   2,
+						"s": map[string]any{
+							"count": 2, "min": 1, "max": 3, "avg": 2.0, "sum": 4,
+							"sum_of_squares": 10, "variance": 1.0, "std_deviation": 1.0,
+						},
+					},
+				},
+			},
+			expected: `{"c": {"buckets": [{"key": "a", "doc_count": 2, "s": {
+				"count": 2, "min": 1.0, "max": 3.0, "avg": 2.0, "sum": 4.0,
+				"sum_of_squares": 10.0,
+				"variance": 1.0, "variance_population": 1.0, "variance_sampling": 2.0,
+				"std_deviation": 1.0, "std_deviation_population": 1.0, "std_deviation_sampling": 1.4142135623730951,
+				"std_deviation_bounds": {
+					"upper": 3.0, "lower": 1.0,
+					"upper_population": 3.0, "lower_population": 1.0,
+					"upper_sampling": 3.414213562373095, "lower_sampling": 0.5857864376269049
+				}}}], "doc_count_error_upper_bound": 0, "sum_other_doc_count": 0}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"p": {"percentiles": {"field": "load_time", "percents": [50, 99.9]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 10,
+				"$bucket:%0": map[string]any{
+					"p":        map[string]any{"0": 12.5, "1": 40},
+					DummyAlias: false,
+				},
+			},
+			expected: `{"p": {"values": {"50.0": 12.5, "99.9": 40.0}}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"p": {"percentiles": {"field": "load_time", "percents": [50], "keyed": false}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 10,
+				"$bucket:%0": map[string]any{
+					"p":        map[string]any{"0": 12.5},
+					DummyAlias: false,
+				},
+			},
+			expected: `{"p": {"values": [{"key": 50.0, "value": 12.5}]}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"r": {"percentile_ranks": {"field": "load_time", "values": [500, 600]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 10,
+				"$bucket:%1": []any{
+					map[string]any{"$values:r%0": 100, "$values:r%1": 2},
+					map[string]any{"$values:r%0": 500, "$values:r%1": 2},
+					map[string]any{"$values:r%0": 700.0, "$values:r%1": 4},
+				},
+			},
+			// 500 is the middle of the values at 500 and
+			// 600 is interpolated between 500 and 700
+			expected: `{"r": {"values": {"500.0": 37.5, "600.0": 56.25}}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"terms": {"field": "class"}, "aggs": {"p": {"percentiles": {"field": "grade", "percents": [50, 100]}}}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 5,
+				"$bucket:c%0": []any{
+					map[string]any{"$key:c%0": "a", DocCount: 4},
+					map[string]any{"$key:c%0": "b", DocCount: 1},
+				},
+				"$bucket:c%1": []any{
+					map[string]any{"$key:c%0": "a", "$values:p%0": 10, "$values:p%1": 1},
+					map[string]any{"$key:c%0": "b", "$values:p%0": 5, "$values:p%1": 1},
+					map[string]any{"$key:c%0": "a", "$values:p%0": 1, "$values:p%1": 1},
+					map[string]any{"$key:c%0": "a", "$values:p%0": 3, "$values:p%1": 1},
+					map[string]any{"$key:c%0": "a", "$values:p%0": 2, "$values:p%1": 1},
+				},
+			},
+			expected: `{"c": {"buckets": [
+				{"key": "a", "doc_count": 4, "p": {"values": {"50.0": 2.5, "100.0": 10.0}}},
+				{"key": "b", "doc_count": 1, "p": {"values": {"50.0": 5.0, "100.0": 5.0}}}
+			], "doc_count_error_upper_bound": 0, "sum_other_doc_count": 0}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"terms": {"field": "class"}, "aggs": {"r": {"percentile_ranks": {"field": "grade", "values": [0, 4, 20]}}}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 4,
+				"$bucket:c%0": []any{
+					map[string]any{"$key:c%0": "a", DocCount: 4},
+				},
+				"$bucket:c%1": []any{
+					map[string]any{"$key:c%0": "a", "$values:r%0": 2, "$values:r%1": 2},
+					map[string]any{"$key:c%0": "a", "$values:r%0": 6, "$values:r%1": 2},
+				},
+			},
+			expected: `{"c": {"buckets": [
+				{"key": "a", "doc_count": 4, "r": {"values": {"0.0": 0.0, "4.0": 50.0, "20.0": 100.0}}}
+			], "doc_count_error_upper_bound": 0, "sum_other_doc_count": 0}}`,
+		},
+	}
+
+	for i, td := range testData {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(td.query), &ej); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", td.query, err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+			}
+			if _, err := ej.SQL(&qc); err != nil {
+				t.Fatalf("can't transform aggregation %q: %v", td.query, err)
+			}
+			er, _, err := ej.ConvertResult(&qc, td.result)
+			if err != nil {
+				t.Fatalf("can't process results: %v", err)
+			}
+			var expected any
+			if err := json.Unmarshal([]byte(td.expected), &expected); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", td.expected, err)
+			}
+			compareJSON(t, "unexpected aggregation result", er.Aggregations, expected)
+		})
+	}
+}
+
+func TestBucketAggregationResults(t *testing.T) {
+	testData := []struct {
+		query    string
+		result   map[string]any
+		expected string
+	}{
+		{
+			query: `{"size": 0, "aggs": {"r": {"range": {"field": "d", "ranges": [{"from": 100, "to": 500}, {"to": 100}, {"key": "slow", "from": 500}]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 5,
+				"$bucket:r%0": []any{
+					map[string]any{"$key:r%0": 0, DocCount: 2},
+					map[string]any{"$key:r%0": 2, DocCount: 3},
+				},
+			},
+			expected: `{"r": {"buckets": [
+				{"key": "*-100.0", "to": 100.0, "doc_count": 2},
+				{"key": "100.0-500.0", "from": 100.0, "to": 500.0, "doc_count": 0},
+				{"key": "slow", "from": 500.0, "doc_count": 3}
+			]}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"r": {"range": {"field": "d", "keyed": true, "ranges": [{"to": 100}, {"from": 100}]}, "aggs": {"a": {"avg": {"field": "x"}}}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 3,
+				"$bucket:r%0": []any{
+					map[string]any{"$key:r%0": 1, DocCount: 3, "a": 2.5},
+				},
+			},
+			expected: `{"r": {"buckets": {
+				"*-100.0": {"to": 100.0, "doc_count": 0, "a": {"value": null}},
+				"100.0-*": {"from": 100.0, "doc_count": 3, "a": {"value": 2.5}}
+			}}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"r": {"date_range": {"field": "timestamp", "format": "date", "ranges": [{"to": "2022.06.25||-1d"}, {"from": 1656028800000}]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 3,
+				"$bucket:r%0": []any{
+					map[string]any{"$key:r%0": 0, DocCount: 1},
+					map[string]any{"$key:r%0": 1, DocCount: 2},
+				},
+			},
+			expected: `{"r": {"buckets": [
+				{"key": "*-2022-06-24", "to": 1656028800000.0, "to_as_string": "2022-06-24", "doc_count": 1},
+				{"key": "2022-06-24-*", "from": 1656028800000.0, "from_as_string": "2022-06-24", "doc_count": 2}
+			]}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"composite": {"size": 2, "sources": [{"day": {"date_histogram": {"field": "timestamp", "fixed_interval": "1d"}}}, {"o": {"terms": {"field": "o"}}}]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 3,
+				"$bucket:c%0": []any{
+					map[string]any{"$key:c%0": 1656028800, "$key:c%1": "AA", DocCount: 1},
+					map[string]any{"$key:c%0": 1656028800, "$key:c%1": "BB", DocCount: 2},
+				},
+			},
+			expected: `{"c": {
+				"after_key": {"day": 1656028800000, "o": "BB"},
+				"buckets": [
+					{"key": {"day": 1656028800000, "o": "AA"}, "doc_count": 1},
+					{"key": {"day": 1656028800000, "o": "BB"}, "doc_count": 2}
+				]}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"composite": {"sources": [{"p": {"histogram": {"field": "p", "interval": 50, "missing_bucket": true}}}, {"o": {"terms": {"field": "o", "missing_bucket": true}}}]}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 6,
+				"$bucket:c%0": []any{
+					map[string]any{"$key:c%0": nil, "$key:c%1": nil, DocCount: 1},
+					map[string]any{"$key:c%0": nil, "$key:c%1": "AA", DocCount: 2},
+					map[string]any{"$key:c%0": 50, "$key:c%1": nil, DocCount: 3},
+				},
+			},
+			expected: `{"c": {
+				"after_key": {"p": 50.0, "o": null},
+				"buckets": [
+					{"key": {"p": null, "o": null}, "doc_count": 1},
+					{"key": {"p": null, "o": "AA"}, "doc_count": 2},
+					{"key": {"p": 50.0, "o": null}, "doc_count": 3}
+				]}}`,
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"composite": {"sources": [{"p": {"histogram": {"field": "p", "interval": 50}}}], "after": {"p": 100}}}}}`,
+			result: map[string]any{
+				TotalCountBucket: 0,
+			},
+			expected: `{"c": {"buckets": []}}`,
+		},
+	}
+
+	for i, td := range testData {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(td.query), &ej); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", td.query, err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+				TypeMapping: map[string]TypeMapping{
+					"timestamp": {Type: "datetime"},
+				},
+			}
+			if _, err := ej.SQL(&qc); err != nil {
+				t.Fatalf("can't transform aggregation %q: %v", td.query, err)
+			}
+			er, _, err := ej.ConvertResult(&qc, td.result)
+			if err != nil {
+				t.Fatalf("can't process results: %v", err)
+			}
+			var expected any
+			if err := json.Unmarshal([]byte(td.expected), &expected); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", td.expected, err)
+			}
+			compareJSON(t, "unexpected aggregation result", er.Aggregations, expected)
+		})
+	}
+}
+
+func TestUnsupportedBucketAggregations(t *testing.T) {
+	testData := []struct {
+		query string
+		err   string
+	}{
+		{
+			query: `{"size": 0, "aggs": {"r": {"range": {"field": "d", "ranges": [{"to": 100}, {"from": 50}]}}}}`,
+			err:   "overlapping ranges",
+		},
+		{
+			query: `{"size": 0, "aggs": {"t": {"terms": {"field": "x"}, "aggs": {"c": {"composite": {"sources": [{"o": {"terms": {"field": "o"}}}]}}}}}}`,
+			err:   "parent aggregation",
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"composite": {"sources": [{"o": {"terms": {"field": "o"}}}], "after": {"x": 1}}}}}`,
+			err:   "doesn't contain a value",
+		},
+		{
+			query: `{"size": 0, "aggs": {"c": {"composite": {"sources": [{"o": {"terms": {"field": "o"}}}], "after": {"o": null}}}}}`,
+			err:   "invalid 'after' key",
+		},
+	}
+
+	for i, td := range testData {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(td.query), &ej); err != nil {
+				t.Fatal(err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+			}
+			_, err := ej.SQL(&qc)
+			if err == nil || !strings.Contains(err.Error(), td.err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
 func TestKeyGroups(t *testing.T) {
 	rec := map[string]any{
 		"$key:aa%0":    0,
@@ -15,6 +15,10 @@
 package elastic_proxy
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 )
 
@@ -167,3 +171,86 @@ func testDatashape() map[string]any {
 		"wrong-type": 42,
 	}
 }
+
+func TestFetchSchema(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/schema" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("table") {
+		case "empty":
+			w.Write([]byte(`{"total": 0, "fields": {}}`))
+		case "sampled":
+			w.Write([]byte(`{"total": 5, "incomplete": true, "fields": {
+				"name": {"string": 4, "null": 1}}}`))
+		default:
+			w.Write([]byte(`{"total": 10, "fields": {
+				"name": {"string": 10},
+				"inner": {"struct": 10},
+				"inner.age": {"int": 8, "null": 2}},
+				"distinct": {"name": 7}}`))
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	schema, err := FetchSchema(srv.Client(), u, "token", "db", "table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Total != 10 || schema.Incomplete {
+		t.Errorf("got total %d, incomplete %v", schema.Total, schema.Incomplete)
+	}
+	if _, ok := schema.Fields["name"].(map[string]any)["distinct"]; ok {
+		t.Error("distinct count among the types of name")
+	}
+	if n := schema.Distinct["name"]; n != 7 {
+		t.Errorf("name: got %d distinct", n)
+	}
+	m := DataShapeToElasticMapping(schema.Fields)
+	if typ := m.Properties["name"].Type; typ != elasticTypeString {
+		t.Errorf("name: got type %q", typ)
+	}
+	if typ := m.Properties["inner"].Properties["age"].Type; typ != elasticTypeInt {
+		t.Errorf("inner.age: got type %q", typ)
+	}
+
+	sampled, err := FetchSchema(srv.Client(), u, "token", "db", "sampled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sampled.Incomplete {
+		t.Error("sampled schema not incomplete")
+	}
+
+	// merging sums the type counts and
+	// keeps the largest distinct count
+	var merged Schema
+	merged.Merge(schema)
+	merged.Merge(schema)
+	merged.Merge(sampled)
+	if merged.Total != 25 || !merged.Incomplete {
+		t.Errorf("merged: got total %d, incomplete %v", merged.Total, merged.Incomplete)
+	}
+	name := merged.Fields["name"].(map[string]any)
+	if name["string"] != 24 || name["null"] != 1 {
+		t.Errorf("merged name: got %v", name)
+	}
+	if n := merged.Distinct["name"]; n != 7 {
+		t.Errorf("merged name: got %d distinct", n)
+	}
+	if n := schema.Fields["name"].(map[string]any)["string"]; n != 10 {
+		t.Errorf("merging modified the source schema: %v", n)
+	}
+
+	_, err = FetchSchema(srv.Client(), u, "token", "db", "empty")
+	if !errors.Is(err, ErrNoSchema) {
+		t.Errorf("expected ErrNoSchema; got %v", err)
+	}
+	_, err = FetchSchema(srv.Client(), u, "bad-token", "db", "table")
+	if err == nil {
+		t.Error("expected an error with a bad token")
+	}
+}
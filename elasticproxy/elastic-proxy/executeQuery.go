@@ -15,6 +15,8 @@
 package elastic_proxy
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,9 +29,26 @@ const (
 	DefaultHTTPTimeout time.Duration = 30 * time.Second
 )
 
+// ErrSnapshotGone is returned by ExecuteSnapshotQuery
+// when the snapshot doesn't exist (anymore).
+var ErrSnapshotGone = errors.New("snapshot does not exist or has expired")
+
 func ExecuteQuery(client *http.Client, u *url.URL, token, sql string) (*http.Response, error) {
+	return ExecuteSnapshotQuery(client, u, token, sql, "")
+}
+
+// ExecuteSnapshotQuery executes the query against the
+// snapshot (see PinSnapshot) with the given id of the
+// indexes of the tables. If the id is empty, then the
+// current indexes are queried.
+func ExecuteSnapshotQuery(client *http.Client, u *url.URL, token, sql, snapshot string) (*http.Response, error) {
 	endPoint := *u
 	endPoint.Path = "/query"
+	if snapshot != "" {
+		endPoint.RawQuery = url.Values{
+			"snapshot": []string{snapshot},
+		}.Encode()
+	}
 
 	req, err := http.NewRequest(http.MethodPost, endPoint.String(), strings.NewReader(sql))
 	if err != nil {
@@ -47,8 +66,183 @@ func ExecuteQuery(client *http.Client, u *url.URL, token, sql string) (*http.Res
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		respBody, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, ErrSnapshotGone
+		}
 		return nil, fmt.Errorf("http error %d (%s): %s", resp.StatusCode, resp.Status, string(respBody))
 	}
 
 	return resp, nil
 }
+
+// ErrNoSchema is returned by FetchSchema when the
+// Sneller endpoint has no stored schema for a table.
+var ErrNoSchema = errors.New("no stored schema")
+
+// Schema is the stored schema of a table
+// as returned by the Sneller /schema endpoint.
+type Schema struct {
+	// Total is the number of rows in the table.
+	Total int64
+	// Fields has the same structure as the 'fields'
+	// output of SNELLER_DATASHAPE, so it can be passed
+	// to DataShapeToElasticMapping.
+	Fields map[string]any
+	// Distinct holds the approximate number
+	// of distinct values of each field.
+	Distinct map[string]int64
+	// Incomplete is set if some of the data in
+	// the table was written without a stored schema,
+	// so Fields was determined from a sample.
+	Incomplete bool
+}
+
+// Merge merges the schema o into s. The type counts
+// of the fields are summed; the distinct counts can't
+// be summed, so the larger one is kept as a lower bound.
+func (s *Schema) Merge(o *Schema) {
+	s.Total += o.Total
+	s.Incomplete = s.Incomplete || o.Incomplete
+	if s.Fields == nil {
+		s.Fields = make(map[string]any, len(o.Fields))
+	}
+	for name, details := range o.Fields {
+		prev, ok := s.Fields[name].(map[string]any)
+		if !ok {
+			prev = make(map[string]any)
+			s.Fields[name] = prev
+		}
+		for typ, count := range details.(map[string]any) {
+			n, _ := prev[typ].(int)
+			prev[typ] = n + count.(int)
+		}
+	}
+	for name, n := range o.Distinct {
+		if s.Distinct == nil {
+			s.Distinct = make(map[string]int64, len(o.Distinct))
+		}
+		if n > s.Distinct[name] {
+			s.Distinct[name] = n
+		}
+	}
+}
+
+// FetchSchema fetches the stored schema of a table from
+// the Sneller /schema endpoint.
+func FetchSchema(client *http.Client, u *url.URL, token, database, table string) (*Schema, error) {
+	endPoint := *u
+	endPoint.Path = "/schema"
+	endPoint.RawQuery = url.Values{
+		"database": []string{database},
+		"table":    []string{table},
+	}.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, endPoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("http error %d (%s): %s", resp.StatusCode, resp.Status, string(respBody))
+	}
+
+	var schema struct {
+		Total      int64                       `json:"total"`
+		Incomplete bool                        `json:"incomplete"`
+		Fields     map[string]map[string]int64 `json:"fields"`
+		Distinct   map[string]int64            `json:"distinct"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(schema.Fields) == 0 {
+		return nil, ErrNoSchema
+	}
+
+	fields := make(map[string]any, len(schema.Fields))
+	for name, types := range schema.Fields {
+		details := make(map[string]any, len(types))
+		for typ, count := range types {
+			details[typ] = int(count)
+		}
+		fields[name] = details
+	}
+	return &Schema{
+		Total:      schema.Total,
+		Fields:     fields,
+		Distinct:   schema.Distinct,
+		Incomplete: schema.Incomplete,
+	}, nil
+}
+
+// FetchSnapshot returns the ETag that identifies
+// the current index snapshot of the tables. The
+// query is only planned (not executed) and the
+// ETag changes whenever the index of one of the
+// tables is updated.
+func FetchSnapshot(client *http.Client, u *url.URL, token string, sources []TableSource) (string, error) {
+	resp, err := planSources(client, u, token, sources, nil)
+	if err != nil {
+		return "", err
+	}
+	eTag := resp.Header.Get("ETag")
+	if eTag == "" {
+		return "", errors.New("no ETag returned for index snapshot")
+	}
+	return eTag, nil
+}
+
+// PinSnapshot saves the current indexes of the tables
+// as the snapshot with the given id. Queries that are
+// executed using ExecuteSnapshotQuery with the same id
+// keep returning the same results when the tables are
+// updated, until the snapshot expires.
+func PinSnapshot(client *http.Client, u *url.URL, token string, sources []TableSource, id string) error {
+	_, err := planSources(client, u, token, sources, url.Values{
+		"snapshot": []string{id},
+		"pin":      []string{""},
+	})
+	return err
+}
+
+// planSources plans (but doesn't execute) a
+// query that references all the tables
+func planSources(client *http.Client, u *url.URL, token string, sources []TableSource, params url.Values) (*http.Response, error) {
+	sql := "SELECT COUNT(*) FROM " + printExpr(&exprSources{Sources: sources}, false)
+
+	endPoint := *u
+	endPoint.Path = "/query"
+	query := url.Values{
+		"query": []string{sql},
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+	endPoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodHead, endPoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/ion")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("http error %d (%s)", resp.StatusCode, resp.Status)
+	}
+	return resp, nil
+}
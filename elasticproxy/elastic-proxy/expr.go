@@ -140,6 +140,7 @@ func (pc *printContext) indentIfNeeded() {
 type exprSelect struct {
 	Context    *QueryContext
 	With       []projectAliasExpr
+	DistinctOn []expression
 	Projection []projectAliasExpr
 	From       []expression
 	Where      expression
@@ -190,13 +191,21 @@ func (e *exprSelect) Print(pc *printContext) {
 		pc.Pop()
 		pc.WriteNewlineN(2)
 	}
+	selectKeyword := "SELECT "
+	if len(e.DistinctOn) > 0 {
+		distinctOn := make([]string, len(e.DistinctOn))
+		for i, d := range e.DistinctOn {
+			distinctOn[i] = PrintExpr(d)
+		}
+		selectKeyword = fmt.Sprintf("SELECT DISTINCT ON (%s) ", strings.Join(distinctOn, ", "))
+	}
 	topLevelSelect := pc.Level() == 0
 	if topLevelSelect {
-		pc.WriteString("SELECT ")
+		pc.WriteString(selectKeyword)
 		pc.WriteNewline()
 		pc.Push()
 	} else {
-		pc.PushString("SELECT ")
+		pc.PushString(selectKeyword)
 	}
 	for i, pe := range e.Projection {
 		if i > 0 {
@@ -339,7 +348,8 @@ func (e *projectAliasExpr) Print(pc *printContext) {
 type orderByExpr struct {
 	Context *QueryContext
 	expression
-	Order Ordering
+	Order     Ordering
+	NullsLast bool
 }
 
 func (e *orderByExpr) QueryContext() *QueryContext {
@@ -351,6 +361,9 @@ func (e *orderByExpr) Print(pc *printContext) {
 	if e.Order != "" {
 		pc.WriteString(fmt.Sprintf(" %s", e.Order))
 	}
+	if e.NullsLast {
+		pc.WriteString(" NULLS LAST")
+	}
 }
 
 type exprObjectField struct {
@@ -446,7 +459,7 @@ func (e *exprOperator1) Print(pc *printContext) {
 		pc.WriteString(fmt.Sprintf("(%s ", e.Operator))
 		e.Expr1.Print(pc)
 		pc.WriteRune(')')
-	case "IS MISSING", "IS NOT MISSING":
+	case "IS MISSING", "IS NOT MISSING", "IS NULL", "IS NOT NULL":
 		pc.WriteRune('(')
 		e.Expr1.Print(pc)
 		pc.WriteString(fmt.Sprintf(" %s)", e.Operator))
@@ -509,6 +522,39 @@ func (e *exprOperator2) Print(pc *printContext) {
 	pc.WriteRune(')')
 }
 
+// exprCase represents a searched CASE
+// expression that returns the value of
+// the first WHEN clause that matches.
+type exprCase struct {
+	Context *QueryContext
+	Whens   []exprCaseWhen
+	Else    expression
+}
+
+type exprCaseWhen struct {
+	When expression
+	Then expression
+}
+
+func (e *exprCase) QueryContext() *QueryContext {
+	return e.Context
+}
+
+func (e *exprCase) Print(pc *printContext) {
+	pc.WriteString("CASE")
+	for _, w := range e.Whens {
+		pc.WriteString(" WHEN ")
+		w.When.Print(pc)
+		pc.WriteString(" THEN ")
+		w.Then.Print(pc)
+	}
+	if e.Else != nil {
+		pc.WriteString(" ELSE ")
+		e.Else.Print(pc)
+	}
+	pc.WriteString(" END")
+}
+
 // exprFieldName represents a table (or
 // alias) that references a data-source.
 type exprSourceName struct {
@@ -631,6 +677,14 @@ func ParseExprFieldNameParts(qc *QueryContext, fields []string) *exprFieldName {
 		e.Fields = append(e.Fields, field)
 	}
 
+	// fields within a nested path refer to the
+	// (unnested) array elements
+	if s, fields := qc.resolveNested(e.Fields); s != nil {
+		e.Source = s.Alias
+		e.Fields = fields
+		return &e
+	}
+
 	fullFieldName := strings.Join(e.Fields, ".")
 	for field, typeMapping := range qc.TypeMapping {
 		if typeMapping.Type == "list" && strings.HasPrefix(fullFieldName, field+".") {
@@ -0,0 +1,184 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldCapability describes the capabilities of
+// a field for a single type
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-field-caps.html
+type FieldCapability struct {
+	Type          string   `json:"type"`
+	MetadataField bool     `json:"metadata_field"`
+	Searchable    bool     `json:"searchable"`
+	Aggregatable  bool     `json:"aggregatable"`
+	Indices       []string `json:"indices,omitempty"`
+}
+
+// FieldCapabilities maps a field name to the
+// capabilities of each type of the field
+type FieldCapabilities map[string]map[string]FieldCapability
+
+// metadataFields are always available in Elastic
+var metadataFields = map[string]FieldCapability{
+	"_id":     {Type: "_id", MetadataField: true, Searchable: true},
+	"_index":  {Type: "_index", MetadataField: true, Searchable: true, Aggregatable: true},
+	"_source": {Type: "_source", MetadataField: true},
+}
+
+// NewFieldCapabilities determines the capabilities
+// of the fields (matching one of the patterns) of an
+// index, based on the mapping that is derived from
+// the data and the type mapping of the index.
+func NewFieldCapabilities(mapping *ElasticMapping, typeMapping map[string]TypeMapping, patterns []string) FieldCapabilities {
+	fc := make(FieldCapabilities)
+	add := func(field string, capability FieldCapability) {
+		if !matchAnyWildcard(field, patterns) {
+			return
+		}
+		fc[field] = map[string]FieldCapability{capability.Type: capability}
+	}
+
+	for field, capability := range metadataFields {
+		add(field, capability)
+	}
+
+	var walk func(prefix string, p Properties)
+	walk = func(prefix string, p Properties) {
+		for name, mv := range p {
+			field := prefix + name
+			if mv.Type == elasticTypeStruct {
+				add(field, FieldCapability{Type: elasticTypeStruct})
+				walk(field+".", mv.Properties)
+				continue
+			}
+			for subField, capability := range fieldCapability(field, mv.Type, typeMapping) {
+				add(subField, capability)
+			}
+		}
+	}
+	if mapping != nil {
+		walk("", mapping.Properties)
+	}
+
+	// fields that have an explicit type mapping, but
+	// don't occur in the (sampled) data are included
+	// as well
+	for field := range typeMapping {
+		if strings.Contains(field, "*") {
+			continue
+		}
+		if _, ok := fc[field]; ok {
+			continue
+		}
+		for subField, capability := range fieldCapability(field, "", typeMapping) {
+			add(subField, capability)
+		}
+	}
+	return fc
+}
+
+// fieldCapability returns the capabilities of the
+// field and its sub-fields (i.e. "name.keyword")
+func fieldCapability(field, elasticType string, typeMapping map[string]TypeMapping) map[string]FieldCapability {
+	tm, ok := mapType(field, typeMapping)
+	if !ok {
+		if elasticType == "" || elasticType == elasticTypeList {
+			return nil
+		}
+		return map[string]FieldCapability{
+			field: {Type: elasticType, Searchable: true, Aggregatable: true},
+		}
+	}
+
+	result := make(map[string]FieldCapability, 1+len(tm.Fields))
+	switch tm.Type {
+	case "", "text":
+		result[field] = FieldCapability{Type: "text", Searchable: true}
+	case "keyword", "keyword-ignore-case", "contains":
+		result[field] = FieldCapability{Type: elasticTypeString, Searchable: true, Aggregatable: true}
+	case "datetime", "unix_seconds", "unix_milli_seconds", "unix_micro_seconds", "unix_nano_seconds":
+		result[field] = FieldCapability{Type: elasticTypeTimestamp, Searchable: true, Aggregatable: true}
+	default:
+		if elasticType == "" {
+			elasticType = defaultElasticType
+		}
+		result[field] = FieldCapability{Type: elasticType, Searchable: true, Aggregatable: true}
+	}
+	for name, typ := range tm.Fields {
+		switch typ {
+		case "keyword", "keyword-ignore-case", "contains":
+			result[field+"."+name] = FieldCapability{Type: elasticTypeString, Searchable: true, Aggregatable: true}
+		case "", "text":
+			result[field+"."+name] = FieldCapability{Type: "text", Searchable: true}
+		}
+	}
+	return result
+}
+
+// MergeFieldCapabilities combines the capabilities
+// of multiple indices. When a field has different
+// types, then the indices that use each type are
+// listed.
+func MergeFieldCapabilities(indices map[string]FieldCapabilities) FieldCapabilities {
+	names := make([]string, 0, len(indices))
+	for index := range indices {
+		names = append(names, index)
+	}
+	sort.Strings(names)
+
+	result := make(FieldCapabilities)
+	for _, index := range names {
+		for field, types := range indices[index] {
+			current, ok := result[field]
+			if !ok {
+				current = make(map[string]FieldCapability)
+				result[field] = current
+			}
+			for typ, capability := range types {
+				if prev, ok := current[typ]; ok {
+					capability.Searchable = capability.Searchable && prev.Searchable
+					capability.Aggregatable = capability.Aggregatable && prev.Aggregatable
+					capability.Indices = prev.Indices
+				}
+				capability.Indices = append(capability.Indices, index)
+				current[typ] = capability
+			}
+		}
+	}
+
+	for _, types := range result {
+		if len(types) > 1 {
+			continue
+		}
+		for typ, capability := range types {
+			capability.Indices = nil
+			types[typ] = capability
+		}
+	}
+	return result
+}
+
+func matchAnyWildcard(s string, wildcards []string) bool {
+	for _, wildcard := range wildcards {
+		if matchWildcard(s, wildcard) {
+			return true
+		}
+	}
+	return false
+}
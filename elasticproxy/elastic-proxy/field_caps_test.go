@@ -0,0 +1,106 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldCapabilities(t *testing.T) {
+	mapping := &ElasticMapping{
+		Properties: Properties{
+			"count":     {Type: elasticTypeInt},
+			"timestamp": {Type: elasticTypeInt},
+			"name":      {Type: elasticTypeString},
+			"tags":      {Type: elasticTypeList},
+			"geo": {
+				Type: elasticTypeStruct,
+				Properties: Properties{
+					"lat": {Type: elasticTypeFloat64},
+					"lon": {Type: elasticTypeFloat64},
+				},
+			},
+		},
+	}
+	typeMapping := map[string]TypeMapping{
+		"timestamp": {Type: "unix_seconds"},
+		"name": {
+			Type:   "text",
+			Fields: map[string]string{"raw": "keyword"},
+		},
+		"missing": {Type: "keyword"},
+	}
+
+	searchable := func(typ string) map[string]FieldCapability {
+		return map[string]FieldCapability{typ: {Type: typ, Searchable: true, Aggregatable: true}}
+	}
+	got := NewFieldCapabilities(mapping, typeMapping, []string{"*"})
+	want := FieldCapabilities{
+		"_id":       {"_id": metadataFields["_id"]},
+		"_index":    {"_index": metadataFields["_index"]},
+		"_source":   {"_source": metadataFields["_source"]},
+		"count":     searchable("long"),
+		"timestamp": searchable("date"),
+		"name":      {"text": {Type: "text", Searchable: true}},
+		"name.raw":  searchable("keyword"),
+		"geo":       {"object": {Type: "object"}},
+		"geo.lat":   searchable("double"),
+		"geo.lon":   searchable("double"),
+		"missing":   searchable("keyword"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nexpected %v", got, want)
+	}
+
+	got = NewFieldCapabilities(mapping, typeMapping, []string{"geo.*", "count"})
+	want = FieldCapabilities{
+		"count":   searchable("long"),
+		"geo.lat": searchable("double"),
+		"geo.lon": searchable("double"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nexpected %v", got, want)
+	}
+}
+
+func TestMergeFieldCapabilities(t *testing.T) {
+	long := FieldCapability{Type: "long", Searchable: true, Aggregatable: true}
+	keyword := FieldCapability{Type: "keyword", Searchable: true, Aggregatable: true}
+	text := FieldCapability{Type: "text", Searchable: true}
+
+	got := MergeFieldCapabilities(map[string]FieldCapabilities{
+		"a": {
+			"id":   {"long": long},
+			"name": {"keyword": keyword},
+		},
+		"b": {
+			"id":   {"long": long},
+			"name": {"text": text},
+		},
+		"c": {
+			"name": {"keyword": keyword},
+		},
+	})
+	keyword.Indices = []string{"a", "c"}
+	text.Indices = []string{"b"}
+	want := FieldCapabilities{
+		"id":   {"long": long},
+		"name": {"keyword": keyword, "text": text},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nexpected %v", got, want)
+	}
+}
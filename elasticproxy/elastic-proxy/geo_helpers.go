@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -31,10 +32,28 @@ type geoPoint struct {
 	Lon, Lat float64
 }
 
+var wktPointRegex = regexp.MustCompile(`^POINT\s*\((?P<long>-?[0-9]*(\.[0-9]+)?)\s+(?P<lat>-?[0-9]*(\.[0-9]+)?)\)$`)
+
+// UnmarshalJSON accepts all the geo-point
+// formats that Elastic supports (except
+// for geohashes)
 func (p *geoPoint) UnmarshalJSON(data []byte) error {
 	var text string
 	err := json.Unmarshal(data, &text)
 	if err != nil {
+		var obj struct {
+			Lat *float64 `json:"lat"`
+			Lon *float64 `json:"lon"`
+		}
+		if err := json.Unmarshal(data, &obj); err == nil {
+			if obj.Lat == nil || obj.Lon == nil {
+				return errors.New("geo-point needs both 'lat' and 'lon'")
+			}
+			p.Lat = *obj.Lat
+			p.Lon = *obj.Lon
+			return nil
+		}
+
 		var floats []float64
 		err = json.Unmarshal(data, &floats)
 		if err != nil {
@@ -48,10 +67,22 @@ func (p *geoPoint) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
-	re := regexp.MustCompile(`^POINT\s*\((?P<long>[0-9]*(\.[0-9]+)?)\s+(?P<lat>[0-9]*(\.[0-9]+)?)\)$`)
-	matches := re.FindStringSubmatch(text)
+	// "lat,lon" format
+	if lat, long, ok := strings.Cut(text, ","); ok {
+		p.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		if err != nil {
+			return err
+		}
+		p.Lon, err = strconv.ParseFloat(strings.TrimSpace(long), 64)
+		return err
+	}
+
+	matches := wktPointRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return fmt.Errorf("unsupported geo-point %q", text)
+	}
 	var long, lat string
-	for i, name := range re.SubexpNames() {
+	for i, name := range wktPointRegex.SubexpNames() {
 		switch name {
 		case "long":
 			long = matches[i]
@@ -78,3 +109,44 @@ type geoBounds struct {
 	TopLeft     geoPoint `json:"top_left"`
 	BottomRight geoPoint `json:"bottom_right"`
 }
+
+// distanceUnits holds the number of
+// meters for each Elastic distance unit
+var distanceUnits = map[string]float64{
+	"mi": 1609.344, "miles": 1609.344,
+	"yd": 0.9144, "yards": 0.9144,
+	"ft": 0.3048, "feet": 0.3048,
+	"in": 0.0254, "inch": 0.0254,
+	"km": 1000, "kilometers": 1000,
+	"m": 1, "meters": 1,
+	"cm": 0.01, "centimeters": 0.01,
+	"mm": 0.001, "millimeters": 0.001,
+	"NM": 1852, "nmi": 1852, "nauticalmiles": 1852,
+}
+
+// parseDistance parses an Elastic distance
+// (i.e. "12km") and returns it in meters
+func parseDistance(data any) (float64, error) {
+	switch v := data.(type) {
+	case float64:
+		return v, nil
+	case string:
+		text := strings.TrimSpace(v)
+		i := strings.IndexFunc(text, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i < 0 {
+			return strconv.ParseFloat(text, 64)
+		}
+		value, err := strconv.ParseFloat(text[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid distance %q", v)
+		}
+		factor, ok := distanceUnits[strings.TrimSpace(text[i:])]
+		if !ok {
+			return 0, fmt.Errorf("invalid distance unit in %q", v)
+		}
+		return value * factor, nil
+	}
+	return 0, fmt.Errorf("invalid distance %v", data)
+}
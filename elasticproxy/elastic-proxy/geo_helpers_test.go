@@ -50,3 +50,62 @@ func TestGeoPointMarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestGeoPointUnmarshal(t *testing.T) {
+	type item struct {
+		Text  string
+		Point geoPoint
+	}
+	items := []item{
+		{`{"lat": 52.4, "lon": 4.9}`, geoPoint{Lon: 4.9, Lat: 52.4}},
+		{`[4.9, 52.4]`, geoPoint{Lon: 4.9, Lat: 52.4}},
+		{`"52.4, 4.9"`, geoPoint{Lon: 4.9, Lat: 52.4}},
+		{`"POINT (-73.9 40.7)"`, geoPoint{Lon: -73.9, Lat: 40.7}},
+	}
+	for i, gp := range items {
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			var p geoPoint
+			if err := json.Unmarshal([]byte(gp.Text), &p); err != nil {
+				t.Fatalf("cannot unmarshal geo-point %q: %v", gp.Text, err)
+			}
+			if p != gp.Point {
+				t.Fatalf("got: %+v, expected: %+v", p, gp.Point)
+			}
+		})
+	}
+
+	var p geoPoint
+	if err := json.Unmarshal([]byte(`"u173zq"`), &p); err == nil {
+		t.Fatal("expected geohashes to be rejected")
+	}
+}
+
+func TestParseDistance(t *testing.T) {
+	type item struct {
+		Distance any
+		Meters   float64
+	}
+	items := []item{
+		{"12km", 12000},
+		{"200m", 200},
+		{"1.5mi", 2414.016},
+		{"10 NM", 18520},
+		{"350", 350},
+		{float64(42), 42},
+	}
+	for i, d := range items {
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			meters, err := parseDistance(d.Distance)
+			if err != nil {
+				t.Fatalf("cannot parse distance %v: %v", d.Distance, err)
+			}
+			if meters != d.Meters {
+				t.Fatalf("got: %g, expected: %g", meters, d.Meters)
+			}
+		})
+	}
+
+	if _, err := parseDistance("12parsecs"); err == nil {
+		t.Fatal("expected invalid unit to be rejected")
+	}
+}
@@ -0,0 +1,569 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// default highlighting options (same as Elastic)
+const (
+	defaultFragmentSize      = 100
+	defaultNumberOfFragments = 5
+	defaultPreTag            = "<em>"
+	defaultPostTag           = "</em>"
+)
+
+// boundaryMaxScan is the maximum number of bytes
+// that a fragment is extended to a word boundary
+const boundaryMaxScan = 20
+
+// Highlight determines how the hits are highlighted
+// (https://www.elastic.co/guide/en/elasticsearch/reference/current/highlighting.html)
+//
+// The fragments are determined by the proxy, based
+// on the predicates of the translated search query.
+type Highlight struct {
+	highlightOptions
+	Fields            highlightFields `json:"fields"`
+	RequireFieldMatch *bool           `json:"require_field_match"`
+	Encoder           string          `json:"encoder"`
+	HighlightQuery    *notSupported   `json:"highlight_query"`
+}
+
+// highlightOptions can be set for all
+// fields and overridden per field
+type highlightOptions struct {
+	PreTags           []string `json:"pre_tags"`
+	PostTags          []string `json:"post_tags"`
+	FragmentSize      *int     `json:"fragment_size"`
+	NumberOfFragments *int     `json:"number_of_fragments"`
+}
+
+type highlightField struct {
+	Name string
+	highlightOptions
+}
+
+// highlightFields can be specified as an object
+// or as an array of single field objects
+type highlightFields []highlightField
+
+func (hf *highlightFields) UnmarshalJSON(data []byte) error {
+	var fields []map[string]highlightOptions
+	if err := json.Unmarshal(data, &fields); err != nil {
+		var m map[string]highlightOptions
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		fields = []map[string]highlightOptions{m}
+	}
+
+	*hf = nil
+	for _, m := range fields {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			*hf = append(*hf, highlightField{Name: name, highlightOptions: m[name]})
+		}
+	}
+	return nil
+}
+
+// highlightTerm is a predicate of the search
+// query that determines the highlighted parts
+// of the values of a field
+type highlightTerm struct {
+	Field  string         // full field name
+	Regexp *regexp.Regexp // matches the highlighted parts
+	Fuzzy  *fuzzyTerm     // matches the full value (if set)
+}
+
+// fuzzyTerm matches values that are within the
+// Damerau-Levenshtein distance (see EQUALS_FUZZY)
+type fuzzyTerm struct {
+	Value   string
+	Edits   int
+	Unicode bool
+}
+
+// highlightTerms returns the predicates of the
+// search query that can be highlighted. Negated
+// predicates don't match the hit, so they are
+// skipped (just like other operators).
+func highlightTerms(e expression) []highlightTerm {
+	var terms []highlightTerm
+	var walk func(e expression)
+	walk = func(e expression) {
+		switch e := e.(type) {
+		case *exprOperator2:
+			switch e.Operator {
+			case "AND", "OR":
+				walk(e.Expr1)
+				walk(e.Expr2)
+				return
+			}
+			if t := operatorHighlightTerm(e); t != nil {
+				terms = append(terms, *t)
+			}
+		case *exprFunction:
+			if t := fuzzyHighlightTerm(e); t != nil {
+				terms = append(terms, *t)
+			}
+		}
+	}
+	walk(e)
+	return terms
+}
+
+func operatorHighlightTerm(e *exprOperator2) *highlightTerm {
+	field, lower := highlightFieldName(e.Expr1)
+	if field == "" {
+		return nil
+	}
+	prefix := ""
+	if lower {
+		prefix = "(?i)"
+	}
+
+	var re string
+	switch e.Operator {
+	case "=":
+		value, ok := stringLiteral(e.Expr2)
+		if !ok {
+			return nil
+		}
+		re = prefix + "^" + regexp.QuoteMeta(value) + "$"
+	case "IN":
+		values, ok := e.Expr2.(*exprJSONLiteralArray)
+		if !ok {
+			return nil
+		}
+		var alts []string
+		for _, v := range values.Values {
+			if s, ok := v.Value.(string); ok {
+				alts = append(alts, regexp.QuoteMeta(s))
+			}
+		}
+		if len(alts) == 0 {
+			return nil
+		}
+		re = prefix + "^(?:" + strings.Join(alts, "|") + ")$"
+	case "LIKE", "ILIKE", "SIMILAR TO":
+		pattern, ok := stringLiteral(e.Expr2)
+		if !ok {
+			return nil
+		}
+		if e.Operator == "ILIKE" {
+			prefix = "(?i)"
+		}
+		re = prefix + "(?s)^" + patternToRegex(pattern, e.Operator == "SIMILAR TO") + "$"
+	case "~":
+		pattern, ok := stringLiteral(e.Expr2)
+		if !ok {
+			return nil
+		}
+		// undo the escaping of regexLiteral
+		re = prefix + strings.ReplaceAll(pattern, `\\`, `\`)
+	default:
+		return nil
+	}
+
+	r, err := regexp.Compile(re)
+	if err != nil {
+		return nil
+	}
+	return &highlightTerm{Field: field, Regexp: r}
+}
+
+func fuzzyHighlightTerm(e *exprFunction) *highlightTerm {
+	if e.Name != "EQUALS_FUZZY" && e.Name != "EQUALS_FUZZY_UNICODE" || len(e.Exprs) != 3 {
+		return nil
+	}
+	field, lower := highlightFieldName(e.Exprs[0])
+	if field == "" || lower {
+		return nil
+	}
+	value, ok := stringLiteral(e.Exprs[1])
+	if !ok {
+		return nil
+	}
+	edits, ok := e.Exprs[2].(*exprJSONLiteral)
+	if !ok {
+		return nil
+	}
+	var n int
+	switch v := edits.Value.Value.(type) {
+	case int:
+		n = v
+	case float64:
+		n = int(v)
+	default:
+		return nil
+	}
+	return &highlightTerm{
+		Field: field,
+		Fuzzy: &fuzzyTerm{Value: value, Edits: n, Unicode: e.Name == "EQUALS_FUZZY_UNICODE"},
+	}
+}
+
+// highlightFieldName returns the full name of the
+// field (if the expression refers to a field) and
+// if the field is converted to lower case
+func highlightFieldName(e expression) (string, bool) {
+	lower := false
+	if f, ok := e.(*exprFunction); ok && f.Name == "LOWER" && len(f.Exprs) == 1 {
+		e, lower = f.Exprs[0], true
+	}
+	fn, ok := e.(*exprFieldName)
+	if !ok || len(fn.Fields) == 0 {
+		return "", false
+	}
+	field := strings.Join(fn.Fields, ".")
+	if fn.Source == "" || fn.Source == DefaultSource {
+		return field, lower
+	}
+	// fields of nested documents are
+	// relative to the nested path
+	for _, s := range fn.Context.nested {
+		if s.Alias == fn.Source {
+			return s.Path + "." + field, lower
+		}
+	}
+	return "", false
+}
+
+func stringLiteral(e expression) (string, bool) {
+	l, ok := e.(*exprJSONLiteral)
+	if !ok {
+		return "", false
+	}
+	s, ok := l.Value.Value.(string)
+	return s, ok
+}
+
+// patternToRegex converts a LIKE or SIMILAR TO
+// pattern into a (Go) regular expression
+func patternToRegex(pattern string, similar bool) string {
+	var sb strings.Builder
+	for _, ch := range pattern {
+		switch {
+		case ch == '%':
+			sb.WriteString(".*")
+		case ch == '_':
+			sb.WriteRune('.')
+		case similar && strings.ContainsRune("|*+?{}()[]", ch):
+			sb.WriteRune(ch)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return sb.String()
+}
+
+// highlightSpan is a highlighted part of a value
+type highlightSpan struct {
+	Start, End int
+	Tag        int // index of the pre/post tags
+}
+
+// spans returns the parts of the value
+// that are matched by the term
+func (t *highlightTerm) spans(value string, tag int) []highlightSpan {
+	if t.Fuzzy != nil {
+		if t.Fuzzy.matches(value) {
+			return []highlightSpan{{Start: 0, End: len(value), Tag: tag}}
+		}
+		return nil
+	}
+
+	var spans []highlightSpan
+	for pos := 0; pos < len(value); {
+		loc := t.Regexp.FindStringIndex(value[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[0], pos+loc[1]
+		// the word boundaries of text
+		// predicates aren't highlighted
+		ts := start + len(value[start:end]) - len(strings.TrimLeft(value[start:end], " \t"))
+		te := start + len(strings.TrimRight(value[start:end], " \t"))
+		if ts < te {
+			spans = append(spans, highlightSpan{Start: ts, End: te, Tag: tag})
+		}
+		// a trailing boundary can be the leading
+		// boundary of the next occurrence
+		next := end
+		if te < end && te > ts {
+			next = te
+		}
+		if next <= pos {
+			next = pos + 1
+		}
+		pos = next
+	}
+	return spans
+}
+
+func (f *fuzzyTerm) matches(value string) bool {
+	if f.Unicode {
+		return fuzzyDistance([]rune(value), []rune(f.Value)) <= f.Edits
+	}
+	// ASCII case differences don't count
+	return fuzzyDistance([]byte(asciiLower(value)), []byte(asciiLower(f.Value))) <= f.Edits
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// fuzzyDistance returns the Damerau-Levenshtein
+// distance (optimal string alignment) of a and b
+func fuzzyDistance[T byte | rune](a, b []T) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// highlight returns the highlighted fragments
+// for each of the highlighted fields of the hit
+func (h *Highlight) highlight(hit map[string]any, terms []highlightTerm) map[string][]string {
+	requireFieldMatch := h.RequireFieldMatch == nil || *h.RequireFieldMatch
+	result := make(map[string][]string)
+	stringValues("", hit, func(key, value string) {
+		field := h.field(key)
+		if field == nil {
+			return
+		}
+		name := strings.ReplaceAll(strings.TrimPrefix(key, "@"), ".@", ".")
+		var spans []highlightSpan
+		for i := range terms {
+			if requireFieldMatch && terms[i].Field != name {
+				continue
+			}
+			spans = append(spans, terms[i].spans(value, i)...)
+		}
+		if len(spans) == 0 {
+			return
+		}
+		opts := h.options(field)
+		fragments := opts.fragments(value, mergeSpans(spans), h.Encoder == "html")
+		if n := *opts.NumberOfFragments; n > 0 {
+			// the fragments of all array elements are limited
+			fragments = fragments[:min(len(fragments), max(n-len(result[key]), 0))]
+		}
+		result[key] = append(result[key], fragments...)
+	})
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// field returns the (first) highlighted field that matches the key
+func (h *Highlight) field(key string) *highlightField {
+	for i := range h.Fields {
+		if matchWildcard(key, h.Fields[i].Name) {
+			return &h.Fields[i]
+		}
+	}
+	return nil
+}
+
+// options returns the options for the field
+// (with the global options and defaults applied)
+func (h *Highlight) options(field *highlightField) highlightOptions {
+	opts := field.highlightOptions
+	if opts.PreTags == nil {
+		opts.PreTags = h.PreTags
+	}
+	if opts.PostTags == nil {
+		opts.PostTags = h.PostTags
+	}
+	if opts.FragmentSize == nil {
+		opts.FragmentSize = h.FragmentSize
+	}
+	if opts.NumberOfFragments == nil {
+		opts.NumberOfFragments = h.NumberOfFragments
+	}
+
+	if len(opts.PreTags) == 0 {
+		opts.PreTags = []string{defaultPreTag}
+	}
+	if len(opts.PostTags) == 0 {
+		opts.PostTags = []string{defaultPostTag}
+	}
+	if opts.FragmentSize == nil {
+		size := defaultFragmentSize
+		opts.FragmentSize = &size
+	}
+	if opts.NumberOfFragments == nil {
+		n := defaultNumberOfFragments
+		opts.NumberOfFragments = &n
+	}
+	return opts
+}
+
+// stringValues invokes fn for all string values
+// (including array elements) with the full key
+func stringValues(key string, v any, fn func(key, value string)) {
+	switch v := v.(type) {
+	case string:
+		fn(key, v)
+	case []any:
+		for _, e := range v {
+			stringValues(key, e, fn)
+		}
+	case map[string]any:
+		for k, e := range v {
+			if key != "" {
+				k = key + "." + k
+			}
+			stringValues(k, e, fn)
+		}
+	}
+}
+
+// mergeSpans sorts the spans and drops
+// the spans that overlap a previous span
+func mergeSpans(spans []highlightSpan) []highlightSpan {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Start != spans[j].Start {
+			return spans[i].Start < spans[j].Start
+		}
+		return spans[i].End > spans[j].End
+	})
+	merged := spans[:0]
+	end := 0
+	for _, s := range spans {
+		if s.Start >= end {
+			merged = append(merged, s)
+			end = s.End
+		}
+	}
+	return merged
+}
+
+// fragments returns the (highlighted) fragments of the
+// value with the spans. The fragments are approximately
+// fragment_size bytes (extended to word boundaries).
+// The full value is returned when number_of_fragments is 0.
+func (o *highlightOptions) fragments(value string, spans []highlightSpan, escape bool) []string {
+	if *o.NumberOfFragments == 0 {
+		return []string{o.tagged(value, 0, len(value), spans, escape)}
+	}
+
+	size := *o.FragmentSize
+	var fragments []string
+	for i := 0; i < len(spans) && len(fragments) < *o.NumberOfFragments; {
+		s := spans[i]
+
+		// center the fragment around the span
+		margin := max((size-(s.End-s.Start))/2, 0)
+		start := max(s.Start-margin, 0)
+		end := len(value)
+		if size < end-start {
+			end = max(start+size, s.End)
+		}
+		for start > 0 && !utf8.RuneStart(value[start]) {
+			start--
+		}
+		for end < len(value) && !utf8.RuneStart(value[end]) {
+			end++
+		}
+
+		// don't split words (unless they are too long)
+		if lo := max(start-boundaryMaxScan, 0); start > 0 && !isSpace(value[start-1]) {
+			if j := strings.LastIndexAny(value[lo:start], " \t\r\n"); j >= 0 {
+				start = lo + j + 1
+			} else if lo == 0 {
+				start = 0
+			}
+		}
+		if hi := min(end+boundaryMaxScan, len(value)); end < len(value) && !isSpace(value[end]) {
+			if j := strings.IndexAny(value[end:hi], " \t\r\n"); j >= 0 {
+				end += j
+			} else if hi == len(value) {
+				end = hi
+			}
+		}
+
+		// include all spans that fit in the fragment
+		j := i
+		for j < len(spans) && spans[j].End <= end {
+			j++
+		}
+		fragments = append(fragments, strings.TrimSpace(o.tagged(value, start, end, spans[i:j], escape)))
+		i = j
+	}
+	return fragments
+}
+
+// tagged returns value[start:end] with the
+// spans surrounded by the pre and post tags
+func (o *highlightOptions) tagged(value string, start, end int, spans []highlightSpan, escape bool) string {
+	text := func(s string) string {
+		if escape {
+			return html.EscapeString(s)
+		}
+		return s
+	}
+	var sb strings.Builder
+	pos := start
+	for _, s := range spans {
+		sb.WriteString(text(value[pos:s.Start]))
+		sb.WriteString(o.PreTags[s.Tag%len(o.PreTags)])
+		sb.WriteString(text(value[s.Start:s.End]))
+		sb.WriteString(o.PostTags[s.Tag%len(o.PostTags)])
+		pos = s.End
+	}
+	sb.WriteString(text(value[pos:end]))
+	return sb.String()
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
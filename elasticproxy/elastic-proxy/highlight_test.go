@@ -0,0 +1,198 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		hit    map[string]any
+		expect map[string][]string
+	}{
+		{
+			name:  "query-string",
+			input: `{"query":{"query_string":{"query":"title:(quick fox)"}},"highlight":{"fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "The Quick brown fox jumps over the lazy dog", "tag": "fox"},
+			expect: map[string][]string{
+				"title": {"The <em>Quick</em> brown <em>fox</em> jumps over the lazy dog"},
+			},
+		},
+		{
+			name:  "adjacent-terms",
+			input: `{"query":{"query_string":{"query":"title:fox"}},"highlight":{"fields":{"*":{}}}}`,
+			hit:   map[string]any{"title": "fox fox"},
+			expect: map[string][]string{
+				"title": {"<em>fox</em> <em>fox</em>"},
+			},
+		},
+		{
+			name:  "tags",
+			input: `{"query":{"query_string":{"query":"title:\"brown fox\""}},"highlight":{"pre_tags":["["],"post_tags":["]"],"fields":[{"title":{}}]}}`,
+			hit:   map[string]any{"title": "quick brown fox"},
+			expect: map[string][]string{
+				"title": {"quick [brown fox]"},
+			},
+		},
+		{
+			name:  "match",
+			input: `{"query":{"match":{"title":"quick fox"}},"highlight":{"fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "quick fox"},
+			expect: map[string][]string{
+				"title": {"<em>quick fox</em>"},
+			},
+		},
+		{
+			name:  "multi-match",
+			input: `{"query":{"multi_match":{"query":"quick fox","fields":["title","tag"]}},"highlight":{"fields":{"*":{}}}}`,
+			hit:   map[string]any{"title": "a quick red fox", "tag": "quick fox"},
+			expect: map[string][]string{
+				"title": {"a <em>quick</em> red <em>fox</em>"},
+				"tag":   {"<em>quick fox</em>"},
+			},
+		},
+		{
+			name:  "require-field-match",
+			input: `{"query":{"query_string":{"query":"title:fox"}},"highlight":{"require_field_match":false,"fields":{"*":{}}}}`,
+			hit:   map[string]any{"title": "a fox", "body": "the fox", "tag": "fox"},
+			expect: map[string][]string{
+				"title": {"a <em>fox</em>"},
+				"body":  {"the <em>fox</em>"},
+				"tag":   {"<em>fox</em>"},
+			},
+		},
+		{
+			name:  "keyword",
+			input: `{"query":{"term":{"tag":"Big Cat"}},"highlight":{"fields":{"tag":{}}}}`,
+			hit:   map[string]any{"tag": "Big Cat"},
+			expect: map[string][]string{
+				"tag": {"<em>Big Cat</em>"},
+			},
+		},
+		{
+			name:  "prefix",
+			input: `{"query":{"prefix":{"tag":"Bi"}},"highlight":{"fields":{"tag":{}}}}`,
+			hit:   map[string]any{"tag": "Big Cat"},
+			expect: map[string][]string{
+				"tag": {"<em>Big Cat</em>"},
+			},
+		},
+		{
+			name:  "terms",
+			input: `{"query":{"terms":{"tag":["a","b"]}},"highlight":{"fields":{"tag":{}}}}`,
+			hit:   map[string]any{"tag": []any{"a", "c", "b"}},
+			expect: map[string][]string{
+				"tag": {"<em>a</em>", "<em>b</em>"},
+			},
+		},
+		{
+			name:  "fuzzy",
+			input: `{"query":{"fuzzy":{"tag":{"value":"Cats","fuzziness":1}}},"highlight":{"fields":{"tag":{}}}}`,
+			hit:   map[string]any{"tag": []any{"cast", "dog"}},
+			expect: map[string][]string{
+				"tag": {"<em>cast</em>"},
+			},
+		},
+		{
+			name:  "fuzzy-unicode",
+			input: `{"query":{"fuzzy":{"tag":{"value":"Käse","fuzziness":1}}},"highlight":{"fields":{"tag":{}}}}`,
+			hit:   map[string]any{"tag": []any{"käse", "Kse", "käsen"}},
+			expect: map[string][]string{
+				"tag": {"<em>käse</em>", "<em>Kse</em>"},
+			},
+		},
+		{
+			name:  "must-not",
+			input: `{"query":{"bool":{"must":{"query_string":{"query":"title:quick"}},"must_not":{"query_string":{"query":"title:fox"}}}},"highlight":{"fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "quick dog fox"},
+			expect: map[string][]string{
+				"title": {"<em>quick</em> dog fox"},
+			},
+		},
+		{
+			name:  "no-match",
+			input: `{"query":{"match":{"title":"fox"}},"highlight":{"fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "a dog"},
+		},
+		{
+			name:  "nested-object",
+			input: `{"query":{"query_string":{"query":"user.name:bob"}},"highlight":{"fields":{"user.*":{}}}}`,
+			hit:   map[string]any{"user": map[string]any{"name": "Bob Smith", "id": "bob"}},
+			expect: map[string][]string{
+				"user.name": {"<em>Bob</em> Smith"},
+			},
+		},
+		{
+			name:  "fragments",
+			input: `{"query":{"query_string":{"query":"title:fox"}},"highlight":{"fragment_size":12,"number_of_fragments":2,"fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "the fox was here and then another fox came and a third fox"},
+			expect: map[string][]string{
+				"title": {"the <em>fox</em> was here", "another <em>fox</em> came"},
+			},
+		},
+		{
+			name:  "whole-value",
+			input: `{"query":{"query_string":{"query":"title:fox"}},"highlight":{"fragment_size":5,"fields":{"title":{"number_of_fragments":0}}}}`,
+			hit:   map[string]any{"title": "the fox was here and then another fox came"},
+			expect: map[string][]string{
+				"title": {"the <em>fox</em> was here and then another <em>fox</em> came"},
+			},
+		},
+		{
+			name:  "html-encoder",
+			input: `{"query":{"query_string":{"query":"title:fox"}},"highlight":{"encoder":"html","fields":{"title":{}}}}`,
+			hit:   map[string]any{"title": "fox & <b>dog</b>"},
+			expect: map[string][]string{
+				"title": {"<em>fox</em> &amp; &lt;b&gt;dog&lt;/b&gt;"},
+			},
+		},
+	}
+
+	for i := range tests {
+		test := &tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(test.input), &ej); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", test.input, err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+				TypeMapping: map[string]TypeMapping{
+					"tag": {Type: "keyword"},
+				},
+			}
+			if _, err := ej.SQL(&qc); err != nil {
+				t.Fatal(err)
+			}
+			result, _, err := ej.ConvertResult(&qc, map[string]any{
+				TotalCountBucket: 1,
+				HitsBucket:       []any{test.hit},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := result.Hits.Hits[0].Highlight
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("expected %v, got %v", test.expect, got)
+			}
+		})
+	}
+}
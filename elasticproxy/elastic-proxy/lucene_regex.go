@@ -0,0 +1,303 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// optional operators of the Lucene regular expression syntax
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/regexp-syntax.html
+const (
+	luceneRegexComplement   = 1 << iota // ~
+	luceneRegexEmpty                    // #
+	luceneRegexIntersection             // &
+	luceneRegexInterval                 // <n-m>
+	luceneRegexAnyString                // @
+
+	luceneRegexNone = 0
+	luceneRegexAll  = luceneRegexComplement | luceneRegexEmpty | luceneRegexIntersection | luceneRegexInterval | luceneRegexAnyString
+)
+
+var (
+	ErrUnsupportedRegexOperator = errors.New("unsupported regex operator")
+)
+
+// parseLuceneRegexFlags parses the 'flags'
+// of the regexp query (i.e. "INTERVAL|ANYSTRING")
+func parseLuceneRegexFlags(text string) (int, error) {
+	if text == "" {
+		return luceneRegexAll, nil
+	}
+	flags := luceneRegexNone
+	for _, f := range strings.Split(text, "|") {
+		switch strings.TrimSpace(f) {
+		case "ALL":
+			flags |= luceneRegexAll
+		case "NONE":
+		case "COMPLEMENT":
+			flags |= luceneRegexComplement
+		case "EMPTY":
+			flags |= luceneRegexEmpty
+		case "INTERSECTION":
+			flags |= luceneRegexIntersection
+		case "INTERVAL":
+			flags |= luceneRegexInterval
+		case "ANYSTRING":
+			flags |= luceneRegexAnyString
+		default:
+			return 0, fmt.Errorf("unknown regex flag %q", f)
+		}
+	}
+	return flags, nil
+}
+
+// translateLuceneRegex converts a Lucene regular
+// expression to a Go (RE2) regular expression.
+//
+// Lucene regular expressions always match the
+// entire string, so the result is anchored.
+// Lucene doesn't support anchors or character
+// classes, such as \d, so '^', '$' and escaped
+// characters are always matched literally.
+func translateLuceneRegex(re string, flags int, caseInsensitive bool) (string, error) {
+	var sb strings.Builder
+	sb.WriteRune('^')
+	if caseInsensitive {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("(?s:")
+
+	runes := []rune(re)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case '\\':
+			i++
+			if i >= len(runes) {
+				return "", errors.New("regex can't end with an escape character")
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return "", errors.New("unterminated string in regex")
+			}
+			sb.WriteString(regexp.QuoteMeta(string(runes[i+1 : end])))
+			i = end
+		case '[':
+			end, err := translateLuceneClass(&sb, runes, i)
+			if err != nil {
+				return "", err
+			}
+			i = end
+		case '(':
+			sb.WriteString("(?:")
+		case '.', '?', '+', '*', '|', ')', '{', '}':
+			sb.WriteRune(r)
+		case '#':
+			if flags&luceneRegexEmpty == 0 {
+				sb.WriteRune(r)
+			} else {
+				// the empty language never matches
+				sb.WriteString(`[^\x00-\x{10FFFF}]`)
+			}
+		case '@':
+			if flags&luceneRegexAnyString == 0 {
+				sb.WriteRune(r)
+			} else {
+				sb.WriteString(".*")
+			}
+		case '<':
+			if flags&luceneRegexInterval == 0 {
+				sb.WriteRune(r)
+				continue
+			}
+			end := i + 1
+			for end < len(runes) && runes[end] != '>' {
+				end++
+			}
+			if end >= len(runes) {
+				return "", errors.New("unterminated interval in regex")
+			}
+			interval, err := translateLuceneInterval(string(runes[i+1 : end]))
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(interval)
+			i = end
+		case '~':
+			if flags&luceneRegexComplement != 0 {
+				return "", fmt.Errorf("%w: complement (~)", ErrUnsupportedRegexOperator)
+			}
+			sb.WriteRune(r)
+		case '&':
+			if flags&luceneRegexIntersection != 0 {
+				return "", fmt.Errorf("%w: intersection (&)", ErrUnsupportedRegexOperator)
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString(")$")
+
+	// make sure the translated regex is valid
+	result := sb.String()
+	if _, err := regexp.Compile(result); err != nil {
+		return "", fmt.Errorf("invalid regex %q: %w", re, err)
+	}
+	return result, nil
+}
+
+// translateLuceneClass translates the character
+// class that starts at runes[start] and returns
+// the index of the closing bracket
+func translateLuceneClass(sb *strings.Builder, runes []rune, start int) (int, error) {
+	sb.WriteRune('[')
+	i := start + 1
+	if i < len(runes) && runes[i] == '^' {
+		sb.WriteRune('^')
+		i++
+	}
+	for ; i < len(runes); i++ {
+		r := runes[i]
+		switch r {
+		case ']':
+			sb.WriteRune(']')
+			return i, nil
+		case '\\':
+			i++
+			if i >= len(runes) {
+				return 0, errors.New("regex can't end with an escape character")
+			}
+			r = runes[i]
+			fallthrough
+		default:
+			if r != '-' && strings.ContainsRune(`\[]^-`, r) {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(r)
+		}
+	}
+	return 0, errors.New("unterminated character class in regex")
+}
+
+// translateLuceneInterval converts a numeric
+// interval (i.e. "1-100") to a regex. When the
+// lower bound is zero-padded, then the numbers
+// must have the same width.
+func translateLuceneInterval(interval string) (string, error) {
+	lo, hi, ok := strings.Cut(interval, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid interval <%s> in regex", interval)
+	}
+	minValue, err1 := strconv.ParseUint(lo, 10, 64)
+	maxValue, err2 := strconv.ParseUint(hi, 10, 64)
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("invalid interval <%s> in regex", interval)
+	}
+	if minValue > maxValue {
+		minValue, maxValue = maxValue, minValue
+	}
+
+	width := 0
+	if len(lo) > 1 && lo[0] == '0' {
+		width = len(lo)
+	}
+
+	var alternatives []string
+	if width > 0 {
+		alternatives = numericRangeRegex(
+			fmt.Sprintf("%0*d", width, minValue),
+			fmt.Sprintf("%0*d", width, maxValue))
+	} else {
+		// split the range in ranges with the same number of digits
+		for digits := len(strconv.FormatUint(minValue, 10)); digits <= len(strconv.FormatUint(maxValue, 10)); digits++ {
+			from := uint64(0)
+			if digits > 1 {
+				from = pow10(digits - 1)
+			}
+			to := pow10(digits) - 1
+			if from < minValue {
+				from = minValue
+			}
+			if to > maxValue {
+				to = maxValue
+			}
+			alternatives = append(alternatives, numericRangeRegex(
+				strconv.FormatUint(from, 10),
+				strconv.FormatUint(to, 10))...)
+		}
+	}
+	return "(?:" + strings.Join(alternatives, "|") + ")", nil
+}
+
+func pow10(n int) uint64 {
+	v := uint64(1)
+	for i := 0; i < n; i++ {
+		v *= 10
+	}
+	return v
+}
+
+// numericRangeRegex returns the regexes that match
+// all numbers between lo and hi, which must have
+// the same number of digits
+func numericRangeRegex(lo, hi string) []string {
+	if lo == hi {
+		return []string{lo}
+	}
+	if lo[0] == hi[0] {
+		var result []string
+		for _, re := range numericRangeRegex(lo[1:], hi[1:]) {
+			result = append(result, lo[:1]+re)
+		}
+		return result
+	}
+
+	rest := len(lo) - 1
+	anyDigits := strings.Repeat("[0-9]", rest)
+
+	var result []string
+	first, last := lo[0], hi[0]
+	if strings.Trim(lo[1:], "0") != "" {
+		for _, re := range numericRangeRegex(lo[1:], strings.Repeat("9", rest)) {
+			result = append(result, lo[:1]+re)
+		}
+		first++
+	}
+	var upper []string
+	if strings.Trim(hi[1:], "9") != "" {
+		for _, re := range numericRangeRegex(strings.Repeat("0", rest), hi[1:]) {
+			upper = append(upper, hi[:1]+re)
+		}
+		last--
+	}
+	switch {
+	case first == last:
+		result = append(result, string(first)+anyDigits)
+	case first < last:
+		result = append(result, "["+string(first)+"-"+string(last)+"]"+anyDigits)
+	}
+	return append(result, upper...)
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestTranslateLuceneRegex(t *testing.T) {
+	type testCase struct {
+		regex    string
+		flags    string
+		matches  []string
+		excludes []string
+	}
+	testCases := []testCase{
+		{regex: "ab.*", matches: []string{"ab", "abc", "ab\ncd"}, excludes: []string{"xab", "a"}},
+		{regex: "a\\.b", matches: []string{"a.b"}, excludes: []string{"axb"}},
+		{regex: `"a.b"c+`, matches: []string{"a.bc", "a.bccc"}, excludes: []string{"axbc"}},
+		{regex: "[a-c]{2,3}", matches: []string{"ab", "cca"}, excludes: []string{"a", "abca", "ad"}},
+		{regex: "[^a-c]x", matches: []string{"dx"}, excludes: []string{"ax"}},
+		{regex: "(ab|cd)?e", matches: []string{"e", "abe", "cde"}, excludes: []string{"abcde"}},
+		{regex: "^a$", matches: []string{"^a$"}, excludes: []string{"a"}},
+		{regex: "a@", matches: []string{"a", "abc"}, excludes: []string{"ba"}},
+		{regex: "a@", flags: "NONE", matches: []string{"a@"}, excludes: []string{"abc"}},
+		{regex: "a#", matches: nil, excludes: []string{"a", "a#"}},
+		{regex: "foo<1-100>", matches: []string{"foo1", "foo9", "foo10", "foo57", "foo100"}, excludes: []string{"foo0", "foo101", "foo01"}},
+		{regex: "<08-12>", matches: []string{"08", "09", "10", "12"}, excludes: []string{"8", "07", "13"}},
+		{regex: "<7-23>", matches: []string{"7", "19", "20", "23"}, excludes: []string{"6", "24", "30"}},
+		{regex: "a<1-2>", flags: "ANYSTRING", matches: []string{"a<1-2>"}, excludes: []string{"a1"}},
+		{regex: "a~b", flags: "INTERVAL", matches: []string{"a~b"}},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			flags, err := parseLuceneRegexFlags(tc.flags)
+			if err != nil {
+				t.Fatalf("invalid flags %q: %v", tc.flags, err)
+			}
+			re, err := translateLuceneRegex(tc.regex, flags, false)
+			if err != nil {
+				t.Fatalf("cannot translate %q: %v", tc.regex, err)
+			}
+			compiled := regexp.MustCompile(re)
+			for _, m := range tc.matches {
+				if !compiled.MatchString(m) {
+					t.Errorf("%q (translated to %q) should match %q", tc.regex, re, m)
+				}
+			}
+			for _, m := range tc.excludes {
+				if compiled.MatchString(m) {
+					t.Errorf("%q (translated to %q) shouldn't match %q", tc.regex, re, m)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateLuceneRegexErrors(t *testing.T) {
+	for _, re := range []string{"a~b", "a&b"} {
+		if _, err := translateLuceneRegex(re, luceneRegexAll, false); !errors.Is(err, ErrUnsupportedRegexOperator) {
+			t.Errorf("%q: expected unsupported operator, got %v", re, err)
+		}
+	}
+	for _, re := range []string{"a\\", "[abc", `"abc`, "<1-", "<a-b>", "(ab"} {
+		if _, err := translateLuceneRegex(re, luceneRegexAll, false); err == nil {
+			t.Errorf("%q: expected an error", re)
+		}
+	}
+	if _, err := parseLuceneRegexFlags("INTERVAL|FOO"); err == nil {
+		t.Error("expected unknown flag to be rejected")
+	}
+}
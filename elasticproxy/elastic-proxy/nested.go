@@ -0,0 +1,173 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// nestedScope is an array of objects that has
+// been unnested using `FROM ..., <array> AS <alias>`
+type nestedScope struct {
+	Path  string         // full path of the array (e.g. "spans")
+	Alias string         // binding of the array elements
+	Array *exprFieldName // expression of the (unnested) array
+}
+
+// resolveNested checks if the field belongs to
+// one of the nested paths and returns the
+// (innermost) scope and the remaining fields.
+func (qc *QueryContext) resolveNested(fields []string) (*nestedScope, []string) {
+	fullFieldName := strings.Join(fields, ".")
+	for i := len(qc.nested) - 1; i >= 0; i-- {
+		s := &qc.nested[i]
+		if strings.HasPrefix(fullFieldName, s.Path+".") {
+			return s, fields[strings.Count(s.Path, ".")+1:]
+		}
+	}
+	return nil, nil
+}
+
+// withNested returns a copy of the query context
+// that unnests the array at the given path. The
+// fields within the path are resolved against the
+// array elements.
+func (qc *QueryContext) withNested(path string) *QueryContext {
+	array := ParseExprFieldName(qc, path)
+	source := DefaultSource
+	if array.Source != "" {
+		source = array.Source
+	}
+
+	// make sure the alias is unique when the same
+	// path is unnested multiple times
+	alias := SourceAliasPrefix + path
+	for i := 1; qc.hasSource(alias); i++ {
+		alias = fmt.Sprintf("%s%s#%d", SourceAliasPrefix, path, i)
+	}
+
+	nqc := *qc
+	nqc.Sources = append(slices.Clip(qc.Sources), &exprFieldNameAlias{
+		Context: qc,
+		Alias:   alias,
+		Fields:  append([]string{source}, array.Fields...),
+	})
+	nqc.nested = append(slices.Clip(qc.nested), nestedScope{
+		Path:  path,
+		Alias: alias,
+		Array: array,
+	})
+	nqc.docKey = nil
+	return &nqc
+}
+
+// withReverseNested returns a copy of the query
+// context that resolves the fields against the
+// given (parent) nested path or the root document
+// if the path is empty. The nested rows are still
+// joined, so documents are counted by the array
+// that was unnested directly below the path.
+func (qc *QueryContext) withReverseNested(path string) (*QueryContext, error) {
+	if len(qc.nested) == 0 {
+		return nil, errors.New("reverse_nested can only be used within a nested aggregation")
+	}
+	level := -1
+	if path != "" {
+		level = slices.IndexFunc(qc.nested, func(s nestedScope) bool { return s.Path == path })
+		if level < 0 {
+			return nil, fmt.Errorf("reverse_nested path %q isn't a parent nested path", path)
+		}
+		if level == len(qc.nested)-1 {
+			return nil, fmt.Errorf("reverse_nested path %q should be a parent of the current nested path", path)
+		}
+	}
+
+	nqc := *qc
+	nqc.nested = slices.Clip(qc.nested[:level+1])
+	nqc.docKey = qc.nested[level+1].Array
+	return &nqc, nil
+}
+
+func (qc *QueryContext) hasSource(alias string) bool {
+	for _, s := range qc.Sources {
+		if a, ok := s.(*exprFieldNameAlias); ok && a.Alias == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// nested implements the nested query. The array is
+// unnested in the source query and the documents are
+// deduplicated again, so a document matches when at
+// least one array element matches the inner query.
+type nested struct {
+	Path           string        `json:"path"`
+	Query          *Query        `json:"query"`
+	ScoreMode      string        `json:"score_mode"`
+	IgnoreUnmapped bool          `json:"ignore_unmapped"`
+	InnerHits      *notSupported `json:"inner_hits"`
+}
+
+func (n *nested) Expression(qc *QueryContext) (expression, error) {
+	if n.Path == "" {
+		return nil, errors.New("nested query requires a 'path'")
+	}
+	if n.Query == nil {
+		return nil, errors.New("nested query requires a 'query'")
+	}
+	switch n.ScoreMode {
+	case "", "avg", "max", "min", "none", "sum":
+	default:
+		return nil, fmt.Errorf("invalid score_mode %q in nested query", n.ScoreMode)
+	}
+	if qc.nestedKeys == nil {
+		return nil, errors.New("nested query is only supported in the search query")
+	}
+	if qc.optional > 0 {
+		return nil, errors.New("nested query isn't supported within 'should' or 'must_not'")
+	}
+
+	nqc := qc.withNested(n.Path)
+	e, err := n.Query.Expression(nqc)
+	if err != nil {
+		return nil, err
+	}
+
+	// the (unnested) arrays are added to the source
+	// query and the top-level arrays identify the
+	// document for deduplication
+	qc.Sources = nqc.Sources
+	if len(qc.nested) == 0 {
+		key := nqc.nested[len(nqc.nested)-1].Array
+		if !slices.ContainsFunc(*qc.nestedKeys, func(k expression) bool { return PrintExpr(k) == PrintExpr(key) }) {
+			*qc.nestedKeys = append(*qc.nestedKeys, key)
+		}
+	}
+
+	// make sure the unnesting is used, so the
+	// documents without any elements are dropped
+	if l, ok := e.(*exprJSONLiteral); e == nil || ok && l.Value.Value == true {
+		e = &exprOperator1{
+			Context:  nqc,
+			Operator: "IS NOT MISSING",
+			Expr1:    ParseExprSourceName(nqc, nqc.nested[len(nqc.nested)-1].Alias),
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNested(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		err      string
+	}{
+		{
+			name:  "match-all",
+			input: `{"size":1,"query":{"nested":{"path":"spans","query":{"match_all":{}}}}}`,
+			contains: []string{
+				`SELECT DISTINCT ON ("$source"."spans") *`,
+				`"$source"."spans" AS "$source:spans"`,
+				`WHERE ("$source:spans" IS NOT MISSING)`,
+			},
+		},
+		{
+			name:  "multiple-paths",
+			input: `{"size":1,"query":{"bool":{"must":[{"nested":{"path":"spans","query":{"term":{"spans.name":"a"}}}},{"nested":{"path":"tags","query":{"term":{"tags.key":"b"}}}}]}}}`,
+			contains: []string{
+				`SELECT DISTINCT ON ("$source"."spans", "$source"."tags") *`,
+				`"$source"."spans" AS "$source:spans"`,
+				`"$source"."tags" AS "$source:tags"`,
+				`("$source:spans"."name" = 'a')`,
+				`("$source:tags"."key" = 'b')`,
+			},
+		},
+		{
+			name:  "same-path-twice",
+			input: `{"size":1,"query":{"bool":{"filter":[{"nested":{"path":"spans","query":{"term":{"spans.name":"a"}}}},{"nested":{"path":"spans","query":{"term":{"spans.name":"b"}}}}]}}}`,
+			contains: []string{
+				`SELECT DISTINCT ON ("$source"."spans") *`,
+				`"$source"."spans" AS "$source:spans#1"`,
+				`("$source:spans#1"."name" = 'b')`,
+			},
+		},
+		{
+			name:  "nested-within-nested",
+			input: `{"size":1,"query":{"nested":{"path":"spans","query":{"nested":{"path":"spans.events","query":{"term":{"spans.events.type":"error"}}}}}}}`,
+			contains: []string{
+				`SELECT DISTINCT ON ("$source"."spans") *`,
+				`"$source:spans"."events" AS "$source:spans.events"`,
+				`("$source:spans.events"."type" = 'error')`,
+			},
+		},
+		{
+			name:  "filter-within-nested-aggregation",
+			input: `{"size":0,"aggs":{"spans":{"nested":{"path":"spans"},"aggs":{"errors":{"filter":{"term":{"spans.status":"error"}}}}}}}`,
+			contains: []string{
+				`COUNT("$source:spans") AS "$doc_count"`,
+				`WHERE ("$source:spans"."status" = 'error')`,
+			},
+		},
+		{
+			name:  "must-not",
+			input: `{"query":{"bool":{"must_not":{"nested":{"path":"spans","query":{"term":{"spans.name":"a"}}}}}}}`,
+			err:   "nested query isn't supported within 'should' or 'must_not'",
+		},
+		{
+			name:  "should",
+			input: `{"query":{"bool":{"should":[{"term":{"x":1}},{"nested":{"path":"spans","query":{"term":{"spans.name":"a"}}}}]}}}`,
+			err:   "nested query isn't supported within 'should' or 'must_not'",
+		},
+		{
+			name:  "filter-aggregation",
+			input: `{"size":0,"aggs":{"a":{"filter":{"nested":{"path":"spans","query":{"term":{"spans.name":"a"}}}}}}}`,
+			err:   "nested query is only supported in the search query",
+		},
+		{
+			name:  "reverse-nested-without-nested",
+			input: `{"size":0,"aggs":{"a":{"reverse_nested":{}}}}`,
+			err:   "reverse_nested can only be used within a nested aggregation",
+		},
+		{
+			name:  "reverse-nested-invalid-path",
+			input: `{"size":0,"aggs":{"a":{"nested":{"path":"spans"},"aggs":{"b":{"reverse_nested":{"path":"spans"}}}}}}`,
+			err:   `reverse_nested path "spans" should be a parent of the current nested path`,
+		},
+	}
+
+	for i := range tests {
+		test := &tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(test.input), &ej); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", test.input, err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+			}
+			sql, err := ej.SQL(&qc)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := PrintExpr(sql)
+			for _, c := range test.contains {
+				if !strings.Contains(got, c) {
+					t.Errorf("expected %s in %s", c, got)
+				}
+			}
+		})
+	}
+}
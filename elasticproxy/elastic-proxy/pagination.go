@@ -0,0 +1,265 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// PointInTime refers to a point-in-time that
+// has been opened via the '_pit' endpoint
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/point-in-time-api.html
+type PointInTime struct {
+	ID        string `json:"id"`
+	KeepAlive string `json:"keep_alive"`
+}
+
+// ScrollPosition holds the position of a
+// scroll within the search results.
+//
+// Sorted results use keyset pagination, so the
+// next page starts at the sort values of the
+// last hit. Hits with the same sort values as
+// the last hit that were already returned are
+// skipped using an offset (Ties), so sort values
+// don't need to be unique.
+//
+// Unsorted results can only use an offset.
+type ScrollPosition struct {
+	Offset int         `json:"offset,omitempty"`
+	After  []sortValue `json:"after,omitempty"`
+	Ties   int         `json:"ties,omitempty"`
+}
+
+// sortValue is a sort value that preserves
+// timestamps when it is serialized to JSON
+type sortValue struct {
+	JSONLiteral
+}
+
+func (v sortValue) MarshalJSON() ([]byte, error) {
+	if t, ok := v.Value.(time.Time); ok {
+		return json.Marshal(map[string]string{"timestamp": t.Format(time.RFC3339Nano)})
+	}
+	return json.Marshal(v.Value)
+}
+
+func (v *sortValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var ts struct {
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal(data, &ts); err != nil {
+			return err
+		}
+		v.Value = ts.Timestamp
+		return nil
+	}
+	return v.JSONLiteral.UnmarshalJSON(data)
+}
+
+var (
+	ErrSearchAfterWithoutSort = errors.New("search_after requires a sort")
+	ErrSearchAfterWithFrom    = errors.New("from parameter must be set to 0 when search_after is used")
+)
+
+// keysetQuery returns the expression that
+// only selects the hits that follow the
+// sort values (taking the sort order into
+// account). When inclusive is set, then hits
+// with the exact sort values are selected too.
+func (ej *ElasticJSON) keysetQuery(qc *QueryContext, values []JSONLiteral, inclusive bool) (expression, error) {
+	if len(ej.Sort) == 0 {
+		return nil, ErrSearchAfterWithoutSort
+	}
+	if len(values) != len(ej.Sort) {
+		return nil, fmt.Errorf("search_after has %d value(s), but there are %d sort field(s)", len(values), len(ej.Sort))
+	}
+
+	literals := make([]expression, len(values))
+	for i, v := range values {
+		literals[i] = &exprJSONLiteral{Context: qc, Value: keysetValue(qc, ej.Sort[i].Field, v)}
+	}
+
+	// (k0 > v0) OR (k0 = v0 AND k1 > v1) OR ...
+	var alternatives []expression
+	for i := range ej.Sort {
+		operator := ">"
+		if ej.Sort[i].Order == OrderDescending {
+			operator = "<"
+		}
+		var exprs []expression
+		for j := 0; j < i; j++ {
+			exprs = append(exprs, &exprOperator2{
+				Context:  qc,
+				Operator: "=",
+				Expr1:    qc.sortExpression(ej.Sort[j].Field),
+				Expr2:    literals[j],
+			})
+		}
+		exprs = append(exprs, &exprOperator2{
+			Context:  qc,
+			Operator: operator,
+			Expr1:    qc.sortExpression(ej.Sort[i].Field),
+			Expr2:    literals[i],
+		})
+		alternatives = append(alternatives, andExpressions(exprs))
+	}
+	if inclusive {
+		var exprs []expression
+		for i := range ej.Sort {
+			exprs = append(exprs, &exprOperator2{
+				Context:  qc,
+				Operator: "=",
+				Expr1:    qc.sortExpression(ej.Sort[i].Field),
+				Expr2:    literals[i],
+			})
+		}
+		alternatives = append(alternatives, andExpressions(exprs))
+	}
+	return joinExpressions(alternatives, "OR"), nil
+}
+
+// keysetValue converts a sort value back to
+// the value that can be compared with the
+// field. Timestamps are returned as the number
+// of milliseconds since epoch in the sort values.
+func keysetValue(qc *QueryContext, field string, v JSONLiteral) JSONLiteral {
+	f, ok := format(field, qc.TypeMapping)
+	if !ok {
+		return v
+	}
+	if isTimestampFormat(f) {
+		switch ms := v.Value.(type) {
+		case int64:
+			return JSONLiteral{Value: time.UnixMilli(ms).UTC()}
+		case float64:
+			return JSONLiteral{Value: time.UnixMilli(int64(ms)).UTC()}
+		}
+	}
+	return v
+}
+
+// paginate restricts the hits to the
+// search_after values or scroll position
+func (ej *ElasticJSON) paginate(qc *QueryContext, hits *exprSelect) error {
+	if ej.SearchAfter != nil {
+		if hits.Offset > 0 {
+			return ErrSearchAfterWithFrom
+		}
+		where, err := ej.keysetQuery(qc, ej.SearchAfter, false)
+		if err != nil {
+			return err
+		}
+		hits.Where = where
+	}
+
+	if pos := ej.ScrollPosition; pos != nil {
+		if pos.After == nil {
+			hits.Offset += pos.Offset
+			return nil
+		}
+		values := make([]JSONLiteral, len(pos.After))
+		for i := range pos.After {
+			values[i] = pos.After[i].JSONLiteral
+		}
+		where, err := ej.keysetQuery(qc, values, true)
+		if err != nil {
+			return err
+		}
+		hits.Where = andExpressions([]expression{hits.Where, where})
+		hits.Offset = pos.Ties
+	}
+	return nil
+}
+
+// NextScrollPosition determines the scroll
+// position after the hits of the result.
+func (ej *ElasticJSON) NextScrollPosition(result *ElasticResult) *ScrollPosition {
+	prev := ej.ScrollPosition
+	if prev == nil {
+		prev = &ScrollPosition{}
+	}
+	var hits []elasticResultHitRecord
+	if result.Hits != nil {
+		hits = result.Hits.Hits
+	}
+	if len(hits) == 0 {
+		return prev
+	}
+
+	if len(ej.Sort) == 0 {
+		return &ScrollPosition{Offset: prev.Offset + len(hits)}
+	}
+
+	last := hits[len(hits)-1].rawSort
+	next := ScrollPosition{
+		After: make([]sortValue, len(last)),
+	}
+	for i, v := range last {
+		next.After[i] = sortValue{JSONLiteral{Value: v}}
+	}
+
+	// count the hits with the same sort
+	// values at the end of the page
+	ties := 0
+	for i := len(hits) - 1; i >= 0 && equalSortValues(hits[i].rawSort, last); i-- {
+		ties++
+	}
+	if ties == len(hits) && prev.After != nil && equalSortValues(rawValues(prev.After), last) {
+		// all hits have the same values as
+		// the hits that were skipped before
+		ties += prev.Ties
+	}
+	next.Ties = ties
+	return &next
+}
+
+func rawValues(values []sortValue) []any {
+	result := make([]any, len(values))
+	for i := range values {
+		result[i] = values[i].Value
+	}
+	return result
+}
+
+func equalSortValues(a, b []any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalSortValue(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSortValue(a, b any) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	fa, errA := NewElasticFloat(a)
+	fb, errB := NewElasticFloat(b)
+	if errA == nil && errB == nil {
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		position *ScrollPosition
+		where    string
+		offset   int
+		err      error
+	}{
+		{
+			name:  "search_after",
+			input: `{"sort":[{"timestamp":{"order":"desc"}},{"id":{"order":"asc"}}],"search_after":[1672531200000,"abc"]}`,
+			where: "((\"$source\".\"timestamp\" < `2023-01-01T00:00:00Z`) OR ((\"$source\".\"timestamp\" = `2023-01-01T00:00:00Z`) AND (\"$source\".\"id\" > 'abc')))",
+		},
+		{
+			name:  "search_after-without-sort",
+			input: `{"search_after":[1]}`,
+			err:   ErrSearchAfterWithoutSort,
+		},
+		{
+			name:  "search_after-with-from",
+			input: `{"from":10,"sort":[{"id":{"order":"asc"}}],"search_after":[1]}`,
+			err:   ErrSearchAfterWithFrom,
+		},
+		{
+			name:     "scroll-offset",
+			input:    `{"size":10}`,
+			position: &ScrollPosition{Offset: 20},
+			offset:   20,
+		},
+		{
+			name:     "scroll-keyset",
+			input:    `{"size":10,"sort":[{"id":{"order":"asc"}}]}`,
+			position: &ScrollPosition{After: []sortValue{{JSONLiteral{Value: int64(5)}}}, Ties: 2},
+			where:    `(("$source"."id" > 5) OR ("$source"."id" = 5))`,
+			offset:   2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(tc.input), &ej); err != nil {
+				t.Fatal(err)
+			}
+			ej.ScrollPosition = tc.position
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+				TypeMapping: map[string]TypeMapping{
+					"timestamp": {Type: "datetime"},
+				},
+			}
+			hits := &exprSelect{Context: &qc}
+			if ej.From != nil {
+				hits.Offset = *ej.From
+			}
+			err := ej.paginate(&qc, hits)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got error %v, expected %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hits.Offset != tc.offset {
+				t.Errorf("got offset %d, expected %d", hits.Offset, tc.offset)
+			}
+			got := ""
+			if hits.Where != nil {
+				got = PrintExpr(hits.Where)
+			}
+			if got != tc.where {
+				t.Errorf("got WHERE %s, expected %s", got, tc.where)
+			}
+		})
+	}
+}
+
+func TestNextScrollPosition(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	hit := func(values ...any) elasticResultHitRecord {
+		return elasticResultHitRecord{rawSort: values}
+	}
+	result := func(hits ...elasticResultHitRecord) *ElasticResult {
+		return &ElasticResult{Hits: &elasticResultHits{Hits: hits}}
+	}
+	sorted := ElasticJSON{Sort: []SortField{{Field: "timestamp", Order: OrderAscending}}}
+
+	tests := []struct {
+		name   string
+		ej     ElasticJSON
+		prev   *ScrollPosition
+		result *ElasticResult
+		want   *ScrollPosition
+	}{
+		{
+			name:   "unsorted",
+			prev:   &ScrollPosition{Offset: 10},
+			result: result(hit(), hit()),
+			want:   &ScrollPosition{Offset: 12},
+		},
+		{
+			name:   "empty-page",
+			ej:     sorted,
+			prev:   &ScrollPosition{Offset: 10},
+			result: result(),
+			want:   &ScrollPosition{Offset: 10},
+		},
+		{
+			name:   "ties",
+			ej:     sorted,
+			result: result(hit(ts.Add(-time.Second)), hit(ts), hit(ts)),
+			want:   &ScrollPosition{After: []sortValue{{JSONLiteral{Value: ts}}}, Ties: 2},
+		},
+		{
+			name:   "all-ties",
+			ej:     sorted,
+			prev:   &ScrollPosition{After: []sortValue{{JSONLiteral{Value: ts}}}, Ties: 2},
+			result: result(hit(ts), hit(ts)),
+			want:   &ScrollPosition{After: []sortValue{{JSONLiteral{Value: ts}}}, Ties: 4},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ej.ScrollPosition = tc.prev
+			got := tc.ej.NextScrollPosition(tc.result)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, expected %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScrollPositionRoundtrip(t *testing.T) {
+	pos := ScrollPosition{
+		After: []sortValue{
+			{JSONLiteral{Value: time.Date(2023, 1, 1, 12, 30, 0, 123456789, time.UTC)}},
+			{JSONLiteral{Value: "abc"}},
+			{JSONLiteral{Value: int64(42)}},
+		},
+		Ties: 3,
+	}
+	data, err := json.Marshal(&pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ScrollPosition
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, pos) {
+		t.Errorf("got %+v, expected %+v", got, pos)
+	}
+}
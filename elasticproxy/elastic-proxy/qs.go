@@ -150,7 +150,10 @@ func (e *qsExpression1) SetBoost(value float64) {
 }
 
 func (e *qsExpression1) Expression(qc *QueryContext, defaultFieldName qsFieldName) (expression, error) {
+	// negated terms don't contribute to the score
+	qc.unscored++
 	e1, err := e.Expr.Expression(qc, defaultFieldName)
+	qc.unscored--
 	if err != nil {
 		return nil, err
 	}
@@ -281,6 +284,13 @@ func (e *qsFieldExpression) Expression(qc *QueryContext, defaultFieldName qsFiel
 	case valueTypeText:
 		switch e.Operator {
 		case "=":
+			if _, wildcard := translateWildcard(e.Value); !wildcard {
+				boost := e.Boost
+				if boost == -1 {
+					boost = 1
+				}
+				qc.addScoreTerms(fn, e.Value, boost)
+			}
 			switch fn.Type() {
 			case "keyword":
 				if re, wildcard := translateWildcard(e.Value); wildcard {
@@ -381,7 +391,7 @@ func (e *qsFieldExpression) Expression(qc *QueryContext, defaultFieldName qsFiel
 		Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{value}},
 	}, nil
 
-	// FUZZY and BOOST are not used in our SQL translation
+	// FUZZY is not used in our SQL translation
 }
 
 func translateWildcard(in string) (string, bool) {
@@ -19,6 +19,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
@@ -45,27 +49,27 @@ type Query struct {
 	FunctionScore *notSupported  `json:"function_score"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-function-score-query.html
 
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/full-text-queries.html
-	Intervals         *notSupported     `json:"intervals"`           // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-intervals-query.html
-	Match             *match            `json:"match"`               // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query.html
-	MatchBoolPrefix   *map[string]field `json:"match_bool_prefix"`   // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-bool-prefix-query.html
-	MatchPhrase       *matchPhrase      `json:"match_phrase"`        // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query-phrase.html
-	MatchPhrasePrefix *map[string]field `json:"match_phrase_prefix"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query-phrase-prefix.html
-	CombinedFields    *map[string]field `json:"combined_fields"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-combined-fields-query.html
-	MultiMatch        *map[string]field `json:"multi_match"`         // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-multi-match-query.html
-	QueryString       *QueryString      `json:"query_string"`        // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-query-string-query.html
-	SimpleQueryString *notSupported     `json:"simple_query_string"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-simple-query-string-query.html
+	Intervals         *notSupported      `json:"intervals"`           // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-intervals-query.html
+	Match             *match             `json:"match"`               // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query.html
+	MatchBoolPrefix   *map[string]field  `json:"match_bool_prefix"`   // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-bool-prefix-query.html
+	MatchPhrase       *matchPhrase       `json:"match_phrase"`        // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query-phrase.html
+	MatchPhrasePrefix *map[string]field  `json:"match_phrase_prefix"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-match-query-phrase-prefix.html
+	CombinedFields    *map[string]field  `json:"combined_fields"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-combined-fields-query.html
+	MultiMatch        *multiMatch        `json:"multi_match"`         // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-multi-match-query.html
+	QueryString       *QueryString       `json:"query_string"`        // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-query-string-query.html
+	SimpleQueryString *SimpleQueryString `json:"simple_query_string"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-simple-query-string-query.html
 
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/geo-queries.html
 	GeoBoundingBox *geoBoundingBox `json:"geo_bounding_box"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-bounding-box-query.html
-	GeoDistance    *notSupported   `json:"geo_distance"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-distance-query.html
-	GeoPolygon     *notSupported   `json:"geo_polygon"`      // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-polygon-query.html
+	GeoDistance    *geoDistance    `json:"geo_distance"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-distance-query.html
+	GeoPolygon     *geoPolygon     `json:"geo_polygon"`      // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-polygon-query.html
 	GeoShape       *notSupported   `json:"geo_shape"`        // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-geo-shape-query.html
 
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/shape-queries.html
 	Shape *notSupported `json:"shape"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-shape-query.html
 
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/joining-queries.html
-	Nested    *notSupported `json:"nested"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-nested-query.html
+	Nested    *nested       `json:"nested"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-nested-query.html
 	HasChild  *notSupported `json:"has_child"`  // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-has-child-query.html
 	HasParent *notSupported `json:"has_parent"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-has-parent-query.html
 	ParentID  *notSupported `json:"parent_id"`  // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-parent-id-query.html
@@ -97,10 +101,10 @@ type Query struct {
 
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/term-level-queries.html
 	Exists   *exists       `json:"exists"`    // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-exists-query.html
-	Fuzzy    *notSupported `json:"fuzzy"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-fuzzy-query.html
-	Prefix   *notSupported `json:"prefix"`    // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-ids-query.html
+	Fuzzy    *fuzzy        `json:"fuzzy"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-fuzzy-query.html
+	Prefix   *prefix       `json:"prefix"`    // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-prefix-query.html
 	Range    *ranges       `json:"range"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-range-query.html
-	Regexp   *notSupported `json:"regexp"`    // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-regexp-query.html
+	Regexp   *regexpQuery  `json:"regexp"`    // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-regexp-query.html
 	Term     *term         `json:"term"`      // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-term-query.html
 	Terms    *terms        `json:"terms"`     // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-terms-query.html
 	TermsSet *notSupported `json:"terms_set"` // https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-terms-set-query.html
@@ -119,7 +123,9 @@ func (q *Query) Expression(qc *QueryContext) (expression, error) {
 
 	items := []expr{q.Bool, q.ConstantScore, q.MatchAll, q.MatchPhrase,
 		q.Match, q.MatchNone, q.Exists, q.Term, q.Terms, q.Range,
-		q.QueryString, q.GeoBoundingBox, q.Wildcard}
+		q.QueryString, q.SimpleQueryString, q.GeoBoundingBox, q.GeoDistance,
+		q.GeoPolygon, q.Wildcard, q.Prefix, q.Regexp, q.Fuzzy, q.Nested,
+		q.MultiMatch}
 
 	var exprs []expression
 	for _, item := range items {
@@ -207,6 +213,8 @@ type boolean struct {
 }
 
 func (b *boolean) Expression(qc *QueryContext) (expression, error) {
+	defer qc.boosted(b.Boost)()
+
 	var exprs []expression
 
 	if b.Must != nil {
@@ -220,7 +228,10 @@ func (b *boolean) Expression(qc *QueryContext) (expression, error) {
 	}
 
 	if b.Filter != nil {
+		// filters don't contribute to the score
+		qc.unscored++
 		e, err := b.Filter.Expression(qc)
+		qc.unscored--
 		if err != nil {
 			return nil, err
 		}
@@ -230,7 +241,15 @@ func (b *boolean) Expression(qc *QueryContext) (expression, error) {
 	}
 
 	if b.Should != nil {
+		// multiple clauses are OR-ed together
+		optional := len(*b.Should) > 1
+		if optional {
+			qc.optional++
+		}
 		e, err := b.Should.Expression(qc)
+		if optional {
+			qc.optional--
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -240,7 +259,11 @@ func (b *boolean) Expression(qc *QueryContext) (expression, error) {
 	}
 
 	if b.MustNot != nil {
+		qc.optional++
+		qc.unscored++
 		e, err := b.MustNot.Expression(qc)
+		qc.unscored--
+		qc.optional--
 		if err != nil {
 			return nil, err
 		}
@@ -263,6 +286,8 @@ type constantScore struct {
 
 func (cs *constantScore) Expression(qc *QueryContext) (expression, error) {
 	if cs.Filter != nil {
+		qc.unscored++
+		defer func() { qc.unscored-- }()
 		return cs.Filter.Expression(qc)
 	}
 	return nil, nil
@@ -273,17 +298,104 @@ type matchPhrase map[string]field
 func (m *matchPhrase) Expression(qc *QueryContext) (expression, error) {
 	var exprs []expression
 	for fieldName, mp := range *m {
+		fn := ParseExprFieldName(qc, fieldName)
 		e := &exprOperator2{
 			Context:  qc,
 			Operator: "=",
-			Expr1:    ParseExprFieldName(qc, fieldName),
+			Expr1:    fn,
 			Expr2:    &exprJSONLiteral{Context: qc, Value: mp.Query},
 		}
 		exprs = append(exprs, e)
+		if text, ok := mp.Query.Value.(string); ok {
+			qc.addScoreTerms(fn, text, mp.Boost.value())
+		}
 	}
 	return andExpressions(exprs), nil
 }
 
+// multiMatch matches the query against multiple
+// fields. The query is split into terms (unless it
+// is a phrase) that are matched like the terms in
+// a query string, so text fields match individual
+// words and keyword fields match the full value.
+type multiMatch struct {
+	Query              string       `json:"query"`
+	Fields             []string     `json:"fields"`
+	Type               string       `json:"type"`
+	Operator           Operator     `json:"operator"`
+	Analyzer           *string      `json:"analyzer"`
+	Fuzziness          *string      `json:"fuzziness"`
+	Lenient            *bool        `json:"lenient"`
+	MinimumShouldMatch *JSONLiteral `json:"minimum_should_match"`
+	TieBreaker         *float64     `json:"tie_breaker"`
+	Boost              *boostValue  `json:"boost"`
+}
+
+func (mm *multiMatch) Expression(qc *QueryContext) (expression, error) {
+	if len(mm.Fields) == 0 {
+		return nil, errors.New("multi_match requires 'fields'")
+	}
+	phrase := false
+	switch mm.Type {
+	case "", "best_fields", "most_fields", "cross_fields":
+	case "phrase":
+		phrase = true
+	default:
+		return nil, fmt.Errorf("multi_match type %q isn't supported", mm.Type)
+	}
+	if strings.TrimSpace(mm.Query) == "" {
+		// a query without any terms doesn't match anything
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{false}}, nil
+	}
+	operator := "OR"
+	if strings.EqualFold(string(mm.Operator), string(OperatorAnd)) {
+		operator = "AND"
+	}
+
+	defer qc.boosted(mm.Boost)()
+
+	var fieldExprs []expression
+	for _, f := range mm.Fields {
+		fieldName, boostText, hasBoost := strings.Cut(f, "^")
+		boost := float64(-1)
+		if hasBoost {
+			var err error
+			if boost, err = strconv.ParseFloat(boostText, 64); err != nil {
+				return nil, fmt.Errorf("invalid boost in multi_match field %q", f)
+			}
+		}
+
+		// keywords and phrases aren't split into terms
+		terms := []string{mm.Query}
+		switch ParseExprFieldName(qc, fieldName).Type() {
+		case "keyword", "keyword-ignore-case":
+		default:
+			if !phrase {
+				terms = strings.Fields(mm.Query)
+			}
+		}
+
+		var exprs []expression
+		for _, term := range terms {
+			qfe := qsFieldExpression{
+				FieldName: parseQSFieldName(fieldName),
+				Value:     term,
+				Type:      valueTypeText,
+				Operator:  "=",
+				Boost:     boost,
+				Fuzzy:     -1,
+			}
+			e, err := qfe.Expression(qc, qsFieldName{})
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, e)
+		}
+		fieldExprs = append(fieldExprs, joinExpressions(exprs, operator))
+	}
+	return joinExpressions(fieldExprs, "OR"), nil
+}
+
 type geoBoundingBox map[string]geoBounds
 
 func (gbb *geoBoundingBox) Expression(qc *QueryContext) (expression, error) {
@@ -306,16 +418,229 @@ func (gbb *geoBoundingBox) Expression(qc *QueryContext) (expression, error) {
 	return andExpressions(exprs), nil
 }
 
-type match map[string]JSONLiteral
+// geoDistance selects all geo-points that are
+// within the distance of the specified point
+type geoDistance struct {
+	Field            string
+	Point            geoPoint
+	Distance         float64 // in meters
+	DistanceType     string
+	ValidationMethod string
+	Name             string
+	Boost            *boostValue
+}
+
+func (gd *geoDistance) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		var err error
+		switch k {
+		case "distance":
+			var distance any
+			if err = json.Unmarshal(v, &distance); err == nil {
+				gd.Distance, err = parseDistance(distance)
+			}
+		case "distance_type":
+			err = json.Unmarshal(v, &gd.DistanceType)
+		case "validation_method":
+			err = json.Unmarshal(v, &gd.ValidationMethod)
+		case "_name":
+			err = json.Unmarshal(v, &gd.Name)
+		case "boost":
+			err = json.Unmarshal(v, &gd.Boost)
+		default:
+			if gd.Field != "" {
+				return errors.New("geo_distance supports only a single field")
+			}
+			gd.Field = k
+			err = json.Unmarshal(v, &gd.Point)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if gd.Field == "" {
+		return errors.New("geo_distance requires a field")
+	}
+	if _, ok := m["distance"]; !ok {
+		return errors.New("geo_distance requires a distance")
+	}
+	return nil
+}
+
+func (gd *geoDistance) Expression(qc *QueryContext) (expression, error) {
+	// GEO_DISTANCE always uses the (accurate)
+	// haversine formula, so the 'plane'
+	// distance type is treated as 'arc'
+	return &exprOperator2{
+		Context:  qc,
+		Operator: "<=",
+		Expr1: &exprFunction{
+			Context: qc,
+			Name:    "GEO_DISTANCE",
+			Exprs: []expression{
+				ParseExprFieldName(qc, gd.Field+LatExt),
+				ParseExprFieldName(qc, gd.Field+LonExt),
+				&exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: gd.Point.Lat}},
+				&exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: gd.Point.Lon}},
+			},
+		},
+		Expr2: &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: gd.Distance}},
+	}, nil
+}
+
+// geoPolygon selects all geo-points that are
+// inside the polygon (deprecated since 7.12)
+type geoPolygon struct {
+	Field            string
+	Points           []geoPoint
+	ValidationMethod string
+	Name             string
+}
+
+func (gp *geoPolygon) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		var err error
+		switch k {
+		case "validation_method":
+			err = json.Unmarshal(v, &gp.ValidationMethod)
+		case "_name":
+			err = json.Unmarshal(v, &gp.Name)
+		case "boost":
+		default:
+			if gp.Field != "" {
+				return errors.New("geo_polygon supports only a single field")
+			}
+			gp.Field = k
+			var polygon struct {
+				Points []geoPoint `json:"points"`
+			}
+			err = json.Unmarshal(v, &polygon)
+			gp.Points = polygon.Points
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if gp.Field == "" {
+		return errors.New("geo_polygon requires a field")
+	}
+	if len(gp.Points) < 3 {
+		return errors.New("geo_polygon requires at least 3 points")
+	}
+	return nil
+}
+
+// Expression uses the ray-casting algorithm to
+// determine if the point is inside the polygon.
+// A ray is cast from the point in the eastern
+// direction and if it crosses an odd number of
+// edges, then the point is inside the polygon.
+func (gp *geoPolygon) Expression(qc *QueryContext) (expression, error) {
+	lat := ParseExprFieldName(qc, gp.Field+LatExt)
+	long := ParseExprFieldName(qc, gp.Field+LonExt)
+	literal := func(v float64) expression {
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: v}}
+	}
+
+	var crossings expression
+	for i := range gp.Points {
+		p1, p2 := gp.Points[i], gp.Points[(i+1)%len(gp.Points)]
+		if p1.Lat == p2.Lat {
+			continue // horizontal edges are never crossed
+		}
+		minLat, maxLat := p1.Lat, p2.Lat
+		if minLat > maxLat {
+			minLat, maxLat = maxLat, minLat
+		}
+
+		// longitude of the edge at the latitude of the point
+		slope := (p2.Lon - p1.Lon) / (p2.Lat - p1.Lat)
+		edgeLon := &exprOperator2{
+			Context:  qc,
+			Operator: "+",
+			Expr1: &exprOperator2{
+				Context:  qc,
+				Operator: "*",
+				Expr1:    literal(slope),
+				Expr2:    &exprOperator2{Context: qc, Operator: "-", Expr1: lat, Expr2: literal(p1.Lat)},
+			},
+			Expr2: literal(p1.Lon),
+		}
+
+		crossing := &exprCase{
+			Context: qc,
+			Whens: []exprCaseWhen{{
+				When: andExpressions([]expression{
+					&exprOperator2{Context: qc, Operator: ">=", Expr1: lat, Expr2: literal(minLat)},
+					&exprOperator2{Context: qc, Operator: "<", Expr1: lat, Expr2: literal(maxLat)},
+					&exprOperator2{Context: qc, Operator: "<", Expr1: long, Expr2: edgeLon},
+				}),
+				Then: &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: 1}},
+			}},
+			Else: &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: 0}},
+		}
+		if crossings == nil {
+			crossings = crossing
+		} else {
+			crossings = &exprOperator2{Context: qc, Operator: "+", Expr1: crossings, Expr2: crossing}
+		}
+	}
+	if crossings == nil {
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{false}}, nil
+	}
+
+	return &exprOperator2{
+		Context:  qc,
+		Operator: "=",
+		Expr1: &exprOperator2{
+			Context:  qc,
+			Operator: "%",
+			Expr1:    crossings,
+			Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: 2}},
+		},
+		Expr2: &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: 1}},
+	}, nil
+}
+
+type match map[string]matchField
 
 func (m *match) Expression(qc *QueryContext) (expression, error) {
 	var exprs []expression
 	for field, value := range *m {
-		exprs = append(exprs, fieldEquals(field, value, qc))
+		exprs = append(exprs, fieldEquals(field, value.JSONLiteral, qc))
+		if text, ok := value.Value.(string); ok {
+			qc.addScoreTerms(ParseExprFieldName(qc, field), text, value.Boost.value())
+		}
 	}
 	return andExpressions(exprs), nil
 }
 
+// matchField is the value of a match query, which
+// is either the value itself or an object with the
+// query and its options
+type matchField struct {
+	JSONLiteral
+	Boost *boostValue
+}
+
+func (mf *matchField) UnmarshalJSON(data []byte) error {
+	var f field
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	mf.JSONLiteral = f.Query
+	mf.Boost = f.Boost
+	return nil
+}
+
 type term struct {
 	Field           string
 	Value           JSONLiteral `json:"value"`
@@ -448,6 +773,256 @@ func (w wildCard) Expression(qc *QueryContext) (expression, error) {
 	return andExpressions(exprs), nil
 }
 
+// singleFieldQuery splits a query that applies
+// to a single field (i.e. {"user.id": {...}})
+// into the field-name and its parameters.
+func singleFieldQuery(data []byte) (string, json.RawMessage, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", nil, err
+	}
+	if len(m) != 1 {
+		return "", nil, ErrTermOnlySingleField
+	}
+	for f, params := range m {
+		return f, params, nil
+	}
+	panic("unreachable")
+}
+
+// prefixExpression returns an expression that
+// checks if the field starts with the prefix.
+func prefixExpression(qc *QueryContext, field, prefix string, caseInsensitive bool) expression {
+	if strings.ContainsAny(prefix, `%_`) {
+		// LIKE doesn't support escaping, so
+		// fallback to a regular expression
+		re := "^" + regexp.QuoteMeta(prefix)
+		if caseInsensitive {
+			re = "(?i)" + re
+		}
+		return &exprOperator2{
+			Context:  qc,
+			Operator: "~",
+			Expr1:    ParseExprFieldName(qc, field),
+			Expr2:    &exprJSONLiteral{Context: qc, Value: regexLiteral(re)},
+		}
+	}
+	operator := "LIKE"
+	if caseInsensitive {
+		operator = "ILIKE"
+	}
+	return &exprOperator2{
+		Context:  qc,
+		Operator: operator,
+		Expr1:    ParseExprFieldName(qc, field),
+		Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: prefix + "%"}},
+	}
+}
+
+// regexLiteral returns the literal for a regular
+// expression. SQL strings use the backslash as
+// the escape character, so it needs to be escaped
+// to pass escaped characters to the regex engine.
+func regexLiteral(re string) JSONLiteral {
+	return JSONLiteral{Value: strings.ReplaceAll(re, `\`, `\\`)}
+}
+
+type prefix struct {
+	Field           string
+	Value           string  `json:"value"`
+	Rewrite         *string `json:"rewrite"`
+	CaseInsensitive bool    `json:"case_insensitive"`
+}
+
+func (p *prefix) UnmarshalJSON(data []byte) error {
+	field, params, err := singleFieldQuery(data)
+	if err != nil {
+		return err
+	}
+	p.Field = field
+	if params[0] != '{' {
+		return json.Unmarshal(params, &p.Value)
+	}
+	type _prefix prefix
+	return json.Unmarshal(params, (*_prefix)(p))
+}
+
+func (p *prefix) Expression(qc *QueryContext) (expression, error) {
+	return prefixExpression(qc, p.Field, p.Value, p.CaseInsensitive), nil
+}
+
+type regexpQuery struct {
+	Field                 string
+	Value                 string      `json:"value"`
+	Flags                 string      `json:"flags"`
+	CaseInsensitive       bool        `json:"case_insensitive"`
+	MaxDeterminizedStates *int        `json:"max_determinized_states"`
+	Rewrite               *string     `json:"rewrite"`
+	Boost                 *boostValue `json:"boost"`
+}
+
+func (r *regexpQuery) UnmarshalJSON(data []byte) error {
+	field, params, err := singleFieldQuery(data)
+	if err != nil {
+		return err
+	}
+	r.Field = field
+	if params[0] != '{' {
+		return json.Unmarshal(params, &r.Value)
+	}
+	type _regexpQuery regexpQuery
+	return json.Unmarshal(params, (*_regexpQuery)(r))
+}
+
+func (r *regexpQuery) Expression(qc *QueryContext) (expression, error) {
+	flags, err := parseLuceneRegexFlags(r.Flags)
+	if err != nil {
+		return nil, err
+	}
+	re, err := translateLuceneRegex(r.Value, flags, r.CaseInsensitive)
+	if err != nil {
+		return nil, err
+	}
+	return &exprOperator2{
+		Context:  qc,
+		Operator: "~",
+		Expr1:    ParseExprFieldName(qc, r.Field),
+		Expr2:    &exprJSONLiteral{Context: qc, Value: regexLiteral(re)},
+	}, nil
+}
+
+// fuzziness is the maximum edit distance
+// (https://www.elastic.co/guide/en/elasticsearch/reference/current/common-options.html#fuzziness)
+type fuzziness struct {
+	Distance        int  // fixed edit distance
+	Auto            bool // edit distance depends on the term length
+	AutoLow, AutoHi int
+}
+
+func (f *fuzziness) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		f.Distance = int(v)
+	case string:
+		text := strings.TrimSpace(v)
+		if !strings.HasPrefix(text, "AUTO") {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				return fmt.Errorf("invalid fuzziness %q", v)
+			}
+			f.Distance = n
+			break
+		}
+		f.Auto, f.AutoLow, f.AutoHi = true, 3, 6
+		if bounds, ok := strings.CutPrefix(text, "AUTO:"); ok {
+			lo, hi, ok := strings.Cut(bounds, ",")
+			if !ok {
+				return fmt.Errorf("invalid fuzziness %q", v)
+			}
+			var err1, err2 error
+			f.AutoLow, err1 = strconv.Atoi(lo)
+			f.AutoHi, err2 = strconv.Atoi(hi)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("invalid fuzziness %q", v)
+			}
+		} else if text != "AUTO" {
+			return fmt.Errorf("invalid fuzziness %q", v)
+		}
+	default:
+		return fmt.Errorf("invalid fuzziness %s", string(data))
+	}
+	if f.Distance < 0 || f.Distance > 2 {
+		return fmt.Errorf("fuzziness should be 0, 1, 2 or AUTO")
+	}
+	return nil
+}
+
+// edits returns the maximum number of
+// edits that are allowed for the term
+func (f *fuzziness) edits(term string) int {
+	if !f.Auto {
+		return f.Distance
+	}
+	n := utf8.RuneCountInString(term)
+	switch {
+	case n < f.AutoLow:
+		return 0
+	case n < f.AutoHi:
+		return 1
+	default:
+		return 2
+	}
+}
+
+type fuzzy struct {
+	Field          string
+	Value          string      `json:"value"`
+	Fuzziness      *fuzziness  `json:"fuzziness"`
+	MaxExpansions  *int        `json:"max_expansions"`
+	PrefixLength   int         `json:"prefix_length"`
+	Transpositions *bool       `json:"transpositions"`
+	Rewrite        *string     `json:"rewrite"`
+	Boost          *boostValue `json:"boost"`
+}
+
+func (f *fuzzy) UnmarshalJSON(data []byte) error {
+	field, params, err := singleFieldQuery(data)
+	if err != nil {
+		return err
+	}
+	f.Field = field
+	if params[0] != '{' {
+		return json.Unmarshal(params, &f.Value)
+	}
+	type _fuzzy fuzzy
+	return json.Unmarshal(params, (*_fuzzy)(f))
+}
+
+func (f *fuzzy) Expression(qc *QueryContext) (expression, error) {
+	var edits int
+	if f.Fuzziness != nil {
+		edits = f.Fuzziness.edits(f.Value)
+	} else {
+		// default is AUTO
+		auto := fuzziness{Auto: true, AutoLow: 3, AutoHi: 6}
+		edits = auto.edits(f.Value)
+	}
+	if edits == 0 {
+		return fieldEquals(f.Field, JSONLiteral{Value: f.Value}, qc), nil
+	}
+
+	name := "EQUALS_FUZZY"
+	for _, r := range f.Value {
+		if r >= utf8.RuneSelf {
+			name = "EQUALS_FUZZY_UNICODE"
+			break
+		}
+	}
+	var exprs []expression
+	if f.PrefixLength > 0 {
+		// the prefix should match exactly
+		runes := []rune(f.Value)
+		if f.PrefixLength < len(runes) {
+			runes = runes[:f.PrefixLength]
+		}
+		exprs = append(exprs, prefixExpression(qc, f.Field, string(runes), false))
+	}
+	exprs = append(exprs, &exprFunction{
+		Context: qc,
+		Name:    name,
+		Exprs: []expression{
+			ParseExprFieldName(qc, f.Field),
+			&exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: f.Value}},
+			&exprJSONLiteral{Context: qc, Value: JSONLiteral{Value: edits}},
+		},
+	})
+	return andExpressions(exprs), nil
+}
+
 type QueryString struct {
 	Query                           string      `json:"query"`
 	DefaultField                    *string     `json:"default_field"`
@@ -474,6 +1049,8 @@ type QueryString struct {
 }
 
 func (qs *QueryString) Expression(qc *QueryContext) (expression, error) {
+	defer qc.boosted(qs.Boost)()
+
 	lex := newQueryStringLexer([]byte(qs.Query))
 	lex.defaultOperator = "OR"
 	if qs.DefaultOperator != nil {
@@ -627,6 +1204,7 @@ type field struct {
 	Fields                          *[]string       `json:"fields"`
 	Type                            *string         `json:"type"`
 	TieBreaker                      *float64        `json:"tie_breaker"`
+	Boost                           *boostValue     `json:"boost"`
 }
 
 func (f *field) UnmarshalJSON(data []byte) error {
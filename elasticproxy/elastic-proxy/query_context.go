@@ -22,6 +22,37 @@ type QueryContext struct {
 	IgnoreTotalHits        bool
 	IgnoreSumOtherDocCount bool
 	TypeMapping            map[string]TypeMapping
+
+	// nested holds the nested paths (innermost
+	// last) that fields are resolved against
+	nested []nestedScope
+	// docKey (if set) is used to count the
+	// documents instead of the nested rows
+	docKey expression
+	// nestedKeys collects the arrays that identify
+	// a document when the query unnests them
+	// (only set while translating the search query)
+	nestedKeys *[]expression
+	// optional is non-zero when translating
+	// 'should' or 'must_not' clauses
+	optional int
+	// scoring collects the terms that contribute
+	// to the relevance score of the hits (only set
+	// while translating the search query)
+	scoring *[]scoreTerm
+	// scoreBoost is the boost that is applied to
+	// the terms that are added to the score
+	scoreBoost float64
+	// unscored is non-zero when translating clauses
+	// that don't contribute to the score (i.e.
+	// 'filter' or 'must_not')
+	unscored int
+	// score holds the relevance score of the
+	// hits (nil when the hits aren't scored)
+	score expression
+	// highlightTerms holds the predicates of the
+	// search query that are highlighted in the hits
+	highlightTerms []highlightTerm
 }
 
 type TableSource struct {
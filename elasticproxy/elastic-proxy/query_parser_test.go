@@ -225,3 +225,38 @@ func TestUnmarshalTerms(t *testing.T) {
 		t.Fatal("expected boost 2.2")
 	}
 }
+
+func TestUnmarshalFuzziness(t *testing.T) {
+	type testCase struct {
+		fuzziness string
+		term      string
+		edits     int
+	}
+	testCases := []testCase{
+		{`1`, "kimchy", 1},
+		{`"2"`, "ab", 2},
+		{`"AUTO"`, "ab", 0},
+		{`"AUTO"`, "abc", 1},
+		{`"AUTO"`, "abcde", 1},
+		{`"AUTO"`, "abcdef", 2},
+		{`"AUTO:2,4"`, "ab", 1},
+		{`"AUTO:2,4"`, "abcd", 2},
+		{`"AUTO"`, "zürich", 2},
+	}
+	for _, tc := range testCases {
+		var f fuzziness
+		if err := json.Unmarshal([]byte(tc.fuzziness), &f); err != nil {
+			t.Fatalf("can't unmarshal %s: %v", tc.fuzziness, err)
+		}
+		if edits := f.edits(tc.term); edits != tc.edits {
+			t.Errorf("fuzziness %s for %q: expected %d edits, got %d", tc.fuzziness, tc.term, tc.edits, edits)
+		}
+	}
+
+	for _, invalid := range []string{`3`, `"AUTO:2"`, `"AUTOMATIC"`, `true`} {
+		var f fuzziness
+		if err := json.Unmarshal([]byte(invalid), &f); err == nil {
+			t.Errorf("expected fuzziness %s to be rejected", invalid)
+		}
+	}
+}
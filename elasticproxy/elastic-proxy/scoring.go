@@ -0,0 +1,250 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters (same defaults as Elastic)
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// maxTermFrequency limits the number of term
+// occurrences that are counted (each occurrence
+// requires an additional regular expression)
+const maxTermFrequency = 3
+
+// scoreTerm is a (search) term that contributes
+// to the relevance score of a hit
+type scoreTerm struct {
+	Field *exprFieldName
+	Term  string
+	Boost float64
+}
+
+// isKeyword returns true when the term should match
+// the full (non-analyzed) value of the field
+func (t *scoreTerm) isKeyword() bool {
+	switch t.Field.Type() {
+	case "keyword", "keyword-ignore-case":
+		return true
+	}
+	return false
+}
+
+// occurrences returns the expression that checks
+// if the term occurs (at least) n times in the field
+func (t *scoreTerm) occurrences(n int) expression {
+	qc := t.Field.Context
+	switch t.Field.Type() {
+	case "keyword":
+		return &exprOperator2{
+			Context:  qc,
+			Operator: "=",
+			Expr1:    t.Field,
+			Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{t.Term}},
+		}
+	case "keyword-ignore-case":
+		return &exprOperator2{
+			Context:  qc,
+			Operator: "=",
+			Expr1:    &exprFunction{Context: qc, Name: "LOWER", Exprs: []expression{t.Field}},
+			Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{strings.ToLower(t.Term)}},
+		}
+	}
+
+	const sep = `[^[:alnum:]]`
+	term := regexp.QuoteMeta(t.Term)
+	re := `(?i)(^|` + sep + `)` + term
+	if n > 1 {
+		re += fmt.Sprintf(`(%s(.*%s)?%s){%d}`, sep, sep, term, n-1)
+	}
+	re += `(` + sep + `|$)`
+	return &exprOperator2{
+		Context:  qc,
+		Operator: "~",
+		Expr1:    t.Field,
+		Expr2:    &exprJSONLiteral{Context: qc, Value: JSONLiteral{re}},
+	}
+}
+
+// addScoreTerms adds the terms in the text that is
+// matched against the field to the relevance score.
+// Text fields are tokenized, but keyword fields
+// are scored using the full text.
+func (qc *QueryContext) addScoreTerms(fn *exprFieldName, text string, boost float64) {
+	if qc.scoring == nil || qc.unscored > 0 || len(qc.nested) > 0 {
+		return
+	}
+	t := scoreTerm{Field: fn, Boost: boost * qc.scoreBoost}
+	if t.isKeyword() {
+		t.Term = text
+		*qc.scoring = append(*qc.scoring, t)
+		return
+	}
+	terms := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, term := range terms {
+		t.Term = term
+		*qc.scoring = append(*qc.scoring, t)
+	}
+}
+
+// boosted multiplies the boost of the score terms
+// with the given boost and returns the function
+// that restores the previous boost
+func (qc *QueryContext) boosted(boost *boostValue) func() {
+	prev := qc.scoreBoost
+	qc.scoreBoost *= boost.value()
+	return func() { qc.scoreBoost = prev }
+}
+
+// value returns the boost (1 if not set) without
+// the rounding errors of the float32 representation
+func (b *boostValue) value() float64 {
+	if b == nil {
+		return 1
+	}
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(*b), 'g', -1, 32), 64)
+	return v
+}
+
+// exprStatistic refers to a collection statistic
+// that is determined by the statistics query
+type exprStatistic struct {
+	Context *QueryContext
+	Name    string
+}
+
+func (e *exprStatistic) QueryContext() *QueryContext {
+	return e.Context
+}
+
+func (e *exprStatistic) Print(pc *printContext) {
+	pc.WriteString(fmt.Sprintf("(SELECT %q FROM %q)", e.Name, StatsSource))
+}
+
+// scoreExpressions returns the query that determines
+// the collection statistics (based on all documents
+// in the tables) and the expression that calculates
+// the BM25 relevance score of a document.
+//
+// The length of a text field is approximated by its
+// number of characters and the term frequency is
+// limited to maxTermFrequency.
+func scoreExpressions(qc *QueryContext, terms []scoreTerm, from expression) (*exprSelect, expression) {
+	number := func(v float64) expression {
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{v}}
+	}
+	op := func(operator string, e1, e2 expression) expression {
+		return &exprOperator2{Context: qc, Operator: operator, Expr1: e1, Expr2: e2}
+	}
+
+	stats := &exprSelect{
+		Context: qc,
+		Projection: []projectAliasExpr{{
+			Context:    qc,
+			Alias:      DocCount,
+			expression: &exprFunction{Context: qc, Name: "COUNT", Exprs: []expression{&exprFieldName{Context: qc}}},
+		}},
+		From: []expression{from},
+	}
+	// statistics are shared by terms with
+	// the same match expression or field
+	ids := make(map[string]int)
+	statistic := func(name, key string, e expression) *exprStatistic {
+		id, ok := ids[key]
+		if !ok {
+			id = len(ids)
+			ids[key] = id
+		}
+		alias := fmt.Sprintf("%s:%d", name, id)
+		if !slices.ContainsFunc(stats.Projection, func(p projectAliasExpr) bool { return p.Alias == alias }) {
+			stats.Projection = append(stats.Projection, projectAliasExpr{Context: qc, Alias: alias, expression: e})
+		}
+		return &exprStatistic{Context: qc, Name: alias}
+	}
+	docCount := &exprStatistic{Context: qc, Name: DocCount}
+
+	var score expression
+	for i := range terms {
+		t := &terms[i]
+		match := t.occurrences(1)
+
+		// idf = ln(1 + (N - df + 0.5) / (df + 0.5))
+		df := statistic("$df", PrintExpr(match), &exprFunction{
+			Context: qc,
+			Name:    "COUNT",
+			Exprs:   []expression{&exprFieldName{Context: qc}},
+			Filter:  match,
+		})
+		var idf expression = &exprFunction{
+			Context: qc,
+			Name:    "LN",
+			Exprs: []expression{op("+", number(1), op("/",
+				op("+", op("-", docCount, df), number(0.5)),
+				op("+", df, number(0.5))))},
+		}
+		if t.Boost != 1 {
+			idf = op("*", number(t.Boost), idf)
+		}
+
+		// keywords aren't normalized and always have
+		// a term frequency of 1, so the score is idf
+		termScore := &exprCase{Context: qc, Else: number(0)}
+		if t.isKeyword() {
+			termScore.Whens = []exprCaseWhen{{When: match, Then: idf}}
+		} else {
+			// dl / avgdl = length * count / sum(length)
+			field := PrintExpr(t.Field)
+			length := &exprFunction{Context: qc, Name: "CHAR_LENGTH", Exprs: []expression{t.Field}}
+			sumLength := statistic("$length", field, &exprFunction{Context: qc, Name: "SUM", Exprs: []expression{length}})
+			count := statistic("$count", field, &exprFunction{Context: qc, Name: "COUNT", Exprs: []expression{t.Field}})
+			norm := op("*", number(bm25K1), op("+", number(1-bm25B),
+				op("/", op("*", op("*", number(bm25B), length), count), sumLength)))
+
+			// idf * (k1 + 1) * tf / (tf + k1 * (1 - b + b * dl / avgdl))
+			// (using the highest term frequency that matches)
+			for tf := maxTermFrequency; tf >= 1; tf-- {
+				when := match
+				if tf > 1 {
+					when = t.occurrences(tf)
+				}
+				termScore.Whens = append(termScore.Whens, exprCaseWhen{
+					When: when,
+					Then: op("*", op("*", idf, number(bm25K1+1)),
+						op("/", number(float64(tf)), op("+", number(float64(tf)), norm))),
+				})
+			}
+		}
+
+		if score == nil {
+			score = termScore
+		} else {
+			score = op("+", score, termScore)
+		}
+	}
+
+	return stats, score
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestScoring(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		contains []string
+		missing  []string
+		err      string
+	}{
+		{
+			name:  "match",
+			input: `{"query":{"match":{"title":"quick fox"}}}`,
+			contains: []string{
+				`"$stats" AS (SELECT COUNT(*) AS "$doc_count"`,
+				`COUNT(*) FILTER (WHERE ("$source"."title" ~ '(?i)(^|[^[:alnum:]])quick([^[:alnum:]]|$)')) AS "$df:0"`,
+				`SUM(CHAR_LENGTH("$source"."title")) AS "$length:1"`,
+				`COUNT("$source"."title") AS "$count:1"`,
+				`COUNT(*) FILTER (WHERE ("$source"."title" ~ '(?i)(^|[^[:alnum:]])fox([^[:alnum:]]|$)')) AS "$df:2"`,
+				`("$source"."title" ~ '(?i)(^|[^[:alnum:]])quick([^[:alnum:]](.*[^[:alnum:]])?quick){2}([^[:alnum:]]|$)')`,
+				`ELSE 0 END) DESC LIMIT 10`,
+				`AS "$score" FROM "$source" ORDER BY`,
+				`AS "$scores"`,
+			},
+		},
+		{
+			name:  "boost",
+			input: `{"query":{"match":{"title":{"query":"fox","boost":2}}}}`,
+			contains: []string{
+				`(2 * LN(`,
+			},
+		},
+		{
+			name:  "nested-boost",
+			input: `{"query":{"bool":{"boost":2,"must":{"query_string":{"query":"title:fox^1.5"}}}}}`,
+			contains: []string{
+				`(3 * LN(`,
+			},
+		},
+		{
+			name:  "keyword",
+			input: `{"query":{"match":{"tag":"Big Cat"}}}`,
+			contains: []string{
+				`COUNT(*) FILTER (WHERE ("$source"."tag" = 'Big Cat')) AS "$df:0"`,
+				`CASE WHEN ("$source"."tag" = 'Big Cat') THEN LN(`,
+			},
+			missing: []string{
+				`CHAR_LENGTH`,
+			},
+		},
+		{
+			name:  "multi-match",
+			input: `{"query":{"multi_match":{"query":"quick fox","fields":["title^3","tag"]}}}`,
+			contains: []string{
+				`("$source"."title" ~ '(^|[ \t])(?i)quick([ \t]|$)')`,
+				`("$source"."tag" = 'quick fox')`,
+				`(3 * LN(`,
+				`CASE WHEN ("$source"."tag" = 'quick fox') THEN LN(`,
+			},
+		},
+		{
+			name:  "sort-by-score",
+			input: `{"sort":["_score",{"timestamp":{"order":"asc"}}],"query":{"match_phrase":{"title":"quick fox"}}}`,
+			contains: []string{
+				`ELSE 0 END) DESC, "$source"."timestamp" ASC`,
+				`AS "$scores"`,
+			},
+		},
+		{
+			name:  "search-after-score",
+			input: `{"sort":["_score"],"search_after":[1.5],"query":{"match":{"title":"fox"}}}`,
+			contains: []string{
+				`ELSE 0 END < 1.5)`,
+			},
+		},
+		{
+			name:  "sort-by-field",
+			input: `{"sort":[{"timestamp":{"order":"asc"}}],"query":{"match":{"title":"fox"}}}`,
+			missing: []string{
+				`"$stats"`,
+				`"$scores"`,
+			},
+		},
+		{
+			name:  "filter",
+			input: `{"query":{"bool":{"filter":{"match":{"title":"fox"}},"must_not":{"match":{"title":"dog"}}}}}`,
+			missing: []string{
+				`"$stats"`,
+			},
+		},
+		{
+			name:  "negated-query-string",
+			input: `{"query":{"query_string":{"query":"title:quick AND NOT title:fox"}}}`,
+			contains: []string{
+				`(?i)(^|[^[:alnum:]])quick([^[:alnum:]]|$)`,
+			},
+			missing: []string{
+				`(?i)(^|[^[:alnum:]])fox([^[:alnum:]]|$)`,
+			},
+		},
+		{
+			name:  "without-hits",
+			input: `{"size":0,"query":{"match":{"title":"fox"}}}`,
+			missing: []string{
+				`"$stats"`,
+			},
+		},
+		{
+			name:  "multi-match-without-fields",
+			input: `{"query":{"multi_match":{"query":"fox"}}}`,
+			err:   "multi_match requires 'fields'",
+		},
+		{
+			name:  "multi-match-unsupported-type",
+			input: `{"query":{"multi_match":{"query":"fox","fields":["title"],"type":"bool_prefix"}}}`,
+			err:   `multi_match type "bool_prefix" isn't supported`,
+		},
+	}
+
+	for i := range tests {
+		test := &tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			var ej ElasticJSON
+			if err := json.Unmarshal([]byte(test.input), &ej); err != nil {
+				t.Fatalf("can't unmarshal %q: %v", test.input, err)
+			}
+			qc := QueryContext{
+				Query:        ej,
+				TableSources: []TableSource{{Table: "table"}},
+				TypeMapping: map[string]TypeMapping{
+					"tag": {Type: "keyword"},
+				},
+			}
+			sql, err := ej.SQL(&qc)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := PrintExpr(sql)
+			for _, c := range test.contains {
+				if !strings.Contains(got, c) {
+					t.Errorf("expected %s in %s", c, got)
+				}
+			}
+			for _, c := range test.missing {
+				if strings.Contains(got, c) {
+					t.Errorf("didn't expect %s in %s", c, got)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreResult(t *testing.T) {
+	var ej ElasticJSON
+	input := `{"sort":["_score"],"query":{"match":{"title":"fox"}}}`
+	if err := json.Unmarshal([]byte(input), &ej); err != nil {
+		t.Fatal(err)
+	}
+	qc := QueryContext{Query: ej}
+	result, _, err := ej.ConvertResult(&qc, map[string]any{
+		TotalCountBucket: 2,
+		HitsBucket: []any{
+			map[string]any{"title": "fox fox"},
+			map[string]any{"title": "a quick fox"},
+		},
+		ScoresBucket: []any{
+			map[string]any{ScoreField: 1.5},
+			map[string]any{ScoreField: 0.75},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits := result.Hits.Hits
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 hits, got %d", len(hits))
+	}
+	for i, want := range []float64{1.5, 0.75} {
+		if hits[i].Score == nil || *hits[i].Score != want {
+			t.Errorf("hit %d: expected score %v, got %v", i, want, hits[i].Score)
+		}
+		if len(hits[i].Sort) != 1 || hits[i].Sort[0] != want {
+			t.Errorf("hit %d: expected sort value %v, got %v", i, want, hits[i].Sort)
+		}
+	}
+	if result.Hits.MaxScore == nil || *result.Hits.MaxScore != 1.5 {
+		t.Errorf("expected max score 1.5, got %v", result.Hits.MaxScore)
+	}
+
+	_, _, err = ej.ConvertResult(&qc, map[string]any{
+		TotalCountBucket: 1,
+		HitsBucket:       []any{map[string]any{"title": "fox"}},
+		ScoresBucket:     []any{},
+	})
+	if err == nil {
+		t.Fatal("expected an error when scores are missing")
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/query-dsl-simple-query-string-query.html
+type SimpleQueryString struct {
+	Query                           string      `json:"query"`
+	Fields                          *[]string   `json:"fields"`
+	DefaultOperator                 *string     `json:"default_operator"`
+	AllFields                       *bool       `json:"all_fields"`
+	AnalyzeWildcard                 *bool       `json:"analyze_wildcard"`
+	Analyzer                        *string     `json:"analyzer"`
+	AutoGenerateSynonymsPhraseQuery *bool       `json:"auto_generate_synonyms_phrase_query"`
+	Boost                           *boostValue `json:"boost"`
+	Flags                           *string     `json:"flags"` // TODO
+	FuzzyMaxExpansions              *int        `json:"fuzzy_max_expansions"`
+	FuzzyPrefixLength               *int        `json:"fuzzy_prefix_length"`
+	FuzzyTranspositions             *bool       `json:"fuzzy_transpositions"`
+	Lenient                         *bool       `json:"lenient"`
+	MinimumShouldMatch              *string     `json:"minimum_should_match"`
+	QuoteFieldSuffix                *string     `json:"quote_field_suffix"`
+}
+
+func (sqs *SimpleQueryString) Expression(qc *QueryContext) (expression, error) {
+	defer qc.boosted(sqs.Boost)()
+
+	var fields []qsFieldName
+	if sqs.Fields != nil {
+		for _, f := range *sqs.Fields {
+			// boosting is not used in our SQL translation
+			f, _, _ = strings.Cut(f, "^")
+			fields = append(fields, parseQSFieldName(f))
+		}
+	}
+	if len(fields) == 0 {
+		fields = append(fields, parseQSFieldName(""))
+	}
+
+	p := simpleQueryParser{
+		input:           []rune(sqs.Query),
+		defaultOperator: "OR",
+		fields:          fields,
+	}
+	if sqs.DefaultOperator != nil {
+		p.defaultOperator = strings.ToUpper(*sqs.DefaultOperator)
+	}
+	if p.defaultOperator != "AND" && p.defaultOperator != "OR" {
+		return nil, errors.New("default_operator should be either AND or OR")
+	}
+
+	qsExpression, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if qsExpression == nil {
+		// an empty query doesn't match anything
+		return &exprJSONLiteral{Context: qc, Value: JSONLiteral{false}}, nil
+	}
+	return qsExpression.Expression(qc, fields[0])
+}
+
+// simpleQueryParser parses the simple query
+// string syntax. Unlike the query string
+// syntax, it never fails on invalid input,
+// but it tries to make the most out of it.
+//
+// The operators are evaluated from left to
+// right without precedence (like Lucene's
+// SimpleQueryParser does).
+type simpleQueryParser struct {
+	input           []rune
+	pos             int
+	defaultOperator string
+	fields          []qsFieldName
+}
+
+func (p *simpleQueryParser) parse() (qsExpression, error) {
+	return p.parseExpr(false)
+}
+
+func (p *simpleQueryParser) parseExpr(nested bool) (qsExpression, error) {
+	var result qsExpression
+	operator := ""
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			// unbalanced parentheses are ignored
+			return result, nil
+		}
+		switch p.input[p.pos] {
+		case ')':
+			p.pos++
+			if nested {
+				return result, nil
+			}
+			continue
+		case '+':
+			p.pos++
+			operator = "AND"
+			continue
+		case '|':
+			p.pos++
+			operator = "OR"
+			continue
+		}
+
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			continue
+		}
+		if result == nil {
+			result = e
+		} else {
+			if operator == "" {
+				operator = p.defaultOperator
+			}
+			result = &qsExpression2{
+				Operator: operator,
+				Expr1:    result,
+				Expr2:    e,
+			}
+		}
+		operator = ""
+	}
+}
+
+func (p *simpleQueryParser) parseUnary() (qsExpression, error) {
+	switch p.input[p.pos] {
+	case '-':
+		p.pos++
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, nil
+		}
+		e, err := p.parseUnary()
+		if err != nil || e == nil {
+			return nil, err
+		}
+		return &qsExpression1{Operator: "NOT", Expr: e}, nil
+	case '(':
+		p.pos++
+		return p.parseExpr(true)
+	case '"':
+		return p.parsePhrase(), nil
+	}
+	return p.parseTerm(), nil
+}
+
+// parsePhrase parses a quoted phrase (the
+// optional slop (~N) is ignored)
+func (p *simpleQueryParser) parsePhrase() qsExpression {
+	p.pos++ // skip opening quote
+	var sb strings.Builder
+	for p.pos < len(p.input) && p.input[p.pos] != '"' {
+		r := p.input[p.pos]
+		if r == '\\' && p.pos+1 < len(p.input) {
+			p.pos++
+			r = p.input[p.pos]
+		}
+		if r == '*' || r == '?' || r == '\\' {
+			// phrases don't support wildcards
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+		p.pos++
+	}
+	p.pos++ // skip closing quote
+	p.parseFuzziness()
+	return p.fieldExpression(sb.String(), valueTypeText, -1)
+}
+
+func (p *simpleQueryParser) parseTerm() qsExpression {
+	var sb strings.Builder
+	for p.pos < len(p.input) {
+		r := p.input[p.pos]
+		if unicode.IsSpace(r) || strings.ContainsRune(`+|()"~`, r) {
+			break
+		}
+		p.pos++
+		switch r {
+		case '\\':
+			if p.pos < len(p.input) {
+				sb.WriteRune('\\')
+				sb.WriteRune(p.input[p.pos])
+				p.pos++
+			}
+		case '*':
+			// only a trailing asterisk is a wildcard
+			if p.pos < len(p.input) && !unicode.IsSpace(p.input[p.pos]) && !strings.ContainsRune(`+|()"~`, p.input[p.pos]) {
+				sb.WriteRune('\\')
+			}
+			sb.WriteRune(r)
+		case '?':
+			sb.WriteString(`\?`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	fuzzy := p.parseFuzziness()
+
+	value := sb.String()
+	if value == "" {
+		return nil
+	}
+	valueType := valueTypeText
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		valueType = valueTypeInt
+	} else if _, err := strconv.ParseFloat(value, 64); err == nil {
+		valueType = valueTypeFloat
+	}
+	return p.fieldExpression(value, valueType, fuzzy)
+}
+
+// parseFuzziness parses the optional fuzziness
+// (~N) of a term or the slop of a phrase
+func (p *simpleQueryParser) parseFuzziness() float64 {
+	if p.pos >= len(p.input) || p.input[p.pos] != '~' {
+		return -1
+	}
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+		p.pos++
+	}
+	n, err := strconv.Atoi(string(p.input[start:p.pos]))
+	if err != nil {
+		return 2 // Elastic's default edit distance
+	}
+	return float64(n)
+}
+
+// fieldExpression returns the expression that
+// matches the value against any of the fields
+func (p *simpleQueryParser) fieldExpression(value string, valueType valueType, fuzzy float64) qsExpression {
+	var result qsExpression
+	for _, f := range p.fields {
+		e := &qsFieldExpression{
+			FieldName: f,
+			Value:     value,
+			Type:      valueType,
+			Operator:  "=",
+			Boost:     -1,
+			Fuzzy:     fuzzy,
+		}
+		if result == nil {
+			result = e
+		} else {
+			result = &qsExpression2{
+				Operator: "OR",
+				Expr1:    result,
+				Expr2:    e,
+			}
+		}
+	}
+	return result
+}
+
+func (p *simpleQueryParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
@@ -0,0 +1,805 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SnellerInc/sneller/date"
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+)
+
+// SQLCommand is the kind of an Elasticsearch SQL statement
+type SQLCommand int
+
+const (
+	SQLSelect SQLCommand = iota
+	SQLShowTables
+	SQLDescribe
+)
+
+// ElasticSQL is an Elasticsearch SQL statement
+// that has been translated to PartiQL
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/sql-spec.html
+type ElasticSQL struct {
+	Command SQLCommand
+
+	// Pattern is the index (pattern) of
+	// SHOW TABLES, DESCRIBE and SHOW COLUMNS
+	Pattern string
+
+	// Query is the translated SELECT statement
+	// (without its LIMIT and OFFSET)
+	Query *expr.Query
+
+	// Offset and Limit of the SELECT statement
+	// (Limit is -1 if the statement isn't limited)
+	Offset, Limit int64
+
+	like bool // Pattern is a LIKE pattern
+}
+
+// SQLParam is a parameter of an Elasticsearch SQL
+// query. Parameters are specified either as a
+// plain value or as {"type": "...", "value": ...}.
+type SQLParam struct {
+	Value any
+}
+
+func (p *SQLParam) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if m, ok := v.(map[string]any); ok {
+		v = m["value"]
+	}
+	p.Value = v
+	return nil
+}
+
+func (p SQLParam) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Value)
+}
+
+// literal returns the parameter as a PartiQL literal
+func (p SQLParam) literal() (string, error) {
+	switch v := p.Value.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return expr.ToString(expr.Float(v)), nil
+	case string:
+		return expr.Quote(v), nil
+	default:
+		return "", fmt.Errorf("unsupported parameter type %T", v)
+	}
+}
+
+// ParseElasticSQL parses an Elasticsearch SQL statement
+// and translates a SELECT statement to PartiQL. The
+// indices that are referenced by the query are still
+// unresolved (see ResolveIndices). The current time
+// is used for NOW() and CURRENT_DATE.
+func ParseElasticSQL(query string, params []SQLParam, now time.Time) (*ElasticSQL, error) {
+	tokens, err := lexElasticSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt, err := parseSQLCommand(tokens); stmt != nil || err != nil {
+		return stmt, err
+	}
+
+	text, err := normalizeElasticSQL(tokens, params)
+	if err != nil {
+		return nil, err
+	}
+	q, err := partiql.Parse([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	if q.Explain != expr.ExplainNone || q.Into != nil {
+		return nil, errors.New("only SELECT statements are supported")
+	}
+
+	stmt := &ElasticSQL{
+		Command: SQLSelect,
+		Query:   q,
+		Limit:   -1,
+	}
+	if sel, ok := q.Body.(*expr.Select); ok {
+		// Elastic names the columns by their
+		// expression (i.e. "YEAR(ts)")
+		for i := range sel.Columns {
+			c := &sel.Columns[i]
+			if _, ok := c.Expr.(expr.Star); ok || c.Explicit() {
+				continue
+			}
+			name := c.Result()
+			if _, ok := c.Expr.(expr.Ident); !ok || name == "" {
+				name = expr.ToString(c.Expr)
+			}
+			c.As(name)
+		}
+		if sel.Limit != nil {
+			stmt.Limit = int64(*sel.Limit)
+			sel.Limit = nil
+		}
+		if sel.Offset != nil {
+			stmt.Offset = int64(*sel.Offset)
+			sel.Offset = nil
+		}
+	}
+
+	r := sqlRewriter{now: now}
+	for i := range q.With {
+		q.With[i].As = expr.Rewrite(&r, q.With[i].As).(*expr.Select)
+	}
+	q.Body = expr.Rewrite(&r, q.Body)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return stmt, nil
+}
+
+// Indices returns the indices that are
+// referenced by a SELECT statement
+func (s *ElasticSQL) Indices() []string {
+	if s.Query == nil {
+		return nil
+	}
+	ctes := make(map[string]bool, len(s.Query.With))
+	for _, cte := range s.Query.With {
+		ctes[cte.Table] = true
+	}
+	var indices []string
+	seen := make(map[string]bool)
+	visit := expr.WalkFunc(func(n expr.Node) bool {
+		if t, ok := n.(*expr.Table); ok {
+			if id, ok := t.Expr.(expr.Ident); ok && !ctes[string(id)] && !seen[string(id)] {
+				seen[string(id)] = true
+				indices = append(indices, string(id))
+			}
+		}
+		return true
+	})
+	for i := range s.Query.With {
+		expr.Walk(visit, s.Query.With[i].As)
+	}
+	expr.Walk(visit, s.Query.Body)
+	return indices
+}
+
+// ResolveIndices replaces the indices in the
+// FROM clauses with their Sneller tables
+func (s *ElasticSQL) ResolveIndices(resolve func(index string) ([]TableSource, bool)) error {
+	if s.Query == nil {
+		return nil
+	}
+	ctes := make(map[string]bool, len(s.Query.With))
+	for _, cte := range s.Query.With {
+		ctes[cte.Table] = true
+	}
+	r := tableRewriter{resolve: resolve, ctes: ctes}
+	for i := range s.Query.With {
+		s.Query.With[i].As = expr.Rewrite(&r, s.Query.With[i].As).(*expr.Select)
+	}
+	s.Query.Body = expr.Rewrite(&r, s.Query.Body)
+	return r.err
+}
+
+// Paged returns if the results of the
+// statement can be fetched in pages
+func (s *ElasticSQL) Paged() bool {
+	_, ok := s.Query.Body.(*expr.Select)
+	return ok
+}
+
+// Page returns the PartiQL query that fetches
+// (at most) count rows starting at the offset
+// (relative to the start of the results)
+func (s *ElasticSQL) Page(offset, count int64) string {
+	sel, ok := s.Query.Body.(*expr.Select)
+	if !ok {
+		return expr.ToString(s.Query)
+	}
+	from := s.Offset + offset
+	if s.Limit >= 0 && offset+count > s.Limit {
+		count = max(s.Limit-offset, 0)
+	}
+	limit := expr.Integer(count)
+	sel.Limit = &limit
+	if from > 0 {
+		offset := expr.Integer(from)
+		sel.Offset = &offset
+	}
+	text := expr.ToString(s.Query)
+	sel.Limit, sel.Offset = nil, nil
+	return text
+}
+
+// tableRewriter replaces indices by
+// their Sneller tables
+type tableRewriter struct {
+	resolve func(index string) ([]TableSource, bool)
+	ctes    map[string]bool
+	err     error
+}
+
+func (r *tableRewriter) Walk(expr.Node) expr.Rewriter { return r }
+
+func (r *tableRewriter) Rewrite(n expr.Node) expr.Node {
+	t, ok := n.(*expr.Table)
+	if !ok || r.err != nil {
+		return n
+	}
+	if _, ok := t.Expr.(*expr.Select); ok {
+		return n // sub-query
+	}
+	id, ok := t.Expr.(expr.Ident)
+	if !ok {
+		r.err = fmt.Errorf("unsupported table %s", expr.ToString(t.Expr))
+		return n
+	}
+	if r.ctes[string(id)] {
+		return n
+	}
+	sources, ok := r.resolve(string(id))
+	if !ok || len(sources) == 0 {
+		r.err = fmt.Errorf("unknown index [%s]", id)
+		return n
+	}
+	var table expr.Node
+	for _, s := range sources {
+		var source expr.Node
+		if s.Database != "" {
+			source = expr.MakePath([]string{s.Database, s.Table})
+		} else {
+			source = expr.Ident(s.Table)
+		}
+		if table == nil {
+			table = source
+		} else {
+			table = expr.Append(table, source)
+		}
+	}
+	return &expr.Table{Binding: expr.Bind(table, t.Result())}
+}
+
+// sqlRewriter translates the Elasticsearch SQL
+// functions that PartiQL doesn't support
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/sql-functions.html
+type sqlRewriter struct {
+	now time.Time
+	err error
+}
+
+func (r *sqlRewriter) Walk(expr.Node) expr.Rewriter { return r }
+
+func (r *sqlRewriter) Rewrite(n expr.Node) expr.Node {
+	if r.err != nil {
+		return n
+	}
+	switch n := n.(type) {
+	case expr.Ident:
+		switch strings.ToUpper(string(n)) {
+		case "CURRENT_TIMESTAMP":
+			return r.timestamp(false)
+		case "CURRENT_DATE":
+			return r.timestamp(true)
+		}
+	case *expr.StringMatch:
+		// RLIKE should match the entire string
+		if n.Op == expr.RegexpMatch {
+			n.Pattern = "^(?:" + n.Pattern + ")$"
+		}
+	case *expr.Builtin:
+		if n.Func == expr.Unspecified {
+			result, err := r.function(strings.ToUpper(n.Text), n.Args)
+			if err != nil {
+				r.err = err
+				return n
+			}
+			return result
+		}
+	}
+	return n
+}
+
+func (r *sqlRewriter) timestamp(truncate bool) expr.Node {
+	t := r.now.UTC().Truncate(time.Millisecond)
+	if truncate {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return &expr.Timestamp{Value: date.FromTime(t)}
+}
+
+// extractFunctions are functions that
+// extract a part of a datetime
+var extractFunctions = map[string]expr.Timepart{
+	"YEAR":             expr.Year,
+	"QUARTER":          expr.Quarter,
+	"MONTH":            expr.Month,
+	"MONTH_OF_YEAR":    expr.Month,
+	"DAY":              expr.Day,
+	"DAY_OF_MONTH":     expr.Day,
+	"DAYOFMONTH":       expr.Day,
+	"DOM":              expr.Day,
+	"DAY_OF_YEAR":      expr.DOY,
+	"DAYOFYEAR":        expr.DOY,
+	"DOY":              expr.DOY,
+	"DAY_OF_WEEK":      expr.DOW,
+	"DAYOFWEEK":        expr.DOW,
+	"DOW":              expr.DOW,
+	"HOUR":             expr.Hour,
+	"HOUR_OF_DAY":      expr.Hour,
+	"MINUTE":           expr.Minute,
+	"MINUTE_OF_HOUR":   expr.Minute,
+	"SECOND":           expr.Second,
+	"SECOND_OF_MINUTE": expr.Second,
+}
+
+func (r *sqlRewriter) function(name string, args []expr.Node) (expr.Node, error) {
+	nargs := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("function %s expects %d arguments", name, n)
+		}
+		return nil
+	}
+
+	if part, ok := extractFunctions[name]; ok {
+		if err := nargs(1); err != nil {
+			return nil, err
+		}
+		return extract(part, args[0]), nil
+	}
+
+	switch name {
+	case "UCASE":
+		return expr.CallByName("UPPER", args...), nil
+	case "LCASE":
+		return expr.CallByName("LOWER", args...), nil
+	case "LENGTH":
+		// LENGTH excludes trailing blanks
+		if err := nargs(1); err != nil {
+			return nil, err
+		}
+		return expr.CallByName("CHAR_LENGTH", expr.CallByName("RTRIM", args[0])), nil
+	case "IFNULL", "ISNULL", "NVL":
+		if err := nargs(2); err != nil {
+			return nil, err
+		}
+		return expr.Coalesce(args), nil
+	case "NOW", "CURRENT_TIMESTAMP":
+		return r.timestamp(false), nil
+	case "CURDATE", "CURRENT_DATE", "TODAY":
+		return r.timestamp(true), nil
+	case "DATETRUNC", "DATE_TRUNC":
+		if err := nargs(2); err != nil {
+			return nil, err
+		}
+		part, err := timeUnit(name, args[0], expr.DOW, expr.DOY)
+		if err != nil {
+			return nil, err
+		}
+		return expr.DateTrunc(part, args[1]), nil
+	case "DATEADD", "DATE_ADD", "TIMESTAMPADD", "TIMESTAMP_ADD":
+		if err := nargs(3); err != nil {
+			return nil, err
+		}
+		part, err := timeUnit(name, args[0], expr.DOW, expr.DOY)
+		if err != nil {
+			return nil, err
+		}
+		return expr.DateAdd(part, args[1], args[2]), nil
+	case "DATEDIFF", "DATE_DIFF", "TIMESTAMPDIFF", "TIMESTAMP_DIFF":
+		if err := nargs(3); err != nil {
+			return nil, err
+		}
+		part, err := timeUnit(name, args[0], expr.DOW, expr.DOY)
+		if err != nil {
+			return nil, err
+		}
+		return expr.DateDiff(part, args[1], args[2]), nil
+	case "DATEPART", "DATE_PART":
+		if err := nargs(2); err != nil {
+			return nil, err
+		}
+		part, err := timeUnit(name, args[0], expr.Week)
+		if err != nil {
+			return nil, err
+		}
+		return extract(part, args[1]), nil
+	case "MATCH", "QUERY", "SCORE":
+		return nil, fmt.Errorf("full-text search function %s is not supported", name)
+	}
+	return nil, fmt.Errorf("unsupported function %s", name)
+}
+
+// extract extracts a part of a datetime
+// (Elastic numbers the weekdays from 1 to 7)
+func extract(part expr.Timepart, e expr.Node) expr.Node {
+	if part == expr.DOW {
+		return expr.Add(expr.DateExtract(part, e), expr.Integer(1))
+	}
+	return expr.DateExtract(part, e)
+}
+
+// timeUnits are the datetime units (and their
+// abbreviations) of the datetime functions
+var timeUnits = map[string]expr.Timepart{
+	"year": expr.Year, "years": expr.Year, "yy": expr.Year, "yyyy": expr.Year,
+	"quarter": expr.Quarter, "quarters": expr.Quarter, "qq": expr.Quarter, "q": expr.Quarter,
+	"month": expr.Month, "months": expr.Month, "mm": expr.Month, "m": expr.Month,
+	"dayofyear": expr.DOY, "dy": expr.DOY, "y": expr.DOY,
+	"week": expr.Week, "weeks": expr.Week, "wk": expr.Week, "ww": expr.Week,
+	"weekday": expr.DOW, "weekdays": expr.DOW, "dw": expr.DOW,
+	"day": expr.Day, "days": expr.Day, "dd": expr.Day, "d": expr.Day,
+	"hour": expr.Hour, "hours": expr.Hour, "hh": expr.Hour,
+	"minute": expr.Minute, "minutes": expr.Minute, "mi": expr.Minute, "n": expr.Minute,
+	"second": expr.Second, "seconds": expr.Second, "ss": expr.Second, "s": expr.Second,
+	"millisecond": expr.Millisecond, "milliseconds": expr.Millisecond, "ms": expr.Millisecond,
+	"microsecond": expr.Microsecond, "microseconds": expr.Microsecond, "mcs": expr.Microsecond,
+}
+
+func timeUnit(name string, e expr.Node, unsupported ...expr.Timepart) (expr.Timepart, error) {
+	unit, ok := e.(expr.String)
+	if !ok {
+		return 0, fmt.Errorf("first argument of %s should be a datetime unit string", name)
+	}
+	part, ok := timeUnits[strings.ToLower(string(unit))]
+	if ok {
+		for _, p := range unsupported {
+			if part == p {
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		return 0, fmt.Errorf("unsupported datetime unit '%s' for %s", unit, name)
+	}
+	return part, nil
+}
+
+// castTypes maps the Elasticsearch SQL types
+// to their PartiQL counterparts
+var castTypes = map[string]string{
+	"VARCHAR":      "STRING",
+	"KEYWORD":      "STRING",
+	"TEXT":         "STRING",
+	"BYTE":         "INTEGER",
+	"TINYINT":      "INTEGER",
+	"SHORT":        "INTEGER",
+	"SMALLINT":     "INTEGER",
+	"INT":          "INTEGER",
+	"LONG":         "INTEGER",
+	"BIGINT":       "INTEGER",
+	"DOUBLE":       "FLOAT",
+	"REAL":         "FLOAT",
+	"HALF_FLOAT":   "FLOAT",
+	"SCALED_FLOAT": "FLOAT",
+	"DATETIME":     "TIMESTAMP",
+	"DATE":         "TIMESTAMP",
+}
+
+// renamedFunctions are functions that are keywords
+// in PartiQL, but take a unit string in Elastic
+var renamedFunctions = map[string]string{
+	"DATE_TRUNC": "DATETRUNC",
+	"DATE_ADD":   "DATEADD",
+	"DATE_DIFF":  "DATEDIFF",
+}
+
+type sqlTokenKind int
+
+const (
+	sqlSpace sqlTokenKind = iota
+	sqlWord
+	sqlString
+	sqlQuotedID
+	sqlSymbol
+)
+
+// sqlToken is a token of an Elasticsearch SQL
+// statement. The text of strings and quoted
+// identifiers is unquoted.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+// lexElasticSQL splits the statement into tokens.
+// Elasticsearch SQL escapes quotes by doubling
+// them, whereas PartiQL uses backslash escapes,
+// so strings and identifiers are unquoted here.
+func lexElasticSQL(query string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSQLSpace(c):
+			for i < len(query) && isSQLSpace(query[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlSpace, text: " "})
+		case strings.HasPrefix(query[i:], "--"):
+			n := strings.IndexByte(query[i:], '\n')
+			if n < 0 {
+				n = len(query) - i
+			}
+			i += n
+			tokens = append(tokens, sqlToken{kind: sqlSpace, text: " "})
+		case strings.HasPrefix(query[i:], "/*"):
+			n := strings.Index(query[i+2:], "*/")
+			if n < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += n + 4
+			tokens = append(tokens, sqlToken{kind: sqlSpace, text: " "})
+		case c == '\'' || c == '"':
+			text, n, err := unquoteSQL(query[i:])
+			if err != nil {
+				return nil, err
+			}
+			kind := sqlString
+			if c == '"' {
+				kind = sqlQuotedID
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: text})
+			i += n
+		case isSQLWordChar(c):
+			start := i
+			for i < len(query) && isSQLWordChar(query[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: sqlWord, text: query[start:i]})
+		default:
+			_, n := utf8.DecodeRuneInString(query[i:])
+			tokens = append(tokens, sqlToken{kind: sqlSymbol, text: query[i : i+n]})
+			i += n
+		}
+	}
+	return tokens, nil
+}
+
+// unquoteSQL unquotes the string or identifier
+// at the start of s and returns its length
+func unquoteSQL(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			sb.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			sb.WriteByte(quote)
+			i++
+			continue
+		}
+		return sb.String(), i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated quoted text %s", s)
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isSQLWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '@' || c >= utf8.RuneSelf
+}
+
+// nextToken returns the index of the next
+// token (that isn't whitespace) or -1
+func nextToken(tokens []sqlToken, i int) int {
+	for i++; i < len(tokens); i++ {
+		if tokens[i].kind != sqlSpace {
+			return i
+		}
+	}
+	return -1
+}
+
+// prevToken returns the index of the previous
+// token (that isn't whitespace) or -1
+func prevToken(tokens []sqlToken, i int) int {
+	for i--; i >= 0; i-- {
+		if tokens[i].kind != sqlSpace {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSQLToken(tokens []sqlToken, i int, kind sqlTokenKind, text string) bool {
+	return i >= 0 && tokens[i].kind == kind && strings.EqualFold(tokens[i].text, text)
+}
+
+// normalizeElasticSQL translates the lexical
+// differences between Elasticsearch SQL and
+// PartiQL and substitutes the parameters
+func normalizeElasticSQL(tokens []sqlToken, params []SQLParam) (string, error) {
+	var sb strings.Builder
+	type cast struct {
+		depth   int
+		convert bool // CONVERT(value, type)
+	}
+	var casts []cast
+	depth, param := 0, 0
+	for i, tok := range tokens {
+		text := tok.text
+		switch tok.kind {
+		case sqlString:
+			text = expr.Quote(tok.text)
+		case sqlQuotedID:
+			text = expr.QuoteID(tok.text)
+		case sqlSymbol:
+			switch tok.text {
+			case "?":
+				if param >= len(params) {
+					return "", errors.New("not enough parameters specified")
+				}
+				lit, err := params[param].literal()
+				if err != nil {
+					return "", fmt.Errorf("parameter %d: %w", param+1, err)
+				}
+				text = lit
+				param++
+			case "(":
+				depth++
+			case ")":
+				if n := len(casts); n > 0 && casts[n-1].depth == depth {
+					casts = casts[:n-1]
+				}
+				depth--
+			case ",":
+				if n := len(casts); n > 0 && casts[n-1].depth == depth && casts[n-1].convert {
+					text = " AS "
+				}
+			}
+		case sqlWord:
+			word := strings.ToUpper(tok.text)
+			next := nextToken(tokens, i)
+			switch {
+			case word == "RLIKE":
+				text = "~"
+			case (word == "CAST" || word == "CONVERT") && isSQLToken(tokens, next, sqlSymbol, "("):
+				casts = append(casts, cast{depth: depth + 1, convert: word == "CONVERT"})
+				text = "CAST"
+			case renamedFunctions[word] != "" && isSQLToken(tokens, next, sqlSymbol, "("):
+				text = renamedFunctions[word]
+			case len(casts) > 0 && casts[len(casts)-1].depth == depth && castTypes[word] != "" &&
+				(isSQLToken(tokens, prevToken(tokens, i), sqlWord, "AS") || isSQLToken(tokens, prevToken(tokens, i), sqlSymbol, ",")):
+				text = castTypes[word]
+			}
+		}
+		sb.WriteString(text)
+	}
+	if param != len(params) {
+		return "", fmt.Errorf("%d parameters specified, but the query uses %d", len(params), param)
+	}
+	return sb.String(), nil
+}
+
+// parseSQLCommand parses the commands that aren't
+// queries (SHOW TABLES, DESCRIBE and SHOW COLUMNS).
+// It returns nil for SELECT statements.
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/sql-commands.html
+func parseSQLCommand(tokens []sqlToken) (*ElasticSQL, error) {
+	var words []sqlToken
+	for i := nextToken(tokens, -1); i >= 0; i = nextToken(tokens, i) {
+		words = append(words, tokens[i])
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty query")
+	}
+	if words[len(words)-1].kind == sqlSymbol && words[len(words)-1].text == ";" {
+		words = words[:len(words)-1]
+	}
+	keyword := func(i int, text ...string) bool {
+		for _, t := range text {
+			if isSQLToken(words, i, sqlWord, t) {
+				return true
+			}
+		}
+		return false
+	}
+	// pattern concatenates the remaining tokens,
+	// so unquoted index names (i.e. logs-2023)
+	// can be used as well
+	pattern := func(i int) (string, error) {
+		if i >= len(words) {
+			return "", errors.New("missing index name")
+		}
+		var sb strings.Builder
+		for _, w := range words[i:] {
+			sb.WriteString(w.text)
+		}
+		return sb.String(), nil
+	}
+
+	switch {
+	case keyword(0, "SHOW") && keyword(1, "TABLES"):
+		stmt := &ElasticSQL{Command: SQLShowTables, Pattern: "*"}
+		switch {
+		case len(words) == 2:
+		case len(words) == 4 && keyword(2, "LIKE") && words[3].kind == sqlString:
+			stmt.Pattern = words[3].text
+			stmt.like = true
+		case len(words) > 2 && !keyword(2, "LIKE"):
+			stmt.Pattern, _ = pattern(2)
+		default:
+			return nil, errors.New("invalid SHOW TABLES statement")
+		}
+		return stmt, nil
+	case keyword(0, "SHOW") && keyword(1, "COLUMNS") && keyword(2, "FROM", "IN"):
+		p, err := pattern(3)
+		return &ElasticSQL{Command: SQLDescribe, Pattern: p}, err
+	case keyword(0, "DESCRIBE", "DESC"):
+		p, err := pattern(1)
+		return &ElasticSQL{Command: SQLDescribe, Pattern: p}, err
+	case keyword(0, "SHOW", "SYS"):
+		return nil, fmt.Errorf("unsupported command %s", strings.ToUpper(words[0].text))
+	}
+	return nil, nil
+}
+
+// MatchIndex returns if the index matches the
+// pattern of SHOW TABLES. The pattern is either
+// a LIKE pattern or a wildcard pattern.
+func (s *ElasticSQL) MatchIndex(index string) bool {
+	if !s.like {
+		return matchWildcard(index, s.Pattern)
+	}
+	var pat strings.Builder
+	pat.WriteRune('^')
+	escaped := false
+	for _, r := range s.Pattern {
+		switch {
+		case escaped:
+			pat.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			pat.WriteString(".*")
+		case r == '_':
+			pat.WriteRune('.')
+		default:
+			pat.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pat.WriteRune('$')
+	result, _ := regexp.MatchString(pat.String(), index)
+	return result
+}
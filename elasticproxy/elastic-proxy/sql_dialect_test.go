@@ -0,0 +1,191 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseElasticSQL(t *testing.T) {
+	now := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	resolve := func(index string) ([]TableSource, bool) {
+		switch index {
+		case "logs":
+			return []TableSource{{Database: "db", Table: "logs"}}, true
+		case "my-index":
+			return []TableSource{{Database: "db", Table: "a"}, {Database: "db", Table: "b"}}, true
+		}
+		return nil, false
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		params  string
+		offset  int64
+		count   int64
+		indices []string
+		sql     string
+	}{
+		{
+			name:    "columns",
+			query:   `SELECT name, UCASE(name), COUNT(*) FROM logs GROUP BY name`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `SELECT name AS name, UPPER(name) AS "UCASE(name)", COUNT(*) AS "COUNT(*)" FROM db.logs AS logs GROUP BY name LIMIT 11`,
+		},
+		{
+			name:    "quoting",
+			query:   `SELECT "we""ird" AS "my col", 'it''s' s FROM "my-index" -- comment`,
+			count:   11,
+			indices: []string{"my-index"},
+			sql:     `SELECT "we\"ird" AS "my col", 'it\'s' AS s FROM (db.a ++ db.b) AS "my-index" LIMIT 11`,
+		},
+		{
+			name:    "params",
+			query:   `SELECT a FROM logs WHERE a = ? AND b > ? AND c = ? /* inline */ AND d IS NOT ?`,
+			params:  `["x", 1.5, {"type": "boolean", "value": true}, null]`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `SELECT a AS a FROM db.logs AS logs WHERE a = 'x' AND b > 1.5 AND c = TRUE AND d IS NOT NULL LIMIT 11`,
+		},
+		{
+			name:    "limit-and-offset",
+			query:   `SELECT a FROM logs ORDER BY a LIMIT 25 OFFSET 5`,
+			offset:  20,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `SELECT a AS a FROM db.logs AS logs ORDER BY a ASC NULLS FIRST LIMIT 5 OFFSET 25`,
+		},
+		{
+			name:    "rlike-and-cast",
+			query:   `SELECT CAST(a AS LONG) AS a, CONVERT(b, VARCHAR) AS b FROM logs WHERE c RLIKE 'x|y'`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `SELECT CAST(a AS INTEGER) AS a, CAST(b AS STRING) AS b FROM db.logs AS logs WHERE c ~ '^(?:x|y)$' LIMIT 11`,
+		},
+		{
+			name:    "datetime",
+			query:   `SELECT YEAR(ts) y, DAY_OF_WEEK(ts) dow, DATE_TRUNC('month', ts) m, DATE_ADD('days', 1, ts) a, DATEDIFF('hh', ts, NOW()) d FROM logs WHERE ts > CURRENT_DATE`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     "SELECT DATE_EXTRACT_YEAR(ts) AS y, DATE_EXTRACT_DOW(ts) + 1 AS dow, DATE_TRUNC_MONTH(ts) AS m, DATE_ADD_DAY(1, ts) AS a, DATE_DIFF_HOUR(ts, `2023-05-06T07:08:09Z`) AS d FROM db.logs AS logs WHERE ts > `2023-05-06T00:00:00Z` LIMIT 11",
+		},
+		{
+			name:    "functions",
+			query:   `SELECT IFNULL(a, 'x') a, LENGTH(b) b FROM logs`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `SELECT CASE WHEN a IS NOT NULL THEN a WHEN 'x' IS NOT NULL THEN 'x' ELSE NULL END AS a, CHAR_LENGTH(RTRIM(b)) AS b FROM db.logs AS logs LIMIT 11`,
+		},
+		{
+			name:    "cte",
+			query:   `WITH t AS (SELECT a FROM logs) SELECT * FROM t`,
+			count:   11,
+			indices: []string{"logs"},
+			sql:     `WITH t AS (SELECT a FROM db.logs AS logs) SELECT * FROM t LIMIT 11`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var params []SQLParam
+			if tc.params != "" {
+				if err := json.Unmarshal([]byte(tc.params), &params); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stmt, err := ParseElasticSQL(tc.query, params, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stmt.Command != SQLSelect {
+				t.Fatalf("got command %d", stmt.Command)
+			}
+			if indices := stmt.Indices(); !reflect.DeepEqual(indices, tc.indices) {
+				t.Errorf("got indices %v, expected %v", indices, tc.indices)
+			}
+			if err := stmt.ResolveIndices(resolve); err != nil {
+				t.Fatal(err)
+			}
+			if sql := stmt.Page(tc.offset, tc.count); sql != tc.sql {
+				t.Errorf("got:\n%s\nexpected:\n%s", sql, tc.sql)
+			}
+		})
+	}
+}
+
+func TestParseElasticSQLErrors(t *testing.T) {
+	queries := []string{
+		`SELECT 'abc FROM logs`,
+		`SELECT a FROM logs WHERE a = ?`,
+		`SELECT MATCH(a, 'x') FROM logs`,
+		`SELECT DATE_TRUNC('weekday', ts) FROM logs`,
+		`SELECT FOO(a) FROM logs`,
+		`SHOW FUNCTIONS`,
+		``,
+	}
+	for _, query := range queries {
+		if _, err := ParseElasticSQL(query, nil, time.Now()); err == nil {
+			t.Errorf("expected error for %q", query)
+		}
+	}
+
+	stmt, err := ParseElasticSQL(`SELECT a FROM other`, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stmt.ResolveIndices(func(string) ([]TableSource, bool) { return nil, false }); err == nil {
+		t.Error("expected error for unknown index")
+	}
+}
+
+func TestSQLCommands(t *testing.T) {
+	tests := []struct {
+		query   string
+		command SQLCommand
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{query: `SHOW TABLES`, command: SQLShowTables, pattern: "*", match: []string{"logs"}},
+		{query: `show tables like 'lo_s%';`, command: SQLShowTables, pattern: "lo_s%", match: []string{"logs", "logs-2023"}, noMatch: []string{"log"}},
+		{query: `SHOW TABLES logs-*`, command: SQLShowTables, pattern: "logs-*", match: []string{"logs-2023"}, noMatch: []string{"logs"}},
+		{query: `DESCRIBE logs-2023`, command: SQLDescribe, pattern: "logs-2023"},
+		{query: `DESC "logs"`, command: SQLDescribe, pattern: "logs"},
+		{query: `SHOW COLUMNS IN logs`, command: SQLDescribe, pattern: "logs"},
+	}
+	for _, tc := range tests {
+		stmt, err := ParseElasticSQL(tc.query, nil, time.Now())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if stmt.Command != tc.command || stmt.Pattern != tc.pattern {
+			t.Errorf("%s: got %d %q, expected %d %q", tc.query, stmt.Command, stmt.Pattern, tc.command, tc.pattern)
+		}
+		for _, index := range tc.match {
+			if !stmt.MatchIndex(index) {
+				t.Errorf("%s: %q should match", tc.query, index)
+			}
+		}
+		for _, index := range tc.noMatch {
+			if stmt.MatchIndex(index) {
+				t.Errorf("%s: %q shouldn't match", tc.query, index)
+			}
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SnellerInc/sneller/expr"
+
+	"github.com/amazon-ion/ion-go/ion"
+)
+
+// SQLColumn is a column of an Elasticsearch SQL result
+type SQLColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SQLResult is the (columnar) result of a query
+type SQLResult struct {
+	Columns []SQLColumn
+	Rows    [][]any
+
+	CacheHits    int64
+	CacheMisses  int64
+	BytesScanned int64
+}
+
+// ReadSQLResult reads the Ion result of a query.
+// Sneller returns the rows as structs (the field
+// order is preserved) followed by a 'final_status'
+// struct that holds the statistics and the types
+// of the result columns.
+func ReadSQLResult(r io.Reader) (*SQLResult, error) {
+	var result SQLResult
+	var rows []sqlRow
+	var resultSet []SQLColumn
+
+	rd := ion.NewReader(r)
+	for rd.Next() {
+		annotations, err := rd.Annotations()
+		if err != nil {
+			return nil, err
+		}
+		if len(annotations) > 0 && annotations[0].Text != nil {
+			switch *annotations[0].Text {
+			case "final_status":
+				resultSet, err = readFinalStatus(rd, &result)
+				if err != nil {
+					return nil, err
+				}
+			case "query_error":
+				v, _ := readIonValue(rd)
+				return nil, fmt.Errorf("query error: %v", v)
+			}
+			continue
+		}
+		if rd.Type() != ion.StructType {
+			return nil, fmt.Errorf("unexpected %s in query result", rd.Type())
+		}
+		row, err := readIonRow(rd)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	if err := rd.Err(); err != nil {
+		return nil, err
+	}
+
+	// use the order of the result set (if known) or
+	// the order in which the columns first appear
+	result.Columns = resultSet
+	index := make(map[string]int)
+	for i, c := range result.Columns {
+		index[c.Name] = i
+	}
+	for _, row := range rows {
+		for _, name := range row.names {
+			if _, ok := index[name]; !ok {
+				index[name] = len(result.Columns)
+				result.Columns = append(result.Columns, SQLColumn{Name: name})
+			}
+		}
+	}
+
+	result.Rows = make([][]any, len(rows))
+	for i, row := range rows {
+		values := make([]any, len(result.Columns))
+		for j, name := range row.names {
+			values[index[name]] = row.values[j]
+		}
+		result.Rows[i] = values
+	}
+
+	// determine the types that aren't
+	// known from the values
+	for i := range result.Columns {
+		if result.Columns[i].Type != "" {
+			continue
+		}
+		result.Columns[i].Type = "null"
+		for _, row := range result.Rows {
+			if typ := valueSQLType(row[i]); typ != "" {
+				result.Columns[i].Type = typ
+				break
+			}
+		}
+	}
+	return &result, nil
+}
+
+type sqlRow struct {
+	names  []string
+	values []any
+}
+
+func readIonRow(rd ion.Reader) (sqlRow, error) {
+	var row sqlRow
+	if err := rd.StepIn(); err != nil {
+		return row, err
+	}
+	for rd.Next() {
+		name, err := rd.FieldName()
+		if err != nil {
+			return row, err
+		}
+		if name == nil || name.Text == nil {
+			return row, errors.New("field without name in query result")
+		}
+		v, err := readIonValue(rd)
+		if err != nil {
+			return row, err
+		}
+		row.names = append(row.names, *name.Text)
+		row.values = append(row.values, v)
+	}
+	if err := rd.Err(); err != nil {
+		return row, err
+	}
+	return row, rd.StepOut()
+}
+
+// readFinalStatus reads the statistics and
+// the result set of the final status
+func readFinalStatus(rd ion.Reader, result *SQLResult) ([]SQLColumn, error) {
+	if rd.Type() != ion.StructType {
+		return nil, errors.New("invalid final status")
+	}
+	var columns []SQLColumn
+	if err := rd.StepIn(); err != nil {
+		return nil, err
+	}
+	for rd.Next() {
+		name, err := rd.FieldName()
+		if err != nil {
+			return nil, err
+		}
+		if name == nil || name.Text == nil {
+			continue
+		}
+		switch *name.Text {
+		case "error":
+			v, _ := readIonValue(rd)
+			return nil, fmt.Errorf("query error: %v", v)
+		case "hits", "misses", "scanned":
+			v, err := rd.Int64Value()
+			if err != nil || v == nil {
+				continue
+			}
+			switch *name.Text {
+			case "hits":
+				result.CacheHits = *v
+			case "misses":
+				result.CacheMisses = *v
+			case "scanned":
+				result.BytesScanned = *v
+			}
+		case "result_set":
+			row, err := readIonRow(rd)
+			if err != nil {
+				return nil, err
+			}
+			for i, name := range row.names {
+				var typ string
+				if ts, ok := row.values[i].(int64); ok {
+					typ = typeSetSQLType(expr.TypeSet(ts))
+				}
+				columns = append(columns, SQLColumn{Name: name, Type: typ})
+			}
+		}
+	}
+	if err := rd.Err(); err != nil {
+		return nil, err
+	}
+	return columns, rd.StepOut()
+}
+
+// readIonValue reads the current value. Structs
+// are returned as maps and timestamps as time.Time.
+func readIonValue(rd ion.Reader) (any, error) {
+	if rd.IsNull() {
+		return nil, nil
+	}
+	switch rd.Type() {
+	case ion.BoolType:
+		v, err := rd.BoolValue()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return *v, nil
+	case ion.IntType:
+		v, err := rd.Int64Value()
+		if err != nil {
+			b, err := rd.BigIntValue()
+			if err != nil || b == nil {
+				return nil, err
+			}
+			f, _ := strconv.ParseFloat(b.String(), 64)
+			return f, nil
+		}
+		if v == nil {
+			return nil, nil
+		}
+		return *v, nil
+	case ion.FloatType:
+		v, err := rd.FloatValue()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		if math.IsNaN(*v) || math.IsInf(*v, 0) {
+			return nil, nil // not representable in JSON
+		}
+		return *v, nil
+	case ion.DecimalType:
+		v, err := rd.DecimalValue()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		text := strings.Replace(strings.TrimSuffix(v.String(), "."), "d", "e", 1)
+		return strconv.ParseFloat(text, 64)
+	case ion.TimestampType:
+		v, err := rd.TimestampValue()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return v.GetDateTime(), nil
+	case ion.StringType:
+		v, err := rd.StringValue()
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return *v, nil
+	case ion.SymbolType:
+		v, err := rd.SymbolValue()
+		if err != nil || v == nil || v.Text == nil {
+			return nil, err
+		}
+		return *v.Text, nil
+	case ion.BlobType, ion.ClobType:
+		return rd.ByteValue()
+	case ion.ListType, ion.SexpType:
+		if err := rd.StepIn(); err != nil {
+			return nil, err
+		}
+		list := []any{}
+		for rd.Next() {
+			v, err := readIonValue(rd)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if err := rd.Err(); err != nil {
+			return nil, err
+		}
+		return list, rd.StepOut()
+	case ion.StructType:
+		row, err := readIonRow(rd)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, len(row.names))
+		for i, name := range row.names {
+			m[name] = row.values[i]
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// typeSetSQLType returns the Elasticsearch SQL type
+// of a result column (or an empty string if the
+// type should be derived from the values)
+func typeSetSQLType(ts expr.TypeSet) string {
+	t := ts &^ (expr.NullType | expr.MissingType)
+	switch {
+	case t == 0:
+		return ""
+	case t&^expr.IntegerType == 0:
+		return "long"
+	case t&^(expr.NumericType|expr.DecimalType) == 0:
+		return "double"
+	case t&^(expr.StringType|expr.SymbolType) == 0:
+		return "keyword"
+	case t == expr.TimeType:
+		return "datetime"
+	case t == expr.BoolType:
+		return "boolean"
+	case t == expr.StructType:
+		return "object"
+	}
+	return ""
+}
+
+// valueSQLType returns the Elasticsearch
+// SQL type of a (non-null) value
+func valueSQLType(v any) string {
+	switch v.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case string:
+		return "keyword"
+	case time.Time:
+		return "datetime"
+	case map[string]any, []any:
+		return "object"
+	case []byte:
+		return "binary"
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package elastic_proxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSQLResult(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		input   string
+		columns []SQLColumn
+		rows    [][]any
+		err     bool
+	}{
+		{
+			name:  "result-set",
+			input: `{b: "x", a: 1} {a: 2, b: null} final_status::{hits: 1, misses: 2, scanned: 3, result_set: {b: 65535, a: 8}}`,
+			columns: []SQLColumn{
+				{Name: "b", Type: "keyword"},
+				{Name: "a", Type: "long"},
+			},
+			rows: [][]any{{"x", int64(1)}, {nil, int64(2)}},
+		},
+		{
+			name:  "inferred",
+			input: `{a: 2023-01-02T03:04:05Z, b: 1.5e0} {a: null, c: {x: [true]}} final_status::{hits: 0, misses: 0, scanned: 0}`,
+			columns: []SQLColumn{
+				{Name: "a", Type: "datetime"},
+				{Name: "b", Type: "double"},
+				{Name: "c", Type: "object"},
+			},
+			rows: [][]any{{ts, 1.5, nil}, {nil, nil, map[string]any{"x": []any{true}}}},
+		},
+		{
+			name:  "error",
+			input: `{a: 1} final_status::{error: "out of memory"}`,
+			err:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := ReadSQLResult(strings.NewReader(tc.input))
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Columns, tc.columns) {
+				t.Errorf("got columns %v, expected %v", result.Columns, tc.columns)
+			}
+			if !reflect.DeepEqual(result.Rows, tc.rows) {
+				t.Errorf("got rows %v, expected %v", result.Rows, tc.rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:pages%0" AS
+    (SELECT DATE_TRUNC(DAY,"$source"."timestamp") AS "$key:pages%0",
+            "$source"."OriginCountry" AS "$key:pages%1",
+            COUNT(*) AS "$doc_count",
+            AVG("$source"."price") AS "avg_price"
+     FROM "$source"
+     WHERE ((("$source"."timestamp" IS NOT MISSING) AND ("$source"."OriginCountry" IS NOT MISSING)) AND ((DATE_TRUNC(DAY,"$source"."timestamp") > `2022-06-25T00:00:00Z`) OR ((DATE_TRUNC(DAY,"$source"."timestamp") = `2022-06-25T00:00:00Z`) AND ("$source"."OriginCountry" < 'NL'))))
+     GROUP BY DATE_TRUNC(DAY,"$source"."timestamp"),
+              "$source"."OriginCountry"
+     ORDER BY "$key:pages%0" ASC,
+     "$key:pages%1" DESC
+     LIMIT 2
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:pages%0"
+  ) AS "$bucket:pages%0"
\ No newline at end of file
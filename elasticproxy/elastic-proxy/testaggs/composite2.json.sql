@@ -0,0 +1,41 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:prices%0" AS
+    (SELECT (FLOOR(("$source"."price" / 50)) * 50) AS "$key:prices%0",
+            COUNT(*) AS "$doc_count"
+     FROM "$source"
+     WHERE ("$source"."price" IS NOT MISSING)
+     GROUP BY (FLOOR(("$source"."price" / 50)) * 50)
+     ORDER BY "$key:prices%0" ASC
+     LIMIT 10
+    ),
+
+  "$bucket:prices:carriers%0" AS
+    (SELECT (FLOOR(("$source"."price" / 50)) * 50) AS "$key:prices%0",
+            "$source"."Carrier" AS "$key:prices:carriers%0",
+            COUNT(*) AS "$doc_count"
+     FROM "$source"
+     WHERE ((FLOOR(("$source"."price" / 50)) * 50) IN (SELECT "$selection"."$key:prices%0"
+     FROM "$bucket:prices%0" AS "$selection"))
+     GROUP BY (FLOOR(("$source"."price" / 50)) * 50),
+              "$source"."Carrier"
+     HAVING (ROW_NUMBER() OVER (PARTITION BY (FLOOR(("$source"."price" / 50)) * 50) ORDER BY COUNT(*) DESC) <= 10)
+     ORDER BY "$doc_count" DESC
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:prices%0"
+  ) AS "$bucket:prices%0",
+
+  (SELECT *
+   FROM "$bucket:prices:carriers%0"
+  ) AS "$bucket:prices:carriers%0"
\ No newline at end of file
@@ -0,0 +1,30 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:pages%0" AS
+    (SELECT "$source"."timestamp" AS "$key:pages%0",
+            (CASE WHEN ("$source"."DestCountry" IS MISSING) THEN NULL ELSE "$source"."DestCountry" END) AS "$key:pages%1",
+            (CASE WHEN ("$source"."OriginCountry" IS MISSING) THEN NULL ELSE "$source"."OriginCountry" END) AS "$key:pages%2",
+            COUNT(*) AS "$doc_count"
+     FROM "$source"
+     WHERE (("$source"."timestamp" IS NOT MISSING) AND ((("$source"."timestamp" > `2022-06-25T00:00:00Z`) OR (("$source"."timestamp" = `2022-06-25T00:00:00Z`) AND ((CASE WHEN ("$source"."DestCountry" IS MISSING) THEN NULL ELSE "$source"."DestCountry" END < 'NL') OR (CASE WHEN ("$source"."DestCountry" IS MISSING) THEN NULL ELSE "$source"."DestCountry" END IS NULL)))) OR ((("$source"."timestamp" = `2022-06-25T00:00:00Z`) AND (CASE WHEN ("$source"."DestCountry" IS MISSING) THEN NULL ELSE "$source"."DestCountry" END = 'NL')) AND (CASE WHEN ("$source"."OriginCountry" IS MISSING) THEN NULL ELSE "$source"."OriginCountry" END IS NOT NULL))))
+     GROUP BY "$source"."timestamp",
+              CASE WHEN ("$source"."DestCountry" IS MISSING) THEN NULL ELSE "$source"."DestCountry" END,
+              CASE WHEN ("$source"."OriginCountry" IS MISSING) THEN NULL ELSE "$source"."OriginCountry" END
+     ORDER BY "$key:pages%0" ASC,
+     "$key:pages%1" DESC NULLS LAST,
+     "$key:pages%2" ASC
+     LIMIT 10
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:pages%0"
+  ) AS "$bucket:pages%0"
\ No newline at end of file
@@ -0,0 +1,23 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:periods%0" AS
+    (SELECT (CASE WHEN (("$source"."timestamp" >= `2022-06-01T00:00:00Z`) AND ("$source"."timestamp" < `2022-06-24T00:00:00Z`)) THEN 0 WHEN ("$source"."timestamp" >= `2022-06-24T00:00:00Z`) THEN 1 END) AS "$key:periods%0",
+            COUNT(*) AS "$doc_count"
+     FROM "$source"
+     WHERE ((("$source"."timestamp" >= `2022-06-01T00:00:00Z`) AND ("$source"."timestamp" < `2022-06-24T00:00:00Z`)) OR ("$source"."timestamp" >= `2022-06-24T00:00:00Z`))
+     GROUP BY CASE WHEN (("$source"."timestamp" >= `2022-06-01T00:00:00Z`) AND ("$source"."timestamp" < `2022-06-24T00:00:00Z`)) THEN 0 WHEN ("$source"."timestamp" >= `2022-06-24T00:00:00Z`) THEN 1 END
+     ORDER BY "$key:periods%0" ASC
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:periods%0"
+  ) AS "$bucket:periods%0"
\ No newline at end of file
@@ -0,0 +1,24 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:classes%0" AS
+    (SELECT "$source"."class" AS "$key:classes%0",
+            COUNT(*) AS "$doc_count",
+            ({'count':COUNT("$source"."grade"),'min':MIN("$source"."grade"),'max':MAX("$source"."grade"),'avg':AVG("$source"."grade"),'sum':SUM("$source"."grade"),'sum_of_squares':SUM(("$source"."grade" * "$source"."grade")),'variance':VARIANCE_POP("$source"."grade"),'std_deviation':STDDEV_POP("$source"."grade")}) AS "grades_stats"
+     FROM "$source"
+     GROUP BY "$source"."class"
+     ORDER BY "$doc_count" DESC
+     LIMIT 10
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:classes%0"
+  ) AS "$bucket:classes%0"
\ No newline at end of file
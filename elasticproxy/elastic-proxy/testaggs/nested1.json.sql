@@ -0,0 +1,17 @@
+WITH
+  "$source" AS
+    (SELECT DISTINCT ON ("$source"."spans") *
+     FROM "table" AS "$source",
+          "$source"."spans" AS "$source:spans"
+     WHERE ((("$source:spans"."name" = 'GET /api') AND ("$source:spans"."duration" > 100)) AND ("$source"."service" = 'frontend'))
+    )
+
+SELECT
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$source"
+   LIMIT 10
+  ) AS "$hits"
\ No newline at end of file
@@ -0,0 +1,69 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:spans%0" AS
+    (SELECT COUNT("$source:spans") AS "$doc_count",
+            FALSE AS "$dummy$"
+     FROM "$source",
+          "$source"."spans" AS "$source:spans"
+     ORDER BY "$doc_count" DESC
+    ),
+
+  "$bucket:spans:names%0" AS
+    (SELECT "$source:spans"."name" AS "$key:spans:names%0",
+            COUNT("$source:spans") AS "$doc_count",
+            AVG("$source:spans"."duration") AS "avg_duration"
+     FROM "$source",
+          "$source"."spans" AS "$source:spans"
+     GROUP BY "$source:spans"."name"
+     ORDER BY "$doc_count" DESC
+     LIMIT 10
+    ),
+
+  "$bucket:spans:names:traces%0" AS
+    (SELECT "$source:spans"."name" AS "$key:spans:names%0",
+            COUNT(DISTINCT "$source"."spans") AS "$doc_count"
+     FROM "$source",
+          "$source"."spans" AS "$source:spans"
+     WHERE ("$source:spans"."name" IN (SELECT "$selection"."$key:spans:names%0"
+     FROM "$bucket:spans:names%0" AS "$selection"))
+     GROUP BY "$source:spans"."name"
+     ORDER BY "$doc_count" DESC
+    ),
+
+  "$bucket:spans:names:traces:services%0" AS
+    (SELECT "$source:spans"."name" AS "$key:spans:names%0",
+            "$source"."service" AS "$key:spans:names:traces:services%0",
+            COUNT(DISTINCT "$source"."spans") AS "$doc_count"
+     FROM "$source",
+          "$source"."spans" AS "$source:spans"
+     WHERE ("$source:spans"."name" IN (SELECT "$selection"."$key:spans:names%0"
+     FROM "$bucket:spans:names:traces%0" AS "$selection"))
+     GROUP BY "$source:spans"."name",
+              "$source"."service"
+     ORDER BY "$doc_count" DESC
+    )
+
+SELECT
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:spans%0"
+  ) AS "$bucket:spans%0",
+
+  (SELECT *
+   FROM "$bucket:spans:names%0"
+  ) AS "$bucket:spans:names%0",
+
+  (SELECT *
+   FROM "$bucket:spans:names:traces%0"
+  ) AS "$bucket:spans:names:traces%0",
+
+  (SELECT *
+   FROM "$bucket:spans:names:traces:services%0"
+  ) AS "$bucket:spans:names:traces:services%0"
\ No newline at end of file
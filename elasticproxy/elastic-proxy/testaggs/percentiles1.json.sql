@@ -0,0 +1,20 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:%0" AS
+    (SELECT ({'0':APPROX_PERCENTILE("$source"."load_time",0.95),'1':APPROX_PERCENTILE("$source"."load_time",0.99),'2':APPROX_PERCENTILE("$source"."load_time",0.999)}) AS "load_time_outlier",
+            FALSE AS "$dummy$"
+     FROM "$source"
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:%0"
+  ) AS "$bucket:%0"
\ No newline at end of file
@@ -0,0 +1,38 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:hosts%0" AS
+    (SELECT "$source"."host" AS "$key:hosts%0",
+            COUNT(*) AS "$doc_count"
+     FROM "$source"
+     GROUP BY "$source"."host"
+     ORDER BY "$doc_count" DESC
+     LIMIT 10
+    ),
+
+  "$bucket:hosts%1" AS
+    (SELECT "$source"."host" AS "$key:hosts%0",
+            "$source"."load_time" AS "$values:load_time_outlier%0",
+            COUNT("$source"."load_time") AS "$values:load_time_outlier%1"
+     FROM "$source"
+     WHERE ("$source"."host" IN (SELECT "$selection"."$key:hosts%0"
+     FROM "$bucket:hosts%0" AS "$selection"))
+     GROUP BY "$source"."host",
+              "$source"."load_time"
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:hosts%0"
+  ) AS "$bucket:hosts%0",
+
+  (SELECT *
+   FROM "$bucket:hosts%1"
+  ) AS "$bucket:hosts%1"
\ No newline at end of file
@@ -0,0 +1,24 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:latency%0" AS
+    (SELECT (CASE WHEN ("$source"."duration" < 100) THEN 0 WHEN (("$source"."duration" >= 100) AND ("$source"."duration" < 500)) THEN 1 WHEN ("$source"."duration" >= 500) THEN 2 END) AS "$key:latency%0",
+            COUNT(*) AS "$doc_count",
+            AVG("$source"."size") AS "avg_size"
+     FROM "$source"
+     WHERE ((("$source"."duration" < 100) OR (("$source"."duration" >= 100) AND ("$source"."duration" < 500))) OR ("$source"."duration" >= 500))
+     GROUP BY CASE WHEN ("$source"."duration" < 100) THEN 0 WHEN (("$source"."duration" >= 100) AND ("$source"."duration" < 500)) THEN 1 WHEN ("$source"."duration" >= 500) THEN 2 END
+     ORDER BY "$key:latency%0" ASC
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:latency%0"
+  ) AS "$bucket:latency%0"
\ No newline at end of file
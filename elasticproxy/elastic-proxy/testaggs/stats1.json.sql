@@ -0,0 +1,20 @@
+WITH
+  "$source" AS
+    (SELECT *
+     FROM "table" AS "$source"
+    ),
+
+  "$bucket:%0" AS
+    (SELECT ({'count':COUNT("$source"."grade"),'min':MIN("$source"."grade"),'max':MAX("$source"."grade"),'avg':AVG("$source"."grade"),'sum':SUM("$source"."grade")}) AS "grades_stats",
+            FALSE AS "$dummy$"
+     FROM "$source"
+    )
+
+SELECT 
+  (SELECT COUNT(*)
+   FROM "$source"
+  ) AS "$total_count",
+
+  (SELECT *
+   FROM "$bucket:%0"
+  ) AS "$bucket:%0"
\ No newline at end of file
@@ -0,0 +1 @@
+(((EQUALS_FUZZY("$source"."user"."id",'kimchy',2) AND (("$source"."host" LIKE 'w%') AND EQUALS_FUZZY("$source"."host",'web',1))) AND EQUALS_FUZZY_UNICODE("$source"."city",'Zürich',1)) AND ("$source"."tag" = 'ab'))
\ No newline at end of file
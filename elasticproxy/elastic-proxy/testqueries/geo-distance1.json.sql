@@ -0,0 +1 @@
+(GEO_DISTANCE("$source"."pin"."location"."lat","$source"."pin"."location"."lon",40,-70) <= 12000)
\ No newline at end of file
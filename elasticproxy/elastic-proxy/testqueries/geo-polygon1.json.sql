@@ -0,0 +1 @@
+((((CASE WHEN ((("$source"."person"."location"."lat" >= 30) AND ("$source"."person"."location"."lat" < 40)) AND ("$source"."person"."location"."lon" < ((1 * ("$source"."person"."location"."lat" - 40)) + -70))) THEN 1 ELSE 0 END + CASE WHEN ((("$source"."person"."location"."lat" >= 20) AND ("$source"."person"."location"."lat" < 30)) AND ("$source"."person"."location"."lon" < ((-1 * ("$source"."person"."location"."lat" - 30)) + -80))) THEN 1 ELSE 0 END) + CASE WHEN ((("$source"."person"."location"."lat" >= 20) AND ("$source"."person"."location"."lat" < 40)) AND ("$source"."person"."location"."lon" < ((0 * ("$source"."person"."location"."lat" - 20)) + -70))) THEN 1 ELSE 0 END) % 2) = 1)
\ No newline at end of file
@@ -0,0 +1 @@
+((("$source"."user"."id" LIKE 'ki%') AND ("$source"."host" ILIKE 'Web%')) AND ("$source"."path" ~ '^/var/log_'))
\ No newline at end of file
@@ -0,0 +1 @@
+(("$source"."user"."id" ~ '^(?i)(?s:k.*y\\.(?:[1-9]|1[0-2]))$') AND ("$source"."host" ~ '^(?s:web-[0-9]+(?:x\\+|.*))$'))
\ No newline at end of file
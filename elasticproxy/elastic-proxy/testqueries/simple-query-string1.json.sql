@@ -0,0 +1 @@
+(((("$source"."title" ~ '(^|[ \t])(?i)fried eggs([ \t]|$)') OR ("$source"."body" ~ '(^|[ \t])(?i)fried eggs([ \t]|$)')) AND ((("$source"."title" ~ '(^|[ \t])(?i)eggplant([ \t]|$)') OR ("$source"."body" ~ '(^|[ \t])(?i)eggplant([ \t]|$)')) OR (("$source"."title" ~ '(^|[ \t])(?i)potato([ \t]|$)') OR ("$source"."body" ~ '(^|[ \t])(?i)potato([ \t]|$)')))) AND (NOT (("$source"."title" ~ '(^|[ \t])(?i)frittata([ \t]|$)') OR ("$source"."body" ~ '(^|[ \t])(?i)frittata([ \t]|$)'))))
\ No newline at end of file
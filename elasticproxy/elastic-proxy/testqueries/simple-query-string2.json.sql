@@ -0,0 +1 @@
+(((("$source"."u_name" SIMILAR TO 'u_%') OR ("$source"."u_name" SIMILAR TO 'web%')) OR ("$source"."u_name" = 42)) OR ("$source"."u_name" = 'foo'))
\ No newline at end of file
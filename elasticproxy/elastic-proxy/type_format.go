@@ -37,19 +37,9 @@ func formatIn(key string, value any, mapping map[string]TypeMapping) (any, error
 		case time.Time:
 			return v.UTC(), nil
 		case string:
-			t, err := time.Parse(time.RFC3339Nano, v)
+			t, err := parseDateTime(v)
 			if err != nil {
-				t, err = time.Parse(time.RFC3339, v)
-				if err != nil {
-					now := time.Now()
-					if testNow != nil {
-						now = *testNow
-					}
-					t, err = ParseDateMath(v, now)
-					if err != nil {
-						return nil, err
-					}
-				}
+				return nil, err
 			}
 			return t.UTC(), nil
 		}
@@ -170,6 +160,23 @@ func mapType(key string, mapping map[string]TypeMapping) (*TypeMapping, bool) {
 	return nil, false
 }
 
+// parseDateTime parses an RFC3339 timestamp
+// or an Elastic date-math expression
+func parseDateTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			now := time.Now()
+			if testNow != nil {
+				now = *testNow
+			}
+			t, err = ParseDateMath(v, now)
+		}
+	}
+	return t, err
+}
+
 func format(key string, mapping map[string]TypeMapping) (string, bool) {
 	m, ok := mapType(key, mapping)
 	if ok {
@@ -178,6 +185,16 @@ func format(key string, mapping map[string]TypeMapping) (string, bool) {
 	return "", false
 }
 
+// isTimestampFormat returns whether
+// the type-format maps to a timestamp
+func isTimestampFormat(f string) bool {
+	switch f {
+	case "datetime", "unix_seconds", "unix_milli_seconds", "unix_micro_seconds", "unix_nano_seconds":
+		return true
+	}
+	return false
+}
+
 func formatOutRaw(value any, f string) (any, error) {
 	if f == "" {
 		return value, nil
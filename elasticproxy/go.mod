@@ -1,6 +1,6 @@
 module github.com/SnellerInc/sneller/elasticproxy
 
-go 1.20
+go 1.21
 
 require (
 	github.com/amazon-ion/ion-go v1.2.0
@@ -9,11 +9,17 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
 	github.com/yudai/gojsondiff v1.0.0
-	golang.org/x/crypto v0.12.0
-	golang.org/x/exp v0.0.0-20230810033253-352e893a4cad
+	golang.org/x/crypto v0.16.0
+	golang.org/x/exp v0.0.0-20231127185646-65229373498e
 )
 
 require (
+	github.com/dchest/siphash v1.2.3 // indirect
+	github.com/klauspost/compress v1.17.4 // indirect
+)
+
+require (
+	github.com/SnellerInc/sneller v0.0.0
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
@@ -24,6 +30,8 @@ require (
 	github.com/yudai/golcs v0.0.0-20170316035057-ecda9a501e82 // indirect
 	github.com/yudai/pp v2.0.1+incompatible // indirect
 	golang.org/x/net v0.14.0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
-	golang.org/x/text v0.12.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
+
+replace github.com/SnellerInc/sneller => ../
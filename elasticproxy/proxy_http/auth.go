@@ -0,0 +1,154 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/SnellerInc/sneller/auth"
+)
+
+// allTenants can be used in the tenants of an
+// index to allow all authenticated tenants
+const allTenants = "*"
+
+// configAuth enables multi-tenant authentication.
+// Callers authenticate using a bearer token or an
+// API key that is resolved to a tenant using the
+// same providers as snellerd (see auth.Parse).
+type configAuth struct {
+	// Provider is the specification of the
+	// provider (authorization endpoint or file)
+	Provider string `json:"provider"`
+	// ForwardToken forwards the token of the
+	// caller to Sneller (instead of using the
+	// token of the Sneller configuration)
+	ForwardToken bool `json:"forwardToken,omitempty"`
+
+	provider auth.Provider
+}
+
+func (ca *configAuth) UnmarshalJSON(data []byte) error {
+	type _configAuth configAuth
+	if err := json.Unmarshal(data, (*_configAuth)(ca)); err != nil {
+		return err
+	}
+	if ca.Provider == "" {
+		return errors.New("field 'provider': cannot be empty")
+	}
+	p, err := auth.Parse(ca.Provider)
+	if err != nil {
+		return fmt.Errorf("field 'provider': %s", err)
+	}
+	ca.provider = p
+	return nil
+}
+
+// credentials returns the bearer token or API key
+// in the authorization header of the request
+func credentials(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok {
+		return "", false
+	}
+	switch strings.ToLower(scheme) {
+	case "bearer", "apikey":
+		token = strings.TrimSpace(token)
+		return token, token != ""
+	}
+	return "", false
+}
+
+// Authorize authenticates the request and checks
+// if the caller is allowed to access the selected
+// indexes. It writes the error response when the
+// request isn't authorized.
+func (c *HandlerContext) Authorize() bool {
+	r := c.Request
+	if c.Config.Auth == nil {
+		if c.NeedsAuthentication() {
+			username, password, ok := r.BasicAuth()
+			if !ok || !c.Authenticate(username, password) {
+				log.Printf("%s %v[%s]: unauthorized", r.Method, r.URL, r.RemoteAddr)
+				c.Writer.WriteHeader(http.StatusUnauthorized)
+				return false
+			}
+		}
+		return true
+	}
+
+	if c.Tenant == nil {
+		token, ok := credentials(r)
+		if !ok {
+			c.AddHeader("WWW-Authenticate", `Bearer realm="security"`)
+			c.AddHeader("WWW-Authenticate", "ApiKey")
+			c.Error(http.StatusUnauthorized, "missing bearer token or API key")
+			return false
+		}
+		tenant, err := c.Config.Auth.provider.Authorize(r.Context(), token)
+		if err != nil {
+			c.Error(http.StatusUnauthorized, "unable to authenticate: %v", err)
+			return false
+		}
+		c.Tenant, c.token = tenant, token
+
+		if c.Config.Auth.ForwardToken {
+			// the mappings are derived from the
+			// tables of the tenant, so they are
+			// cached per tenant
+			c.Memcache.TenantID += "/" + tenant.ID()
+			if c.Cache != dummyCache {
+				c.Cache = c.newMappingCache()
+			}
+		}
+	}
+
+	for _, index := range c.selected {
+		if !c.IndexAllowed(index) {
+			c.Error(http.StatusForbidden, "tenant %q isn't allowed to access index %q", c.Tenant.ID(), index)
+			return false
+		}
+	}
+	return true
+}
+
+// IndexAllowed returns true if the (authenticated)
+// caller is allowed to access the index
+func (c *HandlerContext) IndexAllowed(index string) bool {
+	if c.Config.Auth == nil {
+		return true
+	}
+	m, ok := c.Config.Mapping[index]
+	if !ok || c.Tenant == nil {
+		return false
+	}
+	return slices.Contains(m.Tenants, allTenants) || slices.Contains(m.Tenants, c.Tenant.ID())
+}
+
+// SnellerToken returns the token that is used to
+// access Sneller (the token of the caller when the
+// token is forwarded)
+func (c *HandlerContext) SnellerToken() string {
+	if c.Config.Auth != nil && c.Config.Auth.ForwardToken {
+		return c.token
+	}
+	return c.Config.Sneller.Token
+}
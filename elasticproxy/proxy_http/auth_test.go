@@ -0,0 +1,159 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SnellerInc/sneller/db"
+)
+
+type testTenant struct {
+	db.Tenant
+	id string
+}
+
+func (t *testTenant) ID() string { return t.id }
+
+// testProvider maps tokens to tenant IDs
+type testProvider map[string]string
+
+func (p testProvider) Authorize(_ context.Context, token string) (db.Tenant, error) {
+	id, ok := p[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &testTenant{id: id}, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	config := &Config{
+		Auth: &configAuth{
+			provider: testProvider{"token-a": "a", "token-b": "b", "key-c": "c"},
+		},
+		Mapping: map[string]*mappingEntry{
+			"logs-a": {Tenants: []string{"a"}},
+			"logs-b": {Tenants: []string{"b", "c"}},
+			"shared": {Tenants: []string{"*"}},
+			"closed": {},
+		},
+	}
+	config.Sneller.Token = "static"
+
+	tests := []struct {
+		name    string
+		auth    string
+		indices []string
+		status  int
+	}{
+		{name: "no-credentials", indices: []string{"logs-a"}, status: http.StatusUnauthorized},
+		{name: "basic", auth: "Basic dXNlcjpwYXNz", indices: []string{"logs-a"}, status: http.StatusUnauthorized},
+		{name: "invalid-token", auth: "Bearer token-x", indices: []string{"logs-a"}, status: http.StatusUnauthorized},
+		{name: "own-index", auth: "Bearer token-a", indices: []string{"logs-a"}, status: http.StatusOK},
+		{name: "api-key", auth: "ApiKey key-c", indices: []string{"logs-b"}, status: http.StatusOK},
+		{name: "other-index", auth: "Bearer token-a", indices: []string{"logs-b"}, status: http.StatusForbidden},
+		{name: "all-indices", auth: "Bearer token-b", indices: []string{"logs-b", "logs-a"}, status: http.StatusForbidden},
+		{name: "shared", auth: "Bearer token-b", indices: []string{"logs-b", "shared"}, status: http.StatusOK},
+		{name: "without-tenants", auth: "Bearer token-a", indices: []string{"closed"}, status: http.StatusForbidden},
+	}
+
+	for i := range tests {
+		test := &tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/logs/_search", nil)
+			if test.auth != "" {
+				r.Header.Set("Authorization", test.auth)
+			}
+			w := httptest.NewRecorder()
+			c := NewHandlerContext(config, http.DefaultClient, w, r, false, func(string, ...any) {})
+			for _, index := range test.indices {
+				if !c.SelectIndex(index) {
+					t.Fatalf("index %q not selected", index)
+				}
+			}
+			ok := c.Authorize()
+			if ok != (test.status == http.StatusOK) {
+				t.Fatalf("unexpected result %v (status %d)", ok, w.Code)
+			}
+			if w.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestForwardToken(t *testing.T) {
+	config := &Config{
+		Auth: &configAuth{
+			provider: testProvider{"token-a": "a"},
+		},
+		Mapping: map[string]*mappingEntry{
+			"logs-a": {Tenants: []string{"a"}},
+			"logs-b": {Tenants: []string{"b"}},
+		},
+	}
+	config.Sneller.Token = "static"
+
+	r := httptest.NewRequest(http.MethodPost, "/logs-a/_search", nil)
+	r.Header.Set("Authorization", "Bearer token-a")
+	c := NewHandlerContext(config, http.DefaultClient, httptest.NewRecorder(), r, false, func(string, ...any) {})
+	c.SelectIndex("logs-a")
+	if !c.Authorize() {
+		t.Fatal("expected the request to be authorized")
+	}
+	if got := c.SnellerToken(); got != "static" {
+		t.Errorf("expected the static token, got %q", got)
+	}
+	if !c.IndexAllowed("logs-a") || c.IndexAllowed("logs-b") {
+		t.Error("only index logs-a should be allowed")
+	}
+
+	config.Auth.ForwardToken = true
+	c = NewHandlerContext(config, http.DefaultClient, httptest.NewRecorder(), r, false, func(string, ...any) {})
+	c.Memcache.TenantID = "host"
+	c.SelectIndex("logs-a")
+	if !c.Authorize() {
+		t.Fatal("expected the request to be authorized")
+	}
+	if got := c.SnellerToken(); got != "token-a" {
+		t.Errorf("expected the forwarded token, got %q", got)
+	}
+	if c.Memcache.TenantID != "host/a" {
+		t.Errorf("expected the mapping cache to be scoped to the tenant, got %q", c.Memcache.TenantID)
+	}
+}
+
+func TestConfigAuth(t *testing.T) {
+	var config Config
+	data := `{"auth":{"provider":"https://auth.example.com/token","forwardToken":true},"mapping":{"logs":{"table":"logs","tenants":["a"]}}}`
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Auth == nil || config.Auth.provider == nil || !config.Auth.ForwardToken {
+		t.Fatalf("unexpected auth configuration %+v", config.Auth)
+	}
+	if got := config.Mapping["logs"].Tenants; len(got) != 1 || got[0] != "a" {
+		t.Errorf("unexpected tenants %v", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"auth":{}}`), new(Config)); err == nil {
+		t.Error("expected an error without a provider")
+	}
+}
@@ -31,9 +31,11 @@ type Config struct {
 		ESPassword string `json:"esPassword,omitempty"`
 		IgnoreCert bool   `json:"ignoreCert,omitempty"`
 	} `json:"elastic,omitempty"`
+	Auth               *configAuth              `json:"auth,omitempty"`
 	Sneller            configSneller            `json:"sneller,omitempty"`
 	Mapping            map[string]*mappingEntry `json:"mapping"`
 	CompareWithElastic bool                     `json:"compareWithElastic,omitempty"`
+	ContextSecret      string                   `json:"contextSecret,omitempty"`
 }
 
 const elasticMappingLimitMax = 1_000_000
@@ -80,6 +82,10 @@ type mappingEntry struct {
 	IgnoreTotalHits        bool                                 `json:"ignoreTotalHits"`
 	IgnoreSumOtherDocCount bool                                 `json:"ignoreSumOtherDocCount"`
 	TypeMapping            map[string]elastic_proxy.TypeMapping `json:"typeMapping,omitempty"`
+	// Tenants are the tenants that are allowed to
+	// access the index (only used with multi-tenant
+	// authentication, "*" allows all tenants)
+	Tenants []string `json:"tenants,omitempty"`
 }
 
 type mappingEntrySource struct {
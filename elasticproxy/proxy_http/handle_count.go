@@ -15,9 +15,6 @@
 package proxy_http
 
 import (
-	"log"
-	"net/http"
-
 	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
 
 	"github.com/gorilla/mux"
@@ -32,13 +29,8 @@ func CountProxy(c *HandlerContext) (handled bool) {
 		return
 	}
 
-	if c.NeedsAuthentication() {
-		username, password, ok := c.Request.BasicAuth()
-		if !ok || !c.Authenticate(username, password) {
-			log.Printf("%s %v[%s]: unauthorized", c.Request.Method, c.Request.URL, c.Request.RemoteAddr)
-			c.Writer.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+	if !c.Authorize() {
+		return
 	}
 
 	if !c.HasSnellerEndpoint() {
@@ -0,0 +1,92 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
+
+	"github.com/gorilla/mux"
+)
+
+// FieldCapsProxy handles the field capabilities API.
+// The field types are derived from the Elastic mapping
+// (see MappingProxy) and the type mapping of the index.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-field-caps.html
+func FieldCapsProxy(c *HandlerContext) (handled bool) {
+	indices := strings.Split(mux.Vars(c.Request)["index"], ",")
+	sort.Strings(indices)
+
+	// only handle the request if all indices are configured
+	for _, index := range indices {
+		if !c.SelectIndex(index) {
+			return false
+		}
+	}
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	var fields []string
+	if fieldsText := c.Request.URL.Query().Get("fields"); fieldsText != "" {
+		fields = strings.Split(fieldsText, ",")
+	}
+	if c.Request.Body != nil {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.InternalServerError("error reading body: %v", err)
+			return
+		}
+		if len(body) > 0 {
+			var request struct {
+				Fields []string `json:"fields"`
+			}
+			if err := json.Unmarshal(body, &request); err != nil {
+				c.BadRequest("error decoding body: %v", err)
+				return
+			}
+			fields = append(fields, request.Fields...)
+		}
+	}
+	if len(fields) == 0 {
+		c.BadRequest("specified fields can't be empty")
+		return
+	}
+
+	perIndex := make(map[string]elastic_proxy.FieldCapabilities, len(indices))
+	for _, index := range indices {
+		c.SelectIndex(index)
+		elasticMapping := fetchElasticMapping(c, index)
+		if elasticMapping == nil {
+			// break on any error
+			return
+		}
+		perIndex[index] = elastic_proxy.NewFieldCapabilities(elasticMapping, c.Mapping.TypeMapping, fields)
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	writeResult(c, map[string]any{
+		"indices": indices,
+		"fields":  elastic_proxy.MergeFieldCapabilities(perIndex),
+	})
+	return
+}
@@ -36,25 +36,14 @@ func MappingProxy(c *HandlerContext) bool {
 		if !c.SelectIndex(index) {
 			return false
 		}
-
-		elasticMapping, err := c.Cache.Fetch(index)
-		if err != nil {
-			c.VerboseLog("cannot fetch Elastic mapping from cache: %s", err)
+		if c.Config.Auth != nil && !c.Authorize() {
+			return true
 		}
 
+		elasticMapping := fetchElasticMapping(c, index)
 		if elasticMapping == nil {
-			elasticMapping = obtainElasticMapping(c)
-			if elasticMapping == nil {
-				// break on any error
-				return true
-			}
-
-			err = c.Cache.Store(index, elasticMapping)
-			if err != nil {
-				c.VerboseLog("cannot store Elastic mapping in cache: %s", err)
-			}
-		} else {
-			c.VerboseLog("fetched Elastic mapping from cache")
+			// break on any error
+			return true
 		}
 
 		result[index] = elasticMapping
@@ -64,6 +53,33 @@ func MappingProxy(c *HandlerContext) bool {
 	return true
 }
 
+// fetchElasticMapping returns the Elastic mapping of
+// the (selected) index from the cache or derives it
+// from the data. It returns nil (and writes the error)
+// when the mapping can't be determined.
+func fetchElasticMapping(c *HandlerContext, index string) *elastic_proxy.ElasticMapping {
+	elasticMapping, err := c.Cache.Fetch(index)
+	if err != nil {
+		c.VerboseLog("cannot fetch Elastic mapping from cache: %s", err)
+	}
+
+	if elasticMapping != nil {
+		c.VerboseLog("fetched Elastic mapping from cache")
+		return elasticMapping
+	}
+
+	elasticMapping = obtainElasticMapping(c)
+	if elasticMapping == nil {
+		return nil
+	}
+
+	err = c.Cache.Store(index, elasticMapping)
+	if err != nil {
+		c.VerboseLog("cannot store Elastic mapping in cache: %s", err)
+	}
+	return elasticMapping
+}
+
 func obtainElasticMapping(c *HandlerContext) *elastic_proxy.ElasticMapping {
 	// Query Sneller engine
 	if !c.HasSnellerEndpoint() {
@@ -71,6 +87,13 @@ func obtainElasticMapping(c *HandlerContext) *elastic_proxy.ElasticMapping {
 		return nil
 	}
 
+	// prefer the schema stored in the table indexes;
+	// it is available without scanning any data
+	if fields := fetchStoredSchema(c); fields != nil {
+		c.VerboseLog("using stored schema")
+		return elastic_proxy.DataShapeToElasticMapping(fields)
+	}
+
 	var from strings.Builder
 	if len(c.Mapping.Sources) > 1 {
 		from.WriteRune('(')
@@ -110,6 +133,36 @@ func obtainElasticMapping(c *HandlerContext) *elastic_proxy.ElasticMapping {
 	return elastic_proxy.DataShapeToElasticMapping(fields)
 }
 
+// fetchStoredSchema returns the union of the stored schemas
+// of all the sources of the current mapping, or nil if any
+// of the sources does not have a complete stored schema
+func fetchStoredSchema(c *HandlerContext) map[string]any {
+	var result elastic_proxy.Schema
+	for _, s := range c.Mapping.Sources {
+		if s.Database == "" {
+			return nil
+		}
+		schema, err := elastic_proxy.FetchSchema(
+			c.Client,
+			c.Config.Sneller.EndPoint,
+			c.SnellerToken(),
+			s.Database,
+			s.Table)
+		if err != nil {
+			c.VerboseLog("cannot fetch stored schema for %s: %s", s.SQL(), err)
+			return nil
+		}
+		if schema.Incomplete {
+			// some of the data has no stored schema,
+			// so sample all the sources instead
+			c.VerboseLog("stored schema for %s is incomplete", s.SQL())
+			return nil
+		}
+		result.Merge(schema)
+	}
+	return result.Fields
+}
+
 func executeQuery(c *HandlerContext, SQL string) bool {
 	defer func() {
 		duration := time.Since(c.Logging.Start)
@@ -123,7 +176,7 @@ func executeQuery(c *HandlerContext, SQL string) bool {
 
 	c.Logging.SQL = SQL
 
-	tokenLast4 := c.Config.Sneller.Token
+	tokenLast4 := c.SnellerToken()
 	if len(tokenLast4) > 4 {
 		tokenLast4 = tokenLast4[len(tokenLast4)-4:]
 	}
@@ -138,7 +191,7 @@ func executeQuery(c *HandlerContext, SQL string) bool {
 	response, err := elastic_proxy.ExecuteQuery(
 		c.Client,
 		c.Config.Sneller.EndPoint,
-		c.Config.Sneller.Token,
+		c.SnellerToken(),
 		c.Logging.SQL)
 
 	if err != nil {
@@ -0,0 +1,232 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultMaxConcurrentSearches is the number of
+// searches of a multi search that run concurrently
+// (unless overridden by 'max_concurrent_searches')
+const defaultMaxConcurrentSearches = 5
+
+// msearchItem is a single search of a multi search
+type msearchItem struct {
+	Index string
+	Body  []byte
+}
+
+// parseMultiSearch parses the newline-delimited
+// body of a multi search. Each search consists of
+// a header (with the index) and the search body.
+func parseMultiSearch(body []byte, defaultIndex string) ([]msearchItem, error) {
+	var lines [][]byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no requests added")
+	}
+	if len(lines)%2 != 0 {
+		return nil, errors.New("the msearch request must be terminated by a newline [\\n]")
+	}
+
+	items := make([]msearchItem, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		var header struct {
+			Index json.RawMessage `json:"index"`
+		}
+		if err := json.Unmarshal(lines[i], &header); err != nil {
+			return nil, fmt.Errorf("invalid header of search %d: %w", i/2, err)
+		}
+		index := defaultIndex
+		if len(header.Index) > 0 {
+			var indices []string
+			if err := json.Unmarshal(header.Index, &indices); err != nil {
+				var name string
+				if err := json.Unmarshal(header.Index, &name); err != nil {
+					return nil, fmt.Errorf("invalid index in header of search %d: %w", i/2, err)
+				}
+				indices = strings.Split(name, ",")
+			}
+			if len(indices) != 1 {
+				return nil, fmt.Errorf("search %d should use a single index", i/2)
+			}
+			index = indices[0]
+		}
+		if index == "" {
+			return nil, fmt.Errorf("search %d doesn't specify an index", i/2)
+		}
+		items = append(items, msearchItem{Index: index, Body: lines[i+1]})
+	}
+	return items, nil
+}
+
+// MultiSearchProxy handles the multi search API.
+// All searches run through the regular search
+// path and are only handled by the proxy if all
+// searched indices are configured.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/search-multi-search.html
+func MultiSearchProxy(c *HandlerContext) (handled bool) {
+	if c.Request.Body == nil {
+		return false
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.InternalServerError("error reading body: %v", err)
+		return true
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	items, err := parseMultiSearch(body, mux.Vars(c.Request)["index"])
+	if err != nil {
+		// let Elastic deal with it (or report the error)
+		return false
+	}
+
+	// only handle the request if all indices are configured
+	for _, item := range items {
+		if !c.SelectIndex(item.Index) {
+			return false
+		}
+	}
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	maxConcurrent := defaultMaxConcurrentSearches
+	if text := c.Request.URL.Query().Get("max_concurrent_searches"); text != "" {
+		maxConcurrent, err = strconv.Atoi(text)
+		if err != nil || maxConcurrent <= 0 {
+			c.BadRequest("invalid max_concurrent_searches query parameter %q", text)
+			return
+		}
+	}
+
+	responses := make([]any, len(items))
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			responses[i] = subSearch(c, &items[i])
+		}(i)
+	}
+	wg.Wait()
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	writeResult(c, map[string]any{
+		"took":      time.Since(c.Logging.Start).Milliseconds(),
+		"responses": responses,
+	})
+	return
+}
+
+// subSearch runs a single search of a multi search
+// and returns its response (or error)
+func subSearch(c *HandlerContext, item *msearchItem) any {
+	r, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, "/"+item.Index+"/_search", bytes.NewReader(item.Body))
+	if err != nil {
+		return msearchError(http.StatusInternalServerError, err.Error())
+	}
+	r.Header = c.Request.Header.Clone()
+	r.Host = c.Request.Host
+	r.RemoteAddr = c.Request.RemoteAddr
+	r = mux.SetURLVars(r, map[string]string{"index": item.Index})
+
+	w := &responseBuffer{header: make(http.Header)}
+	sub := *c
+	sub.Request = r
+	sub.Writer = w
+	sub.Logging = newLogging(r)
+	sub.Logging.TenantID = c.Logging.TenantID
+	if !search(&sub, false) {
+		return msearchError(http.StatusNotFound, fmt.Sprintf("index %q is not handled by the proxy", item.Index))
+	}
+
+	if w.status != http.StatusOK {
+		reason := strings.TrimSpace(w.body.String())
+		if reason == "" {
+			reason = http.StatusText(w.status)
+		}
+		return msearchError(w.status, reason)
+	}
+
+	var result map[string]any
+	dec := json.NewDecoder(&w.body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return msearchError(http.StatusInternalServerError, err.Error())
+	}
+	result["status"] = http.StatusOK
+	return result
+}
+
+func msearchError(status int, reason string) map[string]any {
+	return map[string]any{
+		"error": map[string]any{
+			"type":   "exception",
+			"reason": reason,
+		},
+		"status": status,
+	}
+}
+
+// responseBuffer captures the response
+// of a search within a multi search
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+func (w *responseBuffer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseBuffer) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMultiSearch(t *testing.T) {
+	body := `{"index":"logs"}
+{"query":{"match_all":{}}}
+{}
+{"size":0}
+
+{"index":["metrics"],"preference":"1234"}
+{"size":10}
+`
+	got, err := parseMultiSearch([]byte(body), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []msearchItem{
+		{Index: "logs", Body: []byte(`{"query":{"match_all":{}}}`)},
+		{Index: "default", Body: []byte(`{"size":0}`)},
+		{Index: "metrics", Body: []byte(`{"size":10}`)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+
+	invalid := []struct {
+		body         string
+		defaultIndex string
+	}{
+		{body: "", defaultIndex: "default"},
+		{body: "{}\n", defaultIndex: "default"},
+		{body: "{}\n{}\n", defaultIndex: ""},
+		{body: `{"index":"a,b"}` + "\n{}\n", defaultIndex: ""},
+		{body: `{"index":["a","b"]}` + "\n{}\n", defaultIndex: ""},
+		{body: "not json\n{}\n", defaultIndex: "default"},
+	}
+	for _, tc := range invalid {
+		if _, err := parseMultiSearch([]byte(tc.body), tc.defaultIndex); err == nil {
+			t.Errorf("%q: expected an error", tc.body)
+		}
+	}
+}
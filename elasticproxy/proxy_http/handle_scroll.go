@@ -0,0 +1,476 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SnellerInc/sneller/db"
+	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
+
+	"github.com/gorilla/mux"
+)
+
+// searchContext is the state of a scroll or a
+// point-in-time. The state is encoded in the
+// scroll or PIT id, so the proxy doesn't need
+// to store it and it works with multiple proxy
+// instances. The id is signed, so it can't be
+// altered by the client.
+//
+// The context is pinned to a snapshot of the
+// indexes of the tables that is saved when the
+// context is opened, so the searches return
+// the same results when data is added to the
+// tables. The snapshot expires after
+// db.SnapshotMaxAge.
+type searchContext struct {
+	Index    string `json:"index"`
+	Snapshot string `json:"snapshot"`
+	Created  int64  `json:"created"` // unix milliseconds
+	Expires  int64  `json:"expires"` // unix milliseconds
+
+	// search request (scroll only)
+	Body     json.RawMessage               `json:"body,omitempty"`
+	Params   string                        `json:"params,omitempty"`
+	Position *elastic_proxy.ScrollPosition `json:"position,omitempty"`
+}
+
+var (
+	errSearchContextMissing = errors.New("search context is missing (it expired)")
+	errInvalidSearchContext = errors.New("invalid search context")
+	errNotSearchContext     = errors.New("not a search context of the proxy")
+)
+
+// newSearchContext creates a context for the
+// currently selected index and pins it to a
+// new snapshot of the indexes of the tables
+func newSearchContext(c *HandlerContext, keepAlive string) (*searchContext, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	sc := searchContext{
+		Index:    c.Logging.Index,
+		Snapshot: hex.EncodeToString(id[:]),
+		Created:  time.Now().UnixMilli(),
+	}
+	if err := sc.keepAlive(keepAlive); err != nil {
+		return nil, err
+	}
+	if _, err := searchContextKey(c); err != nil {
+		return nil, err
+	}
+	ts := make([]elastic_proxy.TableSource, len(c.Mapping.Sources))
+	for i, s := range c.Mapping.Sources {
+		ts[i] = elastic_proxy.TableSource{Database: s.Database, Table: s.Table}
+	}
+	err := elastic_proxy.PinSnapshot(c.Client, c.Config.Sneller.EndPoint, c.SnellerToken(), ts, sc.Snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("cannot pin index snapshot: %w", err)
+	}
+	return &sc, nil
+}
+
+// keepAlive extends the expiration time; the
+// context can't outlive its snapshot
+func (sc *searchContext) keepAlive(keepAlive string) error {
+	d, err := parseKeepAlive(keepAlive)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(d).UnixMilli()
+	if expires > sc.Created+db.SnapshotMaxAge.Milliseconds() {
+		return fmt.Errorf("keep-alive %s exceeds the maximum lifetime (%s) of a search context", keepAlive, db.SnapshotMaxAge)
+	}
+	sc.Expires = expires
+	return nil
+}
+
+// searchContextKey returns the key that is
+// used to sign the search contexts
+func searchContextKey(c *HandlerContext) ([]byte, error) {
+	secret := c.Config.ContextSecret
+	if secret == "" {
+		secret = c.Config.Sneller.Token
+	}
+	if secret == "" {
+		return nil, errors.New("no secret configured to sign search contexts (set 'contextSecret')")
+	}
+	return []byte(secret), nil
+}
+
+func signSearchContext(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// encode encodes the context as the
+// (signed) scroll or PIT id
+func (sc *searchContext) encode(key []byte) string {
+	data, _ := json.Marshal(sc)
+	return base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(signSearchContext(key, data))
+}
+
+// decodeSearchContext decodes the scroll or PIT
+// id. It returns errNotSearchContext if the id
+// wasn't created by the proxy (i.e. it's an Elastic
+// id) and errInvalidSearchContext if the signature
+// of the id is invalid.
+func decodeSearchContext(c *HandlerContext, id string) (*searchContext, error) {
+	payload, signature, ok := strings.Cut(id, ".")
+	if !ok {
+		return nil, errNotSearchContext
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errNotSearchContext
+	}
+	var sc searchContext
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, errNotSearchContext
+	}
+	if sc.Index == "" || sc.Snapshot == "" {
+		return nil, errNotSearchContext
+	}
+	key, err := searchContextKey(c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSearchContext, err)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, signSearchContext(key, data)) {
+		return nil, errInvalidSearchContext
+	}
+	return &sc, nil
+}
+
+// rejectSearchContext handles the error returned by
+// decodeSearchContext. Ids with an invalid signature
+// are rejected; other ids are forwarded (so it
+// returns false).
+func rejectSearchContext(c *HandlerContext, err error) (handled bool) {
+	if errors.Is(err, errInvalidSearchContext) {
+		c.BadRequest("%v", err)
+		return true
+	}
+	return false
+}
+
+// validate checks if the context didn't expire
+func (sc *searchContext) validate() error {
+	if time.Now().UnixMilli() > sc.Expires {
+		return errSearchContextMissing
+	}
+	return nil
+}
+
+// selectSearchContext selects the index of the
+// context and checks if it can still be used.
+// It returns false when the request has been
+// handled (or should be forwarded).
+func selectSearchContext(c *HandlerContext, sc *searchContext) (handled, ok bool) {
+	if !c.SelectIndex(sc.Index) {
+		return false, false
+	}
+	if !checkSearchAccess(c) {
+		return true, false
+	}
+	if err := sc.validate(); err != nil {
+		c.NotFound("%v", err)
+		return true, false
+	}
+	return true, true
+}
+
+// peekBody reads the request body and restores
+// it, so the request can still be forwarded
+func peekBody(c *HandlerContext) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// parseKeepAlive parses an Elastic time value
+// (i.e. "1m" or "30s")
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/api-conventions.html#time-units
+func parseKeepAlive(text string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"nanos", time.Nanosecond},
+		{"micros", time.Microsecond},
+		{"ms", time.Millisecond},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+	}
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(text, u.suffix); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				break
+			}
+			return time.Duration(n) * u.unit, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid keep-alive %q", text)
+}
+
+// OpenPointInTimeProxy opens a point-in-time
+// for the index that can be used in searches
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/point-in-time-api.html
+func OpenPointInTimeProxy(c *HandlerContext) (handled bool) {
+	handled = true
+
+	// only handle the configured index
+	if !c.SelectIndex(mux.Vars(c.Request)["index"]) {
+		return false
+	}
+
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	keepAlive := c.Request.URL.Query().Get("keep_alive")
+	if keepAlive == "" {
+		c.BadRequest("[keep_alive] is required")
+		return
+	}
+	sc, err := newSearchContext(c, keepAlive)
+	if err != nil {
+		c.BadRequest("cannot open point-in-time: %v", err)
+		return
+	}
+
+	c.AddHeader("Content-Type", "application/json")
+	key, _ := searchContextKey(c)
+	writeResult(c, map[string]any{"id": sc.encode(key)})
+	return
+}
+
+// ClosePointInTimeProxy closes a point-in-time.
+// Contexts aren't stored, so there is nothing
+// to free.
+func ClosePointInTimeProxy(c *HandlerContext) (handled bool) {
+	var request struct {
+		ID string `json:"id"`
+	}
+	body, err := peekBody(c)
+	if err != nil || json.Unmarshal(body, &request) != nil {
+		return false
+	}
+	sc, err := decodeSearchContext(c, request.ID)
+	if err != nil {
+		return rejectSearchContext(c, err)
+	}
+	if !c.SelectIndex(sc.Index) {
+		return false
+	}
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	c.AddHeader("Content-Type", "application/json")
+	writeResult(c, map[string]any{"succeeded": true, "num_freed": 1})
+	return
+}
+
+// PointInTimeSearchProxy searches within
+// a point-in-time (the index is determined
+// by the point-in-time)
+func PointInTimeSearchProxy(c *HandlerContext) (handled bool) {
+	body, err := peekBody(c)
+	if err != nil {
+		c.InternalServerError("error reading body: %v", err)
+		return true
+	}
+	var request struct {
+		PIT *elastic_proxy.PointInTime `json:"pit"`
+	}
+	if json.Unmarshal(body, &request) != nil || request.PIT == nil {
+		return false
+	}
+	sc, err := decodeSearchContext(c, request.PIT.ID)
+	if err != nil {
+		return rejectSearchContext(c, err)
+	}
+	if sc.Body != nil {
+		return false
+	}
+
+	handled, ok := selectSearchContext(c, sc)
+	if !ok {
+		return
+	}
+
+	pq := prepareQuery(c)
+	if pq == nil {
+		return
+	}
+	if pq.ej.PIT.KeepAlive != "" {
+		if err := sc.keepAlive(pq.ej.PIT.KeepAlive); err != nil {
+			c.BadRequest("%v", err)
+			return
+		}
+	}
+
+	searchWithContext(c, pq, false, sc)
+	return
+}
+
+// ScrollProxy returns the next page of a scroll
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/scroll-api.html
+func ScrollProxy(c *HandlerContext) (handled bool) {
+	var request struct {
+		Scroll   string `json:"scroll"`
+		ScrollID string `json:"scroll_id"`
+	}
+	body, err := peekBody(c)
+	if err != nil {
+		c.InternalServerError("error reading body: %v", err)
+		return true
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return false
+		}
+	}
+	q := c.Request.URL.Query()
+	if scroll := q.Get("scroll"); scroll != "" {
+		request.Scroll = scroll
+	}
+	if scrollID := mux.Vars(c.Request)["scroll_id"]; scrollID != "" {
+		request.ScrollID = scrollID
+	} else if scrollID := q.Get("scroll_id"); scrollID != "" {
+		request.ScrollID = scrollID
+	}
+
+	sc, err := decodeSearchContext(c, request.ScrollID)
+	if err != nil {
+		return rejectSearchContext(c, err)
+	}
+	if sc.Body == nil {
+		return false
+	}
+
+	handled, ok := selectSearchContext(c, sc)
+	if !ok {
+		return
+	}
+
+	if request.Scroll != "" {
+		if err := sc.keepAlive(request.Scroll); err != nil {
+			c.BadRequest("%v", err)
+			return
+		}
+	}
+
+	// rebuild the original search request
+	// (the same way as prepareQuery does)
+	pq := proxyQuery{body: sc.Body}
+	params, err := url.ParseQuery(sc.Params)
+	if err != nil {
+		c.BadRequest("error decoding query parameters: %v", err)
+		return
+	}
+	c.Logging.QueryParams = params
+	if err := parseQueryParams(params, &pq.ej); err != nil {
+		c.BadRequest("error decoding query parameters: %v", err)
+		return
+	}
+	if err := json.Unmarshal(sc.Body, &c.Logging.Request); err != nil {
+		c.BadRequest("invalid JSON request: %v", err)
+		return
+	}
+	if err := json.Unmarshal(sc.Body, &pq.ej); err != nil {
+		c.BadRequest("error decoding body: %v", err)
+		return
+	}
+
+	// aggregations are only returned for the first page
+	pq.ej.Aggregations = nil
+	pq.ej.ScrollPosition = sc.Position
+
+	searchWithContext(c, &pq, false, sc)
+	return
+}
+
+// ClearScrollProxy clears a scroll. Contexts
+// aren't stored, so there is nothing to free.
+func ClearScrollProxy(c *HandlerContext) (handled bool) {
+	var request struct {
+		ScrollID json.RawMessage `json:"scroll_id"`
+	}
+	body, err := peekBody(c)
+	if err != nil || json.Unmarshal(body, &request) != nil {
+		return false
+	}
+	var ids []string
+	if err := json.Unmarshal(request.ScrollID, &ids); err != nil {
+		var id string
+		if err := json.Unmarshal(request.ScrollID, &id); err != nil {
+			return false
+		}
+		ids = []string{id}
+	}
+	if len(ids) == 0 {
+		return false
+	}
+
+	// all scroll ids should be created by the proxy
+	for _, id := range ids {
+		sc, err := decodeSearchContext(c, id)
+		if err != nil {
+			return rejectSearchContext(c, err)
+		}
+		if !c.SelectIndex(sc.Index) {
+			return false
+		}
+	}
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	c.AddHeader("Content-Type", "application/json")
+	writeResult(c, map[string]any{"succeeded": true, "num_freed": len(ids)})
+	return
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
+)
+
+func TestParseKeepAlive(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Duration
+	}{
+		{"1d", 24 * time.Hour},
+		{"2h", 2 * time.Hour},
+		{"5m", 5 * time.Minute},
+		{"30s", 30 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"10micros", 10 * time.Microsecond},
+		{"100nanos", 100 * time.Nanosecond},
+	}
+	for _, tc := range tests {
+		got, err := parseKeepAlive(tc.text)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.text, err)
+		} else if got != tc.want {
+			t.Errorf("%q: got %v, expected %v", tc.text, got, tc.want)
+		}
+	}
+
+	for _, text := range []string{"", "1", "m", "-1m", "0s", "1w", "1.5m"} {
+		if _, err := parseKeepAlive(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestSearchContextID(t *testing.T) {
+	c := &HandlerContext{Config: &Config{ContextSecret: "secret"}}
+	key, err := searchContextKey(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := searchContext{
+		Index:    "my-index",
+		Snapshot: "0123456789abcdef",
+		Created:  1672531140000,
+		Expires:  1672531200000,
+		Body:     []byte(`{"size":10}`),
+		Params:   "scroll=1m",
+		Position: &elastic_proxy.ScrollPosition{Offset: 10},
+	}
+	id := sc.encode(key)
+	got, err := decodeSearchContext(c, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, &sc) {
+		t.Errorf("got %+v, expected %+v", got, &sc)
+	}
+
+	// Elastic scroll and PIT ids aren't decoded
+	for _, id := range []string{
+		"DXF1ZXJ5QW5kRmV0Y2gBAAAAAAAAAD4WYm9laVYtZndUQlNsdDcwakFMNjU1QQ==",
+		"46ToAwMDaWR5BXV1aWQyKwZub2RlXzMAAAAAAAAAACoBYwADaWR4BXV1aWQxAgZub2RlXzEAAAAAAAAAAAEBYQADaWR5BXV1aWQyKgZub2RlXzIAAAAAAAAAAAwBYgACBXV1aWQyAAAFdXVpZDEAAQltYXRjaF9hbGw_gAAAAA==",
+		"",
+	} {
+		if _, err := decodeSearchContext(c, id); !errors.Is(err, errNotSearchContext) {
+			t.Errorf("%q: got %v, expected %v", id, err, errNotSearchContext)
+		}
+	}
+
+	// altered contexts are rejected
+	_, signature, _ := strings.Cut(id, ".")
+	forged := sc
+	forged.Expires += 24 * time.Hour.Milliseconds()
+	data, _ := json.Marshal(&forged)
+	for _, tc := range []struct {
+		id     string
+		secret string
+	}{
+		{base64.RawURLEncoding.EncodeToString(data) + "." + signature, "secret"},
+		{base64.RawURLEncoding.EncodeToString(data) + ".", "secret"},
+		{id, "other-secret"},
+		{id, ""},
+	} {
+		c := &HandlerContext{Config: &Config{ContextSecret: tc.secret}}
+		if _, err := decodeSearchContext(c, tc.id); !errors.Is(err, errInvalidSearchContext) {
+			t.Errorf("%q (secret %q): got %v, expected %v", tc.id, tc.secret, err, errInvalidSearchContext)
+		}
+	}
+
+	// the Sneller token is used when
+	// no secret is configured
+	c = &HandlerContext{Config: &Config{}}
+	c.Config.Sneller.Token = "secret"
+	if _, err := decodeSearchContext(c, id); err != nil {
+		t.Errorf("decoding with the Sneller token: %v", err)
+	}
+}
+
+func TestSearchContextKeepAlive(t *testing.T) {
+	sc := searchContext{Created: time.Now().UnixMilli()}
+	if err := sc.keepAlive("1h"); err != nil {
+		t.Fatal(err)
+	}
+	// the context can't outlive its snapshot
+	if err := sc.keepAlive("2d"); err == nil {
+		t.Error("expected an error")
+	}
+	sc.Created -= 23 * time.Hour.Milliseconds()
+	if err := sc.keepAlive("2h"); err == nil {
+		t.Error("expected an error")
+	}
+}
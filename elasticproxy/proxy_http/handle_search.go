@@ -15,8 +15,7 @@
 package proxy_http
 
 import (
-	"log"
-	"net/http"
+	"errors"
 
 	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
 
@@ -39,40 +38,104 @@ func search(c *HandlerContext, isAsync bool) (handled bool) {
 		return false
 	}
 
-	if c.NeedsAuthentication() {
-		username, password, ok := c.Request.BasicAuth()
-		if !ok || !c.Authenticate(username, password) {
-			r := c.Request
-			log.Printf("%s %v[%s]: unauthorized", r.Method, r.URL, r.RemoteAddr)
-			c.Writer.WriteHeader(http.StatusUnauthorized)
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	pq := prepareQuery(c)
+	if pq == nil {
+		return
+	}
+
+	if pq.ej.PIT != nil {
+		c.BadRequest("[indices] cannot be used with point in time")
+		return
+	}
+
+	// open a scroll (if requested)
+	var sc *searchContext
+	if keepAlive := c.Request.URL.Query().Get("scroll"); keepAlive != "" {
+		if pq.ej.From != nil && *pq.ej.From > 0 {
+			c.BadRequest("using [from] is not allowed in a scroll context")
+			return
+		}
+		if pq.ej.SearchAfter != nil {
+			c.BadRequest("[search_after] cannot be used in a scroll context")
+			return
+		}
+		var err error
+		sc, err = newSearchContext(c, keepAlive)
+		if err != nil {
+			c.BadRequest("cannot open scroll: %v", err)
 			return
 		}
+		sc.Body = pq.body
+		if len(sc.Body) == 0 {
+			sc.Body = []byte("{}")
+		}
+		sc.Params = c.Request.URL.RawQuery
+	}
+
+	searchWithContext(c, pq, isAsync, sc)
+	return
+}
+
+// checkSearchAccess checks if the request is
+// authorized and can be handled by the proxy
+func checkSearchAccess(c *HandlerContext) bool {
+	if !c.Authorize() {
+		return false
 	}
 
 	if !c.HasSnellerEndpoint() {
 		c.NotFound("no Sneller endpoint defined for %s", c.Request.Host)
-		return
+		return false
 	}
 
 	c.AddHeader("X-Elastic-Product", "Elasticsearch")
+	return true
+}
 
-	pq := prepareQuery(c)
-	if pq == nil {
-		return
-	}
-
+// searchWithContext executes the search and writes
+// the result. When a scroll or point-in-time context
+// is passed, then the result includes its (updated) id.
+func searchWithContext(c *HandlerContext, pq *proxyQuery, isAsync bool, sc *searchContext) {
 	// use the default track_total_hits for searching (if not set)
 	if pq.ej.TrackTotalHits == nil {
 		pq.ej.TrackTotalHits = &elastic_proxy.DefaultTrackTotalHits
 	}
 
+	var key []byte
+	if sc != nil {
+		var err error
+		key, err = searchContextKey(c)
+		if err != nil {
+			c.InternalServerError("%v", err)
+			return
+		}
+		pq.snapshot = sc.Snapshot
+	}
+
 	err := execute(c, pq, false)
 	c.AddHeader("X-Sneller-Proxy-ID", c.Logging.QueryID)
+	if errors.Is(err, elastic_proxy.ErrSnapshotGone) {
+		c.NotFound("%v", errSearchContextMissing)
+		return
+	}
 	if err != nil {
 		c.InternalServerError("error executing query: %v", err)
 		return
 	}
 
+	if sc != nil {
+		if sc.Body != nil {
+			sc.Position = pq.ej.NextScrollPosition(c.Logging.Result)
+			c.Logging.Result.ScrollID = sc.encode(key)
+		} else {
+			c.Logging.Result.PitID = sc.encode(key)
+		}
+	}
+
 	// Write all headers
 	setCommonHeaders(c)
 	for header, values := range pq.headers {
@@ -107,5 +170,4 @@ func search(c *HandlerContext, isAsync bool) (handled bool) {
 	}
 
 	writeResult(c, resultData)
-	return
 }
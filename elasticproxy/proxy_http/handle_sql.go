@@ -0,0 +1,596 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
+)
+
+// defaultSQLFetchSize is the number of rows
+// that are returned per page (unless overridden
+// by 'fetch_size')
+const defaultSQLFetchSize = 1000
+
+// sqlCatalog is the catalog name that
+// is reported by SHOW TABLES
+const sqlCatalog = "sneller"
+
+// sqlDateTimeFormat is the format of datetime
+// values in Elasticsearch SQL results
+const sqlDateTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type sqlRequest struct {
+	Query     string                   `json:"query"`
+	FetchSize int64                    `json:"fetch_size"`
+	Cursor    string                   `json:"cursor"`
+	Params    []elastic_proxy.SQLParam `json:"params"`
+	Columnar  bool                     `json:"columnar"`
+	TimeZone  string                   `json:"time_zone"`
+}
+
+// sqlCursor is the state of a paged SQL query.
+// Just like the scroll context, the state is
+// encoded in the cursor. The cursor holds the
+// original query, so it is translated (and the
+// indices are checked) again for each page.
+type sqlCursor struct {
+	Query     string                   `json:"query"`
+	Params    []elastic_proxy.SQLParam `json:"params,omitempty"`
+	Now       int64                    `json:"now"` // unix milliseconds
+	Snapshot  string                   `json:"snapshot"`
+	Offset    int64                    `json:"offset"`
+	FetchSize int64                    `json:"fetch_size"`
+	Columnar  bool                     `json:"columnar,omitempty"`
+}
+
+func (sc *sqlCursor) encode() string {
+	data, _ := json.Marshal(sc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSQLCursor decodes the cursor. It fails
+// if the cursor wasn't created by the proxy.
+func decodeSQLCursor(cursor string) (*sqlCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var sc sqlCursor
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, err
+	}
+	if sc.Query == "" || sc.Snapshot == "" || sc.FetchSize <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return &sc, nil
+}
+
+// sqlResponse is the page of an SQL
+// query that should be returned
+type sqlResponse struct {
+	Columns  []elastic_proxy.SQLColumn
+	Rows     [][]any
+	Cursor   string
+	Header   bool // include the columns
+	Columnar bool
+}
+
+// SQLProxy handles the Elasticsearch SQL API. The
+// query is translated to PartiQL and it's only
+// handled by the proxy if all referenced indices
+// are configured.
+//
+// See: https://www.elastic.co/guide/en/elasticsearch/reference/current/sql-search-api.html
+func SQLProxy(c *HandlerContext) (handled bool) {
+	body, err := peekBody(c)
+	if err != nil {
+		c.InternalServerError("error reading body: %v", err)
+		return true
+	}
+	var request sqlRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return false
+		}
+	}
+
+	cursor := sqlCursor{
+		Query:     request.Query,
+		Params:    request.Params,
+		Now:       time.Now().UnixMilli(),
+		FetchSize: request.FetchSize,
+		Columnar:  request.Columnar,
+	}
+	if request.Cursor != "" {
+		sc, err := decodeSQLCursor(request.Cursor)
+		if err != nil {
+			return false // Elastic cursor
+		}
+		cursor = *sc
+	}
+	if cursor.Query == "" {
+		return false
+	}
+	if cursor.FetchSize <= 0 {
+		cursor.FetchSize = defaultSQLFetchSize
+	}
+
+	stmt, err := elastic_proxy.ParseElasticSQL(cursor.Query, cursor.Params, time.UnixMilli(cursor.Now))
+	if err != nil {
+		handled = true
+		if checkSearchAccess(c) {
+			c.BadRequest("invalid query: %v", err)
+		}
+		return
+	}
+
+	switch stmt.Command {
+	case elastic_proxy.SQLShowTables:
+		handled = true
+		if checkSearchAccess(c) {
+			sqlShowTables(c, stmt)
+		}
+		return
+	case elastic_proxy.SQLDescribe:
+		if !c.SelectIndex(stmt.Pattern) {
+			return false
+		}
+		handled = true
+		if checkSearchAccess(c) {
+			sqlDescribe(c, stmt.Pattern)
+		}
+		return
+	}
+
+	// only handle the query if all indices are configured
+	indices := stmt.Indices()
+	if len(indices) == 0 {
+		return false
+	}
+	for _, index := range indices {
+		if !c.SelectIndex(index) {
+			return false
+		}
+	}
+	c.Logging.Index = strings.Join(indices, ",")
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	var sources []elastic_proxy.TableSource
+	err = stmt.ResolveIndices(func(index string) ([]elastic_proxy.TableSource, bool) {
+		m, ok := c.Config.Mapping[index]
+		if !ok {
+			return nil, false
+		}
+		ts := make([]elastic_proxy.TableSource, len(m.Sources))
+		for i, s := range m.Sources {
+			ts[i] = elastic_proxy.TableSource{Database: s.Database, Table: s.Table}
+		}
+		sources = append(sources, ts...)
+		return ts, true
+	})
+	if err != nil {
+		c.BadRequest("invalid query: %v", err)
+		return
+	}
+
+	// the next pages should use the same index snapshot
+	if request.Cursor != "" {
+		snapshot, err := elastic_proxy.FetchSnapshot(c.Client, c.Config.Sneller.EndPoint, c.SnellerToken(), sources)
+		if err != nil {
+			c.InternalServerError("cannot determine index snapshot: %v", err)
+			return
+		}
+		if snapshot != cursor.Snapshot {
+			c.NotFound("cursor is invalid (the index has changed)")
+			return
+		}
+	}
+
+	result := executeSQL(c, stmt.Page(cursor.Offset, cursor.FetchSize+1))
+	if result == nil {
+		return
+	}
+
+	response := sqlResponse{
+		Columns:  result.Columns,
+		Rows:     result.Rows,
+		Header:   request.Cursor == "",
+		Columnar: cursor.Columnar,
+	}
+	if stmt.Paged() && int64(len(response.Rows)) > cursor.FetchSize {
+		response.Rows = response.Rows[:cursor.FetchSize]
+		if cursor.Snapshot == "" {
+			cursor.Snapshot, err = elastic_proxy.FetchSnapshot(c.Client, c.Config.Sneller.EndPoint, c.SnellerToken(), sources)
+			if err != nil {
+				c.InternalServerError("cannot determine index snapshot: %v", err)
+				return
+			}
+		}
+		cursor.Offset += cursor.FetchSize
+		response.Cursor = cursor.encode()
+	}
+
+	writeSQLResponse(c, &response)
+	return
+}
+
+// SQLCloseProxy closes a cursor. Cursors
+// aren't stored, so there is nothing to free.
+func SQLCloseProxy(c *HandlerContext) (handled bool) {
+	var request sqlRequest
+	body, err := peekBody(c)
+	if err != nil || json.Unmarshal(body, &request) != nil {
+		return false
+	}
+	if _, err := decodeSQLCursor(request.Cursor); err != nil {
+		return false
+	}
+
+	handled = true
+	if !checkSearchAccess(c) {
+		return
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	writeResult(c, map[string]any{"succeeded": true})
+	return
+}
+
+// executeSQL executes the (translated) query
+// and returns the result (or nil on error)
+func executeSQL(c *HandlerContext, SQL string) *elastic_proxy.SQLResult {
+	defer func() {
+		c.Logging.Duration = time.Since(c.Logging.Start)
+	}()
+
+	c.Logging.SQL = SQL
+
+	tokenLast4 := c.SnellerToken()
+	if len(tokenLast4) > 4 {
+		tokenLast4 = tokenLast4[len(tokenLast4)-4:]
+	}
+
+	c.Logging.Sneller = &SnellerLogging{
+		EndPoint:   c.Config.Sneller.EndPoint.String(),
+		TokenLast4: tokenLast4,
+		Sources:    c.Mapping.Sources,
+	}
+
+	response, err := elastic_proxy.ExecuteQuery(
+		c.Client,
+		c.Config.Sneller.EndPoint,
+		c.SnellerToken(),
+		c.Logging.SQL)
+	if err != nil {
+		c.InternalServerError("error executing query: %v", err)
+		return nil
+	}
+	defer response.Body.Close()
+
+	// Update ID to synchronize with Sneller query ID
+	if queryID := response.Header.Get("X-Sneller-Query-ID"); queryID != "" {
+		c.Logging.QueryID = queryID
+	}
+	c.AddHeader("X-Sneller-Proxy-ID", c.Logging.QueryID)
+
+	result, err := elastic_proxy.ReadSQLResult(response.Body)
+	if err != nil {
+		c.InternalServerError("error decoding Ion response: %v", err)
+		return nil
+	}
+	c.Logging.Sneller.CacheHits = int(result.CacheHits)
+	c.Logging.Sneller.CacheMisses = int(result.CacheMisses)
+	c.Logging.Sneller.BytesScanned = int(result.BytesScanned)
+	setStatisticsHeaders(c)
+	return result
+}
+
+// sqlShowTables lists the configured
+// indices that match the pattern
+func sqlShowTables(c *HandlerContext, stmt *elastic_proxy.ElasticSQL) {
+	var names []string
+	for index := range c.Config.Mapping {
+		if stmt.MatchIndex(index) && c.IndexAllowed(index) {
+			names = append(names, index)
+		}
+	}
+	sort.Strings(names)
+
+	response := sqlResponse{
+		Columns: []elastic_proxy.SQLColumn{
+			{Name: "catalog", Type: "keyword"},
+			{Name: "name", Type: "keyword"},
+			{Name: "type", Type: "keyword"},
+			{Name: "kind", Type: "keyword"},
+		},
+		Rows:   make([][]any, 0, len(names)),
+		Header: true,
+	}
+	for _, name := range names {
+		response.Rows = append(response.Rows, []any{sqlCatalog, name, "TABLE", "INDEX"})
+	}
+	writeSQLResponse(c, &response)
+}
+
+// sqlTypes maps the Elastic field types
+// to their SQL data types
+var sqlTypes = map[string]string{
+	"text":    "VARCHAR",
+	"keyword": "VARCHAR",
+	"long":    "BIGINT",
+	"double":  "DOUBLE",
+	"date":    "TIMESTAMP",
+	"boolean": "BOOLEAN",
+	"object":  "STRUCT",
+}
+
+// sqlDescribe lists the columns of the index
+func sqlDescribe(c *HandlerContext, index string) {
+	elasticMapping := fetchElasticMapping(c, index)
+	if elasticMapping == nil {
+		return
+	}
+	fc := elastic_proxy.NewFieldCapabilities(elasticMapping, c.Mapping.TypeMapping, []string{"*"})
+	fields := make([]string, 0, len(fc))
+	for field, types := range fc {
+		for _, capability := range types {
+			if !capability.MetadataField {
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	response := sqlResponse{
+		Columns: []elastic_proxy.SQLColumn{
+			{Name: "column", Type: "keyword"},
+			{Name: "type", Type: "keyword"},
+			{Name: "mapping", Type: "keyword"},
+		},
+		Rows:   make([][]any, 0, len(fields)),
+		Header: true,
+	}
+	for _, field := range fields {
+		for typ := range fc[field] {
+			sqlType, ok := sqlTypes[typ]
+			if !ok {
+				sqlType = "OTHER"
+			}
+			mapping := typ
+			if typ == "date" {
+				mapping = "datetime"
+			}
+			response.Rows = append(response.Rows, []any{field, sqlType, mapping})
+		}
+	}
+	writeSQLResponse(c, &response)
+}
+
+// writeSQLResponse writes the response in the
+// requested format (json, txt, csv or tsv)
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/sql-rest-format.html
+func writeSQLResponse(c *HandlerContext, response *sqlResponse) {
+	q := c.Request.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		accept := c.Request.Header.Get("Accept")
+		switch {
+		case strings.HasPrefix(accept, "text/plain"):
+			format = "txt"
+		case strings.HasPrefix(accept, "text/csv"):
+			format = "csv"
+		case strings.HasPrefix(accept, "text/tab-separated-values"):
+			format = "tsv"
+		default:
+			format = "json"
+		}
+	}
+
+	var data []byte
+	switch format {
+	case "json":
+		result := map[string]any{}
+		if response.Header {
+			result["columns"] = response.Columns
+		}
+		rows := sqlValues(response.Rows)
+		if response.Columnar {
+			values := make([][]any, len(response.Columns))
+			for i := range values {
+				values[i] = make([]any, len(rows))
+				for j, row := range rows {
+					values[i][j] = row[i]
+				}
+			}
+			result["values"] = values
+		} else {
+			result["rows"] = rows
+		}
+		if response.Cursor != "" {
+			result["cursor"] = response.Cursor
+		}
+		c.Writer.Header().Set("Content-Type", "application/json")
+		writeResult(c, result)
+		return
+	case "txt":
+		data = formatSQLText(response)
+		c.Writer.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	case "csv", "tsv":
+		mediaType, delimiter := "text/csv", ','
+		if format == "tsv" {
+			mediaType, delimiter = "text/tab-separated-values", '\t'
+		} else if text := q.Get("delimiter"); text != "" {
+			r, size := utf8.DecodeRuneInString(text)
+			if size != len(text) || r == '"' || r == '\r' || r == '\n' {
+				c.BadRequest("invalid delimiter %q", text)
+				return
+			}
+			delimiter = r
+		}
+		var err error
+		data, err = formatSQLCSV(response, delimiter)
+		if err != nil {
+			c.InternalServerError("cannot format result: %v", err)
+			return
+		}
+		header := "present"
+		if !response.Header {
+			header = "absent"
+		}
+		c.Writer.Header().Set("Content-Type", mediaType+"; charset=utf-8; header="+header)
+	default:
+		c.BadRequest("invalid format %q", format)
+		return
+	}
+
+	// the cursor is returned in a header
+	// for the textual formats
+	if response.Cursor != "" {
+		c.Writer.Header().Set("Cursor", response.Cursor)
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Write(data)
+}
+
+// sqlValues converts the values to their JSON
+// representation (datetimes are formatted)
+func sqlValues(rows [][]any) [][]any {
+	result := make([][]any, len(rows))
+	for i, row := range rows {
+		values := make([]any, len(row))
+		for j, v := range row {
+			if t, ok := v.(time.Time); ok {
+				values[j] = t.UTC().Format(sqlDateTimeFormat)
+			} else {
+				values[j] = v
+			}
+		}
+		result[i] = values
+	}
+	return result
+}
+
+// sqlText returns the textual representation of a value
+func sqlText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(sqlDateTimeFormat)
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// formatSQLText formats the rows as a table with
+// centered column names (the same way as Elastic)
+func formatSQLText(response *sqlResponse) []byte {
+	const minWidth = 15
+
+	widths := make([]int, len(response.Columns))
+	for i, c := range response.Columns {
+		widths[i] = max(minWidth, utf8.RuneCountInString(c.Name))
+	}
+	cells := make([][]string, len(response.Rows))
+	for i, row := range response.Rows {
+		cells[i] = make([]string, len(row))
+		for j, v := range row {
+			cells[i][j] = sqlText(v)
+			widths[j] = max(widths[j], utf8.RuneCountInString(cells[i][j]))
+		}
+	}
+
+	var buf bytes.Buffer
+	if response.Header {
+		for i, c := range response.Columns {
+			if i > 0 {
+				buf.WriteByte('|')
+			}
+			n := utf8.RuneCountInString(c.Name)
+			left := (widths[i] - n) / 2
+			buf.WriteString(strings.Repeat(" ", left))
+			buf.WriteString(c.Name)
+			buf.WriteString(strings.Repeat(" ", widths[i]-n-left))
+		}
+		buf.WriteByte('\n')
+		for i := range response.Columns {
+			if i > 0 {
+				buf.WriteByte('+')
+			}
+			buf.WriteString(strings.Repeat("-", widths[i]))
+		}
+		buf.WriteByte('\n')
+	}
+	for _, row := range cells {
+		for j, cell := range row {
+			if j > 0 {
+				buf.WriteByte('|')
+			}
+			buf.WriteString(cell)
+			buf.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell)))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// formatSQLCSV formats the rows as CSV
+// (the header is only included once)
+func formatSQLCSV(response *sqlResponse, delimiter rune) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = delimiter
+	w.UseCRLF = true
+	if response.Header {
+		names := make([]string, len(response.Columns))
+		for i, c := range response.Columns {
+			names[i] = c.Name
+		}
+		if err := w.Write(names); err != nil {
+			return nil, err
+		}
+	}
+	record := make([]string, len(response.Columns))
+	for _, row := range response.Rows {
+		for i, v := range row {
+			record[i] = sqlText(v)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxy_http
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
+)
+
+func testSQLResponse(header bool) *sqlResponse {
+	return &sqlResponse{
+		Columns: []elastic_proxy.SQLColumn{
+			{Name: "author", Type: "keyword"},
+			{Name: "page_count", Type: "long"},
+			{Name: "release_date", Type: "datetime"},
+		},
+		Rows: [][]any{
+			{"Dan Simmons", int64(482), time.Date(1989, 5, 26, 0, 0, 0, 0, time.UTC)},
+			{"Frank \"Herbert\"", nil, nil},
+		},
+		Header: header,
+	}
+}
+
+func TestFormatSQLText(t *testing.T) {
+	want := "    author     |  page_count   |      release_date      \n" +
+		"---------------+---------------+------------------------\n" +
+		"Dan Simmons    |482            |1989-05-26T00:00:00.000Z\n" +
+		"Frank \"Herbert\"|               |                        \n"
+	if got := string(formatSQLText(testSQLResponse(true))); got != want {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, want)
+	}
+
+	// the next pages don't include the header
+	want = "Dan Simmons    |482            |1989-05-26T00:00:00.000Z\n" +
+		"Frank \"Herbert\"|               |                        \n"
+	if got := string(formatSQLText(testSQLResponse(false))); got != want {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestFormatSQLCSV(t *testing.T) {
+	want := "author;page_count;release_date\r\n" +
+		"Dan Simmons;482;1989-05-26T00:00:00.000Z\r\n" +
+		"\"Frank \"\"Herbert\"\"\";;\r\n"
+	got, err := formatSQLCSV(testSQLResponse(true), ';')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestSQLCursor(t *testing.T) {
+	sc := sqlCursor{
+		Query:     "SELECT * FROM logs WHERE a = ?",
+		Params:    []elastic_proxy.SQLParam{{Value: "x"}},
+		Now:       1672531200000,
+		Snapshot:  `"abc123"`,
+		Offset:    1000,
+		FetchSize: 1000,
+	}
+	got, err := decodeSQLCursor(sc.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*got, sc) {
+		t.Errorf("got %+v, expected %+v", *got, sc)
+	}
+
+	// Elastic cursors should be forwarded
+	if _, err := decodeSQLCursor("sDXF1ZXJ5QW5kRmV0Y2gBAAAAAAAAAAEWYUpOYklQMHhRUEtld3RmN"); err == nil {
+		t.Error("expected an error for an Elastic cursor")
+	}
+}
@@ -18,6 +18,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+
+	"github.com/SnellerInc/sneller/db"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
@@ -32,6 +35,13 @@ type HandlerContext struct {
 	Mapping *mappingEntry // currently selected mapping for an index
 	Cache   MappingCache
 
+	// Tenant is the authenticated tenant
+	// (only set when using multi-tenant
+	// authentication)
+	Tenant   db.Tenant
+	token    string   // token of the authenticated tenant
+	selected []string // indexes that were selected
+
 	// function performing verbose logging
 	VerboseLog func(string, ...any)
 
@@ -76,18 +86,25 @@ func (c *HandlerContext) SelectIndex(index string) bool {
 
 	c.Mapping = m
 	c.Logging.Index = index
+	if !slices.Contains(c.selected, index) {
+		c.selected = append(c.selected, index)
+	}
 
 	if c.Memcache.Client != nil && c.Cache == dummyCache {
-		c.Cache = NewMemcacheMappingCache(
-			c.Memcache.Client,
-			c.Memcache.TenantID,
-			c.Memcache.Secret,
-			c.Memcache.ExpirationTime)
+		c.Cache = c.newMappingCache()
 	}
 
 	return true
 }
 
+func (c *HandlerContext) newMappingCache() MappingCache {
+	return NewMemcacheMappingCache(
+		c.Memcache.Client,
+		c.Memcache.TenantID,
+		c.Memcache.Secret,
+		c.Memcache.ExpirationTime)
+}
+
 func (c *HandlerContext) AddHeader(k, v string) {
 	c.Writer.Header().Add(k, v)
 }
@@ -24,6 +24,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	elastic_proxy "github.com/SnellerInc/sneller/elasticproxy/elastic-proxy"
@@ -159,6 +160,75 @@ func TestMappingHandler(t *testing.T) {
 	}
 }
 
+func TestFieldCapsHandler(t *testing.T) {
+	config := &Config{}
+	config.Sneller.EndPoint = &url.URL{Scheme: "http", Host: "localhost"}
+	config.Mapping = map[string]*mappingEntry{
+		"cached": {
+			Sources: []mappingEntrySource{
+				{
+					Table: "cached",
+				},
+			},
+			TypeMapping: map[string]elastic_proxy.TypeMapping{
+				"name": {Type: "text", Fields: map[string]string{"keyword": "keyword"}},
+			},
+		},
+	}
+
+	cache := &testCache{
+		fetchedMapping: &elastic_proxy.ElasticMapping{
+			Properties: map[string]elastic_proxy.MappingValue{
+				"name": {Type: "keyword"},
+				"age":  {Type: "long"},
+			},
+		},
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{index}/_field_caps", func(w http.ResponseWriter, r *http.Request) {
+		c := NewHandlerContext(config, nil, w, r, false, func(string, ...any) {})
+		c.Cache = cache
+		if !FieldCapsProxy(c) {
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown/_field_caps?fields=*", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("unknown index shouldn't be handled (got status %d)", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cached/_field_caps?fields=name*,age", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"indices": []any{"cached"},
+		"fields": map[string]any{
+			"name": map[string]any{
+				"text": map[string]any{"type": "text", "metadata_field": false, "searchable": true, "aggregatable": false},
+			},
+			"name.keyword": map[string]any{
+				"keyword": map[string]any{"type": "keyword", "metadata_field": false, "searchable": true, "aggregatable": true},
+			},
+			"age": map[string]any{
+				"long": map[string]any{"type": "long", "metadata_field": false, "searchable": true, "aggregatable": true},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:  %v", got)
+		t.Errorf("want: %v", want)
+	}
+}
+
 func TestIntegrationMappingsHandler(t *testing.T) {
 	srv := launchElasticSearchTestServer(t, RoundTripFn(snellerdHandler), new(testCache))
 	defer srv.Close()
@@ -200,7 +270,9 @@ func TestIntegrationWithMemcacheMappingsHandler(t *testing.T) {
 
 	snellerdCalls := 0
 	snellerdWrapper := func(r *http.Request) *http.Response {
-		snellerdCalls += 1
+		if r.URL.Path == "/query" {
+			snellerdCalls += 1
+		}
 		return snellerdHandler(r)
 	}
 
@@ -332,6 +404,16 @@ func testReadMappingResponse(t *testing.T, req *http.Request, path string) {
 }
 
 func snellerdHandler(r *http.Request) *http.Response {
+	if r.Method == http.MethodGet && r.URL.Path == "/schema" {
+		// behave like a snellerd without stored
+		// schemas, so that the SNELLER_DATASHAPE
+		// fallback is exercised
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     "404 Not Found",
+			Body:       io.NopCloser(strings.NewReader("not found")),
+		}
+	}
 	if r.Method != http.MethodPost {
 		panic("wrong request: POST expected")
 	}
@@ -35,6 +35,7 @@ type proxyQuery struct {
 	queryParams url.Values
 	ej          elastic_proxy.ElasticJSON
 	headers     map[string][]string
+	snapshot    string // index snapshot (scroll and point-in-time only)
 }
 
 func prepareQuery(c *HandlerContext) *proxyQuery {
@@ -186,7 +187,7 @@ func execute(c *HandlerContext, pq *proxyQuery, alwaysDetermineCount bool) error
 	}
 	c.Logging.SQL = elastic_proxy.PrintExprPretty(sqlExpr)
 
-	tokenLast4 := c.Config.Sneller.Token
+	tokenLast4 := c.SnellerToken()
 	if len(tokenLast4) > 4 {
 		tokenLast4 = tokenLast4[len(tokenLast4)-4:]
 	}
@@ -200,7 +201,7 @@ func execute(c *HandlerContext, pq *proxyQuery, alwaysDetermineCount bool) error
 	client := &http.Client{
 		Timeout: c.Config.Sneller.Timeout,
 	}
-	response, err := elastic_proxy.ExecuteQuery(client, c.Config.Sneller.EndPoint, c.Config.Sneller.Token, c.Logging.SQL)
+	response, err := elastic_proxy.ExecuteSnapshotQuery(client, c.Config.Sneller.EndPoint, c.SnellerToken(), c.Logging.SQL, pq.snapshot)
 	if err != nil {
 		return err
 	}
@@ -255,10 +256,14 @@ func writeResult(c *HandlerContext, resultData any) {
 }
 
 func setCommonHeaders(c *HandlerContext) {
+	setStatisticsHeaders(c)
+	c.AddHeader("Content-Type", "application/json")
+}
+
+func setStatisticsHeaders(c *HandlerContext) {
 	c.AddHeader("X-Sneller-Cache-Hits", strconv.Itoa(c.Logging.Sneller.CacheHits))
 	c.AddHeader("X-Sneller-Cache-Misses", strconv.Itoa(c.Logging.Sneller.CacheMisses))
 	c.AddHeader("X-Sneller-Bytes-Scanned", strconv.Itoa(c.Logging.Sneller.BytesScanned))
-	c.AddHeader("Content-Type", "application/json")
 }
 
 func parseStatistics(l *Logging, dec *ion.Decoder) error {
@@ -132,6 +132,8 @@ func getEmpty(name string) (composite, bool) {
 		return &Unpivot{}, true
 	case "union":
 		return &Union{}, true
+	case "param":
+		return &Param{}, true
 	default:
 		return nil, false
 	}
@@ -1434,7 +1434,7 @@ var IsKeyword func(s string) bool
 // if it contains non-printable characters or it is a
 // PartiQL keyword.
 func QuoteID(s string) string {
-	if IsKeyword != nil && IsKeyword(s) || strings.ContainsAny(s, "%,~+-!<>=(){}[]:") {
+	if IsKeyword != nil && IsKeyword(s) || strings.ContainsAny(s, "%,~+-!<>=(){}[]:\"' .*/|;?&^#`") {
 		return strconv.Quote(s)
 	}
 	for _, r := range s {
@@ -0,0 +1,156 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SnellerInc/sneller/ion"
+)
+
+// Param is a query parameter placeholder
+// (written as `?`, `$1` or `:name`)
+// that is replaced with a constant
+// by BindParams before the query is planned.
+type Param struct {
+	// Index is the 1-based position
+	// of a positional parameter,
+	// or zero for a named parameter.
+	Index int
+	// Name is the name of a named parameter.
+	Name string
+}
+
+func (p *Param) text(dst *strings.Builder, redact bool) {
+	if p.Name != "" {
+		dst.WriteByte(':')
+		dst.WriteString(p.Name)
+		return
+	}
+	dst.WriteByte('$')
+	dst.WriteString(strconv.Itoa(p.Index))
+}
+
+func (p *Param) Equals(x Node) bool {
+	p2, ok := x.(*Param)
+	return ok && p.Index == p2.Index && p.Name == p2.Name
+}
+
+func (p *Param) walk(v Visitor) {}
+
+func (p *Param) Encode(dst *ion.Buffer, st *ion.Symtab) {
+	dst.BeginStruct(-1)
+	settype(dst, st, "param")
+	if p.Name != "" {
+		dst.BeginField(st.Intern("name"))
+		dst.WriteString(p.Name)
+	} else {
+		dst.BeginField(st.Intern("index"))
+		dst.WriteInt(int64(p.Index))
+	}
+	dst.EndStruct()
+}
+
+func (p *Param) SetField(f ion.Field) error {
+	switch f.Label {
+	case "name":
+		s, err := f.String()
+		if err != nil {
+			return err
+		}
+		p.Name = s
+	case "index":
+		i, err := f.Int()
+		if err != nil {
+			return err
+		}
+		p.Index = int(i)
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+func (p *Param) check(h Hint) error {
+	return errsyntax(p, fmt.Sprintf("parameter %s is not bound", ToString(p)))
+}
+
+// Params are the values of query parameters
+// (see BindParams).
+type Params struct {
+	// Positional are the values of the
+	// positional parameters `$1`, `$2`, etc.
+	// (or each `?` in order of appearance).
+	Positional []Constant
+	// Named are the values of
+	// the named parameters `:name`.
+	Named map[string]Constant
+}
+
+// Value returns the value of the parameter x,
+// or nil if the parameter has no value.
+func (p *Params) Value(x *Param) Constant {
+	if x.Name != "" {
+		return p.Named[x.Name]
+	}
+	if x.Index > 0 && x.Index <= len(p.Positional) {
+		return p.Positional[x.Index-1]
+	}
+	return nil
+}
+
+type binder struct {
+	params *Params
+	err    error
+}
+
+func (b *binder) Walk(n Node) Rewriter { return b }
+
+func (b *binder) Rewrite(n Node) Node {
+	p, ok := n.(*Param)
+	if !ok {
+		return n
+	}
+	val := b.params.Value(p)
+	if val == nil {
+		if b.err == nil {
+			b.err = errsyntax(p, fmt.Sprintf("no value for parameter %s", ToString(p)))
+		}
+		return n
+	}
+	// each occurrence of the parameter
+	// gets its own copy of the value
+	return Copy(val)
+}
+
+// BindParams replaces each Param in q with
+// the corresponding value in params.
+// BindParams returns an error if a parameter
+// in q doesn't have a value.
+//
+// BindParams should be called before the query
+// is checked and planned so that the
+// planner sees the constant values.
+func BindParams(q *Query, params *Params) error {
+	b := &binder{params: params}
+	for i := range q.With {
+		q.With[i].As = Rewrite(b, q.With[i].As).(*Select)
+	}
+	q.Into = Rewrite(b, q.Into)
+	q.Body = Rewrite(b, q.Body)
+	return b.err
+}
@@ -54,6 +54,17 @@ type scanner struct {
 	notkw bool
 	// the last symbol returned by `Lex`
 	lastsym int
+	// number of `?` parameters seen so far
+	// and whether `$n` parameters were seen
+	// (the two styles can't be mixed)
+	unnumbered int
+	numbered   bool
+	// params are the values of the parameters
+	// (if known), see ParseParams
+	params *expr.Params
+	// analyze is set when EXPLAIN
+	// is followed by ANALYZE
+	analyze bool
 
 	// value of UTCNOW(); populated lazily
 	// (we need every instance of UTCNOW()
@@ -343,7 +354,20 @@ func (s *scanner) lex(l *yySymType) int {
 		s.notkw = false
 		s.pos++
 		return int(b)
-	case ',', '*', '-', '/', '%', ':', '&', '^', '[', ']', '(', ')', '{', '}':
+	case '?', '$':
+		return s.lexParam(l)
+	case ':':
+		// ':' separates a field name (always
+		// a string) from its value in a struct
+		// literal; otherwise it introduces a
+		// named parameter
+		if s.lastsym != STRING && (isalpha(s.peekat(1)) || s.peekat(1) == '_') {
+			return s.lexParam(l)
+		}
+		s.notkw = false
+		s.pos++
+		return int(b)
+	case ',', '*', '-', '/', '%', '&', '^', '[', ']', '(', ')', '{', '}':
 		// literal operators
 		s.notkw = false
 		s.pos++
@@ -410,6 +434,11 @@ func (s *scanner) lexIdent(l *yySymType) int {
 				s.chompws()
 				s.notkw = true
 			}
+			// EXPLAIN ANALYZE is EXPLAIN as far as
+			// the grammar is concerned
+			if term == EXPLAIN {
+				s.lexAnalyze()
+			}
 			return term
 		}
 	}
@@ -418,6 +447,22 @@ func (s *scanner) lexIdent(l *yySymType) int {
 	return ID
 }
 
+// lexAnalyze consumes the ANALYZE keyword
+// if it immediately follows EXPLAIN
+func (s *scanner) lexAnalyze() {
+	const word = "ANALYZE"
+	s.chompws()
+	end := s.pos + len(word)
+	if end > len(s.from) || !bytes.EqualFold(s.from[s.pos:end], []byte(word)) {
+		return
+	}
+	if end < len(s.from) && !issep(s.from[end]) {
+		return
+	}
+	s.pos = end
+	s.analyze = true
+}
+
 // lexNumber lexes a number-like thing
 // (NOTE: this is too permissive; we do the actual
 // checking for valid numbers at parse time)
@@ -587,6 +632,82 @@ func (s *scanner) lexIon(l *yySymType) int {
 	return ION
 }
 
+// lex a query parameter placeholder
+// (`?`, `$n` or `:name`); see param
+// for the token that is returned
+func (s *scanner) lexParam(l *yySymType) int {
+	startpos := s.pos
+	s.notkw = false
+	switch s.from[s.pos] {
+	case '?':
+		if s.numbered {
+			s.err = s.mkerror(1, "cannot mix '?' and '$n' parameters")
+			return ERROR
+		}
+		s.pos++
+		s.unnumbered++
+		return s.param(l, startpos, &expr.Param{Index: s.unnumbered})
+	case '$':
+		s.pos++
+		for s.pos < len(s.from) && isdigit(s.from[s.pos]) {
+			s.pos++
+		}
+		n, err := strconv.Atoi(string(s.from[startpos+1 : s.pos]))
+		if err != nil || n < 1 {
+			length := s.pos - startpos
+			s.pos = startpos
+			s.err = s.mkerror(length, "expected a parameter number >= 1 after '$'")
+			return ERROR
+		}
+		if s.unnumbered > 0 {
+			s.pos = startpos
+			s.err = s.mkerror(1, "cannot mix '?' and '$n' parameters")
+			return ERROR
+		}
+		s.numbered = true
+		return s.param(l, startpos, &expr.Param{Index: n})
+	default: // ':'
+		s.pos++
+		for s.pos < len(s.from) && isident(s.from[s.pos]) {
+			s.pos++
+		}
+		return s.param(l, startpos, &expr.Param{Name: string(s.from[startpos+1 : s.pos])})
+	}
+}
+
+// param returns the token for a parameter.
+// Without parameter values, the placeholder
+// is returned as an ION token so that it is
+// accepted wherever a datum is. Otherwise the
+// value is substituted, so that strings and
+// numbers are also accepted where the grammar
+// requires a literal (i.e. LIKE patterns
+// and indexes).
+func (s *scanner) param(l *yySymType, startpos int, p *expr.Param) int {
+	if s.params == nil {
+		l.expr = p
+		return ION
+	}
+	val := s.params.Value(p)
+	if val == nil {
+		length := s.pos - startpos
+		s.pos = startpos
+		s.err = s.mkerror(length, "no value for parameter %s", expr.ToString(p))
+		return ERROR
+	}
+	switch v := val.(type) {
+	case expr.String:
+		l.str = string(v)
+		return STRING
+	case expr.Integer, expr.Float, *expr.Rational:
+		l.expr = expr.Copy(v)
+		return NUMBER
+	default:
+		l.expr = expr.Copy(v)
+		return ION
+	}
+}
+
 func toint(e expr.Node) (int, error) {
 	if i, ok := e.(expr.Integer); ok {
 		return int(i), nil
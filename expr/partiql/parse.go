@@ -46,7 +46,18 @@ func dropParser(p *yyParserImpl) {
 // and returns the result, or an error if one
 // is encountered.
 func Parse(in []byte) (*expr.Query, error) {
-	s := &scanner{from: in}
+	return ParseParams(in, nil)
+}
+
+// ParseParams is like Parse, but it binds the query
+// parameters to the values in params while parsing,
+// so parameters can be used wherever a literal of
+// the same type can be used (i.e. `x LIKE ?` and
+// `x[?]`). It returns an error if a parameter
+// doesn't have a value. If params is nil, then
+// ParseParams is equivalent to Parse.
+func ParseParams(in []byte, params *expr.Params) (*expr.Query, error) {
+	s := &scanner{from: in, params: params}
 	p := newParser()
 	ret := p.Parse(s)
 	dropParser(p)
@@ -56,6 +67,9 @@ func Parse(in []byte) (*expr.Query, error) {
 	if ret != 0 {
 		return nil, fmt.Errorf("parse error %d", ret)
 	}
+	if s.analyze {
+		s.result.Analyze = true
+	}
 	return s.result, nil
 }
 
@@ -91,11 +91,16 @@ var sameq = []string{
 	`EXPLAIN AS text SELECT * FROM table`,
 	`EXPLAIN AS list SELECT * FROM table`,
 	`EXPLAIN AS graphviz SELECT * FROM table`,
+	`EXPLAIN ANALYZE SELECT * FROM table`,
+	`EXPLAIN ANALYZE AS list SELECT * FROM table`,
+	`EXPLAIN ANALYZE AS graphviz SELECT analyze FROM table`,
 	`SELECT SNELLER_DATASHAPE(*) FROM table`,
 	`SELECT * FROM table1 UNION SELECT * FROM table2`,
 	`SELECT * FROM table1 UNION ALL SELECT * FROM table2`,
 	`SELECT * FROM table1 UNION SELECT * FROM table2 UNION ALL SELECT * FROM table3 UNION SELECT * FROM table4`,
 	`SELECT agg, SUM(x), ROW_NUMBER() OVER (ORDER BY SUM(x) ASC NULLS FIRST) FROM table GROUP BY agg`,
+	"SELECT x FROM table WHERE y = $1 AND z < $2",
+	"SELECT x, {'y': :y} FROM table WHERE x = :a OR x = :b",
 }
 
 func TestParseSFW(t *testing.T) {
@@ -577,6 +582,22 @@ func TestParseErrors(t *testing.T) {
 			query: `SELECT /* this /*is /*nested (not really) */`,
 			msg:   "1:16: unterminated comment",
 		},
+		{
+			query: `SELECT x FROM table WHERE y = ? AND z = $2`,
+			msg:   "1:41: cannot mix '?' and '$n' parameters",
+		},
+		{
+			query: `SELECT x FROM table WHERE y = $1 AND z = ?`,
+			msg:   "1:42: cannot mix '?' and '$n' parameters",
+		},
+		{
+			query: `SELECT x FROM table WHERE y = $0`,
+			msg:   "expected a parameter number >= 1 after '$'",
+		},
+		{
+			query: `SELECT x FROM table LIMIT ?`,
+			msg:   "unexpected ION",
+		},
 	}
 
 	for i := range testcases {
@@ -599,6 +620,109 @@ func TestParseErrors(t *testing.T) {
 	}
 }
 
+func TestParseParams(t *testing.T) {
+	q, err := Parse([]byte("SELECT x FROM table WHERE y = ? AND z >= ? AND z < ? AND w = ?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT x FROM table WHERE y = $1 AND z >= $2 AND z < $3 AND w = $4"
+	if got := q.Text(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	params := &expr.Params{
+		Positional: []expr.Constant{
+			expr.String("foo"), expr.Integer(1), expr.Integer(10),
+		},
+	}
+	err = expr.BindParams(q, params)
+	if err == nil || !strings.Contains(err.Error(), "no value for parameter $4") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	params.Positional = append(params.Positional, expr.Bool(true))
+	q, err = Parse([]byte("SELECT x FROM table WHERE y = ? AND z >= ? AND z < ? AND w = ?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = expr.BindParams(q, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "SELECT x FROM table WHERE y = 'foo' AND z >= 1 AND z < 10 AND w = TRUE"
+	if got := q.Text(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseBoundParams(t *testing.T) {
+	params := &expr.Params{
+		Positional: []expr.Constant{
+			expr.String("a%"), expr.Integer(2), expr.String("!"),
+		},
+		Named: map[string]expr.Constant{
+			"s": expr.String("foo"),
+			"i": expr.Integer(3),
+			"b": expr.Bool(true),
+		},
+	}
+	testcases := []struct {
+		query, want string
+	}{
+		{
+			query: "SELECT x FROM t WHERE x LIKE ?",
+			want:  "SELECT x FROM t WHERE x LIKE 'a%'",
+		},
+		{
+			query: "SELECT x FROM t WHERE x NOT ILIKE $1 ESCAPE $3",
+			want:  "SELECT x FROM t WHERE !(x ILIKE 'a%' ESCAPE '!')",
+		},
+		{
+			query: "SELECT x FROM t WHERE x SIMILAR TO :s",
+			want:  "SELECT x FROM t WHERE x SIMILAR TO 'foo'",
+		},
+		{
+			query: "SELECT x FROM t WHERE x IN (:s)",
+			want:  "SELECT x FROM t WHERE x IN ('foo')",
+		},
+		{
+			query: "SELECT x FROM t WHERE x IN (:s, :i, :b)",
+			want:  "SELECT x FROM t WHERE x IN ('foo', 3, TRUE)",
+		},
+		{
+			query: "SELECT x[:i], y[$2].z FROM t",
+			want:  "SELECT x[3], y[2].z FROM t",
+		},
+		{
+			query: "SELECT x[:s] FROM t",
+			want:  "SELECT x.foo FROM t",
+		},
+		{
+			query: "SELECT x FROM t LIMIT :i",
+			want:  "SELECT x FROM t LIMIT 3",
+		},
+	}
+	for i := range testcases {
+		q, err := ParseParams([]byte(testcases[i].query), params)
+		if err != nil {
+			t.Errorf("%s: %s", testcases[i].query, err)
+			continue
+		}
+		if got := q.Text(); got != testcases[i].want {
+			t.Errorf("%s: got %q, want %q", testcases[i].query, got, testcases[i].want)
+		}
+		// without values, only the parameters
+		// in place of a datum are accepted
+		_, err = Parse([]byte(testcases[i].query))
+		if err == nil && !strings.Contains(testcases[i].query, "IN (") {
+			t.Errorf("%s: expected an error without parameter values", testcases[i].query)
+		}
+	}
+
+	_, err := ParseParams([]byte("SELECT x FROM t WHERE x LIKE :missing"), params)
+	if err == nil || !strings.Contains(err.Error(), "1:30: no value for parameter :missing") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
 func TestParseIdentifiers(t *testing.T) {
 	operators := []string{
 		"+",
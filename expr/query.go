@@ -45,6 +45,10 @@ func (c *CTE) Equals(other *CTE) bool {
 // Query contains a complete query.
 type Query struct {
 	Explain ExplainFormat
+	// Analyze is set for EXPLAIN ANALYZE,
+	// which executes the query and reports
+	// the runtime statistics of the plan.
+	Analyze bool
 
 	With []CTE
 	// Into, if non-nil, is the INTO
@@ -73,15 +77,19 @@ func (q *Query) Text() string {
 }
 
 func (q *Query) text(dst *strings.Builder, redact bool) {
-	switch q.Explain {
-	case ExplainDefault:
+	if q.Explain != ExplainNone {
 		dst.WriteString("EXPLAIN ")
+		if q.Analyze {
+			dst.WriteString("ANALYZE ")
+		}
+	}
+	switch q.Explain {
 	case ExplainText:
-		dst.WriteString("EXPLAIN AS text ")
+		dst.WriteString("AS text ")
 	case ExplainList:
-		dst.WriteString("EXPLAIN AS list ")
+		dst.WriteString("AS list ")
 	case ExplainGraphviz:
-		dst.WriteString("EXPLAIN AS graphviz ")
+		dst.WriteString("AS graphviz ")
 	}
 
 	if len(q.With) > 0 {
@@ -137,6 +145,10 @@ func (q *Query) Encode(dst *ion.Buffer, st *ion.Symtab) {
 
 	field("explain")
 	dst.WriteInt(int64(q.Explain))
+	if q.Analyze {
+		field("analyze")
+		dst.WriteBool(true)
+	}
 
 	if len(q.With) > 0 {
 		field("with")
@@ -185,6 +197,8 @@ func (q *Query) SetField(f ion.Field) error {
 			return err
 		}
 		q.Explain = ExplainFormat(v)
+	case "analyze":
+		q.Analyze, err = f.Bool()
 	case "with":
 		hastable := false
 		var table string
@@ -256,6 +270,7 @@ func (q *Query) Check() error {
 func (q *Query) Clone() *Query {
 	ret := &Query{
 		Explain: q.Explain,
+		Analyze: q.Analyze,
 		With:    slices.Clone(q.With),
 		Into:    Copy(q.Into),
 		Body:    Copy(q.Body),
@@ -15,10 +15,13 @@
 package sneller
 
 import (
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"path"
+	"slices"
 	"time"
 
 	"github.com/SnellerInc/sneller/date"
@@ -45,15 +48,34 @@ type savedList struct {
 	list []string
 }
 
+// savedView holds the definition of a view,
+// or nil if there is no view with that name
+type savedView struct {
+	db, name string
+	view     *db.View
+}
+
 // FSEnv provides a plan.Env from a db.FS
 type FSEnv struct {
 	Root db.InputFS
 
+	// Snapshot, if non-empty, is the id of the
+	// snapshot (see db.WriteSnapshot) of the indexes
+	// that is queried instead of the current indexes.
+	Snapshot string
+	// Pin determines whether a missing snapshot
+	// is created from the current index.
+	Pin bool
+
 	db     string
 	tenant db.Tenant
 
 	recent []savedIndex
 	lists  []savedList
+	views  []savedView
+	// tables are the tables and views
+	// referenced so far (see Tables)
+	tables []string
 
 	// FIXME: change cachedEnv and don't
 	// keep the accumulated state here:
@@ -94,23 +116,27 @@ func (f *FSEnv) Index(p expr.Node) (plan.Index, error) {
 	return f.index(p)
 }
 
-func (f *FSEnv) index(e expr.Node) (*blockfmt.Index, error) {
-	var dbname, table string
-	var err error
-
+// tableName returns the database and
+// table referenced by a table expression
+func (f *FSEnv) tableName(e expr.Node) (dbname, table string, err error) {
 	switch e := e.(type) {
 	case expr.Ident:
-		dbname = f.db
-		table = string(e)
+		return f.db, string(e), nil
 	case *expr.Dot:
 		id, ok := e.Inner.(expr.Ident)
 		if !ok {
-			return nil, syntax("trailing path expression %q in table not supported", expr.ToString(e.Inner))
+			return "", "", syntax("trailing path expression %q in table not supported", expr.ToString(e.Inner))
 		}
-		dbname = string(id)
-		table = e.Field
+		return string(id), e.Field, nil
 	default:
-		return nil, syntax("unexpected table expression %q", expr.ToString(e))
+		return "", "", syntax("unexpected table expression %q", expr.ToString(e))
+	}
+}
+
+func (f *FSEnv) index(e expr.Node) (*blockfmt.Index, error) {
+	dbname, table, err := f.tableName(e)
+	if err != nil {
+		return nil, err
 	}
 	// if a query references the same table
 	// more than once (common with CTEs, nested SELECTs, etc.),
@@ -120,7 +146,12 @@ func (f *FSEnv) index(e expr.Node) (*blockfmt.Index, error) {
 			return f.recent[i].index, nil
 		}
 	}
-	index, err := db.OpenPartialIndex(f.Root, dbname, table, f.tenant.Key())
+	var index *blockfmt.Index
+	if f.Snapshot != "" {
+		index, err = f.snapshot(dbname, table)
+	} else {
+		index, err = db.OpenPartialIndex(f.Root, dbname, table, f.tenant.Key())
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +160,7 @@ func (f *FSEnv) index(e expr.Node) (*blockfmt.Index, error) {
 		table: table,
 		index: index,
 	})
+	f.reference(dbname, table)
 	if f.modtime.IsZero() || f.modtime.Before(index.Created) {
 		f.modtime = index.Created
 	}
@@ -141,6 +173,22 @@ func (f *FSEnv) index(e expr.Node) (*blockfmt.Index, error) {
 	return index, nil
 }
 
+// snapshot opens (and if f.Pin is set, creates)
+// the snapshot f.Snapshot of the index of a table
+func (f *FSEnv) snapshot(dbname, table string) (*blockfmt.Index, error) {
+	if f.Pin {
+		ofs, ok := f.Root.(db.OutputFS)
+		if !ok {
+			return nil, fmt.Errorf("cannot create snapshots in %T", f.Root)
+		}
+		err := db.WriteSnapshot(ofs, dbname, table, f.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db.OpenSnapshot(f.Root, dbname, table, f.Snapshot, f.tenant.Key())
+}
+
 // MaxScanned returns the maximum number of
 // bytes that need to be scanned to satisfy this query.
 func (f *FSEnv) MaxScanned() int64 { return f.maxscan }
@@ -171,6 +219,66 @@ func (f *FSEnv) Stat(e expr.Node, h *plan.Hints) (*plan.Input, error) {
 	}, nil
 }
 
+var _ plan.Viewer = (*FSEnv)(nil)
+
+// View implements plan.Viewer.View
+func (f *FSEnv) View(e expr.Node) (*expr.Query, error) {
+	dbname, name, err := f.tableName(e)
+	if err != nil || dbname == "" {
+		// let Stat report bad table expressions
+		return nil, nil
+	}
+	v, err := f.view(dbname, name)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	q, err := ParseView(dbname, v.Query)
+	if err != nil {
+		return nil, fmt.Errorf("view %s.%s: %w", dbname, name, err)
+	}
+	// changing the view changes the query results
+	io.WriteString(f.hash, path.Join(dbname, name))
+	io.WriteString(f.hash, v.Query)
+	f.reference(dbname, name)
+	return q, nil
+}
+
+// view returns the definition of a view, or nil
+// if the view doesn't exist; the result is saved,
+// so each view is only looked up once
+func (f *FSEnv) view(dbname, name string) (*db.View, error) {
+	for i := range f.views {
+		if f.views[i].db == dbname && f.views[i].name == name {
+			return f.views[i].view, nil
+		}
+	}
+	v, err := db.OpenView(f.Root, dbname, name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		v = nil
+	}
+	f.views = append(f.views, savedView{
+		db:   dbname,
+		name: name,
+		view: v,
+	})
+	return v, nil
+}
+
+func (f *FSEnv) reference(dbname, table string) {
+	name := dbname + "." + table
+	if !slices.Contains(f.tables, name) {
+		f.tables = append(f.tables, name)
+	}
+}
+
+// Tables returns the names ("db.table") of the
+// tables and views referenced by the queries
+// planned with f so far, in order of first reference.
+func (f *FSEnv) Tables() []string { return f.tables }
+
 var _ plan.TableLister = (*FSEnv)(nil)
 
 // ListTables implements plan.TableLister.ListTables
@@ -182,6 +182,9 @@ type CompressionWriter struct {
 	// metadata to be attached
 	// to the next block
 	futureRange
+
+	// shape of the data written so far
+	shape shapeBuilder
 }
 
 // WrittenBlocks returns the number of blocks
@@ -261,6 +264,26 @@ func pickPrefix(t *Trailer, minchunks int) (index int, offset int64) {
 	return index, offset
 }
 
+// prefixShape returns the shape of the first j
+// blocks of t, which is extrapolated from the
+// shape of the whole object
+func prefixShape(t *Trailer, j int) Shape {
+	if t.Shape.Empty() {
+		// the prefix data is not summarized
+		return Shape{Approximate: true, Incomplete: true}
+	}
+	prefix, total := 0, 0
+	for i := range t.Blocks {
+		if i < j {
+			prefix += t.Blocks[i].Chunks
+		}
+		total += t.Blocks[i].Chunks
+	}
+	s := t.Shape.Clone()
+	s.scale(int64(prefix), int64(total))
+	return s
+}
+
 // consume maybe *some* of an existing object
 // without doing any heavy lifting w.r.t compression
 func (w *CompressionWriter) writeStart(r io.Reader, t *Trailer) error {
@@ -281,6 +304,7 @@ func (w *CompressionWriter) writeStart(r io.Reader, t *Trailer) error {
 	// set the currently-output state:
 	w.Trailer.Blocks = t.Blocks[:j]
 	w.Trailer.Sparse = t.Sparse.Trim(j)
+	w.Trailer.Shape = prefixShape(t, j)
 	// set the state of what we expect to consume:
 	t.Blocks = t.Blocks[j:]
 	t.Sparse = t.Sparse.Slice(j, t.Sparse.Blocks())
@@ -317,7 +341,8 @@ func (w *CompressionWriter) setSymbols(st *ion.Symtab) {
 	w.Comp.(*zionCompressor).enc.SetSymbols(st)
 }
 
-func (w *CompressionWriter) writeCompressed(p []byte) error {
+func (w *CompressionWriter) writeCompressed(p, decompressed []byte) error {
+	w.shape.observe(decompressed)
 	before := len(w.buffer)
 	w.buffer = appendRawFrame(w.buffer, p)
 	return w.checkFlush(before)
@@ -335,6 +360,9 @@ func (w *CompressionWriter) Write(p []byte) (n int, err error) {
 	if w.flushblocks == 0 && !w.skipChecks && !ion.IsBVM(p) {
 		return 0, fmt.Errorf("blockfmt.CompressionWriter.Write: blocks flushed, but no BVM")
 	}
+	if !w.skipChecks {
+		w.shape.observe(p)
+	}
 	before := len(w.buffer)
 	w.buffer, err = appendFrame(w.buffer, w.Comp, p)
 	if err != nil {
@@ -386,6 +414,8 @@ func (w *CompressionWriter) Close() error {
 		panic("missing Flush before Close")
 	}
 	finalize(&w.Trailer, w.blocks, w.MinChunksPerBlock)
+	shape := w.shape.shape()
+	w.Trailer.Shape.merge(&shape, MaxTrailerShapeFields)
 	w.Trailer.Offset = w.offset
 	trailer := w.Trailer.trailer(w.Comp.Name(), w.InputAlign)
 	w.offset += int64(len(trailer))
@@ -57,6 +57,7 @@ func (c *concat) add(src *Descriptor) bool {
 		dt.Version = t.Version
 		dt.BlockShift = t.BlockShift
 		dt.Sparse = t.Sparse.Clone()
+		dt.Shape = t.Shape.Clone()
 	} else {
 		dt := &c.output.Trailer
 		// ensure trailer is compatible
@@ -66,6 +67,11 @@ func (c *concat) add(src *Descriptor) bool {
 			!dt.Sparse.Append(&t.Sparse) {
 			return false
 		}
+		if t.Shape.Empty() != dt.Shape.Empty() {
+			// one side was written without a shape
+			dt.Shape.Incomplete = true
+		}
+		dt.Shape.merge(&t.Shape, MaxTrailerShapeFields)
 	}
 	for i := range t.Blocks {
 		dt.Blocks = append(dt.Blocks, Blockdesc{
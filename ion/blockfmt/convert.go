@@ -690,7 +690,7 @@ func (c *Converter) fastPrepend(tw trailerWriter) error {
 }
 
 type compressWriter interface {
-	writeCompressed(p []byte) error
+	writeCompressed(p, decompressed []byte) error
 	setSymbols(st *ion.Symtab)
 }
 
@@ -752,7 +752,7 @@ func (f *fastWriter) Write(p []byte) (int, error) {
 			}
 			f.skipped += f.dst.Align
 			f.maxchunks--
-			return len(p), f.inner.writeCompressed(p)
+			return len(p), f.inner.writeCompressed(p, f.tmp)
 		}
 		f.slowpath = true
 		if f.skipped > 0 {
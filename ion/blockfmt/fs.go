@@ -78,7 +78,10 @@ type S3FS struct {
 
 // Prefix implements InputFS.Prefix
 func (s *S3FS) Prefix() string {
-	return "s3://" + s.Bucket + "/"
+	if s.Root == "" {
+		return "s3://" + s.Bucket + "/"
+	}
+	return "s3://" + s.Bucket + "/" + s.Root + "/"
 }
 
 // ETag implements InputFS.ETag
@@ -94,7 +97,7 @@ func (s *S3FS) Create(path string) (Uploader, error) {
 	up := &s3.Uploader{
 		Key:    s.Key,
 		Bucket: s.Bucket,
-		Object: path,
+		Object: s.ObjectKey(path),
 	}
 	err := up.Start()
 	if err != nil {
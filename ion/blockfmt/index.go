@@ -786,6 +786,58 @@ func (idx *Index) TimeRange(path []string) (min, max date.Time, ok bool) {
 	return min, max, ok
 }
 
+// Shape returns the union of the shapes of all
+// the objects pointed to by this Index.
+// Shape does not need to read any indirect
+// references, since each reference carries
+// a summary of the objects it points to.
+// If any of the objects was written without
+// a shape, the returned Shape is Incomplete.
+func (idx *Index) Shape() Shape {
+	var s Shape
+	for i := range idx.Indirect.Refs {
+		r := &idx.Indirect.Refs[i]
+		if r.Shape.Empty() {
+			s.Incomplete = true
+		}
+		s.Merge(&r.Shape)
+	}
+	for i := range idx.Inline {
+		t := &idx.Inline[i].Trailer
+		if t.Shape.Empty() {
+			s.Incomplete = true
+		}
+		s.Merge(&t.Shape)
+	}
+	return s
+}
+
+// Stats returns the number of rows and the number
+// of decompressed bytes in the objects pointed to by
+// this Index. The row count is approximate if any
+// of the objects have an approximate Shape.
+// If any object was written without recording
+// these statistics, then ok is false.
+func (idx *Index) Stats() (rows, size int64, ok bool) {
+	for i := range idx.Indirect.Refs {
+		r := &idx.Indirect.Refs[i]
+		if r.Decompressed == 0 || r.Shape.Empty() || r.Shape.Incomplete {
+			return 0, 0, false
+		}
+		rows += r.Shape.Rows
+		size += r.Decompressed
+	}
+	for i := range idx.Inline {
+		t := &idx.Inline[i].Trailer
+		if t.Shape.Empty() || t.Shape.Incomplete {
+			return 0, 0, false
+		}
+		rows += t.Shape.Rows
+		size += t.Decompressed()
+	}
+	return rows, size, true
+}
+
 // Objects returns the number of packed objects
 // that are pointed to by this Index.
 func (idx *Index) Objects() int {
@@ -115,6 +115,7 @@ type singleStream struct {
 	comp        Compressor
 	lastblock   int64
 	flushblocks int
+	shape       shapeBuilder
 
 	bg chan error
 }
@@ -187,6 +188,7 @@ func (m *MultiWriter) writeStart(r io.Reader, t *Trailer) error {
 	m.base = offset
 	m.Trailer.Blocks = t.Blocks[:j]
 	m.Trailer.Sparse = t.Sparse.Trim(j)
+	m.Trailer.Shape = prefixShape(t, j)
 	t.Blocks = t.Blocks[j:]
 	t.Sparse = t.Sparse.Slice(j, t.Sparse.Blocks())
 	return nil
@@ -266,6 +268,9 @@ func (s *singleStream) Write(p []byte) (int, error) {
 	if s.flushblocks == 0 && !s.parent.skipChecks && !ion.IsBVM(p) {
 		return 0, fmt.Errorf("blockfmt.MultiWriter: flush, but then no BVM")
 	}
+	if !s.parent.skipChecks {
+		s.shape.observe(p)
+	}
 	s.flushblocks++
 	var err error
 	s.buf, err = appendFrame(s.buf, s.comp, p)
@@ -276,7 +281,8 @@ func (s *singleStream) setSymbols(st *ion.Symtab) {
 	s.comp.(*zionCompressor).enc.SetSymbols(st)
 }
 
-func (s *singleStream) writeCompressed(p []byte) error {
+func (s *singleStream) writeCompressed(p, decompressed []byte) error {
+	s.shape.observe(decompressed)
 	s.flushblocks++
 	s.buf = appendRawFrame(s.buf, p)
 	return nil
@@ -367,6 +373,10 @@ func (s *singleStream) Close() error {
 	if s.flushblocks != 0 {
 		return fmt.Errorf("singleStream.Close() missing call to Flush() first")
 	}
+	shape := s.shape.shape()
+	s.parent.lock.Lock()
+	s.parent.Trailer.Shape.merge(&shape, MaxTrailerShapeFields)
+	s.parent.lock.Unlock()
 	defer func() {
 		if s.comp != nil {
 			s.comp.Close()
@@ -51,6 +51,14 @@ type IndirectRef struct {
 	// that were compacted to produce the
 	// packfiles pointed to by Path.
 	OrigObjects int
+	// Decompressed is the total decompressed
+	// size of all the objects inside the packed
+	// file pointed to by Path.
+	Decompressed int64
+	// Shape is the union of the shapes
+	// of all the objects inside the packed
+	// file pointed to by Path.
+	Shape Shape
 
 	// for decoding compatibility only!
 	ranges []Range
@@ -74,6 +82,8 @@ func (i *IndirectTree) encode(st *ion.Symtab, buf *ion.Buffer) {
 	size := st.Intern("size")
 	objects := st.Intern("objects")
 	origObjects := st.Intern("orig-objects")
+	decompressed := st.Intern("decompressed")
+	shape := st.Intern("shape")
 
 	buf.BeginStruct(-1)
 	buf.BeginField(st.Intern("refs"))
@@ -92,6 +102,14 @@ func (i *IndirectTree) encode(st *ion.Symtab, buf *ion.Buffer) {
 		buf.WriteInt(int64(i.Refs[j].Objects))
 		buf.BeginField(origObjects)
 		buf.WriteInt(int64(i.Refs[j].OrigObjects))
+		if i.Refs[j].Decompressed > 0 {
+			buf.BeginField(decompressed)
+			buf.WriteInt(i.Refs[j].Decompressed)
+		}
+		if s := &i.Refs[j].Shape; !s.Empty() || s.Incomplete {
+			buf.BeginField(shape)
+			i.Refs[j].Shape.Encode(buf, st)
+		}
 		buf.EndStruct()
 	}
 	buf.EndList()
@@ -133,6 +151,15 @@ func (i *IndirectTree) parse(td *TrailerDecoder, d ion.Datum) error {
 						}
 						ir.OrigObjects = int(n)
 						return nil
+					case "decompressed":
+						n, err := f.Int()
+						if err != nil {
+							return err
+						}
+						ir.Decompressed = n
+						return nil
+					case "shape":
+						return td.decodeShape(&ir.Shape, f.Datum)
 					default:
 						_, err := ir.ObjectInfo.set(f)
 						return err
@@ -432,6 +459,15 @@ func (c *IndexConfig) append(idx *Index, ofs UploadFS, basedir string, lst []Des
 	r.Size = int64(len(compressed))
 	r.Objects = len(all)
 	r.OrigObjects += delta
+	r.Decompressed = 0
+	r.Shape = Shape{}
+	for j := range all {
+		r.Decompressed += all[j].Trailer.Decompressed()
+		if all[j].Trailer.Shape.Empty() {
+			r.Shape.Incomplete = true
+		}
+		r.Shape.Merge(&all[j].Trailer.Shape)
+	}
 
 	info, err := fs.Stat(ofs, p)
 	if err != nil {
@@ -214,6 +214,16 @@ func TestIndirectTree(t *testing.T) {
 
 		gotAll := allRefs(idx)
 		assertEquivalent(gotAll, all)
+		gotSize, wantSize := int64(0), int64(len(all))*ds
+		for j := range idx.Indirect.Refs {
+			gotSize += idx.Indirect.Refs[j].Decompressed
+		}
+		for j := range idx.Inline {
+			gotSize += idx.Inline[j].Trailer.Decompressed()
+		}
+		if gotSize != wantSize {
+			t.Errorf("iter %d decompressed size %d, want %d", i, gotSize, wantSize)
+		}
 		if idx.Indirect.OrigObjects() > 0 {
 			field := []string{"timestamp"}
 			tr := idx.Indirect.Sparse.Get(field)
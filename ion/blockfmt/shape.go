@@ -0,0 +1,601 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package blockfmt
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+
+	"github.com/SnellerInc/sneller/ion"
+
+	"github.com/dchest/siphash"
+)
+
+// MaxShapeFields is the maximum number of
+// distinct field paths recorded in a Shape.
+const MaxShapeFields = 512
+
+// MaxTrailerShapeFields is the maximum number of
+// distinct field paths recorded in the Shape of
+// a single Trailer. It is much lower than MaxShapeFields
+// because the trailers of inline descriptors are
+// stored in the index, and the size of the index is limited.
+const MaxTrailerShapeFields = 64
+
+// ShapeItems is the path component used
+// to represent the elements of a list.
+const ShapeItems = "$items"
+
+// shapeTypes is the list of type names
+// recorded in a shape; the names match
+// the ones produced by SNELLER_DATASHAPE(*)
+var shapeTypes = [...]string{
+	"null",
+	"bool",
+	"int",
+	"float",
+	"decimal",
+	"timestamp",
+	"string",
+	"list",
+	"struct",
+	"sexp",
+	"clob",
+	"blob",
+}
+
+const numShapeTypes = len(shapeTypes)
+
+// shapeType maps an ion type to an index in shapeTypes
+func shapeType(t ion.Type) int {
+	switch t {
+	case ion.NullType:
+		return 0
+	case ion.BoolType:
+		return 1
+	case ion.UintType, ion.IntType:
+		return 2
+	case ion.FloatType:
+		return 3
+	case ion.DecimalType:
+		return 4
+	case ion.TimestampType:
+		return 5
+	case ion.SymbolType, ion.StringType:
+		return 6
+	case ion.ListType:
+		return 7
+	case ion.StructType:
+		return 8
+	case ion.SexpType:
+		return 9
+	case ion.ClobType:
+		return 10
+	case ion.BlobType:
+		return 11
+	}
+	return -1
+}
+
+// sketchBits is log2 of the number of
+// registers in a distinct-count sketch
+const sketchBits = 6
+
+// sketch is a small HyperLogLog sketch
+// used to estimate distinct value counts
+type sketch [1 << sketchBits]uint8
+
+func (s *sketch) add(h uint64) {
+	j := h & (1<<sketchBits - 1)
+	w := h >> sketchBits
+	// w has sketchBits leading zeros by construction
+	rank := uint8(bits.LeadingZeros64(w)-sketchBits) + 1
+	if rank > s[j] {
+		s[j] = rank
+	}
+}
+
+func (s *sketch) merge(o *sketch) {
+	for i := range s {
+		if o[i] > s[i] {
+			s[i] = o[i]
+		}
+	}
+}
+
+func (s *sketch) empty() bool {
+	for i := range s {
+		if s[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sketch) estimate() float64 {
+	const m = float64(len(s))
+	alpha := 0.709 // alpha_64; see the HyperLogLog paper
+	sum := 0.0
+	zeros := 0
+	for i := range s {
+		sum += math.Ldexp(1, -int(s[i]))
+		if s[i] == 0 {
+			zeros++
+		}
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// small range correction
+		e = m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// FieldShape is a summary of the values
+// present at a particular path.
+type FieldShape struct {
+	// Path is the path to the field.
+	// Elements of lists are represented
+	// with the ShapeItems path component.
+	Path []string
+	// Types maps type names (the same names
+	// used by SNELLER_DATASHAPE(*)) to the number
+	// of values of that type.
+	Types map[string]int64
+
+	distinct sketch
+}
+
+// Name returns the path joined with '.'
+func (f *FieldShape) Name() string {
+	return strings.Join(f.Path, ".")
+}
+
+// Count returns the number of values
+// (of any type) present at f.Path.
+func (f *FieldShape) Count() int64 {
+	n := int64(0)
+	for _, c := range f.Types {
+		n += c
+	}
+	return n
+}
+
+// Distinct returns the approximate number of
+// distinct scalar values present at f.Path.
+func (f *FieldShape) Distinct() int64 {
+	if f.distinct.empty() {
+		return 0
+	}
+	n := int64(math.Round(f.distinct.estimate()))
+	if c := f.Count(); n > c {
+		n = c
+	}
+	return n
+}
+
+func (f *FieldShape) merge(o *FieldShape) {
+	if f.Types == nil {
+		f.Types = make(map[string]int64, len(o.Types))
+	}
+	for k, v := range o.Types {
+		f.Types[k] += v
+	}
+	f.distinct.merge(&o.distinct)
+}
+
+// Shape is a compact summary of the field paths
+// and value types present in an object, along with
+// approximate distinct counts for each path.
+//
+// Shapes are computed while objects are written
+// and stored in the object Trailer, so that
+// schema information is available without
+// scanning any data. Shapes merge cheaply, so
+// the shape of a whole table is the union of
+// the shapes of its objects (see Index.Shape).
+type Shape struct {
+	// Rows is the number of rows summarized.
+	Rows int64
+	// Fields is the list of fields, sorted by path.
+	Fields []FieldShape
+	// Truncated is set if more than
+	// MaxShapeFields distinct paths were
+	// present and some were not recorded.
+	Truncated bool
+	// Approximate is set if the counts in
+	// the shape were extrapolated rather
+	// than computed directly.
+	Approximate bool
+	// Incomplete is set if some of the
+	// summarized data was written without
+	// a shape, so the shape doesn't describe
+	// all of the data.
+	Incomplete bool
+}
+
+// Empty returns true if the shape summarizes no rows.
+func (s *Shape) Empty() bool {
+	return s.Rows == 0 && len(s.Fields) == 0
+}
+
+// Get returns the field summary for a path,
+// or nil if no such path is present.
+func (s *Shape) Get(path []string) *FieldShape {
+	i, ok := slices.BinarySearchFunc(s.Fields, path, func(f FieldShape, p []string) int {
+		return slices.Compare(f.Path, p)
+	})
+	if !ok {
+		return nil
+	}
+	return &s.Fields[i]
+}
+
+// Merge merges o into s.
+func (s *Shape) Merge(o *Shape) {
+	s.merge(o, MaxShapeFields)
+}
+
+// merge merges o into s, recording
+// no more than limit field paths
+func (s *Shape) merge(o *Shape, limit int) {
+	s.Rows += o.Rows
+	s.Truncated = s.Truncated || o.Truncated
+	s.Approximate = s.Approximate || o.Approximate
+	s.Incomplete = s.Incomplete || o.Incomplete
+	for i := range o.Fields {
+		j, ok := slices.BinarySearchFunc(s.Fields, o.Fields[i].Path, func(f FieldShape, p []string) int {
+			return slices.Compare(f.Path, p)
+		})
+		if ok {
+			s.Fields[j].merge(&o.Fields[i])
+			continue
+		}
+		if len(s.Fields) >= limit {
+			s.Truncated = true
+			continue
+		}
+		f := FieldShape{Path: o.Fields[i].Path}
+		f.merge(&o.Fields[i])
+		s.Fields = slices.Insert(s.Fields, j, f)
+	}
+}
+
+// Clone returns a deep copy of s.
+func (s *Shape) Clone() Shape {
+	var out Shape
+	out.Merge(s)
+	return out
+}
+
+// scale scales the counts in the shape
+// by the fraction num/den
+func (s *Shape) scale(num, den int64) {
+	if num == den || den == 0 {
+		return
+	}
+	frac := func(v int64) int64 {
+		return int64(math.Round(float64(v) * float64(num) / float64(den)))
+	}
+	s.Rows = frac(s.Rows)
+	for i := range s.Fields {
+		for k, v := range s.Fields[i].Types {
+			s.Fields[i].Types[k] = frac(v)
+		}
+	}
+	s.Approximate = true
+}
+
+// Encode encodes the shape into dst
+// using the provided symbol table.
+func (s *Shape) Encode(dst *ion.Buffer, st *ion.Symtab) {
+	dst.BeginStruct(-1)
+	dst.BeginField(st.Intern("rows"))
+	dst.WriteInt(s.Rows)
+	if s.Truncated {
+		dst.BeginField(st.Intern("truncated"))
+		dst.WriteBool(true)
+	}
+	if s.Approximate {
+		dst.BeginField(st.Intern("approximate"))
+		dst.WriteBool(true)
+	}
+	if s.Incomplete {
+		dst.BeginField(st.Intern("incomplete"))
+		dst.WriteBool(true)
+	}
+	dst.BeginField(st.Intern("fields"))
+	dst.BeginList(-1)
+	var (
+		path     = st.Intern("path")
+		types    = st.Intern("types")
+		distinct = st.Intern("distinct")
+	)
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		dst.BeginStruct(-1)
+		dst.BeginField(path)
+		dst.BeginList(-1)
+		for j := range f.Path {
+			dst.WriteSymbol(st.Intern(f.Path[j]))
+		}
+		dst.EndList()
+		dst.BeginField(types)
+		dst.BeginStruct(-1)
+		for _, name := range shapeTypes {
+			if n := f.Types[name]; n != 0 {
+				dst.BeginField(st.Intern(name))
+				dst.WriteInt(n)
+			}
+		}
+		dst.EndStruct()
+		if !f.distinct.empty() {
+			dst.BeginField(distinct)
+			dst.WriteBlob(f.distinct[:])
+		}
+		dst.EndStruct()
+	}
+	dst.EndList()
+	dst.EndStruct()
+}
+
+func (d *TrailerDecoder) decodeShape(s *Shape, v ion.Datum) error {
+	return v.UnpackStruct(func(f ion.Field) error {
+		var err error
+		switch f.Label {
+		case "rows":
+			s.Rows, err = f.Int()
+		case "truncated":
+			s.Truncated, err = f.Bool()
+		case "approximate":
+			s.Approximate, err = f.Bool()
+		case "incomplete":
+			s.Incomplete, err = f.Bool()
+		case "fields":
+			err = f.UnpackList(func(v ion.Datum) error {
+				var fs FieldShape
+				err := v.UnpackStruct(func(f ion.Field) error {
+					var err error
+					switch f.Label {
+					case "path":
+						fs.Path, err = d.path(f.Datum)
+					case "types":
+						fs.Types = make(map[string]int64)
+						err = f.UnpackStruct(func(f ion.Field) error {
+							n, err := f.Int()
+							if err != nil {
+								return err
+							}
+							fs.Types[f.Label] = n
+							return nil
+						})
+					case "distinct":
+						var b []byte
+						b, err = f.BlobShared()
+						if err == nil && len(b) != len(fs.distinct) {
+							err = fmt.Errorf("unexpected distinct sketch size %d", len(b))
+						}
+						copy(fs.distinct[:], b)
+					}
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				s.Fields = append(s.Fields, fs)
+				return nil
+			})
+		}
+		return err
+	})
+}
+
+// shapeNode is a trie node holding the
+// statistics for one path in a shapeBuilder
+type shapeNode struct {
+	counts   [numShapeTypes]int64
+	distinct sketch
+	children map[string]*shapeNode
+}
+
+// shapeBuilder accumulates a Shape from
+// aligned chunks of ion data
+type shapeBuilder struct {
+	st        ion.Symtab
+	symhash   []cachedHash // cached hashes of symbol text
+	rows      int64
+	fields    int
+	truncated bool
+	root      shapeNode
+}
+
+// cachedHash is the cached hash
+// of the text of a symbol
+type cachedHash struct {
+	hash uint64
+	ok   bool
+}
+
+const (
+	shapeK0 = 0x736e656c6c657273
+	shapeK1 = 0x6461746173686170
+)
+
+func (b *shapeBuilder) child(n *shapeNode, name string) *shapeNode {
+	c := n.children[name]
+	if c != nil {
+		return c
+	}
+	if b.fields >= MaxTrailerShapeFields {
+		b.truncated = true
+		return nil
+	}
+	if n.children == nil {
+		n.children = make(map[string]*shapeNode)
+	}
+	c = &shapeNode{}
+	n.children[name] = c
+	b.fields++
+	return c
+}
+
+// symbolHash returns the hash of the text of a symbol,
+// which is the same as the hash of a string with that text
+func (b *shapeBuilder) symbolHash(sym ion.Symbol) uint64 {
+	if int(sym) < len(b.symhash) && b.symhash[sym].ok {
+		return b.symhash[sym].hash
+	}
+	str, _ := b.st.Lookup(sym)
+	h := siphash.Hash(shapeK0^uint64(ion.StringType), shapeK1, []byte(str))
+	if n := int(sym) + 1; n > len(b.symhash) {
+		prev := len(b.symhash)
+		b.symhash = slices.Grow(b.symhash, n-prev)[:n]
+		clear(b.symhash[prev:])
+	}
+	b.symhash[sym] = cachedHash{hash: h, ok: true}
+	return h
+}
+
+// observe adds the rows in one chunk
+// of ion data to the shape; chunks must be
+// provided in the order they were written
+// so that symbol tables are resolved correctly
+func (b *shapeBuilder) observe(chunk []byte) {
+	var err error
+	for len(chunk) > 0 {
+		if ion.IsBVM(chunk) || ion.TypeOf(chunk) == ion.AnnotationType {
+			if ion.IsBVM(chunk) {
+				b.symhash = b.symhash[:0]
+			}
+			chunk, err = b.st.Unmarshal(chunk)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		size := ion.SizeOf(chunk)
+		if size <= 0 || size > len(chunk) {
+			return
+		}
+		if ion.TypeOf(chunk) == ion.StructType {
+			b.rows++
+			body, _ := ion.Contents(chunk[:size])
+			b.walkStruct(&b.root, body)
+		}
+		chunk = chunk[size:]
+	}
+}
+
+func (b *shapeBuilder) walkStruct(n *shapeNode, body []byte) {
+	for len(body) > 0 {
+		sym, rest, err := ion.ReadLabel(body)
+		if err != nil {
+			return
+		}
+		size := ion.SizeOf(rest)
+		if size <= 0 || size > len(rest) {
+			return
+		}
+		name, ok := b.st.Lookup(sym)
+		if ok {
+			if c := b.child(n, name); c != nil {
+				b.walkValue(c, rest[:size])
+			}
+		}
+		body = rest[size:]
+	}
+}
+
+func (b *shapeBuilder) walkValue(n *shapeNode, val []byte) {
+	t := ion.TypeOf(val)
+	if val[0]&0x0f == 0x0f {
+		// typed null
+		t = ion.NullType
+	} else if t == ion.NullType {
+		return // nop pad
+	}
+	j := shapeType(t)
+	if j < 0 {
+		return
+	}
+	n.counts[j]++
+	switch t {
+	case ion.NullType:
+		return
+	case ion.StructType:
+		body, _ := ion.Contents(val)
+		b.walkStruct(n, body)
+		return
+	case ion.ListType:
+		c := b.child(n, ShapeItems)
+		if c == nil {
+			return
+		}
+		body, _ := ion.Contents(val)
+		for len(body) > 0 {
+			size := ion.SizeOf(body)
+			if size <= 0 || size > len(body) {
+				return
+			}
+			b.walkValue(c, body[:size])
+			body = body[size:]
+		}
+		return
+	case ion.SymbolType:
+		sym, _, err := ion.ReadSymbol(val)
+		if err != nil {
+			return
+		}
+		n.distinct.add(b.symbolHash(sym))
+	case ion.StringType:
+		body, _ := ion.Contents(val)
+		n.distinct.add(siphash.Hash(shapeK0^uint64(ion.StringType), shapeK1, body))
+	default:
+		// the encoding of other scalars is
+		// canonical, so hash the whole value
+		n.distinct.add(siphash.Hash(shapeK0, shapeK1, val))
+	}
+}
+
+// shape produces the Shape accumulated so far
+func (b *shapeBuilder) shape() Shape {
+	s := Shape{Rows: b.rows, Truncated: b.truncated}
+	var walk func(path []string, n *shapeNode)
+	walk = func(path []string, n *shapeNode) {
+		for name, c := range n.children {
+			p := append(path[:len(path):len(path)], name)
+			f := FieldShape{
+				Path:     p,
+				Types:    make(map[string]int64),
+				distinct: c.distinct,
+			}
+			for i, v := range c.counts {
+				if v != 0 {
+					f.Types[shapeTypes[i]] = v
+				}
+			}
+			s.Fields = append(s.Fields, f)
+			walk(p, c)
+		}
+	}
+	walk(nil, &b.root)
+	slices.SortFunc(s.Fields, func(x, y FieldShape) int {
+		return slices.Compare(x.Path, y.Path)
+	})
+	return s
+}
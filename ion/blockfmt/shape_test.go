@@ -0,0 +1,239 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package blockfmt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/SnellerInc/sneller/ion"
+
+	"github.com/dchest/siphash"
+)
+
+func writeShapeRows(t *testing.T, n int) *Trailer {
+	return writeShapeData(t, n, func(i int) []ion.Field {
+		fields := []ion.Field{
+			{Label: "id", Datum: ion.Int(int64(i))},
+			{Label: "kind", Datum: ion.String(fmt.Sprintf("kind-%d", i%10))},
+			{Label: "tags", Datum: ion.NewList(nil, []ion.Datum{
+				ion.String("x"), ion.Int(int64(i % 3)),
+			}).Datum()},
+		}
+		if i%2 == 0 {
+			fields = append(fields, ion.Field{
+				Label: "inner",
+				Datum: ion.NewStruct(nil, []ion.Field{
+					{Label: "ok", Datum: ion.Bool(i%4 == 0)},
+				}).Datum(),
+			})
+		} else {
+			fields = append(fields, ion.Field{Label: "inner", Datum: ion.Null})
+		}
+		return fields
+	})
+}
+
+// writeShapeData writes n rows produced by
+// row and returns the trailer of the object
+func writeShapeData(t *testing.T, n int, row func(i int) []ion.Field) *Trailer {
+	var out BufferUploader
+	out.PartSize = 4096
+	w := &CompressionWriter{
+		Output:     &out,
+		Comp:       CompressorByName("zstd"),
+		InputAlign: 4096,
+	}
+	cn := ion.Chunker{W: w, Align: w.InputAlign, RangeAlign: 100 * w.InputAlign}
+	for i := 0; i < n; i++ {
+		ion.NewStruct(nil, row(i)).Encode(&cn.Buffer, &cn.Symbols)
+		if err := cn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(out.Bytes())
+	trailer, err := ReadTrailer(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trailer.Shape, w.Trailer.Shape) {
+		t.Fatal("shape did not round-trip")
+	}
+	return trailer
+}
+
+func TestShape(t *testing.T) {
+	const rows = 5000
+	trailer := writeShapeRows(t, rows)
+	s := &trailer.Shape
+	if s.Rows != rows {
+		t.Fatalf("got %d rows, expected %d", s.Rows, rows)
+	}
+	type want struct {
+		path     []string
+		types    map[string]int64
+		distinct int64 // approximate
+	}
+	for _, w := range []want{
+		{[]string{"id"}, map[string]int64{"int": rows}, rows},
+		{[]string{"kind"}, map[string]int64{"string": rows}, 10},
+		{[]string{"tags"}, map[string]int64{"list": rows}, 0},
+		{[]string{"tags", ShapeItems}, map[string]int64{"string": rows, "int": rows}, 4},
+		{[]string{"inner"}, map[string]int64{"struct": rows / 2, "null": rows / 2}, 0},
+		{[]string{"inner", "ok"}, map[string]int64{"bool": rows / 2}, 2},
+	} {
+		f := s.Get(w.path)
+		if f == nil {
+			t.Errorf("missing field %v", w.path)
+			continue
+		}
+		if !reflect.DeepEqual(f.Types, w.types) {
+			t.Errorf("%s: got types %v, expected %v", f.Name(), f.Types, w.types)
+		}
+		got := f.Distinct()
+		if d := got - w.distinct; d < -w.distinct/4 || d > w.distinct/4 {
+			t.Errorf("%s: got %d distinct, expected about %d", f.Name(), got, w.distinct)
+		}
+	}
+	if len(s.Fields) != 6 {
+		t.Errorf("got %d fields", len(s.Fields))
+	}
+
+	// merging doubles counts but not
+	// the approximate distinct counts
+	merged := s.Clone()
+	merged.Merge(s)
+	if merged.Rows != 2*rows {
+		t.Errorf("merged rows: %d", merged.Rows)
+	}
+	f := merged.Get([]string{"kind"})
+	if f.Count() != 2*rows || f.Distinct() != s.Get([]string{"kind"}).Distinct() {
+		t.Errorf("merged kind: count %d distinct %d", f.Count(), f.Distinct())
+	}
+}
+
+func TestShapeMixedSymbols(t *testing.T) {
+	// the same values encoded as strings and
+	// as symbols are counted as the same value
+	const rows = 2000
+	var st ion.Symtab
+	var body ion.Buffer
+	kind := st.Intern("kind")
+	for i := 0; i < rows; i++ {
+		text := fmt.Sprintf("kind-%d", i%200)
+		body.BeginStruct(-1)
+		body.BeginField(kind)
+		if i%2 == 0 {
+			body.WriteString(text)
+		} else {
+			body.WriteSymbol(st.Intern(text))
+		}
+		body.EndStruct()
+	}
+	var chunk ion.Buffer
+	st.Marshal(&chunk, true)
+	chunk.UnsafeAppend(body.Bytes())
+
+	var b shapeBuilder
+	b.observe(chunk.Bytes())
+	s := b.shape()
+	f := s.Get([]string{"kind"})
+	if f == nil {
+		t.Fatal("missing field kind")
+	}
+	want := map[string]int64{"string": rows}
+	if !reflect.DeepEqual(f.Types, want) {
+		t.Errorf("got types %v, expected %v", f.Types, want)
+	}
+	// the sketch is the same as the
+	// sketch of just the strings
+	var strs sketch
+	for i := 0; i < 200; i++ {
+		strs.add(siphash.Hash(shapeK0^uint64(ion.StringType), shapeK1, []byte(fmt.Sprintf("kind-%d", i))))
+	}
+	if f.distinct != strs {
+		t.Errorf("got %d distinct, expected %d", f.Distinct(), int64(math.Round(strs.estimate())))
+	}
+}
+
+func TestShapeTrailerLimit(t *testing.T) {
+	// each trailer records at most
+	// MaxTrailerShapeFields fields, but
+	// merged shapes can hold more
+	wide := func(base int) *Trailer {
+		return writeShapeData(t, 100, func(i int) []ion.Field {
+			var fields []ion.Field
+			for j := 0; j < MaxTrailerShapeFields+10; j++ {
+				fields = append(fields, ion.Field{
+					Label: fmt.Sprintf("f%03d", base+j),
+					Datum: ion.Int(int64(i)),
+				})
+			}
+			return fields
+		})
+	}
+	t0, t1 := wide(0), wide(MaxTrailerShapeFields+10)
+	for _, tr := range []*Trailer{t0, t1} {
+		if len(tr.Shape.Fields) != MaxTrailerShapeFields || !tr.Shape.Truncated {
+			t.Fatalf("trailer shape: %d fields, truncated=%v", len(tr.Shape.Fields), tr.Shape.Truncated)
+		}
+	}
+	merged := t0.Shape.Clone()
+	merged.Merge(&t1.Shape)
+	if len(merged.Fields) != 2*MaxTrailerShapeFields {
+		t.Errorf("merged shape: %d fields", len(merged.Fields))
+	}
+}
+
+func TestIndexShapeIncomplete(t *testing.T) {
+	trailer := writeShapeRows(t, 100)
+	shaped := Descriptor{Trailer: *trailer}
+	unshaped := Descriptor{Trailer: *trailer}
+	unshaped.Trailer.Shape = Shape{}
+
+	idx := &Index{Inline: []Descriptor{shaped, shaped}}
+	s := idx.Shape()
+	if s.Incomplete || s.Rows != 200 {
+		t.Fatalf("rows=%d incomplete=%v", s.Rows, s.Incomplete)
+	}
+	if _, _, ok := idx.Stats(); !ok {
+		t.Error("no stats for shaped objects")
+	}
+	idx.Inline = append(idx.Inline, unshaped)
+	s = idx.Shape()
+	if !s.Incomplete {
+		t.Error("shape with an unshaped object is not incomplete")
+	}
+	if _, _, ok := idx.Stats(); ok {
+		t.Error("stats for unshaped objects")
+	}
+	// the flag survives concatenation
+	c := &concat{}
+	if !c.add(&shaped) || !c.add(&unshaped) {
+		t.Fatal("cannot concatenate")
+	}
+	if !c.output.Trailer.Shape.Incomplete {
+		t.Error("concatenated shape is not incomplete")
+	}
+}
@@ -73,6 +73,11 @@ type Trailer struct {
 	// of timestamp ranges and constant fields
 	// within Blocks.
 	Sparse SparseIndex
+	// Shape is a summary of the fields
+	// and types present within Blocks.
+	// Shape may be empty for objects written
+	// before shapes were recorded.
+	Shape Shape
 }
 
 // Encode encodes a trailer to the provided buffer
@@ -104,6 +109,11 @@ func (t *Trailer) Encode(dst *ion.Buffer, st *ion.Symtab) {
 	dst.BeginField(st.Intern("sparse"))
 	t.Sparse.Encode(dst, st)
 
+	if !t.Shape.Empty() {
+		dst.BeginField(st.Intern("shape"))
+		t.Shape.Encode(dst, st)
+	}
+
 	// block offsets are double-differential-encoded
 	// (because they tend to be evenly spaced),
 	// and chunk counts are delta-encoded (because
@@ -327,6 +337,8 @@ func (d *TrailerDecoder) Decode(v ion.Datum, dst *Trailer) error {
 		case "sparse":
 			seenSparse = true
 			return d.decodeSparse(&dst.Sparse, f.Datum)
+		case "shape":
+			return d.decodeShape(&dst.Shape, f.Datum)
 		case "blocks-delta":
 			// smaller delta-encoded block list format
 			n, err := countList(f.Datum)
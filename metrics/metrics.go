@@ -0,0 +1,406 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package metrics implements a minimal set of
+// counters, gauges and histograms that can be
+// exposed in the OpenMetrics text format.
+//
+// Metrics are registered with a Registry, and
+// the current state of a Registry is captured
+// as a list of Family values with Registry.Gather.
+// Families are plain data, so they can be sent
+// between processes and combined with Merge
+// before being written out with Write.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// Label is a single label name/value pair.
+type Label struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Bucket is a single (cumulative) histogram bucket.
+// The implicit +Inf bucket is not included.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// Sample is the value of a single metric
+// within a family. For histograms, Value is
+// unused and Buckets, Count and Sum are set.
+type Sample struct {
+	Labels  []Label  `json:"labels,omitempty"`
+	Value   float64  `json:"value,omitempty"`
+	Buckets []Bucket `json:"buckets,omitempty"`
+	Count   uint64   `json:"count,omitempty"`
+	Sum     float64  `json:"sum,omitempty"`
+}
+
+// Family is a snapshot of a named
+// group of metrics of the same type.
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help,omitempty"`
+	Type    Type     `json:"type"`
+	Samples []Sample `json:"samples"`
+}
+
+// float is an atomically-updated float64
+type float struct {
+	bits atomic.Uint64
+}
+
+func (f *float) load() float64 { return math.Float64frombits(f.bits.Load()) }
+
+func (f *float) store(v float64) { f.bits.Store(math.Float64bits(v)) }
+
+func (f *float) add(v float64) {
+	for {
+		old := f.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if f.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v float
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() { c.v.add(1) }
+
+// Add adds v to the counter.
+// Add panics if v is negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.v.add(v)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 { return c.v.load() }
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v float
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) { g.v.store(v) }
+
+// Add adds v (which may be negative) to the gauge.
+func (g *Gauge) Add(v float64) { g.v.add(v) }
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() { g.v.add(1) }
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() { g.v.add(-1) }
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 { return g.v.load() }
+
+// DefaultBuckets are the default histogram
+// buckets; they are suited for latencies
+// measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // not cumulative
+	count  atomic.Uint64
+	sum    float
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upper:  buckets,
+		counts: make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	// buckets are inclusive of their upper bound
+	i, _ := slices.BinarySearch(h.upper, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.sum.add(v)
+	h.count.Add(1)
+}
+
+func (h *Histogram) sample(labels []Label) Sample {
+	s := Sample{
+		Labels:  labels,
+		Buckets: make([]Bucket, len(h.upper)),
+	}
+	// load the count first so that the
+	// cumulative bucket counts don't
+	// (usually) exceed the total count
+	s.Count = h.count.Load()
+	s.Sum = h.sum.load()
+	n := uint64(0)
+	for i := range h.upper {
+		n += h.counts[i].Load()
+		s.Buckets[i] = Bucket{UpperBound: h.upper[i], Count: n}
+	}
+	if n > s.Count {
+		s.Count = n
+	}
+	return s
+}
+
+// vec is a set of metrics of the same
+// type that are distinguished by their labels
+type vec[T any] struct {
+	labels []string
+	make   func() *T
+
+	lock    sync.Mutex
+	entries map[string]*entry[T]
+}
+
+type entry[T any] struct {
+	values []string
+	metric *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	e, ok := v.entries[key]
+	if !ok {
+		if v.entries == nil {
+			v.entries = make(map[string]*entry[T])
+		}
+		e = &entry[T]{values: slices.Clone(values), metric: v.make()}
+		v.entries[key] = e
+	}
+	return e.metric
+}
+
+func (v *vec[T]) each(fn func(labels []Label, m *T)) {
+	v.lock.Lock()
+	lst := make([]*entry[T], 0, len(v.entries))
+	for _, e := range v.entries {
+		lst = append(lst, e)
+	}
+	v.lock.Unlock()
+	slices.SortFunc(lst, func(a, b *entry[T]) int {
+		return slices.Compare(a.values, b.values)
+	})
+	for _, e := range lst {
+		labels := make([]Label, len(v.labels))
+		for i := range labels {
+			labels[i] = Label{Name: v.labels[i], Value: e.values[i]}
+		}
+		fn(labels, e.metric)
+	}
+}
+
+// CounterVec is a set of counters
+// distinguished by label values.
+type CounterVec struct {
+	vec[Counter]
+}
+
+// With returns the counter for the given label
+// values, which must be provided in the same order
+// as the labels passed to Registry.CounterVec.
+func (c *CounterVec) With(values ...string) *Counter { return c.with(values) }
+
+// GaugeVec is a set of gauges
+// distinguished by label values.
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+// With returns the gauge for the given label values.
+func (g *GaugeVec) With(values ...string) *Gauge { return g.with(values) }
+
+// HistogramVec is a set of histograms
+// distinguished by label values.
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// With returns the histogram for the given label values.
+func (h *HistogramVec) With(values ...string) *Histogram { return h.with(values) }
+
+// Registry is a collection of metrics.
+// The zero value of Registry is ready to use.
+type Registry struct {
+	lock       sync.Mutex
+	names      map[string]struct{}
+	collectors []func() Family
+}
+
+// Default is the registry used for metrics
+// that are maintained at the package level.
+var Default = new(Registry)
+
+func (r *Registry) register(name string, fn func() Family) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.names[name]; ok {
+		panic(fmt.Sprintf("metrics: %q registered twice", name))
+	}
+	if r.names == nil {
+		r.names = make(map[string]struct{})
+	}
+	r.names[name] = struct{}{}
+	r.collectors = append(r.collectors, fn)
+}
+
+// Counter registers and returns a new Counter.
+// The name should not include the "_total" suffix;
+// it is added when the counter is written.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := new(Counter)
+	r.CounterFunc(name, help, c.Value)
+	return c
+}
+
+// CounterFunc registers a counter whose
+// value is determined by calling fn.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, func() Family {
+		return Family{Name: name, Help: help, Type: TypeCounter, Samples: []Sample{{Value: fn()}}}
+	})
+}
+
+// CounterVec registers and returns a new CounterVec
+// with the given label names.
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[Counter]{labels: labels, make: func() *Counter { return new(Counter) }}}
+	r.register(name, func() Family {
+		f := Family{Name: name, Help: help, Type: TypeCounter}
+		c.each(func(labels []Label, c *Counter) {
+			f.Samples = append(f.Samples, Sample{Labels: labels, Value: c.Value()})
+		})
+		return f
+	})
+	return c
+}
+
+// Gauge registers and returns a new Gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := new(Gauge)
+	r.GaugeFunc(name, help, g.Value)
+	return g
+}
+
+// GaugeFunc registers a gauge whose
+// value is determined by calling fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, func() Family {
+		return Family{Name: name, Help: help, Type: TypeGauge, Samples: []Sample{{Value: fn()}}}
+	})
+}
+
+// GaugeVec registers and returns a new GaugeVec
+// with the given label names.
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec[Gauge]{labels: labels, make: func() *Gauge { return new(Gauge) }}}
+	r.register(name, func() Family {
+		f := Family{Name: name, Help: help, Type: TypeGauge}
+		g.each(func(labels []Label, g *Gauge) {
+			f.Samples = append(f.Samples, Sample{Labels: labels, Value: g.Value()})
+		})
+		return f
+	})
+	return g
+}
+
+// Histogram registers and returns a new Histogram.
+// If buckets is nil, DefaultBuckets are used.
+// Otherwise buckets must be sorted in increasing order.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(checkBuckets(buckets))
+	r.register(name, func() Family {
+		return Family{Name: name, Help: help, Type: TypeHistogram, Samples: []Sample{h.sample(nil)}}
+	})
+	return h
+}
+
+// HistogramVec registers and returns a new
+// HistogramVec with the given label names.
+// See also Registry.Histogram.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = checkBuckets(buckets)
+	h := &HistogramVec{vec[Histogram]{labels: labels, make: func() *Histogram { return newHistogram(buckets) }}}
+	r.register(name, func() Family {
+		f := Family{Name: name, Help: help, Type: TypeHistogram}
+		h.each(func(labels []Label, h *Histogram) {
+			f.Samples = append(f.Samples, h.sample(labels))
+		})
+		return f
+	})
+	return h
+}
+
+func checkBuckets(buckets []float64) []float64 {
+	if buckets == nil {
+		return DefaultBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		panic("metrics: histogram buckets are not sorted")
+	}
+	return buckets
+}
+
+// Gather returns the current state of all of
+// the metrics in r, sorted by name.
+func (r *Registry) Gather() []Family {
+	r.lock.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.lock.Unlock()
+	fams := make([]Family, len(collectors))
+	for i := range collectors {
+		fams[i] = collectors[i]()
+	}
+	slices.SortFunc(fams, func(a, b Family) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return fams
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	var r Registry
+	queries := r.CounterVec("queries", "Number of queries.", "status")
+	queries.With("200").Add(3)
+	queries.With("400").Inc()
+	live := r.Gauge("live", "Live \"processes\".")
+	live.Set(2)
+	live.Dec()
+	r.GaugeFunc("size", "", func() float64 { return 1.5 })
+	h := r.Histogram("latency", "Latency.", []float64{0.1, 1})
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var out strings.Builder
+	if err := Write(&out, r.Gather()); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE latency histogram
+# HELP latency Latency.
+latency_bucket{le="0.1"} 1
+latency_bucket{le="1"} 2
+latency_bucket{le="+Inf"} 3
+latency_count 3
+latency_sum 5.6
+# TYPE live gauge
+# HELP live Live \"processes\".
+live 1
+# TYPE queries counter
+# HELP queries Number of queries.
+queries_total{status="200"} 3
+queries_total{status="400"} 1
+# TYPE size gauge
+size 1.5
+# EOF
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var parent, child Registry
+	parent.Counter("errors", "Errors.").Inc()
+	child.CounterVec("errors", "Errors.", "op").With("dial").Add(2)
+	child.Gauge("hits", "Hits.").Set(4)
+
+	// simulate sending the child metrics
+	// to the parent process
+	buf, err := json.Marshal(child.Gather())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fams []Family
+	if err := json.Unmarshal(buf, &fams); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	Write(&out, Merge(parent.Gather(), WithLabel(fams, "tenant", "a\nb")))
+	want := `# TYPE errors counter
+# HELP errors Errors.
+errors_total 1
+errors_total{tenant="a\nb",op="dial"} 2
+# TYPE hits gauge
+# HELP hits Hits.
+hits{tenant="a\nb"} 4
+# EOF
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	var r Registry
+	r.Counter("x", "")
+	r.Gauge("x", "")
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type
+// of the output produced by Write.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// WithLabel returns a copy of fams where
+// every sample has an additional label.
+func WithLabel(fams []Family, name, value string) []Family {
+	out := make([]Family, len(fams))
+	for i := range fams {
+		out[i] = fams[i]
+		out[i].Samples = make([]Sample, len(fams[i].Samples))
+		for j, s := range fams[i].Samples {
+			s.Labels = append([]Label{{Name: name, Value: value}}, s.Labels...)
+			out[i].Samples[j] = s
+		}
+	}
+	return out
+}
+
+// Merge combines lists of families into a single
+// list sorted by name. Families with the same name
+// have their samples concatenated; the type and help
+// text of the first family with that name are used.
+func Merge(lists ...[]Family) []Family {
+	var out []Family
+	index := make(map[string]int)
+	for _, lst := range lists {
+		for i := range lst {
+			j, ok := index[lst[i].Name]
+			if !ok {
+				index[lst[i].Name] = len(out)
+				f := lst[i]
+				f.Samples = slices.Clip(f.Samples)
+				out = append(out, f)
+				continue
+			}
+			if out[j].Type != lst[i].Type {
+				continue
+			}
+			out[j].Samples = append(out[j].Samples, lst[i].Samples...)
+		}
+	}
+	slices.SortFunc(out, func(a, b Family) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return out
+}
+
+// Write writes fams to w in the
+// OpenMetrics text exposition format.
+func Write(w io.Writer, fams []Family) error {
+	bw := bufio.NewWriter(w)
+	for i := range fams {
+		writeFamily(bw, &fams[i])
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+func writeFamily(w *bufio.Writer, f *Family) {
+	w.WriteString("# TYPE ")
+	w.WriteString(f.Name)
+	w.WriteByte(' ')
+	w.WriteString(string(f.Type))
+	w.WriteByte('\n')
+	if f.Help != "" {
+		w.WriteString("# HELP ")
+		w.WriteString(f.Name)
+		w.WriteByte(' ')
+		w.WriteString(escape(f.Help))
+		w.WriteByte('\n')
+	}
+	for i := range f.Samples {
+		s := &f.Samples[i]
+		switch f.Type {
+		case TypeCounter:
+			writeSample(w, f.Name+"_total", s.Labels, nil, s.Value)
+		case TypeHistogram:
+			name := f.Name + "_bucket"
+			for _, b := range s.Buckets {
+				writeSample(w, name, s.Labels, &Label{Name: "le", Value: formatFloat(b.UpperBound)}, float64(b.Count))
+			}
+			writeSample(w, name, s.Labels, &Label{Name: "le", Value: "+Inf"}, float64(s.Count))
+			writeSample(w, f.Name+"_count", s.Labels, nil, float64(s.Count))
+			writeSample(w, f.Name+"_sum", s.Labels, nil, s.Sum)
+		default:
+			writeSample(w, f.Name, s.Labels, nil, s.Value)
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels []Label, extra *Label, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extra != nil {
+		w.WriteByte('{')
+		for i := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, &labels[i])
+		}
+		if extra != nil {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			writeLabel(w, extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func writeLabel(w *bufio.Writer, l *Label) {
+	w.WriteString(l.Name)
+	w.WriteString(`="`)
+	w.WriteString(escape(l.Value))
+	w.WriteByte('"')
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler returns an http.Handler that serves
+// the merged result of calling each of the gather
+// functions in the OpenMetrics text format.
+func Handler(gather ...func() []Family) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lists := make([][]Family, len(gather))
+		for i := range gather {
+			lists[i] = gather[i]()
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if r.Method == http.MethodHead {
+			return
+		}
+		Write(w, Merge(lists...))
+	})
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/vm"
+)
+
+// Instrument is an Op that counts the
+// rows produced by its input Op and measures
+// the time it takes to produce them, and adds
+// the result to ExecStats.Ops.
+//
+// Instrument ops are inserted into the plan
+// of a query by EXPLAIN ANALYZE.
+type Instrument struct {
+	Nonterminal
+	// ID identifies the Instrument
+	// in ExecStats.Ops.
+	ID int
+
+	// stats are the statistics of the
+	// input op, one per peer; they are
+	// populated by Explain after executing
+	// the query (see setStats)
+	stats []OpStats
+}
+
+func (i *Instrument) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	rc := vm.NewRowCounter(dst)
+	start := time.Now()
+	err := i.From.exec(rc, src, ep)
+	ep.Stats.addOps([]OpStats{{
+		ID:    i.ID,
+		Peer:  -1,
+		Rows:  rc.Rows(),
+		Bytes: rc.Bytes(),
+		Wall:  time.Since(start),
+		Busy:  rc.Busy(),
+		Wait:  rc.Wait(),
+	}})
+	return err
+}
+
+func (i *Instrument) encode(dst *ion.Buffer, st *ion.Symtab, _ *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("instrument", dst, st)
+	dst.BeginField(st.Intern("id"))
+	dst.WriteInt(int64(i.ID))
+	dst.EndStruct()
+	return nil
+}
+
+func (i *Instrument) SetField(f ion.Field) error {
+	switch f.Label {
+	case "id":
+		n, err := f.Int()
+		if err != nil {
+			return err
+		}
+		i.ID = int(n)
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+// setStats sets i.stats from the list of all
+// the OpStats collected during execution,
+// merging the stats produced by the same peer
+func (i *Instrument) setStats(all []OpStats) {
+	i.stats = i.stats[:0]
+outer:
+	for j := range all {
+		s := &all[j]
+		if s.ID != i.ID {
+			continue
+		}
+		for k := range i.stats {
+			if i.stats[k].Peer == s.Peer {
+				i.stats[k].Rows += s.Rows
+				i.stats[k].Bytes += s.Bytes
+				i.stats[k].Wall = max(i.stats[k].Wall, s.Wall)
+				i.stats[k].Busy += s.Busy
+				i.stats[k].Wait += s.Wait
+				continue outer
+			}
+		}
+		i.stats = append(i.stats, *s)
+	}
+	slices.SortFunc(i.stats, func(a, b OpStats) int {
+		return a.Peer - b.Peer
+	})
+}
+
+// total returns the sum of the stats of all
+// the peers (and the longest time elapsed)
+func (i *Instrument) total() OpStats {
+	ret := OpStats{ID: i.ID, Peer: -1}
+	for j := range i.stats {
+		ret.Rows += i.stats[j].Rows
+		ret.Bytes += i.stats[j].Bytes
+		ret.Wall = max(ret.Wall, i.stats[j].Wall)
+		ret.Busy += i.stats[j].Busy
+		ret.Wait += i.stats[j].Wait
+	}
+	return ret
+}
+
+// prev returns the Instrument of the op
+// that produces the input of the op instrumented
+// by i, or nil if the op has no input
+func (i *Instrument) prev() *Instrument {
+	for op := i.From.input(); op != nil; op = op.input() {
+		if p, ok := op.(*Instrument); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func (i *Instrument) peerStats(peer int) OpStats {
+	for j := range i.stats {
+		if i.stats[j].Peer == peer {
+			return i.stats[j]
+		}
+	}
+	return OpStats{ID: i.ID, Peer: peer}
+}
+
+// self returns the time spent by the op
+// that produced s on its own: the time the
+// op producing its input (in) spent waiting
+// for it, or, if the op has no input, the
+// time spent writing its output, minus the
+// time spent waiting for the next op
+func self(s *OpStats, in *OpStats) time.Duration {
+	t := s.Busy
+	if in != nil {
+		t = in.Wait
+	}
+	return max(t-s.Wait, 0)
+}
+
+func (i *Instrument) format(dst *strings.Builder, s *OpStats, in *OpStats) {
+	if in != nil {
+		fmt.Fprintf(dst, "rows in: %d, ", in.Rows)
+	}
+	fmt.Fprintf(dst, "rows out: %d, bytes out: %d, time: %s, elapsed: %s",
+		s.Rows, s.Bytes, self(s, in).Round(time.Microsecond), s.Wall.Round(time.Microsecond))
+}
+
+// lines returns the textual form of the
+// statistics: the total followed by the
+// statistics of each peer (if any)
+func (i *Instrument) lines() []string {
+	prev := i.prev()
+	var sb strings.Builder
+	sb.WriteString("-> ")
+	tot := i.total()
+	if prev != nil {
+		in := prev.total()
+		i.format(&sb, &tot, &in)
+	} else {
+		i.format(&sb, &tot, nil)
+	}
+	lst := []string{sb.String()}
+	for j := range i.stats {
+		s := &i.stats[j]
+		if s.Peer < 0 {
+			continue
+		}
+		sb.Reset()
+		fmt.Fprintf(&sb, "peer %d: ", s.Peer)
+		if prev != nil {
+			in := prev.peerStats(s.Peer)
+			i.format(&sb, s, &in)
+		} else {
+			i.format(&sb, s, nil)
+		}
+		lst = append(lst, sb.String())
+	}
+	return lst
+}
+
+// String implements fmt.Stringer
+func (i *Instrument) String() string {
+	return strings.Join(i.lines(), "\n")
+}
+
+func (i *Instrument) describe(dst *strings.Builder, indent int) {
+	lst := i.lines()
+	tabline(dst, indent, lst[0])
+	for _, line := range lst[1:] {
+		tabline(dst, indent+1, line)
+	}
+}
+
+// instrumenter inserts Instrument ops
+// into a query plan
+type instrumenter struct {
+	id int
+}
+
+func (in *instrumenter) node(n *Node) {
+	n.Op = in.op(n.Op)
+}
+
+// op instruments the op and all of its inputs
+func (in *instrumenter) op(op Op) Op {
+	if from := op.input(); from != nil {
+		op.setinput(in.op(from))
+	}
+	switch o := op.(type) {
+	case *Substitute:
+		for i := range o.Inner {
+			in.node(o.Inner[i])
+		}
+		// the output of Substitute is
+		// the output of its input
+		return op
+	case *HashJoin:
+		in.node(o.Build)
+	}
+	ret := &Instrument{Nonterminal: Nonterminal{From: op}, ID: in.id}
+	in.id++
+	return ret
+}
+
+// instrument inserts an Instrument op
+// after every op of the tree (see EXPLAIN ANALYZE)
+func instrument(t *Tree) {
+	in := &instrumenter{}
+	in.node(&t.Root)
+}
+
+// walkInstruments calls fn for
+// each Instrument op within n
+func walkInstruments(n *Node, fn func(*Instrument)) {
+	for op := n.Op; op != nil; op = op.input() {
+		switch o := op.(type) {
+		case *Instrument:
+			fn(o)
+		case *Substitute:
+			for i := range o.Inner {
+				walkInstruments(o.Inner[i], fn)
+			}
+		case *HashJoin:
+			walkInstruments(o.Build, fn)
+		}
+	}
+}
+
+type discardSink struct{}
+
+func (discardSink) Open() (io.WriteCloser, error) { return discardWriter{}, nil }
+func (discardSink) Close() error                  { return nil }
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) Close() error                { return nil }
+
+// analyze executes e.Tree (which has been
+// instrumented by instrument), discarding
+// its output, and populates the statistics
+// of each Instrument op
+func (e *Explain) analyze(ep *ExecParams) error {
+	// the tree of the query may have been decoded
+	// separately from the tree being executed
+	t := *e.Tree
+	t.ID = ep.Plan.ID
+	t.Data = ep.Plan.Data
+	subep := ep.clone()
+	subep.Plan = &t
+	start := time.Now()
+	err := t.exec(discardSink{}, subep)
+	e.elapsed = time.Since(start)
+	e.scanned = subep.Stats.BytesScanned
+	ep.Stats.atomicAdd(&subep.Stats)
+	if err != nil {
+		return err
+	}
+	walkInstruments(&e.Tree.Root, func(i *Instrument) {
+		i.setStats(subep.Stats.Ops)
+	})
+	return nil
+}
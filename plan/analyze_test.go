@@ -0,0 +1,154 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/expr/partiql"
+	"github.com/SnellerInc/sneller/ion"
+)
+
+func TestExplainAnalyze(t *testing.T) {
+	env := &testenv{t: t}
+	se := &splitEnv{
+		Env: env,
+		geom: &Geometry{
+			Peers: []Transport{&LocalTransport{}, &LocalTransport{}},
+		},
+	}
+	run := func(t *testing.T, text, field string) string {
+		q, err := partiql.Parse([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := NewSplit(q, se)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the statistics must survive the
+		// serialization of the plan
+		var ib ion.Buffer
+		var st ion.Symtab
+		err = tree.Encode(&ib, &st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err = Decode(&st, ib.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		ep := &ExecParams{
+			Plan:   tree,
+			Output: &out,
+			Runner: env,
+		}
+		err = Exec(ep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ep.Stats.BytesScanned == 0 {
+			t.Error("no bytes scanned")
+		}
+		st.Reset()
+		d, _, err := ion.ReadDatum(&st, out.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := d.Struct()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, ok := s.FieldByName(field)
+		if !ok {
+			t.Fatalf("no field %q", field)
+		}
+		if field == "plan-lines" {
+			var lines []string
+			err = f.UnpackList(func(d ion.Datum) error {
+				line, err := d.String()
+				lines = append(lines, line)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return strings.Join(lines, "\n")
+		}
+		str, err := f.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}
+	match := func(t *testing.T, text string, patterns ...string) {
+		t.Helper()
+		for _, p := range patterns {
+			if !regexp.MustCompile(p).MatchString(text) {
+				t.Errorf("output did not match %q", p)
+			}
+		}
+	}
+
+	const query = `SELECT COUNT(*) FROM parking WHERE Make = 'HOND'`
+	t.Run("text", func(t *testing.T) {
+		text := run(t, "EXPLAIN ANALYZE "+query, "plan")
+		t.Logf("plan:\n%s", text)
+		match(t, text,
+			// the leaf scans every row and
+			// the filter passes 122 of them
+			`(?m)^-> rows out: 1023, bytes out: \d+, time: \S+, elapsed: \S+$`,
+			`(?m)^\tpeer \d: rows out: 1023, bytes out: \d+, time: \S+, elapsed: \S+$`,
+			`(?m)^-> rows in: 1023, rows out: 122, bytes out: \d+, time: \S+, elapsed: \S+$`,
+			`(?m)^\tpeer \d: rows in: 122, rows out: 1, `,
+			`(?m)^-> rows in: 1, rows out: 1, `,
+			`(?m)^EXECUTION TIME: .+$`,
+			`(?m)^BYTES SCANNED: [1-9]\d*$`,
+		)
+		// the time of the filter must not
+		// include the time spent scanning
+		m := regexp.MustCompile(`(?m)^-> rows in: 1023, .*time: (\S+), elapsed: (\S+)$`).FindStringSubmatch(text)
+		if m == nil {
+			t.Fatal("no filter statistics")
+		}
+		self, err := time.ParseDuration(m[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		elapsed, err := time.ParseDuration(m[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if self >= elapsed {
+			t.Errorf("filter time %s not less than elapsed time %s", self, elapsed)
+		}
+	})
+	t.Run("list", func(t *testing.T) {
+		text := run(t, "EXPLAIN ANALYZE AS list "+query, "plan-lines")
+		match(t, text, `(?m)^-> rows out: 1023, `, `(?m)^BYTES SCANNED: `)
+	})
+	t.Run("graphviz", func(t *testing.T) {
+		text := run(t, "EXPLAIN ANALYZE AS graphviz "+query, "graphviz")
+		match(t, text, `^digraph plan`, `\\n-> rows out: 1023, `)
+		if strings.Contains(text, "INSTRUMENT") {
+			t.Error("Instrument op included in graph")
+		}
+	})
+}
@@ -160,6 +160,18 @@ func empty(name string) (Op, bool) {
 		op = &Explain{}
 	case "substitute":
 		op = &Substitute{}
+	case "exchangemap":
+		op = &ExchangeMap{}
+	case "exchangepart":
+		op = &ExchangePart{}
+	case "exchange":
+		op = &Exchange{}
+	case "exchangescan":
+		op = &ExchangeScan{}
+	case "hashjoin":
+		op = &HashJoin{}
+	case "instrument":
+		op = &Instrument{}
 	default:
 		return nil, false
 	}
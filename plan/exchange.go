@@ -0,0 +1,787 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package plan
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/plan/pir"
+	"github.com/SnellerInc/sneller/vm"
+)
+
+// Distributed hash joins are executed as follows:
+//
+// The query coordinator executes an ExchangeMap,
+// which sends the rest of the query to each peer
+// wrapped in an ExchangePart op that assigns the peer
+// the partition of the joined rows that it owns.
+//
+// Each peer executes a HashJoin that reads both
+// sides of the join through an Exchange. An Exchange
+// asks every peer (over the peer's Transport) for
+// the rows in the partition owned by the requesting
+// peer out of that peer's share of the input.
+// Each peer scans its share of the input only once,
+// after every peer has asked for its partition,
+// and streams each partition of the rows to the
+// peer that owns it (see ExchangeScan).
+// Since rows with equal join keys always hash to
+// the same partition, each peer can join its partition
+// of the rows independently of every other peer,
+// and no single peer needs to hold the entire
+// build side of the join.
+//
+// Since the Geometry of an Exchange is sent to
+// every peer, it must consist of Transports that
+// reach the same peer regardless of which peer
+// they are decoded on (see SharedTransport).
+
+// SharedTransport is implemented by Transports
+// that only reach their peer when they are executed
+// on the peer that constructed them, like the
+// LocalTransport that a peer uses for itself.
+// Shared returns a Transport that reaches the
+// same peer when it is executed on any peer.
+type SharedTransport interface {
+	Transport
+	Shared() Transport
+}
+
+// sharedGeometry returns g with each
+// SharedTransport replaced by its shared form
+func sharedGeometry(g *Geometry) *Geometry {
+	peers := make([]Transport, len(g.Peers))
+	for i, p := range g.Peers {
+		if s, ok := p.(SharedTransport); ok {
+			p = s.Shared()
+		}
+		peers[i] = p
+	}
+	return &Geometry{Peers: peers}
+}
+
+// ExchangeMap is an op that executes its input op
+// once on each peer in Geometry, assigning each peer
+// the partition of hash-partitioned rows that it owns,
+// and yields the union of the results.
+type ExchangeMap struct {
+	Nonterminal
+
+	// Geometry is the list of peers that
+	// own the partitions of the exchanged rows
+	Geometry *Geometry
+}
+
+func (e *ExchangeMap) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	if e.Geometry == nil {
+		return fmt.Errorf("plan.ExchangeMap: Geometry is nil")
+	}
+	input := -1
+	if src != nil {
+		input = slices.Index(ep.Plan.Inputs, src)
+		if input < 0 {
+			return fmt.Errorf("plan.ExchangeMap: input is not part of the plan")
+		}
+	}
+	// the ExchangeScans of this execution
+	// are identified by a random token
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return err
+	}
+	return execPeers(dst, e.Geometry, ep, func(i int) *Tree {
+		// every peer receives all of the inputs,
+		// since each Exchange needs to split
+		// the entire table across the peers
+		return &Tree{
+			ID:     ep.Plan.ID,
+			Inputs: ep.Plan.Inputs,
+			Data:   ep.Plan.Data,
+			Root: Node{
+				Op: &ExchangePart{
+					Nonterminal: Nonterminal{From: e.From},
+					Part:        i,
+					Token:       hex.EncodeToString(token[:]),
+				},
+				Input: input,
+			},
+		}
+	})
+}
+
+func (e *ExchangeMap) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("exchangemap", dst, st)
+	if e.Geometry != nil {
+		dst.BeginField(st.Intern("geometry"))
+		if err := e.Geometry.encode(dst, st); err != nil {
+			return err
+		}
+	}
+	dst.EndStruct()
+	return nil
+}
+
+func (e *ExchangeMap) SetField(f ion.Field) error {
+	switch f.Label {
+	case "geometry":
+		g, err := decodeGeometry(f.Datum)
+		if err != nil {
+			return err
+		}
+		e.Geometry = g
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+func (e *ExchangeMap) String() string { return "EXCHANGE MAP" }
+
+// ExchangePart is an op that assigns the partition
+// Part of the rows read through any Exchange ops
+// in its input to the current peer.
+type ExchangePart struct {
+	Nonterminal
+	Part int
+	// Token identifies the execution
+	// of the ExchangeMap that produced
+	// the ExchangePart.
+	Token string
+}
+
+func (e *ExchangePart) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	subep := ep.clone()
+	subep.part = e.Part
+	subep.hasPart = true
+	subep.exchange = e.Token
+	err := e.From.exec(dst, src, subep)
+	ep.Stats.atomicAdd(&subep.Stats)
+	return err
+}
+
+func (e *ExchangePart) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("exchangepart", dst, st)
+	dst.BeginField(st.Intern("part"))
+	dst.WriteInt(int64(e.Part))
+	dst.BeginField(st.Intern("token"))
+	dst.WriteString(e.Token)
+	dst.EndStruct()
+	return nil
+}
+
+func (e *ExchangePart) SetField(f ion.Field) error {
+	switch f.Label {
+	case "part":
+		i, err := f.Int()
+		if err != nil {
+			return err
+		}
+		e.Part = int(i)
+	case "token":
+		s, err := f.String()
+		if err != nil {
+			return err
+		}
+		e.Token = s
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+func (e *ExchangePart) String() string {
+	return fmt.Sprintf("EXCHANGE PART %d", e.Part)
+}
+
+// Exchange is an op that executes its input op
+// on each peer in Geometry over that peer's share
+// of the input and yields the rows for which Key
+// belongs to the partition owned by the current peer.
+//
+// An Exchange must be executed beneath an ExchangePart.
+type Exchange struct {
+	Nonterminal
+	// ID distinguishes the Exchanges
+	// beneath the same ExchangeMap.
+	ID int
+	// Key is the path of the join key
+	// used to partition the rows.
+	Key []string
+	// Geometry is the list of peers
+	// that the input is split across.
+	Geometry *Geometry
+}
+
+func (e *Exchange) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	if e.Geometry == nil {
+		return fmt.Errorf("plan.Exchange: Geometry is nil")
+	}
+	if !ep.hasPart {
+		return fmt.Errorf("plan.Exchange: no partition assigned")
+	}
+	parts := len(e.Geometry.Peers)
+	if ep.part < 0 || ep.part >= parts {
+		return fmt.Errorf("plan.Exchange: partition %d out of range for %d peers", ep.part, parts)
+	}
+	// every peer computes the same split, and since
+	// an ExchangeScan only starts once all of the
+	// partitions have been requested, every peer
+	// must request its partition of every share
+	in := src.HashSplit(parts)
+	return execPeers(dst, e.Geometry, ep, func(i int) *Tree {
+		if in[i] == nil {
+			return nil
+		}
+		return &Tree{
+			ID:     ep.Plan.ID,
+			Inputs: in[i : i+1],
+			Data:   ep.Plan.Data,
+			Root: Node{
+				Op: &ExchangeScan{
+					Nonterminal: Nonterminal{From: e.From},
+					Scan:        fmt.Sprintf("%s-%d-%d", ep.exchange, e.ID, i),
+					Key:         e.Key,
+					Part:        ep.part,
+					Parts:       parts,
+				},
+				Input: 0,
+			},
+		}
+	})
+}
+
+func encodePath(dst *ion.Buffer, path []string) {
+	dst.BeginList(-1)
+	for i := range path {
+		dst.WriteString(path[i])
+	}
+	dst.EndList()
+}
+
+func decodePath(f *ion.Field) ([]string, error) {
+	var out []string
+	err := f.UnpackList(func(d ion.Datum) error {
+		str, err := d.String()
+		if err != nil {
+			return err
+		}
+		out = append(out, str)
+		return nil
+	})
+	return out, err
+}
+
+func pathString(path []string) string {
+	if len(path) == 0 {
+		return "<empty>"
+	}
+	return expr.ToString(expr.MakePath(path))
+}
+
+func (e *Exchange) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("exchange", dst, st)
+	dst.BeginField(st.Intern("id"))
+	dst.WriteInt(int64(e.ID))
+	dst.BeginField(st.Intern("key"))
+	encodePath(dst, e.Key)
+	if e.Geometry != nil {
+		dst.BeginField(st.Intern("geometry"))
+		if err := e.Geometry.encode(dst, st); err != nil {
+			return err
+		}
+	}
+	dst.EndStruct()
+	return nil
+}
+
+func (e *Exchange) SetField(f ion.Field) error {
+	switch f.Label {
+	case "id":
+		i, err := f.Int()
+		if err != nil {
+			return err
+		}
+		e.ID = int(i)
+	case "key":
+		key, err := decodePath(&f)
+		if err != nil {
+			return err
+		}
+		e.Key = key
+	case "geometry":
+		g, err := decodeGeometry(f.Datum)
+		if err != nil {
+			return err
+		}
+		e.Geometry = g
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+func (e *Exchange) String() string {
+	return "EXCHANGE ON " + pathString(e.Key)
+}
+
+// ExchangeScan is an op that scans its input once
+// and splits the rows by the hash partition of Key
+// among the queries that request the partitions.
+//
+// Each of the Parts peers sharing an Exchange
+// executes an ExchangeScan with the same Scan and
+// a different Part on the peer that owns the input.
+// The first Parts-1 of them wait for the last one,
+// which scans the input and writes the rows in each
+// partition to the output of the ExchangeScan that
+// requested that partition.
+type ExchangeScan struct {
+	Nonterminal
+	// Scan identifies the scan; it is
+	// unique to the input of one Exchange
+	// in one execution of an ExchangeMap.
+	Scan        string
+	Key         []string
+	Part, Parts int
+}
+
+var (
+	scanLock sync.Mutex
+	scans    map[string]*exchangeScan
+)
+
+// exchangeScan is a shared scan
+// waiting for its partitions to be requested
+type exchangeScan struct {
+	dst   []vm.QuerySink
+	count int
+	done  chan struct{}
+	err   error // set before done is closed
+}
+
+// joinScan registers dst as the output for partition
+// part of the scan id and returns the scan; it also
+// returns true if the caller registered the last partition
+// and is responsible for executing the scan
+func joinScan(id string, part, parts int, dst vm.QuerySink) (*exchangeScan, bool, error) {
+	scanLock.Lock()
+	defer scanLock.Unlock()
+	sc := scans[id]
+	if sc == nil {
+		sc = &exchangeScan{
+			dst:  make([]vm.QuerySink, parts),
+			done: make(chan struct{}),
+		}
+		if scans == nil {
+			scans = make(map[string]*exchangeScan)
+		}
+		scans[id] = sc
+	}
+	if len(sc.dst) != parts || part < 0 || part >= parts {
+		return nil, false, fmt.Errorf("plan.ExchangeScan: partition %d of %d doesn't match scan of %d partitions", part, parts, len(sc.dst))
+	}
+	if sc.dst[part] != nil {
+		return nil, false, fmt.Errorf("plan.ExchangeScan: partition %d requested twice", part)
+	}
+	sc.dst[part] = dst
+	sc.count++
+	if sc.count < parts {
+		return sc, false, nil
+	}
+	delete(scans, id)
+	return sc, true, nil
+}
+
+// wait waits for the scan id to complete or
+// for ctx to be canceled; canceling a scan that
+// hasn't started yet fails every partition, since
+// the scan waits for all of them to be requested
+func (sc *exchangeScan) wait(ctx context.Context, id string) error {
+	select {
+	case <-sc.done:
+		return sc.err
+	case <-ctx.Done():
+	}
+	scanLock.Lock()
+	if scans[id] == sc {
+		// the scan hasn't started yet,
+		// so it never will
+		delete(scans, id)
+		sc.err = fmt.Errorf("plan.ExchangeScan: waiting for %d of %d partitions: %w", len(sc.dst)-sc.count, len(sc.dst), ctx.Err())
+		close(sc.done)
+	}
+	scanLock.Unlock()
+	// the scan is writing to the partition
+	// of the caller, so wait for it to stop
+	<-sc.done
+	return sc.err
+}
+
+func (e *ExchangeScan) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	sc, last, err := joinScan(e.Scan, e.Part, e.Parts, dst)
+	if err != nil {
+		return err
+	}
+	if !last {
+		return sc.wait(ep.Context, e.Scan)
+	}
+	hs, err := vm.NewHashSplit(e.Key, sc.dst)
+	if err == nil {
+		err = e.From.exec(hs, src, ep)
+	}
+	sc.err = err
+	close(sc.done)
+	return err
+}
+
+func (e *ExchangeScan) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("exchangescan", dst, st)
+	dst.BeginField(st.Intern("scan"))
+	dst.WriteString(e.Scan)
+	dst.BeginField(st.Intern("key"))
+	encodePath(dst, e.Key)
+	dst.BeginField(st.Intern("part"))
+	dst.WriteInt(int64(e.Part))
+	dst.BeginField(st.Intern("parts"))
+	dst.WriteInt(int64(e.Parts))
+	dst.EndStruct()
+	return nil
+}
+
+func (e *ExchangeScan) SetField(f ion.Field) error {
+	switch f.Label {
+	case "scan":
+		s, err := f.String()
+		if err != nil {
+			return err
+		}
+		e.Scan = s
+	case "key":
+		key, err := decodePath(&f)
+		if err != nil {
+			return err
+		}
+		e.Key = key
+	case "part", "parts":
+		i, err := f.Int()
+		if err != nil {
+			return err
+		}
+		if f.Label == "part" {
+			e.Part = int(i)
+		} else {
+			e.Parts = int(i)
+		}
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+func (e *ExchangeScan) String() string {
+	return fmt.Sprintf("EXCHANGE SCAN %s %d OF %d", pathString(e.Key), e.Part, e.Parts)
+}
+
+// HashJoin is an op that joins the rows of its input
+// against the rows produced by Build, which must be
+// structures with a join key in the field $__key and
+// the joined value in the field $__val.
+// Each input row is yielded once for each build row
+// with a key equal to Key, with the joined value bound
+// to Result.
+type HashJoin struct {
+	Nonterminal
+	// Build produces the build side of the join.
+	Build *Node
+	// Key is the path of the join key in the input rows.
+	Key []string
+	// Result is the binding for the joined values.
+	Result string
+}
+
+func (h *HashJoin) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	table := vm.NewJoinTable("$__key", "$__val")
+	subep := ep.clone()
+	err := h.Build.exec(table, subep)
+	ep.Stats.atomicAdd(&subep.Stats)
+	if err != nil {
+		return err
+	}
+	hj, err := vm.NewHashJoin(table, h.Key, h.Result, dst)
+	if err != nil {
+		return err
+	}
+	return h.From.exec(hj, src, ep)
+}
+
+func (h *HashJoin) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error {
+	dst.BeginStruct(-1)
+	settype("hashjoin", dst, st)
+	dst.BeginField(st.Intern("build"))
+	if err := h.Build.encode(dst, st, ep); err != nil {
+		return err
+	}
+	dst.BeginField(st.Intern("key"))
+	encodePath(dst, h.Key)
+	dst.BeginField(st.Intern("result"))
+	dst.WriteString(h.Result)
+	dst.EndStruct()
+	return nil
+}
+
+func (h *HashJoin) SetField(f ion.Field) error {
+	switch f.Label {
+	case "build":
+		h.Build = &Node{}
+		return h.Build.decode(f.Datum)
+	case "key":
+		key, err := decodePath(&f)
+		if err != nil {
+			return err
+		}
+		h.Key = key
+	case "result":
+		s, err := f.String()
+		if err != nil {
+			return err
+		}
+		h.Result = s
+	default:
+		return errUnexpectedField
+	}
+	return nil
+}
+
+// String implements fmt.Stringer
+func (h *HashJoin) String() string {
+	var dst strings.Builder
+	tabfprintf(&dst, 0, "HASH JOIN ON %s AS %s BUILD (\n", pathString(h.Key), h.Result)
+	h.Build.describe(1, &dst)
+	dst.WriteString(")")
+	return dst.String()
+}
+
+// exchangeJoins rewrites the first join in a split
+// query plan to be executed as a distributed hash join
+// when the build side of the join is too large to be
+// substituted into the query as a HASH_REPLACEMENT
+// (which is limited to pir.LargeSize rows).
+//
+// The rewrite only applies to joins on a simple path
+// expression for which the table statistics of the
+// build side are known.
+func exchangeJoins(t *Tree, env Env) {
+	indexer, ok := env.(Indexer)
+	if !ok {
+		return
+	}
+	// find the Substitute that produces the
+	// replacement for the join
+	var sparent Op
+	var s *Substitute
+	for op := t.Root.Op; op != nil; op = op.input() {
+		if sub, ok := op.(*Substitute); ok {
+			s = sub
+			break
+		}
+		sparent = op
+	}
+	if s == nil {
+		return
+	}
+	// ... and the UnionMap that contains the join
+	var uparent Op = s
+	var u *UnionMap
+	for op := s.From; op != nil; op = op.input() {
+		if um, ok := op.(*UnionMap); ok {
+			u = um
+			break
+		}
+		uparent = op
+	}
+	if u == nil || u.Geometry == nil || len(u.Geometry.Peers) < 2 {
+		return
+	}
+	// find the first join (in execution order)
+	// with a large build side; since the ops are
+	// linked in reverse execution order, this is
+	// the last matching op in the list
+	var join *Unnest
+	var id int
+	var key []string
+	for op := u.From; op != nil; op = op.input() {
+		if un, ok := op.(*Unnest); ok {
+			if i, k, ok := largeJoin(un, s, indexer); ok {
+				join, id, key = un, i, k
+			}
+		}
+	}
+	if join == nil {
+		return
+	}
+	// the replacement must not be referenced
+	// anywhere other than the join itself
+	if replacementRefs(t, id) != 1 {
+		return
+	}
+
+	// find the op that consumes the join output
+	var above Op = u
+	for op := u.From; op != join; op = op.input() {
+		above = op
+	}
+	build := s.Inner[id]
+	bu := build.Op.(*UnionMap)
+	hj := &HashJoin{
+		Nonterminal: Nonterminal{
+			From: &Exchange{
+				Nonterminal: Nonterminal{From: join.From},
+				Key:         key,
+				Geometry:    sharedGeometry(u.Geometry),
+			},
+		},
+		Build: &Node{
+			OutputType: build.OutputType,
+			Input:      build.Input,
+			Op: &Exchange{
+				Nonterminal: Nonterminal{From: bu.From},
+				ID:          1,
+				Key:         []string{"$__key"},
+				Geometry:    sharedGeometry(bu.Geometry),
+			},
+		},
+		Key:    key,
+		Result: join.Result,
+	}
+	above.setinput(hj)
+	uparent.setinput(&ExchangeMap{
+		Nonterminal: Nonterminal{From: u.From},
+		Geometry:    u.Geometry,
+	})
+	// the replacement is no longer needed
+	if len(s.Inner) == 1 {
+		if sparent == nil {
+			t.Root.Op = s.From
+		} else {
+			sparent.setinput(s.From)
+		}
+	} else {
+		s.Inner[id] = &Node{Input: -1, Op: NoOutput{}}
+	}
+}
+
+// largeJoin determines if un performs a join against
+// a HASH_REPLACEMENT produced by s with a build side
+// that is larger than pir.LargeSize rows, and if so
+// returns the replacement id and the join key path
+func largeJoin(un *Unnest, s *Substitute, indexer Indexer) (int, []string, bool) {
+	hr, ok := un.Expr.(*expr.Builtin)
+	if !ok || hr.Func != expr.HashReplacement || len(hr.Args) != 4 {
+		return 0, nil, false
+	}
+	idarg, ok := hr.Args[0].(expr.Integer)
+	if !ok || int(idarg) < 0 || int(idarg) >= len(s.Inner) {
+		return 0, nil, false
+	}
+	if kind, ok := hr.Args[1].(expr.String); !ok || kind != "joinlist" {
+		return 0, nil, false
+	}
+	if label, ok := hr.Args[2].(expr.String); !ok || label != "$__key" {
+		return 0, nil, false
+	}
+	key, ok := expr.FlatPath(hr.Args[3])
+	if !ok {
+		return 0, nil, false
+	}
+	id := int(idarg)
+	build := s.Inner[id]
+	bu, ok := build.Op.(*UnionMap)
+	if !ok || bu.Geometry == nil || build.Input < 0 {
+		return 0, nil, false
+	}
+	var leaf *Leaf
+	for op := bu.From; op != nil; op = op.input() {
+		if l, ok := op.(*Leaf); ok {
+			leaf = l
+		}
+	}
+	if leaf == nil || leaf.Orig == nil {
+		return 0, nil, false
+	}
+	idx, err := index(indexer, leaf.Orig.Expr)
+	if err != nil || idx == nil {
+		return 0, nil, false
+	}
+	ts, ok := idx.(pir.TableStats)
+	if !ok {
+		return 0, nil, false
+	}
+	rows, _, ok := ts.Stats()
+	if !ok || rows <= pir.LargeSize {
+		return 0, nil, false
+	}
+	return id, key, true
+}
+
+// replacementRefs counts the number of references
+// to the replacement id in the expressions of t
+func replacementRefs(t *Tree, id int) int {
+	rc := &refCounter{id: id}
+	var buf ion.Buffer
+	var st ion.Symtab
+	if err := t.Root.encode(&buf, &st, &ExecParams{Rewriter: rc}); err != nil {
+		return -1
+	}
+	return rc.refs
+}
+
+type refCounter struct {
+	id, refs int
+}
+
+func (r *refCounter) Walk(e expr.Node) expr.Rewriter { return r }
+
+func (r *refCounter) Rewrite(e expr.Node) expr.Node {
+	b, ok := e.(*expr.Builtin)
+	if !ok {
+		return e
+	}
+	var arg expr.Node
+	switch b.Func {
+	case expr.ListReplacement, expr.HashReplacement,
+		expr.StructReplacement, expr.ScalarReplacement:
+		arg = b.Args[0]
+	case expr.InReplacement:
+		arg = b.Args[1]
+	default:
+		return e
+	}
+	if id, ok := arg.(expr.Integer); ok && int(id) == r.id {
+		r.refs++
+	}
+	return e
+}
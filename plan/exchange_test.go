@@ -0,0 +1,255 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/date"
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/vm"
+)
+
+// pipeEnv is the environment used by
+// decoded pipeTransports
+var pipeEnv *testenv
+
+func init() {
+	AddTransportDecoder("testpipe", func() TransportDecoder {
+		return &pipeTransport{}
+	})
+}
+
+// pipeTransport is a Transport that executes
+// queries on an in-process peer by serializing
+// them over a net.Pipe, so that each query is
+// encoded and decoded just like it would be
+// if it were sent to a remote peer
+type pipeTransport struct{}
+
+func (p *pipeTransport) Encode(dst *ion.Buffer, st *ion.Symtab) {
+	dst.BeginStruct(-1)
+	settype("testpipe", dst, st)
+	dst.EndStruct()
+}
+
+func (p *pipeTransport) SetField(ion.Field) error { return errUnexpectedField }
+
+func (p *pipeTransport) Exec(ep *ExecParams) error {
+	local, remote := net.Pipe()
+	defer local.Close()
+	var wg sync.WaitGroup
+	var serverr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serverr = Serve(remote, pipeEnv)
+	}()
+	c := Client{Pipe: local}
+	err := c.Exec(ep)
+	if err2 := c.Close(); err == nil {
+		err = err2
+	}
+	wg.Wait()
+	if err == nil {
+		err = serverr
+	}
+	return err
+}
+
+// largeIndex reports a number of rows
+// larger than what fits in a replacement
+type largeIndex struct{}
+
+func (largeIndex) TimeRange([]string) (min, max date.Time, ok bool) { return }
+func (largeIndex) HasPartition(string) bool                         { return false }
+func (largeIndex) Stats() (int64, int64, bool)                      { return 1000000, 1 << 30, true }
+
+type largeIndexer struct{}
+
+func (largeIndexer) Index(expr.Node) (Index, error) { return largeIndex{}, nil }
+
+// exchangeEnv is a SplitEnv that
+// also implements Indexer
+type exchangeEnv struct {
+	*testenv
+	peers int
+}
+
+func (e *exchangeEnv) Geometry() *Geometry {
+	g := &Geometry{}
+	for i := 0; i < e.peers; i++ {
+		g.Peers = append(g.Peers, &pipeTransport{})
+	}
+	return g
+}
+
+// runQuery executes tree and returns the
+// output rows and the number of bytes scanned
+func runQuery(t *testing.T, tree *Tree, env *testenv) ([]ion.Datum, int64) {
+	var out bytes.Buffer
+	ep := &ExecParams{
+		Plan:    tree,
+		Output:  &out,
+		Runner:  env,
+		Context: context.Background(),
+	}
+	if err := Exec(ep); err != nil {
+		t.Fatal(err)
+	}
+	var st ion.Symtab
+	var rows []ion.Datum
+	buf := out.Bytes()
+	for len(buf) > 0 {
+		d, rest, err := ion.ReadDatum(&st, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.IsEmpty() {
+			rows = append(rows, d.Clone())
+		}
+		buf = rest
+	}
+	return rows, ep.Stats.BytesScanned
+}
+
+func TestExchangeJoin(t *testing.T) {
+	env := &testenv{t: t}
+	pipeEnv = env
+	t.Cleanup(func() { pipeEnv = nil })
+
+	queries := []string{
+		`SELECT COUNT(*) AS c, SUM(b.passenger_count) AS s FROM nyc_taxi a JOIN nyc_taxi b ON a.tpep_pickup_datetime = b.tpep_pickup_datetime`,
+		`SELECT a.VendorID AS v, SUM(b.passenger_count) AS c FROM nyc_taxi a JOIN nyc_taxi b ON a.tpep_pickup_datetime = b.tpep_pickup_datetime WHERE a.passenger_count > 1 GROUP BY a.VendorID ORDER BY v`,
+		`SELECT b.Make AS make, COUNT(*) AS c FROM parking a JOIN parking b ON a.Ticket = b.Ticket GROUP BY b.Make ORDER BY COUNT(*) DESC, b.Make LIMIT 5`,
+	}
+	for i, text := range queries {
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			q, err := partiql.Parse([]byte(text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the reference results use a replacement
+			// for the build side of the join
+			ref, err := New(q, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, wantscan := runQuery(t, ref, env)
+			if len(want) == 0 {
+				t.Fatal("no reference output")
+			}
+
+			env.indexer = largeIndexer{}
+			defer func() { env.indexer = nil }()
+			q, err = partiql.Parse([]byte(text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := NewSplit(q, &exchangeEnv{testenv: env, peers: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan := tree.String()
+			t.Logf("plan:\n%s", plan)
+			for _, op := range []string{"EXCHANGE MAP", "HASH JOIN", "EXCHANGE ON"} {
+				if !strings.Contains(plan, op) {
+					t.Fatalf("plan does not contain %s", op)
+				}
+			}
+			if strings.Contains(plan, "HASH_REPLACEMENT") {
+				t.Fatal("plan still contains HASH_REPLACEMENT")
+			}
+			testPlanSerialize(t, tree)
+
+			got, scanned := runQuery(t, tree, env)
+			// each share of the input should
+			// only be scanned once for each
+			// side of the join
+			if scanned > wantscan {
+				t.Errorf("scanned %d bytes; replacement scanned %d bytes", scanned, wantscan)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d rows, want %d", len(got), len(want))
+			}
+			for j := range got {
+				if !got[j].Equal(want[j]) {
+					t.Errorf("row %d: got %v, want %v", j, got[j], want[j])
+				}
+			}
+		})
+	}
+}
+
+func TestExchangeSmallJoin(t *testing.T) {
+	// joins with a small build side
+	// should keep using replacements
+	env := &testenv{t: t}
+	q, err := partiql.Parse([]byte(`SELECT COUNT(b.Make) FROM parking a JOIN parking b ON a.Ticket = b.Ticket`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewSplit(q, &exchangeEnv{testenv: env, peers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := tree.String(); strings.Contains(plan, "EXCHANGE") {
+		t.Fatalf("unexpected exchange:\n%s", plan)
+	}
+}
+
+func TestExchangeScanCancel(t *testing.T) {
+	// partition 0 waits for partition 1, whose
+	// peer is still building the other side of
+	// the join when the query is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	es := &ExchangeScan{
+		Scan:  "cancel-test",
+		Key:   []string{"x"},
+		Part:  0,
+		Parts: 2,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		var dst vm.QueryBuffer
+		errc <- es.exec(&dst, nil, &ExecParams{Context: ctx})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExchangeScan didn't return after the query was canceled")
+	}
+	scanLock.Lock()
+	_, ok := scans[es.Scan]
+	scanLock.Unlock()
+	if ok {
+		t.Error("canceled scan is still registered")
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
@@ -1887,7 +1888,7 @@ func testRemoteEquivalent(t *testing.T, tree *Tree,
 	if remoteerr != nil {
 		t.Errorf("remote error: %s", remoteerr)
 	}
-	if ep.Stats != *wantstat {
+	if !reflect.DeepEqual(&ep.Stats, wantstat) {
 		t.Errorf("got stats %#v", &ep.Stats)
 		t.Errorf("wanted stats %#v", wantstat)
 	}
@@ -2005,7 +2006,7 @@ func testSplitEquivalent(t *testing.T, text string, e *testenv, expected []strin
 	// inputs across union maps, so stats for
 	// split queries are not expected to match the
 	// original query
-	if ep.Stats != *wantstat {
+	if !reflect.DeepEqual(&ep.Stats, wantstat) {
 		t.Logf("got stats %#v", &ep.Stats)
 		t.Logf("wanted stats %#v", wantstat)
 	}
@@ -41,8 +41,20 @@ func gv(n *Node, dst io.Writer, tid, oid int) (int, int, error) {
 	}
 	var prev Op
 	var children []*Node
+	// stats are the statistics of the next op
+	// (Instrument ops precede the op they instrument)
+	var stats string
 	for o := n.Op; o != nil; o = o.input() {
-		fmt.Fprintf(dst, "n%d [label=%q];\n", oid, o.String())
+		if i, ok := o.(*Instrument); ok {
+			stats = i.String()
+			continue
+		}
+		label := o.String()
+		if stats != "" {
+			label += "\n" + stats
+			stats = ""
+		}
+		fmt.Fprintf(dst, "n%d [label=%q];\n", oid, label)
 		if prev != nil {
 			fmt.Fprintf(dst, "n%d -> n%d;\n", oid, oid-1)
 		}
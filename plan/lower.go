@@ -244,6 +244,18 @@ slowpath:
 	}, nil
 }
 
+// externalSort lets the final ORDER BY of a query
+// that exports its results sort every row
+// instead of applying the default limit
+func externalSort(t *Tree) {
+	for op := t.Root.Op; op != nil; op = op.input() {
+		if o, ok := op.(*OrderBy); ok {
+			o.External = o.Limit == 0
+			return
+		}
+	}
+}
+
 func lowerBind(in *pir.Bind, from Op) (Op, error) {
 	return &Project{
 		Nonterminal: Nonterminal{From: from},
@@ -673,6 +685,14 @@ func (e pirenv) Index(tbl expr.Node) (pir.Index, error) {
 	return index(idx, tbl)
 }
 
+func (e pirenv) View(tbl expr.Node) (*expr.Query, error) {
+	v, ok := e.env.(Viewer)
+	if !ok {
+		return nil, nil
+	}
+	return v.View(tbl)
+}
+
 // New creates a new Tree from raw query AST.
 func New(q *expr.Query, env Env) (*Tree, error) {
 	return newTree(q, env, false)
@@ -690,6 +710,7 @@ func newTree(q *expr.Query, env Env, split bool) (*Tree, error) {
 	}
 	results := b.FinalBindings()
 	types := b.FinalTypes()
+	joins := b.JoinCosts()
 	if split {
 		reduce, err := pir.Split(b)
 		if err != nil {
@@ -704,6 +725,12 @@ func newTree(q *expr.Query, env Env, split bool) (*Tree, error) {
 	if err != nil {
 		return nil, err
 	}
+	if split {
+		exchangeJoins(tree, env)
+	}
+	if q.Into != nil {
+		externalSort(tree)
+	}
 	tree.Results = results
 	tree.ResultTypes = types
 
@@ -711,11 +738,20 @@ func newTree(q *expr.Query, env Env, split bool) (*Tree, error) {
 		return tree, nil
 	}
 
+	if q.Analyze {
+		if q.Into != nil {
+			return nil, fmt.Errorf("cannot use EXPLAIN ANALYZE with SELECT INTO")
+		}
+		instrument(tree)
+	}
+
 	// explain the query
 	op := &Explain{
-		Format: q.Explain,
-		Query:  q,
-		Tree:   tree,
+		Format:  q.Explain,
+		Query:   q,
+		Tree:    tree,
+		Joins:   joins,
+		Analyze: q.Analyze,
 	}
 
 	res := &Tree{Inputs: tree.Inputs, Root: Node{Op: op}}
@@ -25,6 +25,7 @@ import (
 	"github.com/SnellerInc/sneller/date"
 	"github.com/SnellerInc/sneller/expr"
 	"github.com/SnellerInc/sneller/fsutil"
+	"github.com/SnellerInc/sneller/plan/pir"
 )
 
 type multiIndex []Index
@@ -51,6 +52,24 @@ func (m multiIndex) TimeRange(p []string) (min, max date.Time, ok bool) {
 	return min, max, len(m) > 0
 }
 
+// Stats returns the sum of the statistics
+// of all the contained indexes.
+func (m multiIndex) Stats() (rows, size int64, ok bool) {
+	for i := range m {
+		ts, ok := m[i].(pir.TableStats)
+		if !ok {
+			return 0, 0, false
+		}
+		r, s, ok := ts.Stats()
+		if !ok {
+			return 0, 0, false
+		}
+		rows += r
+		size += s
+	}
+	return rows, size, len(m) > 0
+}
+
 func (m multiIndex) HasPartition(x string) bool {
 	for i := range m {
 		if !m[i].HasPartition(x) {
@@ -32,6 +32,8 @@ func TestOutput(t *testing.T) {
 		text string // create temp table
 	}{{
 		text: "SELECT * INTO foo.bar FROM parking",
+	}, {
+		text: "SELECT * INTO foo.bar FROM parking ORDER BY Ticket",
 	}}
 	for i := range cases {
 		c := &cases[i]
@@ -101,6 +103,40 @@ func TestOutput(t *testing.T) {
 	}
 }
 
+func TestOutputExternalSort(t *testing.T) {
+	cases := []struct {
+		text     string
+		external bool
+	}{
+		{"SELECT * FROM parking ORDER BY Ticket LIMIT 10", false},
+		{"SELECT * INTO foo.bar FROM parking ORDER BY Ticket", true},
+		{"SELECT * INTO foo.bar FROM parking ORDER BY Ticket LIMIT 10", false},
+	}
+	for i := range cases {
+		q, err := partiql.Parse([]byte(cases[i].text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := New(q, mkoutenv(t, t.TempDir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ord *OrderBy
+		for op := tree.Root.Op; op != nil; op = op.input() {
+			if o, ok := op.(*OrderBy); ok {
+				ord = o
+				break
+			}
+		}
+		if ord == nil {
+			t.Fatalf("%s: no ORDER BY in plan:\n%s", cases[i].text, tree)
+		}
+		if ord.External != cases[i].external {
+			t.Errorf("%s: External = %v, want %v", cases[i].text, ord.External, cases[i].external)
+		}
+	}
+}
+
 var _ UploadEnv = (*outputenv)(nil)
 
 type outputenv struct {
@@ -65,6 +65,8 @@ func (b *Trace) walkFrom(f expr.From, e Env) error {
 	}
 	switch f := f.(type) {
 	case *expr.Join:
+		f, costs := joinorder(f, e)
+		b.joins = append(b.joins, costs...)
 		return b.walkFromJoin(f, e)
 	case *expr.Table:
 		return b.walkFromTable(f, e)
@@ -95,7 +97,13 @@ func (b *Trace) walkFromTable(f *expr.Table, e Env) error {
 }
 
 func (b *Trace) walkFromJoin(f *expr.Join, e Env) error {
-	err := b.walkFrom(f.Left, e)
+	var err error
+	if j, ok := f.Left.(*expr.Join); ok {
+		// already ordered by walkFrom
+		err = b.walkFromJoin(j, e)
+	} else {
+		err = b.walkFrom(f.Left, e)
+	}
 	if err != nil {
 		return err
 	}
@@ -186,8 +194,14 @@ func Build(q *expr.Query, e Env) (*Trace, error) {
 			return nil, err
 		}
 	}
+	if v, ok := e.(Viewer); ok {
+		body, err = replaceViews(body, v)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if sel, ok := body.(*expr.Select); ok {
-		t, err := build(nil, sel, e)
+		t, err := buildTrace(&Trace{export: q.Into != nil}, sel, e)
 		if err != nil {
 			return nil, err
 		}
@@ -197,6 +211,17 @@ func Build(q *expr.Query, e Env) (*Trace, error) {
 			if !ok || len(p) != 2 {
 				return nil, fmt.Errorf("unsupported INTO: %q", expr.ToString(q.Into))
 			}
+			// a table can't be created
+			// in place of a view
+			if v, ok := e.(Viewer); ok {
+				view, err := v.View(q.Into)
+				if err != nil {
+					return nil, err
+				}
+				if view != nil {
+					return nil, errorf(q.Into, "cannot use view %s as the INTO table", expr.ToString(q.Into))
+				}
+			}
 			t.Into(q.Into, path.Join("db", p[0], p[1]))
 		}
 		return t, nil
@@ -206,7 +231,10 @@ func Build(q *expr.Query, e Env) (*Trace, error) {
 }
 
 func build(parent *Trace, s *expr.Select, e Env) (*Trace, error) {
-	b := &Trace{Parent: parent}
+	return buildTrace(&Trace{Parent: parent}, s, e)
+}
+
+func buildTrace(b *Trace, s *expr.Select, e Env) (*Trace, error) {
 	s = expr.Simplify(s, expr.NoHint).(*expr.Select)
 	err := expr.Check(s)
 	if err != nil {
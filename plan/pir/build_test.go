@@ -1099,6 +1099,26 @@ ORDER BY m, d, h`,
 			},
 			results: []expr.TypeSet{expr.StringType},
 		},
+		{
+			// INTO with an ORDER BY that has no LIMIT
+			input: `SELECT x, y INTO db.sorted FROM foo ORDER BY x`,
+			expect: []string{
+				"ITERATE foo FIELDS [x, y]",
+				"ORDER BY x ASC NULLS FIRST",
+				"PROJECT x AS x, y AS y",
+				"OUTPUT PART db/db/sorted",
+				"OUTPUT INDEX db.sorted AT db/db/sorted",
+			},
+			split: []string{
+				"UNION MAP foo (",
+				"	ITERATE PART foo FIELDS [x, y])",
+				"ORDER BY x ASC NULLS FIRST",
+				"PROJECT x AS x, y AS y",
+				"OUTPUT PART db/db/sorted",
+				"OUTPUT INDEX db.sorted AT db/db/sorted",
+			},
+			results: []expr.TypeSet{expr.StringType},
+		},
 		{
 			// EXISTS -> semi-join
 			input: `SELECT x, EXISTS(SELECT * FROM other WHERE key = x) AS has_other FROM input`,
@@ -0,0 +1,261 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package pir
+
+import (
+	"fmt"
+
+	"github.com/SnellerInc/sneller/expr"
+)
+
+// TableStats may optionally be implemented by an Index
+// to provide the table statistics used by the cost-based
+// join optimizer.
+type TableStats interface {
+	// Stats returns the approximate number of
+	// rows and decompressed bytes in the table.
+	// If the statistics are not available,
+	// then ok should be false.
+	Stats() (rows, size int64, ok bool)
+}
+
+// JoinCost describes the estimated cost
+// of one input table of an inner join.
+type JoinCost struct {
+	// Table is the name of the table binding.
+	Table string
+	// Rows and Size are the estimated number of
+	// rows and decompressed bytes in the table.
+	Rows, Size int64
+	// Build is true if the table is used as
+	// the build (hashed) side of a join,
+	// or false if the table is scanned
+	// and probed against the build side(s).
+	Build bool
+}
+
+func (j *JoinCost) String() string {
+	side := "PROBE"
+	if j.Build {
+		side = "BUILD"
+	}
+	return fmt.Sprintf("%s %s ROWS %d BYTES %d", side, j.Table, j.Rows, j.Size)
+}
+
+// less returns true if j is cheaper
+// to build than x
+func (j *JoinCost) less(x *JoinCost) bool {
+	if j.Rows != x.Rows {
+		return j.Rows < x.Rows
+	}
+	return j.Size < x.Size
+}
+
+// flattenJoins collects the table bindings and
+// top-level ON conjunctions of a tree of inner joins
+func flattenJoins(f expr.From, tables []expr.Binding, on []expr.Node) ([]expr.Binding, []expr.Node, bool) {
+	switch f := f.(type) {
+	case *expr.Table:
+		return append(tables, f.Binding), on, true
+	case *expr.Join:
+		if f.Kind != expr.InnerJoin || f.On == nil {
+			return nil, nil, false
+		}
+		tables, on, ok := flattenJoins(f.Left, tables, on)
+		if !ok {
+			return nil, nil, false
+		}
+		return append(tables, f.Right), conjunctions(f.On, on), true
+	default:
+		return nil, nil, false
+	}
+}
+
+// joinorder determines the order in which the
+// tables in a tree of inner joins should be joined
+// using the table statistics provided by e.
+//
+// Every table except for the first one in a (left-deep)
+// join tree is used to build a hash table, so joinorder
+// picks the largest table to be scanned and then greedily
+// joins the smallest table that is connected to the tables
+// that have already been joined by at least one ON condition.
+//
+// If the statistics for any of the tables are unavailable,
+// or the join cannot be reordered, then joinorder returns
+// the original join tree and no costs.
+func joinorder(j *expr.Join, e Env) (*expr.Join, []JoinCost) {
+	if e == nil {
+		return j, nil
+	}
+	tables, on, ok := flattenJoins(j, nil, nil)
+	if !ok {
+		return j, nil
+	}
+	costs := make([]JoinCost, len(tables))
+	for i := range tables {
+		switch tables[i].Expr.(type) {
+		case *expr.Select, *expr.Unpivot:
+			return j, nil
+		}
+		name := tables[i].Result()
+		if name == "" {
+			return j, nil
+		}
+		for k := range tables[:i] {
+			if tables[k].Result() == name {
+				return j, nil
+			}
+		}
+		idx, err := e.Index(tables[i].Expr)
+		if err != nil || idx == nil {
+			return j, nil
+		}
+		ts, ok := idx.(TableStats)
+		if !ok {
+			return j, nil
+		}
+		rows, size, ok := ts.Stats()
+		if !ok {
+			return j, nil
+		}
+		costs[i] = JoinCost{Table: name, Rows: rows, Size: size, Build: i > 0}
+	}
+
+	// refs[c][t] is set if conjunction c references table t
+	refs := make([][]bool, len(on))
+	for c := range on {
+		refs[c] = make([]bool, len(tables))
+		for t := range tables {
+			refs[c][t] = !doesNotReference(on[c], tables[t].Result())
+		}
+	}
+	placed := make([]bool, len(tables))
+	// connected returns true if some conjunction joins
+	// table t to the set of tables that have been placed
+	connected := func(t int) bool {
+		for c := range on {
+			if !refs[c][t] {
+				continue
+			}
+			other, inside := false, true
+			for k := range tables {
+				if k == t || !refs[c][k] {
+					continue
+				}
+				other = true
+				inside = inside && placed[k]
+			}
+			if other && inside {
+				return true
+			}
+		}
+		return false
+	}
+
+	probe := 0
+	for i := range costs {
+		if costs[probe].less(&costs[i]) {
+			probe = i
+		}
+	}
+	order := []int{probe}
+	placed[probe] = true
+	for len(order) < len(tables) {
+		next := -1
+		for i := range tables {
+			if placed[i] || !connected(i) {
+				continue
+			}
+			if next == -1 || costs[i].less(&costs[next]) {
+				next = i
+			}
+		}
+		if next == -1 {
+			// the tables are not all connected
+			// by equality conditions
+			return j, nil
+		}
+		order = append(order, next)
+		placed[next] = true
+	}
+
+	out := make([]JoinCost, len(order))
+	for k, t := range order {
+		out[k] = costs[t]
+		out[k].Build = k > 0
+	}
+	same := true
+	for k := range order {
+		same = same && order[k] == k
+	}
+	if same {
+		return j, out
+	}
+
+	// each conjunction is evaluated as part of
+	// the join that introduces the last of the
+	// tables that it references
+	pos := make([]int, len(tables))
+	for k, t := range order {
+		pos[t] = k
+	}
+	conds := make([]expr.Node, len(order))
+	for c := range on {
+		at := 1
+		for t := range tables {
+			if refs[c][t] && pos[t] > at {
+				at = pos[t]
+			}
+		}
+		if conds[at] == nil {
+			conds[at] = on[c]
+		} else {
+			conds[at] = expr.And(conds[at], on[c])
+		}
+	}
+	var from expr.From = &expr.Table{Binding: tables[order[0]]}
+	for k := 1; k < len(order); k++ {
+		right := tables[order[k]]
+		if conds[k] == nil {
+			return j, nil
+		}
+		// make sure the join can still be performed
+		// in the chosen order
+		if _, _, err := splitOnEqual(right.Result(), conds[k]); err != nil {
+			return j, nil
+		}
+		from = &expr.Join{
+			Kind:  expr.InnerJoin,
+			On:    conds[k],
+			Left:  from,
+			Right: right,
+		}
+	}
+	return from.(*expr.Join), out
+}
+
+// JoinCosts returns the estimated costs of the
+// inputs of each of the inner joins in the trace
+// and its replacements that were ordered by the
+// cost-based join optimizer, in join order.
+func (b *Trace) JoinCosts() []JoinCost {
+	var out []JoinCost
+	out = append(out, b.joins...)
+	for i := range b.Replacements {
+		out = append(out, b.Replacements[i].JoinCosts()...)
+	}
+	return out
+}
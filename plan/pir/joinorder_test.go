@@ -0,0 +1,123 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package pir
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/SnellerInc/sneller/date"
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+)
+
+type statsIndex struct {
+	rows, size int64
+}
+
+func (s *statsIndex) TimeRange([]string) (min, max date.Time, ok bool) { return }
+func (s *statsIndex) HasPartition(string) bool                         { return false }
+func (s *statsIndex) Stats() (int64, int64, bool)                      { return s.rows, s.size, true }
+
+// statsEnv maps table names to statistics
+type statsEnv map[string]*statsIndex
+
+func (s statsEnv) Schema(expr.Node) expr.Hint { return nil }
+
+func (s statsEnv) Index(e expr.Node) (Index, error) {
+	id, ok := e.(expr.Ident)
+	if !ok {
+		return nil, fmt.Errorf("unexpected table %s", expr.ToString(e))
+	}
+	if idx := s[string(id)]; idx != nil {
+		return idx, nil
+	}
+	return nil, nil
+}
+
+func TestJoinOrder(t *testing.T) {
+	env := statsEnv{
+		"facts": {rows: 1000000, size: 1 << 30},
+		"small": {rows: 100, size: 1 << 16},
+		"large": {rows: 10000, size: 1 << 24},
+	}
+	tcs := []struct {
+		input  string
+		scan   string   // table that is scanned
+		tables []string // expected join order, or nil if unchanged
+	}{
+		{
+			// the fact table should be scanned
+			// and the dimension tables built
+			input:  "SELECT l.x, f.y, s.z FROM large l JOIN facts f ON l.id = f.lid JOIN small s ON s.id = f.sid",
+			scan:   "facts AS f",
+			tables: []string{"f", "s", "l"},
+		},
+		{
+			// build the smaller side of a simple join
+			input:  "SELECT s.x, f.y FROM small s JOIN facts f ON s.id = f.sid",
+			scan:   "facts AS f",
+			tables: []string{"f", "s"},
+		},
+		{
+			// already in the right order
+			input:  "SELECT s.x, f.y FROM facts f JOIN small s ON s.id = f.sid",
+			scan:   "facts AS f",
+			tables: []string{"f", "s"},
+		},
+		{
+			// small can only be joined after large
+			input:  "SELECT s.x, f.y FROM large l JOIN facts f ON l.id = f.lid JOIN small s ON s.id = l.sid",
+			scan:   "facts AS f",
+			tables: []string{"f", "l", "s"},
+		},
+		{
+			// no statistics for "other"
+			input: "SELECT s.x, o.y FROM small s JOIN other o ON s.id = o.sid",
+			scan:  "small AS s",
+		},
+	}
+	for i := range tcs {
+		tc := &tcs[i]
+		t.Run(fmt.Sprintf("case-%d", i), func(t *testing.T) {
+			q, err := partiql.Parse([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Build(q, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			b.Describe(&out)
+			if !strings.Contains(out.String(), "\nITERATE "+tc.scan+" ") {
+				t.Errorf("expected to scan %s:\n%s", tc.scan, out.String())
+			}
+			costs := b.JoinCosts()
+			var got []string
+			for j := range costs {
+				got = append(got, costs[j].Table)
+				if costs[j].Build != (j > 0) {
+					t.Errorf("%s: build = %v", costs[j].Table, costs[j].Build)
+				}
+			}
+			if !slices.Equal(got, tc.tables) {
+				t.Errorf("got order %v, want %v", got, tc.tables)
+			}
+		})
+	}
+}
@@ -857,6 +857,16 @@ type Trace struct {
 	// produced by an expression
 	final      []expr.Binding
 	finalTypes []expr.TypeSet
+
+	// joins is the list of join costs
+	// chosen by the join optimizer
+	joins []JoinCost
+
+	// export is set if the results of
+	// the trace are written out with INTO,
+	// in which case the size of an ORDER BY
+	// is not restricted (see checkSortSize)
+	export bool
 }
 
 // Equals returns true if b and x would produce the same
@@ -69,6 +69,12 @@ func checkNoAggregateInCondition(e expr.Node, context string) error {
 }
 
 func checkSortSize(t *Trace) error {
+	if t.export {
+		// exported results are sorted with an
+		// external merge sort, so they don't need
+		// to fit in memory
+		return nil
+	}
 	final := t.Final()
 	if b, ok := final.(*Bind); ok {
 		final = b.parent()
@@ -0,0 +1,123 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package pir
+
+import (
+	"github.com/SnellerInc/sneller/expr"
+)
+
+// Viewer may optionally be implemented by Env
+// to provide the definitions of stored views.
+//
+// Build replaces each reference to a view
+// with the body of the view (just like a CTE),
+// so the view body is optimized together with
+// the rest of the query.
+type Viewer interface {
+	// View returns the query that defines
+	// the view referenced by the given table
+	// expression, or (nil, nil) if the table
+	// expression doesn't reference a view.
+	// Build may modify the returned query.
+	View(expr.Node) (*expr.Query, error)
+}
+
+// maxViewDepth is the maximum number of
+// views that can be nested within one another
+const maxViewDepth = 16
+
+type viewReplacer struct {
+	env   Viewer
+	depth int
+	err   error
+}
+
+func (v *viewReplacer) Walk(e expr.Node) expr.Rewriter {
+	if v.err != nil {
+		return nil
+	}
+	return v
+}
+
+func (v *viewReplacer) Rewrite(e expr.Node) expr.Node {
+	if v.err != nil {
+		return e
+	}
+	var bind *expr.Binding
+	switch e := e.(type) {
+	case *expr.Table:
+		bind = &e.Binding
+	case *expr.Join:
+		// we'll walk e.Left later
+		bind = &e.Right
+	default:
+		return e
+	}
+	switch bind.Expr.(type) {
+	case expr.Ident, *expr.Dot:
+		if body := v.inline(bind.Expr); body != nil {
+			bind.Expr = body
+		}
+	}
+	return e
+}
+
+// inline returns the body of the view
+// referenced by tbl with all of the views
+// it references replaced, or nil if tbl
+// doesn't reference a view
+func (v *viewReplacer) inline(tbl expr.Node) expr.Node {
+	q, err := v.env.View(tbl)
+	if err != nil {
+		v.err = err
+		return nil
+	}
+	if q == nil {
+		return nil
+	}
+	if v.depth >= maxViewDepth {
+		v.err = errorf(tbl, "view %s: views nested more than %d levels deep", expr.ToString(tbl), maxViewDepth)
+		return nil
+	}
+	if q.Into != nil {
+		v.err = errorf(tbl, "view %s: cannot use INTO in a view", expr.ToString(tbl))
+		return nil
+	}
+	body := q.Body
+	if len(q.With) > 0 {
+		body, err = replaceTables(body, q.With)
+		if err != nil {
+			v.err = err
+			return nil
+		}
+	}
+	if _, ok := body.(*expr.Select); !ok {
+		v.err = errorf(tbl, "view %s: cannot use %T as a view", expr.ToString(tbl), body)
+		return nil
+	}
+	inner := &viewReplacer{env: v.env, depth: v.depth + 1}
+	body = expr.Rewrite(inner, body)
+	if inner.err != nil {
+		v.err = inner.err
+		return nil
+	}
+	return body
+}
+
+func replaceViews(body expr.Node, env Viewer) (expr.Node, error) {
+	rp := &viewReplacer{env: env}
+	ret := expr.Rewrite(rp, body)
+	return ret, rp.err
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package pir
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+)
+
+type viewenv struct {
+	testenv
+	views map[string]string
+}
+
+func (e *viewenv) View(tbl expr.Node) (*expr.Query, error) {
+	text, ok := e.views[expr.ToString(tbl)]
+	if !ok {
+		return nil, nil
+	}
+	return partiql.Parse([]byte(text))
+}
+
+func TestBuildViews(t *testing.T) {
+	views := map[string]string{
+		"v":      "SELECT x, y FROM input WHERE z = 1",
+		"nested": "SELECT x AS a FROM v WHERE y < 10",
+		"cte":    "WITH t AS (SELECT x FROM input WHERE y = 'foo') SELECT x FROM t",
+		"loop":   "SELECT * FROM loop",
+		"multi":  "SELECT x FROM input UNION ALL SELECT y FROM input",
+		"db.v":   "SELECT x FROM input",
+	}
+	tests := []struct {
+		input  string
+		expect []string
+	}{
+		{
+			// the filter is pushed into the view
+			input: "SELECT x FROM v WHERE y > 3",
+			expect: []string{
+				"ITERATE input FIELDS [x, y, z] WHERE z = 1 AND y > 3",
+				"PROJECT x AS x",
+			},
+		},
+		{
+			input: "SELECT a FROM nested",
+			expect: []string{
+				"ITERATE input FIELDS [x, y, z] WHERE z = 1 AND y < 10",
+				"PROJECT x AS a",
+			},
+		},
+		{
+			input: "SELECT COUNT(*) FROM cte",
+			expect: []string{
+				"ITERATE input FIELDS [y] WHERE y = 'foo'",
+				"AGGREGATE COUNT(*) AS \"count\"",
+			},
+		},
+		{
+			// a CTE shadows a view
+			input: "WITH v AS (SELECT x FROM other) SELECT x FROM v",
+			expect: []string{
+				"ITERATE other FIELDS [x]",
+				"PROJECT x AS x",
+			},
+		},
+	}
+	for i := range tests {
+		q, err := partiql.Parse([]byte(tests[i].input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Build(q, &viewenv{views: views})
+		if err != nil {
+			t.Fatalf("%s: %s", tests[i].input, err)
+		}
+		var out strings.Builder
+		NoSplit(b).Describe(&out)
+		want := strings.Join(tests[i].expect, "\n") + "\n"
+		if got := out.String(); got != want {
+			t.Errorf("%s: got\n%swant\n%s", tests[i].input, got, want)
+		}
+	}
+
+	errors := []struct {
+		input, rx string
+	}{
+		{"SELECT * FROM loop", "nested more than 16 levels"},
+		{"SELECT * FROM multi", "cannot use .* as a view"},
+		{"SELECT * INTO db.v FROM input", "cannot use view db.v as the INTO table"},
+	}
+	for i := range errors {
+		q, err := partiql.Parse([]byte(errors[i].input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Build(q, &viewenv{views: views})
+		if err == nil {
+			t.Errorf("%s: no error", errors[i].input)
+			continue
+		}
+		if !regexp.MustCompile(errors[i].rx).MatchString(err.Error()) {
+			t.Errorf("%s: error %q doesn't match %s", errors[i].input, err, errors[i].rx)
+		}
+	}
+}
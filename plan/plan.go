@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/SnellerInc/sneller/expr"
 	"github.com/SnellerInc/sneller/ion"
@@ -205,6 +206,12 @@ type Indexer interface {
 	Index(expr.Node) (Index, error)
 }
 
+// Viewer may optionally be implemented by Env to
+// provide the definitions of stored views.
+// References to views are replaced with the
+// body of the view before the query is planned.
+type Viewer = pir.Viewer
+
 // An Index may be returned by Indexer.Index to provide
 // additional table metadata that may be used during
 // optimization.
@@ -919,6 +926,10 @@ type OrderBy struct {
 	Columns []vm.SortColumn
 	Limit   int
 	Offset  int
+	// External, if set, sorts every row
+	// rather than applying the default limit;
+	// it is only used when exporting results
+	External bool
 }
 
 func (o *OrderBy) String() string {
@@ -943,6 +954,10 @@ func (o *OrderBy) String() string {
 		fmt.Fprintf(b, " OFFSET %d", o.Offset)
 	}
 
+	if o.External {
+		b.WriteString(" EXTERNAL")
+	}
+
 	return b.String()
 }
 
@@ -969,7 +984,12 @@ func (o *OrderBy) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
 			Limit: o.Limit,
 		}
 	}
-	ord, err := vm.NewOrder(writer, orderBy, limit, ep.Parallel)
+	var ord *vm.Order
+	if o.External && limit == nil {
+		ord, err = vm.NewExternalOrder(writer, orderBy, ep.Parallel)
+	} else {
+		ord, err = vm.NewOrder(writer, orderBy, limit, ep.Parallel)
+	}
 	if err != nil {
 		return err
 	}
@@ -1028,6 +1048,10 @@ func (o *OrderBy) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error
 		dst.BeginField(st.Intern("offset"))
 		dst.WriteInt(int64(o.Offset))
 	}
+	if o.External {
+		dst.BeginField(st.Intern("external"))
+		dst.WriteBool(true)
+	}
 
 	dst.EndStruct()
 	return nil
@@ -1087,6 +1111,10 @@ func (o *OrderBy) SetField(f ion.Field) error {
 			return err
 		}
 		o.Offset = int(i)
+	case "external":
+		var err error
+		o.External, err = f.Bool()
+		return err
 	default:
 		return errUnexpectedField
 	}
@@ -1327,6 +1355,20 @@ type Explain struct {
 	Format expr.ExplainFormat
 	Query  *expr.Query
 	Tree   *Tree
+	// Joins is the list of join inputs
+	// in the order chosen by the optimizer
+	// along with their estimated costs.
+	Joins []pir.JoinCost
+	// Analyze is set for EXPLAIN ANALYZE;
+	// Tree is executed (and it includes
+	// Instrument ops), and the plan is
+	// printed along with the statistics
+	// of each op.
+	Analyze bool
+
+	// populated by analyze
+	elapsed time.Duration
+	scanned int64
 }
 
 func (e *Explain) String() string { return "EXPLAIN QUERY" }
@@ -1345,6 +1387,28 @@ func (e *Explain) encode(dst *ion.Buffer, st *ion.Symtab, ep *ExecParams) error
 	e.Query.Encode(dst, st)
 	dst.BeginField(st.Intern("tree"))
 	e.Tree.encode(dst, st, ep)
+	if e.Analyze {
+		dst.BeginField(st.Intern("analyze"))
+		dst.WriteBool(true)
+	}
+	if len(e.Joins) > 0 {
+		dst.BeginField(st.Intern("joins"))
+		dst.BeginList(-1)
+		for i := range e.Joins {
+			j := &e.Joins[i]
+			dst.BeginStruct(-1)
+			dst.BeginField(st.Intern("table"))
+			dst.WriteString(j.Table)
+			dst.BeginField(st.Intern("rows"))
+			dst.WriteInt(j.Rows)
+			dst.BeginField(st.Intern("size"))
+			dst.WriteInt(j.Size)
+			dst.BeginField(st.Intern("build"))
+			dst.WriteBool(j.Build)
+			dst.EndStruct()
+		}
+		dst.EndList()
+	}
 	dst.EndStruct()
 	return nil
 }
@@ -1370,6 +1434,35 @@ func (e *Explain) SetField(f ion.Field) error {
 		}
 
 		e.Tree = tree
+	case "analyze":
+		b, err := f.Bool()
+		if err != nil {
+			return err
+		}
+		e.Analyze = b
+	case "joins":
+		return f.UnpackList(func(d ion.Datum) error {
+			var j pir.JoinCost
+			err := d.UnpackStruct(func(f ion.Field) error {
+				var err error
+				switch f.Label {
+				case "table":
+					j.Table, err = f.String()
+				case "rows":
+					j.Rows, err = f.Int()
+				case "size":
+					j.Size, err = f.Int()
+				case "build":
+					j.Build, err = f.Bool()
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			e.Joins = append(e.Joins, j)
+			return nil
+		})
 
 	default:
 		return errUnexpectedField
@@ -1378,7 +1471,32 @@ func (e *Explain) SetField(f ion.Field) error {
 	return nil
 }
 
+// describe returns the textual form of the
+// query plan, followed by the join costs (if any)
+// and the execution statistics (for EXPLAIN ANALYZE)
+func (e *Explain) describe() string {
+	var sb strings.Builder
+	sb.WriteString(e.Tree.String())
+	if len(e.Joins) > 0 {
+		sb.WriteString("JOIN ORDER:\n")
+		for i := range e.Joins {
+			fmt.Fprintf(&sb, "\t%s\n", e.Joins[i].String())
+		}
+	}
+	if e.Analyze {
+		fmt.Fprintf(&sb, "EXECUTION TIME: %s\n", e.elapsed.Round(time.Microsecond))
+		fmt.Fprintf(&sb, "BYTES SCANNED: %d\n", e.scanned)
+	}
+	return sb.String()
+}
+
 func (e *Explain) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
+	if e.Analyze {
+		if err := e.analyze(ep); err != nil {
+			return err
+		}
+	}
+
 	var b ion.Buffer
 	var st ion.Symtab
 
@@ -1414,11 +1532,11 @@ func (e *Explain) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
 
 	switch e.Format {
 	case expr.ExplainDefault, expr.ExplainText:
-		b.WriteString(e.Tree.String())
+		b.WriteString(e.describe())
 
 	case expr.ExplainList:
 		b.BeginList(-1)
-		for _, line := range strings.Split(e.Tree.String(), "\n") {
+		for _, line := range strings.Split(e.describe(), "\n") {
 			if len(line) > 0 {
 				b.WriteString(line)
 			}
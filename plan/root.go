@@ -210,6 +210,14 @@ type ExecParams struct {
 	FS fs.FS
 
 	get func(i int) *Input
+
+	// part is the partition of the joined rows
+	// that is owned by this query when hasPart
+	// is set, and exchange identifies the execution
+	// of the ExchangeMap that assigned it; see ExchangePart
+	part     int
+	hasPart  bool
+	exchange string
 }
 
 type multiRewriter struct {
@@ -311,6 +319,9 @@ func (ep *ExecParams) clone() *ExecParams {
 		Runner:   ep.Runner,
 		FS:       ep.FS,
 		get:      ep.get,
+		part:     ep.part,
+		hasPart:  ep.hasPart,
+		exchange: ep.exchange,
 	}
 }
 
@@ -16,7 +16,9 @@ package plan
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/SnellerInc/sneller/ion"
 	"github.com/SnellerInc/sneller/vm"
@@ -38,8 +40,16 @@ type ExecStats struct {
 	// BytesScanned is the number
 	// of bytes scanned.
 	BytesScanned int64
+	// Ops are the statistics collected
+	// by the Instrument ops of the query
+	// (see EXPLAIN ANALYZE).
+	Ops []OpStats
 }
 
+// opsLock protects ExecStats.Ops,
+// which can't be updated atomically
+var opsLock sync.Mutex
+
 // CachedTable is an interface optionally
 // implemented by a vm.Table.
 // If a vm.Table returned by TableHandle.Open
@@ -57,6 +67,25 @@ func (e *ExecStats) atomicAdd(tmp *ExecStats) {
 	atomic.AddInt64(&e.CacheHits, tmp.CacheHits)
 	atomic.AddInt64(&e.CacheMisses, tmp.CacheMisses)
 	atomic.AddInt64(&e.BytesScanned, tmp.BytesScanned)
+	if len(tmp.Ops) > 0 {
+		e.addOps(tmp.Ops)
+	}
+}
+
+func (e *ExecStats) addOps(ops []OpStats) {
+	opsLock.Lock()
+	defer opsLock.Unlock()
+	e.Ops = append(e.Ops, ops...)
+}
+
+// setPeer sets the peer of the
+// ops executed by peer i
+func (e *ExecStats) setPeer(i int) {
+	for j := range e.Ops {
+		if e.Ops[j].Peer < 0 {
+			e.Ops[j].Peer = i
+		}
+	}
 }
 
 func (e *ExecStats) Observe(table vm.Table) {
@@ -92,6 +121,14 @@ func (e *ExecStats) Encode(dst *ion.Buffer, st *ion.Symtab) {
 		dst.BeginField(st.Intern("scanned"))
 		dst.WriteInt(e.BytesScanned)
 	}
+	if len(e.Ops) > 0 {
+		dst.BeginField(st.Intern("ops"))
+		dst.BeginList(-1)
+		for i := range e.Ops {
+			e.Ops[i].encode(dst, st)
+		}
+		dst.EndList()
+	}
 	dst.EndStruct()
 }
 
@@ -105,6 +142,15 @@ func (e *ExecStats) Decode(buf []byte, st *ion.Symtab) error {
 			e.CacheMisses, _, err = ion.ReadInt(body)
 		case "scanned":
 			e.BytesScanned, _, err = ion.ReadInt(body)
+		case "ops":
+			_, err = ion.UnpackList(body, func(body []byte) error {
+				var op OpStats
+				if err := op.decode(body, st); err != nil {
+					return err
+				}
+				e.Ops = append(e.Ops, op)
+				return nil
+			})
 		default:
 			return errUnexpectedField
 		}
@@ -118,6 +164,86 @@ func (e *ExecStats) Decode(buf []byte, st *ion.Symtab) error {
 	return nil
 }
 
+// OpStats are the statistics of one op
+// of a query plan collected by an Instrument.
+type OpStats struct {
+	// ID is the ID of the Instrument.
+	ID int
+	// Peer is the index of the peer
+	// that executed the op within the
+	// Geometry of the enclosing UnionMap,
+	// or -1 if the op was executed locally.
+	Peer int
+	// Rows and Bytes are the number of
+	// rows and the bytes of row data
+	// produced by the op.
+	Rows, Bytes int64
+	// Wall is the time elapsed until the op
+	// (and therefore its inputs) finished.
+	Wall time.Duration
+	// Busy is the time spent writing the
+	// output of the op, summed over all the
+	// threads that produced it, and Wait is
+	// the part of Busy spent in the next op.
+	//
+	// See vm.RowCounter
+	Busy, Wait time.Duration
+}
+
+func (o *OpStats) encode(dst *ion.Buffer, st *ion.Symtab) {
+	dst.BeginStruct(-1)
+	dst.BeginField(st.Intern("id"))
+	dst.WriteInt(int64(o.ID))
+	if o.Peer >= 0 {
+		dst.BeginField(st.Intern("peer"))
+		dst.WriteInt(int64(o.Peer))
+	}
+	dst.BeginField(st.Intern("rows"))
+	dst.WriteInt(o.Rows)
+	dst.BeginField(st.Intern("bytes"))
+	dst.WriteInt(o.Bytes)
+	dst.BeginField(st.Intern("wall"))
+	dst.WriteInt(int64(o.Wall))
+	dst.BeginField(st.Intern("busy"))
+	dst.WriteInt(int64(o.Busy))
+	dst.BeginField(st.Intern("wait"))
+	dst.WriteInt(int64(o.Wait))
+	dst.EndStruct()
+}
+
+func (o *OpStats) decode(buf []byte, st *ion.Symtab) error {
+	o.Peer = -1
+	_, err := ion.UnpackStruct(st, buf, func(name string, body []byte) error {
+		var err error
+		var n int64
+		switch name {
+		case "id":
+			n, _, err = ion.ReadInt(body)
+			o.ID = int(n)
+		case "peer":
+			n, _, err = ion.ReadInt(body)
+			o.Peer = int(n)
+		case "rows":
+			o.Rows, _, err = ion.ReadInt(body)
+		case "bytes":
+			o.Bytes, _, err = ion.ReadInt(body)
+		case "wall":
+			n, _, err = ion.ReadInt(body)
+			o.Wall = time.Duration(n)
+		case "busy":
+			n, _, err = ion.ReadInt(body)
+			o.Busy = time.Duration(n)
+		case "wait":
+			n, _, err = ion.ReadInt(body)
+			o.Wait = time.Duration(n)
+		default:
+			return errUnexpectedField
+		}
+		return err
+	})
+	return err
+}
+
 // static symbol table used for
 // encoding the stats structure
 // over remote transports;
@@ -132,6 +258,14 @@ func init() {
 		"hits",
 		"misses",
 		"scanned",
+		"ops",
+		"id",
+		"peer",
+		"rows",
+		"bytes",
+		"wall",
+		"busy",
+		"wait",
 	} {
 		statsSymtab.Intern(s)
 	}
@@ -84,6 +84,10 @@ func printops(dst *strings.Builder, indent int, op Op) {
 		tabline(dst, indent, l.describe())
 		return
 	}
+	if i, ok := op.(*Instrument); ok {
+		i.describe(dst, indent)
+		return
+	}
 	tabline(dst, indent, op.String())
 }
 
@@ -115,6 +119,9 @@ func (t *Tree) MaxScanned() int64 {
 					walk(s.Inner[j])
 				}
 			}
+			if h, ok := op.(*HashJoin); ok {
+				walk(h.Build)
+			}
 		}
 	}
 	walk(&t.Root)
@@ -117,6 +117,31 @@ func (u *UnionMap) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
 		return fmt.Errorf("plan.UnionMap: Geometry is nil")
 	}
 	in := src.HashSplit(len(u.Geometry.Peers))
+	return execPeers(dst, u.Geometry, ep, func(i int) *Tree {
+		if in[i] == nil {
+			return nil
+		}
+		// wrap the rest of the query in a Tree;
+		// this makes it look to the Transport
+		// like we are executing a sub-query, which
+		// is approximately true
+		return &Tree{
+			ID:     ep.Plan.ID,
+			Inputs: in[i : i+1],
+			Data:   ep.Plan.Data,
+			Root: Node{
+				Op:    u.From,
+				Input: 0,
+			},
+		}
+	})
+}
+
+// execPeers executes the sub-query returned by
+// plan(i) on each of the peers i in g and writes
+// the union of the results into dst; peers for
+// which plan returns nil are skipped
+func execPeers(dst vm.QuerySink, g *Geometry, ep *ExecParams, plan func(i int) *Tree) error {
 	w, err := dst.Open()
 	if err != nil {
 		return err
@@ -128,33 +153,23 @@ func (u *UnionMap) exec(dst vm.QuerySink, src *Input, ep *ExecParams) error {
 	// does not benefit substantially from having
 	// parallelism, so we union all the output bytes
 	// into a single thread here
-	errors := make([]error, len(in))
+	errors := make([]error, len(g.Peers))
 	var wg sync.WaitGroup
-	for i := range in {
-		if in[i] == nil {
+	for i := range g.Peers {
+		sub := plan(i)
+		if sub == nil {
 			continue
 		}
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
-			tp := u.Geometry.Peers[i]
-			// wrap the rest of the query in a Tree;
-			// this makes it look to the Transport
-			// like we are executing a sub-query, which
-			// is approximately true
+			tp := g.Peers[i]
 			subep := ep.clone()
-			subep.Plan = &Tree{
-				ID:     ep.Plan.ID,
-				Inputs: in[i : i+1],
-				Data:   ep.Plan.Data,
-				Root: Node{
-					Op:    u.From,
-					Input: 0,
-				},
-			}
+			subep.Plan = sub
 			subep.Output = s
 			// subep.get will be clobbered by Exec here:
 			errors[i] = tp.Exec(subep)
+			subep.Stats.setPeer(i)
 			ep.Stats.atomicAdd(&subep.Stats)
 		}(i)
 	}
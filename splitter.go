@@ -20,6 +20,7 @@ import (
 
 	"github.com/SnellerInc/sneller/plan"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
+	"github.com/SnellerInc/sneller/tlsconf"
 )
 
 type Splitter struct {
@@ -27,6 +28,9 @@ type Splitter struct {
 	WorkerKey tnproto.Key
 	Peers     []*net.TCPAddr
 	SelfAddr  string
+	// TLS, if non-nil, is the TLS configuration
+	// used to connect to peers.
+	TLS *tlsconf.Options
 }
 
 func (s *Splitter) Geometry() *plan.Geometry {
@@ -39,14 +43,32 @@ func (s *Splitter) Geometry() *plan.Geometry {
 
 func (s *Splitter) transport(i int) plan.Transport {
 	nodeID := s.Peers[i].String()
-	if nodeID == s.SelfAddr {
-		return &plan.LocalTransport{}
-	}
-	return &tnproto.Remote{
+	remote := &tnproto.Remote{
 		ID:      s.WorkerID,
 		Key:     s.WorkerKey,
 		Net:     "tcp",
 		Addr:    nodeID,
 		Timeout: 3 * time.Second,
+		TLS:     s.TLS,
+	}
+	if nodeID == s.SelfAddr {
+		return &selfTransport{remote: remote}
 	}
+	return remote
+}
+
+// selfTransport is the Transport for this peer:
+// it executes queries locally, but its shared form
+// is the Remote for this peer, so that a peer that
+// receives a Geometry from us (see plan.Exchange)
+// sends our share of the query back to us instead
+// of executing it locally
+type selfTransport struct {
+	plan.LocalTransport
+	remote *tnproto.Remote
+}
+
+// Shared implements plan.SharedTransport.Shared
+func (s *selfTransport) Shared() plan.Transport {
+	return s.remote
 }
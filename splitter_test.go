@@ -0,0 +1,87 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package sneller
+
+import (
+	"net"
+	"testing"
+
+	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+func TestSplitterGeometry(t *testing.T) {
+	peers := []*net.TCPAddr{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 9000},
+		{IP: net.IPv4(10, 0, 0, 2), Port: 9000},
+	}
+	s := &Splitter{
+		Peers:    peers,
+		SelfAddr: peers[1].String(),
+	}
+	g := s.Geometry()
+	if _, ok := g.Peers[0].(*tnproto.Remote); !ok {
+		t.Fatalf("peer 0: unexpected transport %T", g.Peers[0])
+	}
+	if _, ok := g.Peers[1].(*selfTransport); !ok {
+		t.Fatalf("peer 1: unexpected transport %T", g.Peers[1])
+	}
+	// the plan executed on this peer
+	// runs our share locally
+	var buf ion.Buffer
+	var st ion.Symtab
+	if err := plan.EncodeTransport(g.Peers[1], &st, &buf); err != nil {
+		t.Fatal(err)
+	}
+	d, _, err := ion.ReadDatum(&st, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp, err := plan.DecodeTransport(d); err != nil {
+		t.Fatal(err)
+	} else if _, ok := tp.(*plan.LocalTransport); !ok {
+		t.Fatalf("peer 1: decoded %T", tp)
+	}
+	// every peer should decode a shared transport
+	// that reaches the same peer, including
+	// the one that we execute locally
+	for i := range g.Peers {
+		tp := g.Peers[i]
+		if s, ok := tp.(plan.SharedTransport); ok {
+			tp = s.Shared()
+		}
+		var buf ion.Buffer
+		var st ion.Symtab
+		if err := plan.EncodeTransport(tp, &st, &buf); err != nil {
+			t.Fatal(err)
+		}
+		d, _, err := ion.ReadDatum(&st, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dt, err := plan.DecodeTransport(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := dt.(*tnproto.Remote)
+		if !ok {
+			t.Fatalf("peer %d: decoded %T", i, dt)
+		}
+		if r.Addr != peers[i].String() {
+			t.Errorf("peer %d: decoded address %s", i, r.Addr)
+		}
+	}
+}
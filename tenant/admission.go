@@ -0,0 +1,280 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+// DefaultMaxQueued is the default number
+// of queries that may wait for admission
+// (see WithAdmission).
+const DefaultMaxQueued = 256
+
+// ErrQueueFull is returned by Manager.Admit
+// when a query cannot be run immediately
+// and the admission queue is already full.
+var ErrQueueFull = errors.New("query queue is full")
+
+// Limits are the admission limits
+// of a tenant (see Manager.Admit).
+type Limits struct {
+	// MaxQueries is the maximum number of
+	// queries the tenant may run concurrently.
+	// If MaxQueries is 0, there is no limit.
+	MaxQueries int
+	// MaxBytes is the maximum total number of bytes
+	// that the queries of the tenant running concurrently
+	// may scan. A query that scans more than MaxBytes
+	// on its own is admitted only when no other query of
+	// the tenant is running. If MaxBytes is 0, there is no limit.
+	MaxBytes uint64
+	// Weight is the share of the query capacity
+	// given to the tenant relative to the other
+	// tenants when queries are queued.
+	// A Weight of 0 is equivalent to 1.
+	Weight int
+}
+
+// WithAdmission is an option that can be
+// passed to NewManager to limit the number
+// of queries admitted by Manager.Admit that
+// may run concurrently to maxRunning
+// (or no limit if maxRunning is zero)
+// and the number of queries waiting
+// for admission to maxQueued
+// (or DefaultMaxQueued if maxQueued is zero).
+func WithAdmission(maxRunning, maxQueued int) Option {
+	return func(m *Manager) {
+		m.admission.maxRunning = maxRunning
+		m.admission.maxQueued = maxQueued
+	}
+}
+
+// admission implements admission control
+// and weighted fair queueing of queries:
+// each tenant is given a virtual time that
+// advances by 1/weight with every query
+// it runs, and when capacity frees up the
+// queued query of the tenant with the
+// lowest virtual time is admitted first
+type admission struct {
+	maxRunning int
+	maxQueued  int
+
+	lock    sync.Mutex
+	tenants map[tnproto.ID]*queue
+	running int
+	queued  int
+	// vclock is the virtual time of
+	// the most recently admitted query;
+	// tenants that become active start
+	// from here so that being idle
+	// does not accumulate credit
+	vclock float64
+	seq    uint64
+}
+
+// queue is the admission
+// state of one tenant
+type queue struct {
+	limits  Limits
+	running int
+	bytes   uint64
+	vtime   float64
+	waiting []*waiter
+}
+
+type waiter struct {
+	bytes    uint64
+	seq      uint64
+	ready    chan struct{}
+	admitted bool // guarded by admission.lock
+}
+
+func (a *admission) limit() int {
+	if a.maxQueued == 0 {
+		return DefaultMaxQueued
+	}
+	return a.maxQueued
+}
+
+// fits returns whether a query scanning
+// the given number of bytes can be run
+// on behalf of q right now
+func (a *admission) fits(q *queue, bytes uint64) bool {
+	if a.maxRunning > 0 && a.running >= a.maxRunning {
+		return false
+	}
+	lim := &q.limits
+	if lim.MaxQueries > 0 && q.running >= lim.MaxQueries {
+		return false
+	}
+	if lim.MaxBytes > 0 && q.running > 0 && q.bytes+bytes > lim.MaxBytes {
+		return false
+	}
+	return true
+}
+
+func (a *admission) start(q *queue, bytes uint64) {
+	a.running++
+	q.running++
+	q.bytes += bytes
+	if q.vtime > a.vclock {
+		a.vclock = q.vtime
+	}
+	weight := q.limits.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	q.vtime += 1 / float64(weight)
+}
+
+// dispatch admits as many queued
+// queries as possible in fair order
+func (a *admission) dispatch() {
+	for a.queued > 0 {
+		var next *queue
+		for _, q := range a.tenants {
+			if len(q.waiting) == 0 || !a.fits(q, q.waiting[0].bytes) {
+				continue
+			}
+			if next == nil || q.vtime < next.vtime ||
+				(q.vtime == next.vtime && q.waiting[0].seq < next.waiting[0].seq) {
+				next = q
+			}
+		}
+		if next == nil {
+			return
+		}
+		w := next.waiting[0]
+		next.waiting = next.waiting[1:]
+		a.queued--
+		a.start(next, w.bytes)
+		w.admitted = true
+		close(w.ready)
+	}
+}
+
+// gc drops the state of an idle tenant
+func (a *admission) gc(id tnproto.ID, q *queue) {
+	if q.running == 0 && len(q.waiting) == 0 {
+		delete(a.tenants, id)
+	}
+}
+
+func (a *admission) release(id tnproto.ID, q *queue, bytes uint64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.running--
+	q.running--
+	q.bytes -= bytes
+	a.dispatch()
+	a.gc(id, q)
+}
+
+func (a *admission) acquire(ctx context.Context, id tnproto.ID, lim *Limits, bytes uint64) (func(), error) {
+	a.lock.Lock()
+	if a.tenants == nil {
+		a.tenants = make(map[tnproto.ID]*queue)
+	}
+	q := a.tenants[id]
+	if q == nil {
+		q = &queue{vtime: a.vclock}
+		a.tenants[id] = q
+	}
+	q.limits = *lim
+	var once sync.Once
+	release := func() {
+		once.Do(func() { a.release(id, q, bytes) })
+	}
+	if len(q.waiting) == 0 && a.fits(q, bytes) {
+		a.start(q, bytes)
+		a.lock.Unlock()
+		return release, nil
+	}
+	if a.queued >= a.limit() {
+		a.gc(id, q)
+		a.lock.Unlock()
+		return nil, ErrQueueFull
+	}
+	if len(q.waiting) == 0 && q.vtime < a.vclock {
+		q.vtime = a.vclock
+	}
+	a.seq++
+	w := &waiter{bytes: bytes, seq: a.seq, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	a.queued++
+	a.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+	a.lock.Lock()
+	if w.admitted {
+		// raced with dispatch
+		a.lock.Unlock()
+		release()
+		return nil, ctx.Err()
+	}
+	for i := range q.waiting {
+		if q.waiting[i] == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			break
+		}
+	}
+	a.queued--
+	// the queries behind this one
+	// may be able to run now
+	a.dispatch()
+	a.gc(id, q)
+	a.lock.Unlock()
+	return nil, ctx.Err()
+}
+
+func (a *admission) stats() (running, queued int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.running, a.queued
+}
+
+// Admit waits until a query of the tenant
+// with the provided ID that is expected to
+// scan the given number of bytes can be run
+// within the tenant's limits and the query
+// capacity of the manager (see WithAdmission).
+// Queries that cannot run immediately are
+// queued, and the queued queries are admitted
+// in weighted fair order across tenants.
+//
+// Admit returns ErrQueueFull if the query would
+// have to wait and the queue is full, or the error
+// from ctx if ctx is canceled before the query
+// is admitted. Otherwise the caller must call
+// the returned function once the query has
+// finished executing.
+func (m *Manager) Admit(ctx context.Context, id tnproto.ID, lim *Limits, bytes uint64) (func(), error) {
+	release, err := m.admission.acquire(ctx, id, lim, bytes)
+	if errors.Is(err, ErrQueueFull) {
+		m.metrics.rejected.Inc()
+	}
+	return release, err
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/tenant/tnproto"
+)
+
+// admit runs Admit in the background
+// and returns a channel that yields
+// the release function once the query
+// has been admitted
+func admit(t *testing.T, m *Manager, ctx context.Context, id tnproto.ID, lim *Limits, bytes uint64) chan func() {
+	t.Helper()
+	c := make(chan func(), 1)
+	go func() {
+		release, err := m.Admit(ctx, id, lim, bytes)
+		if err != nil {
+			close(c)
+			return
+		}
+		c <- release
+	}()
+	return c
+}
+
+func waitQueued(t *testing.T, m *Manager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, queued := m.admission.stats()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued queries; got %d", n, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func mustAdmit(t *testing.T, c chan func()) func() {
+	t.Helper()
+	select {
+	case release, ok := <-c:
+		if !ok {
+			t.Fatal("query not admitted")
+		}
+		return release
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for admission")
+	}
+	return nil
+}
+
+func TestAdmitTenantLimits(t *testing.T) {
+	m := NewManager([]string{"/bin/false"})
+	ctx := context.Background()
+	id := tnproto.ID{1}
+	lim := &Limits{MaxQueries: 2, MaxBytes: 100}
+
+	r0 := mustAdmit(t, admit(t, m, ctx, id, lim, 10))
+	r1 := mustAdmit(t, admit(t, m, ctx, id, lim, 10))
+	// too many queries
+	c2 := admit(t, m, ctx, id, lim, 10)
+	waitQueued(t, m, 1)
+	// other tenants aren't affected
+	other := mustAdmit(t, admit(t, m, ctx, tnproto.ID{2}, lim, 1000))
+	other()
+
+	r0()
+	r2 := mustAdmit(t, c2)
+	r1()
+	// too many bytes in flight
+	c3 := admit(t, m, ctx, id, lim, 95)
+	waitQueued(t, m, 1)
+	r2()
+	// a query over the limit runs alone
+	r3 := mustAdmit(t, c3)
+	r3()
+	r4 := mustAdmit(t, admit(t, m, ctx, id, lim, 1000))
+	c5 := admit(t, m, ctx, id, lim, 1)
+	waitQueued(t, m, 1)
+	r4()
+	r4() // releasing twice is harmless
+	mustAdmit(t, c5)()
+
+	if running, queued := m.admission.stats(); running != 0 || queued != 0 {
+		t.Errorf("%d running, %d queued queries left", running, queued)
+	}
+	if len(m.admission.tenants) != 0 {
+		t.Errorf("%d tenants left", len(m.admission.tenants))
+	}
+}
+
+func TestAdmitQueueFull(t *testing.T) {
+	m := NewManager([]string{"/bin/false"}, WithAdmission(1, 2))
+	ctx := context.Background()
+	lim := &Limits{}
+
+	release := mustAdmit(t, admit(t, m, ctx, tnproto.ID{1}, lim, 0))
+	c0 := admit(t, m, ctx, tnproto.ID{2}, lim, 0)
+	cctx, cancel := context.WithCancel(ctx)
+	c1 := admit(t, m, cctx, tnproto.ID{3}, lim, 0)
+	waitQueued(t, m, 2)
+	_, err := m.Admit(ctx, tnproto.ID{4}, lim, 0)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull; got %v", err)
+	}
+	if n := m.metrics.rejected.Value(); n != 1 {
+		t.Errorf("%g queries rejected", n)
+	}
+	// canceling a queued query
+	// removes it from the queue
+	cancel()
+	if _, ok := <-c1; ok {
+		t.Fatal("canceled query admitted")
+	}
+	waitQueued(t, m, 1)
+	release()
+	mustAdmit(t, c0)()
+}
+
+func TestAdmitFair(t *testing.T) {
+	m := NewManager([]string{"/bin/false"}, WithAdmission(1, 100))
+	ctx := context.Background()
+	busy, quiet := tnproto.ID{1}, tnproto.ID{2}
+	lim := &Limits{}
+	busyLim := &Limits{Weight: 2}
+
+	type admitted struct {
+		tenant  byte
+		release func()
+	}
+	done := make(chan admitted)
+	enqueue := func(id tnproto.ID, lim *Limits, tenant byte) {
+		go func() {
+			release, err := m.Admit(ctx, id, lim, 0)
+			if err != nil {
+				panic(err)
+			}
+			done <- admitted{tenant, release}
+		}()
+	}
+
+	release := mustAdmit(t, admit(t, m, ctx, busy, busyLim, 0))
+	// the busy tenant queues lots of queries
+	// before the quiet tenant queues a few
+	for i := 0; i < 6; i++ {
+		enqueue(busy, busyLim, 'b')
+		waitQueued(t, m, i+1)
+	}
+	for i := 0; i < 3; i++ {
+		enqueue(quiet, lim, 'q')
+		waitQueued(t, m, 7+i)
+	}
+	// run the queries one at a time and
+	// record the tenant of each query
+	var order []byte
+	for i := 0; i < 9; i++ {
+		release()
+		a := <-done
+		order = append(order, a.tenant)
+		release = a.release
+		select {
+		case <-done:
+			t.Fatal("more than one query admitted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	release()
+	// with twice the weight, the busy tenant
+	// runs two queries for each one of the
+	// quiet tenant while both have queries queued
+	if got, want := string(order), "qbbqbbqbb"; got != want {
+		t.Errorf("got order %s, want %s", got, want)
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"sync"
 	"sync/atomic"
 
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/vm"
 )
 
@@ -76,7 +77,7 @@ type Cache struct {
 	rocache map[string]*mapping
 
 	// statistics; accessed atomically
-	hits, misses, failures int64
+	hits, misses, failures, fills int64
 }
 
 type Logger interface {
@@ -129,6 +130,32 @@ func (c *Cache) Failures() int64 {
 	return atomic.LoadInt64(&c.failures)
 }
 
+// Fills returns the number of times
+// the cache began filling a new entry.
+func (c *Cache) Fills() int64 {
+	return atomic.LoadInt64(&c.fills)
+}
+
+// Register registers the cache statistics
+// with the metrics registry r.
+func (c *Cache) Register(r *metrics.Registry) {
+	r.CounterFunc("sneller_dcache_hits", "Number of cache hits.", func() float64 {
+		return float64(c.Hits())
+	})
+	r.CounterFunc("sneller_dcache_misses", "Number of cache misses.", func() float64 {
+		return float64(c.Misses())
+	})
+	r.CounterFunc("sneller_dcache_fills", "Number of cache entries filled.", func() float64 {
+		return float64(c.Fills())
+	})
+	r.CounterFunc("sneller_dcache_failures", "Number of cache entries that could not be allocated.", func() float64 {
+		return float64(c.Failures())
+	})
+	r.GaugeFunc("sneller_dcache_live_mappings", "Number of cache entries mapped for reading.", func() float64 {
+		return float64(c.LiveHits())
+	})
+}
+
 type mapping struct {
 	file       *os.File // file handle
 	id, target string   // actual filepath of populated entry
@@ -301,6 +328,7 @@ func (c *Cache) mmap(s Segment, flags Flag) *mapping {
 		return nil
 	}
 	c.onFill()
+	atomic.AddInt64(&c.fills, 1)
 	// we are creating a new entry
 	f, err = os.Create(target + ".tmp")
 	if errors.Is(err, fs.ErrNotExist) &&
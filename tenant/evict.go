@@ -171,6 +171,8 @@ func (m *Manager) evict(t *totalHeap, size int64) {
 			if os.Remove(f.path) == nil {
 				t.files++         // track files evicted
 				t.bytes += f.size // track bytes evicted
+				m.metrics.evictedFiles.Inc()
+				m.metrics.evictedBytes.Add(float64(f.size))
 				size -= f.size
 				if f.atime > t.maxatime {
 					t.maxatime = f.atime
@@ -185,6 +187,13 @@ func (m *Manager) evict(t *totalHeap, size int64) {
 	}
 }
 
+// SpillDir is the name of the directory
+// in the cache directory of a tenant process
+// to which queries spill their state when
+// they run out of memory; cache eviction
+// doesn't touch it
+const SpillDir = "spill"
+
 // walk the tree and put eviction candidates
 // in t.sorted in decreasing order of eviction quality
 func (m *Manager) fill(t *totalHeap, wantsize int64) {
@@ -200,6 +209,10 @@ func (m *Manager) fill(t *totalHeap, wantsize int64) {
 		if err != nil {
 			return err
 		}
+		if d.IsDir() && d.Name() == SpillDir {
+			// live query state, not cached data
+			return fs.SkipDir
+		}
 		if !d.Type().IsRegular() {
 			// don't care about directories,
 			// links, etc.
@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -59,10 +60,14 @@ func TestEvict(t *testing.T) {
 		{"0/04", 1400, base + 500},
 		{"1/05", 100, base - 200},
 		{"1/eph:06", 100, base - int64(7*time.Second)},
+		// spill files are never evicted,
+		// no matter how old they are
+		{"1/spill/07", 0, base - int64(2*time.Hour)},
 	}
 	// the end state should just be the start state
-	// minus the oldest file (which is listed first)
-	end := begin[2 : len(begin)-1]
+	// minus the oldest files (which are listed first)
+	// and the ephemeral file
+	end := append(slices.Clone(begin[2:6]), begin[7])
 
 	myUsage := func(dir string) (int64, int64) {
 		sum := int64(0)
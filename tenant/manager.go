@@ -68,6 +68,7 @@ package tenant
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -84,6 +85,7 @@ import (
 
 	"github.com/SnellerInc/sneller/cgroup"
 	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
 	"github.com/SnellerInc/sneller/usock"
@@ -187,6 +189,52 @@ type Manager struct {
 
 	// warn about being unable to sandbox exactly once
 	warnOnce sync.Once
+
+	admission admission
+
+	metrics managerMetrics
+}
+
+// managerMetrics are the metrics
+// maintained by the Manager itself
+// (see also: Manager.Metrics)
+type managerMetrics struct {
+	registry     metrics.Registry
+	launched     *metrics.Counter
+	evictedFiles *metrics.Counter
+	evictedBytes *metrics.Counter
+	remoteErrors *metrics.CounterVec
+	rejected     *metrics.Counter
+}
+
+func (m *Manager) initMetrics() {
+	r := &m.metrics.registry
+	r.GaugeFunc("sneller_tenant_processes", "Number of live tenant processes.", func() float64 {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		return float64(len(m.live))
+	})
+	r.GaugeFunc("sneller_dcache_used_bytes", "Number of bytes used on the cache file system.", func() float64 {
+		used, _ := usage(m.CacheDir)
+		return float64(used)
+	})
+	r.GaugeFunc("sneller_dcache_capacity_bytes", "Size of the cache file system in bytes.", func() float64 {
+		_, total := usage(m.CacheDir)
+		return float64(total)
+	})
+	m.metrics.launched = r.Counter("sneller_tenant_launches", "Number of tenant processes launched.")
+	m.metrics.evictedFiles = r.Counter("sneller_dcache_evictions", "Number of files evicted from the cache.")
+	m.metrics.evictedBytes = r.Counter("sneller_dcache_evicted_bytes", "Number of bytes evicted from the cache.")
+	m.metrics.remoteErrors = r.CounterVec("sneller_peer_errors", "Number of errors executing queries on remote tenants.", "op")
+	r.GaugeFunc("sneller_queries_running", "Number of admitted queries that are running.", func() float64 {
+		running, _ := m.admission.stats()
+		return float64(running)
+	})
+	r.GaugeFunc("sneller_queries_queued", "Number of queries waiting for admission.", func() float64 {
+		_, queued := m.admission.stats()
+		return float64(queued)
+	})
+	m.metrics.rejected = r.Counter("sneller_queries_rejected", "Number of queries rejected because the admission queue was full.")
 }
 
 // Option is an optional argument
@@ -255,6 +303,13 @@ func WithTenantEnv(fn func(string, tnproto.ID) []string) Option {
 // be passed to NewManager to indicate
 // the listener on which to serve
 // remote proxy exec messages.
+//
+// The listener may be a TLS listener
+// (see tls.NewListener), in which case
+// the connections are relayed to tenants
+// through a socket pair, since the TLS
+// state of a connection can't be passed
+// to another process.
 func WithRemote(l net.Listener) Option {
 	return func(m *Manager) {
 		m.remote = l
@@ -308,6 +363,7 @@ func NewManager(cmd []string, opt ...Option) *Manager {
 	for i := range opt {
 		opt[i](m)
 	}
+	m.initMetrics()
 	return m
 }
 
@@ -398,6 +454,55 @@ func (c *child) proxyExec(peer net.Conn) error {
 	return tnproto.ProxyExec(c.ctl, peer)
 }
 
+// proxyTLS is like proxyExec, but it relays
+// the traffic on conn through a socket pair
+// until the tenant closes its end of the pair
+// or the peer stops accepting data
+func (c *child) proxyTLS(conn *tls.Conn) error {
+	local, remote, err := usock.SocketPair()
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	err = c.proxyExec(remote)
+	remote.Close()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(local, conn)
+		local.CloseWrite()
+	}()
+	io.Copy(conn, local)
+	// unblock the goroutine above
+	conn.Close()
+	local.Close()
+	<-done
+	return nil
+}
+
+// metricsTimeout is the maximum amount of time
+// to wait for a child to report its metrics
+const metricsTimeout = time.Second
+
+func (c *child) metrics() ([]metrics.Family, error) {
+	if !c.lock() {
+		return nil, ErrOverloaded
+	}
+	defer c.unlock()
+	return tnproto.Metrics(c.ctl, metricsTimeout)
+}
+
+func (c *child) queries() ([]tnproto.QueryProgress, error) {
+	if !c.lock() {
+		return nil, ErrOverloaded
+	}
+	defer c.unlock()
+	return tnproto.Queries(c.ctl, metricsTimeout)
+}
+
 func (p *procID) cacheDir() string {
 	// use the first 16 bytes of the key as
 	// the siphash seed; use the tenant ID
@@ -688,6 +793,7 @@ func (m *Manager) get(id tnproto.ID, key tnproto.Key) (*child, error) {
 		m.live = make(map[procID]*child)
 	}
 	m.live[pid] = c
+	m.metrics.launched.Inc()
 	go m.reap(c, pid)
 	return c, nil
 }
@@ -744,6 +850,21 @@ func (m *Manager) Quit(id tnproto.ID, key tnproto.Key) bool {
 	return ok && c.proc.Signal(syscall.SIGQUIT) == nil
 }
 
+// Queries returns the progress of the queries
+// being executed by the tenant process with the
+// provided ID. Queries does not launch the tenant
+// process; if it is not running, Queries returns
+// an empty list.
+func (m *Manager) Queries(id tnproto.ID, key tnproto.Key) ([]tnproto.QueryProgress, error) {
+	m.lock.Lock()
+	c := m.live[procID{id, key}]
+	m.lock.Unlock()
+	if c == nil {
+		return nil, nil
+	}
+	return c.queries()
+}
+
 // Check checks the return status of the
 // tenant error pipe returned from Manager.Do.
 // Check blocks until the other end of the pipe
@@ -784,6 +905,7 @@ func (m *Manager) handleRemote(conn net.Conn) {
 	defer conn.Close()
 	id, key, err := tnproto.ReadHeader(conn)
 	if err != nil {
+		m.metrics.remoteErrors.With("header").Inc()
 		m.errorf("connection: %s", err)
 		return
 	}
@@ -792,15 +914,60 @@ func (m *Manager) handleRemote(conn net.Conn) {
 	}
 	c, err := m.get(id, key)
 	if err != nil {
+		m.metrics.remoteErrors.With("spawn").Inc()
 		m.errorf("couldn't spawn %x: %s", id, err)
 		return
 	}
-	err = c.proxyExec(conn)
+	if tc, ok := conn.(*tls.Conn); ok {
+		err = c.proxyTLS(tc)
+	} else {
+		err = c.proxyExec(conn)
+	}
 	if err != nil {
+		m.metrics.remoteErrors.With("proxy").Inc()
 		m.errorf("id %s: proxy-exec: %s", id, err)
 	}
 }
 
+// Metrics returns the metrics maintained by m
+// along with the metrics reported by each of
+// the live tenant processes over their control
+// sockets. The metrics of tenant processes are
+// labeled with the tenant ID.
+//
+// Tenants that are busy or fail to respond in
+// a timely manner are omitted.
+func (m *Manager) Metrics() []metrics.Family {
+	type tenant struct {
+		id string
+		c  *child
+	}
+	m.lock.Lock()
+	children := make([]tenant, 0, len(m.live))
+	for pid, c := range m.live {
+		children = append(children, tenant{id: pid.tid.String(), c: c})
+	}
+	m.lock.Unlock()
+
+	lists := make([][]metrics.Family, len(children)+1)
+	lists[0] = m.metrics.registry.Gather()
+	var wg sync.WaitGroup
+	for i := range children {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fams, err := children[i].c.metrics()
+			if err != nil {
+				m.errorf("id %s: metrics: %s", children[i].id, err)
+				return
+			}
+			lists[i+1] = metrics.WithLabel(fams, "tenant", children[i].id)
+		}(i)
+	}
+	wg.Wait()
+	return metrics.Merge(lists...)
+}
+
 // Stop performs a graceful cleanup
 // of all of the tenant manager subprocesses.
 //
@@ -31,6 +31,7 @@ import (
 
 	"github.com/SnellerInc/sneller/cgroup"
 	"github.com/SnellerInc/sneller/ion/blockfmt"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
 	"github.com/SnellerInc/sneller/tenant/dcache"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
@@ -142,6 +143,7 @@ func main() {
 	defer uc.Close()
 	env := Env{eventfd: evfd}
 	env.cache = dcache.New(cachedir, env.post)
+	env.cache.Register(metrics.Default)
 	srv := tnproto.Server{
 		Server: plan.Server{Runner: &env},
 		Logf: func(f string, args ...any) {
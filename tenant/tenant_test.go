@@ -28,6 +28,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -40,6 +41,7 @@ import (
 	"github.com/SnellerInc/sneller/ints"
 	"github.com/SnellerInc/sneller/ion"
 	"github.com/SnellerInc/sneller/ion/blockfmt"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
 	"github.com/SnellerInc/sneller/tenant/tnproto"
 	"github.com/SnellerInc/sneller/usock"
@@ -285,6 +287,8 @@ func TestExec(t *testing.T) {
 		t.Logf("got %d evictions", c)
 	}
 
+	checkMetrics(t, m, id)
+
 	// TODO: fix this
 	/*
 		// test a query that should yield
@@ -587,11 +591,20 @@ func testCancel(t *testing.T, m *Manager) {
 	id, key := randpair()
 	// this plan should loop indefinitely until
 	// it is canceled by the
-	rc, err := m.Do(id, key, mkplan(t, `SELECT * FROM HANG(parking)`), tnproto.OutputRaw, here)
+	tree := mkplan(t, `SELECT * FROM HANG(parking)`)
+	tree.ID = "hang"
+	rc, err := m.Do(id, key, tree, tnproto.OutputRaw, here)
 	here.Close()
 	if err != nil {
 		t.Fatal(err)
 	}
+	lst, err := m.Queries(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lst) != 1 || lst[0].ID != "hang" {
+		t.Errorf("unexpected running queries %+v", lst)
+	}
 	start := time.Now()
 	rc.Close()
 	// this will hang unless the remote end
@@ -724,3 +737,31 @@ func toJSON(st *ion.Symtab, d ion.Datum) string {
 	}
 	return sb.String()
 }
+
+// check that the metrics reported by the manager
+// include the metrics reported by the tenant
+func checkMetrics(t *testing.T, m *Manager, id tnproto.ID) {
+	fams := m.Metrics()
+	find := func(name string) *metrics.Family {
+		for i := range fams {
+			if fams[i].Name == name {
+				return &fams[i]
+			}
+		}
+		t.Helper()
+		t.Fatalf("metric %s not found", name)
+		return nil
+	}
+	live := find("sneller_tenant_processes")
+	if len(live.Samples) != 1 || live.Samples[0].Value != 1 {
+		t.Errorf("unexpected live processes %+v", live.Samples)
+	}
+	misses := find("sneller_dcache_misses")
+	if len(misses.Samples) != 1 {
+		t.Fatalf("unexpected cache misses %+v", misses.Samples)
+	}
+	want := []metrics.Label{{Name: "tenant", Value: id.String()}}
+	if !slices.Equal(misses.Samples[0].Labels, want) {
+		t.Errorf("got labels %v, want %v", misses.Samples[0].Labels, want)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
@@ -26,8 +27,10 @@ import (
 	"time"
 
 	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/ion"
 	"github.com/SnellerInc/sneller/ion/blockfmt"
 	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tlsconf"
 	"github.com/SnellerInc/sneller/usock"
 )
 
@@ -61,6 +64,45 @@ func TestAttach(t *testing.T) {
 	}
 }
 
+func TestRemoteEncode(t *testing.T) {
+	id, key := randpair()
+	for _, r := range []*Remote{{
+		ID:   id,
+		Key:  key,
+		Net:  "tcp",
+		Addr: "127.0.0.1:9000",
+	}, {
+		ID:   id,
+		Key:  key,
+		Net:  "tcp",
+		Addr: "127.0.0.1:9000",
+		TLS: &tlsconf.Options{
+			Cert:       "/etc/sneller/peer.pem",
+			Key:        "/etc/sneller/peer-key.pem",
+			CA:         "/etc/sneller/ca.pem",
+			ServerName: "peer.sneller.internal",
+		},
+	}} {
+		var st ion.Symtab
+		var buf ion.Buffer
+		err := plan.EncodeTransport(r, &st, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _, err := ion.ReadDatum(&st, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := plan.DecodeTransport(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, r) {
+			t.Errorf("got %#v, want %#v", out, r)
+		}
+	}
+}
+
 const largeSize = 500000
 
 var largeInput = []*plan.Input{{
@@ -20,14 +20,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http/httputil"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
 	"github.com/SnellerInc/sneller/usock"
 )
@@ -125,6 +129,15 @@ var (
 	// begun execution and error(s) will be written
 	// over the returned pipe
 	detachmsg = []byte("detach!\n")
+
+	// request for a tenant to write the current
+	// state of its metrics into the provided socket
+	metricsmsg = []byte("metrics\n")
+
+	// request for a tenant to write the progress
+	// of the queries it is executing into the
+	// provided socket
+	querymsg = []byte("queries\n")
 )
 
 // ProxyExec tells the tenant listening on the
@@ -144,6 +157,74 @@ func ProxyExec(ctl *net.UnixConn, conn net.Conn) error {
 	return err
 }
 
+// Metrics asks the tenant listening on the
+// control socket for the current state of
+// the metrics in its metrics.Default registry.
+//
+// Like ProxyExec, Metrics performs exactly one
+// Write call on ctl. The response is read from
+// a separate socket, so it does not interfere
+// with other messages on the control socket.
+func Metrics(ctl *net.UnixConn, timeout time.Duration) ([]metrics.Family, error) {
+	var fams []metrics.Family
+	err := request(ctl, metricsmsg, timeout, &fams)
+	if err != nil {
+		return nil, fmt.Errorf("in Metrics: %w", err)
+	}
+	return fams, nil
+}
+
+// QueryProgress describes a query that
+// is being executed by a tenant.
+type QueryProgress struct {
+	// ID is the ID of the query plan
+	// (see plan.Tree.ID).
+	ID string `json:"id"`
+	// Stats are the statistics
+	// accumulated so far.
+	BytesScanned int64 `json:"bytes_scanned"`
+	CacheHits    int64 `json:"cache_hits"`
+	CacheMisses  int64 `json:"cache_misses"`
+}
+
+// Queries asks the tenant listening on the
+// control socket for the progress of the queries
+// it is currently executing on behalf of DirectExec
+// requests.
+//
+// Like Metrics, Queries performs exactly one
+// Write call on ctl.
+func Queries(ctl *net.UnixConn, timeout time.Duration) ([]QueryProgress, error) {
+	var lst []QueryProgress
+	err := request(ctl, querymsg, timeout, &lst)
+	if err != nil {
+		return nil, fmt.Errorf("in Queries: %w", err)
+	}
+	return lst, nil
+}
+
+// request sends msg over ctl along with
+// one end of a new socket pair and decodes
+// the JSON response from the other end into dst
+func request(ctl *net.UnixConn, msg []byte, timeout time.Duration, dst any) error {
+	local, remote, err := usock.SocketPair()
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	_, err = usock.WriteWithConn(ctl, msg, remote)
+	remote.Close()
+	if err != nil {
+		return fmt.Errorf("usock.WriteWithConn: %w", err)
+	}
+	local.SetReadDeadline(time.Now().Add(timeout))
+	err = json.NewDecoder(local).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
 // intermediate serialization state
 // for sending DirectExec messages
 // (sometimes the plan.Tree contains
@@ -298,9 +379,13 @@ func (b *Buffer) DirectExec(ctl *net.UnixConn, conn net.Conn) (io.ReadCloser, er
 type Server struct {
 	plan.Server
 	Logf func(f string, args ...any)
+
+	lock    sync.Mutex
+	running map[*plan.ExecParams]struct{}
 }
 
-// Serve responds to ProxyExec and DirectExec requests
+// Serve responds to ProxyExec, DirectExec, Metrics
+// and Queries requests
 // over the given control socket.
 func (s *Server) Serve(ctl *net.UnixConn) error {
 	var msgbuf [8]byte
@@ -329,6 +414,10 @@ func (s *Server) Serve(ctl *net.UnixConn) error {
 		if bytes.Equal(msgbuf[:], proxymsg) {
 			// proxy request
 			go s.serveProxy(conn)
+		} else if bytes.Equal(msgbuf[:], metricsmsg) {
+			go s.serveJSON(conn, metrics.Default.Gather())
+		} else if bytes.Equal(msgbuf[:], querymsg) {
+			go s.serveJSON(conn, s.progress())
 		} else if bytes.Equal(msgbuf[:3], directmsg[:3]) {
 			// need to read the plan
 			// and then execute it directly
@@ -368,7 +457,11 @@ func (s *Server) Serve(ctl *net.UnixConn) error {
 					conn.Close()
 					return err
 				}
-				go s.serveDirect(t, ofmt.writer(conn), errorWriter)
+				// register the query before handling
+				// the next control message so that
+				// it is visible to Queries immediately
+				ep := s.register(t)
+				go s.serveDirect(ep, ofmt.writer(conn), errorWriter)
 			}
 		} else {
 			if conn != nil {
@@ -387,6 +480,49 @@ func (s *Server) serveProxy(conn net.Conn) {
 	}
 }
 
+func (s *Server) serveJSON(conn net.Conn, v any) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	err := json.NewEncoder(conn).Encode(v)
+	if err != nil && s.Logf != nil {
+		s.Logf("serve %T: %s", v, err)
+	}
+}
+
+func (s *Server) register(t *plan.Tree) *plan.ExecParams {
+	ep := &plan.ExecParams{Plan: t}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.running == nil {
+		s.running = make(map[*plan.ExecParams]struct{})
+	}
+	s.running[ep] = struct{}{}
+	return ep
+}
+
+func (s *Server) unregister(ep *plan.ExecParams) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.running, ep)
+}
+
+// progress returns the progress of
+// the queries that are running
+func (s *Server) progress() []QueryProgress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lst := make([]QueryProgress, 0, len(s.running))
+	for ep := range s.running {
+		lst = append(lst, QueryProgress{
+			ID:           ep.Plan.ID,
+			BytesScanned: atomic.LoadInt64(&ep.Stats.BytesScanned),
+			CacheHits:    atomic.LoadInt64(&ep.Stats.CacheHits),
+			CacheMisses:  atomic.LoadInt64(&ep.Stats.CacheMisses),
+		})
+	}
+	return lst
+}
+
 // pipectx returns a context.Context that is canceled
 // when the pipe is closed
 func pipectx(errpipe net.Conn) context.Context {
@@ -426,7 +562,8 @@ func sendError(conn io.WriteCloser, err error) {
 	conn.Write(buf.Bytes())
 }
 
-func (s *Server) serveDirect(t *plan.Tree, conn io.WriteCloser, errpipe net.Conn) {
+func (s *Server) serveDirect(ep *plan.ExecParams, conn io.WriteCloser, errpipe net.Conn) {
+	defer s.unregister(ep)
 	defer errpipe.Close() // cancels ctx
 	ctx := pipectx(errpipe)
 
@@ -446,13 +583,11 @@ func (s *Server) serveDirect(t *plan.Tree, conn io.WriteCloser, errpipe net.Conn
 			panic(e)
 		}
 	}()
+	t := ep.Plan
 	pl := plan.LocalTransport{}
-	ep := plan.ExecParams{
-		Plan:    t,
-		Output:  conn,
-		Context: ctx,
-		Runner:  s.Runner,
-	}
+	ep.Output = conn
+	ep.Context = ctx
+	ep.Runner = s.Runner
 	if s.InitFS != nil && !t.Data.IsEmpty() {
 		fs, err := s.InitFS(t.Data)
 		if err != nil {
@@ -462,7 +597,7 @@ func (s *Server) serveDirect(t *plan.Tree, conn io.WriteCloser, errpipe net.Conn
 		}
 		ep.FS = fs
 	}
-	err := pl.Exec(&ep)
+	err := pl.Exec(ep)
 	if err != nil {
 		sendError(conn, err)
 	}
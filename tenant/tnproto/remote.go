@@ -15,13 +15,17 @@
 package tnproto
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/SnellerInc/sneller/ion"
+	"github.com/SnellerInc/sneller/metrics"
 	"github.com/SnellerInc/sneller/plan"
+	"github.com/SnellerInc/sneller/tlsconf"
 )
 
 func init() {
@@ -58,6 +62,14 @@ type Remote struct {
 	// of dialing (like DNS resolution)
 	// are part of the timeout window.
 	Timeout time.Duration
+
+	// TLS, if non-nil, determines the files
+	// of the TLS configuration used for the
+	// connection. (The files are read by the
+	// process that executes the query, so they
+	// must be visible to sandboxed tenant processes;
+	// see tenant.Manager.Sandbox.)
+	TLS *tlsconf.Options
 }
 
 func (r *Remote) SetField(f ion.Field) error {
@@ -83,6 +95,24 @@ func (r *Remote) SetField(f ion.Field) error {
 		if err == nil && copy(r.Key[:], buf) != len(r.Key[:]) {
 			err = fmt.Errorf("decoding tnproto.Remote: tenant key should not be %d bytes", len(buf))
 		}
+	case "tls":
+		r.TLS = new(tlsconf.Options)
+		err = f.UnpackStruct(func(f ion.Field) error {
+			var err error
+			switch f.Label {
+			case "cert":
+				r.TLS.Cert, err = f.String()
+			case "key":
+				r.TLS.Key, err = f.String()
+			case "ca":
+				r.TLS.CA, err = f.String()
+			case "server_name":
+				r.TLS.ServerName, err = f.String()
+			default:
+				return fmt.Errorf("decoding tnproto.Remote: unknown TLS field %q", f.Label)
+			}
+			return err
+		})
 	default:
 		return fmt.Errorf("decoding tnproto.Remote: unknown field %q", f.Label)
 	}
@@ -102,9 +132,49 @@ func (r *Remote) Encode(dst *ion.Buffer, st *ion.Symtab) {
 	dst.WriteBlob(r.ID[:])
 	dst.BeginField(st.Intern("key"))
 	dst.WriteBlob(r.Key[:])
+	if r.TLS != nil {
+		dst.BeginField(st.Intern("tls"))
+		dst.BeginStruct(-1)
+		dst.BeginField(st.Intern("cert"))
+		dst.WriteString(r.TLS.Cert)
+		dst.BeginField(st.Intern("key"))
+		dst.WriteString(r.TLS.Key)
+		dst.BeginField(st.Intern("ca"))
+		dst.WriteString(r.TLS.CA)
+		dst.BeginField(st.Intern("server_name"))
+		dst.WriteString(r.TLS.ServerName)
+		dst.EndStruct()
+	}
 	dst.EndStruct()
 }
 
+// Dial dials the address given by r.Net and r.Addr,
+// and performs the TLS handshake if r.TLS is set.
+func (r *Remote) Dial(ctx context.Context) (net.Conn, error) {
+	dl := net.Dialer{Timeout: r.Timeout}
+	conn, err := dl.DialContext(ctx, r.Net, r.Addr)
+	if err != nil || r.TLS == nil {
+		return conn, err
+	}
+	conf, err := tlsconf.Cached(r.TLS)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc := tls.Client(conn, conf.Client(r.Addr))
+	err = tc.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// peerErrors counts the errors encountered
+// while executing queries on remote tenants
+var peerErrors = metrics.Default.CounterVec("sneller_peer_errors",
+	"Number of errors executing queries on remote tenants.", "op")
+
 var clientPool = sync.Pool{
 	New: func() interface{} {
 		return &plan.Client{}
@@ -113,21 +183,22 @@ var clientPool = sync.Pool{
 
 // Exec implements plan.Transport.Exec
 // by dialing the address given by r.Net and r.Addr
-// and sending it an Attach message, followed
+// (see Dial) and sending it an Attach message, followed
 // by a single query execution request with
 // plan.Client.Exec.
 //
 // See also: Attach
 func (r *Remote) Exec(ep *plan.ExecParams) error {
-	dl := net.Dialer{Timeout: r.Timeout}
-	conn, err := dl.DialContext(ep.Context, r.Net, r.Addr)
+	conn, err := r.Dial(ep.Context)
 	if err != nil {
+		peerErrors.With("dial").Inc()
 		return err
 	}
 	// tell the tenant manager to attach us
 	// to the right tenant instance
 	err = Attach(conn, r.ID, r.Key)
 	if err != nil {
+		peerErrors.With("attach").Inc()
 		conn.Close()
 		return err
 	}
@@ -140,5 +211,9 @@ func (r *Remote) Exec(ep *plan.ExecParams) error {
 		cl.Pipe = nil
 		clientPool.Put(cl)
 	}()
-	return cl.Exec(ep)
+	err = cl.Exec(ep)
+	if err != nil && ep.Context.Err() == nil {
+		peerErrors.With("exec").Inc()
+	}
+	return err
 }
@@ -0,0 +1,227 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package tlsconf builds TLS configurations
+// from PEM-encoded certificate files and
+// reloads the files when they change.
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options are the paths of the
+// PEM-encoded files of a TLS configuration.
+type Options struct {
+	// Cert and Key are the paths of the
+	// certificate (chain) and its private key.
+	// Servers require a certificate; clients
+	// present it to servers that ask for one.
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// CA is the path of the CA certificates used
+	// to verify the certificate of the other side.
+	// Servers require clients to present a certificate
+	// signed by one of the CAs (mutual TLS) if CA is set.
+	// Clients use the system CAs if CA is empty.
+	CA string `json:"ca,omitempty"`
+	// ServerName, if set, is the name that
+	// server certificates are verified against
+	// instead of the host of the address dialed.
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// reloadInterval is the minimum amount of
+// time between checks for changed files
+var reloadInterval = time.Second
+
+// stamp identifies the version of a file
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// Config is a TLS configuration
+// loaded from the files given by Options.
+// The files are reloaded when they change,
+// so certificates can be rotated without
+// restarting the process.
+type Config struct {
+	opts Options
+
+	lock    sync.Mutex
+	checked time.Time
+	stamps  [3]stamp // cert, key, ca
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+// New loads the files given by opts.
+func New(opts *Options) (*Config, error) {
+	if (opts.Cert == "") != (opts.Key == "") {
+		return nil, errors.New("tlsconf: both a certificate and a key are required")
+	}
+	c := &Config{opts: *opts}
+	stamps, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+	err = c.load(stamps)
+	if err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+func (c *Config) stat() ([3]stamp, error) {
+	var ret [3]stamp
+	for i, name := range []string{c.opts.Cert, c.opts.Key, c.opts.CA} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return ret, fmt.Errorf("tlsconf: %w", err)
+		}
+		ret[i] = stamp{mod: info.ModTime(), size: info.Size()}
+	}
+	return ret, nil
+}
+
+func (c *Config) load(stamps [3]stamp) error {
+	var cert *tls.Certificate
+	if c.opts.Cert != "" {
+		kp, err := tls.LoadX509KeyPair(c.opts.Cert, c.opts.Key)
+		if err != nil {
+			return fmt.Errorf("tlsconf: loading %s: %w", c.opts.Cert, err)
+		}
+		cert = &kp
+	}
+	var pool *x509.CertPool
+	if c.opts.CA != "" {
+		buf, err := os.ReadFile(c.opts.CA)
+		if err != nil {
+			return fmt.Errorf("tlsconf: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("tlsconf: no certificates in %s", c.opts.CA)
+		}
+	}
+	c.cert, c.pool, c.stamps = cert, pool, stamps
+	return nil
+}
+
+// current returns the certificate and the
+// CA pool, reloading them if the files changed
+func (c *Config) current() (*tls.Certificate, *x509.CertPool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Since(c.checked) < reloadInterval {
+		return c.cert, c.pool
+	}
+	c.checked = time.Now()
+	stamps, err := c.stat()
+	if err == nil && stamps != c.stamps {
+		// if the files can't be loaded (for example,
+		// because they are being replaced), then keep
+		// using the old ones and try again later
+		if c.load(stamps) != nil {
+			c.checked = time.Time{}
+		}
+	}
+	return c.cert, c.pool
+}
+
+// Server returns the configuration
+// of a TLS server. The configuration
+// uses the current files for every
+// new connection.
+func (c *Config) Server() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			if cert == nil {
+				return nil, errors.New("tlsconf: no server certificate")
+			}
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				conf.ClientCAs = pool
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return conf, nil
+		},
+	}
+}
+
+// Client returns the configuration of
+// a TLS client connecting to addr
+// (a host name or a host:port pair).
+func (c *Config) Client(addr string) *tls.Config {
+	cert, pool := c.current()
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		ServerName: c.opts.ServerName,
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			conf.ServerName = host
+		}
+	}
+	if cert != nil {
+		conf.Certificates = []tls.Certificate{*cert}
+	}
+	return conf
+}
+
+var (
+	cacheLock sync.Mutex
+	cache     map[Options]*Config
+)
+
+// Cached is like New, except that it
+// returns the same Config for the same
+// Options, so that processes that only
+// know the Options don't need to load
+// the files for every connection.
+func Cached(opts *Options) (*Config, error) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if c := cache[*opts]; c != nil {
+		return c, nil
+	}
+	c, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cache == nil {
+		cache = make(map[Options]*Config)
+	}
+	cache[*opts] = c
+	return c, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package tlsconf_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SnellerInc/sneller/tlsconf"
+	"github.com/SnellerInc/sneller/tlsconf/tlstest"
+)
+
+// serve accepts TLS connections on a new
+// listener and writes "ok" to each of them
+func newCA(t *testing.T) *tlstest.CA {
+	ca, err := tlstest.NewCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func issue(t *testing.T, ca *tlstest.CA, name string, hosts ...string) *tlsconf.Options {
+	opts, err := ca.Issue(name, hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func serve(t *testing.T, opts *tlsconf.Options) net.Listener {
+	conf, err := tlsconf.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	tl := tls.NewListener(l, conf.Server())
+	go func() {
+		for {
+			conn, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, "ok")
+			}()
+		}
+	}()
+	return l
+}
+
+// dial connects to l and returns the serial
+// number of the certificate of the server
+func dial(t *testing.T, l net.Listener, opts *tlsconf.Options) (int64, error) {
+	conf, err := tlsconf.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	conn, err := tls.Dial("tcp", addr, conf.Client(addr))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		return 0, err
+	}
+	if string(buf) != "ok" {
+		t.Fatalf("read %q", buf)
+	}
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t)
+	l := serve(t, issue(t, ca, "server", "127.0.0.1"))
+
+	if _, err := dial(t, l, issue(t, ca, "client")); err != nil {
+		t.Fatal(err)
+	}
+	// no client certificate
+	if _, err := dial(t, l, &tlsconf.Options{CA: ca.Path}); err == nil {
+		t.Error("connected without a client certificate")
+	}
+	// client certificate from a different CA
+	other := issue(t, newCA(t), "client")
+	other.CA = ca.Path
+	if _, err := dial(t, l, other); err == nil {
+		t.Error("connected with a certificate from another CA")
+	}
+	// server certificate for a different address
+	client := issue(t, ca, "client2")
+	client.ServerName = "example.com"
+	if _, err := dial(t, l, client); err == nil {
+		t.Error("connected to a server with the wrong name")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca := newCA(t)
+	server := issue(t, ca, "server", "127.0.0.1")
+	l := serve(t, server)
+	client := issue(t, ca, "client")
+
+	first, err := dial(t, l, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the server certificate
+	// (and make sure the modification
+	// time changes, regardless of the
+	// resolution of the file system clock)
+	issue(t, ca, "server", "127.0.0.1")
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{server.Cert, server.Key} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files are checked for changes at most once a second
+	time.Sleep(1100 * time.Millisecond)
+	second, err := dial(t, l, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("certificate %d was not reloaded", first)
+	}
+}
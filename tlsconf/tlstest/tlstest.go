@@ -0,0 +1,142 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package tlstest generates self-signed
+// certificate authorities and certificates
+// for testing TLS connections.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/SnellerInc/sneller/tlsconf"
+)
+
+// CA is a self-signed certificate authority.
+type CA struct {
+	// Path is the path of the
+	// PEM-encoded CA certificate.
+	Path string
+
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var serial int64
+
+func template(name string) *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+	}
+}
+
+func writePEM(name, typ string, der []byte) error {
+	buf := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	return os.WriteFile(name, buf, 0600)
+}
+
+// NewCA generates a new CA and writes
+// its certificate to dir/ca.pem.
+// The certificates issued by the CA
+// are written to dir as well.
+func NewCA(dir string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := template("test CA")
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	ca := &CA{
+		Path: filepath.Join(dir, "ca.pem"),
+		dir:  dir,
+		cert: cert,
+		key:  key,
+	}
+	err = writePEM(ca.Path, "CERTIFICATE", der)
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// Issue generates a certificate signed by ca
+// for the given host names and IP addresses
+// (usable by both clients and servers) and writes
+// it and its key to name.pem and name-key.pem.
+// The returned Options use the certificate and
+// the certificate of the CA.
+func (ca *CA) Issue(name string, hosts ...string) (*tlsconf.Options, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := template(name)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	opts := &tlsconf.Options{
+		Cert: filepath.Join(ca.dir, name+".pem"),
+		Key:  filepath.Join(ca.dir, name+"-key.pem"),
+		CA:   ca.Path,
+	}
+	err = writePEM(opts.Key, "EC PRIVATE KEY", kder)
+	if err != nil {
+		return nil, err
+	}
+	err = writePEM(opts.Cert, "CERTIFICATE", der)
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package sneller
+
+import (
+	"fmt"
+
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/expr/partiql"
+)
+
+// ParseView parses and checks the query text
+// of a view in the database dbname (see db.View).
+//
+// Table references in the view without an explicit
+// database are qualified with dbname, so the view
+// produces the same rows regardless of the default
+// database of the query that references it.
+func ParseView(dbname, text string) (*expr.Query, error) {
+	q, err := partiql.Parse([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	if q.Explain != expr.ExplainNone {
+		return nil, fmt.Errorf("cannot use EXPLAIN in a view")
+	}
+	if q.Into != nil {
+		return nil, fmt.Errorf("cannot use INTO in a view")
+	}
+	if _, ok := q.Body.(*expr.Select); !ok {
+		return nil, fmt.Errorf("a view must be a SELECT query")
+	}
+	err = q.Check()
+	if err != nil {
+		return nil, err
+	}
+	qualify := &qualifier{db: dbname}
+	for i := range q.With {
+		qualify.with = q.With[:i]
+		q.With[i].As = expr.Rewrite(qualify, q.With[i].As).(*expr.Select)
+	}
+	qualify.with = q.With
+	q.Body = expr.Rewrite(qualify, q.Body)
+	return q, nil
+}
+
+// qualifier qualifies table references
+// that aren't references to CTEs with db
+type qualifier struct {
+	db   string
+	with []expr.CTE
+}
+
+func (q *qualifier) Walk(e expr.Node) expr.Rewriter { return q }
+
+func (q *qualifier) Rewrite(e expr.Node) expr.Node {
+	var bind *expr.Binding
+	switch e := e.(type) {
+	case *expr.Table:
+		bind = &e.Binding
+	case *expr.Join:
+		bind = &e.Right
+	default:
+		return e
+	}
+	switch v := bind.Expr.(type) {
+	case expr.Ident:
+		bind.Expr = q.qualify(v)
+	case *expr.Appended:
+		for i := range v.Values {
+			if id, ok := v.Values[i].(expr.Ident); ok {
+				v.Values[i] = q.qualify(id)
+			}
+		}
+	}
+	return e
+}
+
+func (q *qualifier) qualify(id expr.Ident) expr.Node {
+	for i := range q.with {
+		if q.with[i].Table == string(id) {
+			return id
+		}
+	}
+	return &expr.Dot{Inner: expr.Ident(q.db), Field: string(id)}
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package sneller
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/SnellerInc/sneller/db"
+)
+
+func TestParseView(t *testing.T) {
+	tcs := []struct {
+		text, want string
+	}{
+		{
+			"SELECT x FROM t WHERE y > 0",
+			"SELECT x FROM db.t WHERE y > 0",
+		},
+		{
+			"SELECT a.x FROM other.t AS a JOIN u AS b ON a.x = b.x",
+			"SELECT a.x FROM other.t AS a JOIN db.u AS b ON a.x = b.x",
+		},
+		{
+			"WITH c AS (SELECT x FROM t) SELECT x FROM c",
+			"WITH c AS (SELECT x FROM db.t) SELECT x FROM c",
+		},
+		{
+			"SELECT x FROM (t ++ u)",
+			"SELECT x FROM (db.t ++ db.u)",
+		},
+	}
+	for i := range tcs {
+		q, err := ParseView("db", tcs[i].text)
+		if err != nil {
+			t.Errorf("%s: %s", tcs[i].text, err)
+			continue
+		}
+		if got := q.Text(); got != tcs[i].want {
+			t.Errorf("got %q, want %q", got, tcs[i].want)
+		}
+	}
+	for _, text := range []string{
+		"SELECT * INTO db.x FROM t",
+		"EXPLAIN SELECT * FROM t",
+		"SELECT x FROM t UNION ALL SELECT y FROM u",
+		"SELECT * FROM",
+	} {
+		if _, err := ParseView("db", text); err == nil {
+			t.Errorf("%s: no error", text)
+		}
+	}
+}
+
+// openRecorder records the files opened
+// through it
+type openRecorder struct {
+	db.InputFS
+	opened []string
+}
+
+func (o *openRecorder) Open(name string) (fs.File, error) {
+	o.opened = append(o.opened, name)
+	return o.InputFS.Open(name)
+}
+
+func TestFSEnvView(t *testing.T) {
+	dfs := db.NewDirFS(t.TempDir())
+	defer dfs.Close()
+	err := db.WriteView(dfs, "db", "v", &db.View{Query: "SELECT x FROM t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &openRecorder{InputFS: dfs}
+	env := &FSEnv{Root: root}
+	for i := 0; i < 2; i++ {
+		v, err := env.view("db", "v")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == nil || v.Query != "SELECT x FROM t" {
+			t.Fatalf("got view %v", v)
+		}
+		v, err = env.view("db", "t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != nil {
+			t.Fatalf("got view %v for a table", v)
+		}
+	}
+	// each view is looked up once, without
+	// listing the views in the database
+	want := []string{db.ViewPath("db", "v"), db.ViewPath("db", "t")}
+	if !slices.Equal(root.opened, want) {
+		t.Errorf("opened %q, want %q", root.opened, want)
+	}
+}
@@ -15,11 +15,13 @@
 package vm
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"sync"
 
 	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/ion"
 )
 
 // DistinctFilter is a QuerySink that deduplicates
@@ -46,6 +48,15 @@ type DistinctFilter struct {
 	dedup     *radixTree64
 	limit     int64
 	remaining int64
+
+	// mem is the size of dedup accounted
+	// against the spill budget; once the budget
+	// is exceeded, dedup stops growing and rows
+	// that it doesn't contain are written to spill
+	// so that they can be deduplicated in Close
+	mem      int64
+	spilling bool
+	spill    *spillSet
 }
 
 // NewDistinct creates a new DistinctFilter
@@ -107,9 +118,78 @@ func (d *DistinctFilter) Open() (io.WriteCloser, error) {
 
 func (d *DistinctFilter) Close() error {
 	d.prog.reset()
+	spillAccount(-d.mem, 0)
+	d.mem = 0
+	if d.spill != nil {
+		err := d.unspill()
+		d.spill.Close()
+		d.spill = nil
+		if err != nil {
+			d.out.Close()
+			return err
+		}
+	}
 	return d.out.Close()
 }
 
+// unspill writes the distinct rows from
+// each of the spilled partitions to d.out
+func (d *DistinctFilter) unspill() error {
+	w, err := d.out.Open()
+	if err != nil {
+		return err
+	}
+	// once we have accumulated this many data bytes,
+	// flush the output buffer:
+	const flushAt = PageSize / 2
+
+	var body, chunk ion.Buffer
+	flush := func(st *ion.Symtab) error {
+		if body.Size() == 0 {
+			return nil
+		}
+		chunk.Reset()
+		st.Marshal(&chunk, true)
+		chunk.UnsafeAppend(body.Bytes())
+		body.Reset()
+		_, err := w.Write(chunk.Bytes())
+		return err
+	}
+	for i := 0; i < spillParts && err == nil; i++ {
+		f := d.spill.parts[i]
+		if f == nil {
+			continue
+		}
+		// partitions are disjoint, so we only
+		// need to deduplicate within each partition
+		seen := newRadixTree(0)
+		err = f.each(func(rec []byte) error {
+			if d.limit > 0 && d.remaining == 0 {
+				return nil
+			}
+			if _, ok := seen.insertSlow(le64(rec)); !ok {
+				return nil
+			}
+			if d.limit > 0 {
+				d.remaining--
+			}
+			body.UnsafeAppend(rec[8:])
+			if body.Size() >= flushAt {
+				return flush(&f.st)
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush(&f.st)
+		}
+	}
+	err2 := w.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
 type deduper struct {
 	prog   prog
 	parent *DistinctFilter
@@ -130,6 +210,17 @@ type deduper struct {
 	// if we reach the limit
 	// set by the parent
 	closed bool
+
+	// memory accounted for local
+	mem int64
+
+	// most recent symbolize() symtab
+	// and the symbols for the aux bindings;
+	// used for writing rows to spill files
+	st      *symtab
+	auxsyms []ion.Symbol
+	scratch ion.Buffer
+	hdr     []byte
 }
 
 func (d *deduper) symbolize(st *symtab, aux *auxbindings) error {
@@ -149,6 +240,11 @@ func (d *deduper) symbolize(st *symtab, aux *auxbindings) error {
 	if !ok {
 		d.hashslot = -1
 	}
+	d.st = st
+	d.auxsyms = d.auxsyms[:0]
+	for i := range aux.bound {
+		d.auxsyms = append(d.auxsyms, st.Intern(aux.bound[i]))
+	}
 	// pass on aux bindings:
 	return d.dst.symbolize(st, aux)
 }
@@ -223,6 +319,17 @@ func (d *deduper) writeRows(delims []vmref, rp *rowParams) error {
 		panic("expected to insert at least one tree entry")
 	}
 
+	// the local tree is only a cache of
+	// the global tree, so we can drop it
+	// if it is using too much memory
+	size := int64(d.local.size())
+	if spillAccount(size-d.mem, size) {
+		spillAccount(-size, 0)
+		d.local = nil
+		size = 0
+	}
+	d.mem = size
+
 	// perform the same insert, but
 	// this time with the global tree
 	outpos = 0
@@ -233,6 +340,15 @@ func (d *deduper) writeRows(delims []vmref, rp *rowParams) error {
 		all = d.parent.dedup
 	}
 	for i := range hashes {
+		if d.parent.spilling {
+			if all.Offset(hashes[i]) < 0 {
+				if err := d.spillRow(delims[i], aux, i, hashes[i]); err != nil {
+					d.parent.lock.Unlock()
+					return err
+				}
+			}
+			continue
+		}
 		_, ok := all.insertSlow(hashes[i])
 		if ok {
 			delims[outpos] = delims[i]
@@ -242,6 +358,11 @@ func (d *deduper) writeRows(delims []vmref, rp *rowParams) error {
 			outpos++
 		}
 	}
+	if !d.parent.spilling {
+		size := int64(all.size())
+		d.parent.spilling = spillAccount(size-d.parent.mem, size)
+		d.parent.mem = size
+	}
 	if d.parent.limit > 0 {
 		c := int64(outpos)
 		if c >= d.parent.remaining {
@@ -265,7 +386,61 @@ func (d *deduper) writeRows(delims []vmref, rp *rowParams) error {
 	return d.dst.writeRows(delims, &d.params)
 }
 
+// spillRow writes a row and its aux bindings to
+// the spill file for its hash; the caller must
+// hold d.parent.lock
+func (d *deduper) spillRow(row vmref, aux [][]vmref, i int, hash uint64) error {
+	d.scratch.Reset()
+	d.scratch.BeginStruct(-1)
+	// aux bindings shadow fields with the same name
+	for j := range d.auxsyms {
+		mem := aux[j][i].mem()
+		if len(mem) == 0 {
+			continue
+		}
+		d.scratch.BeginField(d.auxsyms[j])
+		d.scratch.UnsafeAppend(mem)
+	}
+	data := row.mem()
+outer:
+	for len(data) > 0 {
+		sym, rest, err := ion.ReadLabel(data)
+		if err != nil {
+			return err
+		}
+		size := ion.SizeOf(rest)
+		field := rest[:size]
+		data = rest[size:]
+		for j := range d.auxsyms {
+			if d.auxsyms[j] == sym && len(aux[j][i].mem()) > 0 {
+				continue outer
+			}
+		}
+		d.scratch.BeginField(sym)
+		d.scratch.UnsafeAppend(field)
+	}
+	d.scratch.EndStruct()
+	dat, _, err := ion.ReadDatum(&d.st.Symtab, d.scratch.Bytes())
+	if err != nil {
+		return err
+	}
+	if d.parent.spill == nil {
+		d.parent.spill = new(spillSet)
+	}
+	set := d.parent.spill
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	f, err := set.file(spillPart(hash))
+	if err != nil {
+		return err
+	}
+	d.hdr = binary.LittleEndian.AppendUint64(d.hdr[:0], hash)
+	return f.writeDatum(d.hdr, dat)
+}
+
 func (d *deduper) Close() error {
 	d.bc.reset()
+	spillAccount(-d.mem, 0)
+	d.mem = 0
 	return d.dst.Close()
 }
@@ -15,6 +15,7 @@
 package vm
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"slices"
@@ -22,6 +23,7 @@ import (
 	"sync/atomic"
 
 	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/heap"
 	"github.com/SnellerInc/sneller/ion"
 )
 
@@ -45,6 +47,11 @@ type HashAggregate struct {
 	final *aggtable
 	limit int
 
+	// spill holds the aggregate state written
+	// to disk once the spill budget was exceeded;
+	// it is nil if nothing has been spilled
+	spill *spillSet
+
 	// ordering functions;
 	// applied in order to determine
 	// the total ordering
@@ -53,7 +60,9 @@ type HashAggregate struct {
 	windows []window
 }
 
-type aggOrderFn func(*aggtable, int, int) int
+// aggOrderFn compares entry i of one table
+// with entry j of another (or the same) table
+type aggOrderFn func(l *aggtable, i int, r *aggtable, j int) int
 
 type window struct {
 	// order computes the partitions *plus*
@@ -83,7 +92,7 @@ func (w *window) run(agt *aggtable) {
 	partorder := w.order[:w.partitions]
 	partcmp := func(i, j int) int {
 		for k := range partorder {
-			dir := partorder[k](agt, i, j)
+			dir := partorder[k](agt, i, agt, j)
 			if dir != 0 {
 				return dir
 			}
@@ -92,7 +101,7 @@ func (w *window) run(agt *aggtable) {
 	}
 	cmp := func(i, j int) int {
 		for k := range fullorder {
-			dir := fullorder[k](agt, i, j)
+			dir := fullorder[k](agt, i, agt, j)
 			if dir != 0 {
 				return dir
 			}
@@ -121,18 +130,18 @@ func (h *HashAggregate) Limit(n int) {
 }
 
 func (h *HashAggregate) groupFn(n int, ordering SortOrdering) aggOrderFn {
-	return func(agt *aggtable, i, j int) int {
-		leftmem := agt.repridx(&agt.pairs[i], n)
-		rightmem := agt.repridx(&agt.pairs[j], n)
+	return func(l *aggtable, i int, r *aggtable, j int) int {
+		leftmem := l.repridx(&l.pairs[i], n)
+		rightmem := r.repridx(&r.pairs[j], n)
 		return ordering.Compare(leftmem, rightmem)
 	}
 }
 
 func (h *HashAggregate) aggFn(n int, ordering SortOrdering) aggOrderFn {
-	return func(agt *aggtable, i, j int) int {
+	return func(l *aggtable, i int, r *aggtable, j int) int {
 		op := h.aggregateOps[n]
-		lmem := agt.valueof(&agt.pairs[i])
-		rmem := agt.valueof(&agt.pairs[j])
+		lmem := l.valueof(&l.pairs[i])
+		rmem := r.valueof(&r.pairs[j])
 		dir := aggcmp(op.fn, lmem, rmem)
 		if ordering.Direction == SortDescending {
 			return -dir
@@ -142,7 +151,9 @@ func (h *HashAggregate) aggFn(n int, ordering SortOrdering) aggOrderFn {
 }
 
 func (h *HashAggregate) windowOrder(n int, ordering SortOrdering) aggOrderFn {
-	return func(agt *aggtable, i, j int) int {
+	// window results are only computed for
+	// a single table, so l and r are the same
+	return func(_ *aggtable, i int, _ *aggtable, j int) int {
 		return int(h.windows[n].final[i]) - int(h.windows[n].final[j])
 	}
 }
@@ -396,20 +407,23 @@ func NewHashAggregate(agg, windows Aggregation, by Selection, dst QuerySink) (*H
 	return h, nil
 }
 
-func (h *HashAggregate) Open() (io.WriteCloser, error) {
-	at := &aggtable{
+func (h *HashAggregate) newTable() *aggtable {
+	return &aggtable{
 		parent:       h,
 		tree:         newRadixTree(len(h.initialData)),
 		aggregateOps: h.aggregateOps,
 		mergestate:   mergestate(h.aggregateOps),
 	}
+}
 
+func (h *HashAggregate) Open() (io.WriteCloser, error) {
+	at := h.newTable()
 	atomic.AddInt64(&h.children, 1)
 	return splitter(at), nil
 }
 
-func (h *HashAggregate) sort() []int {
-	ret := make([]int, len(h.final.pairs))
+func (h *HashAggregate) sort(agt *aggtable) []int {
+	ret := make([]int, len(agt.pairs))
 	for i := range ret {
 		ret[i] = i
 	}
@@ -417,15 +431,269 @@ func (h *HashAggregate) sort() []int {
 		return ret
 	}
 	slices.SortFunc(ret, func(i, j int) int {
-		for k := range h.order {
-			dir := h.order[k](h.final, i, j)
-			if dir != 0 {
-				return dir
+		return h.compare(agt, i, agt, j)
+	})
+	return ret
+}
+
+// compare compares entry i of l with
+// entry j of r according to h.order
+func (h *HashAggregate) compare(l *aggtable, i int, r *aggtable, j int) int {
+	for k := range h.order {
+		dir := h.order[k](l, i, r, j)
+		if dir != 0 {
+			return dir
+		}
+	}
+	return 0
+}
+
+// finalize applies the final step of
+// each aggregate op to the values in agt
+func (h *HashAggregate) finalize(agt *aggtable) {
+	hasfinalize := false
+	for i := range agt.pairs {
+		p := &agt.pairs[i]
+		valmem := agt.valueof(p)
+		offset := 0
+		for j := range h.aggregateOps {
+			op := h.aggregateOps[j]
+			if finalize := aggregateOpInfoTable[op.fn].finalizeFunc; finalize != nil && !op.savestate() {
+				buf := valmem[offset:]
+				finalize(buf)
+				hasfinalize = true
+			}
+			offset += op.dataSize()
+			if op.mergestate() {
+				offset += aggregateOpMergeBufferSize
 			}
 		}
-		return 0
+
+		if !hasfinalize {
+			break // no finalize found in the first iteration, exit early
+		}
+	}
+}
+
+// ordered computes the windows, ORDER BY and LIMIT
+// for the entries in agt and passes the result to fn
+func (h *HashAggregate) ordered(agt *aggtable, fn func(*aggtable, []int) error) error {
+	// compute final window results
+	for i := range h.windows {
+		h.windows[i].run(agt)
+	}
+	// compute ORDER BY + LIMIT
+	order := h.sort(agt)
+	if h.limit > 0 && len(order) > h.limit {
+		order = order[:h.limit]
+	}
+	return fn(agt, order)
+}
+
+// results calls fn with the finalized aggregate
+// table(s) and the order in which the entries of
+// each table should be output
+func (h *HashAggregate) results(fn func(*aggtable, []int) error) error {
+	if h.spill == nil {
+		h.finalize(h.final)
+		return h.ordered(h.final, fn)
+	}
+	if len(h.order) == 0 && len(h.windows) == 0 && h.limit <= 0 {
+		// each partition is complete and there is no
+		// ordering to apply, so we can produce the
+		// output one partition at a time
+		wrote := false
+		return h.unspill(func(part *aggtable, last bool) error {
+			if len(part.pairs) == 0 && (wrote || !last) {
+				return nil
+			}
+			wrote = true
+			return fn(part, h.sort(part))
+		})
+	}
+	if len(h.windows) == 0 {
+		return h.merge(fn)
+	}
+	// window functions are computed
+	// over the complete set of groups
+	out := h.newTable()
+	err := h.unspill(func(part *aggtable, _ bool) error {
+		for i := range part.pairs {
+			out.copyEntry(part, &part.pairs[i])
+		}
+		return nil
 	})
-	return ret
+	if err != nil {
+		return err
+	}
+	return h.ordered(out, fn)
+}
+
+// aggMergeBatch is the number of entries
+// that are read from each sorted run (and
+// passed to the output) at a time by merge
+const aggMergeBatch = 1024
+
+// aggRun is a sorted run of finalized
+// entries written to a spill file
+type aggRun struct {
+	f   *spillFile
+	r   *spillReader
+	tbl *aggtable // current batch of entries
+	pos int       // current entry in tbl
+}
+
+// fill reads the next batch of entries from the run;
+// the run is exhausted if tbl is empty afterwards
+func (a *aggRun) fill(h *HashAggregate) error {
+	vsize := len(h.initialData)
+	a.tbl = h.newTable()
+	a.pos = 0
+	for len(a.tbl.pairs) < aggMergeBatch {
+		rec, err := a.r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < 8+vsize {
+			return fmt.Errorf("HashAggregate: corrupt spill record")
+		}
+		off := a.tbl.insert(le64(rec), rec[8:len(rec)-vsize])
+		copy(a.tbl.tree.values[off+8:], rec[len(rec)-vsize:])
+	}
+	return nil
+}
+
+// merge sorts each partition produced by unspill,
+// writes it to a spill file, and then performs a k-way
+// merge of the sorted runs, so that only a batch of
+// entries per partition is held in memory at once;
+// this works because the partitions hold disjoint groups
+func (h *HashAggregate) merge(fn func(*aggtable, []int) error) error {
+	var runs []*aggRun
+	defer func() {
+		for _, run := range runs {
+			run.f.Close()
+		}
+	}()
+	vsize := len(h.initialData)
+	var rec []byte
+	err := h.unspill(func(part *aggtable, _ bool) error {
+		if len(part.pairs) == 0 {
+			return nil
+		}
+		f, err := newSpillFile()
+		if err != nil {
+			return err
+		}
+		runs = append(runs, &aggRun{f: f})
+		order := h.sort(part)
+		if h.limit > 0 && len(order) > h.limit {
+			order = order[:h.limit]
+		}
+		for _, n := range order {
+			p := &part.pairs[n]
+			rec = binary.LittleEndian.AppendUint64(rec[:0], part.hashof(p))
+			rec = append(rec, part.fullrepr(p, len(h.by))...)
+			rec = append(rec, part.valueof(p)[:vsize]...)
+			if err := f.write(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	less := func(a, b *aggRun) bool {
+		return h.compare(a.tbl, a.pos, b.tbl, b.pos) < 0
+	}
+	var order []*aggRun
+	for _, run := range runs {
+		run.r, err = run.f.reader()
+		if err != nil {
+			return err
+		}
+		if err := run.fill(h); err != nil {
+			return err
+		}
+		if len(run.tbl.pairs) > 0 {
+			heap.PushSlice(&order, run, less)
+		}
+	}
+	// entries are added to out in order,
+	// so each batch is output as-is
+	ident := make([]int, aggMergeBatch)
+	for i := range ident {
+		ident[i] = i
+	}
+	limit := h.limit
+	out := h.newTable()
+	wrote := false
+	for len(order) > 0 && (h.limit <= 0 || limit > 0) {
+		run := order[0]
+		out.copyEntry(run.tbl, &run.tbl.pairs[run.pos])
+		limit--
+		if len(out.pairs) == aggMergeBatch {
+			if err := fn(out, ident); err != nil {
+				return err
+			}
+			out = h.newTable()
+			wrote = true
+		}
+		run.pos++
+		if run.pos == len(run.tbl.pairs) {
+			if err := run.fill(h); err != nil {
+				return err
+			}
+		}
+		if len(run.tbl.pairs) == 0 {
+			heap.PopSlice(&order, less)
+		} else {
+			heap.FixSlice(order, 0, less)
+		}
+	}
+	if !wrote || len(out.pairs) > 0 {
+		// always produce at least one
+		// (possibly empty) table
+		return fn(out, ident[:len(out.pairs)])
+	}
+	return nil
+}
+
+// unspill merges the aggregate state in h.final
+// with the state written to h.spill one partition
+// at a time and calls fn with the finalized entries
+// of each partition
+func (h *HashAggregate) unspill(fn func(part *aggtable, last bool) error) error {
+	vsize := len(h.initialData)
+	for i := 0; i < spillParts; i++ {
+		part := h.newTable()
+		for j := range h.final.pairs {
+			p := &h.final.pairs[j]
+			hash := h.final.hashof(p)
+			if spillPart(hash) == i {
+				part.mergeEntry(hash, h.final.fullrepr(p, len(h.by)), h.final.valueof(p))
+			}
+		}
+		err := h.spill.each(i, func(_ *spillFile, rec []byte) error {
+			if len(rec) < 8+vsize {
+				return fmt.Errorf("HashAggregate: corrupt spill record")
+			}
+			part.mergeEntry(le64(rec), rec[8:len(rec)-vsize], rec[len(rec)-vsize:])
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		h.finalize(part)
+		if err := fn(part, i == spillParts-1); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (h *HashAggregate) Close() error {
@@ -437,6 +705,14 @@ func (h *HashAggregate) Close() error {
 	if h.final == nil {
 		return fmt.Errorf("HashAggregate.final == nil, didn't compute any aggregates?")
 	}
+	defer func() {
+		h.final.release()
+		h.final = nil
+		if h.spill != nil {
+			h.spill.Close()
+			h.spill = nil
+		}
+	}()
 	if h.skipEmpty && h.rowcount == 0 {
 		return flushEmpty(h.dst)
 	}
@@ -457,40 +733,6 @@ func (h *HashAggregate) Close() error {
 	for i := range h.windows {
 		windowsyms = append(windowsyms, outst.Intern(h.windows[i].result))
 	}
-	outst.Marshal(&outbuf, true)
-
-	hasfinalize := false
-	for i := range h.final.pairs {
-		p := &h.final.pairs[i]
-		valmem := h.final.valueof(p)
-		offset := 0
-		for j := range h.aggregateOps {
-			op := h.aggregateOps[j]
-			if finalize := aggregateOpInfoTable[op.fn].finalizeFunc; finalize != nil && !op.savestate() {
-				buf := valmem[offset:]
-				finalize(buf)
-				hasfinalize = true
-			}
-			offset += op.dataSize()
-			if op.mergestate() {
-				offset += aggregateOpMergeBufferSize
-			}
-		}
-
-		if !hasfinalize {
-			break // no finalize found in the first iteration, exit early
-		}
-	}
-
-	// compute final window results
-	for i := range h.windows {
-		h.windows[i].run(h.final)
-	}
-	// compute ORDER BY + LIMIT
-	order := h.sort()
-	if h.limit > 0 && len(order) > h.limit {
-		order = order[:h.limit]
-	}
 
 	// turn the i'th 'agg' output
 	// into an offset
@@ -504,38 +746,41 @@ func (h *HashAggregate) Close() error {
 		off += op.dataSize()
 	}
 
-	for _, n := range order {
-		p := &h.final.pairs[n]
-		outbuf.BeginStruct(-1)
-		valmem := h.final.valueof(p)
-		for j, sym := range bysyms {
-			outbuf.BeginField(sym)
-			outbuf.UnsafeAppend(h.final.repridx(p, j))
-		}
-		for j, sym := range aggsyms {
-			outbuf.BeginField(sym)
-			writeAggregatedValue(&outbuf, valmem[offset[j]:], h.aggregateOps[j])
-		}
-		for j, sym := range windowsyms {
-			outbuf.BeginField(sym)
-			outbuf.WriteUint(uint64(h.windows[j].final[n]))
-		}
-		outbuf.EndStruct()
-	}
-
-	h.final = nil
 	// finally, write the output...
 	dst, err := h.dst.Open()
 	if err != nil {
 		return err
 	}
-	// NOTE: we are triggering a vm copy here;
-	// we're doing this deliberately because
-	// typically the result is small (so, cheap)
-	// or the result is large in which case
-	// the RowSplitter will take care to split
-	// it up into small pieces before copying
-	_, err = dst.Write(outbuf.Bytes())
+	err = h.results(func(agt *aggtable, order []int) error {
+		outbuf.Reset()
+		outst.Marshal(&outbuf, true)
+		for _, n := range order {
+			p := &agt.pairs[n]
+			outbuf.BeginStruct(-1)
+			valmem := agt.valueof(p)
+			for j, sym := range bysyms {
+				outbuf.BeginField(sym)
+				outbuf.UnsafeAppend(agt.repridx(p, j))
+			}
+			for j, sym := range aggsyms {
+				outbuf.BeginField(sym)
+				writeAggregatedValue(&outbuf, valmem[offset[j]:], h.aggregateOps[j])
+			}
+			for j, sym := range windowsyms {
+				outbuf.BeginField(sym)
+				outbuf.WriteUint(uint64(h.windows[j].final[n]))
+			}
+			outbuf.EndStruct()
+		}
+		// NOTE: we are triggering a vm copy here;
+		// we're doing this deliberately because
+		// typically the result is small (so, cheap)
+		// or the result is large in which case
+		// the RowSplitter will take care to split
+		// it up into small pieces before copying
+		_, err := dst.Write(outbuf.Bytes())
+		return err
+	})
 	if err != nil {
 		dst.Close()
 		return err
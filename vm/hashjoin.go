@@ -0,0 +1,855 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/SnellerInc/sneller/ion"
+
+	"github.com/dchest/siphash"
+)
+
+// joinBatch is the maximum number of rows
+// written by a hash join in a single call
+// to writeRows
+const joinBatch = 1024
+
+// keyPath locates a join key within a row;
+// the first component of the path may name
+// an auxiliary binding
+type keyPath struct {
+	path []string
+	aux  int // index of path[0] in auxbound, or -1
+	syms []ion.Symbol
+	ok   bool // all of syms are present in the symbol table
+}
+
+func (k *keyPath) symbolize(st *symtab, aux *auxbindings) {
+	fields := k.path
+	k.aux = -1
+	if id, ok := aux.id(k.path[0]); ok {
+		k.aux = id
+		fields = k.path[1:]
+	}
+	k.syms = k.syms[:0]
+	k.ok = true
+	for i := range fields {
+		sym, ok := st.Symbolize(fields[i])
+		if !ok {
+			k.ok = false
+			return
+		}
+		k.syms = append(k.syms, sym)
+	}
+}
+
+// value returns the raw ion value of the key
+// in row i, or nil if the key is not present
+func (k *keyPath) value(delims []vmref, rp *rowParams, i int) []byte {
+	if !k.ok {
+		return nil
+	}
+	var body []byte
+	if k.aux >= 0 {
+		v := rp.auxbound[k.aux][i].mem()
+		if len(k.syms) == 0 {
+			return v
+		}
+		if len(v) == 0 || ion.TypeOf(v) != ion.StructType {
+			return nil
+		}
+		body, _ = ion.Contents(v)
+	} else {
+		body = delims[i].mem()
+	}
+	for j := range k.syms {
+		v := fieldValue(body, k.syms[j])
+		if v == nil || j == len(k.syms)-1 {
+			return v
+		}
+		if ion.TypeOf(v) != ion.StructType {
+			return nil
+		}
+		body, _ = ion.Contents(v)
+	}
+	return nil
+}
+
+// fieldValue returns the raw value of the field
+// labeled sym within the struct body, or nil
+// if no such field is present
+func fieldValue(body []byte, sym ion.Symbol) []byte {
+	for len(body) > 0 {
+		lbl, rest, err := ion.ReadLabel(body)
+		if err != nil || len(rest) == 0 {
+			return nil
+		}
+		size := ion.SizeOf(rest)
+		if size <= 0 || size > len(rest) {
+			return nil
+		}
+		if lbl == sym {
+			return rest[:size]
+		}
+		body = rest[size:]
+	}
+	return nil
+}
+
+// appendKey appends the canonical encoding of the ion
+// value v to dst. Values that compare equal (for example
+// a symbol and a string with the same text, or an
+// integer and an integral float) have the same canonical
+// encoding regardless of the symbol table in use.
+// The returned boolean is false if v is NULL or MISSING,
+// since NULL and MISSING are never equal to anything.
+func appendKey(dst []byte, st *ion.Symtab, v []byte) ([]byte, bool) {
+	if len(v) == 0 {
+		return dst, false
+	}
+	switch ion.TypeOf(v) {
+	case ion.NullType:
+		return dst, false
+	case ion.SymbolType:
+		sym, _, err := ion.ReadSymbol(v)
+		if err != nil {
+			return dst, false
+		}
+		str, ok := st.Lookup(sym)
+		if !ok {
+			return dst, false
+		}
+		dst = append(dst, 's')
+		dst = binary.AppendUvarint(dst, uint64(len(str)))
+		return append(dst, str...), true
+	case ion.StringType:
+		str, _, err := ion.ReadStringShared(v)
+		if err != nil {
+			return dst, false
+		}
+		dst = append(dst, 's')
+		dst = binary.AppendUvarint(dst, uint64(len(str)))
+		return append(dst, str...), true
+	case ion.IntType:
+		i, _, err := ion.ReadInt(v)
+		if err != nil {
+			return dst, false
+		}
+		return binary.LittleEndian.AppendUint64(append(dst, 'i'), uint64(i)), true
+	case ion.UintType:
+		u, _, err := ion.ReadUint(v)
+		if err != nil {
+			return dst, false
+		}
+		if u > math.MaxInt64 {
+			return binary.LittleEndian.AppendUint64(append(dst, 'u'), u), true
+		}
+		return binary.LittleEndian.AppendUint64(append(dst, 'i'), u), true
+	case ion.FloatType:
+		f, _, err := ion.ReadFloat64(v)
+		if err != nil {
+			return dst, false
+		}
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return binary.LittleEndian.AppendUint64(append(dst, 'i'), uint64(int64(f))), true
+		}
+		return binary.LittleEndian.AppendUint64(append(dst, 'f'), math.Float64bits(f)), true
+	case ion.ListType, ion.SexpType:
+		body, _ := ion.Contents(v)
+		dst = append(dst, 'l')
+		for len(body) > 0 {
+			size := ion.SizeOf(body)
+			if size <= 0 || size > len(body) {
+				return dst, false
+			}
+			var ok bool
+			dst, ok = appendKey(dst, st, body[:size])
+			if !ok {
+				dst = append(dst, 'n')
+			}
+			body = body[size:]
+		}
+		return append(dst, 'e'), true
+	case ion.StructType:
+		body, _ := ion.Contents(v)
+		dst = append(dst, 'S')
+		for len(body) > 0 {
+			sym, rest, err := ion.ReadLabel(body)
+			if err != nil || len(rest) == 0 {
+				return dst, false
+			}
+			size := ion.SizeOf(rest)
+			if size <= 0 || size > len(rest) {
+				return dst, false
+			}
+			lbl := st.Get(sym)
+			dst = binary.AppendUvarint(dst, uint64(len(lbl)))
+			dst = append(dst, lbl...)
+			var ok bool
+			dst, ok = appendKey(dst, st, rest[:size])
+			if !ok {
+				dst = append(dst, 'n')
+			}
+			body = rest[size:]
+		}
+		return append(dst, 'e'), true
+	default:
+		// everything else compares by its encoding
+		dst = append(dst, 'r')
+		dst = binary.AppendUvarint(dst, uint64(len(v)))
+		return append(dst, v...), true
+	}
+}
+
+// partitionOf returns the partition (out of parts)
+// that owns the canonical key k
+func partitionOf(k []byte, parts int) int {
+	const (
+		k0 = 0x2b9c6a5e8d41f307
+		k1 = 0x7f13c0e95ad2846b
+	)
+	return int(siphash.Hash(k0, k1, k) % uint64(parts))
+}
+
+// HashPartition is a QuerySink that only
+// passes on the rows whose join key hashes
+// to one particular partition out of a fixed
+// number of partitions. Rows for which the key
+// is NULL or MISSING are dropped, since they
+// cannot match any row in an equi-join.
+//
+// Writing the same rows into one HashPartition
+// for each partition number distributes the rows
+// so that rows with equal keys always end up in
+// the same partition.
+type HashPartition struct {
+	key         []string
+	part, parts int
+	dst         QuerySink
+}
+
+// NewHashPartition constructs a HashPartition that
+// writes the rows for which the key at the path key
+// belongs to partition part of parts into dst.
+func NewHashPartition(key []string, part, parts int, dst QuerySink) (*HashPartition, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("vm.NewHashPartition: empty key path")
+	}
+	if part < 0 || part >= parts {
+		return nil, fmt.Errorf("vm.NewHashPartition: partition %d out of range [0, %d)", part, parts)
+	}
+	return &HashPartition{
+		key:   key,
+		part:  part,
+		parts: parts,
+		dst:   dst,
+	}, nil
+}
+
+func (h *HashPartition) Open() (io.WriteCloser, error) {
+	w, err := h.dst.Open()
+	if err != nil {
+		return nil, err
+	}
+	return splitter(&hashPartitioner{
+		parent: h,
+		key:    keyPath{path: h.key},
+		dst:    asRowConsumer(w),
+	}), nil
+}
+
+func (h *HashPartition) Close() error {
+	return h.dst.Close()
+}
+
+type hashPartitioner struct {
+	parent *HashPartition
+	key    keyPath
+	st     *symtab
+	dst    rowConsumer
+	buf    []byte
+}
+
+func (h *hashPartitioner) symbolize(st *symtab, aux *auxbindings) error {
+	h.st = st
+	h.key.symbolize(st, aux)
+	return h.dst.symbolize(st, aux)
+}
+
+func (h *hashPartitioner) next() rowConsumer { return h.dst }
+
+func (h *hashPartitioner) Close() error { return h.dst.Close() }
+
+func (h *hashPartitioner) writeRows(delims []vmref, rp *rowParams) error {
+	n := 0
+	for i := range delims {
+		var ok bool
+		h.buf, ok = appendKey(h.buf[:0], &h.st.Symtab, h.key.value(delims, rp, i))
+		if !ok || partitionOf(h.buf, h.parent.parts) != h.parent.part {
+			continue
+		}
+		delims[n] = delims[i]
+		for j := range rp.auxbound {
+			rp.auxbound[j][n] = rp.auxbound[j][i]
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	for j := range rp.auxbound {
+		rp.auxbound[j] = rp.auxbound[j][:n]
+	}
+	return h.dst.writeRows(delims[:n], rp)
+}
+
+// HashSplit is a QuerySink that distributes
+// the rows written to it among several QuerySinks:
+// each row is passed on to the QuerySink at the
+// index of the hash partition of its join key,
+// so the rows written to the i'th QuerySink are
+// the rows that a HashPartition for partition i
+// of len(dst) partitions would pass on.
+//
+// Unlike a set of HashPartitions, a HashSplit
+// only needs the input rows to be written once.
+type HashSplit struct {
+	key []string
+	dst []QuerySink
+}
+
+// NewHashSplit constructs a HashSplit that writes
+// the rows for which the key at the path key belongs
+// to partition i of len(dst) partitions into dst[i].
+func NewHashSplit(key []string, dst []QuerySink) (*HashSplit, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("vm.NewHashSplit: empty key path")
+	}
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("vm.NewHashSplit: no outputs")
+	}
+	return &HashSplit{key: key, dst: dst}, nil
+}
+
+func (h *HashSplit) Open() (io.WriteCloser, error) {
+	hs := &hashSplitter{
+		key:   keyPath{path: h.key},
+		parts: make([]splitPart, len(h.dst)),
+	}
+	for i := range h.dst {
+		w, err := h.dst[i].Open()
+		if err != nil {
+			hs.Close()
+			return nil, err
+		}
+		hs.parts[i].dst = asRowConsumer(w)
+	}
+	return splitter(hs), nil
+}
+
+func (h *HashSplit) Close() error {
+	var err error
+	for i := range h.dst {
+		if err2 := h.dst[i].Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+type hashSplitter struct {
+	key   keyPath
+	st    *symtab
+	buf   []byte
+	parts []splitPart
+}
+
+// splitPart is the state of
+// one output of a hashSplitter
+type splitPart struct {
+	aux    auxbindings
+	dst    rowConsumer
+	done   bool // dst returned io.EOF
+	delims []vmref
+	params rowParams
+}
+
+func (h *hashSplitter) symbolize(st *symtab, aux *auxbindings) error {
+	h.st = st
+	h.key.symbolize(st, aux)
+	for i := range h.parts {
+		p := &h.parts[i]
+		if p.done {
+			continue
+		}
+		p.aux.set(aux)
+		if err := p.dst.symbolize(st, &p.aux); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *hashSplitter) next() rowConsumer { return nil }
+
+func (h *hashSplitter) Close() error {
+	var err error
+	for i := range h.parts {
+		if h.parts[i].dst == nil {
+			continue
+		}
+		if err2 := h.parts[i].dst.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+func (h *hashSplitter) EndSegment() {
+	for i := range h.parts {
+		for rc := h.parts[i].dst; rc != nil; rc = rc.next() {
+			if esw, ok := rc.(EndSegmentWriter); ok {
+				esw.EndSegment()
+			}
+		}
+	}
+}
+
+func (h *hashSplitter) writeRows(delims []vmref, rp *rowParams) error {
+	for i := range h.parts {
+		p := &h.parts[i]
+		p.delims = p.delims[:0]
+		p.params.auxbound = shrink(p.params.auxbound, len(rp.auxbound))
+		for j := range p.params.auxbound {
+			p.params.auxbound[j] = p.params.auxbound[j][:0]
+		}
+	}
+	for i := range delims {
+		var ok bool
+		h.buf, ok = appendKey(h.buf[:0], &h.st.Symtab, h.key.value(delims, rp, i))
+		if !ok {
+			continue
+		}
+		p := &h.parts[partitionOf(h.buf, len(h.parts))]
+		if p.done {
+			continue
+		}
+		p.delims = append(p.delims, delims[i])
+		for j := range rp.auxbound {
+			p.params.auxbound[j] = append(p.params.auxbound[j], rp.auxbound[j][i])
+		}
+	}
+	live := 0
+	for i := range h.parts {
+		p := &h.parts[i]
+		if p.done {
+			continue
+		}
+		live++
+		if len(p.delims) == 0 {
+			continue
+		}
+		// ensure padding:
+		p.delims = sanitizeAux(p.delims, len(p.delims))
+		for j := range p.params.auxbound {
+			p.params.auxbound[j] = sanitizeAux(p.params.auxbound[j], len(p.delims))
+		}
+		err := p.dst.writeRows(p.delims, &p.params)
+		if err == io.EOF {
+			// this output doesn't need
+			// any more rows, but the others may
+			p.done = true
+			live--
+		} else if err != nil {
+			return err
+		}
+	}
+	if live == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// JoinTable is a QuerySink that accumulates
+// the build side of a hash join.
+//
+// Each row written to a JoinTable should contain
+// the join key and the joined value as two fields;
+// rows where the key is NULL or MISSING or the
+// value is MISSING are ignored.
+//
+// A JoinTable may be used by a HashJoin
+// once it has been closed.
+type JoinTable struct {
+	key, value string
+
+	lock   sync.Mutex
+	values ion.Bag
+	keys   []string
+	labels map[string]struct{}
+
+	// populated by Close:
+	items  []ion.Datum
+	index  map[string][]int32
+	sorted []string
+}
+
+// NewJoinTable constructs an empty JoinTable
+// that reads the join key from the field named
+// key and the joined value from the field named value.
+func NewJoinTable(key, value string) *JoinTable {
+	return &JoinTable{
+		key:    key,
+		value:  value,
+		labels: make(map[string]struct{}),
+	}
+}
+
+// Len returns the number of entries in the table.
+func (j *JoinTable) Len() int { return len(j.keys) }
+
+// Size returns the approximate size
+// of the table entries in memory.
+func (j *JoinTable) Size() int { return j.values.Size() }
+
+func (j *JoinTable) Open() (io.WriteCloser, error) {
+	return splitter(&joinBuilder{
+		parent: j,
+		labels: make(map[string]struct{}),
+	}), nil
+}
+
+// Close indexes the entries of the table.
+func (j *JoinTable) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.items = j.items[:0]
+	j.index = make(map[string][]int32)
+	j.values.Each(func(d ion.Datum) bool {
+		n := int32(len(j.items))
+		j.items = append(j.items, d)
+		k := j.keys[n]
+		j.index[k] = append(j.index[k], n)
+		return true
+	})
+	if len(j.items) != len(j.keys) {
+		return fmt.Errorf("vm.JoinTable: %d values for %d keys", len(j.items), len(j.keys))
+	}
+	j.sorted = j.sorted[:0]
+	for lbl := range j.labels {
+		j.sorted = append(j.sorted, lbl)
+	}
+	slices.Sort(j.sorted)
+	return nil
+}
+
+type joinBuilder struct {
+	parent *JoinTable
+	st     *symtab
+
+	keysym, valsym ion.Symbol
+	ok             bool
+
+	values ion.Bag
+	keys   []string
+	labels map[string]struct{}
+	buf    []byte
+}
+
+func (b *joinBuilder) symbolize(st *symtab, aux *auxbindings) error {
+	b.st = st
+	var kok, vok bool
+	b.keysym, kok = st.Symbolize(b.parent.key)
+	b.valsym, vok = st.Symbolize(b.parent.value)
+	b.ok = kok && vok
+	return nil
+}
+
+func (b *joinBuilder) next() rowConsumer { return nil }
+
+func (b *joinBuilder) writeRows(delims []vmref, rp *rowParams) error {
+	if !b.ok {
+		return nil
+	}
+	st := &b.st.Symtab
+	for i := range delims {
+		body := delims[i].mem()
+		k := fieldValue(body, b.keysym)
+		v := fieldValue(body, b.valsym)
+		if v == nil {
+			continue
+		}
+		var ok bool
+		b.buf, ok = appendKey(b.buf[:0], st, k)
+		if !ok {
+			continue
+		}
+		d, _, err := ion.ReadDatum(st, v)
+		if err != nil {
+			return err
+		}
+		collectLabels(st, v, b.labels)
+		b.values.AddDatum(d)
+		b.keys = append(b.keys, string(b.buf))
+	}
+	return nil
+}
+
+func (b *joinBuilder) Close() error {
+	j := b.parent
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.values.Append(&b.values)
+	j.keys = append(j.keys, b.keys...)
+	for lbl := range b.labels {
+		j.labels[lbl] = struct{}{}
+	}
+	return nil
+}
+
+// collectLabels adds the text of every
+// struct field label within v to labels
+func collectLabels(st *ion.Symtab, v []byte, labels map[string]struct{}) {
+	switch ion.TypeOf(v) {
+	case ion.StructType:
+		body, _ := ion.Contents(v)
+		for len(body) > 0 {
+			sym, rest, err := ion.ReadLabel(body)
+			if err != nil || len(rest) == 0 {
+				return
+			}
+			labels[st.Get(sym)] = struct{}{}
+			size := ion.SizeOf(rest)
+			if size <= 0 || size > len(rest) {
+				return
+			}
+			collectLabels(st, rest[:size], labels)
+			body = rest[size:]
+		}
+	case ion.ListType, ion.SexpType:
+		body, _ := ion.Contents(v)
+		for len(body) > 0 {
+			size := ion.SizeOf(body)
+			if size <= 0 || size > len(body) {
+				return
+			}
+			collectLabels(st, body[:size], labels)
+			body = body[size:]
+		}
+	}
+}
+
+// HashJoin is a QuerySink that joins each row
+// written to it against the entries of a JoinTable.
+//
+// For each entry with a key equal to the key of
+// a row, the row is written to the destination
+// with the value of the entry bound to an auxiliary
+// binding. Rows that do not match any entries
+// are dropped (i.e. HashJoin performs an inner join).
+type HashJoin struct {
+	table  *JoinTable
+	key    []string
+	result string
+	dst    QuerySink
+}
+
+// NewHashJoin constructs a HashJoin that matches the
+// key at the path key against the entries of table and
+// binds each matching value to result before writing
+// the joined rows into dst.
+//
+// The table must have been closed before
+// any rows are written to the HashJoin.
+func NewHashJoin(table *JoinTable, key []string, result string, dst QuerySink) (*HashJoin, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("vm.NewHashJoin: empty key path")
+	}
+	return &HashJoin{
+		table:  table,
+		key:    key,
+		result: result,
+		dst:    dst,
+	}, nil
+}
+
+func (h *HashJoin) Open() (io.WriteCloser, error) {
+	w, err := h.dst.Open()
+	if err != nil {
+		return nil, err
+	}
+	return splitter(&joinProber{
+		parent: h,
+		key:    keyPath{path: h.key},
+		dst:    asRowConsumer(w),
+		refs:   make(map[int32]vmref),
+	}), nil
+}
+
+func (h *HashJoin) Close() error {
+	return h.dst.Close()
+}
+
+type joinProber struct {
+	parent *HashJoin
+	key    keyPath
+	st     *symtab
+	dst    rowConsumer
+	auxnum int
+
+	transcode func(*ion.Buffer, ion.Datum)
+	tmp       ion.Buffer
+	buf       []byte
+
+	// page holds the encoded values
+	// of the current batch of output rows
+	page []byte
+	used int
+	refs map[int32]vmref
+
+	out    []vmref
+	outaux [][]vmref
+	params rowParams
+}
+
+func (p *joinProber) next() rowConsumer { return p.dst }
+
+func (p *joinProber) symbolize(st *symtab, aux *auxbindings) error {
+	p.st = st
+	p.key.symbolize(st, aux)
+	// make sure all of the struct field labels are
+	// present in the symbol table before symbolizing
+	// the rest of the query so that the encoded
+	// values do not introduce new symbols
+	t := p.parent.table
+	for i := range t.sorted {
+		st.Intern(t.sorted[i])
+	}
+	p.transcode = t.values.Transcoder(&st.Symtab)
+	clear(p.refs)
+	p.auxnum = aux.push(p.parent.result)
+	return p.dst.symbolize(st, aux)
+}
+
+// encode returns a reference to the encoded
+// value of the table entry i, or false if
+// there is no room left in the current page
+func (p *joinProber) encode(i int32) (vmref, bool, error) {
+	if ref, ok := p.refs[i]; ok {
+		return ref, true, nil
+	}
+	if p.page == nil {
+		p.page = Malloc()
+		p.used = 0
+	}
+	p.tmp.Reset()
+	p.transcode(&p.tmp, p.parent.table.items[i])
+	buf := p.tmp.Bytes()
+	if len(buf) > len(p.page) {
+		return vmref{}, false, fmt.Errorf("vm.HashJoin: value of %d bytes exceeds page size", len(buf))
+	}
+	if len(buf) > len(p.page)-p.used {
+		return vmref{}, false, nil
+	}
+	mem := p.page[p.used:]
+	pos, ok := vmdispl(mem)
+	if !ok {
+		panic("vm.HashJoin: page not in vmm")
+	}
+	copy(mem, buf)
+	p.used += len(buf)
+	ref := vmref{pos, uint32(len(buf))}
+	p.refs[i] = ref
+	return ref, true, nil
+}
+
+func (p *joinProber) flush() error {
+	n := len(p.out)
+	if n == 0 {
+		return nil
+	}
+	p.out = sanitizeAux(p.out, n)
+	for i := range p.outaux {
+		p.outaux[i] = sanitizeAux(p.outaux[i], n)
+	}
+	p.params.auxbound = shrink(p.params.auxbound, len(p.outaux))
+	copy(p.params.auxbound, p.outaux)
+	err := p.dst.writeRows(p.out, &p.params)
+	p.out = p.out[:0]
+	for i := range p.outaux {
+		p.outaux[i] = p.outaux[i][:0]
+	}
+	p.used = 0
+	clear(p.refs)
+	return err
+}
+
+func (p *joinProber) writeRows(delims []vmref, rp *rowParams) error {
+	if len(rp.auxbound) != p.auxnum {
+		panic("unexpected auxilliary inputs")
+	}
+	t := p.parent.table
+	p.outaux = shrink(p.outaux, p.auxnum+1)
+	for i := range p.outaux {
+		p.outaux[i] = p.outaux[i][:0]
+	}
+	for i := range delims {
+		var ok bool
+		p.buf, ok = appendKey(p.buf[:0], &p.st.Symtab, p.key.value(delims, rp, i))
+		if !ok {
+			continue
+		}
+		for _, j := range t.index[string(p.buf)] {
+			ref, ok, err := p.encode(j)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if err := p.flush(); err != nil {
+					return err
+				}
+				ref, _, err = p.encode(j)
+				if err != nil {
+					return err
+				}
+			}
+			p.out = append(p.out, delims[i])
+			for a := range rp.auxbound {
+				p.outaux[a] = append(p.outaux[a], rp.auxbound[a][i])
+			}
+			p.outaux[p.auxnum] = append(p.outaux[p.auxnum], ref)
+			if len(p.out) >= joinBatch {
+				if err := p.flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return p.flush()
+}
+
+func (p *joinProber) Close() error {
+	if p.page != nil {
+		Free(p.page)
+		p.page = nil
+	}
+	return p.dst.Close()
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/SnellerInc/sneller/ion"
+)
+
+// joinRows encodes the structures produced
+// by fn(i) for i in [0, n) as a single chunk
+func joinRows(n int, fn func(i int) ion.Struct) []byte {
+	var st ion.Symtab
+	var body ion.Buffer
+	for i := 0; i < n; i++ {
+		fn(i).Encode(&body, &st)
+	}
+	var buf ion.Buffer
+	st.Marshal(&buf, true)
+	buf.UnsafeAppend(body.Bytes())
+	return buf.Bytes()
+}
+
+// readRows decodes each of the structures in buf
+func readRows(t *testing.T, buf []byte) []ion.Struct {
+	var st ion.Symtab
+	var out []ion.Struct
+	for len(buf) > 0 {
+		if ion.IsBVM(buf) || ion.TypeOf(buf) == ion.AnnotationType {
+			var err error
+			buf, err = st.Unmarshal(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if ion.TypeOf(buf) == ion.NullType {
+			// padding
+			buf = buf[ion.SizeOf(buf):]
+			continue
+		}
+		d, rest, err := ion.ReadDatum(&st, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := d.Struct()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, s)
+		buf = rest
+	}
+	return out
+}
+
+func TestHashJoin(t *testing.T) {
+	// build side: one entry per key in [0, 100),
+	// plus a second entry for key 7 and an entry
+	// with a NULL key that should never match
+	build := joinRows(102, func(i int) ion.Struct {
+		var key ion.Datum
+		name := fmt.Sprintf("name-%d", i)
+		switch {
+		case i < 100:
+			key = ion.Int(int64(i))
+		case i == 100:
+			key = ion.Int(7)
+			name = "other-7"
+		default:
+			key = ion.Null
+		}
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "$__key", Datum: key},
+			{Label: "$__val", Datum: ion.NewList(nil, []ion.Datum{
+				ion.String(name),
+				ion.NewStruct(nil, []ion.Field{{Label: "inner", Datum: ion.Int(int64(i))}}).Datum(),
+			}).Datum()},
+		})
+	})
+	table := NewJoinTable("$__key", "$__val")
+	err := CopyRows(table, buftbl(build), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 101 {
+		t.Fatalf("table has %d entries; expected 101", table.Len())
+	}
+
+	// probe side: keys in [0, 200) with
+	// every third key encoded as a float
+	probe := joinRows(200, func(i int) ion.Struct {
+		id := ion.Int(int64(i))
+		if i%3 == 0 {
+			id = ion.Float(float64(i))
+		}
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "id", Datum: id},
+			{Label: "other", Datum: ion.String("xyz")},
+		})
+	})
+	var dst QueryBuffer
+	proj, err := NewProjection(selection("id as id, s[0] as name, s[1].inner as inner"), &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hj, err := NewHashJoin(table, []string{"id"}, "s", proj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyRows(hj, buftbl(probe), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := readRows(t, dst.Bytes())
+	if len(rows) != 101 {
+		t.Fatalf("got %d rows out; expected 101", len(rows))
+	}
+	for i := range rows {
+		var id, inner int64
+		var name string
+		err := rows[i].Each(func(f ion.Field) error {
+			var err error
+			switch f.Label {
+			case "id":
+				id, err = f.Int()
+				if err != nil {
+					var fl float64
+					fl, err = f.Float()
+					id = int64(fl)
+				}
+			case "name":
+				name, err = f.String()
+			case "inner":
+				inner, err = f.Int()
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, wantinner := fmt.Sprintf("name-%d", id), id
+		if name == "other-7" {
+			want, wantinner = "other-7", 100
+		}
+		if id >= 100 || name != want || inner != wantinner {
+			t.Errorf("row %d: id=%d name=%q inner=%d", i, id, name, inner)
+		}
+	}
+}
+
+func TestHashPartition(t *testing.T) {
+	const parts = 3
+	rows := joinRows(500, func(i int) ion.Struct {
+		key := ion.String(fmt.Sprintf("key-%d", i%50))
+		if i%7 == 0 {
+			key = ion.Null
+		}
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "row", Datum: ion.Int(int64(i))},
+			{Label: "k", Datum: ion.NewStruct(nil, []ion.Field{{Label: "key", Datum: key}}).Datum()},
+		})
+	})
+	owner := make(map[string]int)
+	seen := make(map[int64]bool)
+	for part := 0; part < parts; part++ {
+		var dst QueryBuffer
+		proj, err := NewProjection(selection("row as row, k.key as key"), &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hp, err := NewHashPartition([]string{"k", "key"}, part, parts, proj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CopyRows(hp, buftbl(rows), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range readRows(t, dst.Bytes()) {
+			f, _ := s.FieldByName("row")
+			row, _ := f.Int()
+			f, _ = s.FieldByName("key")
+			key, _ := f.String()
+			if seen[row] {
+				t.Errorf("row %d in more than one partition", row)
+			}
+			seen[row] = true
+			if p, ok := owner[key]; ok && p != part {
+				t.Errorf("key %q in partitions %d and %d", key, p, part)
+			}
+			owner[key] = part
+		}
+	}
+	for i := int64(0); i < 500; i++ {
+		if seen[i] == (i%7 == 0) {
+			t.Errorf("row %d: seen = %v", i, seen[i])
+		}
+	}
+}
+
+func TestHashSplit(t *testing.T) {
+	const parts = 3
+	rows := joinRows(500, func(i int) ion.Struct {
+		key := ion.String(fmt.Sprintf("key-%d", i%50))
+		if i%7 == 0 {
+			key = ion.Null
+		}
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "row", Datum: ion.Int(int64(i))},
+			{Label: "k", Datum: ion.NewStruct(nil, []ion.Field{{Label: "key", Datum: key}}).Datum()},
+		})
+	})
+	var split [parts]QueryBuffer
+	dst := make([]QuerySink, parts)
+	for i := range dst {
+		proj, err := NewProjection(selection("row as row"), &split[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst[i] = proj
+	}
+	hs, err := NewHashSplit([]string{"k", "key"}, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyRows(hs, buftbl(rows), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(buf []byte) []int64 {
+		var out []int64
+		for _, s := range readRows(t, buf) {
+			f, _ := s.FieldByName("row")
+			row, _ := f.Int()
+			out = append(out, row)
+		}
+		slices.Sort(out)
+		return out
+	}
+	// each partition should be exactly
+	// the output of a HashPartition
+	for part := 0; part < parts; part++ {
+		var want QueryBuffer
+		proj, err := NewProjection(selection("row as row"), &want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hp, err := NewHashPartition([]string{"k", "key"}, part, parts, proj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CopyRows(hp, buftbl(rows), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, exp := ids(split[part].Bytes()), ids(want.Bytes())
+		if len(exp) == 0 {
+			t.Fatalf("partition %d: no rows", part)
+		}
+		if !slices.Equal(got, exp) {
+			t.Errorf("partition %d: got %v, want %v", part, got, exp)
+		}
+	}
+}
@@ -127,6 +127,14 @@ func (t *radixTree64) ref(r int32) []byte {
 	return buf[:t.vsize]
 }
 
+// size returns the number of bytes of memory used by t
+func (t *radixTree64) size() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.index)*tabsize*4 + len(t.values)
+}
+
 // newtable pushes a new table to the index
 func (t *radixTree64) newtable() int {
 	idx := len(t.index)
@@ -341,6 +349,10 @@ type aggtable struct {
 	// has an hpair entry that holds
 	// the representation of each value
 	pairs []hpair
+
+	// mem is the number of bytes
+	// accounted against the spill budget
+	mem int64
 }
 
 // for an aggtable, get the hash of the value
@@ -678,6 +690,65 @@ func (a *aggtable) writeRows(delims []vmref, rp *rowParams) error {
 		}
 	}
 
+	return a.account()
+}
+
+// size returns the number of bytes of memory used by a
+func (a *aggtable) size() int64 {
+	return int64(a.tree.size() + len(a.repr) + len(a.pairs)*8)
+}
+
+// account updates the memory accounted for a
+// and spills a to disk if the budget is exceeded
+func (a *aggtable) account() error {
+	size := a.size()
+	spill := spillAccount(size-a.mem, size)
+	a.mem = size
+	if spill {
+		return a.spill()
+	}
+	return nil
+}
+
+// release drops the memory accounted for a
+func (a *aggtable) release() {
+	spillAccount(-a.mem, 0)
+	a.mem = 0
+}
+
+// spill writes the entries in a to the
+// spill files of the parent and resets a
+func (a *aggtable) spill() error {
+	parent := a.parent
+	parent.lock.Lock()
+	if parent.spill == nil {
+		parent.spill = new(spillSet)
+	}
+	set := parent.spill
+	parent.lock.Unlock()
+
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	vsize := len(parent.initialData)
+	var rec []byte
+	for i := range a.pairs {
+		p := &a.pairs[i]
+		hash := a.hashof(p)
+		f, err := set.file(spillPart(hash))
+		if err != nil {
+			return err
+		}
+		rec = binary.LittleEndian.AppendUint64(rec[:0], hash)
+		rec = append(rec, a.fullrepr(p, len(parent.by))...)
+		rec = append(rec, a.valueof(p)[:vsize]...)
+		if err := f.write(rec); err != nil {
+			return err
+		}
+	}
+	a.tree = newRadixTree(vsize)
+	a.repr = nil
+	a.pairs = nil
+	a.release()
 	return nil
 }
 
@@ -686,6 +757,7 @@ func (a *aggtable) Close() error {
 	parent := a.parent
 	atomic.AddInt64(&parent.rowcount, a.rows)
 	a.rows = 0
+	var err error
 	parent.lock.Lock()
 
 	// a little clever:
@@ -706,6 +778,10 @@ func (a *aggtable) Close() error {
 		parent.final = nil
 		parent.lock.Unlock()
 		a.merge(tmp)
+		tmp.release()
+		if err2 := a.account(); err == nil {
+			err = err2
+		}
 		parent.lock.Lock()
 	}
 
@@ -714,7 +790,7 @@ func (a *aggtable) Close() error {
 		panic("duplicate aggtable.Close()")
 	}
 	parent.lock.Unlock()
-	return nil
+	return err
 }
 
 // merge the right-hand-side table into
@@ -725,22 +801,39 @@ func (a *aggtable) merge(r *aggtable) {
 	for i := range r.pairs {
 		p := &r.pairs[i]
 		// get value from rhs
-		hash := r.hashof(p)
-		repr := r.fullrepr(p, len(a.parent.by))
-		value := r.valueof(p)
-
-		// regular insert slow path for lhs
-		off, ok := a.tree.insertSlow(hash)
-		if ok {
-			reprloc := int32(len(a.repr))
-			a.repr = append(a.repr, repr...)
-			a.pairs = append(a.pairs, hpair{
-				reprloc: reprloc,
-				hloc:    off,
-			})
-			a.initentry(a.tree.values[off+8:])
-		}
+		a.mergeEntry(r.hashof(p), r.fullrepr(p, len(a.parent.by)), r.valueof(p))
+	}
+}
 
-		mergeAggregatedValues(a.tree.values[off+8:], value, a.aggregateOps)
+// insert returns the value offset of the entry
+// for the given hash, creating a new entry with
+// the given representation if necessary
+func (a *aggtable) insert(hash uint64, repr []byte) int32 {
+	// regular insert slow path for lhs
+	off, ok := a.tree.insertSlow(hash)
+	if ok {
+		reprloc := int32(len(a.repr))
+		a.repr = append(a.repr, repr...)
+		a.pairs = append(a.pairs, hpair{
+			reprloc: reprloc,
+			hloc:    off,
+		})
+		a.initentry(a.tree.values[off+8:])
 	}
+	return off
+}
+
+// mergeEntry merges an aggregate value into
+// the entry for the given hash and representation
+func (a *aggtable) mergeEntry(hash uint64, repr, value []byte) {
+	off := a.insert(hash, repr)
+	mergeAggregatedValues(a.tree.values[off+8:], value, a.aggregateOps)
+}
+
+// copyEntry copies an entry from r into a;
+// unlike merge, this works for finalized
+// values as long as a has no entry for p yet
+func (a *aggtable) copyEntry(r *aggtable, p *hpair) {
+	off := a.insert(r.hashof(p), r.fullrepr(p, len(a.parent.by)))
+	copy(a.tree.values[off+8:], r.valueof(p)[:len(a.parent.initialData)])
 }
@@ -0,0 +1,132 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RowCounter is a QuerySink that
+// counts the rows (and the bytes of
+// row data) written to the next QuerySink
+// and measures the time spent writing them.
+//
+// See NewRowCounter
+type RowCounter struct {
+	rows, bytes int64
+	busy, wait  int64 // nanoseconds
+	dst         QuerySink
+}
+
+type rowCounter struct {
+	parent      *RowCounter
+	dst         rowConsumer
+	rows, bytes int64
+	opened      time.Time
+	wait        time.Duration
+}
+
+// NewRowCounter constructs a RowCounter
+// that passes all of its rows to dst.
+func NewRowCounter(dst QuerySink) *RowCounter {
+	return &RowCounter{dst: dst}
+}
+
+// Rows returns the number of rows
+// written by the writers that have
+// been closed.
+func (r *RowCounter) Rows() int64 {
+	return atomic.LoadInt64(&r.rows)
+}
+
+// Bytes returns the number of bytes
+// of the rows written by the writers
+// that have been closed.
+func (r *RowCounter) Bytes() int64 {
+	return atomic.LoadInt64(&r.bytes)
+}
+
+// Busy returns the sum of the time elapsed
+// between opening and closing each of
+// the writers that have been closed.
+func (r *RowCounter) Busy() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.busy))
+}
+
+// Wait returns the time spent within
+// the next QuerySink (and its writers),
+// summed over all the writers.
+// The time spent by the writers of the
+// RowCounter on their own is Busy()-Wait().
+func (r *RowCounter) Wait() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.wait))
+}
+
+func (r *RowCounter) Open() (io.WriteCloser, error) {
+	start := time.Now()
+	w, err := r.dst.Open()
+	if err != nil {
+		return nil, err
+	}
+	return splitter(&rowCounter{
+		parent: r,
+		dst:    asRowConsumer(w),
+		opened: start,
+		wait:   time.Since(start),
+	}), nil
+}
+
+func (r *RowCounter) Close() error {
+	start := time.Now()
+	err := r.dst.Close()
+	atomic.AddInt64(&r.wait, int64(time.Since(start)))
+	return err
+}
+
+func (r *rowCounter) symbolize(st *symtab, aux *auxbindings) error {
+	start := time.Now()
+	err := r.dst.symbolize(st, aux)
+	r.wait += time.Since(start)
+	return err
+}
+
+func (r *rowCounter) next() rowConsumer { return r.dst }
+
+func (r *rowCounter) writeRows(rows []vmref, rp *rowParams) error {
+	r.rows += int64(len(rows))
+	for i := range rows {
+		r.bytes += int64(rows[i][1])
+	}
+	start := time.Now()
+	err := r.dst.writeRows(rows, rp)
+	r.wait += time.Since(start)
+	return err
+}
+
+func (r *rowCounter) Close() error {
+	start := time.Now()
+	err := r.dst.Close()
+	end := time.Now()
+	r.wait += end.Sub(start)
+	atomic.AddInt64(&r.parent.rows, r.rows)
+	atomic.AddInt64(&r.parent.bytes, r.bytes)
+	atomic.AddInt64(&r.parent.busy, int64(end.Sub(r.opened)))
+	atomic.AddInt64(&r.parent.wait, int64(r.wait))
+	r.rows, r.bytes, r.wait = 0, 0, 0
+	r.opened = end
+	return err
+}
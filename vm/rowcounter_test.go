@@ -0,0 +1,84 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRowCounter(t *testing.T) {
+	buf, err := os.ReadFile("../testdata/parking.10n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst QueryBuffer
+	c := NewRowCounter(&dst)
+	err = CopyRows(c, buftbl(buf), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := len(structures(dst.Bytes()))
+	if c.Rows() != int64(want) {
+		t.Errorf("counted %d rows; expected %d", c.Rows(), want)
+	}
+	if c.Bytes() <= 0 || c.Bytes() > int64(len(buf)) {
+		t.Errorf("counted %d bytes of %d input bytes", c.Bytes(), len(buf))
+	}
+}
+
+type slowSink struct {
+	delay time.Duration
+}
+
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (s *slowSink) Open() (io.WriteCloser, error) { return &slowWriter{s.delay}, nil }
+func (s *slowSink) Close() error                  { return nil }
+
+func (s *slowWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (s *slowWriter) Close() error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func TestRowCounterTime(t *testing.T) {
+	buf, err := os.ReadFile("../testdata/parking.10n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const delay = 10 * time.Millisecond
+	c := NewRowCounter(&slowSink{delay: delay})
+	err = CopyRows(c, buftbl(buf), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each writer waits for the next
+	// writer to close
+	if c.Wait() < 2*delay {
+		t.Errorf("wait %s < %s", c.Wait(), 2*delay)
+	}
+	if c.Busy() < c.Wait() {
+		t.Errorf("busy %s < wait %s", c.Busy(), c.Wait())
+	}
+}
@@ -16,8 +16,10 @@ package vm
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/SnellerInc/sneller/expr"
@@ -112,6 +114,16 @@ type Order struct {
 
 	// lock for writing to the heap
 	recordsLock sync.Mutex
+
+	// sorted runs of records written
+	// to disk by threads that exceeded
+	// the spill budget (or, for external sorts,
+	// sortRunSize); protected by recordsLock
+	runs []*spillFile
+
+	// sorted in-memory runs of records
+	// from full sorts; protected by recordsLock
+	sorted [][]krecord
 }
 
 // NewOrder constructs a new Order QuerySink that
@@ -122,6 +134,20 @@ func NewOrder(dst io.Writer, columns []SortColumn, limit *SortLimit, parallelism
 	if limit == nil {
 		limit = &SortLimit{Limit: 100000}
 	}
+	return newOrder(dst, columns, limit, parallelism)
+}
+
+// NewExternalOrder constructs a new Order QuerySink
+// that sorts every row with an external merge sort:
+// each thread sorts its own rows and writes sorted runs
+// to temporary files once it holds more than sortRunSize
+// bytes (or the spill budget is exceeded), and the runs
+// are merged when the Order is closed.
+func NewExternalOrder(dst io.Writer, columns []SortColumn, parallelism int) (*Order, error) {
+	return newOrder(dst, columns, nil, parallelism)
+}
+
+func newOrder(dst io.Writer, columns []SortColumn, limit *SortLimit, parallelism int) (*Order, error) {
 	s := &Order{
 		columns:     columns,
 		limit:       limit,
@@ -172,6 +198,11 @@ func (s *Order) orderList() []SortOrdering {
 
 // Open implements QuerySink.Open
 func (s *Order) Open() (io.WriteCloser, error) {
+	if s.limit == nil {
+		fs := &sortstateFull{parent: s}
+		fs.cmp.fields = s.orderList()
+		return splitter(fs), nil
+	}
 	kt := &sortstateKtop{parent: s}
 	kt.kheap.fields = s.orderList()
 	// we'll trim this later:
@@ -188,36 +219,67 @@ func (s *Order) Close() error {
 	// s.sub safely
 	// s.wg.Wait()
 
+	defer func() {
+		for i := range s.runs {
+			s.runs[i].Close()
+		}
+		s.runs = nil
+		s.sorted = nil
+	}()
+	if s.limit == nil {
+		return s.finalizeFull()
+	}
 	return s.finalizeKtop()
 }
 
-func (s *Order) finalizeKtop() error {
-	var globalst ion.Symtab
-	var tmp ion.Buffer
+// orderOutput writes records
+// to the final destination in chunks
+type orderOutput struct {
+	dst io.Writer
+	st  ion.Symtab
+	tmp ion.Buffer
+	out []byte
+}
 
+func (o *orderOutput) write(rec *krecord) error {
 	// once we have accumulated this many data bytes,
 	// flush the output buffer:
 	const flushAt = PageSize / 2
 
-	// temporary buffer for flushing:
-	var out []byte
-	flush := func() error {
-		slice := tmp.Size()
-		if slice == 0 {
-			return nil
-		}
-		globalst.Marshal(&tmp, true)
-		out = append(out[:0], tmp.Bytes()[slice:]...)
-		out = append(out, tmp.Bytes()[:slice]...)
-		globalst.Reset()
-		tmp.Reset()
-		_, err := s.dst.Write(out)
-		return err
+	rec.data.Encode(&o.tmp, &o.st)
+	if o.tmp.Size() >= flushAt {
+		return o.flush()
+	}
+	return nil
+}
+
+func (o *orderOutput) flush() error {
+	slice := o.tmp.Size()
+	if slice == 0 {
+		return nil
 	}
+	o.st.Marshal(&o.tmp, true)
+	o.out = append(o.out[:0], o.tmp.Bytes()[slice:]...)
+	o.out = append(o.out, o.tmp.Bytes()[:slice]...)
+	o.st.Reset()
+	o.tmp.Reset()
+	_, err := o.dst.Write(o.out)
+	return err
+}
 
+func (s *Order) finalizeKtop() error {
+	out := &orderOutput{dst: s.dst}
+	if len(s.runs) > 0 {
+		srcs := []*runSource{{mem: s.kheap.sorted()}}
+		err := s.merge(srcs, s.limit.Offset, s.limit.Limit, out.write)
+		if err != nil {
+			return err
+		}
+		return out.flush()
+	}
 	off := s.limit.Offset
 	if off >= len(s.kheap.heaporder) {
-		return flush() // symbol table + no data
+		return out.flush() // symbol table + no data
 	}
 	// reverse the max-heap ordering
 	// to end up with the final desired ordering,
@@ -235,16 +297,114 @@ func (s *Order) finalizeKtop() error {
 		i--
 	}
 	for i := range final {
-		rec := &final[i]
-		rec.data.Encode(&tmp, &globalst)
-		if tmp.Size() >= flushAt {
-			err := flush()
-			if err != nil {
+		if err := out.write(&final[i]); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+// finalizeFull merges the sorted runs
+// produced by each of the sortstateFull threads
+func (s *Order) finalizeFull() error {
+	out := &orderOutput{dst: s.dst}
+	var srcs []*runSource
+	for _, recs := range s.sorted {
+		srcs = append(srcs, &runSource{mem: recs})
+	}
+	if err := s.merge(srcs, 0, -1, out.write); err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// runSource is a source of sorted
+// records for Order.merge
+type runSource struct {
+	cur  krecord
+	run  *spillFile
+	r    *spillReader
+	mem  []krecord // in-memory records if r is nil
+	done bool
+}
+
+func (r *runSource) advance() error {
+	if r.r == nil {
+		if len(r.mem) == 0 {
+			r.done = true
+			return nil
+		}
+		r.cur, r.mem = r.mem[0], r.mem[1:]
+		return nil
+	}
+	rec, err := r.r.next()
+	if err == io.EOF {
+		r.done = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n, w := binary.Uvarint(rec)
+	if w <= 0 || len(rec[w:]) < int(n) {
+		return fmt.Errorf("Order: corrupt spill record")
+	}
+	d, err := r.run.datum(rec, w+int(n))
+	if err != nil {
+		return err
+	}
+	r.cur = krecord{
+		order: bytes.Clone(rec[w : w+int(n)]),
+		data:  d.Clone(),
+	}
+	return nil
+}
+
+// merge performs a k-way merge of the records
+// in srcs and s.runs and calls fn on each record
+// in order after skipping the first skip records;
+// at most limit records are produced unless limit is < 0
+func (s *Order) merge(srcs []*runSource, skip, limit int, fn func(*krecord) error) error {
+	for _, run := range s.runs {
+		r, err := run.reader()
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, &runSource{run: run, r: r})
+	}
+	cmp := kheap{fields: s.orderList()}
+	less := func(a, b *runSource) bool {
+		return cmp.reccmp(&a.cur, &b.cur) < 0
+	}
+	var order []*runSource
+	for _, src := range srcs {
+		if err := src.advance(); err != nil {
+			return err
+		}
+		if !src.done {
+			heap.PushSlice(&order, src, less)
+		}
+	}
+	for len(order) > 0 && limit != 0 {
+		src := order[0]
+		if skip > 0 {
+			skip--
+		} else {
+			if err := fn(&src.cur); err != nil {
 				return err
 			}
+			limit--
+		}
+		if err := src.advance(); err != nil {
+			return err
+		}
+		if src.done {
+			heap.PopSlice(&order, less)
+		} else {
+			heap.FixSlice(order, 0, less)
 		}
 	}
-	return flush()
+	return nil
 }
 
 // ----------------------------------------------------------------------
@@ -327,6 +487,9 @@ type sortstateKtop struct {
 	recent    []byte
 	filtbc    bytecode
 	filtprog  prog
+
+	// memory accounted for kheap
+	mem int64
 }
 
 func (s *sortstateKtop) invalidatePrefilter() {
@@ -498,6 +661,7 @@ func (s *sortstateKtop) maybePrefilter() error {
 type krecord struct {
 	order []byte
 	data  ion.Datum
+	size  int // approximate memory used by the record
 }
 
 // kheap is a heap of ion records
@@ -506,12 +670,24 @@ type kheap struct {
 	records   []krecord      // raw record storage
 	fields    []SortOrdering // ordering constraint
 	limit     int            // target size
+	size      int64          // sum of records[...].size
+}
+
+// sorted pops every record from the heap
+// and returns the records in ascending order
+func (k *kheap) sorted() []krecord {
+	out := make([]krecord, len(k.heaporder))
+	for i := len(out) - 1; i >= 0; i-- {
+		n := heap.PopSlice(&k.heaporder, k.greater)
+		out[i] = k.records[n]
+	}
+	return out
 }
 
 // insert a set of ordering fields into the heap,
-// returning a non-nil pointer to the destination datum
+// returning a non-nil pointer to the destination record
 // *if* the entry should be captured, or nil otherwise
-func (k *kheap) insert(fields [][]byte) *ion.Datum {
+func (k *kheap) insert(fields [][]byte) *krecord {
 	if len(fields) != len(k.fields) {
 		panic("bad # fields")
 	}
@@ -527,7 +703,7 @@ func (k *kheap) insert(fields [][]byte) *ion.Datum {
 			order: flatten(nil, fields),
 		})
 		heap.PushSlice(&k.heaporder, n, k.greater)
-		return &k.records[n].data
+		return &k.records[n]
 	}
 	top := &k.records[k.heaporder[0]]
 	topdata := top.order
@@ -541,7 +717,7 @@ func (k *kheap) insert(fields [][]byte) *ion.Datum {
 			// overwrite
 			top.order = flatten(top.order[:0], fields)
 			heap.FixSlice(k.heaporder, 0, k.greater)
-			return &top.data
+			return top
 		}
 		// dir == 0 -> continue; ignore if exactly equal
 		topdata = topdata[size:]
@@ -616,35 +792,18 @@ outer:
 				continue outer // MISSING
 			}
 		}
-		datptr := s.kheap.insert(cols)
-		if datptr == nil {
+		rec := s.kheap.insert(cols)
+		if rec == nil {
 			continue
 		}
-		s.scratch.Reset()
-		s.scratch.BeginStruct(-1)
-		// TODO: speed up the transcoding process here:
-		data := delims[rowID].mem()
-		for len(data) > 0 {
-			var sym ion.Symbol
-			sym, data, _ = ion.ReadLabel(data)
-			s.scratch.BeginField(sym)
-			size := ion.SizeOf(data)
-			s.scratch.UnsafeAppend(data[:size])
-			data = data[size:]
-		}
-		for j := range s.auxsyms {
-			mem := rp.auxbound[j][rowID].mem()
-			if len(mem) == 0 {
-				continue
-			}
-			s.scratch.BeginField(s.auxsyms[j])
-			s.scratch.UnsafeAppend(mem)
-		}
-		s.scratch.EndStruct()
-		dat, _, _ := ion.ReadDatum(&s.st.Symtab, s.scratch.Bytes())
-		dat.CloneInto(datptr)
+		size := len(rec.order) + snapshotRow(&s.scratch, s.st, s.auxsyms, delims, rp, rowID, &rec.data)
+		s.kheap.size += int64(size - rec.size)
+		rec.size = size
 		s.invalidatePrefilter()
 	}
+	if err := s.account(); err != nil {
+		return err
+	}
 	if len(s.kheap.records) == s.kheap.limit {
 		// since the heap is full,
 		// we can begin trying to prefilter
@@ -657,11 +816,96 @@ outer:
 	return nil
 }
 
+// snapshotRow copies row i of delims, along with
+// the aux bindings in rp, into dst using scratch as
+// temporary storage and returns the encoded size of the row
+func snapshotRow(scratch *ion.Buffer, st *symtab, auxsyms []ion.Symbol, delims []vmref, rp *rowParams, i int, dst *ion.Datum) int {
+	scratch.Reset()
+	scratch.BeginStruct(-1)
+	// TODO: speed up the transcoding process here:
+	data := delims[i].mem()
+	for len(data) > 0 {
+		var sym ion.Symbol
+		sym, data, _ = ion.ReadLabel(data)
+		scratch.BeginField(sym)
+		size := ion.SizeOf(data)
+		scratch.UnsafeAppend(data[:size])
+		data = data[size:]
+	}
+	for j := range auxsyms {
+		mem := rp.auxbound[j][i].mem()
+		if len(mem) == 0 {
+			continue
+		}
+		scratch.BeginField(auxsyms[j])
+		scratch.UnsafeAppend(mem)
+	}
+	scratch.EndStruct()
+	dat, _, _ := ion.ReadDatum(&st.Symtab, scratch.Bytes())
+	dat.CloneInto(dst)
+	return scratch.Size()
+}
+
+// account updates the memory accounted for
+// the heap and spills the heap to a sorted run
+// if the budget is exceeded
+func (s *sortstateKtop) account() error {
+	spill := spillAccount(s.kheap.size-s.mem, s.kheap.size)
+	s.mem = s.kheap.size
+	if spill {
+		return s.spill()
+	}
+	return nil
+}
+
+// writeSortRun writes the records in recs,
+// which must already be sorted, to a new spill file;
+// each record is the length-prefixed ordering key
+// followed by the row
+func writeSortRun(recs []krecord) (*spillFile, error) {
+	f, err := newSpillFile()
+	if err != nil {
+		return nil, err
+	}
+	var hdr []byte
+	for i := range recs {
+		hdr = binary.AppendUvarint(hdr[:0], uint64(len(recs[i].order)))
+		hdr = append(hdr, recs[i].order...)
+		if err := f.writeDatum(hdr, recs[i].data); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// spill writes the records in the heap
+// to a new sorted run and resets the heap
+func (s *sortstateKtop) spill() error {
+	f, err := writeSortRun(s.kheap.sorted())
+	if err != nil {
+		return err
+	}
+	s.parent.recordsLock.Lock()
+	s.parent.runs = append(s.parent.runs, f)
+	s.parent.recordsLock.Unlock()
+
+	s.kheap.records = nil
+	s.kheap.heaporder = nil
+	s.kheap.size = 0
+	spillAccount(-s.mem, 0)
+	s.mem = 0
+	s.invalidatePrefilter()
+	return nil
+}
+
 func (s *sortstateKtop) Close() error {
 	if s.parentNotified {
 		return nil
 	}
 	s.parentNotified = true
+	spillAccount(-s.mem, 0)
+	s.mem = 0
 
 	s.findbc.reset()
 	s.filtbc.reset()
@@ -677,3 +921,146 @@ func (s *sortstateKtop) Close() error {
 	s.parent.recordsLock.Unlock()
 	return nil
 }
+
+// ----------------------------------------------------------------------
+
+// sortRunSize is the number of bytes of
+// records that a sortstateFull thread buffers
+// before it writes them out as a sorted run
+var sortRunSize int64 = 256 << 20
+
+// sortstateFull sorts all of the rows
+// written by one thread for a full sort
+type sortstateFull struct {
+	// the parent context for this sorting operation
+	parent *Order
+
+	// most recent aux bindings
+	// passed to symbolize()
+	aux *auxbindings
+	// auxyms[i] corresponds to aux.bound[i]
+	// for the most recent symbol table
+	auxsyms []ion.Symbol
+
+	closed bool
+
+	// bytecode for locating columns
+	findbc bytecode
+	prog   prog
+	// most recent symbolize() symtab
+	st *symtab
+
+	// cmp holds the ordering constraints
+	cmp     kheap
+	records []krecord
+	size    int64 // sum of records[...].size
+	mem     int64 // memory accounted for records
+	scratch ion.Buffer
+	colbuf  [][]byte
+}
+
+func (s *sortstateFull) next() rowConsumer { return nil }
+
+func (s *sortstateFull) EndSegment() {
+	s.findbc.dropScratch() // restored in symbolize()
+}
+
+func (s *sortstateFull) symbolize(st *symtab, aux *auxbindings) error {
+	s.st = st
+	s.aux = aux
+	s.auxsyms = s.auxsyms[:0]
+	for i := range s.aux.bound {
+		s.auxsyms = append(s.auxsyms, st.Intern(s.aux.bound[i]))
+	}
+	return symbolize(s.parent, &s.prog, &s.findbc, st, aux, false)
+}
+
+func (s *sortstateFull) writeRows(delims []vmref, rp *rowParams) error {
+	if len(delims) == 0 {
+		return nil
+	}
+	fieldsView, err := bcfind(s.parent, &s.findbc, delims, rp)
+	if err != nil {
+		return err
+	}
+	cols := shrink(s.colbuf, len(s.cmp.fields))
+outer:
+	for rowID := 0; rowID < len(delims); rowID++ {
+		n := 0
+		for j := 0; j < len(cols); j++ {
+			delim := getdelim(fieldsView, rowID, j, len(cols))
+			cols[j] = delim.mem()
+			if len(cols[j]) == 0 {
+				continue outer // MISSING
+			}
+			n += len(cols[j])
+		}
+		rec := krecord{order: make([]byte, 0, n)}
+		for j := range cols {
+			rec.order = append(rec.order, cols[j]...)
+		}
+		rec.size = n + snapshotRow(&s.scratch, s.st, s.auxsyms, delims, rp, rowID, &rec.data)
+		s.records = append(s.records, rec)
+		s.size += int64(rec.size)
+	}
+	return s.account()
+}
+
+// account updates the memory accounted for
+// the buffered records and writes them out as
+// a sorted run if there are too many of them
+func (s *sortstateFull) account() error {
+	spill := spillAccount(s.size-s.mem, s.size)
+	s.mem = s.size
+	if spill || s.size >= sortRunSize {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *sortstateFull) sort() {
+	slices.SortFunc(s.records, func(a, b krecord) int {
+		return s.cmp.reccmp(&a, &b)
+	})
+}
+
+// spill sorts the buffered records and
+// writes them to a new sorted run
+func (s *sortstateFull) spill() error {
+	s.sort()
+	f, err := writeSortRun(s.records)
+	if err != nil {
+		return err
+	}
+	s.parent.recordsLock.Lock()
+	s.parent.runs = append(s.parent.runs, f)
+	s.parent.recordsLock.Unlock()
+
+	s.records = nil
+	s.size = 0
+	spillAccount(-s.mem, 0)
+	s.mem = 0
+	return nil
+}
+
+func (s *sortstateFull) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	spillAccount(-s.mem, 0)
+	s.mem = 0
+
+	s.findbc.reset()
+	if len(s.records) == 0 {
+		return nil
+	}
+	// sort the remaining records on this thread
+	// and hand them to the parent for merging
+	s.sort()
+	s.parent.recordsLock.Lock()
+	s.parent.sorted = append(s.parent.sorted, s.records)
+	s.parent.recordsLock.Unlock()
+	s.records = nil
+	return nil
+}
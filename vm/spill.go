@@ -0,0 +1,246 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SnellerInc/sneller/ion"
+)
+
+// spillParts is the number of partitions
+// that hashed operator state is split into
+// when it is written to disk
+const spillParts = 16
+
+var (
+	spillLock sync.Mutex
+	spillDir  string
+
+	spillBudget atomic.Int64 // configured budget; <= 0 means unlimited
+	spillUsed   atomic.Int64 // bytes of state currently accounted
+	spillFiles  atomic.Int64 // total number of spill files created
+)
+
+// SetSpill configures the operators that accumulate
+// state in memory (ORDER BY, DISTINCT and GROUP BY)
+// to write some of that state to temporary files in dir
+// once the state held by all of the operators in
+// the process exceeds budget bytes.
+// The spilled state is merged back in when the
+// operator completes, so query results are unaffected.
+// An empty dir or a budget <= 0 disables spilling.
+func SetSpill(dir string, budget int64) {
+	spillLock.Lock()
+	defer spillLock.Unlock()
+	if dir == "" {
+		budget = 0
+	}
+	spillDir = dir
+	spillBudget.Store(budget)
+}
+
+// spillAccount adjusts the amount of memory
+// accounted against the spill budget by delta bytes
+// and returns true if the budget has been exceeded
+// and the caller, which now holds size bytes, holds
+// enough memory that it should spill its state
+func spillAccount(delta, size int64) bool {
+	used := spillUsed.Add(delta)
+	budget := spillBudget.Load()
+	return budget > 0 && used > budget && size >= budget/spillParts
+}
+
+// spillFile is a temporary file
+// holding length-prefixed records
+type spillFile struct {
+	f   *os.File
+	w   *bufio.Writer
+	hdr []byte
+	buf ion.Buffer
+
+	// st is the symbol table used to
+	// encode the datums in the records;
+	// symbols are only ever appended, so
+	// the final table can decode every record
+	st ion.Symtab
+}
+
+func newSpillFile() (*spillFile, error) {
+	spillLock.Lock()
+	dir := spillDir
+	spillLock.Unlock()
+	f, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill file: %w", err)
+	}
+	spillFiles.Add(1)
+	return &spillFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write appends a record to the file
+func (s *spillFile) write(rec []byte) error {
+	s.hdr = binary.AppendUvarint(s.hdr[:0], uint64(len(rec)))
+	s.w.Write(s.hdr)
+	_, err := s.w.Write(rec)
+	return err
+}
+
+// writeDatum appends a record consisting
+// of prefix followed by the encoding of d
+func (s *spillFile) writeDatum(prefix []byte, d ion.Datum) error {
+	s.buf.Set(append(s.buf.Bytes()[:0], prefix...))
+	d.Encode(&s.buf, &s.st)
+	return s.write(s.buf.Bytes())
+}
+
+// datum decodes the datum following
+// the first n bytes of a record
+func (s *spillFile) datum(rec []byte, n int) (ion.Datum, error) {
+	d, _, err := ion.ReadDatum(&s.st, rec[n:])
+	if err != nil {
+		return d, fmt.Errorf("reading spill file: %w", err)
+	}
+	return d, nil
+}
+
+// spillReader reads the records
+// in a spillFile in order
+type spillReader struct {
+	r   *bufio.Reader
+	rec []byte
+}
+
+// reader returns a reader for the records
+// written to s so far
+func (s *spillFile) reader() (*spillReader, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &spillReader{r: bufio.NewReader(s.f)}, nil
+}
+
+// next returns the next record or io.EOF;
+// the record is only valid until next is called again
+func (r *spillReader) next() ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading spill file: %w", err)
+	}
+	r.rec = slices.Grow(r.rec[:0], int(n))[:n]
+	if _, err := io.ReadFull(r.r, r.rec); err != nil {
+		return nil, fmt.Errorf("reading spill file: %w", err)
+	}
+	return r.rec, nil
+}
+
+// each calls fn on each of the records
+// in the file; rec is only valid until fn returns
+func (s *spillFile) each(fn func(rec []byte) error) error {
+	r, err := s.reader()
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := r.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes and removes the file
+func (s *spillFile) Close() error {
+	err := s.f.Close()
+	err2 := os.Remove(s.f.Name())
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+// spillSet is a set of spill files
+// partitioned by the top bits of a hash
+type spillSet struct {
+	lock  sync.Mutex
+	parts [spillParts]*spillFile
+}
+
+// spillPart returns the partition for a hash;
+// the result does not change when the hash is
+// rotated by 32 bits (see radixTree64.insertSlow)
+func spillPart(h uint64) int {
+	return int((h ^ bits.RotateLeft64(h, 32)) >> 60)
+}
+
+// file returns the file for partition i,
+// creating it if necessary; the caller
+// must hold s.lock
+func (s *spillSet) file(i int) (*spillFile, error) {
+	if s.parts[i] == nil {
+		f, err := newSpillFile()
+		if err != nil {
+			return nil, err
+		}
+		s.parts[i] = f
+	}
+	return s.parts[i], nil
+}
+
+// each calls fn for each record in partition i
+func (s *spillSet) each(i int, fn func(f *spillFile, rec []byte) error) error {
+	f := s.parts[i]
+	if f == nil {
+		return nil
+	}
+	return f.each(func(rec []byte) error {
+		return fn(f, rec)
+	})
+}
+
+// Close closes and removes all of the files in s
+func (s *spillSet) Close() error {
+	var err error
+	for i := range s.parts {
+		if s.parts[i] == nil {
+			continue
+		}
+		if err2 := s.parts[i].Close(); err == nil {
+			err = err2
+		}
+		s.parts[i] = nil
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+// Copyright 2023 Sneller, Inc.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/SnellerInc/sneller/expr"
+	"github.com/SnellerInc/sneller/ion"
+)
+
+// spillRows returns n rows with a high-cardinality
+// integer key k, a string group g and a value v
+func spillRows(n int) []byte {
+	return joinRows(n, func(i int) ion.Struct {
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "k", Datum: ion.Int(int64((i * 7919) % (n / 2)))},
+			{Label: "g", Datum: ion.String(fmt.Sprintf("group-%d", i%(n/3)))},
+			{Label: "v", Datum: ion.Int(int64(i))},
+		})
+	})
+}
+
+// rowStrings returns a canonical string
+// representation of each row in buf
+func rowStrings(t *testing.T, buf []byte) []string {
+	var out []string
+	for _, s := range readRows(t, buf) {
+		var st ion.Symtab
+		var b ion.Buffer
+		var str string
+		s.Each(func(f ion.Field) error {
+			b.Reset()
+			f.Datum.Encode(&b, &st)
+			str += fmt.Sprintf("%s=%x;", f.Label, b.Bytes())
+			return nil
+		})
+		out = append(out, str)
+	}
+	return out
+}
+
+// spillRun runs fn without spilling and then
+// with a tiny spill budget and checks that the
+// results are identical and that state was spilled
+func spillRun(t *testing.T, sorted bool, fn func() []string) {
+	want := fn()
+	if len(want) == 0 {
+		t.Fatal("no output")
+	}
+	SetSpill(t.TempDir(), 1<<14)
+	defer SetSpill("", 0)
+	files, used := spillFiles.Load(), spillUsed.Load()
+	got := fn()
+	if spillFiles.Load() == files {
+		t.Fatal("nothing was spilled")
+	}
+	if n := spillUsed.Load() - used; n != 0 {
+		t.Errorf("%d bytes still accounted after completion", n)
+	}
+	if !sorted {
+		slices.Sort(want)
+		slices.Sort(got)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %d rows, want %d rows (results differ)", len(got), len(want))
+	}
+}
+
+func TestSpillHashAggregate(t *testing.T) {
+	rows := spillRows(20000)
+	cases := []struct {
+		limit   int
+		ordered bool
+	}{
+		{},
+		{ordered: true},
+		{ordered: true, limit: 100},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("ordered=%v,limit=%d", c.ordered, c.limit), func(t *testing.T) {
+			spillRun(t, c.ordered, func() []string {
+				var dst QueryBuffer
+				agg := Aggregation{
+					mkagg(expr.OpCount, "v", "count"),
+					mkagg(expr.OpSum, "v", "sum"),
+					mkagg(expr.OpMax, "v", "max"),
+				}
+				by := Selection{{Expr: expr.Ident("k")}, {Expr: expr.Ident("g")}}
+				ha, err := NewHashAggregate(agg, nil, by, &dst)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.ordered {
+					ha.OrderByAggregate(1, SortOrdering{Direction: SortDescending})
+					ha.OrderByGroup(0, SortOrdering{Direction: SortAscending})
+					ha.OrderByGroup(1, SortOrdering{Direction: SortAscending})
+				}
+				ha.Limit(c.limit)
+				if err := CopyRows(ha, buftbl(rows), 4); err != nil {
+					t.Fatal(err)
+				}
+				if err := ha.Close(); err != nil {
+					t.Fatal(err)
+				}
+				return rowStrings(t, dst.Bytes())
+			})
+		})
+	}
+}
+
+func TestSpillDistinct(t *testing.T) {
+	rows := spillRows(20000)
+	spillRun(t, false, func() []string {
+		var dst QueryBuffer
+		proj, err := NewProjection(selection("k as k"), &dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		df, err := NewDistinct([]expr.Node{expr.Ident("k")}, proj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CopyRows(df, buftbl(rows), 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := df.Close(); err != nil {
+			t.Fatal(err)
+		}
+		out := rowStrings(t, dst.Bytes())
+		if len(out) != 10000 {
+			t.Fatalf("got %d distinct rows; expected 10000", len(out))
+		}
+		return out
+	})
+}
+
+func TestSpillOrder(t *testing.T) {
+	input, err := limitTestIon(20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spillRun(t, true, func() []string {
+		var out bytes.Buffer
+		orderBy := []SortColumn{makeOrdering("key", SortDescending, SortNullsFirst)}
+		sorter, err := NewOrder(&out, orderBy, &SortLimit{Limit: 5000, Offset: 100}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CopyRows(sorter, buftbl(input), 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := sorter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := parseIonRecords(out.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 5000 || rows[0] != "19899" {
+			t.Fatalf("unexpected output %v...", rows[:min(len(rows), 3)])
+		}
+		return rows
+	})
+}
+
+// fullSortRows returns n rows with a key
+// of mixed types (including nulls) and an id
+func fullSortRows(n int) []byte {
+	return joinRows(n, func(i int) ion.Struct {
+		var key ion.Datum
+		switch i % 5 {
+		case 0:
+			key = ion.Int(int64(i*7919%n) - int64(n/2))
+		case 1:
+			key = ion.Float(float64(i*7919%n) + 0.5)
+		case 2:
+			key = ion.String(fmt.Sprintf("s%06d", i*7919%n))
+		case 3:
+			key = ion.Null
+		default:
+			key = ion.Bool(i%2 == 0)
+		}
+		return ion.NewStruct(nil, []ion.Field{
+			{Label: "key", Datum: key},
+			{Label: "id", Datum: ion.Int(int64(i))},
+		})
+	})
+}
+
+func TestSpillFullSort(t *testing.T) {
+	rows := fullSortRows(20000)
+	orderings := []SortOrdering{
+		{Direction: SortAscending, NullsOrder: SortNullsFirst},
+		{Direction: SortAscending, NullsOrder: SortNullsLast},
+		{Direction: SortDescending, NullsOrder: SortNullsFirst},
+		{Direction: SortDescending, NullsOrder: SortNullsLast},
+	}
+	sortAll := func(t *testing.T, ord SortOrdering) []ion.Struct {
+		var out bytes.Buffer
+		orderBy := []SortColumn{{Node: expr.Ident("key"), Ordering: ord}}
+		sorter, err := NewExternalOrder(&out, orderBy, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CopyRows(sorter, buftbl(rows), 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := sorter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return readRows(t, out.Bytes())
+	}
+	for _, ord := range orderings {
+		t.Run(ord.String(), func(t *testing.T) {
+			want := sortAll(t, ord)
+			files := spillFiles.Load()
+			saved := sortRunSize
+			sortRunSize = 1 << 14
+			got := sortAll(t, ord)
+			sortRunSize = saved
+			if spillFiles.Load() == files {
+				t.Fatal("no sorted runs were written")
+			}
+			if len(got) != len(want) || len(got) != 20000 {
+				t.Fatalf("got %d rows, want %d", len(got), len(want))
+			}
+			var st ion.Symtab
+			var buf ion.Buffer
+			key := func(s ion.Struct) []byte {
+				f, ok := s.FieldByName("key")
+				if !ok {
+					t.Fatal("missing key")
+				}
+				buf.Reset()
+				f.Datum.Encode(&buf, &st)
+				return slices.Clone(buf.Bytes())
+			}
+			var prev []byte
+			for i := range got {
+				k := key(got[i])
+				if prev != nil && ord.Compare(prev, k) > 0 {
+					t.Fatalf("row %d: %x sorts before %x", i, prev, k)
+				}
+				if !bytes.Equal(k, key(want[i])) {
+					t.Fatalf("row %d: got key %x, want %x", i, k, key(want[i]))
+				}
+				prev = k
+			}
+		})
+	}
+}
+
+func TestSpillFilesRemoved(t *testing.T) {
+	dir := t.TempDir()
+	SetSpill(dir, 1<<14)
+	defer SetSpill("", 0)
+	var set spillSet
+	for i := 0; i < 100; i++ {
+		f, err := set.file(spillPart(uint64(i) * 0x9e3779b97f4a7c15))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := 0
+	for i := range set.parts {
+		set.each(i, func(_ *spillFile, rec []byte) error {
+			total++
+			return nil
+		})
+	}
+	if total != 100 {
+		t.Errorf("read %d records back; expected 100", total)
+	}
+	if err := set.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 0 {
+		t.Errorf("%d spill files left behind", len(ents))
+	}
+}